@@ -0,0 +1,230 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package auto provides a reader that detects the compression format
+// of a stream and decompresses it.
+package auto
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/lz4"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/xz"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is a compression format.
+type Format string
+
+// Formats that are detected by default.
+const (
+	Unknown Format = ""
+	Gzip    Format = "gzip"
+	Zlib    Format = "zlib"
+	Zstd    Format = "zstd"
+	S2      Format = "s2"
+	Snappy  Format = "snappy"
+	Bzip2   Format = "bzip2"
+	XZ      Format = "xz"
+	LZ4     Format = "lz4"
+)
+
+var (
+	// ErrUnknownFormat is returned when the format of the input cannot be detected.
+	ErrUnknownFormat = errors.New("auto: unknown compression format")
+
+	// ErrNoDecoder is returned when the format was detected,
+	// but no decoder has been registered for it.
+	ErrNoDecoder = errors.New("auto: no decoder registered for format")
+)
+
+// MaxMagicSize is the maximum number of bytes a Matcher will be given.
+const MaxMagicSize = 16
+
+// A Matcher returns whether the start of a stream matches a format.
+// The supplied slice contains up to MaxMagicSize bytes,
+// and may be shorter if the stream is shorter.
+type Matcher func(b []byte) bool
+
+// A DecoderFunc returns a decompressing reader for the stream.
+type DecoderFunc func(r io.Reader) (io.ReadCloser, error)
+
+type format struct {
+	f       Format
+	match   Matcher
+	decoder DecoderFunc
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = []format{
+		{f: Gzip, match: magic("\x1f\x8b"), decoder: newGzip},
+		{f: Zstd, match: matchZstd, decoder: newZstd},
+		{f: S2, match: magic("\xff\x06\x00\x00S2sTwO"), decoder: newS2},
+		{f: Snappy, match: magic("\xff\x06\x00\x00sNaPpY"), decoder: newS2},
+		{f: Bzip2, match: matchBzip2, decoder: newBzip2},
+		{f: XZ, match: magic("\xfd7zXZ\x00"), decoder: newXZ},
+		{f: LZ4, match: magic("\x04\x22\x4d\x18"), decoder: newLZ4},
+		// Zlib has the weakest signature, so it is checked last.
+		{f: Zlib, match: matchZlib, decoder: newZlib},
+	}
+)
+
+// Register adds or replaces a format.
+// If the format already exists, a nil match will keep the existing matcher,
+// which allows replacing the decoder of a built-in format.
+// New formats are checked before the built-in formats.
+func Register(f Format, match Matcher, decoder DecoderFunc) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	for i, v := range formats {
+		if v.f == f {
+			if match != nil {
+				formats[i].match = match
+			}
+			formats[i].decoder = decoder
+			return
+		}
+	}
+	if match == nil {
+		panic(fmt.Sprintf("auto: no matcher for new format %q", f))
+	}
+	formats = append([]format{{f: f, match: match, decoder: decoder}}, formats...)
+}
+
+// Detect returns the format of the stream starting with b.
+// Unknown is returned if no format matches.
+func Detect(b []byte) Format {
+	if len(b) > MaxMagicSize {
+		b = b[:MaxMagicSize]
+	}
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, v := range formats {
+		if v.match(b) {
+			return v.f
+		}
+	}
+	return Unknown
+}
+
+// NewReader detects the compression format of r and returns a reader
+// that decompresses it, as well as the detected format.
+// Data may be read ahead from r, so it should not be used after this call.
+// If the format cannot be detected ErrUnknownFormat is returned.
+// If the format is detected, but no decoder is registered,
+// the format is returned along with ErrNoDecoder.
+func NewReader(r io.Reader) (io.ReadCloser, Format, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok || br.Size() < MaxMagicSize {
+		br = bufio.NewReader(r)
+	}
+	b, err := br.Peek(MaxMagicSize)
+	if err != nil && err != io.EOF {
+		return nil, Unknown, err
+	}
+	if len(b) == 0 {
+		return nil, Unknown, io.ErrUnexpectedEOF
+	}
+
+	formatsMu.RLock()
+	var found *format
+	for i := range formats {
+		if formats[i].match(b) {
+			v := formats[i]
+			found = &v
+			break
+		}
+	}
+	formatsMu.RUnlock()
+
+	if found == nil {
+		return nil, Unknown, ErrUnknownFormat
+	}
+	if found.decoder == nil {
+		return nil, found.f, ErrNoDecoder
+	}
+	rc, err := found.decoder(br)
+	if err != nil {
+		return nil, found.f, err
+	}
+	return rc, found.f, nil
+}
+
+func magic(m string) Matcher {
+	return func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte(m))
+	}
+}
+
+// matchZstd matches zstd frames and skippable frames.
+func matchZstd(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	if bytes.Equal(b[:4], []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return true
+	}
+	// Skippable frame: 0x184D2A5?
+	return b[0]&0xf0 == 0x50 && b[1] == 0x2a && b[2] == 0x4d && b[3] == 0x18
+}
+
+func matchBzip2(b []byte) bool {
+	return len(b) >= 4 && b[0] == 'B' && b[1] == 'Z' && b[2] == 'h' && b[3] >= '1' && b[3] <= '9'
+}
+
+// matchZlib checks the zlib header as described in RFC 1950.
+func matchZlib(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	cmf, flg := b[0], b[1]
+	const deflate = 8
+	return cmf&0x0f == deflate && cmf>>4 <= 7 && (uint16(cmf)<<8|uint16(flg))%31 == 0
+}
+
+func newGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func newZlib(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func newZstd(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func newS2(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(s2.NewReader(r)), nil
+}
+
+func newBzip2(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func newLZ4(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+func newXZ(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(xr), nil
+}
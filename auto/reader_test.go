@@ -0,0 +1,156 @@
+package auto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/lz4"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNewReader(t *testing.T) {
+	want := bytes.Repeat([]byte("hello, world\n"), 1000)
+	writers := map[Format]func(w io.Writer) io.WriteCloser{
+		Gzip: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		Zlib: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		Zstd: func(w io.Writer) io.WriteCloser {
+			enc, err := zstd.NewWriter(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return enc
+		},
+		S2:     func(w io.Writer) io.WriteCloser { return s2.NewWriter(w) },
+		LZ4:    func(w io.Writer) io.WriteCloser { return lz4.NewWriter(w) },
+		Snappy: func(w io.Writer) io.WriteCloser { return snappy.NewBufferedWriter(w) },
+	}
+	for format, fn := range writers {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := fn(&buf)
+			if _, err := w.Write(want); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := Detect(buf.Bytes()); got != format {
+				t.Fatalf("detected %q, want %q", got, format)
+			}
+			r, f, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f != format {
+				t.Fatalf("got format %q, want %q", f, format)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("output mismatch")
+			}
+		})
+	}
+}
+
+func TestNewReaderXZ(t *testing.T) {
+	in, err := ioutil.ReadFile("../xz/testdata/gettysburg-crc32.xz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ioutil.ReadFile("../testdata/gettysburg.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, f, err := NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != XZ {
+		t.Fatalf("got format %q", f)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output mismatch")
+	}
+}
+
+func TestNewReaderBzip2(t *testing.T) {
+	in, _ := hex.DecodeString("425a6839314159265359b123de4300000359800010400410001264c0102000310340d02001a69103ab6c8284f8bb9229c28485891ef218")
+	r, f, err := NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != Bzip2 {
+		t.Fatalf("got format %q", f)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello, bzip2\n" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestNewReaderErrors(t *testing.T) {
+	if _, f, err := NewReader(strings.NewReader("plain text")); err != ErrUnknownFormat || f != Unknown {
+		t.Errorf("got %q, %v", f, err)
+	}
+	if _, _, err := NewReader(strings.NewReader("")); err == nil {
+		t.Error("expected error on empty input")
+	}
+	const noDecoder = Format("nodecoder")
+	Register(noDecoder, magic("NODEC"), nil)
+	if _, f, err := NewReader(strings.NewReader("NODEC data")); err != ErrNoDecoder || f != noDecoder {
+		t.Errorf("got %q, %v", f, err)
+	}
+	if _, f, err := NewReader(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})); err == nil || f != Gzip {
+		t.Errorf("got %q, %v", f, err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	const custom = Format("custom")
+	errCustom := errors.New("custom")
+	Register(custom, func(b []byte) bool { return bytes.HasPrefix(b, []byte("CUSTOM")) }, func(r io.Reader) (io.ReadCloser, error) {
+		return nil, errCustom
+	})
+	if got := Detect([]byte("CUSTOM data")); got != custom {
+		t.Fatalf("detected %q", got)
+	}
+	if _, f, err := NewReader(strings.NewReader("CUSTOM data")); err != errCustom || f != custom {
+		t.Errorf("got %q, %v", f, err)
+	}
+
+	// Add a decoder to a detected format, keeping the matcher.
+	Register(XZ, nil, func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	})
+	defer Register(XZ, nil, newXZ)
+	r, f, err := NewReader(strings.NewReader("\xfd7zXZ\x00data"))
+	if err != nil || f != XZ {
+		t.Fatalf("got %q, %v", f, err)
+	}
+	got, _ := ioutil.ReadAll(r)
+	if string(got) != "\xfd7zXZ\x00data" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package autotune selects a codec and level by measuring candidates
+// on a sample of the data that will be compressed.
+//
+// Typical use is to run Recommend once at startup with a few representative
+// blocks and a small time budget:
+//
+//	res, err := autotune.Recommend(ctx, samples, autotune.Options{
+//		Budget:   500 * time.Millisecond,
+//		MinRatio: 3,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	c := res.Codec
+//
+// Measurements are affected by other load on the machine,
+// so results can differ between runs when candidates are close.
+package autotune
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultBudget is the time budget used if none is specified.
+const DefaultBudget = time.Second
+
+// ErrNoMatch is returned by Recommend when no candidate satisfies the requirements.
+var ErrNoMatch = errors.New("autotune: no candidate satisfies the requirements")
+
+// Candidate is a codec configuration to measure.
+type Candidate struct {
+	// Name identifies the candidate, for example "zstd-3".
+	// If empty, the codec name and level are used.
+	Name string
+
+	// Level is the level the codec was created with.
+	// It is informational and its meaning depends on the codec.
+	Level int
+
+	// Codec to measure.
+	Codec codec.Codec
+}
+
+func (c Candidate) name() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s-%d", c.Codec.Name(), c.Level)
+}
+
+// DefaultCandidates returns the candidates measured if none are specified.
+// It contains s2 with levels 1 (fast) to 3 (best),
+// zstd with levels 1 (fastest) to 4 (best) and gzip with levels 1, 5 and 9.
+func DefaultCandidates() []Candidate {
+	return []Candidate{
+		{Name: "s2-fast", Level: 1, Codec: codec.S2()},
+		{Name: "s2-better", Level: 2, Codec: codec.S2(s2.WriterBetterCompression())},
+		{Name: "s2-best", Level: 3, Codec: codec.S2(s2.WriterBestCompression())},
+		{Name: "zstd-fastest", Level: int(zstd.SpeedFastest), Codec: codec.Zstd(zstd.SpeedFastest)},
+		{Name: "zstd-default", Level: int(zstd.SpeedDefault), Codec: codec.Zstd(zstd.SpeedDefault)},
+		{Name: "zstd-better", Level: int(zstd.SpeedBetterCompression), Codec: codec.Zstd(zstd.SpeedBetterCompression)},
+		{Name: "zstd-best", Level: int(zstd.SpeedBestCompression), Codec: codec.Zstd(zstd.SpeedBestCompression)},
+		{Name: "gzip-1", Level: gzip.BestSpeed, Codec: codec.Gzip(gzip.BestSpeed)},
+		{Name: "gzip-5", Level: 5, Codec: codec.Gzip(5)},
+		{Name: "gzip-9", Level: gzip.BestCompression, Codec: codec.Gzip(gzip.BestCompression)},
+	}
+}
+
+// Objective selects which of the candidates satisfying
+// the requirements is recommended.
+type Objective int
+
+const (
+	// FastestEncode recommends the candidate with the highest compression speed.
+	FastestEncode Objective = iota
+	// FastestDecode recommends the candidate with the highest decompression speed.
+	FastestDecode
+	// SmallestOutput recommends the candidate with the highest ratio.
+	SmallestOutput
+)
+
+// Options control the measurements and the recommendation.
+type Options struct {
+	// Candidates to measure. If nil, DefaultCandidates is used.
+	Candidates []Candidate
+
+	// Budget is the approximate total time to spend measuring.
+	// It is divided evenly between candidates, but every candidate
+	// compresses each sample at least once.
+	// If 0, DefaultBudget is used.
+	Budget time.Duration
+
+	// MinRatio is the minimum required compression ratio,
+	// uncompressed size divided by compressed size.
+	MinRatio float64
+
+	// MinEncodeSpeed and MinDecodeSpeed are the minimum required
+	// speeds in uncompressed bytes per second.
+	MinEncodeSpeed, MinDecodeSpeed float64
+
+	// Objective selects between candidates satisfying the requirements.
+	Objective Objective
+}
+
+// Result contains the measurements of a candidate.
+type Result struct {
+	Candidate
+
+	// In and Out are the uncompressed and compressed bytes of one pass over the samples.
+	In, Out int64
+
+	// Ratio is In divided by Out.
+	Ratio float64
+
+	// EncodeSpeed and DecodeSpeed are in uncompressed bytes per second.
+	EncodeSpeed, DecodeSpeed float64
+
+	// Rounds is the number of times the samples were compressed.
+	Rounds int
+}
+
+// String returns a summary of the result.
+func (r Result) String() string {
+	return fmt.Sprintf("%s: ratio %.2f, encode %.1f MB/s, decode %.1f MB/s", r.name(), r.Ratio, r.EncodeSpeed/1e6, r.DecodeSpeed/1e6)
+}
+
+func (r Result) satisfies(o Options) bool {
+	return r.Ratio >= o.MinRatio && r.EncodeSpeed >= o.MinEncodeSpeed && r.DecodeSpeed >= o.MinDecodeSpeed
+}
+
+// Measure compresses and decompresses the samples with all candidates
+// and returns the results in the order of the candidates.
+// The output of every candidate is verified.
+// If the context is cancelled, the results measured so far are returned
+// with the context error.
+func Measure(ctx context.Context, samples [][]byte, o Options) ([]Result, error) {
+	cands := o.Candidates
+	if cands == nil {
+		cands = DefaultCandidates()
+	}
+	budget := o.Budget
+	if budget <= 0 {
+		budget = DefaultBudget
+	}
+	var total int64
+	for _, s := range samples {
+		total += int64(len(s))
+	}
+	if total == 0 {
+		return nil, errors.New("autotune: no sample data")
+	}
+	share := budget / time.Duration(len(cands))
+	res := make([]Result, 0, len(cands))
+	for _, c := range cands {
+		r, err := measure(ctx, c, samples, share)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func measure(ctx context.Context, c Candidate, samples [][]byte, budget time.Duration) (Result, error) {
+	r := Result{Candidate: c}
+	r.Name = c.name()
+	var enc, dec []byte
+	var encTime, decTime time.Duration
+	var processed int64
+	for r.Rounds == 0 || encTime+decTime < budget {
+		for _, s := range samples {
+			if err := ctx.Err(); err != nil {
+				return r, err
+			}
+			t := time.Now()
+			var err error
+			enc, err = c.Codec.Encode(enc[:0], s)
+			encTime += time.Since(t)
+			if err != nil {
+				return r, fmt.Errorf("autotune: %s: %w", r.Name, err)
+			}
+			t = time.Now()
+			dec, err = c.Codec.Decode(dec[:0], enc)
+			decTime += time.Since(t)
+			if err != nil {
+				return r, fmt.Errorf("autotune: %s: %w", r.Name, err)
+			}
+			if !bytes.Equal(dec, s) {
+				return r, fmt.Errorf("autotune: %s: output mismatch", r.Name)
+			}
+			if r.Rounds == 0 {
+				r.In += int64(len(s))
+				r.Out += int64(len(enc))
+			}
+			processed += int64(len(s))
+		}
+		r.Rounds++
+	}
+	if r.Out > 0 {
+		r.Ratio = float64(r.In) / float64(r.Out)
+	}
+	r.EncodeSpeed = speed(processed, encTime)
+	r.DecodeSpeed = speed(processed, decTime)
+	return r, nil
+}
+
+func speed(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		// Too fast to measure.
+		d = time.Nanosecond
+	}
+	return float64(n) / d.Seconds()
+}
+
+// Recommend measures the candidates and returns the best one according
+// to the objective among those satisfying the requirements.
+// If no candidate satisfies the requirements, the candidate with the
+// highest ratio is returned with ErrNoMatch.
+func Recommend(ctx context.Context, samples [][]byte, o Options) (Result, error) {
+	res, err := Measure(ctx, samples, o)
+	if err != nil {
+		return Result{}, err
+	}
+	return Select(res, o)
+}
+
+// Select returns the best result according to the objective among those
+// satisfying the requirements of the options.
+// If no result satisfies the requirements, the result with the
+// highest ratio is returned with ErrNoMatch.
+func Select(res []Result, o Options) (Result, error) {
+	if len(res) == 0 {
+		return Result{}, errors.New("autotune: no results")
+	}
+	var ok []Result
+	for _, r := range res {
+		if r.satisfies(o) {
+			ok = append(ok, r)
+		}
+	}
+	if len(ok) == 0 {
+		sorted := append([]Result(nil), res...)
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Ratio > sorted[j].Ratio })
+		return sorted[0], ErrNoMatch
+	}
+	var less func(a, b Result) bool
+	switch o.Objective {
+	case FastestDecode:
+		less = func(a, b Result) bool { return a.DecodeSpeed > b.DecodeSpeed }
+	case SmallestOutput:
+		less = func(a, b Result) bool { return a.Ratio > b.Ratio }
+	default:
+		less = func(a, b Result) bool { return a.EncodeSpeed > b.EncodeSpeed }
+	}
+	sort.SliceStable(ok, func(i, j int) bool { return less(ok[i], ok[j]) })
+	return ok[0], nil
+}
@@ -0,0 +1,93 @@
+package autotune
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/zstd"
+)
+
+func testSamples() [][]byte {
+	var res [][]byte
+	for i := 0; i < 4; i++ {
+		var buf bytes.Buffer
+		for j := 0; buf.Len() < 20000; j++ {
+			buf.WriteString(`{"id":`)
+			buf.WriteByte(byte('0' + (i*j)%10))
+			buf.WriteString(`,"name":"autotune sample","tags":["a","b"]}` + "\n")
+		}
+		res = append(res, buf.Bytes())
+	}
+	return res
+}
+
+func TestRecommend(t *testing.T) {
+	res, err := Measure(context.Background(), testSamples(), Options{Budget: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(DefaultCandidates()) {
+		t.Fatalf("want %d results, got %d", len(DefaultCandidates()), len(res))
+	}
+	for _, r := range res {
+		t.Log(r)
+		if r.Ratio <= 1 || r.Rounds < 1 || r.EncodeSpeed <= 0 || r.DecodeSpeed <= 0 {
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+
+	best, err := Select(res, Options{Objective: SmallestOutput})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Ratio > best.Ratio {
+			t.Errorf("%s has higher ratio than %s", r.Name, best.Name)
+		}
+	}
+
+	// The fastest encoder satisfying a ratio must not be slower than others satisfying it.
+	o := Options{MinRatio: best.Ratio * 0.9}
+	fast, err := Select(res, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range res {
+		if r.Ratio >= o.MinRatio && r.EncodeSpeed > fast.EncodeSpeed {
+			t.Errorf("%s is faster than %s", r.Name, fast.Name)
+		}
+	}
+
+	got, err := Select(res, Options{MinRatio: best.Ratio * 2})
+	if err != ErrNoMatch || got.Name != best.Name {
+		t.Errorf("want %s with ErrNoMatch, got %s, %v", best.Name, got.Name, err)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	o := Options{
+		Candidates: []Candidate{{Level: 1, Codec: codec.Zstd(zstd.SpeedFastest)}},
+		Budget:     time.Nanosecond,
+	}
+	r, err := Recommend(context.Background(), testSamples(), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "zstd-1" || r.Rounds != 1 {
+		t.Errorf("unexpected result: %+v", r)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Recommend(ctx, testSamples(), Options{}); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	if _, err := Recommend(context.Background(), nil, Options{}); err == nil {
+		t.Error("expected error with no samples")
+	}
+}
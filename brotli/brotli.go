@@ -0,0 +1,135 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package brotli allows an external brotli implementation to be used
+// with the codec registry and the gzhttp writer factories.
+//
+// This package does not contain a brotli compressor.
+// Instead an implementation is supplied by the caller, for example
+// using github.com/andybalholm/brotli:
+//
+//	impl := brotli.Implementation{
+//		NewWriter: func(w io.Writer, level int) brotli.Writer {
+//			return abrotli.NewWriterLevel(w, level)
+//		},
+//		NewReader: func(r io.Reader) io.Reader {
+//			return abrotli.NewReader(r)
+//		},
+//		MinLevel:     abrotli.BestSpeed,
+//		MaxLevel:     abrotli.BestCompression,
+//		DefaultLevel: abrotli.DefaultCompression,
+//	}
+//	if err := brotli.Register(impl); err != nil {
+//		panic(err)
+//	}
+//
+// After registering, the codec is available as codec.Get(brotli.Name).
+package brotli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+// Name is the name of brotli codecs.
+// This is also the HTTP Content-Encoding token for brotli.
+const Name = "br"
+
+// Writer is a brotli compressing writer.
+type Writer interface {
+	io.WriteCloser
+
+	// Flush writes any pending data to the underlying writer.
+	Flush() error
+}
+
+// Implementation describes an external brotli implementation.
+type Implementation struct {
+	// NewWriter must return a writer that compresses to w at the specified level.
+	// level will always be between MinLevel and MaxLevel.
+	NewWriter func(w io.Writer, level int) Writer
+
+	// NewReader must return a reader that decompresses r.
+	// If the returned reader implements io.Closer, it will be closed.
+	NewReader func(r io.Reader) io.Reader
+
+	// MinLevel and MaxLevel is the range of supported compression levels.
+	MinLevel, MaxLevel int
+
+	// DefaultLevel is the level used when registering the codec.
+	DefaultLevel int
+}
+
+// Validate returns an error if the implementation is incomplete.
+func (i Implementation) Validate() error {
+	if i.NewWriter == nil || i.NewReader == nil {
+		return errors.New("brotli: NewWriter and NewReader must be set")
+	}
+	if i.MinLevel > i.MaxLevel {
+		return fmt.Errorf("brotli: invalid level range %d -> %d", i.MinLevel, i.MaxLevel)
+	}
+	if err := i.checkLevel(i.DefaultLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (i Implementation) checkLevel(level int) error {
+	if level < i.MinLevel || level > i.MaxLevel {
+		return fmt.Errorf("brotli: level %d out of range %d -> %d", level, i.MinLevel, i.MaxLevel)
+	}
+	return nil
+}
+
+// Codec returns a codec that compresses using the implementation at the specified level.
+func Codec(impl Implementation, level int) (codec.Codec, error) {
+	if err := impl.Validate(); err != nil {
+		return nil, err
+	}
+	if err := impl.checkLevel(level); err != nil {
+		return nil, err
+	}
+	return codec.NewStream(Name,
+		func(w io.Writer) (io.WriteCloser, error) {
+			return impl.NewWriter(w, level), nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			dec := impl.NewReader(r)
+			if rc, ok := dec.(io.ReadCloser); ok {
+				return rc, nil
+			}
+			return ioutil.NopCloser(dec), nil
+		}), nil
+}
+
+// Register adds a codec using the implementation at the default level
+// to the codec registry.
+func Register(impl Implementation) error {
+	c, err := Codec(impl, impl.DefaultLevel)
+	if err != nil {
+		return err
+	}
+	codec.Register(c)
+	return nil
+}
+
+// WriterFactory returns a writer factory for the implementation,
+// which can be used to supply brotli compression to gzhttp.
+func WriterFactory(impl Implementation) (writer.GzipWriterFactory, error) {
+	if err := impl.Validate(); err != nil {
+		return writer.GzipWriterFactory{}, err
+	}
+	return writer.GzipWriterFactory{
+		Levels: func() (min, max int) {
+			return impl.MinLevel, impl.MaxLevel
+		},
+		New: func(w io.Writer, level int) writer.GzipWriter {
+			return impl.NewWriter(w, level)
+		},
+	}, nil
+}
@@ -0,0 +1,129 @@
+package brotli
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/flate"
+)
+
+// fakeImpl uses deflate in place of brotli to test the plumbing.
+var fakeImpl = Implementation{
+	NewWriter: func(w io.Writer, level int) Writer {
+		fw, err := flate.NewWriter(w, level)
+		if err != nil {
+			panic(err)
+		}
+		return fw
+	},
+	NewReader: func(r io.Reader) io.Reader {
+		return flate.NewReader(r)
+	},
+	MinLevel:     1,
+	MaxLevel:     9,
+	DefaultLevel: 5,
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register(fakeImpl); err != nil {
+		t.Fatal(err)
+	}
+	c, ok := codec.Get(Name)
+	if !ok {
+		t.Fatal("codec not registered")
+	}
+	if c.Name() != Name {
+		t.Fatalf("want name %q, got %q", Name, c.Name())
+	}
+	in := bytes.Repeat([]byte("brotli bridge "), 1000)
+	for i := 0; i < 2; i++ {
+		comp, err := c.Encode(nil, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(comp) >= len(in) {
+			t.Errorf("no compression: %d >= %d", len(comp), len(in))
+		}
+		got, err := c.Decode(nil, comp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatal("output mismatch")
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(in)
+	w.Close()
+	r, err := c.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("stream output mismatch")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Register(Implementation{}); err == nil {
+		t.Error("expected error on empty implementation")
+	}
+	bad := fakeImpl
+	bad.MinLevel, bad.MaxLevel = 9, 1
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error on invalid level range")
+	}
+	bad = fakeImpl
+	bad.DefaultLevel = 10
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error on invalid default level")
+	}
+	if _, err := Codec(fakeImpl, 0); err == nil {
+		t.Error("expected error on level out of range")
+	}
+}
+
+func TestWriterFactory(t *testing.T) {
+	f, err := WriterFactory(fakeImpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	min, max := f.Levels()
+	if min != 1 || max != 9 {
+		t.Errorf("want levels 1 -> 9, got %d -> %d", min, max)
+	}
+	var buf bytes.Buffer
+	w := f.New(&buf, max)
+	w.Write([]byte("hello"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 {
+		t.Error("no output after flush")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(flate.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := WriterFactory(Implementation{}); err == nil {
+		t.Error("expected error on empty implementation")
+	}
+}
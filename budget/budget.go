@@ -0,0 +1,337 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package budget provides a global limit on the memory retained by
+// buffer pools in this module.
+//
+// By default there is no limit, and pools behave like sync.Pool,
+// where retained memory is released by the garbage collector.
+//
+// When a limit is set with SetLimit, pools in zstd, s2, flate/gzip and gzhttp
+// only retain buffers while the total retained size is below the limit.
+// Buffers that would exceed the limit are dropped instead of being retained.
+// Retained memory is held until it is reused or the limit is lowered,
+// so Retained reports the exact amount.
+//
+// Sizes of buffers are estimated, so the limit should be seen as approximate.
+//
+// Gets and misses are only counted while a limit is set or CollectStats is enabled,
+// so pools without either have the same cost as sync.Pool.
+package budget
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	limit    int64
+	retained int64
+	collect  int32
+
+	// poolsMu protects pools and counters.
+	// Pools are only registered while they retain buffers.
+	// When both are held, Pool.mu must be locked first.
+	poolsMu  sync.Mutex
+	pools    = make(map[*Pool]struct{})
+	counters = make(map[string]*poolCounters)
+)
+
+// poolCounters contains counters shared by all pools with the same name.
+type poolCounters struct {
+	gets, misses, dropped int64
+}
+
+func countersFor(name string) *poolCounters {
+	poolsMu.Lock()
+	c := counters[name]
+	if c == nil {
+		c = &poolCounters{}
+		counters[name] = c
+	}
+	poolsMu.Unlock()
+	return c
+}
+
+// SetLimit sets the maximum number of bytes retained by all pools.
+// A limit of 0 disables the limit, which is the default.
+// A negative limit will prevent pools from retaining any buffers.
+//
+// If the limit is lowered below the currently retained size,
+// buffers are evicted until the retained size is below the limit.
+// Removing the limit evicts all retained buffers.
+func SetLimit(n int64) {
+	atomic.StoreInt64(&limit, n)
+	if n == 0 {
+		// Release everything, so memory is managed by the GC again.
+		n = -1
+	}
+	for _, p := range registered() {
+		p.trim(n)
+	}
+}
+
+// CollectStats enables or disables counting of gets and misses for Stats
+// while no limit is set. With a limit, they are always counted.
+// The metrics package enables it when metrics are exported.
+func CollectStats(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&collect, v)
+}
+
+// counting returns whether gets and misses are counted.
+func counting() bool {
+	return atomic.LoadInt32(&collect) != 0 || atomic.LoadInt64(&limit) != 0
+}
+
+// Limit returns the current limit.
+// A value of 0 means there is no limit.
+func Limit() int64 {
+	return atomic.LoadInt64(&limit)
+}
+
+// Retained returns the number of bytes retained by limited pools.
+// This is always 0 when no limit is set.
+func Retained() int64 {
+	return atomic.LoadInt64(&retained)
+}
+
+// PoolStats contains information about pools with the same name.
+type PoolStats struct {
+	// Name of the pool, prefixed by the package name.
+	Name string
+
+	// Retained is the number of bytes retained.
+	Retained int64
+
+	// Items is the number of buffers retained.
+	Items int
+
+	// Dropped is the number of buffers that were not retained
+	// because the limit was reached.
+	Dropped int64
+
+	// Gets is the number of values requested from the pools.
+	// It is only counted while a limit is set or CollectStats is enabled.
+	Gets int64
+
+	// Misses is the number of requested values that had to be allocated.
+	// It is only counted while a limit is set or CollectStats is enabled.
+	Misses int64
+}
+
+// HitRate returns the fraction of requested values that were reused.
+func (p PoolStats) HitRate() float64 {
+	if p.Gets == 0 {
+		return 0
+	}
+	return float64(p.Gets-p.Misses) / float64(p.Gets)
+}
+
+// Stats returns statistics for all pools that have been used.
+// Pools with the same name are combined.
+// The result is sorted by name.
+func Stats() []PoolStats {
+	byName := make(map[string]*PoolStats)
+	get := func(name string) *PoolStats {
+		ps := byName[name]
+		if ps == nil {
+			ps = &PoolStats{Name: name}
+			byName[name] = ps
+		}
+		return ps
+	}
+	for _, p := range registered() {
+		p.mu.Lock()
+		ps := get(p.Name)
+		ps.Retained += p.retained
+		ps.Items += len(p.free)
+		p.mu.Unlock()
+	}
+	poolsMu.Lock()
+	for name, c := range counters {
+		ps := get(name)
+		ps.Dropped += atomic.LoadInt64(&c.dropped)
+		ps.Gets += atomic.LoadInt64(&c.gets)
+		ps.Misses += atomic.LoadInt64(&c.misses)
+	}
+	poolsMu.Unlock()
+	res := make([]PoolStats, 0, len(byName))
+	for _, ps := range byName {
+		res = append(res, *ps)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func registered() []*Pool {
+	poolsMu.Lock()
+	ps := make([]*Pool, 0, len(pools))
+	for p := range pools {
+		ps = append(ps, p)
+	}
+	poolsMu.Unlock()
+	return ps
+}
+
+// reserve attempts to reserve n bytes within the limit.
+func reserve(n int64) bool {
+	for {
+		cur := atomic.LoadInt64(&retained)
+		l := atomic.LoadInt64(&limit)
+		if l == 0 || cur+n > l {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&retained, cur, cur+n) {
+			return true
+		}
+	}
+}
+
+func release(n int64) {
+	atomic.AddInt64(&retained, -n)
+}
+
+// Pool is a pool of buffers that respects the global limit.
+// The zero value is ready to use.
+// A Pool must not be copied after first use.
+//
+// Without a limit, values are kept in a sync.Pool.
+// With a limit, values are kept until they are reused or evicted.
+type Pool struct {
+	// Name is used to identify the pool in Stats.
+	Name string
+
+	// New optionally specifies a function to generate a value when
+	// Get would otherwise return nil.
+	New func() interface{}
+
+	// Size returns the number of bytes retained by x.
+	// If nil, all values are counted as 0 bytes and will always be retained.
+	Size func(x interface{}) int64
+
+	pool sync.Pool
+
+	cntOnce sync.Once
+	cnt     *poolCounters
+
+	// nfree is the number of retained values, used for fast checks.
+	nfree int32
+
+	// mu protects the fields below.
+	mu       sync.Mutex
+	free     []pooled
+	retained int64
+}
+
+type pooled struct {
+	v    interface{}
+	size int64
+}
+
+// Get returns a value from the pool.
+// If the pool is empty, New is called if set, otherwise nil is returned.
+func (p *Pool) Get() interface{} {
+	if atomic.LoadInt32(&p.nfree) == 0 && !counting() {
+		// Nothing is retained or counted.
+		if v := p.pool.Get(); v != nil {
+			return v
+		}
+		if p.New != nil {
+			return p.New()
+		}
+		return nil
+	}
+	c := p.counters()
+	atomic.AddInt64(&c.gets, 1)
+	if atomic.LoadInt32(&p.nfree) > 0 {
+		p.mu.Lock()
+		if n := len(p.free); n > 0 {
+			v := p.free[n-1]
+			p.free[n-1] = pooled{}
+			p.free = p.free[:n-1]
+			p.removed(v.size)
+			p.mu.Unlock()
+			return v.v
+		}
+		p.mu.Unlock()
+	} else if atomic.LoadInt64(&limit) == 0 {
+		if v := p.pool.Get(); v != nil {
+			return v
+		}
+	}
+	atomic.AddInt64(&c.misses, 1)
+	if p.New != nil {
+		return p.New()
+	}
+	return nil
+}
+
+func (p *Pool) counters() *poolCounters {
+	p.cntOnce.Do(func() {
+		p.cnt = countersFor(p.Name)
+	})
+	return p.cnt
+}
+
+// Put adds x to the pool.
+// If a limit is set and retaining x would exceed the limit, x is dropped.
+func (p *Pool) Put(x interface{}) {
+	if x == nil {
+		return
+	}
+	if atomic.LoadInt64(&limit) == 0 {
+		p.pool.Put(x)
+		return
+	}
+	var size int64
+	if p.Size != nil {
+		size = p.Size(x)
+	}
+	if size > 0 && !reserve(size) {
+		atomic.AddInt64(&p.counters().dropped, 1)
+		return
+	}
+	p.mu.Lock()
+	if len(p.free) == 0 {
+		poolsMu.Lock()
+		pools[p] = struct{}{}
+		poolsMu.Unlock()
+	}
+	p.free = append(p.free, pooled{v: x, size: size})
+	p.retained += size
+	atomic.StoreInt32(&p.nfree, int32(len(p.free)))
+	p.mu.Unlock()
+}
+
+// removed updates accounting after a value of the size has been removed.
+// p.mu must be held.
+func (p *Pool) removed(size int64) {
+	p.retained -= size
+	release(size)
+	atomic.StoreInt32(&p.nfree, int32(len(p.free)))
+	if len(p.free) == 0 {
+		poolsMu.Lock()
+		delete(pools, p)
+		poolsMu.Unlock()
+		// Release the backing array.
+		p.free = nil
+	}
+}
+
+// trim drops values until the global retained size is at most n.
+func (p *Pool) trim(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.free) > 0 && atomic.LoadInt64(&retained) > n {
+		v := p.free[0]
+		p.free[0] = pooled{}
+		p.free = p.free[1:]
+		p.removed(v.size)
+		atomic.AddInt64(&p.counters().dropped, 1)
+	}
+}
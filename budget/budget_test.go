@@ -0,0 +1,158 @@
+package budget
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func newTestPool(name string) *Pool {
+	return &Pool{
+		Name: name,
+		New:  func() interface{} { return make([]byte, 1000) },
+		Size: func(x interface{}) int64 { return int64(cap(x.([]byte))) },
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	SetLimit(0)
+	CollectStats(true)
+	defer CollectStats(false)
+	p := newTestPool("test.unlimited")
+	b := p.Get().([]byte)
+	if len(b) != 1000 {
+		t.Fatal("unexpected buffer")
+	}
+	p.Put(b)
+	if Retained() != 0 {
+		t.Errorf("want 0 retained, got %d", Retained())
+	}
+	p.Get()
+	s := statsFor(t, "test.unlimited")
+	// sync.Pool may drop values, so the second Get may miss.
+	if s.Retained != 0 || s.Gets != 2 || s.Misses < 1 || s.HitRate() > 0.5 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	// Without stats, nothing is counted.
+	CollectStats(false)
+	p.Put(p.Get())
+	if s := statsFor(t, "test.unlimited"); s.Gets != 2 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func statsFor(t *testing.T, name string) PoolStats {
+	t.Helper()
+	for _, s := range Stats() {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no stats for %s", name)
+	return PoolStats{}
+}
+
+func TestLimit(t *testing.T) {
+	defer SetLimit(0)
+	SetLimit(2500)
+	if Limit() != 2500 {
+		t.Fatalf("want limit 2500, got %d", Limit())
+	}
+	a, b := newTestPool("test.a"), newTestPool("test.b")
+	var bufs [][]byte
+	for i := 0; i < 4; i++ {
+		bufs = append(bufs, a.Get().([]byte))
+	}
+	for _, buf := range bufs {
+		a.Put(buf)
+	}
+	if Retained() != 2000 {
+		t.Fatalf("want 2000 retained, got %d", Retained())
+	}
+	// Doesn't fit.
+	b.Put(make([]byte, 1000))
+	b.Put(make([]byte, 500))
+	if Retained() != 2500 {
+		t.Fatalf("want 2500 retained, got %d", Retained())
+	}
+	if s := statsFor(t, "test.a"); s.Retained != 2000 || s.Items != 2 || s.Dropped != 2 || s.Misses != 4 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+	if s := statsFor(t, "test.b"); s.Retained != 500 || s.Items != 1 || s.Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+
+	// Reused buffers are released.
+	got := b.Get().([]byte)
+	if cap(got) != 500 {
+		t.Errorf("want retained buffer, got cap %d", cap(got))
+	}
+	if Retained() != 2000 {
+		t.Fatalf("want 2000 retained, got %d", Retained())
+	}
+
+	// Lowering the limit evicts.
+	SetLimit(1000)
+	if Retained() > 1000 {
+		t.Fatalf("want at most 1000 retained, got %d", Retained())
+	}
+
+	// Removing the limit releases everything.
+	SetLimit(0)
+	if Retained() != 0 {
+		t.Fatalf("want 0 retained, got %d", Retained())
+	}
+	if !bytes.Equal(a.Get().([]byte), make([]byte, 1000)) {
+		t.Error("unexpected buffer")
+	}
+}
+
+func TestNegativeLimit(t *testing.T) {
+	defer SetLimit(0)
+	SetLimit(-1)
+	p := newTestPool("test.negative")
+	p.Put(make([]byte, 10))
+	if Retained() != 0 {
+		t.Errorf("want 0 retained, got %d", Retained())
+	}
+	if got := p.Get().([]byte); len(got) != 1000 {
+		t.Error("expected new buffer")
+	}
+}
+
+func BenchmarkPool(b *testing.B) {
+	b.Run("sync", func(b *testing.B) {
+		p := sync.Pool{New: func() interface{} { return make([]byte, 1000) }}
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				p.Put(p.Get())
+			}
+		})
+	})
+	b.Run("stats", func(b *testing.B) {
+		CollectStats(true)
+		defer CollectStats(false)
+		p := newTestPool("bench")
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				p.Put(p.Get())
+			}
+		})
+	})
+	for _, limit := range []int64{0, 1 << 20} {
+		b.Run(map[int64]string{0: "unlimited", 1 << 20: "limited"}[limit], func(b *testing.B) {
+			SetLimit(limit)
+			defer SetLimit(0)
+			p := newTestPool("bench")
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					p.Put(p.Get())
+				}
+			})
+		})
+	}
+}
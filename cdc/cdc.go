@@ -0,0 +1,210 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package cdc implements content-defined chunking.
+//
+// Content-defined chunking splits data at positions determined by the
+// content rather than at fixed offsets. Inserting or removing data only
+// changes the chunks around the edit, so unchanged data produces identical
+// chunks. This makes the chunks suitable for deduplication and delta sync.
+//
+// The chunker uses the FastCDC algorithm with a gear based rolling hash
+// and normalized chunking, which keeps chunk sizes close to the average.
+//
+// The gear table and cut point selection are part of the package contract:
+// the same data and options always produce the same chunks, across
+// versions and platforms.
+//
+// Writer compresses each chunk as an independent zstd frame or s2 stream
+// in a seekable stream, so chunks can be located and decompressed
+// individually using the seekable index.
+package cdc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	// DefaultAvgSize is the default average chunk size.
+	DefaultAvgSize = 64 << 10
+
+	// MinSize is the smallest allowed minimum chunk size.
+	MinSize = 64
+
+	// MaxSize is the largest allowed maximum chunk size.
+	MaxSize = 1 << 30
+)
+
+// Options control the sizes of chunks.
+// The zero value uses the defaults.
+type Options struct {
+	// AvgSize is the desired average chunk size.
+	// If 0, DefaultAvgSize is used.
+	AvgSize int
+
+	// MinSize is the minimum chunk size.
+	// Only the last chunk can be smaller.
+	// If 0, AvgSize/4 is used.
+	MinSize int
+
+	// MaxSize is the maximum chunk size.
+	// If 0, AvgSize*4 is used.
+	MaxSize int
+}
+
+// params are the validated chunking parameters.
+type params struct {
+	min, avg, max int
+
+	// maskS is used before the average size and maskL after.
+	// maskS has more bits set, making cut points less likely.
+	maskS, maskL uint64
+}
+
+func (o Options) params() (params, error) {
+	p := params{min: o.MinSize, avg: o.AvgSize, max: o.MaxSize}
+	if p.avg == 0 {
+		p.avg = DefaultAvgSize
+	}
+	if p.min == 0 {
+		p.min = p.avg / 4
+	}
+	if p.max == 0 {
+		p.max = p.avg * 4
+	}
+	if p.min < MinSize || p.max > MaxSize || p.min > p.avg || p.avg > p.max {
+		return p, fmt.Errorf("cdc: invalid chunk sizes min %d, avg %d, max %d", p.min, p.avg, p.max)
+	}
+	b := bits.Len(uint(p.avg)) - 1
+	p.maskS = mask(b + 2)
+	p.maskL = mask(b - 2)
+	return p, nil
+}
+
+// mask returns a mask with the n highest bits set.
+// The highest bits of the gear hash depend on the most input bytes.
+func mask(n int) uint64 {
+	if n < 1 {
+		n = 1
+	}
+	return ^uint64(0) << uint(64-n)
+}
+
+// cut returns the length of the first chunk of b.
+// If b is shorter than the maximum chunk size, it is assumed to be the end
+// of the input, and all of b may be returned.
+func (p *params) cut(b []byte) int {
+	n := len(b)
+	if n <= p.min {
+		return n
+	}
+	if n > p.max {
+		n = p.max
+	}
+	normal := p.avg
+	if normal > n {
+		normal = n
+	}
+	var h uint64
+	i := p.min
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[b[i]]
+		if h&p.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[b[i]]
+		if h&p.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// Split returns the chunks of b.
+// The returned slices point into b.
+func Split(b []byte, o Options) ([][]byte, error) {
+	p, err := o.params()
+	if err != nil {
+		return nil, err
+	}
+	var res [][]byte
+	for len(b) > 0 {
+		n := p.cut(b)
+		res = append(res, b[:n])
+		b = b[n:]
+	}
+	return res, nil
+}
+
+// Chunk is a chunk returned by a Chunker.
+type Chunk struct {
+	// Offset is the position of the chunk in the input.
+	Offset int64
+
+	// Data is the content of the chunk.
+	// It is only valid until the next call to Next.
+	Data []byte
+}
+
+// Chunker splits a stream into chunks.
+type Chunker struct {
+	r      io.Reader
+	p      params
+	buf    []byte
+	start  int
+	offset int64
+	err    error
+}
+
+// NewChunker returns a Chunker that reads from r.
+func NewChunker(r io.Reader, o Options) (*Chunker, error) {
+	p, err := o.params()
+	if err != nil {
+		return nil, err
+	}
+	return &Chunker{r: r, p: p, buf: make([]byte, 0, 2*p.max)}, nil
+}
+
+// Next returns the next chunk.
+// At the end of the input, io.EOF is returned.
+func (c *Chunker) Next() (Chunk, error) {
+	for len(c.buf)-c.start < c.p.max && c.err == nil {
+		if c.start > 0 {
+			c.buf = c.buf[:copy(c.buf, c.buf[c.start:])]
+			c.start = 0
+		}
+		n, err := c.r.Read(c.buf[len(c.buf):cap(c.buf)])
+		c.buf = c.buf[:len(c.buf)+n]
+		if err != nil {
+			c.err = err
+		}
+	}
+	if c.err != nil && c.err != io.EOF {
+		return Chunk{}, c.err
+	}
+	avail := c.buf[c.start:]
+	if len(avail) == 0 {
+		return Chunk{}, io.EOF
+	}
+	n := c.p.cut(avail)
+	ch := Chunk{Offset: c.offset, Data: avail[:n:n]}
+	c.start += n
+	c.offset += int64(n)
+	return ch, nil
+}
+
+// Reset resets the Chunker to read from r.
+func (c *Chunker) Reset(r io.Reader) {
+	c.r = r
+	c.buf = c.buf[:0]
+	c.start = 0
+	c.offset = 0
+	c.err = nil
+}
+
+var errClosed = errors.New("cdc: Writer is closed")
@@ -0,0 +1,206 @@
+package cdc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/seekable"
+	"github.com/klauspost/compress/xxhash"
+)
+
+func testInput(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(1)).Read(b)
+	return b
+}
+
+func TestSplitSizes(t *testing.T) {
+	data := testInput(8 << 20)
+	o := Options{AvgSize: 16 << 10}
+	p, err := o.params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := Split(data, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for i, c := range chunks {
+		if len(c) > p.max || (len(c) < p.min && i != len(chunks)-1) {
+			t.Fatalf("chunk %d has size %d", i, len(c))
+		}
+		total += len(c)
+	}
+	if total != len(data) {
+		t.Fatalf("chunks total %d, want %d", total, len(data))
+	}
+	avg := total / len(chunks)
+	if avg < p.avg/2 || avg > p.avg*2 {
+		t.Fatalf("average chunk size %d, want about %d", avg, p.avg)
+	}
+	t.Logf("%d chunks, average %d", len(chunks), avg)
+}
+
+func TestSplitStable(t *testing.T) {
+	data := testInput(1 << 20)
+	chunks, err := Split(data, Options{AvgSize: 8 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut points must not change between versions.
+	want := []int{10363, 18897, 28148, 42607}
+	off := 0
+	for i, c := range chunks[:len(want)] {
+		off += len(c)
+		if off != want[i] {
+			t.Fatalf("cut point %d: got %d, want %d", i, off, want[i])
+		}
+	}
+}
+
+func TestSplitDedup(t *testing.T) {
+	data := testInput(4 << 20)
+	edited := append([]byte("inserted at the start"), data...)
+	edited = append(edited[:2<<20], append([]byte("in the middle"), edited[2<<20:]...)...)
+
+	hashes := func(b []byte) map[uint64]int {
+		chunks, err := Split(b, Options{AvgSize: 16 << 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[uint64]int, len(chunks))
+		for _, c := range chunks {
+			m[xxhash.Sum64(c)] = len(c)
+		}
+		return m
+	}
+	a, b := hashes(data), hashes(edited)
+	shared := 0
+	for h, n := range b {
+		if _, ok := a[h]; ok {
+			shared += n
+		}
+	}
+	// Only chunks around the edits should change.
+	if shared < len(data)*9/10 {
+		t.Fatalf("only %d of %d bytes shared after edit", shared, len(data))
+	}
+	t.Logf("%d of %d bytes shared", shared, len(data))
+}
+
+func TestChunker(t *testing.T) {
+	data := testInput(3<<20 + 12345)
+	o := Options{AvgSize: 16 << 10}
+	want, err := Split(data, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read in small pieces to exercise buffering.
+	c, err := NewChunker(&shortReader{r: bytes.NewReader(data), n: 1000}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var off int64
+	for i := 0; ; i++ {
+		ch, err := c.Next()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Fatalf("got %d chunks, want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ch.Offset != off || !bytes.Equal(ch.Data, want[i]) {
+			t.Fatalf("chunk %d mismatch", i)
+		}
+		off += int64(len(ch.Data))
+	}
+}
+
+// shortReader returns at most n bytes per read.
+type shortReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *shortReader) Read(p []byte) (int, error) {
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	return r.r.Read(p)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, o := range []Options{
+		{AvgSize: 32},
+		{MinSize: 10 << 10, AvgSize: 8 << 10},
+		{AvgSize: 8 << 10, MaxSize: 4 << 10},
+		{AvgSize: 1 << 30},
+	} {
+		if _, err := Split(nil, o); err == nil {
+			t.Errorf("%+v: want error", o)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	data := testInput(2 << 20)
+	copy(data[1<<20:], data[:512<<10])
+	o := Options{AvgSize: 16 << 10}
+	want, err := Split(data, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []seekable.Format{seekable.Zstd, seekable.S2} {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, f, o, seekable.WriterChunkSize(100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in uneven pieces.
+		for b := data; len(b) > 0; {
+			n := 70001
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := w.Write(b[:n]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		r, err := seekable.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx := r.Index()
+		if len(idx.Chunks) != len(want) {
+			t.Fatalf("format %v: got %d chunks, want %d", f, len(idx.Chunks), len(want))
+		}
+		for i := range want {
+			got, err := r.Chunk(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want[i]) {
+				t.Fatalf("format %v: chunk %d mismatch", f, i)
+			}
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("format %v: content mismatch", f)
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package cdc
+
+// gear contains the random values used by the rolling hash.
+// Changing the values changes all cut points.
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed.
+	x := uint64(0x636463)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package cdc
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/seekable"
+)
+
+// Writer compresses a stream as a seekable stream,
+// with a compressed chunk for each content-defined chunk.
+//
+// Since chunks are compressed independently, identical chunks in different
+// streams have identical compressed frames, which can be stored once.
+// The uncompressed and compressed positions of each chunk are in the index.
+type Writer struct {
+	sw  *seekable.Writer
+	p   params
+	buf []byte
+	err error
+}
+
+// NewWriter returns a Writer that writes a seekable stream in the format to w.
+// The options are passed to seekable.NewWriter. The chunk size is set to
+// the maximum chunk size and cannot be changed.
+// Close must be called to write the remaining chunks and the index.
+func NewWriter(w io.Writer, f seekable.Format, o Options, opts ...seekable.WriterOption) (*Writer, error) {
+	p, err := o.params()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts[:len(opts):len(opts)], seekable.WriterChunkSize(p.max))
+	sw, err := seekable.NewWriter(w, f, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{sw: sw, p: p}, nil
+}
+
+// Write adds p to the stream.
+// Chunks are written when their end has been determined.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.p.max {
+		return len(p), nil
+	}
+	b := w.buf
+	for len(b) >= w.p.max {
+		n := w.p.cut(b)
+		if err := w.writeChunk(b[:n]); err != nil {
+			return 0, err
+		}
+		b = b[n:]
+	}
+	w.buf = w.buf[:copy(w.buf, b)]
+	return len(p), nil
+}
+
+func (w *Writer) writeChunk(b []byte) error {
+	if _, err := w.sw.Write(b); err != nil {
+		w.err = err
+		return err
+	}
+	if err := w.sw.Flush(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// Close writes the remaining chunks and the index.
+// The Writer cannot be used after Close.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	b := w.buf
+	for len(b) > 0 {
+		n := w.p.cut(b)
+		if err := w.writeChunk(b[:n]); err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	w.buf = nil
+	w.err = errClosed
+	return w.sw.Close()
+}
+
+// Index returns the index of the chunks written so far.
+// The returned index must not be modified.
+func (w *Writer) Index() *seekable.Index {
+	return w.sw.Index()
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/auto"
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	decompress = flag.Bool("d", false, "Decompress files. The format is detected from the content")
+	test       = flag.Bool("t", false, "Test integrity of compressed files. No output will be written")
+	list       = flag.Bool("list", false, "List format and sizes of compressed files")
+	format     = flag.String("F", "", "Compression format: gzip, zstd or s2. Default is detected from -o, otherwise zstd")
+	level      = flag.Int("level", 0, "Compression level. gzip: 1-9, zstd: 1-22, s2: 1-3. 0 uses the default of the format")
+	output     = flag.String("o", "", "Write output to this file. Only valid with a single input")
+	recursive  = flag.Bool("r", false, "Operate recursively on directories")
+	cpu        = flag.Int("T", runtime.GOMAXPROCS(0), "Compress/decompress using this amount of threads, if supported by the format")
+	safe       = flag.Bool("safe", false, "Do not overwrite output files")
+	stdout     = flag.Bool("c", false, "Write all output to stdout. Multiple input files will be concatenated")
+	remove     = flag.Bool("rm", false, "Delete source file(s) after successful compression or decompression. By default they are kept")
+	quiet      = flag.Bool("q", false, "Don't write any output to terminal, except errors")
+	help       = flag.Bool("help", false, "Display help")
+
+	version = "(dev)"
+	date    = "(unknown)"
+)
+
+// formatInfo describes a format that can be used for compression.
+type formatInfo struct {
+	name   string
+	ext    string
+	levels [2]int
+	def    int
+}
+
+var formats = []formatInfo{
+	{name: codec.NameGzip, ext: ".gz", levels: [2]int{gzip.BestSpeed, gzip.BestCompression}, def: 6},
+	{name: codec.NameZstd, ext: ".zst", levels: [2]int{1, 22}, def: 3},
+	{name: codec.NameS2, ext: ".s2", levels: [2]int{1, 3}, def: 1},
+}
+
+// decompressExt contains extensions removed when decompressing.
+var decompressExt = []string{".gz", ".zst", ".zstd", ".s2", ".snappy", ".sz", ".lz4", ".xz", ".bz2", ".zz"}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	modes := 0
+	for _, b := range []bool{*decompress, *test, *list} {
+		if b {
+			modes++
+		}
+	}
+	if len(args) == 0 || *help || modes > 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "kcompress v%v, built at %v.\n\n", version, date)
+		_, _ = fmt.Fprintf(os.Stderr, "Copyright (c) 2021 Klaus Post. All rights reserved.\n\n")
+		_, _ = fmt.Fprintln(os.Stderr, `Usage: kcompress [options] file1 file2
+
+Compresses all files supplied as input separately using gzip, zstd or s2.
+Output files are written as 'filename.ext' + '.gz', '.zst' or '.s2'.
+The format is selected with -F or by the extension of the -o file name.
+
+Use -d to decompress files. The format is detected from the content, so
+gzip, zlib, zstd, s2, snappy, lz4, xz and bzip2 files can be decompressed.
+Known extensions are removed from output file names.
+Use -t to test compressed files and -list to show information about them.
+
+By default output files will be overwritten and input files kept.
+Use - as the only file name to read from stdin and write to stdout.
+
+Wildcards are accepted: testdir/*.txt will compress all files in testdir ending with .txt
+Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/b.txt
+Use -r to include all files in directories.
+
+The exit code is 0 on success and 2 on errors.
+
+Options:`)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	f := selectFormat()
+	if len(args) == 1 && args[0] == "-" {
+		// Catch interrupt, so we don't exit at once.
+		// os.Stdin will return EOF, so we should be able to get everything.
+		signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+		switch {
+		case *list:
+			info, err := listStream(os.Stdin)
+			exitErr(err)
+			printList([]listInfo{info}, []string{"(stdin)"})
+		case *decompress, *test:
+			r, _, err := auto.NewReader(bufio.NewReaderSize(os.Stdin, 1<<20))
+			exitErr(err)
+			out, done := createOutput(*output, 0666)
+			if *test {
+				out = ioutil.Discard
+			}
+			_, err = io.Copy(out, r)
+			exitErr(err)
+			exitErr(r.Close())
+			exitErr(done())
+		default:
+			out, done := createOutput(*output, 0666)
+			w, err := newWriter(f, out)
+			exitErr(err)
+			_, err = io.Copy(w, bufio.NewReaderSize(os.Stdin, 1<<20))
+			exitErr(err)
+			exitErr(w.Close())
+			exitErr(done())
+		}
+		return
+	}
+
+	files := findFiles(args)
+	if *output != "" && len(files) != 1 {
+		exitErr(errors.New("-o can only be used with a single input file"))
+	}
+	*quiet = *quiet || *stdout || *output == "-"
+	switch {
+	case *list:
+		infos := make([]listInfo, 0, len(files))
+		for _, filename := range files {
+			file, err := os.Open(filename)
+			exitErr(err)
+			info, err := listStream(file)
+			file.Close()
+			if err != nil {
+				exitErr(fmt.Errorf("%s: %w", filename, err))
+			}
+			infos = append(infos, info)
+		}
+		printList(infos, files)
+	case *decompress, *test:
+		for _, filename := range files {
+			decompressFile(filename)
+		}
+	default:
+		for _, filename := range files {
+			compressFile(f, filename)
+		}
+	}
+}
+
+// selectFormat returns the format used for compression.
+func selectFormat() formatInfo {
+	name := strings.ToLower(*format)
+	if name == "" && *output != "" && *output != "-" {
+		for _, f := range formats {
+			if strings.HasSuffix(*output, f.ext) {
+				name = f.name
+			}
+		}
+	}
+	if name == "" {
+		name = codec.NameZstd
+	}
+	for _, f := range formats {
+		if f.name == name {
+			if *level == 0 {
+				*level = f.def
+			}
+			if *level < f.levels[0] || *level > f.levels[1] {
+				exitErr(fmt.Errorf("%s level must be %d to %d, got %d", f.name, f.levels[0], f.levels[1], *level))
+			}
+			return f
+		}
+	}
+	exitErr(fmt.Errorf("unknown format %q. Use gzip, zstd or s2", *format))
+	return formatInfo{}
+}
+
+// newWriter returns a compressing writer for the format.
+func newWriter(f formatInfo, w io.Writer) (io.WriteCloser, error) {
+	var c codec.Codec
+	switch f.name {
+	case codec.NameGzip:
+		c = codec.Gzip(*level)
+	case codec.NameZstd:
+		c = codec.Zstd(zstd.EncoderLevelFromZstd(*level), zstd.WithEncoderConcurrency(*cpu))
+	case codec.NameS2:
+		opts := []s2.WriterOption{s2.WriterConcurrency(*cpu)}
+		switch *level {
+		case 2:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 3:
+			opts = append(opts, s2.WriterBestCompression())
+		}
+		c = codec.S2(opts...)
+	default:
+		return nil, fmt.Errorf("unknown format %q", f.name)
+	}
+	return c.NewWriter(w)
+}
+
+// findFiles expands the patterns to file names.
+// Directories are walked if recursive is set, otherwise they are skipped.
+func findFiles(patterns []string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		exitErr(err)
+		if len(found) == 0 {
+			exitErr(fmt.Errorf("unable to find file %v", pattern))
+		}
+		for _, name := range found {
+			st, err := os.Stat(name)
+			exitErr(err)
+			if !st.IsDir() {
+				files = append(files, name)
+				continue
+			}
+			if !*recursive {
+				if !*quiet {
+					fmt.Println("Skipping directory", name)
+				}
+				continue
+			}
+			err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			exitErr(err)
+		}
+	}
+	return files
+}
+
+func compressFile(f formatInfo, filename string) {
+	if strings.HasSuffix(filename, f.ext) && !*stdout && *output == "" {
+		if !*quiet {
+			fmt.Println("Skipping", filename, "already has", f.ext, "extension")
+		}
+		return
+	}
+	var closeOnce sync.Once
+	dstFilename := filename + f.ext
+	if *output != "" {
+		dstFilename = *output
+	}
+	if !*quiet {
+		fmt.Print("Compressing ", filename, " -> ", dstFilename)
+	}
+	file, err := os.Open(filename)
+	exitErr(err)
+	defer closeOnce.Do(func() { file.Close() })
+	st, err := file.Stat()
+	exitErr(err)
+	out, done := createOutput(dstFilename, st.Mode())
+	wc := wCounter{out: out}
+	w, err := newWriter(f, &wc)
+	exitErr(err)
+	start := time.Now()
+	input, err := io.Copy(w, bufio.NewReaderSize(file, 1<<20))
+	exitErr(err)
+	exitErr(w.Close())
+	exitErr(done())
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbpersec := (float64(input) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(wc.n) * 100 / float64(input)
+		fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", input, wc.n, pct, mbpersec)
+	}
+	if *remove {
+		closeOnce.Do(func() {
+			file.Close()
+			removeFile(filename)
+		})
+	}
+}
+
+func decompressFile(filename string) {
+	dstFilename := *output
+	if dstFilename == "" {
+		for _, ext := range decompressExt {
+			if strings.HasSuffix(filename, ext) {
+				dstFilename = strings.TrimSuffix(filename, ext)
+				break
+			}
+		}
+	}
+	switch {
+	case *test:
+		dstFilename = "(test)"
+	case *stdout:
+		dstFilename = "(stdout)"
+	case dstFilename == "":
+		if !*quiet {
+			fmt.Println("Skipping", filename, "unknown extension, use -o or -c")
+		}
+		return
+	}
+	var closeOnce sync.Once
+	if !*quiet {
+		fmt.Print("Decompressing ", filename, " -> ", dstFilename)
+	}
+	file, err := os.Open(filename)
+	exitErr(err)
+	defer closeOnce.Do(func() { file.Close() })
+	st, err := file.Stat()
+	exitErr(err)
+	rc := rCounter{in: file}
+	r, detected, err := auto.NewReader(bufio.NewReaderSize(&rc, 1<<20))
+	if err != nil {
+		exitErr(fmt.Errorf("%s: %w", filename, err))
+	}
+	var out io.Writer = ioutil.Discard
+	done := func() error { return nil }
+	if !*test {
+		out, done = createOutput(dstFilename, st.Mode())
+	}
+	start := time.Now()
+	output, err := io.Copy(out, r)
+	if err == nil {
+		err = r.Close()
+	}
+	if err != nil {
+		exitErr(fmt.Errorf("%s: %w", filename, err))
+	}
+	exitErr(done())
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbPerSec := (float64(output) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(output) * 100 / float64(rc.n)
+		fmt.Printf(" (%s) %d -> %d [%.02f%%]; %.01fMB/s\n", detected, rc.n, output, pct, mbPerSec)
+	}
+	if *remove && !*test {
+		closeOnce.Do(func() {
+			file.Close()
+			removeFile(filename)
+		})
+	}
+}
+
+// createOutput returns the writer for output and a function that must be
+// called when all output has been written.
+func createOutput(name string, mode os.FileMode) (io.Writer, func() error) {
+	if *stdout || name == "" || name == "-" {
+		return os.Stdout, func() error { return nil }
+	}
+	if *safe {
+		_, err := os.Stat(name)
+		if !os.IsNotExist(err) {
+			exitErr(errors.New("destination file exists"))
+		}
+	}
+	dstFile, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	exitErr(err)
+	bw := bufio.NewWriterSize(dstFile, 1<<20)
+	return bw, func() error {
+		err := bw.Flush()
+		if err2 := dstFile.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}
+}
+
+func removeFile(name string) {
+	if !*quiet {
+		fmt.Println("Removing", name)
+	}
+	exitErr(os.Remove(name))
+}
+
+// listInfo contains information about a compressed stream.
+type listInfo struct {
+	format                   auto.Format
+	compressed, uncompressed int64
+}
+
+// listStream detects the format of r and decompresses it to determine the size.
+func listStream(r io.Reader) (listInfo, error) {
+	rc := rCounter{in: r}
+	dec, f, err := auto.NewReader(bufio.NewReaderSize(&rc, 1<<20))
+	if err != nil {
+		return listInfo{format: f}, err
+	}
+	n, err := io.Copy(ioutil.Discard, dec)
+	if err == nil {
+		err = dec.Close()
+	}
+	return listInfo{format: f, compressed: int64(rc.n), uncompressed: n}, err
+}
+
+func printList(infos []listInfo, names []string) {
+	fmt.Printf("%-7s %12s %14s %7s  %s\n", "Format", "Compressed", "Uncompressed", "Ratio", "Filename")
+	for i, info := range infos {
+		ratio := ""
+		if info.compressed > 0 {
+			ratio = fmt.Sprintf("%.3f", float64(info.uncompressed)/float64(info.compressed))
+		}
+		fmt.Printf("%-7s %12s %14s %7s  %s\n", info.format, humanSize(info.compressed), humanSize(info.uncompressed), ratio, names[i])
+	}
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func exitErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
+		os.Exit(2)
+	}
+}
+
+type wCounter struct {
+	n   int
+	out io.Writer
+}
+
+func (w *wCounter) Write(p []byte) (n int, err error) {
+	n, err = w.out.Write(p)
+	w.n += n
+	return n, err
+}
+
+type rCounter struct {
+	n  int
+	in io.Reader
+}
+
+func (w *rCounter) Read(p []byte) (n int, err error) {
+	n, err = w.in.Read(p)
+	w.n += n
+	return n, err
+}
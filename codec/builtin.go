@@ -0,0 +1,255 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/lz4"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Names of the built-in codecs.
+const (
+	NameGzip    = "gzip"
+	NameZlib    = "zlib"
+	NameDeflate = "deflate"
+	NameZstd    = "zstd"
+	NameS2      = "s2"
+	NameSnappy  = "snappy"
+	NameLZ4     = "lz4"
+)
+
+func init() {
+	Register(Gzip(gzip.DefaultCompression))
+	Register(Zlib(zlib.DefaultCompression))
+	Register(Deflate(flate.DefaultCompression))
+	Register(Zstd(zstd.SpeedDefault))
+	Register(S2())
+	Register(Snappy())
+	Register(LZ4())
+}
+
+// Gzip returns a gzip codec with the specified compression level.
+// An invalid level will return errors when compressing.
+func Gzip(level int) Codec {
+	return &streamCodec{
+		name: NameGzip,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// Zlib returns a zlib codec with the specified compression level.
+// An invalid level will return errors when compressing.
+func Zlib(level int) Codec {
+	return &streamCodec{
+		name: NameZlib,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		newReader: zlib.NewReader,
+	}
+}
+
+// Deflate returns a raw deflate codec with the specified compression level.
+// An invalid level will return errors when compressing.
+func Deflate(level int) Codec {
+	return &streamCodec{
+		name: NameDeflate,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	}
+}
+
+// S2 returns an S2 codec using the S2 stream format.
+// The writer options are used for all compression.
+func S2(opts ...s2.WriterOption) Codec {
+	return &streamCodec{
+		name: NameS2,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return s2.NewWriter(w, opts...), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(s2.NewReader(r)), nil
+		},
+	}
+}
+
+// Snappy returns a codec that produces Snappy compatible streams.
+// Decoding will also accept S2 streams.
+func Snappy() Codec {
+	return &streamCodec{
+		name: NameSnappy,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return newSnappyWriter(w), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(s2.NewReader(r)), nil
+		},
+	}
+}
+
+// LZ4 returns a codec using the LZ4 frame format.
+// The writer options are used for all compression.
+func LZ4(opts ...lz4.WriterOption) Codec {
+	return &streamCodec{
+		name: NameLZ4,
+		newWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return lz4.NewWriter(w, opts...), nil
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(lz4.NewReader(r)), nil
+		},
+	}
+}
+
+// Zstd returns a zstd codec.
+// Blocks are compressed with EncodeAll and decompressed with DecodeAll
+// using shared encoders and decoders.
+// Additional encoder options can be supplied.
+func Zstd(level zstd.EncoderLevel, opts ...zstd.EOption) Codec {
+	return &zstdCodec{eopts: append([]zstd.EOption{zstd.WithEncoderLevel(level)}, opts...)}
+}
+
+// writeResetCloser is a writer that can be reused.
+type writeResetCloser interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// NewStream returns a codec for a streaming compression format.
+// Blocks are compressed by writing them to a writer returned by newWriter.
+// If the writers have a Reset(io.Writer) method, they are reused for block compression.
+// This can be used to expose compressors from other packages in the registry.
+func NewStream(name string, newWriter func(w io.Writer) (io.WriteCloser, error), newReader func(r io.Reader) (io.ReadCloser, error)) Codec {
+	return &streamCodec{name: name, newWriter: newWriter, newReader: newReader}
+}
+
+// streamCodec implements block compression using streams.
+// Writers that can be reset are pooled for block compression.
+type streamCodec struct {
+	name      string
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	writers   sync.Pool
+}
+
+func (c *streamCodec) Name() string {
+	return c.name
+}
+
+func (c *streamCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return c.newWriter(w)
+}
+
+func (c *streamCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return c.newReader(r)
+}
+
+func (c *streamCodec) Encode(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	var w io.WriteCloser
+	if rw, _ := c.writers.Get().(writeResetCloser); rw != nil {
+		rw.Reset(buf)
+		w = rw
+	} else {
+		var err error
+		w, err = c.newWriter(buf)
+		if err != nil {
+			return dst, err
+		}
+	}
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	if rw, ok := w.(writeResetCloser); ok {
+		rw.Reset(nil)
+		c.writers.Put(rw)
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *streamCodec) Decode(dst, src []byte) ([]byte, error) {
+	r, err := c.newReader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	_, err = buf.ReadFrom(r)
+	if err != nil {
+		r.Close()
+		return dst, err
+	}
+	return buf.Bytes(), r.Close()
+}
+
+// zstdCodec uses a shared encoder and decoder for blocks.
+type zstdCodec struct {
+	eopts []zstd.EOption
+
+	once    sync.Once
+	enc     *zstd.Encoder
+	dec     *zstd.Decoder
+	initErr error
+}
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		c.enc, c.initErr = zstd.NewWriter(nil, c.eopts...)
+		if c.initErr != nil {
+			return
+		}
+		c.dec, c.initErr = zstd.NewReader(nil)
+	})
+	return c.initErr
+}
+
+func (c *zstdCodec) Name() string {
+	return NameZstd
+}
+
+func (c *zstdCodec) Encode(dst, src []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return dst, err
+	}
+	return c.enc.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCodec) Decode(dst, src []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return dst, err
+	}
+	return c.dec.DecodeAll(src, dst)
+}
+
+func (c *zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, c.eopts...)
+}
+
+func (c *zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package codec provides common interfaces for the compression formats
+// in this repository and a registry to look them up by name.
+//
+// This allows frameworks to select a compression format from configuration:
+//
+//	c, ok := codec.Get("zstd")
+//	if !ok {
+//		return fmt.Errorf("unknown codec")
+//	}
+//	compressed, err := c.Encode(nil, data)
+//
+// All built-in codecs are registered by default.
+package codec
+
+import (
+	"io"
+	"sort"
+	"sync"
+)
+
+// Compressor compresses blocks of data.
+type Compressor interface {
+	// Encode appends the compressed src to dst and returns the result.
+	Encode(dst, src []byte) ([]byte, error)
+}
+
+// Decompressor decompresses blocks of data.
+type Decompressor interface {
+	// Decode appends the decompressed src to dst and returns the result.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// StreamCompressor provides streaming compression and decompression.
+type StreamCompressor interface {
+	// NewWriter returns a writer that compresses to w.
+	// The writer must be closed to flush all data.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Codec is a named compression format.
+// Blocks produced by Encode can be read with NewReader,
+// and streams produced by NewWriter can be decoded with Decode.
+// All methods must be safe for concurrent use.
+type Codec interface {
+	// Name returns the name of the codec, for example "gzip".
+	Name() string
+
+	Compressor
+	Decompressor
+	StreamCompressor
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Codec)
+)
+
+// Register adds a codec to the registry.
+// If a codec with the same name exists, it is replaced.
+// This can be used to register a built-in codec with different settings.
+func Register(c Codec) {
+	registryMu.Lock()
+	registry[c.Name()] = c
+	registryMu.Unlock()
+}
+
+// Get returns the codec with the supplied name.
+func Get(name string) (Codec, bool) {
+	registryMu.RLock()
+	c, ok := registry[name]
+	registryMu.RUnlock()
+	return c, ok
+}
+
+// Names returns the names of all registered codecs in sorted order.
+func Names() []string {
+	registryMu.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	registryMu.RUnlock()
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func testData() [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+	return [][]byte{
+		nil,
+		[]byte("hello"),
+		bytes.Repeat([]byte("hello, world\n"), 20000),
+		random,
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	names := Names()
+	if len(names) < 7 {
+		t.Fatalf("want at least 7 codecs, got %v", names)
+	}
+	for _, name := range names {
+		c, ok := Get(name)
+		if !ok {
+			t.Fatalf("codec %q not found", name)
+		}
+		if c.Name() != name {
+			t.Fatalf("name mismatch: %q != %q", c.Name(), name)
+		}
+		t.Run(name, func(t *testing.T) {
+			for _, in := range testData() {
+				// Block, reusing pooled state.
+				for i := 0; i < 2; i++ {
+					prefix := []byte("prefix")
+					enc, err := c.Encode(prefix, in)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.HasPrefix(enc, prefix) {
+						t.Fatal("dst not preserved")
+					}
+					dec, err := c.Decode(prefix, enc[len(prefix):])
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(dec[len(prefix):], in) {
+						t.Fatal("block output mismatch")
+					}
+				}
+
+				// Stream written, read as block and stream.
+				var buf bytes.Buffer
+				w, err := c.NewWriter(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write(in); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				dec, err := c.Decode(nil, buf.Bytes())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(dec, in) {
+					t.Fatal("stream to block output mismatch")
+				}
+				r, err := c.NewReader(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec, err = ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(dec, in) {
+					t.Fatal("stream output mismatch")
+				}
+			}
+		})
+	}
+}
+
+func TestSnappyCompatible(t *testing.T) {
+	c, _ := Get(NameSnappy)
+	for _, in := range testData() {
+		enc, err := c.Encode(nil, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(snappy.NewReader(bytes.NewReader(enc)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatal("output mismatch")
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register(Gzip(9))
+	defer Register(Gzip(-1))
+	c, ok := Get(NameGzip)
+	if !ok {
+		t.Fatal("gzip not found")
+	}
+	if _, err := c.Encode(nil, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Get("unknown"); ok {
+		t.Fatal("unexpected codec")
+	}
+	if _, err := Gzip(100).Encode(nil, []byte("hello")); err == nil {
+		t.Fatal("expected error on invalid level")
+	}
+}
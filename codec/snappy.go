@@ -0,0 +1,121 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package codec
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+)
+
+const (
+	snappyMaxBlockSize = 64 << 10
+	snappyMagicChunk   = "\xff\x06\x00\x00sNaPpY"
+
+	snappyChunkCompressed   = 0x00
+	snappyChunkUncompressed = 0x01
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// snappyCRC returns the masked CRC as described in the Snappy framing format.
+func snappyCRC(b []byte) uint32 {
+	c := crc32.Update(0, crcTable, b)
+	return c>>15 | c<<17 + 0xa282ead8
+}
+
+var errClosed = errors.New("codec: writer closed")
+
+// snappyWriter writes streams in the Snappy framing format.
+// Blocks are compressed with s2.EncodeSnappy.
+type snappyWriter struct {
+	w       io.Writer
+	buf     []byte
+	enc     []byte
+	out     []byte
+	err     error
+	wroteID bool
+}
+
+func newSnappyWriter(w io.Writer) *snappyWriter {
+	return &snappyWriter{w: w, buf: make([]byte, 0, snappyMaxBlockSize)}
+}
+
+func (w *snappyWriter) Reset(dst io.Writer) {
+	w.w = dst
+	w.buf = w.buf[:0]
+	w.err = nil
+	w.wroteID = false
+}
+
+func (w *snappyWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int
+	for len(p) > 0 {
+		todo := p
+		if free := snappyMaxBlockSize - len(w.buf); len(todo) > free {
+			todo = todo[:free]
+		}
+		w.buf = append(w.buf, todo...)
+		p = p[len(todo):]
+		n += len(todo)
+		if len(w.buf) == snappyMaxBlockSize {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *snappyWriter) flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.out = w.out[:0]
+	if !w.wroteID {
+		w.out = append(w.out, snappyMagicChunk...)
+		w.wroteID = true
+	}
+	if len(w.buf) > 0 {
+		const headerSize = 8
+		start := len(w.out)
+		w.out = append(w.out, make([]byte, headerSize)...)
+		if n := s2.MaxEncodedLen(len(w.buf)); cap(w.enc) < n {
+			w.enc = make([]byte, n)
+		}
+		w.enc = s2.EncodeSnappy(w.enc[:cap(w.enc)], w.buf)
+		chunk := byte(snappyChunkCompressed)
+		if len(w.enc) < len(w.buf) {
+			w.out = append(w.out, w.enc...)
+		} else {
+			chunk = snappyChunkUncompressed
+			w.out = append(w.out, w.buf...)
+		}
+		n := len(w.out) - start - 4
+		c := snappyCRC(w.buf)
+		hdr := w.out[start : start+headerSize]
+		hdr[0], hdr[1], hdr[2], hdr[3] = chunk, uint8(n), uint8(n>>8), uint8(n>>16)
+		hdr[4], hdr[5], hdr[6], hdr[7] = uint8(c), uint8(c>>8), uint8(c>>16), uint8(c>>24)
+		w.buf = w.buf[:0]
+	}
+	if len(w.out) > 0 {
+		_, w.err = w.w.Write(w.out)
+	}
+	return w.err
+}
+
+// Close flushes remaining data.
+// The underlying writer is not closed.
+func (w *snappyWriter) Close() error {
+	err := w.flush()
+	if err == nil {
+		w.err = errClosed
+	}
+	return err
+}
@@ -0,0 +1,6 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// The file system requires Go 1.16 or later.
+
+package compressfs
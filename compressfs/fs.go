@@ -0,0 +1,420 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+// Package compressfs provides a file system that transparently decompresses files.
+//
+// Files stored as 'name.gz', 'name.zst' or 'name.s2' are presented as 'name'
+// with decompressed content. This allows storing compressed assets,
+// for example with embed.FS, and serving them with http.FileServer:
+//
+//	//go:embed static
+//	var static embed.FS
+//
+//	http.Handle("/", http.FileServer(http.FS(compressfs.New(static))))
+//
+// The decompressed size of a file is only determined when requested,
+// by decompressing the file once. Sizes are cached.
+package compressfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DecoderFunc returns a reader that decompresses r.
+type DecoderFunc func(r io.Reader) (io.ReadCloser, error)
+
+// Option configures the file system.
+type Option func(*FS)
+
+// WithDecoder adds a decoder for files with the extension.
+// The extension must include the leading dot, for example ".gz".
+// Extensions are tried in the order they are added, after the defaults.
+// If the extension is already registered, the decoder is replaced.
+func WithDecoder(ext string, dec DecoderFunc) Option {
+	return func(f *FS) {
+		for i := range f.decoders {
+			if f.decoders[i].ext == ext {
+				f.decoders[i].dec = dec
+				return
+			}
+		}
+		f.decoders = append(f.decoders, decoder{ext: ext, dec: dec})
+	}
+}
+
+// WithoutDefaults removes the default decoders.
+// Use WithDecoder to add decoders.
+func WithoutDefaults() Option {
+	return func(f *FS) {
+		f.decoders = nil
+	}
+}
+
+type decoder struct {
+	ext string
+	dec DecoderFunc
+}
+
+// FS is a file system that decompresses files.
+// Uncompressed files are returned as is.
+// If both 'name' and 'name.gz' exist, 'name' is returned.
+type FS struct {
+	fsys     fs.FS
+	decoders []decoder
+
+	// sizes contains decompressed sizes, indexed by the compressed name.
+	sizes sync.Map
+}
+
+// New returns a file system that decompresses files in fsys.
+func New(fsys fs.FS, opts ...Option) *FS {
+	f := &FS{
+		fsys: fsys,
+		decoders: []decoder{
+			{ext: ".gz", dec: newGzip},
+			{ext: ".zst", dec: newZstd},
+			{ext: ".s2", dec: newS2},
+		},
+	}
+	for _, o := range opts {
+		o(f)
+	}
+	return f
+}
+
+func newGzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func newZstd(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func newS2(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(s2.NewReader(r)), nil
+}
+
+// Open opens the named file.
+// If the file doesn't exist, the compressed versions are tried.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	file, err := f.fsys.Open(name)
+	if err == nil {
+		if st, err := file.Stat(); err == nil && st.IsDir() {
+			return &dirFile{File: file, fs: f, name: name}, nil
+		}
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, d := range f.decoders {
+		cf, err := f.fsys.Open(name + d.ext)
+		if err != nil {
+			continue
+		}
+		st, err := cf.Stat()
+		if err != nil || st.IsDir() {
+			cf.Close()
+			continue
+		}
+		return f.newFile(name, d, cf, st)
+	}
+	return nil, err
+}
+
+// Stat returns information about the named file.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+// ReadDir reads the named directory.
+// Compressed files are listed with the extension removed.
+// Entries are sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return f.convertEntries(name, entries), nil
+}
+
+// convertEntries replaces compressed entries with decompressed entries.
+func (f *FS) convertEntries(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	exists := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		exists[e.Name()] = struct{}{}
+	}
+	res := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		d, ok := f.decoderFor(e.Name())
+		if !ok || e.IsDir() {
+			res = append(res, e)
+			continue
+		}
+		plain := strings.TrimSuffix(e.Name(), d.ext)
+		if _, ok := exists[plain]; ok {
+			// Uncompressed file or another compressed version takes precedence.
+			continue
+		}
+		exists[plain] = struct{}{}
+		res = append(res, &dirEntry{DirEntry: e, name: plain, fs: f, path: path.Join(dir, e.Name()), d: d})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+	return res
+}
+
+func (f *FS) decoderFor(name string) (decoder, bool) {
+	for _, d := range f.decoders {
+		if strings.HasSuffix(name, d.ext) && len(name) > len(d.ext) {
+			return d, true
+		}
+	}
+	return decoder{}, false
+}
+
+// size returns the decompressed size of the named compressed file.
+func (f *FS) size(name string, d decoder) (int64, error) {
+	if v, ok := f.sizes.Load(name); ok {
+		return v.(int64), nil
+	}
+	cf, err := f.fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer cf.Close()
+	rc, err := d.dec(cf)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(ioutil.Discard, rc)
+	if err2 := rc.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return 0, err
+	}
+	f.sizes.Store(name, n)
+	return n, nil
+}
+
+// dirEntry is a directory entry of a compressed file.
+type dirEntry struct {
+	fs.DirEntry
+	name string
+	fs   *FS
+	path string
+	d    decoder
+}
+
+func (e *dirEntry) Name() string {
+	return e.name
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	st, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{FileInfo: st, name: e.name, fs: e.fs, path: e.path, d: e.d}, nil
+}
+
+// fileInfo is the information of a compressed file.
+// The size is the decompressed size.
+type fileInfo struct {
+	fs.FileInfo
+	name string
+	fs   *FS
+	path string
+	d    decoder
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// Size returns the decompressed size.
+// If the file cannot be decompressed, -1 is returned.
+func (fi *fileInfo) Size() int64 {
+	n, err := fi.fs.size(fi.path, fi.d)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// file is an open compressed file.
+// Seeking forward is done by decompressing and discarding,
+// and seeking backwards restarts decompression.
+type file struct {
+	fs   *FS
+	info *fileInfo
+	f    fs.File
+	rc   io.ReadCloser
+	pos  int64
+	err  error
+}
+
+func (f *FS) newFile(name string, d decoder, cf fs.File, st fs.FileInfo) (*file, error) {
+	rc, err := d.dec(cf)
+	if err != nil {
+		cf.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	cname := name + d.ext
+	return &file{
+		fs:   f,
+		info: &fileInfo{FileInfo: st, name: path.Base(name), fs: f, path: cname, d: d},
+		f:    cf,
+		rc:   rc,
+	}, nil
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err := f.rc.Read(p)
+	f.pos += int64(n)
+	if err != nil && err != io.EOF {
+		f.err = err
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next read.
+// Seeking relative to the end requires the decompressed size.
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		size, err := f.fs.size(f.info.path, f.info.d)
+		if err != nil {
+			return 0, err
+		}
+		abs = size + offset
+	default:
+		return 0, errors.New("compressfs: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("compressfs: negative position")
+	}
+	if abs < f.pos {
+		if err := f.restart(); err != nil {
+			return 0, err
+		}
+	}
+	if abs > f.pos {
+		n, err := io.CopyN(ioutil.Discard, f.rc, abs-f.pos)
+		f.pos += n
+		if err != nil && err != io.EOF {
+			f.err = err
+			return f.pos, err
+		}
+	}
+	return f.pos, nil
+}
+
+// restart reopens the compressed file and starts decompressing from the beginning.
+func (f *file) restart() error {
+	f.rc.Close()
+	f.f.Close()
+	cf, err := f.fs.fsys.Open(f.info.path)
+	if err != nil {
+		f.err = err
+		return err
+	}
+	rc, err := f.info.d.dec(cf)
+	if err != nil {
+		cf.Close()
+		f.err = err
+		return err
+	}
+	f.f, f.rc, f.pos = cf, rc, 0
+	return nil
+}
+
+func (f *file) Close() error {
+	err := f.rc.Close()
+	if err2 := f.f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// dirFile is an open directory.
+// Compressed files are listed with the extension removed.
+type dirFile struct {
+	fs.File
+	fs      *FS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+// ReadDir reads the directory. All entries are read on the first call,
+// so compressed and uncompressed versions of a file can be merged.
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		rd, ok := d.File.(fs.ReadDirFile)
+		if !ok {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: errors.New("not implemented")}
+		}
+		entries, err := rd.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = d.fs.convertEntries(d.name, entries)
+		d.read = true
+	}
+	if n <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	res := d.entries[:n]
+	d.entries = d.entries[n:]
+	return res, nil
+}
@@ -0,0 +1,184 @@
+//go:build go1.16
+// +build go1.16
+
+package compressfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gz(b []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zst(b []byte) []byte {
+	enc, _ := zstd.NewWriter(nil)
+	return enc.EncodeAll(b, nil)
+}
+
+func s2s(b []byte) []byte {
+	var buf bytes.Buffer
+	w := s2.NewWriter(&buf)
+	w.Write(b)
+	w.Close()
+	return buf.Bytes()
+}
+
+var (
+	contentA = []byte(strings.Repeat("hello gzip ", 1000))
+	contentB = []byte(strings.Repeat("hello zstd ", 1000))
+	contentC = []byte(strings.Repeat("hello s2 ", 1000))
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"a.txt.gz":       {Data: gz(contentA)},
+		"dir/b.txt.zst":  {Data: zst(contentB)},
+		"dir/c.html.s2":  {Data: s2s(contentC)},
+		"plain.txt":      {Data: []byte("plain")},
+		"both.txt":       {Data: []byte("uncompressed")},
+		"both.txt.gz":    {Data: gz([]byte("compressed"))},
+		"dir/empty.json": {Data: nil},
+	}
+}
+
+func TestFS(t *testing.T) {
+	fsys := New(testFS())
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/c.html", "plain.txt", "both.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]byte{
+		"a.txt":      contentA,
+		"dir/b.txt":  contentB,
+		"dir/c.html": contentC,
+		"both.txt":   []byte("uncompressed"),
+	} {
+		got, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: content mismatch", name)
+		}
+		st, err := fs.Stat(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size() != int64(len(want)) {
+			t.Errorf("%s: want size %d, got %d", name, len(want), st.Size())
+		}
+	}
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if got := strings.Join(names, ","); got != "a.txt,both.txt,dir,plain.txt" {
+		t.Errorf("unexpected entries: %s", got)
+	}
+	if _, err := fsys.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	f, err := New(testFS()).Open("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rs := f.(io.ReadSeeker)
+	for _, pos := range []int64{100, 50, 5000, 0} {
+		if _, err := rs.Seek(pos, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		var b [10]byte
+		if _, err := io.ReadFull(rs, b[:]); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b[:], contentA[pos:pos+10]) {
+			t.Errorf("pos %d: content mismatch", pos)
+		}
+	}
+	n, err := rs.Seek(-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(contentA)-10) {
+		t.Errorf("want position %d, got %d", len(contentA)-10, n)
+	}
+	rest, err := ioutil.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, contentA[len(contentA)-10:]) {
+		t.Error("content mismatch at end")
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.FS(New(testFS()))))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/dir/c.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, contentC) {
+		t.Error("content mismatch")
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/a.txt", nil)
+	req.Header.Set("Range", "bytes=6-10")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "gzip " {
+		t.Errorf("unexpected range response %d: %q", resp.StatusCode, body)
+	}
+}
+
+func TestWithDecoder(t *testing.T) {
+	m := fstest.MapFS{"a.txt.gzip": {Data: gz(contentA)}, "b.txt.gz": {Data: gz(contentB)}}
+	fsys := New(m, WithoutDefaults(), WithDecoder(".gzip", newGzip))
+	got, err := fs.ReadFile(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, contentA) {
+		t.Error("content mismatch")
+	}
+	if _, err := fs.ReadFile(fsys, "b.txt"); err == nil {
+		t.Error("default decoder should be removed")
+	}
+}
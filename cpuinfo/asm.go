@@ -0,0 +1,128 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package cpuinfo
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// EnvNoAsm is the environment variable read at startup
+// with packages that should not use assembly.
+const EnvNoAsm = "COMPRESS_NOASM"
+
+// Asm identifies a package with assembly implementations.
+type Asm uint32
+
+// Packages with assembly implementations.
+const (
+	// AsmS2 is block encoding and decoding in the s2 package.
+	AsmS2 Asm = 1 << iota
+	// AsmZstd is match extension in the zstd encoder.
+	AsmZstd
+	// AsmXXHash is the xxhash package,
+	// which is also used for checksums by the zstd and seekable packages.
+	AsmXXHash
+
+	lastAsm = AsmXXHash
+)
+
+var asmNames = map[Asm]string{
+	AsmS2:     "s2",
+	AsmZstd:   "zstd",
+	AsmXXHash: "xxhash",
+}
+
+// asmDisabled is updated atomically.
+var asmDisabled uint32
+
+// String returns the name of the package.
+func (a Asm) String() string {
+	if s, ok := asmNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Asm(%d)", uint32(a))
+}
+
+// ParseAsm returns the package with the supplied name.
+// Names are case insensitive.
+func ParseAsm(name string) (Asm, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for a, s := range asmNames {
+		if s == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("cpuinfo: unknown assembly package %q", name)
+}
+
+// AllAsm returns all packages with assembly implementations.
+func AllAsm() []Asm {
+	var res []Asm
+	for a := Asm(1); a <= lastAsm; a <<= 1 {
+		res = append(res, a)
+	}
+	return res
+}
+
+// Enabled returns whether the package may use assembly.
+// It does not report whether assembly is available for the platform;
+// builds with the noasm tag never use assembly.
+func (a Asm) Enabled() bool {
+	return atomic.LoadUint32(&asmDisabled)&uint32(a) == 0
+}
+
+// DisableAsm makes the packages use their pure Go implementations.
+// Calls made after this returns will not use assembly.
+// Output of the Go and assembly implementations can differ,
+// but both are valid and can be decoded by either.
+func DisableAsm(a ...Asm) {
+	for _, v := range a {
+		for {
+			old := atomic.LoadUint32(&asmDisabled)
+			if atomic.CompareAndSwapUint32(&asmDisabled, old, old|uint32(v)) {
+				break
+			}
+		}
+	}
+}
+
+// EnableAsm allows the packages to use assembly again.
+func EnableAsm(a ...Asm) {
+	for _, v := range a {
+		for {
+			old := atomic.LoadUint32(&asmDisabled)
+			if atomic.CompareAndSwapUint32(&asmDisabled, old, old&^uint32(v)) {
+				break
+			}
+		}
+	}
+}
+
+func disableAsmFromEnv(v string) error {
+	if v == "" {
+		return nil
+	}
+	var errs []string
+	for _, name := range strings.Split(v, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "all") {
+			DisableAsm(AllAsm()...)
+			continue
+		}
+		a, err := ParseAsm(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		DisableAsm(a)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", EnvNoAsm, strings.Join(errs, ", "))
+	}
+	return nil
+}
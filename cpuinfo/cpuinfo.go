@@ -0,0 +1,197 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package cpuinfo reports the CPU features relevant to assembly in this module
+// and allows them to be disabled.
+//
+// AVX2 and AVX-512 select the match extension of the zstd encoder.
+// BMI2 on amd64 and NEON and SVE on arm64 are reported as well,
+// and code checking them with Has will see them as disabled.
+//
+// Features can be disabled by calling Disable before compressors are created,
+// or for all programs by setting the COMPRESS_CPU_DISABLE environment
+// variable to a comma separated list of feature names, for example
+// "avx2,bmi2". The value "all" disables all features.
+//
+// Disabling features is intended for triaging suspected problems with
+// specific instruction paths. Code will fall back to implementations
+// that do not require the feature.
+//
+// Assembly can also be disabled per package with DisableAsm,
+// or with the COMPRESS_NOASM environment variable, for example "s2,zstd,xxhash" or "all".
+// This switches to the pure Go implementations at runtime, which allows
+// comparing output without rebuilding with the noasm tag.
+package cpuinfo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// EnvDisable is the environment variable read at startup
+// with features to disable.
+const EnvDisable = "COMPRESS_CPU_DISABLE"
+
+// Feature is a CPU feature.
+type Feature uint32
+
+// Features that are detected.
+const (
+	// AVX2 is x86 Advanced Vector Extensions 2 with OS support.
+	AVX2 Feature = 1 << iota
+	// AVX512F is the x86 AVX-512 Foundation with OS support.
+	AVX512F
+	// AVX512BW is the x86 AVX-512 Byte and Word Instructions.
+	AVX512BW
+	// BMI2 is the x86 Bit Manipulation Instruction Set 2.
+	BMI2
+	// NEON is ARM Advanced SIMD.
+	NEON
+	// SVE is the ARM Scalable Vector Extension.
+	SVE
+
+	lastFeature = SVE
+)
+
+var featureNames = map[Feature]string{
+	AVX2:     "avx2",
+	AVX512F:  "avx512f",
+	AVX512BW: "avx512bw",
+	BMI2:     "bmi2",
+	NEON:     "neon",
+	SVE:      "sve",
+}
+
+// String returns the lowercase name of the feature.
+func (f Feature) String() string {
+	if s, ok := featureNames[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Feature(%d)", uint32(f))
+}
+
+// ParseFeature returns the feature with the supplied name.
+// Names are case insensitive.
+func ParseFeature(name string) (Feature, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for f, s := range featureNames {
+		if s == name {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("cpuinfo: unknown feature %q", name)
+}
+
+var (
+	// detected is set once at startup.
+	detected uint32
+
+	// disabled is updated atomically.
+	disabled uint32
+)
+
+func init() {
+	detected = uint32(detect())
+	if err := disableFromEnv(os.Getenv(EnvDisable)); err != nil {
+		fmt.Fprintln(os.Stderr, "compress:", err)
+	}
+	if err := disableAsmFromEnv(os.Getenv(EnvNoAsm)); err != nil {
+		fmt.Fprintln(os.Stderr, "compress:", err)
+	}
+}
+
+func disableFromEnv(v string) error {
+	if v == "" {
+		return nil
+	}
+	var errs []string
+	for _, name := range strings.Split(v, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(name), "all") {
+			Disable(All()...)
+			continue
+		}
+		f, err := ParseFeature(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		Disable(f)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", EnvDisable, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// All returns all features known by the package.
+func All() []Feature {
+	var res []Feature
+	for f := Feature(1); f <= lastFeature; f <<= 1 {
+		res = append(res, f)
+	}
+	return res
+}
+
+// Has returns whether the feature is supported by the CPU and not disabled.
+func Has(f Feature) bool {
+	return detected&uint32(f) == uint32(f) && atomic.LoadUint32(&disabled)&uint32(f) == 0
+}
+
+// Detected returns whether the feature is supported by the CPU,
+// regardless of whether it has been disabled.
+func Detected(f Feature) bool {
+	return detected&uint32(f) == uint32(f)
+}
+
+// Disable the features.
+// Code created after this call will not use the features.
+func Disable(f ...Feature) {
+	for _, v := range f {
+		for {
+			old := atomic.LoadUint32(&disabled)
+			if atomic.CompareAndSwapUint32(&disabled, old, old|uint32(v)) {
+				break
+			}
+		}
+	}
+}
+
+// Enable features that have previously been disabled.
+// Features not supported by the CPU cannot be enabled.
+func Enable(f ...Feature) {
+	for _, v := range f {
+		for {
+			old := atomic.LoadUint32(&disabled)
+			if atomic.CompareAndSwapUint32(&disabled, old, old&^uint32(v)) {
+				break
+			}
+		}
+	}
+}
+
+// Disabled returns the features that have been disabled and are supported by the CPU.
+func Disabled() []Feature {
+	var res []Feature
+	for _, f := range All() {
+		if Detected(f) && !Has(f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// Available returns all features that are supported and not disabled.
+func Available() []Feature {
+	var res []Feature
+	for _, f := range All() {
+		if Has(f) {
+			res = append(res, f)
+		}
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !appengine
+// +build !noasm
+// +build gc
+
+package cpuinfo
+
+// cpuid is implemented in cpuinfo_amd64.s.
+func cpuid(op, op2 uint32) (eax, ebx, ecx, edx uint32)
+
+// xgetbv is implemented in cpuinfo_amd64.s.
+func xgetbv() (eax, edx uint32)
+
+func detect() Feature {
+	var f Feature
+	maxID, _, _, _ := cpuid(0, 0)
+	if maxID < 1 {
+		return 0
+	}
+	_, _, ecx1, _ := cpuid(1, 0)
+	var osAVX, osAVX512 bool
+	if ecx1&(1<<27) != 0 {
+		// OSXSAVE. Check that the OS saves the registers.
+		eax, _ := xgetbv()
+		osAVX = eax&0x6 == 0x6
+		osAVX512 = osAVX && eax&0xe0 == 0xe0
+	}
+	if maxID < 7 {
+		return f
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	if ebx7&(1<<8) != 0 {
+		f |= BMI2
+	}
+	if osAVX && ebx7&(1<<5) != 0 {
+		f |= AVX2
+	}
+	if osAVX512 && ebx7&(1<<16) != 0 {
+		f |= AVX512F
+		if ebx7&(1<<30) != 0 {
+			f |= AVX512BW
+		}
+	}
+	return f
+}
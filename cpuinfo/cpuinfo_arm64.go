@@ -0,0 +1,40 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !appengine
+// +build !noasm
+// +build gc
+
+package cpuinfo
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"runtime"
+)
+
+const (
+	atHWCAP   = 16
+	hwcapSVE  = 1 << 22
+	auxvEntry = 16
+)
+
+func detect() Feature {
+	// Advanced SIMD is required on arm64.
+	f := NEON
+	if runtime.GOOS != "linux" {
+		return f
+	}
+	auxv, err := ioutil.ReadFile("/proc/self/auxv")
+	if err != nil {
+		return f
+	}
+	for ; len(auxv) >= auxvEntry; auxv = auxv[auxvEntry:] {
+		tag := binary.LittleEndian.Uint64(auxv)
+		val := binary.LittleEndian.Uint64(auxv[8:])
+		if tag == atHWCAP && val&hwcapSVE != 0 {
+			f |= SVE
+		}
+	}
+	return f
+}
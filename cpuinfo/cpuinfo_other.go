@@ -0,0 +1,10 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !amd64,!arm64 appengine !gc noasm
+
+package cpuinfo
+
+func detect() Feature {
+	return 0
+}
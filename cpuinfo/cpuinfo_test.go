@@ -0,0 +1,98 @@
+package cpuinfo
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	t.Log("available:", Available())
+	if Detected(AVX512BW) && !Detected(AVX512F) {
+		t.Error("AVX512BW without AVX512F")
+	}
+	if runtime.GOARCH == "arm64" && !Detected(NEON) {
+		t.Error("NEON not detected on arm64")
+	}
+	if runtime.GOARCH != "arm64" && (Detected(NEON) || Detected(SVE)) {
+		t.Error("ARM features detected on", runtime.GOARCH)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	defer Enable(All()...)
+	for _, f := range Available() {
+		Disable(f)
+		if Has(f) {
+			t.Errorf("%v not disabled", f)
+		}
+		if !Detected(f) {
+			t.Errorf("%v no longer detected", f)
+		}
+		Enable(f)
+		if !Has(f) {
+			t.Errorf("%v not enabled", f)
+		}
+	}
+	// Features that aren't detected cannot be enabled.
+	for _, f := range All() {
+		if !Detected(f) {
+			Enable(f)
+			if Has(f) {
+				t.Errorf("%v enabled without support", f)
+			}
+		}
+	}
+}
+
+func TestEnv(t *testing.T) {
+	defer Enable(All()...)
+	if err := disableFromEnv("AVX2, bmi2,neon"); err != nil {
+		t.Fatal(err)
+	}
+	if Has(AVX2) || Has(BMI2) || Has(NEON) {
+		t.Error("features not disabled")
+	}
+	if err := disableFromEnv("all"); err != nil {
+		t.Fatal(err)
+	}
+	if len(Available()) != 0 {
+		t.Errorf("features still available: %v", Available())
+	}
+	if err := disableFromEnv("avx3"); err == nil {
+		t.Error("expected error on unknown feature")
+	}
+	for _, f := range All() {
+		got, err := ParseFeature(f.String())
+		if err != nil || got != f {
+			t.Errorf("%v: roundtrip failed: %v, %v", f, got, err)
+		}
+	}
+}
+
+func TestAsm(t *testing.T) {
+	defer EnableAsm(AllAsm()...)
+	if !AsmS2.Enabled() {
+		t.Fatal("s2 assembly disabled by default")
+	}
+	if err := disableAsmFromEnv("S2"); err != nil {
+		t.Fatal(err)
+	}
+	if AsmS2.Enabled() || !AsmZstd.Enabled() || !AsmXXHash.Enabled() {
+		t.Error("unexpected state after disabling s2")
+	}
+	if err := disableAsmFromEnv("all"); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range AllAsm() {
+		if a.Enabled() {
+			t.Errorf("%v still enabled", a)
+		}
+	}
+	EnableAsm(AsmZstd)
+	if !AsmZstd.Enabled() {
+		t.Error("zstd not enabled")
+	}
+	if err := disableAsmFromEnv("flate"); err == nil {
+		t.Error("expected error on unknown package")
+	}
+}
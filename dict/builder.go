@@ -0,0 +1,222 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package dict
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is the output format of a built dictionary.
+type Format int
+
+const (
+	// FormatS2 builds a dictionary for S2 block compression.
+	// The output is the uvarint encoded initial repeat offset followed by
+	// up to 64KB of content.
+	// The best content is placed within the last 2KB, where S2 can
+	// reference it with the shortest copy encoding.
+	FormatS2 Format = iota + 1
+
+	// FormatRaw builds a raw content dictionary of up to 32KB.
+	// There are no headers or entropy tables, so it can be used as a preset
+	// dictionary for deflate, zlib and gzip and as a raw content dictionary.
+	// Content is ordered by how often it is found in samples, with the most
+	// frequent content placed last, where it can be reached with the shortest offsets.
+	FormatRaw
+
+	// FormatZstd builds a dictionary in the zstd dictionary format.
+	// Entropy tables are generated from compressing the samples with the
+	// selected content, and the output can be used with zstd.WithEncoderDict,
+	// zstd.WithDecoderDicts and the zstd commandline tool.
+	FormatZstd
+)
+
+const (
+	// S2MaxDictSize is the maximum content size of an S2 dictionary.
+	S2MaxDictSize = 64 << 10
+
+	// S2MinDictSize is the minimum content size of an S2 dictionary.
+	S2MinDictSize = 16
+
+	// RawMaxDictSize is the maximum size of a raw dictionary.
+	// This is the deflate window size, so content beyond this would not be used.
+	RawMaxDictSize = 32 << 10
+
+	// ZstdMaxDictSize is the maximum content size of a zstd dictionary.
+	ZstdMaxDictSize = 1 << 20
+
+	// ZstdDefaultDictSize is the content size of zstd dictionaries
+	// when no size is specified. This matches the zstd commandline tool.
+	ZstdDefaultDictSize = 110 << 10
+)
+
+const (
+	// DefaultSegmentSize is the default size of content segments.
+	DefaultSegmentSize = 1024
+
+	// DefaultHashBytes is the default number of bytes used for matching content.
+	DefaultHashBytes = 6
+
+	// MaxSegmentSize is the maximum supported segment size.
+	MaxSegmentSize = 64 << 10
+)
+
+// Options for building dictionaries.
+// Use Tune to find the best SegmentSize and HashBytes for a set of samples.
+type Options struct {
+	// Format of the dictionary.
+	Format Format
+
+	// MaxSize is the maximum size of the dictionary content.
+	// If 0 the maximum size allowed by the format is used,
+	// except for zstd where ZstdDefaultDictSize is used.
+	MaxSize int
+
+	// SegmentSize is the size of the segments selected for the dictionary,
+	// often referred to as 'k'.
+	// If 0, DefaultSegmentSize is used.
+	SegmentSize int
+
+	// HashBytes is the number of bytes that must match for content
+	// to be considered repeated, often referred to as 'd'.
+	// Must be between 4 and 8. If 0, DefaultHashBytes is used.
+	HashBytes int
+
+	// ID is the dictionary ID used for zstd dictionaries.
+	// If 0, an ID is derived from the dictionary content.
+	ID uint32
+}
+
+// maxSize returns the maximum content size of the format.
+func (f Format) maxSize() int {
+	switch f {
+	case FormatS2:
+		return S2MaxDictSize
+	case FormatRaw:
+		return RawMaxDictSize
+	case FormatZstd:
+		return ZstdMaxDictSize
+	}
+	return 0
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatS2:
+		return "s2"
+	case FormatRaw:
+		return "raw"
+	case FormatZstd:
+		return "zstd"
+	}
+	return "invalid"
+}
+
+func (o *Options) validate() error {
+	max := o.Format.maxSize()
+	if max == 0 {
+		return fmt.Errorf("unknown format: %d", o.Format)
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = max
+		if o.Format == FormatZstd {
+			o.MaxSize = ZstdDefaultDictSize
+		}
+	}
+	if o.MaxSize < 0 || o.MaxSize > max {
+		return fmt.Errorf("max size %d out of range for %v format. Must be 1 -> %d", o.MaxSize, o.Format, max)
+	}
+	if o.SegmentSize == 0 {
+		o.SegmentSize = DefaultSegmentSize
+	}
+	if o.HashBytes == 0 {
+		o.HashBytes = DefaultHashBytes
+	}
+	if o.HashBytes < 4 || o.HashBytes > 8 {
+		return fmt.Errorf("hash bytes must be between 4 and 8, got %d", o.HashBytes)
+	}
+	if o.SegmentSize < o.HashBytes || o.SegmentSize > MaxSegmentSize {
+		return fmt.Errorf("segment size must be between %d and %d, got %d", o.HashBytes, MaxSegmentSize, o.SegmentSize)
+	}
+	return nil
+}
+
+// Build a dictionary from the supplied samples.
+// Samples should be representative of the content that will be compressed.
+// Content that is repeated across many samples will be preferred.
+func Build(samples [][]byte, o Options) ([]byte, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no samples provided")
+	}
+	segs := newCover(samples, o.SegmentSize, o.HashBytes).selectSegments(o.MaxSize)
+	switch o.Format {
+	case FormatS2:
+		return s2Dict(segs)
+	case FormatRaw:
+		content := concatSegments(segs)
+		if len(content) == 0 {
+			return nil, errors.New("no repeated content found in samples")
+		}
+		return content, nil
+	case FormatZstd:
+		return zstdDict(segs, samples, o.ID)
+	}
+	return nil, fmt.Errorf("unknown format: %d", o.Format)
+}
+
+// zstdDict returns the segments in zstd dictionary format.
+// Zstd reserves IDs below 32768 and above 1<<31, so derived IDs
+// are kept within the range that is free to use.
+func zstdDict(segs []segment, samples [][]byte, id uint32) ([]byte, error) {
+	content := concatSegments(segs)
+	if len(content) < 8 {
+		return nil, fmt.Errorf("not enough repeated content in samples to create dictionary. Got %d bytes, need at least 8", len(content))
+	}
+	if id == 0 {
+		const minID, maxID = 32768, 1 << 31
+		id = minID + crc32.ChecksumIEEE(content)%(maxID-minID)
+	}
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: samples,
+		History:  content,
+	})
+}
+
+// s2Dict returns the segments in S2 dictionary format.
+// The repeat offset is set to the start of the best segment.
+func s2Dict(segs []segment) ([]byte, error) {
+	content := concatSegments(segs)
+	if len(content) < S2MinDictSize {
+		return nil, fmt.Errorf("not enough repeated content in samples to create dictionary. Got %d bytes, need at least %d", len(content), S2MinDictSize)
+	}
+	var repeat int
+	if len(segs) > 0 {
+		repeat = len(content) - len(segs[len(segs)-1].b)
+	}
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], uint64(repeat))
+	return append(tmp[:n:n], content...), nil
+}
+
+func concatSegments(segs []segment) []byte {
+	var n int
+	for _, s := range segs {
+		n += len(s.b)
+	}
+	dst := make([]byte, 0, n)
+	for _, s := range segs {
+		dst = append(dst, s.b...)
+	}
+	return dst
+}
@@ -0,0 +1,252 @@
+package dict
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// loadJSONSamples returns small JSON samples with a few records each.
+func loadJSONSamples(t testing.TB) [][]byte {
+	data, err := ioutil.ReadFile("../gzhttp/testdata/benchmark.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := bytes.SplitAfter(data, []byte("},"))
+	var samples [][]byte
+	for len(records) > 0 {
+		n := 4
+		if n > len(records) {
+			n = len(records)
+		}
+		samples = append(samples, bytes.Join(records[:n], nil))
+		records = records[n:]
+	}
+	return samples
+}
+
+func TestBuildS2(t *testing.T) {
+	train, test := splitSamples(loadJSONSamples(t), 0.5)
+	d, err := Build(train, Options{Format: FormatS2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repeat, n := binary.Uvarint(d)
+	if n <= 0 {
+		t.Fatal("invalid repeat offset")
+	}
+	content := d[n:]
+	t.Log("dictionary size:", len(content), "repeat:", repeat)
+	if len(content) > S2MaxDictSize || len(content) < S2MinDictSize {
+		t.Fatalf("content size out of range: %d", len(content))
+	}
+	if repeat >= uint64(len(content)) {
+		t.Fatalf("repeat offset %d outside content (%d bytes)", repeat, len(content))
+	}
+
+	// S2 cannot use the dictionary yet, so check the content with deflate.
+	ev, err := Evaluate(test, content, EvalOptions{Codecs: []Codec{CodecFlate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ev.Results[0]
+	t.Log(r)
+	if r.WithDict >= r.Baseline {
+		t.Errorf("dictionary did not improve compression: %d >= %d", r.WithDict, r.Baseline)
+	}
+}
+
+func TestBuildMaxSize(t *testing.T) {
+	d, err := Build(loadJSONSamples(t), Options{Format: FormatS2, MaxSize: 4000, SegmentSize: 256, HashBytes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, n := binary.Uvarint(d)
+	if got := len(d) - n; got > 4000 {
+		t.Errorf("content size %d exceeds max size", got)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	samples := [][]byte{[]byte("hello world"), []byte("hello world")}
+	for name, o := range map[string]Options{
+		"no-format":   {},
+		"max-size":    {Format: FormatS2, MaxSize: S2MaxDictSize + 1},
+		"hash-small":  {Format: FormatS2, HashBytes: 3},
+		"hash-big":    {Format: FormatS2, HashBytes: 9},
+		"segment":     {Format: FormatS2, SegmentSize: 4, HashBytes: 6},
+		"segment-big": {Format: FormatS2, SegmentSize: MaxSegmentSize + 1},
+	} {
+		if _, err := Build(samples, o); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := Build(nil, Options{Format: FormatS2}); err == nil {
+		t.Error("expected error on no samples")
+	}
+	if _, err := Build([][]byte{{}, {}}, Options{Format: FormatS2}); err == nil {
+		t.Error("expected error on empty samples")
+	}
+	// Nothing repeated.
+	if _, err := Build([][]byte{[]byte("abcdefgh"), []byte("ijklmnop")}, Options{Format: FormatS2}); err == nil {
+		t.Error("expected error on no repeated content")
+	}
+}
+
+func TestTune(t *testing.T) {
+	all := loadJSONSamples(t)
+	o, res, err := Tune(all, Options{Format: FormatS2, MaxSize: 16 << 10}, TuneOptions{Steps: 4, MinSegmentSize: 100, MaxSegmentSize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 hash sizes, 5 segment sizes.
+	if len(res) != 10 {
+		t.Errorf("want 10 results, got %d", len(res))
+	}
+	for i := 1; i < len(res); i++ {
+		if res[i].Size < res[i-1].Size {
+			t.Fatalf("results not sorted: %+v", res)
+		}
+	}
+	if o.SegmentSize != res[0].SegmentSize || o.HashBytes != res[0].HashBytes {
+		t.Errorf("options not updated: %+v, best: %+v", o, res[0])
+	}
+	t.Logf("best: %+v, worst: %+v", res[0], res[len(res)-1])
+	if _, err := Build(all, o); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTuneErrors(t *testing.T) {
+	samples := [][]byte{[]byte("hello world"), []byte("hello world")}
+	for name, to := range map[string]TuneOptions{
+		"hash":   {HashBytes: []int{3}},
+		"range":  {MinSegmentSize: 1000, MaxSegmentSize: 100},
+		"split":  {SplitPoint: 2},
+		"steps":  {Steps: -1},
+		"conc":   {Concurrency: -1},
+		"k-lt-d": {HashBytes: []int{8}, MinSegmentSize: 4, MaxSegmentSize: 7},
+	} {
+		if _, _, err := Tune(samples, Options{Format: FormatS2}, to); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, _, err := Tune(samples[:1], Options{Format: FormatS2}, TuneOptions{}); err == nil {
+		t.Error("expected error on too few samples")
+	}
+}
+
+func TestBuildRaw(t *testing.T) {
+	train, test := splitSamples(loadJSONSamples(t), 0.5)
+	d, err := Build(train, Options{Format: FormatRaw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("dictionary size:", len(d))
+	if len(d) > RawMaxDictSize {
+		t.Fatalf("dictionary too big: %d", len(d))
+	}
+	ev, err := Evaluate(test, d, EvalOptions{Codecs: []Codec{CodecFlate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ev.Results[0]
+	t.Log(r)
+	if r.WithDict >= r.Baseline {
+		t.Errorf("dictionary did not improve compression: %d >= %d", r.WithDict, r.Baseline)
+	}
+	if _, err := Build(train, Options{Format: FormatRaw, MaxSize: RawMaxDictSize + 1}); err == nil {
+		t.Error("expected error on too big max size")
+	}
+}
+
+func TestShrink(t *testing.T) {
+	train, test := splitSamples(loadJSONSamples(t), 0.5)
+	for _, format := range []Format{FormatRaw, FormatS2} {
+		t.Run(format.String(), func(t *testing.T) {
+			d, err := Build(train, Options{Format: format, MaxSize: 16 << 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			const target = 4 << 10
+			small, err := Shrink(d, train, ShrinkOptions{Format: format, TargetSize: target})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(small) > target {
+				t.Fatalf("dictionary size %d exceeds target", len(small))
+			}
+			content := small
+			if format == FormatS2 {
+				repeat, n := binary.Uvarint(small)
+				if n <= 0 {
+					t.Fatal("invalid repeat offset")
+				}
+				content = small[n:]
+				if repeat >= uint64(len(content)) {
+					t.Fatalf("repeat offset %d outside content (%d bytes)", repeat, len(content))
+				}
+			}
+			// Compare to keeping the start of the dictionary.
+			head := d
+			if format == FormatS2 {
+				_, n := binary.Uvarint(d)
+				head = d[n:]
+			}
+			head = head[:len(content)]
+			evShrunk, err := Evaluate(test, content, EvalOptions{Codecs: []Codec{CodecFlate}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			evHead, err := Evaluate(test, head, EvalOptions{Codecs: []Codec{CodecFlate}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log("shrunk:", evShrunk.Results[0])
+			t.Log("head:", evHead.Results[0])
+			if evShrunk.Results[0].WithDict >= evHead.Results[0].WithDict {
+				t.Error("shrunk dictionary was not better than truncated")
+			}
+
+			// Already small enough.
+			same, err := Shrink(small, train, ShrinkOptions{Format: format, TargetSize: target})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(same, small) {
+				t.Error("dictionary changed")
+			}
+		})
+	}
+	if _, err := Shrink(make([]byte, 100), train, ShrinkOptions{Format: FormatRaw}); err == nil {
+		t.Error("expected error on zero target")
+	}
+	if _, err := Shrink(make([]byte, 100), train, ShrinkOptions{TargetSize: 10}); err == nil {
+		t.Error("expected error on no format")
+	}
+}
+
+func TestBuildZstd(t *testing.T) {
+	train, test := splitSamples(loadJSONSamples(t), 0.5)
+	d, err := Build(train, Options{Format: FormatZstd, MaxSize: 16 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("dictionary size:", len(d))
+	ev, err := Evaluate(test, d, EvalOptions{Codecs: []Codec{CodecZstd}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ev.Results[0]
+	t.Log(r)
+	if !r.DictUsed {
+		t.Fatal("dictionary not used")
+	}
+	if r.WithDict >= r.Baseline {
+		t.Errorf("dictionary did not improve compression: %d >= %d", r.WithDict, r.Baseline)
+	}
+	if _, err := Shrink(d, train, ShrinkOptions{Format: FormatZstd, TargetSize: 4 << 10}); err == nil {
+		t.Error("expected error on shrinking zstd dictionary")
+	}
+}
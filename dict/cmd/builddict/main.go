@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/klauspost/compress/dict"
+)
+
+var (
+	format    = flag.String("format", "zstd", "Dictionary format. Can be 'zstd', 'raw' or 's2'")
+	size      = flag.String("size", "", "Maximum dictionary content size. Examples: 16K, 64K, 110K. Default depends on format")
+	out       = flag.String("o", "dictionary", "Output file name")
+	id        = flag.Uint("id", 0, "Dictionary ID for zstd dictionaries. If 0, an ID is derived from the content")
+	segment   = flag.Int("k", 0, "Segment size. If 0 the default is used")
+	hashBytes = flag.Int("d", 0, "Number of bytes that must match to be considered repeated. 4 to 8. If 0 the default is used")
+	tune      = flag.Bool("tune", false, "Search for the best segment size and hash length before building")
+	testSplit = flag.Float64("test", 0.1, "Fraction of samples held out for evaluation. 0 will evaluate on training samples")
+	maxSample = flag.String("maxsample", "1M", "Maximum size of each sample. Bigger files are truncated")
+	cpu       = flag.Int("cpu", runtime.GOMAXPROCS(0), "Read and tune using this amount of threads")
+	recursive = flag.Bool("r", false, "Read directories recursively")
+	quiet     = flag.Bool("q", false, "Don't write any output to terminal, except errors")
+	help      = flag.Bool("help", false, "Display help")
+
+	version = "(dev)"
+	date    = "(unknown)"
+)
+
+var errNoFiles = errors.New("no sample files found")
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 || *help {
+		_, _ = fmt.Fprintf(os.Stderr, "dictionary builder v%v, built at %v.\n\n", version, date)
+		_, _ = fmt.Fprintf(os.Stderr, "Copyright (c) 2021 Klaus Post. All rights reserved.\n\n")
+		_, _ = fmt.Fprintln(os.Stderr, `Usage: builddict [options] dir1 file1 ...
+
+Builds a dictionary from sample files.
+Each file is used as a single sample.
+Directories will use all files in the directory.
+
+Wildcards are accepted: testdir/*.json will use all files in testdir ending with .json
+Directories can be wildcards as well. testdir/*/*.json will match testdir/subdir/b.json
+
+A fraction of the samples are held out and used for evaluating the dictionary.
+
+Options:`)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	o := dict.Options{SegmentSize: *segment, HashBytes: *hashBytes, ID: uint32(*id)}
+	switch strings.ToLower(*format) {
+	case "zstd":
+		o.Format = dict.FormatZstd
+	case "raw":
+		o.Format = dict.FormatRaw
+	case "s2":
+		o.Format = dict.FormatS2
+	default:
+		exitErr(fmt.Errorf("unknown format: %q", *format))
+	}
+	if *size != "" {
+		sz, err := toSize(*size)
+		exitErr(err)
+		o.MaxSize = int(sz)
+	}
+	maxSz, err := toSize(*maxSample)
+	exitErr(err)
+	if *testSplit < 0 || *testSplit >= 1 {
+		exitErr(fmt.Errorf("test fraction must be >= 0 and < 1, got %v", *testSplit))
+	}
+
+	files, err := findFiles(args)
+	exitErr(err)
+	start := time.Now()
+	samples, total, err := readFiles(files, int64(maxSz), *cpu)
+	exitErr(err)
+	if !*quiet {
+		fmt.Printf("Read %d samples, %d bytes in %v.\n", len(samples), total, time.Since(start).Round(time.Millisecond))
+	}
+	train, test := split(samples, *testSplit)
+
+	if *tune {
+		start := time.Now()
+		var res []dict.TuneResult
+		o, res, err = dict.Tune(train, o, dict.TuneOptions{Concurrency: *cpu})
+		exitErr(err)
+		if !*quiet {
+			fmt.Printf("Tuned %d parameter sets in %v. Best segment size: %d, hash bytes: %d.\n", len(res), time.Since(start).Round(time.Millisecond), o.SegmentSize, o.HashBytes)
+		}
+	}
+
+	start = time.Now()
+	d, err := dict.Build(train, o)
+	exitErr(err)
+	exitErr(ioutil.WriteFile(*out, d, 0666))
+	if *quiet {
+		return
+	}
+	fmt.Printf("Built %v dictionary of %d bytes in %v. Written to %s.\n", o.Format, len(d), time.Since(start).Round(time.Millisecond), *out)
+
+	// Raw and S2 dictionaries are evaluated using the content as a deflate dictionary.
+	content := d
+	codecs := []dict.Codec{dict.CodecFlate}
+	switch o.Format {
+	case dict.FormatZstd:
+		codecs = []dict.Codec{dict.CodecZstd}
+	case dict.FormatS2:
+		_, n := binary.Uvarint(d)
+		content = d[n:]
+	}
+	ev, err := dict.Evaluate(test, content, dict.EvalOptions{Codecs: codecs})
+	exitErr(err)
+	fmt.Printf("\nEvaluated on %d samples:\n", ev.Samples)
+	for _, r := range ev.Results {
+		fmt.Println(r)
+	}
+}
+
+// findFiles returns all files matching the supplied patterns.
+// Directories are expanded to the files they contain.
+func findFiles(patterns []string) ([]string, error) {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("unable to find file %v", pattern)
+		}
+		for _, name := range found {
+			st, err := os.Stat(name)
+			if err != nil {
+				return nil, err
+			}
+			if !st.IsDir() {
+				files = append(files, name)
+				continue
+			}
+			err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					if path != name && !*recursive {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, errNoFiles
+	}
+	return files, nil
+}
+
+// readFiles reads the files concurrently.
+// Samples are returned in the same order as the files.
+// Empty files are skipped.
+func readFiles(files []string, maxSize int64, concurrency int) (samples [][]byte, total int64, err error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	res := make([][]byte, len(files))
+	errs := make([]error, len(files))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				f, err := os.Open(files[i])
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				res[i], errs[i] = ioutil.ReadAll(io.LimitReader(f, maxSize))
+				f.Close()
+			}
+		}()
+	}
+	for i := range files {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+	for i, b := range res {
+		if errs[i] != nil {
+			return nil, 0, fmt.Errorf("reading %s: %w", files[i], errs[i])
+		}
+		if len(b) > 0 {
+			samples = append(samples, b)
+			total += int64(len(b))
+		}
+	}
+	if len(samples) == 0 {
+		return nil, 0, errNoFiles
+	}
+	return samples, total, nil
+}
+
+// split returns every n'th sample as test samples,
+// so the held out samples are spread over the input.
+func split(samples [][]byte, fraction float64) (train, test [][]byte) {
+	if fraction <= 0 || len(samples) < 2 {
+		return samples, samples
+	}
+	n := int(1 / fraction)
+	if n < 2 {
+		n = 2
+	}
+	for i, s := range samples {
+		if i%n == n-1 {
+			test = append(test, s)
+		} else {
+			train = append(train, s)
+		}
+	}
+	if len(test) == 0 {
+		test = train[len(train)-1:]
+		train = train[:len(train)-1]
+	}
+	return train, test
+}
+
+func exitErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
+		os.Exit(2)
+	}
+}
+
+// toSize converts a size indication to bytes.
+func toSize(size string) (uint64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	firstLetter := strings.IndexFunc(size, unicode.IsLetter)
+	if firstLetter == -1 {
+		firstLetter = len(size)
+	}
+
+	bytesString, multiple := size[:firstLetter], size[firstLetter:]
+	bytes, err := strconv.ParseUint(bytesString, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse size: %v", err)
+	}
+
+	switch multiple {
+	case "M", "MB", "MIB":
+		return bytes * 1 << 20, nil
+	case "K", "KB", "KIB":
+		return bytes * 1 << 10, nil
+	case "B", "":
+		return bytes, nil
+	default:
+		return 0, fmt.Errorf("unknown size suffix: %v", multiple)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package dict
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// coverHashLog is the number of bits used for d-mer frequency tables.
+const coverHashLog = 20
+
+const (
+	coverHashSize = 1 << coverHashLog
+	coverPrime    = 0xcf1bbcdcb7a56463
+)
+
+// segment is a piece of sample content selected for the dictionary.
+type segment struct {
+	b     []byte
+	score uint64
+}
+
+// cover selects dictionary content from samples.
+// It is a variation of the COVER algorithm described in
+// "Effective Construction of Relative Lempel-Ziv Dictionaries" by Liao et al.
+// Content is scored by how many samples contain each d-mer (d bytes of content),
+// and the best segments of k bytes are picked from epochs of the input.
+type cover struct {
+	samples [][]byte
+	k, d    int
+
+	// freq contains the number of samples containing each d-mer hash.
+	// D-mers found in less than 2 samples have a frequency of 0.
+	freq []uint32
+
+	// segFreq contains the number of times each d-mer is in the current window.
+	segFreq []uint16
+}
+
+func newCover(samples [][]byte, k, d int) *cover {
+	c := cover{
+		samples: samples,
+		k:       k,
+		d:       d,
+		freq:    make([]uint32, coverHashSize),
+		segFreq: make([]uint16, coverHashSize),
+	}
+	lastSeen := make([]uint32, coverHashSize)
+	for i, s := range samples {
+		id := uint32(i + 1)
+		for j := 0; j+d <= len(s); j++ {
+			h := c.hash(s, j)
+			if lastSeen[h] != id {
+				lastSeen[h] = id
+				c.freq[h]++
+			}
+		}
+	}
+	// Content only present in a single sample will not help compressing others.
+	for i, f := range c.freq {
+		if f < 2 {
+			c.freq[i] = 0
+		}
+	}
+	return &c
+}
+
+// hash returns the hash of the d-mer starting at b[i].
+func (c *cover) hash(b []byte, i int) uint32 {
+	var v uint64
+	if i+8 <= len(b) {
+		v = binary.LittleEndian.Uint64(b[i:])
+	} else {
+		for j, x := range b[i : i+c.d] {
+			v |= uint64(x) << (8 * uint(j))
+		}
+	}
+	v <<= 64 - 8*uint(c.d)
+	return uint32((v * coverPrime) >> (64 - coverHashLog))
+}
+
+// epoch is a part of the samples scanned for a single segment.
+// Samples may be split across epochs.
+type epoch [][]byte
+
+// epochs divides the samples into n parts of roughly the same size,
+// with each epoch being at least 10 segments.
+func (c *cover) epochs(n int) []epoch {
+	var total int
+	for _, s := range c.samples {
+		total += len(s)
+	}
+	if n < 1 {
+		n = 1
+	}
+	size := total / n
+	if size < c.k*10 {
+		size = c.k * 10
+	}
+	var res []epoch
+	var cur epoch
+	var n2 int
+	for _, s := range c.samples {
+		for len(s) > 0 {
+			b := s
+			if len(b) > size-n2 {
+				b = b[:size-n2]
+			}
+			s = s[len(b):]
+			cur = append(cur, b)
+			n2 += len(b)
+			if n2 >= size {
+				res = append(res, cur)
+				cur = nil
+				n2 = 0
+			}
+		}
+	}
+	if len(cur) > 0 {
+		res = append(res, cur)
+	}
+	return res
+}
+
+// best returns the best scoring segment within the epoch.
+// The score is the sum of the frequencies of distinct d-mers in the segment.
+func (c *cover) best(e epoch) segment {
+	var best segment
+	dmers := c.k - c.d + 1
+	for _, s := range e {
+		if len(s) < c.d {
+			continue
+		}
+		var score uint64
+		var start int
+		for i := 0; i+c.d <= len(s); i++ {
+			h := c.hash(s, i)
+			if c.segFreq[h] == 0 {
+				score += uint64(c.freq[h])
+			}
+			c.segFreq[h]++
+			if i-start >= dmers {
+				// Remove the leftmost d-mer.
+				h := c.hash(s, start)
+				c.segFreq[h]--
+				if c.segFreq[h] == 0 {
+					score -= uint64(c.freq[h])
+				}
+				start++
+			}
+			if score > best.score {
+				best = segment{b: s[start : i+c.d], score: score}
+			}
+		}
+		// Clear window.
+		for ; start+c.d <= len(s); start++ {
+			c.segFreq[c.hash(s, start)]--
+		}
+	}
+	if best.score == 0 {
+		return best
+	}
+
+	// Trim d-mers that do not contribute.
+	b := best.b
+	for len(b) > c.d && c.freq[c.hash(b, 0)] == 0 {
+		b = b[1:]
+	}
+	for len(b) > c.d && c.freq[c.hash(b, len(b)-c.d)] == 0 {
+		b = b[:len(b)-1]
+	}
+	best.b = b
+
+	// Zero out the d-mers we have covered, so they aren't selected again.
+	for i := 0; i+c.d <= len(b); i++ {
+		c.freq[c.hash(b, i)] = 0
+	}
+	return best
+}
+
+// selectSegments picks segments until maxSize is reached or no more useful
+// content can be found.
+// Segments are returned in ascending order of score, so when concatenated
+// the most valuable content is placed at the end of the dictionary,
+// which makes it reachable with the shortest offsets.
+func (c *cover) selectSegments(maxSize int) []segment {
+	eps := c.epochs(maxSize / c.k / 4)
+	if len(eps) == 0 {
+		return nil
+	}
+	var segs []segment
+	var total, failures int
+	for i := 0; total < maxSize && failures < len(eps); i++ {
+		seg := c.best(eps[i%len(eps)])
+		if seg.score == 0 {
+			failures++
+			continue
+		}
+		failures = 0
+		if total+len(seg.b) > maxSize {
+			seg.b = seg.b[:maxSize-total]
+			if len(seg.b) < c.d {
+				break
+			}
+		}
+		segs = append(segs, seg)
+		total += len(seg.b)
+	}
+	sort.SliceStable(segs, func(i, j int) bool {
+		return segs[i].score < segs[j].score
+	})
+	return segs
+}
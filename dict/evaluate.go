@@ -0,0 +1,340 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package dict
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec identifies a compression format that can be evaluated.
+type Codec string
+
+const (
+	// CodecZstd evaluates zstd compression.
+	// The dictionary must be in zstd dictionary format to be used.
+	CodecZstd Codec = "zstd"
+
+	// CodecFlate evaluates deflate compression with a preset dictionary.
+	// The last 32KB of the dictionary is used as preset.
+	CodecFlate Codec = "flate"
+
+	// CodecS2 evaluates S2 block compression.
+	// S2 has no dictionary support, so only the baseline is measured.
+	CodecS2 Codec = "s2"
+)
+
+// zstdDictMagic is the magic number of zstd dictionaries.
+var zstdDictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// flateMaxDict is the maximum preset dictionary size used by deflate.
+const flateMaxDict = 32 << 10
+
+// EvalOptions controls how a dictionary is evaluated.
+type EvalOptions struct {
+	// Codecs to evaluate.
+	// If none are specified all codecs are evaluated.
+	Codecs []Codec
+
+	// ZstdLevel is the zstd encoder level to use.
+	// If not set zstd.SpeedDefault is used.
+	ZstdLevel zstd.EncoderLevel
+
+	// FlateLevel is the deflate level to use.
+	// If 0 flate.DefaultCompression is used.
+	FlateLevel int
+}
+
+// CodecResult contains the evaluation result for a single codec.
+// All sizes are the sum of all samples, each compressed independently.
+type CodecResult struct {
+	Codec Codec
+
+	// DictUsed indicates whether the dictionary was used by the codec.
+	// If false, the dictionary numbers are equal to the baseline.
+	DictUsed bool
+
+	// Input is the total uncompressed size.
+	Input int64
+
+	// Baseline is the compressed size without a dictionary.
+	Baseline int64
+
+	// WithDict is the compressed size using the dictionary.
+	WithDict int64
+
+	// Time spent compressing and decompressing without a dictionary.
+	BaselineEncode, BaselineDecode time.Duration
+
+	// Time spent compressing and decompressing with the dictionary.
+	DictEncode, DictDecode time.Duration
+}
+
+// BaselineRatio returns the compression ratio without dictionary.
+func (r CodecResult) BaselineRatio() float64 {
+	if r.Baseline == 0 {
+		return 0
+	}
+	return float64(r.Input) / float64(r.Baseline)
+}
+
+// DictRatio returns the compression ratio with the dictionary.
+func (r CodecResult) DictRatio() float64 {
+	if r.WithDict == 0 {
+		return 0
+	}
+	return float64(r.Input) / float64(r.WithDict)
+}
+
+// SizeDelta returns the relative change in output size when using the dictionary.
+// Negative values mean the output got smaller.
+func (r CodecResult) SizeDelta() float64 {
+	if r.Baseline == 0 {
+		return 0
+	}
+	return float64(r.WithDict-r.Baseline) / float64(r.Baseline)
+}
+
+// EncodeSpeedDelta returns the relative change in compression speed when using the dictionary.
+// Positive values mean compression got faster.
+func (r CodecResult) EncodeSpeedDelta() float64 {
+	return speedDelta(r.BaselineEncode, r.DictEncode)
+}
+
+// DecodeSpeedDelta returns the relative change in decompression speed when using the dictionary.
+// Positive values mean decompression got faster.
+func (r CodecResult) DecodeSpeedDelta() float64 {
+	return speedDelta(r.BaselineDecode, r.DictDecode)
+}
+
+// String returns a single line summary of the result.
+func (r CodecResult) String() string {
+	if !r.DictUsed {
+		return fmt.Sprintf("%s: %d -> %d bytes (%.3fx), dictionary not used", r.Codec, r.Input, r.Baseline, r.BaselineRatio())
+	}
+	return fmt.Sprintf("%s: %d -> %d bytes (%.3fx), with dict %d bytes (%.3fx), size %+.2f%%, encode speed %+.2f%%, decode speed %+.2f%%",
+		r.Codec, r.Input, r.Baseline, r.BaselineRatio(), r.WithDict, r.DictRatio(),
+		r.SizeDelta()*100, r.EncodeSpeedDelta()*100, r.DecodeSpeedDelta()*100)
+}
+
+func speedDelta(before, after time.Duration) float64 {
+	if before <= 0 || after <= 0 {
+		return 0
+	}
+	return float64(before)/float64(after) - 1
+}
+
+// Evaluation contains the result of a dictionary evaluation.
+type Evaluation struct {
+	// Samples is the number of samples evaluated.
+	Samples int
+
+	// Results per codec, in the order they were requested.
+	Results []CodecResult
+}
+
+// Result returns the result for a specific codec, if evaluated.
+func (e *Evaluation) Result(c Codec) (CodecResult, bool) {
+	for _, r := range e.Results {
+		if r.Codec == c {
+			return r, true
+		}
+	}
+	return CodecResult{}, false
+}
+
+// Evaluate will compress each of the samples independently with and without the dictionary
+// and report the size and speed difference for each codec.
+// All output is decompressed and verified, so an error is returned if the dictionary
+// cannot be used to round-trip the samples.
+// Samples should be held out from the samples used to create the dictionary,
+// otherwise the results will be too optimistic.
+func Evaluate(samples [][]byte, dictionary []byte, o EvalOptions) (*Evaluation, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no samples provided")
+	}
+	codecs := o.Codecs
+	if len(codecs) == 0 {
+		codecs = []Codec{CodecZstd, CodecS2, CodecFlate}
+	}
+	res := Evaluation{Samples: len(samples)}
+	for _, c := range codecs {
+		var r CodecResult
+		var err error
+		switch c {
+		case CodecZstd:
+			r, err = evalZstd(samples, dictionary, o.ZstdLevel)
+		case CodecFlate:
+			r, err = evalFlate(samples, dictionary, o.FlateLevel)
+		case CodecS2:
+			r, err = evalS2(samples)
+		default:
+			return nil, fmt.Errorf("unknown codec: %q", c)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c, err)
+		}
+		res.Results = append(res.Results, r)
+	}
+	return &res, nil
+}
+
+// pass contains the measurements of a single pass over all samples.
+type pass struct {
+	size           int64
+	encode, decode time.Duration
+}
+
+// measure compresses and decompresses all samples using the supplied functions.
+func measure(samples [][]byte, enc func(dst, src []byte) ([]byte, error), dec func(dst, src []byte) ([]byte, error)) (pass, error) {
+	var p pass
+	var comp, decomp []byte
+	var err error
+	for i, s := range samples {
+		t := time.Now()
+		comp, err = enc(comp[:0], s)
+		p.encode += time.Since(t)
+		if err != nil {
+			return p, err
+		}
+		p.size += int64(len(comp))
+		t = time.Now()
+		decomp, err = dec(decomp[:0], comp)
+		p.decode += time.Since(t)
+		if err != nil {
+			return p, fmt.Errorf("sample %d: %w", i, err)
+		}
+		if !bytes.Equal(decomp, s) {
+			return p, fmt.Errorf("sample %d: decompressed output mismatch", i)
+		}
+	}
+	return p, nil
+}
+
+func inputSize(samples [][]byte) int64 {
+	var n int64
+	for _, s := range samples {
+		n += int64(len(s))
+	}
+	return n
+}
+
+func evalZstd(samples [][]byte, dictionary []byte, level zstd.EncoderLevel) (CodecResult, error) {
+	r := CodecResult{Codec: CodecZstd, Input: inputSize(samples)}
+	if level == 0 {
+		level = zstd.SpeedDefault
+	}
+	run := func(dict []byte) (pass, error) {
+		eopts := []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(level)}
+		dopts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if dict != nil {
+			eopts = append(eopts, zstd.WithEncoderDict(dict))
+			dopts = append(dopts, zstd.WithDecoderDicts(dict))
+		}
+		enc, err := zstd.NewWriter(nil, eopts...)
+		if err != nil {
+			return pass{}, err
+		}
+		dec, err := zstd.NewReader(nil, dopts...)
+		if err != nil {
+			return pass{}, err
+		}
+		defer dec.Close()
+		return measure(samples,
+			func(dst, src []byte) ([]byte, error) { return enc.EncodeAll(src, dst), nil },
+			func(dst, src []byte) ([]byte, error) { return dec.DecodeAll(src, dst) })
+	}
+	base, err := run(nil)
+	if err != nil {
+		return r, err
+	}
+	r.Baseline, r.BaselineEncode, r.BaselineDecode = base.size, base.encode, base.decode
+	r.WithDict, r.DictEncode, r.DictDecode = base.size, base.encode, base.decode
+	if !bytes.HasPrefix(dictionary, zstdDictMagic) {
+		return r, nil
+	}
+	withDict, err := run(dictionary)
+	if err != nil {
+		return r, err
+	}
+	r.DictUsed = true
+	r.WithDict, r.DictEncode, r.DictDecode = withDict.size, withDict.encode, withDict.decode
+	return r, nil
+}
+
+func evalFlate(samples [][]byte, dictionary []byte, level int) (CodecResult, error) {
+	r := CodecResult{Codec: CodecFlate, Input: inputSize(samples)}
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	// Content is stored at the end of zstd dictionaries,
+	// so the tail can be used for both formats.
+	if len(dictionary) > flateMaxDict {
+		dictionary = dictionary[len(dictionary)-flateMaxDict:]
+	}
+	run := func(dict []byte) (pass, error) {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriterDict(&buf, level, dict)
+		if err != nil {
+			return pass{}, err
+		}
+		fr := flate.NewReader(nil)
+		return measure(samples,
+			func(dst, src []byte) ([]byte, error) {
+				buf.Reset()
+				fw.Reset(&buf)
+				if _, err := fw.Write(src); err != nil {
+					return dst, err
+				}
+				if err := fw.Close(); err != nil {
+					return dst, err
+				}
+				return append(dst, buf.Bytes()...), nil
+			},
+			func(dst, src []byte) ([]byte, error) {
+				if err := fr.(flate.Resetter).Reset(bytes.NewReader(src), dict); err != nil {
+					return dst, err
+				}
+				out := bytes.NewBuffer(dst)
+				_, err := io.Copy(out, fr)
+				return out.Bytes(), err
+			})
+	}
+	base, err := run(nil)
+	if err != nil {
+		return r, err
+	}
+	r.Baseline, r.BaselineEncode, r.BaselineDecode = base.size, base.encode, base.decode
+	r.WithDict, r.DictEncode, r.DictDecode = base.size, base.encode, base.decode
+	if len(dictionary) == 0 {
+		return r, nil
+	}
+	withDict, err := run(dictionary)
+	if err != nil {
+		return r, err
+	}
+	r.DictUsed = true
+	r.WithDict, r.DictEncode, r.DictDecode = withDict.size, withDict.encode, withDict.decode
+	return r, nil
+}
+
+func evalS2(samples [][]byte) (CodecResult, error) {
+	r := CodecResult{Codec: CodecS2, Input: inputSize(samples)}
+	base, err := measure(samples,
+		func(dst, src []byte) ([]byte, error) { return s2.Encode(dst[:cap(dst)], src), nil },
+		func(dst, src []byte) ([]byte, error) { return s2.Decode(dst[:cap(dst)], src) })
+	if err != nil {
+		return r, err
+	}
+	r.Baseline, r.BaselineEncode, r.BaselineDecode = base.size, base.encode, base.decode
+	r.WithDict, r.DictEncode, r.DictDecode = base.size, base.encode, base.decode
+	return r, nil
+}
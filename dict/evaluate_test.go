@@ -0,0 +1,145 @@
+package dict
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// loadTestSamples returns the dictionaries and decoded samples
+// from the zstd dictionary test set, keyed by dictionary name.
+func loadTestSamples(t testing.TB) (dicts map[string][]byte, samples map[string][][]byte) {
+	fn := "../zstd/testdata/dict-tests-small.zip"
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readFile := func(f *zip.File) []byte {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	dicts = make(map[string][]byte)
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".dict") {
+			dicts[strings.TrimSuffix(f.Name, ".dict")] = readFile(f)
+		}
+	}
+	var all [][]byte
+	for _, d := range dicts {
+		all = append(all, d)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(all...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	samples = make(map[string][][]byte)
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".zst") {
+			continue
+		}
+		b, err := dec.DecodeAll(readFile(f), nil)
+		if err != nil {
+			t.Fatal(f.Name, err)
+		}
+		dir := path.Dir(f.Name)
+		samples[dir] = append(samples[dir], b)
+	}
+	return dicts, samples
+}
+
+func TestEvaluate(t *testing.T) {
+	dicts, samples := loadTestSamples(t)
+	ev, err := Evaluate(samples["d0"], dicts["d0"], EvalOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Samples != len(samples["d0"]) {
+		t.Errorf("want %d samples, got %d", len(samples["d0"]), ev.Samples)
+	}
+	if len(ev.Results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(ev.Results))
+	}
+	for _, r := range ev.Results {
+		t.Log(r)
+	}
+	zr, ok := ev.Result(CodecZstd)
+	if !ok {
+		t.Fatal("no zstd result")
+	}
+	if !zr.DictUsed {
+		t.Error("zstd dictionary not used")
+	}
+	if zr.WithDict >= zr.Baseline {
+		t.Errorf("zstd dictionary did not improve compression: %d >= %d", zr.WithDict, zr.Baseline)
+	}
+	fr, ok := ev.Result(CodecFlate)
+	if !ok {
+		t.Fatal("no flate result")
+	}
+	if !fr.DictUsed {
+		t.Error("flate dictionary not used")
+	}
+	if fr.WithDict >= fr.Baseline {
+		t.Errorf("flate dictionary did not improve compression: %d >= %d", fr.WithDict, fr.Baseline)
+	}
+	sr, ok := ev.Result(CodecS2)
+	if !ok {
+		t.Fatal("no s2 result")
+	}
+	if sr.DictUsed || sr.WithDict != sr.Baseline {
+		t.Error("s2 should not use dictionary")
+	}
+}
+
+func TestEvaluateRawDict(t *testing.T) {
+	dicts, samples := loadTestSamples(t)
+	// Strip the zstd header, so only content remains.
+	raw := dicts["d1"]
+	raw = raw[len(raw)-16<<10:]
+	ev, err := Evaluate(samples["d1"], raw, EvalOptions{Codecs: []Codec{CodecZstd, CodecFlate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ev.Results) != 2 {
+		t.Fatalf("want 2 results, got %d", len(ev.Results))
+	}
+	if ev.Results[0].DictUsed {
+		t.Error("zstd should not use raw dictionary")
+	}
+	if !ev.Results[1].DictUsed {
+		t.Error("flate should use raw dictionary")
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	if _, err := Evaluate(nil, nil, EvalOptions{}); err == nil {
+		t.Error("expected error on no samples")
+	}
+	if _, err := Evaluate([][]byte{[]byte("abc")}, nil, EvalOptions{Codecs: []Codec{"lz77"}}); err == nil {
+		t.Error("expected error on unknown codec")
+	}
+	// Invalid zstd dictionary.
+	bad := append([]byte{}, zstdDictMagic...)
+	bad = append(bad, make([]byte, 100)...)
+	if _, err := Evaluate([][]byte{[]byte("abc")}, bad, EvalOptions{Codecs: []Codec{CodecZstd}}); err == nil {
+		t.Error("expected error on invalid dictionary")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package dict
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ShrinkOptions controls how a dictionary is shrunk.
+type ShrinkOptions struct {
+	// Format of the dictionary.
+	Format Format
+
+	// TargetSize is the maximum size of the returned dictionary,
+	// including any headers.
+	TargetSize int
+
+	// SegmentSize is the size of the pieces of content that are considered for removal.
+	// If 0, 256 is used.
+	SegmentSize int
+
+	// HashBytes is the minimum match length when scoring content.
+	// Must be between 4 and 8. If 0, DefaultHashBytes is used.
+	HashBytes int
+}
+
+// Shrink will remove content from a dictionary until it is at most the target size.
+// Content is scored by how much of the samples it can be used to compress,
+// and the least valuable content is removed first.
+// Scores are updated as content is removed.
+// The order of the remaining content is preserved.
+// If the dictionary already fits it is returned as is.
+func Shrink(dictionary []byte, samples [][]byte, o ShrinkOptions) ([]byte, error) {
+	if o.SegmentSize == 0 {
+		o.SegmentSize = 256
+	}
+	if o.HashBytes == 0 {
+		o.HashBytes = DefaultHashBytes
+	}
+	if o.HashBytes < 4 || o.HashBytes > 8 {
+		return nil, fmt.Errorf("hash bytes must be between 4 and 8, got %d", o.HashBytes)
+	}
+	if o.SegmentSize < 1 {
+		return nil, errors.New("segment size must be positive")
+	}
+	if len(dictionary) <= o.TargetSize {
+		return dictionary, nil
+	}
+
+	var content []byte
+	var repeat int
+	switch o.Format {
+	case FormatRaw:
+		content = dictionary
+	case FormatS2:
+		r, n := binary.Uvarint(dictionary)
+		if n <= 0 || r > uint64(len(dictionary)-n) {
+			return nil, errors.New("invalid s2 dictionary")
+		}
+		repeat = int(r)
+		content = dictionary[n:]
+	case FormatZstd:
+		return nil, errors.New("shrinking zstd dictionaries is not supported. Build with a smaller MaxSize instead")
+	default:
+		return nil, fmt.Errorf("unknown format: %d", o.Format)
+	}
+	target := o.TargetSize - (len(dictionary) - len(content))
+	if o.Format == FormatS2 {
+		// The header may shrink as well, but we stay conservative.
+		if target < S2MinDictSize {
+			return nil, fmt.Errorf("target size too small for s2 dictionary")
+		}
+	}
+	if target <= 0 {
+		return nil, fmt.Errorf("target size %d too small", o.TargetSize)
+	}
+
+	// Split into segments and remember where they came from.
+	type seg struct {
+		start, end int
+		score      int64
+	}
+	var segs []seg
+	for i := 0; i < len(content); i += o.SegmentSize {
+		end := i + o.SegmentSize
+		if end > len(content) {
+			end = len(content)
+		}
+		segs = append(segs, seg{start: i, end: end})
+	}
+	size := len(content)
+	m := newDictMatcher(o.HashBytes)
+	var cur []byte
+	var offsets []int
+	for size > target {
+		// Assemble current content and score it.
+		cur, offsets = cur[:0], offsets[:0]
+		for _, s := range segs {
+			offsets = append(offsets, len(cur))
+			cur = append(cur, content[s.start:s.end]...)
+		}
+		used := m.usage(cur, samples)
+		for i := range segs {
+			segs[i].score = 0
+			for _, u := range used[offsets[i] : offsets[i]+segs[i].end-segs[i].start] {
+				segs[i].score += int64(u)
+			}
+		}
+		order := make([]int, len(segs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return segs[order[i]].score < segs[order[j]].score
+		})
+
+		// Remove up to a quarter of the segments before scoring again,
+		// but remove all unused segments.
+		maxRemove := len(segs) / 4
+		if maxRemove < 1 {
+			maxRemove = 1
+		}
+		remove := make(map[int]struct{})
+		for n, i := range order {
+			if size <= target {
+				break
+			}
+			if n >= maxRemove && segs[i].score > 0 {
+				break
+			}
+			remove[i] = struct{}{}
+			size -= segs[i].end - segs[i].start
+		}
+		var keep = segs[:0]
+		for i, s := range segs {
+			if _, ok := remove[i]; !ok {
+				keep = append(keep, s)
+			}
+		}
+		segs = keep
+	}
+
+	// Write output.
+	var dst []byte
+	switch o.Format {
+	case FormatS2:
+		// Keep the repeat offset if its content is kept.
+		newRepeat := -1
+		var n int
+		for _, s := range segs {
+			if repeat >= s.start && repeat < s.end {
+				newRepeat = n + repeat - s.start
+			}
+			n += s.end - s.start
+		}
+		if newRepeat < 0 && len(segs) > 0 {
+			newRepeat = n - (segs[len(segs)-1].end - segs[len(segs)-1].start)
+		}
+		var tmp [binary.MaxVarintLen64]byte
+		dst = append(dst, tmp[:binary.PutUvarint(tmp[:], uint64(newRepeat))]...)
+	}
+	for _, s := range segs {
+		dst = append(dst, content[s.start:s.end]...)
+	}
+	return dst, nil
+}
+
+// dictMatcher finds matches in dictionary content
+// to determine which parts of the content is used.
+type dictMatcher struct {
+	d     int
+	table []int32
+	buf   []byte
+}
+
+const dictMatcherTableBits = 16
+
+func newDictMatcher(d int) *dictMatcher {
+	return &dictMatcher{d: d, table: make([]int32, 1<<dictMatcherTableBits)}
+}
+
+func (m *dictMatcher) hash(b []byte, i int) uint32 {
+	v := binary.LittleEndian.Uint64(b[i:])
+	v <<= 64 - 8*uint(m.d)
+	return uint32((v * coverPrime) >> (64 - dictMatcherTableBits))
+}
+
+// usage returns the number of bytes each position of dict is used for matches
+// when compressing each of the samples with dict as history.
+// Matches are found with a simple greedy parse that prefers the most recent occurrence.
+func (m *dictMatcher) usage(dict []byte, samples [][]byte) []uint32 {
+	used := make([]uint32, len(dict))
+	for _, s := range samples {
+		m.buf = append(append(m.buf[:0], dict...), s...)
+		b := m.buf
+		for i := range m.table {
+			m.table[i] = -1
+		}
+		// Index the dictionary.
+		i := 0
+		for ; i+8 <= len(dict); i++ {
+			m.table[m.hash(b, i)] = int32(i)
+		}
+		i = len(dict)
+		for i+8 <= len(b) {
+			h := m.hash(b, i)
+			c := int(m.table[h])
+			m.table[h] = int32(i)
+			if c < 0 {
+				i++
+				continue
+			}
+			// Extend match.
+			l := 0
+			for i+l < len(b) && b[c+l] == b[i+l] {
+				l++
+			}
+			if l < m.d {
+				i++
+				continue
+			}
+			// Credit the dictionary bytes covered.
+			for j := c; j < c+l && j < len(dict); j++ {
+				used[j]++
+			}
+			// Index the matched bytes.
+			for j := i + 1; j < i+l && j+8 <= len(b); j++ {
+				m.table[m.hash(b, j)] = int32(j)
+			}
+			i += l
+		}
+	}
+	return used
+}
@@ -0,0 +1,216 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package dict
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// TuneOptions controls the parameter search done by Tune.
+type TuneOptions struct {
+	// HashBytes values to try.
+	// If empty, 6 and 8 are tried.
+	HashBytes []int
+
+	// MinSegmentSize and MaxSegmentSize is the range of segment sizes to try.
+	// If 0, 50 and 2000 are used.
+	MinSegmentSize, MaxSegmentSize int
+
+	// Steps is the number of segment sizes tried within the range.
+	// If 0, 40 steps are used.
+	Steps int
+
+	// SplitPoint is the fraction of samples used for building dictionaries.
+	// The remaining samples are used for evaluating the dictionaries.
+	// If 0, 0.75 is used. If 1, all samples are used for both.
+	SplitPoint float64
+
+	// Concurrency is the number of parameter sets evaluated concurrently.
+	// If 0, GOMAXPROCS is used.
+	Concurrency int
+}
+
+func (t *TuneOptions) validate() error {
+	if len(t.HashBytes) == 0 {
+		t.HashBytes = []int{6, 8}
+	}
+	for _, d := range t.HashBytes {
+		if d < 4 || d > 8 {
+			return fmt.Errorf("hash bytes must be between 4 and 8, got %d", d)
+		}
+	}
+	if t.MinSegmentSize == 0 {
+		t.MinSegmentSize = 50
+	}
+	if t.MaxSegmentSize == 0 {
+		t.MaxSegmentSize = 2000
+	}
+	if t.MinSegmentSize < 4 || t.MaxSegmentSize > MaxSegmentSize || t.MinSegmentSize > t.MaxSegmentSize {
+		return fmt.Errorf("invalid segment size range: %d -> %d", t.MinSegmentSize, t.MaxSegmentSize)
+	}
+	if t.Steps == 0 {
+		t.Steps = 40
+	}
+	if t.Steps < 0 {
+		return errors.New("steps must be positive")
+	}
+	if t.SplitPoint == 0 {
+		t.SplitPoint = 0.75
+	}
+	if t.SplitPoint < 0 || t.SplitPoint > 1 {
+		return fmt.Errorf("split point must be between 0 and 1, got %v", t.SplitPoint)
+	}
+	if t.Concurrency == 0 {
+		t.Concurrency = runtime.GOMAXPROCS(0)
+	}
+	if t.Concurrency < 0 {
+		return errors.New("concurrency must be positive")
+	}
+	return nil
+}
+
+// TuneResult is the result of a single parameter set.
+type TuneResult struct {
+	SegmentSize int
+	HashBytes   int
+
+	// Size is the estimated compressed size of the evaluation samples.
+	Size int64
+
+	// DictSize is the size of the dictionary content.
+	DictSize int
+}
+
+// Tune will search for the segment size and hash bytes that gives the best
+// compression of the samples.
+//
+// The samples are split into a set for building and a set for evaluating
+// each dictionary, so the score reflects how well the dictionary compresses
+// content it was not built from.
+// The compressed size is estimated by compressing each evaluation sample with
+// the dictionary content as history.
+//
+// The returned Options has SegmentSize and HashBytes set to the best values found.
+// All results are returned sorted with the best first.
+func Tune(samples [][]byte, o Options, t TuneOptions) (Options, []TuneResult, error) {
+	if err := o.validate(); err != nil {
+		return o, nil, err
+	}
+	if err := t.validate(); err != nil {
+		return o, nil, err
+	}
+	train, test := splitSamples(samples, t.SplitPoint)
+	if len(train) == 0 || len(test) == 0 {
+		return o, nil, errors.New("not enough samples to tune parameters")
+	}
+
+	// Create the parameter sets.
+	var params []TuneResult
+	step := (t.MaxSegmentSize - t.MinSegmentSize) / t.Steps
+	if step < 1 {
+		step = 1
+	}
+	for _, d := range t.HashBytes {
+		for k := t.MinSegmentSize; k <= t.MaxSegmentSize; k += step {
+			if k >= d {
+				params = append(params, TuneResult{SegmentSize: k, HashBytes: d})
+			}
+		}
+	}
+	if len(params) == 0 {
+		return o, nil, errors.New("no valid parameters to try")
+	}
+
+	// Frequencies only depend on d, so they are shared.
+	freqs := make(map[int][]uint32, len(t.HashBytes))
+	for _, d := range t.HashBytes {
+		if _, ok := freqs[d]; !ok {
+			freqs[d] = newCover(train, d, d).freq
+		}
+	}
+
+	var wg sync.WaitGroup
+	idx := make(chan int)
+	wg.Add(t.Concurrency)
+	for i := 0; i < t.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			var est sizeEstimator
+			for i := range idx {
+				p := &params[i]
+				c := cover{
+					samples: train,
+					k:       p.SegmentSize,
+					d:       p.HashBytes,
+					freq:    append([]uint32(nil), freqs[p.HashBytes]...),
+					segFreq: make([]uint16, coverHashSize),
+				}
+				content := concatSegments(c.selectSegments(o.MaxSize))
+				p.DictSize = len(content)
+				p.Size = est.estimate(content, test)
+			}
+		}()
+	}
+	for i := range params {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	sort.SliceStable(params, func(i, j int) bool {
+		if params[i].Size != params[j].Size {
+			return params[i].Size < params[j].Size
+		}
+		return params[i].DictSize < params[j].DictSize
+	})
+	o.SegmentSize = params[0].SegmentSize
+	o.HashBytes = params[0].HashBytes
+	return o, params, nil
+}
+
+// splitSamples returns samples split into a set for building and a set for testing.
+// Samples are distributed evenly, so input order does not bias either set.
+// If split is 1 all samples are returned in both sets.
+func splitSamples(samples [][]byte, split float64) (train, test [][]byte) {
+	if split >= 1 {
+		return samples, samples
+	}
+	for i, s := range samples {
+		if int(float64(i+1)*(1-split)) > int(float64(i)*(1-split)) {
+			test = append(test, s)
+		} else {
+			train = append(train, s)
+		}
+	}
+	return train, test
+}
+
+// sizeEstimator estimates the compressed size of samples when using
+// a dictionary.
+type sizeEstimator struct {
+	buf []byte
+	dst []byte
+}
+
+// estimate returns the estimated total size of the samples when compressed
+// independently with dict as history.
+// Each sample is compressed after the dictionary content and the size
+// of the compressed dictionary is subtracted.
+func (e *sizeEstimator) estimate(dict []byte, samples [][]byte) int64 {
+	e.dst = s2.Encode(e.dst[:cap(e.dst)], dict)
+	base := int64(len(e.dst))
+	var total int64
+	for _, s := range samples {
+		e.buf = append(append(e.buf[:0], dict...), s...)
+		e.dst = s2.Encode(e.dst[:cap(e.dst)], e.buf)
+		total += int64(len(e.dst)) - base
+	}
+	return total
+}
@@ -3,7 +3,8 @@ package flate
 import (
 	"io"
 	"math"
-	"sync"
+
+	"github.com/klauspost/compress/budget"
 )
 
 const (
@@ -53,12 +54,17 @@ func NewStatelessWriter(dst io.Writer) io.WriteCloser {
 }
 
 // bitWriterPool contains bit writers that can be reused.
-var bitWriterPool = sync.Pool{
+var bitWriterPool = budget.Pool{
+	Name: "flate.bitWriter",
 	New: func() interface{} {
 		return newHuffmanBitWriter(nil)
 	},
+	Size: func(interface{}) int64 { return bitWriterSize },
 }
 
+// bitWriterSize is the approximate size of a huffmanBitWriter including its encoders.
+const bitWriterSize = 11 << 10
+
 // StatelessDeflate allows to compress directly to a Writer without retaining state.
 // When returning everything will be flushed.
 // Up to 8KB of an optional dictionary can be given which is presumed to presumed to precede the block.
@@ -2,4 +2,4 @@ module github.com/klauspost/compress
 
 go 1.13
 
-require github.com/golang/snappy v0.0.3 // indirect
+require github.com/golang/snappy v0.0.3
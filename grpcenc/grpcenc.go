@@ -0,0 +1,224 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package grpcenc provides zstd and s2 compressors for gRPC.
+//
+// The compressors implement the Compressor interface of
+// google.golang.org/grpc/encoding and can be registered with:
+//
+//	encoding.RegisterCompressor(grpcenc.Zstd())
+//	encoding.RegisterCompressor(grpcenc.S2())
+//
+// Clients select a compressor with grpc.UseCompressor(grpcenc.NameZstd).
+//
+// Encoders, decoders and buffers are pooled, and the window size used
+// for compression is limited to suit typical message sizes.
+// The decompressed size of messages is limited to guard against
+// excessive memory use from untrusted peers.
+//
+// Zstd messages use the zstd frame format and S2 messages use the
+// S2 stream format, so both can be read by other implementations.
+package grpcenc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/budget"
+)
+
+// Names of the compressors, used as the grpc-encoding header value.
+const (
+	NameZstd = "zstd"
+	NameS2   = "s2"
+)
+
+const (
+	// DefaultMaxDecodedSize is the default limit of decompressed message sizes.
+	// It matches the default maximum receive message size of gRPC.
+	DefaultMaxDecodedSize = 4 << 20
+
+	// DefaultWindowSize is the default zstd window size.
+	DefaultWindowSize = 1 << 20
+)
+
+// ErrTooLarge is returned when a decompressed message exceeds the size limit.
+var ErrTooLarge = errors.New("grpcenc: decompressed message too large")
+
+// Compressor is the interface implemented by the compressors.
+// It matches the encoding.Compressor interface of gRPC.
+type Compressor interface {
+	// Compress returns a writer that compresses to w.
+	// Close must be called to write all data.
+	Compress(w io.Writer) (io.WriteCloser, error)
+
+	// Decompress returns a reader of the decompressed content of r.
+	Decompress(r io.Reader) (io.Reader, error)
+
+	// Name returns the name of the compression.
+	Name() string
+}
+
+type options struct {
+	level          int
+	windowSize     int
+	maxDecodedSize int
+}
+
+// Option is an option for creating a compressor.
+type Option func(*options) error
+
+// WithLevel sets the compression level.
+// For zstd the level uses the zstd command line scale,
+// see zstd.EncoderLevelFromZstd. The default is 1.
+// For s2 the levels are 1 (fast), 2 (better) and 3 (best). The default is 1.
+func WithLevel(level int) Option {
+	return func(o *options) error {
+		if level < 1 {
+			return fmt.Errorf("grpcenc: invalid level %d", level)
+		}
+		o.level = level
+		return nil
+	}
+}
+
+// WithWindowSize sets the zstd window size used for compression.
+// It must be a power of two between zstd.MinWindowSize and zstd.MaxWindowSize.
+// Peers must accept the window size, so it should be kept small.
+// The default is DefaultWindowSize. It is ignored by s2.
+func WithWindowSize(n int) Option {
+	return func(o *options) error {
+		o.windowSize = n
+		return nil
+	}
+}
+
+// WithMaxDecodedSize sets the maximum size of decompressed messages.
+// Larger messages return ErrTooLarge.
+// The default is DefaultMaxDecodedSize.
+func WithMaxDecodedSize(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return fmt.Errorf("grpcenc: invalid max decoded size %d", n)
+		}
+		o.maxDecodedSize = n
+		return nil
+	}
+}
+
+func errInvalidS2Level(level int) error {
+	return fmt.Errorf("grpcenc: s2 level must be 1 to 3, got %d", level)
+}
+
+func newOptions(opts []Option) (options, error) {
+	o := options{
+		level:          1,
+		windowSize:     DefaultWindowSize,
+		maxDecodedSize: DefaultMaxDecodedSize,
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// bufferPool contains byte slices used for messages.
+var bufferPool = budget.Pool{
+	Name: "grpcenc.buffer",
+	New: func() interface{} {
+		return new([]byte)
+	},
+	Size: func(x interface{}) int64 {
+		return int64(cap(*x.(*[]byte)))
+	},
+}
+
+// maxPooledBuffer is the largest buffer returned to the pool.
+const maxPooledBuffer = 4 << 20
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	*b = (*b)[:0]
+	bufferPool.Put(b)
+}
+
+// readAll reads all of r into a pooled buffer.
+func readAll(r io.Reader) (*[]byte, error) {
+	buf := bufferPool.Get().(*[]byte)
+	if br, ok := r.(*bytes.Reader); ok {
+		// Messages are usually in memory.
+		if n := br.Len(); cap(*buf) < n {
+			*buf = make([]byte, 0, n)
+		}
+	}
+	b := (*buf)[:0]
+	for {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n, err := r.Read(b[len(b):cap(b)])
+		b = b[:len(b)+n]
+		if err == io.EOF {
+			*buf = b
+			return buf, nil
+		}
+		if err != nil {
+			*buf = b
+			putBuffer(buf)
+			return nil, err
+		}
+	}
+}
+
+// bufferedWriter collects a message and compresses it on Close.
+type bufferedWriter struct {
+	dst      io.Writer
+	buf      *[]byte
+	compress func(dst io.Writer, src []byte) error
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	if w.buf == nil {
+		return 0, errors.New("grpcenc: write after close")
+	}
+	*w.buf = append(*w.buf, p...)
+	return len(p), nil
+}
+
+func (w *bufferedWriter) Close() error {
+	if w.buf == nil {
+		return nil
+	}
+	err := w.compress(w.dst, *w.buf)
+	putBuffer(w.buf)
+	w.buf = nil
+	return err
+}
+
+// bufferReader reads a pooled buffer and returns it to the pool at EOF.
+type bufferReader struct {
+	buf *[]byte
+	off int
+}
+
+func (r *bufferReader) Read(p []byte) (int, error) {
+	if r.buf == nil {
+		return 0, io.EOF
+	}
+	n := copy(p, (*r.buf)[r.off:])
+	r.off += n
+	if r.off == len(*r.buf) {
+		putBuffer(r.buf)
+		r.buf = nil
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,113 @@
+package grpcenc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+func roundtrip(t *testing.T, c Compressor, msg []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gRPC may write in several calls.
+	half := len(msg) / 2
+	w.Write(msg[:half])
+	w.Write(msg[half:])
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	comp := append([]byte{}, buf.Bytes()...)
+	r, err := c.Decompress(bytes.NewReader(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Fatalf("%s: roundtrip mismatch, got %d bytes, want %d", c.Name(), len(got), len(msg))
+	}
+	return comp
+}
+
+func TestRoundtrip(t *testing.T) {
+	msg := bytes.Repeat([]byte("grpc message payload "), 5000)
+	for _, c := range []Compressor{Zstd(), S2()} {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				roundtrip(t, c, msg[:i*1000])
+			}(i)
+		}
+		wg.Wait()
+		comp := roundtrip(t, c, msg)
+		if len(comp) >= len(msg)/10 {
+			t.Errorf("%s: poor compression: %d -> %d", c.Name(), len(msg), len(comp))
+		}
+	}
+}
+
+func TestInterop(t *testing.T) {
+	msg := bytes.Repeat([]byte("interop "), 1000)
+	comp := roundtrip(t, Zstd(), msg)
+	dec, _ := zstd.NewReader(nil)
+	defer dec.Close()
+	if got, err := dec.DecodeAll(comp, nil); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("zstd output not readable by zstd decoder: %v", err)
+	}
+	if got := Zstd().(interface{ DecompressedSize([]byte) int }).DecompressedSize(comp); got != len(msg) {
+		t.Errorf("want decompressed size %d, got %d", len(msg), got)
+	}
+
+	comp = roundtrip(t, S2(), msg)
+	if got, err := ioutil.ReadAll(s2.NewReader(bytes.NewReader(comp))); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("s2 output not readable by s2 reader: %v", err)
+	}
+}
+
+func TestMaxDecodedSize(t *testing.T) {
+	msg := make([]byte, 10000)
+	for _, f := range []func(...Option) (Compressor, error){NewZstd, NewS2} {
+		c, err := f(WithMaxDecodedSize(len(msg) - 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		w, _ := c.Compress(&buf)
+		w.Write(msg)
+		w.Close()
+		if _, err := c.Decompress(&buf); err != ErrTooLarge {
+			t.Errorf("%s: want ErrTooLarge, got %v", c.Name(), err)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if _, err := NewS2(WithLevel(4)); err == nil {
+		t.Error("expected error on invalid s2 level")
+	}
+	if _, err := NewZstd(WithWindowSize(1000)); err == nil {
+		t.Error("expected error on invalid window size")
+	}
+	c, err := NewS2(WithLevel(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundtrip(t, c, []byte("level 3"))
+	roundtrip(t, c, nil)
+	if _, err := c.Decompress(io.MultiReader(bytes.NewReader([]byte("not s2")))); err == nil {
+		t.Error("expected error on invalid input")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package grpcenc
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/budget"
+	"github.com/klauspost/compress/s2"
+)
+
+// s2BlockSize is the block size used for compression.
+// It is smaller than the s2 default, since messages are usually small.
+const s2BlockSize = 256 << 10
+
+type s2Compressor struct {
+	writers        budget.Pool
+	readers        budget.Pool
+	maxDecodedSize int
+}
+
+// NewS2 returns an s2 compressor with the supplied options.
+func NewS2(opts ...Option) (Compressor, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	wopts := []s2.WriterOption{s2.WriterConcurrency(1), s2.WriterBlockSize(s2BlockSize)}
+	switch o.level {
+	case 1:
+	case 2:
+		wopts = append(wopts, s2.WriterBetterCompression())
+	case 3:
+		wopts = append(wopts, s2.WriterBestCompression())
+	default:
+		return nil, errInvalidS2Level(o.level)
+	}
+	c := &s2Compressor{maxDecodedSize: o.maxDecodedSize}
+	c.writers = budget.Pool{
+		Name: "grpcenc.s2Writer",
+		New: func() interface{} {
+			return s2.NewWriter(nil, wopts...)
+		},
+		Size: func(interface{}) int64 {
+			return int64(2 * s2.MaxEncodedLen(s2BlockSize))
+		},
+	}
+	c.readers = budget.Pool{
+		Name: "grpcenc.s2Reader",
+		New: func() interface{} {
+			return s2.NewReader(nil)
+		},
+		Size: func(interface{}) int64 {
+			return int64(2 * s2.MaxEncodedLen(s2BlockSize))
+		},
+	}
+	return c, nil
+}
+
+// S2 returns an s2 compressor with default options.
+func S2() Compressor {
+	c, err := NewS2()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *s2Compressor) Name() string {
+	return NameS2
+}
+
+func (c *s2Compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	enc := c.writers.Get().(*s2.Writer)
+	enc.Reset(w)
+	return &s2Writer{Writer: enc, pool: &c.writers}, nil
+}
+
+type s2Writer struct {
+	*s2.Writer
+	pool *budget.Pool
+}
+
+func (w *s2Writer) Close() error {
+	if w.Writer == nil {
+		return nil
+	}
+	err := w.Writer.Close()
+	w.Writer.Reset(nil)
+	w.pool.Put(w.Writer)
+	w.Writer = nil
+	return err
+}
+
+func (c *s2Compressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec := c.readers.Get().(*s2.Reader)
+	dec.Reset(r)
+	out, err := readAll(io.LimitReader(dec, int64(c.maxDecodedSize)+1))
+	dec.Reset(nil)
+	c.readers.Put(dec)
+	if err != nil {
+		return nil, err
+	}
+	if len(*out) > c.maxDecodedSize {
+		putBuffer(out)
+		return nil, ErrTooLarge
+	}
+	return &bufferReader{buf: out}, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package grpcenc
+
+import (
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type zstdCompressor struct {
+	enc            *zstd.Encoder
+	dec            *zstd.Decoder
+	maxDecodedSize int
+}
+
+// NewZstd returns a zstd compressor with the supplied options.
+// Messages are compressed and decompressed using shared encoders
+// and decoders that are safe for concurrent use.
+func NewZstd(opts ...Option) (Compressor, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.level)),
+		zstd.WithWindowSize(o.windowSize),
+		zstd.WithLowerEncoderMem(true))
+	if err != nil {
+		return nil, err
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(o.maxDecodedSize)))
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return &zstdCompressor{enc: enc, dec: dec, maxDecodedSize: o.maxDecodedSize}, nil
+}
+
+// Zstd returns a zstd compressor with default options.
+func Zstd() Compressor {
+	c, err := NewZstd()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func (c *zstdCompressor) Name() string {
+	return NameZstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &bufferedWriter{
+		dst: w,
+		buf: bufferPool.Get().(*[]byte),
+		compress: func(dst io.Writer, src []byte) error {
+			out := bufferPool.Get().(*[]byte)
+			*out = c.enc.EncodeAll(src, (*out)[:0])
+			_, err := dst.Write(*out)
+			putBuffer(out)
+			return err
+		},
+	}, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	in, err := readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	defer putBuffer(in)
+	out := bufferPool.Get().(*[]byte)
+	*out, err = c.dec.DecodeAll(*in, (*out)[:0])
+	if err == nil && len(*out) > c.maxDecodedSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		putBuffer(out)
+		if err == zstd.ErrDecoderSizeExceeded || err == zstd.ErrWindowSizeExceeded {
+			err = ErrTooLarge
+		}
+		return nil, err
+	}
+	return &bufferReader{buf: out}, nil
+}
+
+// DecompressedSize returns the decompressed size of a message
+// if it is stored in the frame header, or -1 otherwise.
+func (c *zstdCompressor) DecompressedSize(buf []byte) int {
+	var h zstd.Header
+	if err := h.Decode(buf); err != nil || !h.HasFCS || h.Skippable {
+		return -1
+	}
+	if h.FrameContentSize > math.MaxInt32 {
+		return -1
+	}
+	return int(h.FrameContentSize)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveLevel lowers the compression level when the server is under load.
+// load is called when compression of a response starts, and must return
+// the current load, where 0 is idle and 1 is fully loaded.
+// Above 0.5 the fastest level is used, and at 1 or above
+// responses are not compressed.
+// The level is not changed for encodings added with RegisterEncoding.
+//
+// load may be called concurrently. See AdaptiveLatency for a
+// load signal based on the time spent compressing.
+func AdaptiveLevel(load func() float64) option {
+	return func(c *config) {
+		c.load = load
+		c.latencyLoad = nil
+	}
+}
+
+// AdaptiveLatency lowers the compression level when the average time spent
+// compressing a response approaches target.
+// The average is a moving average of all responses to clients that
+// accept compression, where responses that are not compressed count as 0.
+// The load is the average divided by target, applied as described for AdaptiveLevel.
+//
+// This keeps the added latency stable under bursty traffic, without
+// requiring an external load signal.
+func AdaptiveLatency(target time.Duration) option {
+	return func(c *config) {
+		l := &latencyLoad{target: target}
+		c.load = l.load
+		c.latencyLoad = l
+	}
+}
+
+// latencyLoad keeps a moving average of compression time.
+type latencyLoad struct {
+	average int64 // Nanoseconds, accessed atomically.
+	target  time.Duration
+}
+
+// latencyWeight is the weight of new samples in the moving average.
+const latencyWeight = 0.1
+
+// observe adds the compression time of a response to the average.
+func (l *latencyLoad) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&l.average)
+		avg := int64(float64(old)*(1-latencyWeight) + float64(d)*latencyWeight)
+		if atomic.CompareAndSwapInt64(&l.average, old, avg) {
+			return
+		}
+	}
+}
+
+func (l *latencyLoad) load() float64 {
+	return float64(atomic.LoadInt64(&l.average)) / float64(l.target)
+}
+
+// checkLoad records the load when compression starts
+// and returns whether the response should be compressed.
+func (w *GzipResponseWriter) checkLoad() bool {
+	if w.load == nil {
+		return true
+	}
+	w.currentLoad = w.load()
+	return w.currentLoad < 1
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestAdaptiveLevel(t *testing.T) {
+	serve := func(opts ...option) *httptest.ResponseRecorder {
+		wrapper, err := NewWrapper(opts...)
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(testBody)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	fastest := serve(CompressionLevel(gzip.BestSpeed)).Body.Bytes()
+	best := serve(CompressionLevel(gzip.BestCompression)).Body.Bytes()
+	assertNotEqual(t, fastest, best)
+
+	load := 0.0
+	loadFn := func() float64 { return load }
+	assertEqual(t, best, serve(CompressionLevel(gzip.BestCompression), AdaptiveLevel(loadFn)).Body.Bytes())
+	load = 0.7
+	assertEqual(t, fastest, serve(CompressionLevel(gzip.BestCompression), AdaptiveLevel(loadFn)).Body.Bytes())
+	load = 1
+	resp := serve(AdaptiveLevel(loadFn))
+	assertEqual(t, "", resp.Header().Get("Content-Encoding"))
+	assertEqual(t, testBody, resp.Body.Bytes())
+}
+
+func TestAdaptiveLatency(t *testing.T) {
+	wrapper, err := NewWrapper(AdaptiveLatency(time.Nanosecond))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	}))
+	encoding := func() string {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Header().Get("Content-Encoding")
+	}
+	assertEqual(t, "gzip", encoding())
+	// Compressing takes much longer than the target.
+	assertEqual(t, "", encoding())
+
+	_, err = NewWrapper(AdaptiveLatency(0))
+	assertNotNil(t, err)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CacheCompressed caches up to entries compressed responses of at most
+// maxSize compressed bytes, so repeated responses are not compressed again.
+//
+// Responses are keyed by their content coding, the host and URL of the request,
+// the request headers named by the Vary response header, and the strong ETag.
+// The function set with CacheKey can be used to replace all but the content coding.
+// Responses with "Vary: *" are not cached.
+// The handler is still called for every request, but when a cached
+// response is found, the bytes written by the handler are discarded
+// and the cached compressed response is sent instead.
+// The least recently used responses are removed when the cache is full.
+//
+// Responses are not cached when padding is added with RandomJitter,
+// or when compressed with a shared dictionary.
+func CacheCompressed(entries, maxSize int) option {
+	return func(c *config) {
+		c.cacheEntries, c.cacheMaxSize = entries, maxSize
+		c.cache = nil
+		if entries > 0 && maxSize > 0 {
+			c.cache = newResponseCache(entries)
+		}
+	}
+}
+
+// CacheKey sets a function that returns the cache key of a response,
+// used instead of the request URL, Vary headers and ETag by CacheCompressed.
+// It is called with the request and the response headers when compression starts.
+// The key must identify both the resource and its version,
+// since responses with the same key must have the same content.
+// For example, an ETag alone is not enough if different URLs can have the same ETag.
+// If fn returns an empty string, the response is not cached.
+func CacheKey(fn func(r *http.Request, h http.Header) string) option {
+	return func(c *config) {
+		c.cacheKey = fn
+	}
+}
+
+// responseCache is a least recently used cache of compressed responses.
+type responseCache struct {
+	mu      sync.Mutex
+	entries int
+	order   *list.List // Values are *cacheEntry, most recently used first.
+	byKey   map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key  string
+	body []byte
+}
+
+func newResponseCache(entries int) *responseCache {
+	return &responseCache{
+		entries: entries,
+		order:   list.New(),
+		byKey:   make(map[string]*list.Element, entries),
+	}
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byKey[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cacheEntry).body, true
+}
+
+func (c *responseCache) put(key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byKey[key]; ok {
+		e.Value.(*cacheEntry).body = body
+		c.order.MoveToFront(e)
+		return
+	}
+	c.byKey[key] = c.order.PushFront(&cacheEntry{key: key, body: body})
+	if c.order.Len() > c.entries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.byKey, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// responseCacheKey returns the cache key of the response, or an empty
+// string if it should not be cached.
+// It must be called before the ETag is rewritten.
+func (w *GzipResponseWriter) responseCacheKey() string {
+	if w.cache == nil || w.jitter > 0 || w.encoding == encodingDcz {
+		return ""
+	}
+	var key string
+	if w.cacheKeyFn != nil {
+		key = w.cacheKeyFn(w.req, w.Header())
+	} else if tag := w.Header().Get(etag); len(tag) > 2 && !strings.HasPrefix(tag, "W/") {
+		key = defaultCacheKey(w.req, w.Header(), tag)
+	}
+	if key == "" {
+		return ""
+	}
+	return w.encoding + "\x00" + key
+}
+
+// defaultCacheKey returns the cache key of a response with the strong ETag tag.
+// The key contains the host and URL of r and the request headers named by Vary in h.
+// An empty string is returned if the response varies on anything.
+func defaultCacheKey(r *http.Request, h http.Header, tag string) string {
+	var sb strings.Builder
+	sb.WriteString(r.Host)
+	sb.WriteByte(0)
+	sb.WriteString(r.URL.RequestURI())
+	sb.WriteByte(0)
+	sb.WriteString(tag)
+	for _, v := range h[vary] {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			switch name {
+			case "":
+				continue
+			case "*":
+				return ""
+			case acceptEncoding, availableDictionary:
+				// Part of the content coding.
+				continue
+			}
+			sb.WriteByte(0)
+			sb.WriteString(name)
+			for _, hv := range r.Header[name] {
+				sb.WriteByte(0)
+				sb.WriteString(hv)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// serveCached sends the cached response for key, if any.
+// The buffered and following writes are discarded.
+func (w *GzipResponseWriter) serveCached(key string) (bool, error) {
+	body, ok := w.cache.get(key)
+	if !ok {
+		return false, nil
+	}
+	w.cacheHit = true
+	w.stats.Encoding = w.encoding
+	w.stats.Uncompressed += int64(len(w.buf))
+	w.counted(w.buf)
+	w.buf = w.buf[:0]
+	n, err := w.ResponseWriter.Write(body)
+	w.stats.Compressed += int64(n)
+	return true, err
+}
+
+// discard counts writes to a response served from the cache.
+func (w *GzipResponseWriter) discard(b []byte) (int, error) {
+	w.stats.Uncompressed += int64(len(b))
+	w.counted(b)
+	return len(b), nil
+}
+
+// cacheCapture records the compressed output of a response for the cache.
+// Recording stops if the output exceeds max bytes.
+type cacheCapture struct {
+	w       io.Writer
+	max     int
+	buf     []byte
+	overrun bool
+}
+
+func (c *cacheCapture) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if !c.overrun {
+		if len(c.buf)+n > c.max || err != nil {
+			c.overrun, c.buf = true, nil
+		} else {
+			c.buf = append(c.buf, p[:n]...)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestCacheCompressed(t *testing.T) {
+	// The handler writes a different body for each request,
+	// so responses from the cache can be detected.
+	var calls int
+	tagged := func(tag string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if tag != "" {
+				w.Header().Set("ETag", tag)
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(testBody)
+			w.Write(bytes.Repeat([]byte{'a'}, calls))
+		})
+	}
+	get := func(h http.Handler, path string, header ...string) []byte {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Add(header[i], header[i+1])
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+		zr, err := gzip.NewReader(resp.Body)
+		assertNil(t, err)
+		got, err := ioutil.ReadAll(zr)
+		assertNil(t, err)
+		return got
+	}
+
+	wrapper, err := NewWrapper(CacheCompressed(1, 1<<20))
+	assertNil(t, err)
+	a := wrapper(tagged(`"a"`))
+	first := get(a, "/")
+	assertEqual(t, first, get(a, "/"))
+	assertEqual(t, 2, calls)
+
+	// Weak and missing ETags are not cached.
+	for _, tag := range []string{`W/"a"`, ""} {
+		h := wrapper(tagged(tag))
+		assertNotEqual(t, get(h, "/"), get(h, "/"))
+	}
+
+	// The least recently used response is removed.
+	b := wrapper(tagged(`"b"`))
+	get(b, "/")
+	assertNotEqual(t, first, get(a, "/"))
+
+	// The same ETag on other resources.
+	wrapper, err = NewWrapper(CacheCompressed(10, 1<<20))
+	assertNil(t, err)
+	a = wrapper(tagged(`"a"`))
+	first = get(a, "/a")
+	assertNotEqual(t, first, get(a, "/b"))
+	assertNotEqual(t, first, get(a, "/a?v=2"))
+	assertNotEqual(t, first, get(a, "http://other.example.com/a"))
+	assertEqual(t, first, get(a, "/a"))
+
+	// Request headers named by Vary are part of the key.
+	varied := func(v string) http.Handler {
+		h := tagged(`"v"`)
+		return wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", v)
+			h.ServeHTTP(w, r)
+		}))
+	}
+	h := varied("Accept-Language, X-Test")
+	en := get(h, "/", "Accept-Language", "en")
+	assertNotEqual(t, en, get(h, "/", "Accept-Language", "de"))
+	assertNotEqual(t, en, get(h, "/", "Accept-Language", "en", "X-Test", "1"))
+	assertEqual(t, en, get(h, "/", "Accept-Language", "en"))
+	h = varied("*")
+	assertNotEqual(t, get(h, "/"), get(h, "/"))
+
+	// Too large to be cached.
+	wrapper, err = NewWrapper(CacheCompressed(1, 10))
+	assertNil(t, err)
+	a = wrapper(tagged(`"a"`))
+	assertNotEqual(t, get(a, "/"), get(a, "/"))
+
+	// Custom keys.
+	wrapper, err = NewWrapper(CacheCompressed(10, 1<<20), CacheKey(func(r *http.Request, h http.Header) string {
+		return r.URL.Path
+	}))
+	assertNil(t, err)
+	h = wrapper(tagged(""))
+	x := get(h, "/x")
+	assertNotEqual(t, x, get(h, "/y"))
+	assertEqual(t, x, get(h, "/x"))
+
+	_, err = NewWrapper(CacheCompressed(-1, 10))
+	assertNotNil(t, err)
+}
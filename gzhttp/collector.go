@@ -0,0 +1,116 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Collector aggregates the Stats of responses.
+// Use it with WithMetrics:
+//
+//	stats := gzhttp.NewCollector()
+//	expvar.Publish("gzhttp", stats)
+//	wrapper, err := gzhttp.NewWrapper(gzhttp.WithMetrics(stats.Add))
+//
+// Collector implements expvar.Var, publishing the result of Totals as JSON.
+// To export the counters to other systems, like Prometheus,
+// read them with Totals when they are scraped.
+//
+// A Collector can be shared by several wrappers and is safe for concurrent use.
+type Collector struct {
+	mu     sync.Mutex
+	totals Totals
+}
+
+// Totals contains the aggregated statistics of a Collector.
+type Totals struct {
+	// Bypassed contains responses that were not compressed,
+	// for instance because they were smaller than the minimum size.
+	Bypassed EncodingTotals `json:"bypassed"`
+
+	// Encodings contains compressed responses by content coding.
+	Encodings map[string]EncodingTotals `json:"encodings"`
+}
+
+// EncodingTotals contains the aggregated statistics of a number of responses.
+type EncodingTotals struct {
+	// Responses is the number of responses.
+	Responses int64 `json:"responses"`
+
+	// Uncompressed is the number of bytes written by the handlers.
+	Uncompressed int64 `json:"uncompressed"`
+
+	// Compressed is the number of bytes sent to clients.
+	Compressed int64 `json:"compressed"`
+
+	// DurationNanos is the time spent compressing, in nanoseconds.
+	DurationNanos int64 `json:"duration_ns"`
+}
+
+// Saved returns the number of bytes saved by compression.
+func (t EncodingTotals) Saved() int64 {
+	return t.Uncompressed - t.Compressed
+}
+
+// NewCollector returns an empty collector.
+func NewCollector() *Collector {
+	return &Collector{totals: Totals{Encodings: make(map[string]EncodingTotals)}}
+}
+
+// Add adds the statistics of a response.
+func (c *Collector) Add(s Stats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.totals.Bypassed
+	if s.Encoding != "" {
+		t = c.totals.Encodings[s.Encoding]
+	}
+	t.Responses++
+	t.Uncompressed += s.Uncompressed
+	t.Compressed += s.Compressed
+	t.DurationNanos += int64(s.Duration)
+	if s.Encoding != "" {
+		c.totals.Encodings[s.Encoding] = t
+	} else {
+		c.totals.Bypassed = t
+	}
+}
+
+// Totals returns a copy of the current statistics.
+func (c *Collector) Totals() Totals {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.totals
+	t.Encodings = make(map[string]EncodingTotals, len(c.totals.Encodings))
+	for k, v := range c.totals.Encodings {
+		t.Encodings[k] = v
+	}
+	return t
+}
+
+// Compressed returns the sum of the statistics of all compressed responses.
+func (t Totals) Compressed() EncodingTotals {
+	var sum EncodingTotals
+	for _, e := range t.Encodings {
+		sum.Responses += e.Responses
+		sum.Uncompressed += e.Uncompressed
+		sum.Compressed += e.Compressed
+		sum.DurationNanos += e.DurationNanos
+	}
+	return sum
+}
+
+// String returns the current statistics as JSON.
+// It implements expvar.Var.
+func (c *Collector) String() string {
+	b, err := json.Marshal(c.Totals())
+	if err != nil {
+		// Should never happen.
+		return "{}"
+	}
+	return string(b)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Check that Collector can be published with expvar.
+var _ expvar.Var = &Collector{}
+
+func TestCollector(t *testing.T) {
+	stats := NewCollector()
+	wrapper, err := NewWrapper(EnableZstd(), WithMetrics(stats.Add))
+	assertNil(t, err)
+	var body []byte
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+
+	var sent int64
+	for _, test := range []struct {
+		accept string
+		body   []byte
+	}{
+		{accept: "gzip", body: testBody},
+		{accept: "gzip", body: testBody},
+		{accept: "zstd", body: testBody},
+		{accept: "gzip", body: smallTestBody},
+		// Not reported.
+		{accept: "", body: testBody},
+	} {
+		body = test.body
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if test.accept != "" {
+			sent += int64(resp.Body.Len())
+		}
+	}
+
+	got := stats.Totals()
+	assertEqual(t, int64(1), got.Bypassed.Responses)
+	assertEqual(t, int64(len(smallTestBody)), got.Bypassed.Uncompressed)
+	assertEqual(t, int64(0), got.Bypassed.Saved())
+	assertEqual(t, 2, len(got.Encodings))
+	assertEqual(t, int64(2), got.Encodings["gzip"].Responses)
+	assertEqual(t, int64(2*len(testBody)), got.Encodings["gzip"].Uncompressed)
+	assertEqual(t, int64(1), got.Encodings["zstd"].Responses)
+
+	all := got.Compressed()
+	assertEqual(t, int64(3), all.Responses)
+	assertEqual(t, sent, all.Compressed+got.Bypassed.Compressed)
+	if all.Saved() <= 0 {
+		t.Errorf("expected saved bytes, got %d", all.Saved())
+	}
+
+	// The returned totals are a copy.
+	got.Encodings["gzip"] = EncodingTotals{}
+	assertEqual(t, int64(2), stats.Totals().Encodings["gzip"].Responses)
+
+	var decoded Totals
+	assertNil(t, json.Unmarshal([]byte(stats.String()), &decoded))
+	assertEqual(t, stats.Totals(), decoded)
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import "github.com/klauspost/compress"
+
+// minEstimateSize is the smallest number of buffered bytes
+// checked by MinCompressibility. Estimates of less are unreliable.
+const minEstimateSize = 64
+
+// MinCompressibility sends responses uncompressed if the start of the
+// response is estimated to be less compressible than min,
+// using compress.Estimate.
+// This saves CPU and avoids growing responses that are already compressed,
+// such as images sent with a content type that is otherwise compressed.
+//
+// Values close to zero are likely incompressible.
+// 0.05 will skip most random and already compressed data.
+// Only the data buffered before deciding whether to compress is used,
+// see BufferSize and MinSize. Responses that have less than 64 bytes
+// buffered at that point are not checked.
+// The default, 0, disables the check.
+func MinCompressibility(min float64) option {
+	return func(c *config) {
+		c.minCompressibility = min
+	}
+}
+
+// compressible returns whether the buffered start of the response
+// is estimated to be compressible enough.
+func (w *GzipResponseWriter) compressible() bool {
+	if w.minCompressibility <= 0 || len(w.buf) < minEstimateSize {
+		return true
+	}
+	return compress.Estimate(w.buf) >= w.minCompressibility
+}
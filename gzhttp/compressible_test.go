@@ -0,0 +1,56 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMinCompressibility(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(0)).Read(random)
+
+	tests := []struct {
+		name         string
+		min          float64
+		body         []byte
+		flush        bool
+		wantEncoding string
+	}{
+		{name: "text", min: 0.05, body: testBody, wantEncoding: "gzip"},
+		{name: "random", min: 0.05, body: random, wantEncoding: ""},
+		{name: "random-flushed", min: 0.05, body: random, flush: true, wantEncoding: ""},
+		{name: "random-short", min: 0.05, body: random[:minEstimateSize-1], wantEncoding: "gzip"},
+		{name: "disabled", min: 0, body: random, wantEncoding: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, err := NewWrapper(MinSize(10), MinCompressibility(test.min))
+			assertNil(t, err)
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				if test.flush {
+					w.Write(test.body[:100])
+					w.(http.Flusher).Flush()
+					w.Write(test.body[100:])
+					return
+				}
+				w.Write(test.body)
+			}))
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, test.wantEncoding, resp.Header().Get("Content-Encoding"))
+			if test.wantEncoding == "" && !bytes.Equal(test.body, resp.Body.Bytes()) {
+				t.Fatal("body mismatch")
+			}
+		})
+	}
+}
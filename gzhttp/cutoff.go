@@ -0,0 +1,77 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+// MaxCompressSize limits the CPU time spent on large responses.
+//
+// Responses with a Content-Length above n bytes are not compressed.
+// When a compressed response exceeds n bytes, the remaining data is
+// compressed with the fastest level of the encoder. This ends the current
+// gzip member or zstd frame and starts a new one, which all gzip and zstd
+// decoders must handle. Registered encodings keep their level.
+//
+// The default, 0, means no limit.
+func MaxCompressSize(n int64) option {
+	return func(c *config) {
+		c.maxCompressSize = n
+	}
+}
+
+// tooLarge returns whether a response with the Content-Length cl
+// should not be compressed.
+func (w *GzipResponseWriter) tooLarge(cl int) bool {
+	return w.maxSize > 0 && int64(cl) > w.maxSize
+}
+
+// newCutoffWriter returns a writer using newWriter for the first n bytes
+// and fastWriter for the rest.
+func newCutoffWriter(newWriter, fastWriter writer.EncoderFactory, out io.Writer, n int64) writer.GzipWriter {
+	return &cutoffWriter{
+		GzipWriter: newWriter(out),
+		fast:       fastWriter,
+		out:        out,
+		remain:     n,
+	}
+}
+
+// cutoffWriter switches to a faster encoder after a number of bytes.
+type cutoffWriter struct {
+	writer.GzipWriter // Current encoder.
+
+	fast   writer.EncoderFactory // Encoder to switch to, nil when switched.
+	out    io.Writer
+	remain int64 // Bytes until switching.
+}
+
+func (c *cutoffWriter) Write(p []byte) (int, error) {
+	if c.fast == nil || int64(len(p)) <= c.remain {
+		c.remain -= int64(len(p))
+		return c.GzipWriter.Write(p)
+	}
+	n, err := c.GzipWriter.Write(p[:c.remain])
+	if err != nil {
+		return n, err
+	}
+	if err := c.GzipWriter.Close(); err != nil {
+		return n, err
+	}
+	c.GzipWriter = c.fast(c.out)
+	c.fast = nil
+	n2, err := c.GzipWriter.Write(p[n:])
+	return n + n2, err
+}
+
+// SetHeader sets the gzip header of the first encoder, if supported.
+func (c *cutoffWriter) SetHeader(h writer.Header) {
+	if gw, ok := c.GzipWriter.(writer.GzipWriterExt); ok {
+		gw.SetHeader(h)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestMaxCompressSize(t *testing.T) {
+	const maxSize = 4096
+	body := bytes.Repeat(testBody, 10)
+	var stats Stats
+	wrapper, err := NewWrapper(EnableZstd(), MaxCompressSize(maxSize), RandomJitter(10), WithMetrics(func(s Stats) { stats = s }))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/length" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+		for b := body; len(b) > 0; {
+			n := 1000
+			if n > len(b) {
+				n = len(b)
+			}
+			w.Write(b[:n])
+			b = b[n:]
+		}
+	}))
+
+	for _, enc := range []string{"gzip", "zstd"} {
+		t.Run(enc, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, enc, resp.Header().Get("Content-Encoding"))
+			assertEqual(t, int64(len(body)), stats.Uncompressed)
+			assertEqual(t, int64(resp.Body.Len()), stats.Compressed)
+
+			var got []byte
+			switch enc {
+			case "gzip":
+				zr, err := gzip.NewReader(bytes.NewReader(resp.Body.Bytes()))
+				assertNil(t, err)
+				// The first member contains maxSize bytes.
+				zr.Multistream(false)
+				first, err := ioutil.ReadAll(zr)
+				assertNil(t, err)
+				assertEqual(t, maxSize, len(first))
+				if len(zr.Header.Comment) == 0 {
+					t.Error("first member not padded")
+				}
+
+				zr, err = gzip.NewReader(bytes.NewReader(resp.Body.Bytes()))
+				assertNil(t, err)
+				got, err = ioutil.ReadAll(zr)
+				assertNil(t, err)
+			case "zstd":
+				zr, err := zstd.NewReader(bytes.NewReader(resp.Body.Bytes()))
+				assertNil(t, err)
+				got, err = ioutil.ReadAll(zr)
+				zr.Close()
+				assertNil(t, err)
+			}
+			if !bytes.Equal(got, body) {
+				t.Fatal("body mismatch")
+			}
+		})
+	}
+
+	// Known large responses are not compressed.
+	req, _ := http.NewRequest("GET", "/length", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertEqual(t, "", resp.Header().Get("Content-Encoding"))
+	assertEqual(t, body, resp.Body.Bytes())
+
+	_, err = NewWrapper(MaxCompressSize(-1))
+	assertNotNil(t, err)
+}
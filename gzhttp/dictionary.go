@@ -0,0 +1,215 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/budget"
+	"github.com/klauspost/compress/gzhttp/writer"
+	"github.com/klauspost/compress/gzhttp/writer/zstdkp"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	availableDictionary = "Available-Dictionary"
+	useAsDictionary     = "Use-As-Dictionary"
+
+	encodingDcz = "dcz"
+
+	// MaxDictionarySize is the maximum size of a dictionary.
+	// Clients are only required to support zstd windows up to 8MB,
+	// and the dictionary must fit in the window.
+	MaxDictionarySize = 8 << 20
+)
+
+// dczHeader starts dcz responses and is followed by the SHA-256 hash of the dictionary.
+// It is the header of a zstd skippable frame containing the hash.
+var dczHeader = [8]byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}
+
+// dictionary is a shared dictionary added with Dictionary.
+type dictionary struct {
+	content []byte
+	hash    [sha256.Size]byte
+
+	// enc compresses with the dictionary.
+	// It is set by initEncodings.
+	enc encoding
+}
+
+// Dictionary adds a shared dictionary for Compression Dictionary Transport.
+//
+// Clients that have stored a dictionary send its SHA-256 hash
+// in the Available-Dictionary request header.
+// If the hash matches content, and the client accepts "dcz",
+// the response is compressed with zstd using content as a raw dictionary,
+// which can be much smaller when the response is similar to the dictionary,
+// for instance a new version of the same resource.
+// dcz is preferred over other encodings with the same qvalue.
+// "Available-Dictionary" is added to the Vary header of all responses.
+//
+// The zstd compression level is used for dcz, even if zstd is not enabled.
+// Brotli dictionary compression (dcb) is not supported.
+// Several dictionaries can be added, see UseAsDictionary for how
+// clients obtain them.
+func Dictionary(content []byte) option {
+	return func(c *config) {
+		c.dictionaries = append(c.dictionaries, dictionary{
+			content: content,
+			hash:    sha256.Sum256(content),
+		})
+	}
+}
+
+// UseAsDictionary adds a Use-As-Dictionary header to responses,
+// telling clients to store the response as a dictionary for future requests
+// for URLs matching the URL pattern match, for example "/js/app.*.js".
+// This is mainly useful with PathPrefix and PathRegexp.
+//
+// Responses are only compressed with the stored dictionary if the same
+// content is added with Dictionary for the paths matching the pattern.
+func UseAsDictionary(match string) option {
+	return func(c *config) {
+		c.useAsDictionary = "match=" + sfString(match)
+	}
+}
+
+// sfString returns s as a structured field string.
+func sfString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// validateDictionaries checks the dictionaries and the level used with them.
+func (c *config) validateDictionaries() error {
+	if c.useAsDictionary != "" {
+		for _, r := range c.useAsDictionary {
+			if r < 0x20 || r > 0x7e {
+				return fmt.Errorf("invalid dictionary match pattern: %s", c.useAsDictionary)
+			}
+		}
+	}
+	if len(c.dictionaries) == 0 {
+		return nil
+	}
+	min, max := zstdkp.Levels()
+	if c.zstd.level < min || c.zstd.level > max {
+		return fmt.Errorf("invalid zstd compression level for dictionaries: %d, valid range %d -> %d", c.zstd.level, min, max)
+	}
+	for _, d := range c.dictionaries {
+		// zstd requires at least 8 bytes of dictionary content.
+		if len(d.content) < 8 || len(d.content) > MaxDictionarySize {
+			return fmt.Errorf("invalid dictionary size %d, valid range 8 -> %d", len(d.content), MaxDictionarySize)
+		}
+	}
+	return nil
+}
+
+// initDictionaries sets up the encodings of the dictionaries.
+// c must be validated.
+func (c *config) initDictionaries() {
+	level := zstd.EncoderLevel(c.zstd.level)
+	for i := range c.dictionaries {
+		d := &c.dictionaries[i]
+		pool := newDictionaryPool(d.content, level)
+		hash := d.hash
+		d.enc = encoding{
+			token: encodingDcz,
+			newWriter: func(w io.Writer) writer.GzipWriter {
+				// Write errors will be returned by the following writes.
+				if _, err := w.Write(dczHeader[:]); err == nil {
+					w.Write(hash[:])
+				}
+				enc := pool.Get().(*zstd.Encoder)
+				enc.Reset(w)
+				return &dictionaryWriter{Encoder: enc, pool: pool}
+			},
+		}
+	}
+}
+
+// newDictionaryPool returns a pool of encoders using content as a raw dictionary.
+func newDictionaryPool(content []byte, level zstd.EncoderLevel) *budget.Pool {
+	window := zstdkp.WindowSize
+	for window < len(content) {
+		window *= 2
+	}
+	return &budget.Pool{
+		Name: "gzhttp.dictionary",
+		Size: func(interface{}) int64 { return int64(2*window + len(content)) },
+		New: func() interface{} {
+			// Options are validated, so no error is returned.
+			enc, _ := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(level),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(window),
+				zstd.WithLowerEncoderMem(true),
+				zstd.WithEncoderDictRaw(0, content))
+			return enc
+		},
+	}
+}
+
+// dictionaryWriter is a dcz encoder that is returned to its pool when closed.
+type dictionaryWriter struct {
+	*zstd.Encoder
+	pool *budget.Pool
+}
+
+func (dw *dictionaryWriter) Close() error {
+	if dw.Encoder == nil {
+		return nil
+	}
+	err := dw.Encoder.Close()
+	// Release the reference to the output.
+	dw.Encoder.Reset(nil)
+	dw.pool.Put(dw.Encoder)
+	dw.Encoder = nil
+	return err
+}
+
+// dictionaryEncoding returns the encoding for the dictionary the client
+// has announced in r, and the qvalue of dcz in the Accept-Encoding value ae.
+// It returns nil if there is no matching dictionary or dcz is not accepted.
+// dcz must be listed explicitly, since it requires the dictionary.
+func (c *config) dictionaryEncoding(r *http.Request, ae string) (*encoding, float64) {
+	if len(c.dictionaries) == 0 {
+		return nil, 0
+	}
+	q, _ := findEncoding(ae, encodingDcz)
+	if q <= 0 {
+		return nil, 0
+	}
+	hash, ok := parseAvailableDictionary(r.Header.Get(availableDictionary))
+	if !ok {
+		return nil, 0
+	}
+	for i := range c.dictionaries {
+		d := &c.dictionaries[i]
+		if bytes.Equal(d.hash[:], hash) {
+			return &d.enc, q
+		}
+	}
+	return nil, 0
+}
+
+// parseAvailableDictionary returns the hash in an Available-Dictionary
+// header value, which is a structured field byte sequence.
+func parseAvailableDictionary(s string) ([]byte, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != ':' || s[len(s)-1] != ':' {
+		return nil, false
+	}
+	hash, err := base64.StdEncoding.DecodeString(s[1 : len(s)-1])
+	if err != nil || len(hash) != sha256.Size {
+		return nil, false
+	}
+	return hash, true
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDictionary(t *testing.T) {
+	// The new version of a resource, with the old version as dictionary.
+	dict := append([]byte(nil), testBody...)
+	body := append(append([]byte(nil), testBody...), "new content"...)
+	hash := sha256.Sum256(dict)
+	available := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+	other := sha256.Sum256([]byte("other"))
+
+	wrapper, err := NewWrapper(Dictionary(dict), UseAsDictionary("/app.*.js"), EnableZstd())
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		name, accept, available string
+		encoding                string
+	}{
+		{name: "match", accept: "gzip, zstd, dcz", available: available, encoding: "dcz"},
+		{name: "no-dcz", accept: "gzip, zstd", available: available, encoding: "zstd"},
+		{name: "star", accept: "*", available: available, encoding: "zstd"},
+		{name: "lower-q", accept: "gzip, dcz;q=0.5", available: available, encoding: "gzip"},
+		{name: "unknown", accept: "gzip, dcz", available: ":" + base64.StdEncoding.EncodeToString(other[:]) + ":", encoding: "gzip"},
+		{name: "invalid", accept: "gzip, dcz", available: "abc", encoding: "gzip"},
+		{name: "none", accept: "gzip, dcz", encoding: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/app.2.js", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+			if test.available != "" {
+				req.Header.Set("Available-Dictionary", test.available)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, test.encoding, res.Header.Get("Content-Encoding"))
+			assertEqual(t, []string{"Accept-Encoding", "Available-Dictionary"}, res.Header["Vary"])
+			assertEqual(t, `match="/app.*.js"`, res.Header.Get("Use-As-Dictionary"))
+			if test.encoding != "dcz" {
+				return
+			}
+			got, _ := ioutil.ReadAll(res.Body)
+			if len(got) < 40 {
+				t.Fatalf("response too short: %d bytes", len(got))
+			}
+			assertEqual(t, dczHeader[:], got[:8])
+			assertEqual(t, hash[:], got[8:40])
+			dec, err := zstd.NewReader(nil, zstd.WithDecoderDictRaw(0, dict), zstd.WithDecoderDefaultDict(0))
+			assertNil(t, err)
+			defer dec.Close()
+			// The header is a skippable frame, so the full response can be decoded.
+			decoded, err := dec.DecodeAll(got, nil)
+			assertNil(t, err)
+			if !bytes.Equal(decoded, body) {
+				t.Fatal("body mismatch")
+			}
+			// Only the difference to the dictionary should be encoded.
+			if len(got) > 100 {
+				t.Errorf("dictionary compressed response is %d bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestDictionaryInvalid(t *testing.T) {
+	_, err := NewWrapper(Dictionary([]byte("short")))
+	assertNotNil(t, err)
+	_, err = NewWrapper(Dictionary(make([]byte, MaxDictionarySize+1)))
+	assertNotNil(t, err)
+	_, err = NewWrapper(Dictionary(testBody), ZstdCompressionLevel(100))
+	assertNotNil(t, err)
+	_, err = NewWrapper(UseAsDictionary("/a\n"))
+	assertNotNil(t, err)
+}
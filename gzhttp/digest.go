@@ -0,0 +1,67 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"net/http"
+
+	"github.com/klauspost/compress/xxhash"
+)
+
+const (
+	// DefaultDigestTrailer is the default name of the trailer
+	// added by DigestTrailer.
+	DefaultDigestTrailer = "Content-Digest"
+
+	// Digest algorithms supported by DigestTrailer.
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+	DigestXXHash = "xxhash" // 64 bit xxhash, big endian.
+)
+
+// DigestTrailer will send a digest of the bytes written by the handler
+// in a trailer named name, when the response is compressed,
+// so clients can verify the response after decompressing it.
+// algorithm must be DigestSHA256, DigestSHA512 or DigestXXHash.
+// If name is empty, DefaultDigestTrailer is used.
+//
+// The value uses the format of RFC 9530, for example
+// "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:".
+// Note that RFC 9530 defines Content-Digest and Repr-Digest for the
+// content as it is sent, while this digest is of the uncompressed content.
+//
+// The trailer is declared in the Trailer header when compression starts.
+// Note that not all clients read trailers.
+func DigestTrailer(name, algorithm string) option {
+	return func(c *config) {
+		if name == "" {
+			name = DefaultDigestTrailer
+		}
+		c.digestTrailer = http.CanonicalHeaderKey(name)
+		c.digestAlgorithm = algorithm
+	}
+}
+
+// newDigest returns a new hash for algorithm, or nil if it is not supported.
+func newDigest(algorithm string) hash.Hash {
+	switch algorithm {
+	case DigestSHA256:
+		return sha256.New()
+	case DigestSHA512:
+		return sha512.New()
+	case DigestXXHash:
+		return xxhash.New()
+	}
+	return nil
+}
+
+// digestValue returns the value of the digest trailer.
+func (w *GzipResponseWriter) digestValue() string {
+	return w.digestAlgorithm + "=:" + base64.StdEncoding.EncodeToString(w.digest.Sum(nil)) + ":"
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/xxhash"
+)
+
+func TestDigestTrailer(t *testing.T) {
+	sha256Sum := sha256.Sum256(testBody)
+	sha512Sum := sha512.Sum512(testBody)
+	xxh := xxhash.New()
+	xxh.Write(testBody)
+	tests := []struct {
+		name, algorithm string
+		opts            []option
+		wantName        string
+		sum             []byte
+	}{
+		{algorithm: DigestSHA256, wantName: DefaultDigestTrailer, sum: sha256Sum[:]},
+		{name: "repr-digest", algorithm: DigestSHA512, wantName: "Repr-Digest", sum: sha512Sum[:]},
+		{algorithm: DigestXXHash, wantName: DefaultDigestTrailer, sum: xxh.Sum(nil)},
+		{algorithm: DigestSHA256, opts: []option{CacheCompressed(10, 1<<20)}, wantName: DefaultDigestTrailer, sum: sha256Sum[:]},
+	}
+	for _, test := range tests {
+		t.Run(test.algorithm, func(t *testing.T) {
+			wrapper, err := NewWrapper(append(test.opts, DigestTrailer(test.name, test.algorithm))...)
+			assertNil(t, err)
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"abc"`)
+				w.Write(testBody[:100])
+				w.Write(testBody[100:])
+			}))
+			want := test.algorithm + "=:" + base64.StdEncoding.EncodeToString(test.sum) + ":"
+			// Repeat to also test cached responses.
+			for i := 0; i < 2; i++ {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, req)
+				res := resp.Result()
+				assertEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+				assertEqual(t, test.wantName, res.Header.Get("Trailer"))
+				ioutil.ReadAll(res.Body)
+				assertEqual(t, want, res.Trailer.Get(test.wantName))
+			}
+		})
+	}
+
+	_, err := NewWrapper(DigestTrailer("", "md5"))
+	assertNotNil(t, err)
+	_, err = NewWrapper(DigestTrailer("a b", DigestSHA256))
+	assertNotNil(t, err)
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+// checkDone returns the error of the request context if it is done,
+// for instance because the client has disconnected.
+// The first time this happens, the compressor is closed so it is
+// returned to its pool while the handler is still running,
+// and nothing more is compressed or sent.
+func (w *GzipResponseWriter) checkDone() error {
+	if w.ctx == nil || w.ignore {
+		return nil
+	}
+	err := w.ctx.Err()
+	if err == nil || w.aborted {
+		return err
+	}
+	w.aborted = true
+	if w.gw != nil {
+		// The output is likely lost, so errors are ignored.
+		w.gw.Close()
+		w.gw = nil
+	}
+	w.release()
+	w.buf = w.buf[:0]
+	return err
+}
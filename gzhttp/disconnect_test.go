@@ -0,0 +1,46 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write(testBody)
+		assertNil(t, err)
+		w.(http.Flusher).Flush()
+		if rec.Body.Len() == 0 {
+			t.Fatal("nothing sent")
+		}
+
+		cancel()
+		_, err = w.Write(testBody)
+		assertEqual(t, context.Canceled, err)
+		// Only the end of the stream is written when the compressor is closed.
+		sent := rec.Body.Len()
+		_, err = w.Write(testBody)
+		assertEqual(t, context.Canceled, err)
+		w.(http.Flusher).Flush()
+		// Nothing more is sent.
+		assertEqual(t, sent, rec.Body.Len())
+		gw := w.(*GzipResponseWriter)
+		if gw.gw != nil {
+			t.Error("compressor not released")
+		}
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+	assertEqual(t, "gzip", rec.Header().Get("Content-Encoding"))
+}
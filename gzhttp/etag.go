@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	etag        = "ETag"
+	ifMatch     = "If-Match"
+	ifNoneMatch = "If-None-Match"
+)
+
+// WeakETag will convert a strong ETag set by the handler
+// to a weak ETag when the response is compressed.
+//
+// A strong ETag identifies the exact bytes of a response,
+// so it is no longer valid when the response is compressed.
+// Weak ETags are still matched by If-None-Match.
+func WeakETag() option {
+	return func(c *config) {
+		c.weakETag = true
+	}
+}
+
+// SuffixETag will add "-" and the content coding to the ETag set by the handler
+// when the response is compressed, so "abc" becomes "abc-gzip".
+//
+// The suffix is removed from ETags in If-Match and If-None-Match
+// request headers before calling the handler, so conditional
+// requests compare against the ETag set by the handler.
+func SuffixETag() option {
+	return func(c *config) {
+		c.suffixETag = true
+	}
+}
+
+// updateETag rewrites the ETag header for a compressed response.
+func (w *GzipResponseWriter) updateETag() {
+	if !w.weakETag && !w.suffixETag {
+		return
+	}
+	if tag := w.Header().Get(etag); tag != "" {
+		w.Header().Set(etag, rewriteETag(tag, w.encoding, w.weakETag, w.suffixETag))
+	}
+}
+
+// rewriteETag returns the ETag of a response compressed with encoding.
+func rewriteETag(tag, encoding string, weakETag, suffixETag bool) string {
+	weak := strings.HasPrefix(tag, "W/")
+	opaque := strings.TrimPrefix(tag, "W/")
+	if len(opaque) < 2 || opaque[0] != '"' || opaque[len(opaque)-1] != '"' {
+		// Not a valid ETag, leave it.
+		return tag
+	}
+	if suffixETag {
+		opaque = opaque[:len(opaque)-1] + "-" + encoding + `"`
+	}
+	if weak || weakETag {
+		return "W/" + opaque
+	}
+	return opaque
+}
+
+// stripETagSuffix returns r with the encoding suffix removed from
+// ETags in conditional request headers.
+// If there are no suffixes, r is returned.
+func stripETagSuffix(r *http.Request, encoding string) *http.Request {
+	suffix := "-" + encoding + `"`
+	var cloned bool
+	for _, key := range []string{ifMatch, ifNoneMatch} {
+		v := r.Header.Get(key)
+		if !strings.Contains(v, suffix) {
+			continue
+		}
+		if !cloned {
+			r = r.Clone(r.Context())
+			cloned = true
+		}
+		r.Header.Set(key, strings.Replace(v, suffix, `"`, -1))
+	}
+	return r
+}
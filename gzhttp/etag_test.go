@@ -0,0 +1,118 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRewriteETag(t *testing.T) {
+	tests := []struct {
+		tag          string
+		weak, suffix bool
+		want         string
+	}{
+		{tag: `"abc"`, want: `"abc"`},
+		{tag: `"abc"`, weak: true, want: `W/"abc"`},
+		{tag: `W/"abc"`, weak: true, want: `W/"abc"`},
+		{tag: `"abc"`, suffix: true, want: `"abc-gzip"`},
+		{tag: `W/"abc"`, suffix: true, want: `W/"abc-gzip"`},
+		{tag: `"abc"`, weak: true, suffix: true, want: `W/"abc-gzip"`},
+		{tag: `abc`, weak: true, suffix: true, want: `abc`},
+	}
+	for _, test := range tests {
+		assertEqual(t, test.want, rewriteETag(test.tag, "gzip", test.weak, test.suffix))
+	}
+}
+
+func TestETagHandler(t *testing.T) {
+	modTime := time.Unix(1600000000, 0)
+	serve := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "a.txt", modTime, bytes.NewReader(testBody))
+	})
+	tests := []struct {
+		name string
+		opt  option
+		want string
+	}{
+		{name: "none", opt: MinSize(DefaultMinSize), want: `"abc"`},
+		{name: "weak", opt: WeakETag(), want: `W/"abc"`},
+		{name: "suffix", opt: SuffixETag(), want: `"abc-gzip"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, err := NewWrapper(test.opt)
+			assertNil(t, err)
+			handler := wrapper(serve)
+
+			req, _ := http.NewRequest("GET", "/a.txt", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, http.StatusOK, resp.Code)
+			assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+			assertEqual(t, test.want, resp.Header().Get("ETag"))
+
+			// Not compressed responses are unchanged.
+			req.Header.Del("Accept-Encoding")
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, `"abc"`, resp.Header().Get("ETag"))
+
+			// Conditional request with the returned ETag.
+			req.Header.Set("Accept-Encoding", "gzip")
+			req.Header.Set("If-None-Match", test.want)
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, http.StatusNotModified, resp.Code)
+			assertEqual(t, test.want, resp.Header().Get("ETag"))
+			assertEqual(t, 0, resp.Body.Len())
+		})
+	}
+}
+
+func TestSuffixETagEncodings(t *testing.T) {
+	modTime := time.Unix(1600000000, 0)
+	wrapper, err := NewWrapper(SuffixETag(), EnableZstd())
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "a.txt", modTime, bytes.NewReader(testBody))
+	}))
+
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/a.txt", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, encoding, resp.Header().Get("Content-Encoding"))
+			want := `"abc-` + encoding + `"`
+			assertEqual(t, want, resp.Header().Get("ETag"))
+
+			// The suffix is removed from each ETag in a list.
+			req.Header.Set("If-None-Match", `"other-gzip", `+want)
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, http.StatusNotModified, resp.Code)
+			assertEqual(t, want, resp.Header().Get("ETag"))
+
+			// A validator for another encoding does not match.
+			other := `"abc-gzip"`
+			if encoding == "gzip" {
+				other = `"abc-zstd"`
+			}
+			req.Header.Set("If-None-Match", other)
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, http.StatusOK, resp.Code)
+		})
+	}
+}
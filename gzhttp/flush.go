@@ -0,0 +1,88 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"sync"
+	"time"
+)
+
+// FlushInterval sets the flush interval for responses,
+// similar to httputil.ReverseProxy.
+// Written data is flushed to the client at most d after it is written,
+// without the handler calling Flush.
+// This gives low latency for responses that are written slowly,
+// while still compressing data written in quick succession together.
+//
+// Flushing decides whether to compress the response if that has not
+// been decided yet, so responses smaller than the minimum size may be
+// sent uncompressed if the handler writes nothing for d.
+//
+// A negative value flushes after each write, as for streaming content types.
+// The default, 0, only flushes when the handler calls Flush.
+func FlushInterval(d time.Duration) option {
+	return func(c *config) {
+		c.flushInterval = d
+	}
+}
+
+// latencyFlusher flushes a response writer some time after it is written to.
+// All calls to the writer go through it, since flushes happen on another goroutine.
+type latencyFlusher struct {
+	w        *GzipResponseWriter
+	interval time.Duration
+
+	mu      sync.Mutex // Protects w and the fields below.
+	t       *time.Timer
+	pending bool // A flush is scheduled.
+	done    bool // The response is complete.
+}
+
+func newLatencyFlusher(w *GzipResponseWriter, interval time.Duration) *latencyFlusher {
+	return &latencyFlusher{w: w, interval: interval}
+}
+
+func (l *latencyFlusher) write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n, err := l.w.write(b)
+	if n > 0 && !l.pending {
+		l.pending = true
+		if l.t == nil {
+			l.t = time.AfterFunc(l.interval, l.delayedFlush)
+		} else {
+			l.t.Reset(l.interval)
+		}
+	}
+	return n, err
+}
+
+func (l *latencyFlusher) delayedFlush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.pending || l.done {
+		// The writer may have been reused.
+		return
+	}
+	l.w.flush()
+	l.pending = false
+}
+
+func (l *latencyFlusher) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.flush()
+	l.pending = false
+}
+
+func (l *latencyFlusher) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	if l.t != nil {
+		l.t.Stop()
+	}
+	return l.w.close()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// flushNotifier signals on flushed when it is flushed.
+type flushNotifier struct {
+	*httptest.ResponseRecorder
+	flushed chan struct{}
+}
+
+func (f *flushNotifier) Flush() {
+	f.ResponseRecorder.Flush()
+	select {
+	case f.flushed <- struct{}{}:
+	default:
+	}
+}
+
+// gunzipPartial returns what a client can decode of b so far.
+func gunzipPartial(t *testing.T, b []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestFlushInterval(t *testing.T) {
+	rec := &flushNotifier{ResponseRecorder: httptest.NewRecorder(), flushed: make(chan struct{}, 1)}
+	wrapper, err := NewWrapper(MinSize(10), FlushInterval(time.Millisecond))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		var sent []byte
+		for i := 0; i < 3; i++ {
+			part := []byte("some data that is written slowly\n")
+			w.Write(part)
+			sent = append(sent, part...)
+			select {
+			case <-rec.flushed:
+			case <-time.After(10 * time.Second):
+				t.Fatal("not flushed")
+			}
+			assertEqual(t, "gzip", rec.Header().Get("Content-Encoding"))
+			if got := gunzipPartial(t, rec.Body.Bytes()); !bytes.Equal(got, sent) {
+				t.Fatalf("got %q, want %q", got, sent)
+			}
+		}
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+}
+
+func TestFlushIntervalNegative(t *testing.T) {
+	rec := httptest.NewRecorder()
+	wrapper, err := NewWrapper(MinSize(10), FlushInterval(-1))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		part := []byte("flushed immediately")
+		w.Write(part)
+		if !rec.Flushed {
+			t.Fatal("not flushed")
+		}
+		assertEqual(t, part, gunzipPartial(t, rec.Body.Bytes()))
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import "net/http"
+
+// ForceCompressionHeader sets the name of the response header handlers
+// can set to force compression, see HeaderForceCompression.
+// An empty name disables forced compression.
+//
+// Forced responses are compressed regardless of MinSize, the content type
+// filters and MinCompressibility. HeaderNoCompression takes precedence,
+// and responses that already have a Content-Encoding, that cannot have
+// a body or that are filtered by status code are still sent uncompressed.
+func ForceCompressionHeader(name string) option {
+	return func(c *config) {
+		c.forceHeader = http.CanonicalHeaderKey(name)
+	}
+}
+
+// forced returns whether the handler has forced compression of the response.
+func (w *GzipResponseWriter) forced() bool {
+	return w.forceHeader != "" && len(w.Header()[w.forceHeader]) > 0
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForceCompression(t *testing.T) {
+	small := []byte("a small response")
+	tests := []struct {
+		name         string
+		opts         []option
+		header       string
+		ct           string
+		noCompress   bool
+		flush        bool
+		wantEncoding string
+		wantKept     bool // The header is not removed.
+	}{
+		{name: "small", header: HeaderForceCompression, ct: "text/plain", wantEncoding: "gzip"},
+		{name: "small-unforced", ct: "text/plain", wantEncoding: ""},
+		{name: "content-type", header: HeaderForceCompression, ct: "image/png", wantEncoding: "gzip"},
+		{name: "flushed", header: HeaderForceCompression, ct: "image/png", flush: true, wantEncoding: "gzip"},
+		{name: "no-compression", header: HeaderForceCompression, ct: "text/plain", noCompress: true, wantEncoding: ""},
+		{name: "custom", opts: []option{ForceCompressionHeader("x-compress")}, header: "X-Compress", ct: "text/plain", wantEncoding: "gzip"},
+		{name: "custom-default", opts: []option{ForceCompressionHeader("x-compress")}, header: HeaderForceCompression, ct: "text/plain", wantEncoding: "", wantKept: true},
+		{name: "disabled", opts: []option{ForceCompressionHeader("")}, header: HeaderForceCompression, ct: "text/plain", wantEncoding: "", wantKept: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, err := NewWrapper(test.opts...)
+			assertNil(t, err)
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.ct)
+				if test.header != "" {
+					w.Header().Set(test.header, "1")
+				}
+				if test.noCompress {
+					w.Header().Set(HeaderNoCompression, "1")
+				}
+				w.Write(small)
+				if test.flush {
+					w.(http.Flusher).Flush()
+				}
+			}))
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, test.wantEncoding, resp.Header().Get("Content-Encoding"))
+			if test.header != "" {
+				assertEqual(t, test.wantKept, len(resp.Header()[http.CanonicalHeaderKey(test.header)]) > 0)
+			}
+			if test.wantEncoding == "gzip" {
+				assertEqual(t, small, gunzipPartial(t, resp.Body.Bytes()))
+			}
+		})
+	}
+}
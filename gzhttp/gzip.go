@@ -2,7 +2,9 @@ package gzhttp
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"hash"
 	"io"
 	"mime"
 	"net"
@@ -10,10 +12,15 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unsafe"
 
+	"github.com/klauspost/compress/budget"
 	"github.com/klauspost/compress/gzhttp/writer"
 	"github.com/klauspost/compress/gzhttp/writer/gzkp"
+	"github.com/klauspost/compress/gzhttp/writer/zstdkp"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
@@ -22,13 +29,24 @@ const (
 	// The Header is always removed from output.
 	HeaderNoCompression = "No-Gzip-Compression"
 
+	// HeaderForceCompression can be used to compress a response
+	// regardless of its size and content type.
+	// Any header value will force compression.
+	// The Header is always removed from output.
+	// See ForceCompressionHeader.
+	HeaderForceCompression = "X-Force-Gzip-Compression"
+
 	vary            = "Vary"
 	acceptEncoding  = "Accept-Encoding"
 	contentEncoding = "Content-Encoding"
 	contentRange    = "Content-Range"
+	rangeHeader     = "Range"
 	acceptRanges    = "Accept-Ranges"
 	contentType     = "Content-Type"
 	contentLength   = "Content-Length"
+
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
 )
 
 type codings map[string]float64
@@ -45,6 +63,11 @@ const (
 	// That being the case, you should restrict the gzip compression to files with a size (plus header) greater than a single packet,
 	// 1024 bytes (1KB) is therefore default.
 	DefaultMinSize = 1024
+
+	// DefaultBufferSize is the default number of bytes buffered
+	// before deciding whether to compress a response.
+	// See BufferSize.
+	DefaultBufferSize = 512
 )
 
 // GzipResponseWriter provides an http.ResponseWriter interface, which gzips
@@ -53,18 +76,67 @@ const (
 // It can be configured to skip response smaller than minSize.
 type GzipResponseWriter struct {
 	http.ResponseWriter
-	level     int
-	gwFactory writer.GzipWriterFactory
-	gw        writer.GzipWriter
+	newWriter  writer.EncoderFactory
+	fastWriter writer.EncoderFactory // Used after maxSize bytes, if set.
+	leveled    writer.GzipWriterFactory
+	levelFor   func(encoding, ct string) (level int, ok bool) // Level by content type, if set.
+	maxSize    int64                                          // Maximum size compressed with newWriter.
+	gw         writer.GzipWriter
+	encoding   string // Content-Encoding of compressed responses.
 
 	code int // Saves the WriteHeader value.
 
 	minSize          int    // Specifies the minimum response size to gzip. If the response length is bigger than this value, it is compressed.
+	bufferSize       int    // Maximum number of bytes buffered before deciding whether to compress, if larger than minSize.
 	buf              []byte // Holds the first part of the write before reaching the minSize or the end of the write.
 	ignore           bool   // If true, then we immediately passthru writes to the underlying ResponseWriter.
 	keepAcceptRanges bool   // Keep "Accept-Ranges" header.
 
-	contentTypeFilter func(ct string) bool // Only compress if the response is one of these content-types. All are accepted if empty.
+	contentTypeFilter func(ct string) bool  // Only compress if the response is one of these content-types. All are accepted if empty.
+	sniffer           func(b []byte) string // Detects the content type, if set.
+	sniffLength       int                   // Bytes buffered to detect the content type, if set.
+
+	metrics bool  // Collect stats.
+	stats   Stats // Stats of the response, if metrics is set.
+	jitter  int   // Maximum random padding.
+
+	gzipHeader *writer.Header // Header of gzip streams, if set.
+
+	streamTypes func(ct string) bool // Content types that are flushed after each write.
+	autoFlush   bool                 // Flush after each write.
+	latency     *latencyFlusher      // Flushes periodically, if set.
+
+	weakETag   bool // Make the ETag of compressed responses weak.
+	suffixETag bool // Add the encoding to the ETag of compressed responses.
+
+	lengthTrailer string // Trailer for the uncompressed length, if set.
+	written       int64  // Bytes written to gw, if lengthTrailer is set.
+
+	digestTrailer   string    // Trailer for the digest, if set.
+	digestAlgorithm string    // Algorithm of the digest.
+	digest          hash.Hash // Digest of bytes written to gw, if digestTrailer is set.
+
+	limiter  chan struct{} // Limits concurrent compression, if set.
+	acquired bool          // Holds a slot of limiter.
+
+	load        func() float64 // Returns the current load, if set.
+	currentLoad float64        // Load when compression started.
+
+	statusFilter func(code int) bool // Status codes that may be compressed, if set.
+
+	minCompressibility float64 // Minimum estimated compressibility, if set.
+	forceHeader        string  // Header that forces compression, if set.
+
+	ctx     context.Context // Context of the request, if set.
+	aborted bool            // The request context was done while compressing.
+
+	cache        *responseCache                              // Compressed responses, if set.
+	cacheKeyFn   func(r *http.Request, h http.Header) string // Returns the cache key, if set.
+	cacheMaxSize int                                         // Maximum size of cached responses.
+	req          *http.Request                               // The request, if cache is set.
+	cacheKey     string                                      // Cache key of the response.
+	capture      *cacheCapture                               // Records the response for the cache.
+	cacheHit     bool                                        // The response is served from the cache.
 }
 
 type GzipResponseWriterWithCloseNotify struct {
@@ -77,21 +149,60 @@ func (w GzipResponseWriterWithCloseNotify) CloseNotify() <-chan bool {
 
 // Write appends data to the gzip writer.
 func (w *GzipResponseWriter) Write(b []byte) (int, error) {
+	if w.latency != nil {
+		return w.latency.write(b)
+	}
+	return w.write(b)
+}
+
+func (w *GzipResponseWriter) write(b []byte) (int, error) {
+	if w.cacheHit {
+		return w.discard(b)
+	}
+	if err := w.checkDone(); err != nil {
+		return 0, err
+	}
 	// GZIP responseWriter is initialized. Use the GZIP responseWriter.
 	if w.gw != nil {
-		return w.gw.Write(b)
+		n, err := w.gw.Write(b)
+		if w.autoFlush && err == nil {
+			w.flush()
+		}
+		return n, err
 	}
 
 	// If we have already decided not to use GZIP, immediately passthrough.
 	if w.ignore {
-		return w.ResponseWriter.Write(b)
+		n, err := w.ResponseWriter.Write(b)
+		w.stats.Uncompressed += int64(n)
+		return n, err
+	}
+
+	// Streaming responses are compressed regardless of size,
+	// and flushed after each write.
+	minSize := w.minSize
+	if w.streamTypes != nil && len(w.buf) == 0 {
+		if ct := w.Header().Get(contentType); ct != "" && w.streamTypes(ct) {
+			w.autoFlush = true
+		}
+	}
+	forced := w.forced()
+	if w.autoFlush || forced {
+		minSize = 0
 	}
 
 	// Save the write into a buffer for later use in GZIP responseWriter
 	// (if content is long enough) or at close with regular responseWriter.
-	wantBuf := 512
-	if w.minSize > wantBuf {
-		wantBuf = w.minSize
+	wantBuf := w.bufferSize
+	if minSize > wantBuf {
+		wantBuf = minSize
+	}
+	if w.sniffLength > wantBuf && w.sniffing() {
+		wantBuf = w.sniffLength
+	}
+	if len(w.buf) > wantBuf {
+		// More was buffered for content type detection.
+		wantBuf = len(w.buf)
 	}
 	toAdd := len(b)
 	if len(w.buf)+toAdd > wantBuf {
@@ -105,17 +216,22 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 		// Check more expensive parts now.
 		cl, _ := atoi(w.Header().Get(contentLength))
 		ct := w.Header().Get(contentType)
-		if cl == 0 || cl >= w.minSize && (ct == "" || w.contentTypeFilter(ct)) {
+		if cl == 0 || cl >= minSize && !w.tooLarge(cl) && (ct == "" || forced || w.contentTypeFilter(ct)) {
 			// If the current buffer is less than minSize and a Content-Length isn't set, then wait until we have more data.
-			if len(w.buf) < w.minSize && cl == 0 {
+			if len(w.buf) < minSize && cl == 0 {
+				return len(b), nil
+			}
+
+			// Wait for more data to detect the content type, unless the response is complete.
+			if w.sniffing() && (cl == 0 || len(w.buf) < cl) {
 				return len(b), nil
 			}
 
 			// If the Content-Length is larger than minSize or the current buffer is larger than minSize, then continue.
-			if cl >= w.minSize || len(w.buf) >= w.minSize {
+			if cl >= minSize || len(w.buf) >= minSize {
 				// If a Content-Type wasn't specified, infer it from the current buffer.
 				if ct == "" {
-					ct = http.DetectContentType(w.buf)
+					ct = detectContentType(w.sniffer, w.sniffLength, w.buf)
 				}
 
 				// Handles the intended case of setting a nil Content-Type (as for http/server or http/fs)
@@ -125,15 +241,20 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 				}
 
 				// If the Content-Type is acceptable to GZIP, initialize the GZIP writer.
-				if w.contentTypeFilter(ct) {
+				if (forced || w.contentTypeFilter(ct) && w.compressible()) && w.statusAllowed() && w.acquire() {
 					if err := w.startGzip(); err != nil {
 						return 0, err
 					}
-					if len(remain) > 0 {
+					if w.cacheHit {
+						w.discard(remain)
+					} else if len(remain) > 0 {
 						if _, err := w.gw.Write(remain); err != nil {
 							return 0, err
 						}
 					}
+					if w.autoFlush {
+						w.flush()
+					}
 					return len(b), nil
 				}
 			}
@@ -144,7 +265,9 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 		return 0, err
 	}
 	if len(remain) > 0 {
-		if _, err := w.ResponseWriter.Write(remain); err != nil {
+		n, err := w.ResponseWriter.Write(remain)
+		w.stats.Uncompressed += int64(n)
+		if err != nil {
 			return 0, err
 		}
 	}
@@ -153,8 +276,15 @@ func (w *GzipResponseWriter) Write(b []byte) (int, error) {
 
 // startGzip initializes a GZIP writer and writes the buffer.
 func (w *GzipResponseWriter) startGzip() error {
-	// Set the GZIP header.
-	w.Header().Set(contentEncoding, "gzip")
+	// Set the encoding header.
+	w.Header().Set(contentEncoding, w.encoding)
+	if w.forceHeader != "" {
+		delete(w.Header(), w.forceHeader)
+	}
+	w.cacheKey = w.responseCacheKey()
+
+	// The ETag of the uncompressed response does not match the compressed one.
+	w.updateETag()
 
 	// if the Content-Length is already set, then calls to Write on gzip
 	// will fail to set the Content-Length header since its already set
@@ -165,6 +295,7 @@ func (w *GzipResponseWriter) startGzip() error {
 	if !w.keepAcceptRanges {
 		w.Header().Del(acceptRanges)
 	}
+	w.declareTrailer()
 
 	// Write the header to gzip response.
 	if w.code != 0 {
@@ -173,6 +304,12 @@ func (w *GzipResponseWriter) startGzip() error {
 		w.code = 0
 	}
 
+	if w.cacheKey != "" {
+		if hit, err := w.serveCached(w.cacheKey); hit {
+			return err
+		}
+	}
+
 	// Initialize and flush the buffer into the gzip response if there are any bytes.
 	// If there aren't any, we shouldn't initialize it yet because on Close it will
 	// write the gzip header even if nothing was ever written.
@@ -195,18 +332,26 @@ func (w *GzipResponseWriter) startGzip() error {
 
 // startPlain writes to sent bytes and buffer the underlying ResponseWriter without gzip.
 func (w *GzipResponseWriter) startPlain() error {
+	if w.code == http.StatusNotModified {
+		// Not modified responses must have the ETag of the compressed response.
+		w.updateETag()
+	}
 	if w.code != 0 {
 		w.ResponseWriter.WriteHeader(w.code)
 		// Ensure that no other WriteHeader's happen
 		w.code = 0
 	}
 	delete(w.Header(), HeaderNoCompression)
+	if w.forceHeader != "" {
+		delete(w.Header(), w.forceHeader)
+	}
 	w.ignore = true
 	// If Write was never called then don't call Write on the underlying ResponseWriter.
 	if len(w.buf) == 0 {
 		return nil
 	}
 	n, err := w.ResponseWriter.Write(w.buf)
+	w.stats.Uncompressed += int64(n)
 	// This should never happen (per io.Writer docs), but if the write didn't
 	// accept the entire buffer but returned no specific error, we have no clue
 	// what's going on, so abort just to be safe.
@@ -218,10 +363,31 @@ func (w *GzipResponseWriter) startPlain() error {
 	return err
 }
 
+// responseStats returns the stats of the response.
+func (w *GzipResponseWriter) responseStats() Stats {
+	s := w.stats
+	if s.Encoding == "" {
+		s.Compressed = s.Uncompressed
+	}
+	return s
+}
+
 // WriteHeader just saves the response code until close or GZIP effective writes.
+// Informational responses are sent immediately, and responses that cannot
+// have a body are sent without compression.
 func (w *GzipResponseWriter) WriteHeader(code int) {
-	if w.code == 0 {
-		w.code = code
+	if informational(code) {
+		if w.code == 0 && !w.ignore && w.gw == nil {
+			w.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	if w.code != 0 || w.ignore || w.gw != nil {
+		return
+	}
+	w.code = code
+	if !bodyAllowedForStatus(code) && len(w.buf) == 0 {
+		w.startPlain()
 	}
 }
 
@@ -230,12 +396,64 @@ func (w *GzipResponseWriter) WriteHeader(code int) {
 func (w *GzipResponseWriter) init() {
 	// Bytes written during ServeHTTP are redirected to this gzip writer
 	// before being written to the underlying response.
-	w.gw = w.gwFactory.New(w.ResponseWriter, w.level)
+	newWriter := w.newWriter
+	if w.levelFor != nil && w.leveled.New != nil {
+		level, ok := w.levelFor(w.encoding, w.Header().Get(contentType))
+		if min, max := w.leveled.Levels(); ok && level >= min && level <= max {
+			newLevel := w.leveled.New
+			newWriter = func(out io.Writer) writer.GzipWriter { return newLevel(out, level) }
+		}
+	}
+	if w.currentLoad > 0.5 && w.fastWriter != nil {
+		newWriter = w.fastWriter
+	}
+	if w.maxSize > 0 && w.fastWriter != nil {
+		first := newWriter
+		newWriter = func(out io.Writer) writer.GzipWriter {
+			return newCutoffWriter(first, w.fastWriter, out, w.maxSize)
+		}
+	}
+	out := io.Writer(w.ResponseWriter)
+	if w.cacheKey != "" {
+		w.capture = &cacheCapture{w: out, max: w.cacheMaxSize}
+		out = w.capture
+	}
+	if w.metrics {
+		w.stats.Encoding = w.encoding
+		m := newMeasuredWriter(newWriter, out, &w.stats)
+		w.pad(m.enc, measuredOutput{m})
+		w.gw = m
+	} else {
+		w.gw = newWriter(out)
+		w.pad(w.gw, out)
+	}
+	if w.lengthTrailer != "" || w.digest != nil {
+		w.gw = countingWriter{GzipWriter: w.gw, w: w}
+	}
 }
 
 // Close will close the gzip.Writer and will put it back in the gzipWriterPool.
 func (w *GzipResponseWriter) Close() error {
-	if w.ignore {
+	if w.latency != nil {
+		return w.latency.close()
+	}
+	return w.close()
+}
+
+func (w *GzipResponseWriter) close() error {
+	if w.ignore || w.checkDone() != nil {
+		return nil
+	}
+	if w.gw == nil && !w.cacheHit && len(w.buf) > 0 && w.sniffing() {
+		// The response ended before SniffLength bytes were written.
+		w.sniffLength = 0
+		if _, err := w.write(nil); err != nil || w.ignore {
+			return err
+		}
+	}
+	if w.cacheHit {
+		w.release()
+		w.setTrailer()
 		return nil
 	}
 
@@ -251,6 +469,13 @@ func (w *GzipResponseWriter) Close() error {
 
 	err := w.gw.Close()
 	w.gw = nil
+	w.release()
+	if err == nil {
+		w.setTrailer()
+		if w.capture != nil && !w.capture.overrun {
+			w.cache.put(w.cacheKey, w.capture.buf)
+		}
+	}
 	return err
 }
 
@@ -261,7 +486,18 @@ func (w *GzipResponseWriter) Close() error {
 // this will be ignored.
 // If nothing has been written yet, nothing will be flushed.
 func (w *GzipResponseWriter) Flush() {
-	if w.gw == nil && !w.ignore {
+	if w.latency != nil {
+		w.latency.flush()
+		return
+	}
+	w.flush()
+}
+
+func (w *GzipResponseWriter) flush() {
+	if w.checkDone() != nil {
+		return
+	}
+	if w.gw == nil && !w.ignore && !w.cacheHit {
 		if len(w.buf) == 0 {
 			// Nothing written yet.
 			return
@@ -274,7 +510,7 @@ func (w *GzipResponseWriter) Flush() {
 		)
 
 		if ct == "" {
-			ct = http.DetectContentType(w.buf)
+			ct = detectContentType(w.sniffer, w.sniffLength, w.buf)
 
 			// Handles the intended case of setting a nil Content-Type (as for http/server or http/fs)
 			// Set the header only if the key does not exist
@@ -288,7 +524,9 @@ func (w *GzipResponseWriter) Flush() {
 		}
 
 		// See if we should compress...
-		if len(w.Header()[HeaderNoCompression]) == 0 && ce == "" && cr == "" && cl >= w.minSize && w.contentTypeFilter(ct) {
+		forced := w.forced()
+		if len(w.Header()[HeaderNoCompression]) == 0 && ce == "" && cr == "" && (forced || cl >= w.minSize) && !w.tooLarge(cl) &&
+			(forced || w.contentTypeFilter(ct) && w.compressible()) && w.statusAllowed() && w.acquire() {
 			w.startGzip()
 		} else {
 			w.startPlain()
@@ -316,6 +554,58 @@ func (w *GzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 // verify Hijacker interface implementation
 var _ http.Hijacker = &GzipResponseWriter{}
 
+// Push implements http.Pusher. If the underlying ResponseWriter is a
+// Pusher, its Push method is called. Otherwise http.ErrNotSupported is returned.
+func (w *GzipResponseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// verify Pusher interface implementation
+var _ http.Pusher = &GzipResponseWriter{}
+
+// Unwrap returns the underlying ResponseWriter.
+// This allows http.ResponseController to reach it,
+// for instance to set deadlines.
+// Writes should not be made to the returned ResponseWriter.
+func (w *GzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// SetReadDeadline sets the read deadline of the underlying ResponseWriter,
+// following Unwrap methods like http.ResponseController.
+// If it is not supported, http.ErrNotSupported is returned.
+func (w *GzipResponseWriter) SetReadDeadline(deadline time.Time) error {
+	for rw := w.ResponseWriter; ; {
+		switch t := rw.(type) {
+		case interface{ SetReadDeadline(time.Time) error }:
+			return t.SetReadDeadline(deadline)
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = t.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
+	}
+}
+
+// SetWriteDeadline sets the write deadline of the underlying ResponseWriter,
+// following Unwrap methods like http.ResponseController.
+// If it is not supported, http.ErrNotSupported is returned.
+func (w *GzipResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	for rw := w.ResponseWriter; ; {
+		switch t := rw.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			return t.SetWriteDeadline(deadline)
+		case interface{ Unwrap() http.ResponseWriter }:
+			rw = t.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
+	}
+}
+
 var onceDefault sync.Once
 var defaultWrapper func(http.Handler) http.Handler
 
@@ -332,18 +622,57 @@ func GzipHandler(h http.Handler) http.Handler {
 	return defaultWrapper(h)
 }
 
-var grwPool = sync.Pool{New: func() interface{} { return &GzipResponseWriter{} }}
+// Pool is a pool of response writers.
+// Get must return a *GzipResponseWriter or nil.
+// *sync.Pool and *budget.Pool can be used.
+type Pool interface {
+	Get() interface{}
+	Put(x interface{})
+}
+
+// NewPool returns a pool of response writers, limited by the budget package.
+// Each wrapper has its own pool by default. Use WriterPool to share
+// a pool between wrappers explicitly.
+func NewPool() Pool {
+	return &budget.Pool{
+		Name: "gzhttp.ResponseWriter",
+		New:  func() interface{} { return &GzipResponseWriter{} },
+		Size: func(x interface{}) int64 {
+			return int64(unsafe.Sizeof(GzipResponseWriter{})) + int64(cap(x.(*GzipResponseWriter).buf))
+		},
+	}
+}
 
 // NewWrapper returns a reusable wrapper with the supplied options.
 func NewWrapper(opts ...option) (func(http.Handler) http.Handler, error) {
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrap, nil
+}
+
+// newConfig returns a validated configuration with the supplied options.
+func newConfig(opts ...option) (*config, error) {
 	c := &config{
-		level:   gzip.DefaultCompression,
-		minSize: DefaultMinSize,
+		level:      gzip.DefaultCompression,
+		minSize:    DefaultMinSize,
+		bufferSize: DefaultBufferSize,
+		pool:       NewPool(),
 		writer: writer.GzipWriterFactory{
 			Levels: gzkp.Levels,
 			New:    gzkp.NewWriter,
 		},
 		contentTypes: DefaultContentTypeFilter,
+		forceHeader:  HeaderForceCompression,
+		streamTypes:  streamingFilter(DefaultStreamingContentTypes),
+		zstd: zstdConfig{
+			level: int(zstd.SpeedDefault),
+			writer: writer.GzipWriterFactory{
+				Levels: zstdkp.Levels,
+				New:    zstdkp.NewWriter,
+			},
+		},
 	}
 
 	for _, o := range opts {
@@ -353,42 +682,123 @@ func NewWrapper(opts ...option) (func(http.Handler) http.Handler, error) {
 	if err := c.validate(); err != nil {
 		return nil, err
 	}
+	c.initEncodings()
+	if err := c.initRules(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
 
-	return func(h http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add(vary, acceptEncoding)
-			if acceptsGzip(r) {
-				gw := grwPool.Get().(*GzipResponseWriter)
-				*gw = GzipResponseWriter{
-					ResponseWriter:    w,
-					gwFactory:         c.writer,
-					level:             c.level,
-					minSize:           c.minSize,
-					contentTypeFilter: c.contentTypes,
-					keepAcceptRanges:  c.keepAcceptRanges,
-					buf:               gw.buf,
+// wrap returns h wrapped with compression.
+func (c *config) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := c.forPath(r.URL.Path)
+		if c.disabled || c.requestFilter != nil && !c.requestFilter(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h := h
+		if c.decompressUnaccepted {
+			h = decompressHandler(h)
+		}
+		w.Header().Add(vary, acceptEncoding)
+		if len(c.dictionaries) > 0 {
+			w.Header().Add(vary, availableDictionary)
+		}
+		if c.useAsDictionary != "" {
+			w.Header().Set(useAsDictionary, c.useAsDictionary)
+		}
+		if c.uncompressedRanges && r.Header.Get(rangeHeader) != "" {
+			// Serve the range of the uncompressed response.
+			h.ServeHTTP(w, r)
+			return
+		}
+		enc := c.negotiate(r)
+		if enc == nil && c.notAcceptable != nil && r.Method != http.MethodHead &&
+			identityForbidden(r.Header.Get(acceptEncoding)) {
+			c.notAcceptable.ServeHTTP(w, r)
+			return
+		}
+		if enc != nil {
+			gw, _ := c.pool.Get().(*GzipResponseWriter)
+			if gw == nil {
+				gw = &GzipResponseWriter{}
+			}
+			*gw = GzipResponseWriter{
+				ResponseWriter:     w,
+				newWriter:          enc.newWriter,
+				fastWriter:         enc.fastWriter,
+				leveled:            enc.leveled,
+				levelFor:           c.levelFor,
+				maxSize:            c.maxCompressSize,
+				encoding:           enc.token,
+				minSize:            c.minSize,
+				bufferSize:         c.bufferSize,
+				contentTypeFilter:  c.contentTypes,
+				sniffer:            c.sniffer,
+				sniffLength:        c.sniffLength,
+				keepAcceptRanges:   c.keepAcceptRanges || c.uncompressedRanges,
+				metrics:            c.metrics != nil || c.latencyLoad != nil,
+				jitter:             c.jitter,
+				gzipHeader:         c.gzipHeader,
+				streamTypes:        c.streamTypes,
+				weakETag:           c.weakETag,
+				suffixETag:         c.suffixETag,
+				lengthTrailer:      c.lengthTrailer,
+				digestTrailer:      c.digestTrailer,
+				digestAlgorithm:    c.digestAlgorithm,
+				limiter:            c.limiter,
+				load:               c.load,
+				cache:              c.cache,
+				statusFilter:       c.statusFilter,
+				minCompressibility: c.minCompressibility,
+				forceHeader:        c.forceHeader,
+				cacheKeyFn:         c.cacheKey,
+				cacheMaxSize:       c.cacheMaxSize,
+				buf:                gw.buf,
+			}
+			if len(gw.buf) > 0 {
+				gw.buf = gw.buf[:0]
+			}
+			gw.ctx = r.Context()
+			if c.cache != nil {
+				gw.req = r
+			}
+			if c.flushInterval < 0 {
+				gw.autoFlush = true
+			} else if c.flushInterval > 0 {
+				gw.latency = newLatencyFlusher(gw, c.flushInterval)
+			}
+			defer func() {
+				gw.Close()
+				if c.metrics != nil {
+					c.metrics(gw.responseStats())
 				}
-				if len(gw.buf) > 0 {
-					gw.buf = gw.buf[:0]
+				if c.latencyLoad != nil {
+					c.latencyLoad.observe(gw.stats.Duration)
 				}
-				defer func() {
-					gw.Close()
-					gw.ResponseWriter = nil
-					grwPool.Put(gw)
-				}()
-
-				if _, ok := w.(http.CloseNotifier); ok {
-					gwcn := GzipResponseWriterWithCloseNotify{gw}
-					h.ServeHTTP(gwcn, r)
-				} else {
-					h.ServeHTTP(gw, r)
+				gw.ResponseWriter = nil
+				gw.req, gw.capture, gw.ctx = nil, nil, nil
+				if c.maxRetainedBuffer > 0 && cap(gw.buf) > c.maxRetainedBuffer {
+					gw.buf = nil
 				}
+				c.pool.Put(gw)
+			}()
 
+			if c.suffixETag {
+				r = stripETagSuffix(r, enc.token)
+			}
+			if _, ok := w.(http.CloseNotifier); ok {
+				gwcn := GzipResponseWriterWithCloseNotify{gw}
+				h.ServeHTTP(gwcn, r)
 			} else {
-				h.ServeHTTP(w, r)
+				h.ServeHTTP(gw, r)
 			}
-		})
-	}, nil
+
+		} else {
+			h.ServeHTTP(w, r)
+		}
+	})
 }
 
 // Parsed representation of one of the inputs to ContentTypes.
@@ -422,11 +832,73 @@ func (pct parsedContentType) equals(mediaType string, params map[string]string)
 
 // Used for functional configuration.
 type config struct {
-	minSize          int
-	level            int
-	writer           writer.GzipWriterFactory
-	contentTypes     func(ct string) bool
-	keepAcceptRanges bool
+	minSize              int
+	level                int
+	writer               writer.GzipWriterFactory
+	contentTypes         func(ct string) bool
+	keepAcceptRanges     bool
+	uncompressedRanges   bool
+	maxCompressSize      int64
+	bufferSize           int
+	maxRetainedBuffer    int
+	pool                 Pool
+	levelFor             func(encoding, ct string) (level int, ok bool)
+	requestFilter        func(r *http.Request) bool
+	notAcceptable        http.Handler
+	zstd                 zstdConfig
+	registered           []encoding
+	metrics              func(Stats)
+	disabled             bool
+	jitter               int
+	gzipHeader           *writer.Header
+	weakETag             bool
+	streamTypes          func(ct string) bool
+	suffixETag           bool
+	rules                []pathRule
+	dictionaries         []dictionary
+	useAsDictionary      string
+	flushInterval        time.Duration
+	decompressUnaccepted bool
+	lengthTrailer        string
+	digestTrailer        string
+	digestAlgorithm      string
+	maxConcurrent        int
+	limiter              chan struct{}
+	load                 func() float64
+	latencyLoad          *latencyLoad
+	cache                *responseCache
+	cacheEntries         int
+	cacheMaxSize         int
+	cacheKey             func(r *http.Request, h http.Header) string
+	statusFilter         func(code int) bool
+	minCompressibility   float64
+	forceHeader          string
+	sniffer              func(b []byte) string
+	sniffLength          int
+
+	// encodings contains the enabled encodings in order of preference.
+	encodings []encoding
+}
+
+// encoding is a content coding that responses can be compressed with.
+type encoding struct {
+	token     string
+	newWriter writer.EncoderFactory
+
+	// fastWriter returns a writer using the fastest level.
+	// It is nil for registered encodings.
+	fastWriter writer.EncoderFactory
+
+	// leveled creates writers with a specific level.
+	// It is empty for registered encodings.
+	leveled writer.GzipWriterFactory
+}
+
+// zstdConfig contains the zstd configuration.
+type zstdConfig struct {
+	enabled bool
+	level   int
+	writer  writer.GzipWriterFactory
 }
 
 func (c *config) validate() error {
@@ -435,13 +907,162 @@ func (c *config) validate() error {
 		return fmt.Errorf("invalid compression level requested: %d, valid range %d -> %d", c.level, min, max)
 	}
 
+	if c.zstd.enabled {
+		min, max := c.zstd.writer.Levels()
+		if c.zstd.level < min || c.zstd.level > max {
+			return fmt.Errorf("invalid zstd compression level requested: %d, valid range %d -> %d", c.zstd.level, min, max)
+		}
+	}
+
 	if c.minSize < 0 {
 		return fmt.Errorf("minimum size must be more than zero")
 	}
 
+	if c.bufferSize <= 0 {
+		return fmt.Errorf("buffer size must be more than zero")
+	}
+
+	if c.sniffLength < 0 {
+		return fmt.Errorf("sniff length must not be negative")
+	}
+
+	if c.maxRetainedBuffer < 0 {
+		return fmt.Errorf("maximum retained buffer size must not be negative")
+	}
+
+	if c.pool == nil {
+		return fmt.Errorf("pool must not be nil")
+	}
+
+	if c.maxCompressSize < 0 {
+		return fmt.Errorf("maximum compress size must not be negative")
+	}
+
+	if c.jitter < 0 || c.jitter > MaxJitter {
+		return fmt.Errorf("jitter must be between 0 and %d", MaxJitter)
+	}
+
+	if c.gzipHeader != nil {
+		if err := validateGzipHeader(*c.gzipHeader); err != nil {
+			return err
+		}
+	}
+
+	if c.latencyLoad != nil && c.latencyLoad.target <= 0 {
+		return fmt.Errorf("adaptive latency target must be positive")
+	}
+
+	if c.cacheEntries < 0 || c.cacheMaxSize < 0 {
+		return fmt.Errorf("cache size must not be negative")
+	}
+
+	if c.maxConcurrent < 0 {
+		return fmt.Errorf("maximum concurrent compressions must not be negative")
+	}
+
+	if c.lengthTrailer != "" && !validToken(c.lengthTrailer) {
+		return fmt.Errorf("invalid trailer name: %q", c.lengthTrailer)
+	}
+
+	if c.digestTrailer != "" {
+		if !validToken(c.digestTrailer) {
+			return fmt.Errorf("invalid trailer name: %q", c.digestTrailer)
+		}
+		if newDigest(c.digestAlgorithm) == nil {
+			return fmt.Errorf("unsupported digest algorithm: %q", c.digestAlgorithm)
+		}
+	}
+
+	if err := c.validateDictionaries(); err != nil {
+		return err
+	}
+
+	for _, e := range c.registered {
+		if !validToken(e.token) {
+			return fmt.Errorf("invalid content coding token: %q", e.token)
+		}
+		if e.newWriter == nil {
+			return fmt.Errorf("nil encoder factory for content coding %q", e.token)
+		}
+	}
+
 	return nil
 }
 
+// initEncodings sets up the encodings in order of preference.
+// Registered encodings come first, in the order they were registered,
+// followed by zstd, if enabled, and gzip.
+// A registered encoding replaces a built-in one with the same token.
+func (c *config) initEncodings() {
+	c.encodings = c.encodings[:0]
+	index := func(token string) int {
+		for i, e := range c.encodings {
+			if e.token == token {
+				return i
+			}
+		}
+		return -1
+	}
+	for _, e := range c.registered {
+		// Later registrations replace earlier ones.
+		if i := index(e.token); i >= 0 {
+			c.encodings[i] = e
+			continue
+		}
+		c.encodings = append(c.encodings, e)
+	}
+	if c.zstd.enabled && index(encodingZstd) < 0 {
+		zw, level := c.zstd.writer, c.zstd.level
+		fast := clampLevel(int(zstd.SpeedFastest), zw.Levels)
+		c.encodings = append(c.encodings, encoding{
+			token:      encodingZstd,
+			newWriter:  func(w io.Writer) writer.GzipWriter { return zw.New(w, level) },
+			fastWriter: func(w io.Writer) writer.GzipWriter { return zw.New(w, fast) },
+			leveled:    zw,
+		})
+	}
+	if index(encodingGzip) < 0 {
+		gw, level := c.writer, c.level
+		fast := clampLevel(gzip.BestSpeed, gw.Levels)
+		c.encodings = append(c.encodings, encoding{
+			token:      encodingGzip,
+			newWriter:  func(w io.Writer) writer.GzipWriter { return gw.New(w, level) },
+			fastWriter: func(w io.Writer) writer.GzipWriter { return gw.New(w, fast) },
+			leveled:    gw,
+		})
+	}
+	c.initDictionaries()
+}
+
+// clampLevel returns level limited to the range returned by levels.
+func clampLevel(level int, levels func() (min, max int)) int {
+	min, max := levels()
+	if level < min {
+		return min
+	}
+	if level > max {
+		return max
+	}
+	return level
+}
+
+// validToken returns whether s is a valid HTTP token.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return s != "*"
+}
+
 type option func(c *config)
 
 func MinSize(size int) option {
@@ -501,6 +1122,42 @@ func ContentTypes(types []string) option {
 	}
 }
 
+// DefaultStreamingContentTypes are the default content types
+// that are flushed after each write. See StreamingContentTypes.
+var DefaultStreamingContentTypes = []string{"text/event-stream"}
+
+// StreamingContentTypes sets the content types of streaming responses.
+// Streaming responses are compressed regardless of the minimum size,
+// and flushed to the client after each Write, so events are not kept
+// in the compressor. The content type must be set by the handler before
+// the first Write.
+//
+// Content types are matched as with ContentTypes.
+// The default is DefaultStreamingContentTypes.
+// Setting this to an empty list disables automatic flushing.
+func StreamingContentTypes(types []string) option {
+	return func(c *config) {
+		c.streamTypes = streamingFilter(types)
+	}
+}
+
+// streamingFilter returns a filter matching types, or nil if types is empty.
+func streamingFilter(types []string) func(ct string) bool {
+	var contentTypes []parsedContentType
+	for _, v := range types {
+		mediaType, params, err := mime.ParseMediaType(v)
+		if err == nil {
+			contentTypes = append(contentTypes, parsedContentType{mediaType, params})
+		}
+	}
+	if len(contentTypes) == 0 {
+		return nil
+	}
+	return func(ct string) bool {
+		return handleContentType(contentTypes, ct)
+	}
+}
+
 // ExceptContentTypes specifies a list of content types to compare
 // the Content-Type header to before compressing. If none
 // match, the response will be compressed.
@@ -535,6 +1192,155 @@ func ExceptContentTypes(types []string) option {
 	}
 }
 
+// EnableZstd enables zstd compression for clients that accept it.
+// zstd is used if the client accepts it with a qvalue at least as high as gzip,
+// otherwise gzip is used.
+// The minimum size and content type settings apply to both encodings.
+func EnableZstd() option {
+	return func(c *config) {
+		c.zstd.enabled = true
+	}
+}
+
+// ZstdCompressionLevel sets the zstd compression level.
+// The default is zstd.SpeedDefault.
+func ZstdCompressionLevel(level zstd.EncoderLevel) option {
+	return func(c *config) {
+		c.zstd.level = int(level)
+	}
+}
+
+// ZstdImplementation changes the implementation of the zstd writer.
+// The levels of the writer must be zstd.EncoderLevel values.
+//
+// The default implementation is writer/zstdkp/NewWriter.
+func ZstdImplementation(writer writer.GzipWriterFactory) option {
+	return func(c *config) {
+		c.zstd.writer = writer
+	}
+}
+
+// RegisterEncoding adds a content coding that responses can be compressed with.
+// The token is the value used in the Accept-Encoding and Content-Encoding headers,
+// for example "br". Tokens are case-insensitive.
+//
+// The encoding with the highest qvalue accepted by the client is used.
+// If several are accepted with the same qvalue, registered encodings are
+// preferred in the order they were registered, followed by zstd and gzip.
+// Registering "gzip" or "zstd" replaces the built-in implementation.
+//
+// The minimum size and content type settings apply to all encodings.
+func RegisterEncoding(token string, factory writer.EncoderFactory) option {
+	return func(c *config) {
+		c.registered = append(c.registered, encoding{
+			token:     strings.ToLower(token),
+			newWriter: factory,
+		})
+	}
+}
+
+// NotAcceptable sets a handler that is called instead of the wrapped handler
+// when a client forbids uncompressed responses, with "identity;q=0" or "*;q=0",
+// and accepts none of the enabled encodings.
+// If h is nil, a "406 Not Acceptable" response is sent.
+//
+// By default the Accept-Encoding header is disregarded in this case,
+// and an uncompressed response is sent, as allowed by RFC 9110.
+// Note that responses may also be uncompressed because of their size
+// or content type, regardless of this setting.
+func NotAcceptable(h http.Handler) option {
+	return func(c *config) {
+		if h == nil {
+			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, http.StatusText(http.StatusNotAcceptable), http.StatusNotAcceptable)
+			})
+		}
+		c.notAcceptable = h
+	}
+}
+
+// RequestFilter sets a function that decides whether responses
+// to a request may be compressed. If it returns false, the handler
+// is called with the original ResponseWriter and nothing is buffered.
+// This can be used to skip compression for health checks,
+// clients with broken decoders, or based on authentication.
+//
+// The function may be called concurrently.
+func RequestFilter(fn func(r *http.Request) bool) option {
+	return func(c *config) {
+		c.requestFilter = fn
+	}
+}
+
+// ContentTypeLevel sets a function that returns the compression level
+// for a response with the content type ct, compressed with encoding.
+// If ok is false, or the level is invalid for the encoding,
+// the level of the wrapper is used.
+// Levels are not used for encodings added with RegisterEncoding.
+//
+// The function is called when compression starts,
+// and may be called concurrently.
+func ContentTypeLevel(fn func(encoding, ct string) (level int, ok bool)) option {
+	return func(c *config) {
+		c.levelFor = fn
+	}
+}
+
+// ContentTypeLevels sets the gzip compression level for media types,
+// for example {"application/json": gzip.BestSpeed, "text/html": 6}.
+// Content types that are not listed use the level of the wrapper.
+// Media types are compared without parameters.
+// See ContentTypeLevel for setting levels of other encodings.
+func ContentTypeLevels(levels map[string]int) option {
+	byType := make(map[string]int, len(levels))
+	for ct, level := range levels {
+		if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+			byType[mediaType] = level
+		}
+	}
+	return ContentTypeLevel(func(encoding, ct string) (int, bool) {
+		if encoding != encodingGzip {
+			return 0, false
+		}
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return 0, false
+		}
+		level, ok := byType[mediaType]
+		return level, ok
+	})
+}
+
+// BufferSize sets the maximum number of bytes of the first writes that are
+// buffered before deciding whether to compress a response, if the minimum
+// size is smaller. The buffered data is used to detect the content type,
+// if it is not set. The default is DefaultBufferSize.
+func BufferSize(n int) option {
+	return func(c *config) {
+		c.bufferSize = n
+	}
+}
+
+// MaxRetainedBuffer sets the largest buffer capacity kept when
+// response writers are returned to the pool.
+// Larger buffers are released.
+// The default, 0, keeps all buffers.
+func MaxRetainedBuffer(n int) option {
+	return func(c *config) {
+		c.maxRetainedBuffer = n
+	}
+}
+
+// WriterPool sets the pool used for response writers.
+// This can be used to share or limit the pooled writers.
+// By default each wrapper uses its own pool, created with NewPool,
+// so buffers sized by one wrapper are not used by another.
+func WriterPool(p Pool) option {
+	return func(c *config) {
+		c.pool = p
+	}
+}
+
 // KeepAcceptRanges will keep Accept-Ranges header on gzipped responses.
 // This will likely break ranged requests since that cannot be transparently
 // handled by the filter.
@@ -544,6 +1350,23 @@ func KeepAcceptRanges() option {
 	}
 }
 
+// UncompressedRanges will serve requests with a Range header without compression,
+// so the ranges refer to the uncompressed response.
+// Accept-Ranges headers are kept on compressed responses,
+// so clients know that range requests are supported.
+//
+// For FileServer this also means that precompressed files
+// are not used for range requests.
+//
+// Since a compressed and an uncompressed response may have the same ETag,
+// consider using WeakETag or SuffixETag, so If-Range requests for
+// a compressed response will return the full response.
+func UncompressedRanges() option {
+	return func(c *config) {
+		c.uncompressedRanges = true
+	}
+}
+
 // ContentTypeFilter allows adding a custom content type filter.
 //
 // The supplied function must return true/false to indicate if content
@@ -559,14 +1382,66 @@ func ContentTypeFilter(compress func(ct string) bool) option {
 	}
 }
 
-// acceptsGzip returns true if the given HTTP request indicates that it will
-// accept a gzipped response.
-func acceptsGzip(r *http.Request) bool {
-	// Note that we don't request this for HEAD requests,
+// negotiate returns the encoding to use for the response,
+// or nil if the response should not be compressed.
+func (c *config) negotiate(r *http.Request) *encoding {
+	// Note that we don't compress HEAD requests,
 	// due to a bug in nginx:
 	//   https://trac.nginx.org/nginx/ticket/358
 	//   https://golang.org/issue/5522
-	return r.Method != http.MethodHead && parseEncodingGzip(r.Header.Get(acceptEncoding)) > 0
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	ae := r.Header.Get(acceptEncoding)
+	if ae == "" {
+		return nil
+	}
+	// Dictionary compression is preferred when it is available.
+	best, bestQ := c.dictionaryEncoding(r, ae)
+	for i := range c.encodings {
+		e := &c.encodings[i]
+		if q := encodingQ(ae, e.token); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	if best == nil || identityPreferred(ae, bestQ) {
+		return nil
+	}
+	return best
+}
+
+// encodingQ returns the qvalue of coding in the Accept-Encoding value s.
+// Codings that are not listed get the qvalue of "*", if present.
+// A qvalue of 0 means the coding is not acceptable.
+func encodingQ(s, coding string) float64 {
+	if q, ok := findEncoding(s, coding); ok {
+		return q
+	}
+	q, _ := findEncoding(s, "*")
+	return q
+}
+
+// identityPreferred returns whether the Accept-Encoding value s
+// prefers identity, meaning no encoding, over an encoding with qvalue q.
+// Identity is only preferred if it, or "*", is listed with a higher qvalue.
+func identityPreferred(s string, q float64) bool {
+	iq, ok := findEncoding(s, "identity")
+	if !ok {
+		iq, ok = findEncoding(s, "*")
+	}
+	return ok && iq > q
+}
+
+// identityForbidden returns whether the Accept-Encoding value s
+// forbids identity, meaning no encoding.
+// This is the case if identity, or "*" when identity is not listed,
+// has a qvalue of 0.
+func identityForbidden(s string) bool {
+	iq, ok := findEncoding(s, "identity")
+	if !ok {
+		iq, ok = findEncoding(s, "*")
+	}
+	return ok && iq == 0
 }
 
 // returns true if we've been configured to compress the specific content type.
@@ -592,6 +1467,18 @@ func handleContentType(contentTypes []parsedContentType, ct string) bool {
 
 // parseEncodingGzip returns the qvalue of gzip compression.
 func parseEncodingGzip(s string) float64 {
+	return parseEncoding(s, encodingGzip)
+}
+
+// parseEncoding returns the qvalue of the coding.
+func parseEncoding(s, coding string) float64 {
+	q, _ := findEncoding(s, coding)
+	return q
+}
+
+// findEncoding returns the qvalue of the coding,
+// and whether the coding was listed.
+func findEncoding(s, coding string) (float64, bool) {
 	s = strings.TrimSpace(s)
 
 	for len(s) > 0 {
@@ -599,17 +1486,17 @@ func parseEncodingGzip(s string) float64 {
 		if stop < 0 {
 			stop = len(s)
 		}
-		coding, qvalue, _ := parseCoding(s[:stop])
+		c, qvalue, _ := parseCoding(s[:stop])
 
-		if coding == "gzip" {
-			return qvalue
+		if c == coding {
+			return qvalue, true
 		}
 		if stop == len(s) {
 			break
 		}
 		s = s[stop+1:]
 	}
-	return 0
+	return 0, false
 }
 
 func parseEncodings(s string) (codings, error) {
@@ -3,15 +3,22 @@ package gzhttp
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzhttp/writer"
+	"github.com/klauspost/compress/gzhttp/writer/gzkp"
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
@@ -45,6 +52,44 @@ func TestParseEncodings(t *testing.T) {
 	}
 }
 
+func TestNegotiate(t *testing.T) {
+	c, err := newConfig(EnableZstd(), RegisterEncoding("br", newDeflateWriter))
+	assertNil(t, err)
+	tests := map[string]string{
+		"":                              "",
+		"gzip":                          "gzip",
+		"GZIP":                          "gzip",
+		"zstd, gzip":                    "zstd",
+		"deflate":                       "",
+		"gzip;q=0":                      "",
+		"gzip;q=0.5, zstd;q=0.8":        "zstd",
+		"gzip, zstd, br":                "br",
+		"gzip, zstd;q=1.0, br;q=0.9":    "zstd",
+		"*":                             "br",
+		"*;q=0.5, gzip":                 "gzip",
+		"*, br;q=0, zstd;q=0":           "gzip",
+		"*;q=0":                         "",
+		"gzip;q=0.5, identity":          "",
+		"gzip;q=0.5, identity;q=0.5":    "gzip",
+		"gzip;q=0.5, *":                 "br",
+		"gzip;q=0.5, *;q=0.1":           "gzip",
+		"gzip, identity;q=0":            "gzip",
+		"gzip;q=0.001, identity;q=0":    "gzip",
+		"zstd;q=0, gzip;q=0, br;q=0, *": "",
+	}
+	for ae, want := range tests {
+		t.Run(ae, func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Encoding", ae)
+			var got string
+			if e := c.negotiate(r); e != nil {
+				got = e.token
+			}
+			assertEqual(t, want, got)
+		})
+	}
+}
+
 func TestMustNewGzipHandler(t *testing.T) {
 	// This just exists to provide something for GzipHandler to wrap.
 	handler := newTestHandler(testBody)
@@ -179,6 +224,34 @@ func TestGzipHandlerKeepAcceptRange(t *testing.T) {
 	assertEqual(t, testBody, got)
 }
 
+func TestUncompressedRanges(t *testing.T) {
+	wrapper, err := NewWrapper(UncompressedRanges())
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "a.txt", time.Time{}, bytes.NewReader(testBody))
+	}))
+
+	// Full responses are compressed, but keep Accept-Ranges.
+	req, _ := http.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	res := resp.Result()
+	assertEqual(t, http.StatusOK, res.StatusCode)
+	assertEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+	assertEqual(t, "bytes", res.Header.Get("Accept-Ranges"))
+
+	// Ranges of the uncompressed response.
+	req.Header.Set("Range", "bytes=10-19")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	res = resp.Result()
+	assertEqual(t, http.StatusPartialContent, res.StatusCode)
+	assertEqual(t, "", res.Header.Get("Content-Encoding"))
+	assertEqual(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assertEqual(t, testBody[10:20], resp.Body.Bytes())
+}
+
 func TestNewGzipLevelHandler(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -437,7 +510,9 @@ func TestGzipHandlerDoubleWriteHeader(t *testing.T) {
 	}
 	assertEqual(t, 0, len(body))
 	header := rec.Header()
-	assertEqual(t, "gzip", header.Get("Content-Encoding"))
+	// Responses without a body are not compressed.
+	assertEqual(t, "", header.Get("Content-Encoding"))
+	assertEqual(t, "15000", header.Get("Content-Length"))
 	assertEqual(t, "Accept-Encoding", header.Get("Vary"))
 	assertEqual(t, 304, rec.Code)
 }
@@ -524,6 +599,39 @@ func (m *mockRWCloseNotify) WriteHeader(int) {
 	panic("implement me")
 }
 
+type mockRWPusher struct {
+	http.ResponseWriter
+	pushed []string
+}
+
+func (m *mockRWPusher) Push(target string, opts *http.PushOptions) error {
+	m.pushed = append(m.pushed, target)
+	return nil
+}
+
+func TestPusher(t *testing.T) {
+	var pushErr error
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := w.(http.Pusher)
+		if !ok {
+			t.Fatal("response writer is not a http.Pusher")
+		}
+		pushErr = p.Push("/style.css", nil)
+		w.Write(testBody)
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	rw := &mockRWPusher{ResponseWriter: httptest.NewRecorder()}
+	handler.ServeHTTP(rw, req)
+	assertNil(t, pushErr)
+	assertEqual(t, []string{"/style.css"}, rw.pushed)
+
+	// Not supported by the underlying writer.
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assertEqual(t, http.ErrNotSupported, pushErr)
+}
+
 func TestIgnoreSubsequentWriteHeader(t *testing.T) {
 	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
@@ -1084,6 +1192,149 @@ func newTestHandlerLevel(body []byte, level int) http.Handler {
 	}))
 }
 
+func TestZstdHandler(t *testing.T) {
+	wrapper, err := NewWrapper(EnableZstd())
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	}))
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "gzip, zstd", want: "zstd"},
+		{accept: "zstd", want: "zstd"},
+		{accept: "gzip;q=1, zstd;q=0.5", want: "gzip"},
+		{accept: "gzip;q=0.5, zstd", want: "zstd"},
+		{accept: "gzip, zstd;q=0", want: "gzip"},
+		{accept: "deflate", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/whatever", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, test.want, res.Header.Get("Content-Encoding"))
+			assertEqual(t, "Accept-Encoding", res.Header.Get("Vary"))
+			if test.want != "zstd" {
+				return
+			}
+			dec, err := zstd.NewReader(res.Body)
+			assertNil(t, err)
+			defer dec.Close()
+			got, err := ioutil.ReadAll(dec)
+			assertNil(t, err)
+			assertEqual(t, testBody, got)
+		})
+	}
+}
+
+func TestZstdHandlerDisabled(t *testing.T) {
+	handler := newTestHandler(testBody)
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "zstd, gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertEqual(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+
+	req.Header.Set("Accept-Encoding", "zstd")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertEqual(t, "", resp.Result().Header.Get("Content-Encoding"))
+}
+
+func TestZstdCompressionLevelInvalid(t *testing.T) {
+	_, err := NewWrapper(EnableZstd(), ZstdCompressionLevel(zstd.EncoderLevel(100)))
+	assertNotNil(t, err)
+	_, err = NewWrapper(EnableZstd(), ZstdCompressionLevel(zstd.SpeedBestCompression))
+	assertNil(t, err)
+}
+
+func newDeflateWriter(w io.Writer) writer.GzipWriter {
+	fw, err := flate.NewWriter(w, flate.BestSpeed)
+	if err != nil {
+		panic(err)
+	}
+	return fw
+}
+
+func TestRegisterEncoding(t *testing.T) {
+	wrapper, err := NewWrapper(RegisterEncoding("Deflate", newDeflateWriter), EnableZstd())
+	assertNil(t, err)
+	var body []byte
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "gzip, zstd, deflate", want: "deflate"},
+		{accept: "deflate;q=0.5, gzip", want: "gzip"},
+		{accept: "deflate;q=0.5, zstd", want: "zstd"},
+		{accept: "DEFLATE", want: "deflate"},
+		{accept: "br", want: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/whatever", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+
+			body = smallTestBody
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, "", res.Header.Get("Content-Encoding"))
+			assertEqual(t, "Accept-Encoding", res.Header.Get("Vary"))
+
+			body = testBody
+			resp = httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res = resp.Result()
+			assertEqual(t, test.want, res.Header.Get("Content-Encoding"))
+			assertEqual(t, "Accept-Encoding", res.Header.Get("Vary"))
+			if test.want != "deflate" {
+				return
+			}
+			got, err := ioutil.ReadAll(flate.NewReader(res.Body))
+			assertNil(t, err)
+			assertEqual(t, testBody, got)
+		})
+	}
+}
+
+func TestRegisterEncodingReplace(t *testing.T) {
+	var called int
+	wrapper, err := NewWrapper(RegisterEncoding("gzip", func(w io.Writer) writer.GzipWriter {
+		called++
+		gw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		return gw
+	}))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	}))
+	req, _ := http.NewRequest("GET", "/whatever", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertEqual(t, "gzip", resp.Result().Header.Get("Content-Encoding"))
+	assertEqual(t, 1, called)
+}
+
+func TestRegisterEncodingInvalid(t *testing.T) {
+	for _, token := range []string{"", "*", "a b", "a,b", "a;q=1"} {
+		_, err := NewWrapper(RegisterEncoding(token, newDeflateWriter))
+		assertNotNil(t, err)
+	}
+	_, err := NewWrapper(RegisterEncoding("br", nil))
+	assertNotNil(t, err)
+}
+
 func TestGzipHandlerNilContentType(t *testing.T) {
 	// This just exists to provide something for GzipHandler to wrap.
 	handler := newTestHandler(testBody)
@@ -1098,3 +1349,268 @@ func TestGzipHandlerNilContentType(t *testing.T) {
 
 	assertEqual(t, "", res.Header().Get("Content-Type"))
 }
+
+type countingPool struct {
+	sync.Pool
+	gets, puts int
+	last       *GzipResponseWriter
+}
+
+func (p *countingPool) Get() interface{} {
+	p.gets++
+	return p.Pool.Get()
+}
+
+func (p *countingPool) Put(x interface{}) {
+	p.puts++
+	p.last = x.(*GzipResponseWriter)
+	p.Pool.Put(x)
+}
+
+func TestWriterPool(t *testing.T) {
+	pool := &countingPool{}
+	wrapper, err := NewWrapper(WriterPool(pool), MaxRetainedBuffer(100))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	for i := 0; i < 3; i++ {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+	}
+	assertEqual(t, 3, pool.gets)
+	assertEqual(t, 3, pool.puts)
+	// The buffer was larger than allowed.
+	if pool.last.buf != nil {
+		t.Errorf("buffer with capacity %d retained", cap(pool.last.buf))
+	}
+
+	_, err = NewWrapper(WriterPool(nil))
+	assertNotNil(t, err)
+	_, err = NewWrapper(MaxRetainedBuffer(-1))
+	assertNotNil(t, err)
+}
+
+func TestWriterPoolPerWrapper(t *testing.T) {
+	a, err := newConfig()
+	assertNil(t, err)
+	b, err := newConfig(PathPrefix("/a/", MinSize(10)))
+	assertNil(t, err)
+	if a.pool == b.pool {
+		t.Error("wrappers share the default pool")
+	}
+	// Path rules use the pool of the wrapper.
+	if b.forPath("/a/").pool != b.pool {
+		t.Error("path rule has its own pool")
+	}
+
+	// Pools can be shared explicitly.
+	pool := NewPool()
+	a, err = newConfig(WriterPool(pool))
+	assertNil(t, err)
+	b, err = newConfig(WriterPool(pool))
+	assertNil(t, err)
+	if a.pool != b.pool {
+		t.Error("explicitly shared pool is not shared")
+	}
+}
+
+func TestBufferSize(t *testing.T) {
+	for _, size := range []int{DefaultBufferSize, 4096} {
+		pool := &countingPool{}
+		wrapper, err := NewWrapper(MinSize(100), BufferSize(size), WriterPool(pool))
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(testBody)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+		// The first write is buffered up to the buffer size.
+		if c := cap(pool.last.buf); c < size || c > 2*size {
+			t.Errorf("buffer size %d: got capacity %d", size, c)
+		}
+	}
+	_, err := NewWrapper(BufferSize(0))
+	assertNotNil(t, err)
+}
+
+func TestContentTypeLevels(t *testing.T) {
+	var levels []int
+	impl := writer.GzipWriterFactory{
+		Levels: gzkp.Levels,
+		New: func(w io.Writer, level int) writer.GzipWriter {
+			levels = append(levels, level)
+			return gzkp.NewWriter(w, level)
+		},
+	}
+	wrapper, err := NewWrapper(Implementation(impl), CompressionLevel(5), ContentTypeLevels(map[string]int{
+		"application/json": gzip.BestSpeed,
+		"text/html":        gzip.BestCompression,
+		"text/plain":       100, // Invalid, uses default.
+	}))
+	assertNil(t, err)
+	var ct string
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ct)
+		w.Write(testBody)
+	}))
+	for _, ct = range []string{"application/json", "text/html; charset=utf-8", "text/plain", "text/css"} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+	}
+	assertEqual(t, []int{gzip.BestSpeed, gzip.BestCompression, 5, 5}, levels)
+}
+
+func TestRequestFilter(t *testing.T) {
+	wrapper, err := NewWrapper(RequestFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/health"
+	}))
+	assertNil(t, err)
+	var wrapped bool
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, wrapped = w.(*GzipResponseWriter)
+		w.Write(testBody)
+	}))
+	for _, path := range []string{"/", "/health"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		compressed := path != "/health"
+		assertEqual(t, compressed, wrapped)
+		assertEqual(t, compressed, resp.Header().Get("Content-Encoding") == "gzip")
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var got http.ResponseWriter
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			t.Fatal("response writer has no Unwrap method")
+		}
+		got = u.Unwrap()
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+	if got != http.ResponseWriter(rec) {
+		t.Errorf("got %T, want the recorder", got)
+	}
+}
+
+// deadlineRecorder records deadlines set on it.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	read, write time.Time
+}
+
+func (d *deadlineRecorder) SetReadDeadline(t time.Time) error {
+	d.read = t
+	return nil
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	d.write = t
+	return nil
+}
+
+// unwrapRecorder is a ResponseWriter wrapper that only supports Unwrap.
+type unwrapRecorder struct {
+	http.ResponseWriter
+}
+
+func (u unwrapRecorder) Unwrap() http.ResponseWriter {
+	return u.ResponseWriter
+}
+
+func TestDeadlines(t *testing.T) {
+	type deadliner interface {
+		SetReadDeadline(time.Time) error
+		SetWriteDeadline(time.Time) error
+	}
+	deadline := time.Now().Add(time.Minute)
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, ok := w.(deadliner)
+		if !ok {
+			t.Fatal("response writer has no deadline methods")
+		}
+		if err := d.SetReadDeadline(deadline); err != nil {
+			t.Error(err)
+		}
+		if err := d.SetWriteDeadline(deadline.Add(time.Second)); err != nil {
+			t.Error(err)
+		}
+	}))
+	for _, wrap := range []bool{false, true} {
+		rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+		var w http.ResponseWriter = rec
+		if wrap {
+			w = unwrapRecorder{rec}
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		handler.ServeHTTP(w, req)
+		assertEqual(t, true, rec.read.Equal(deadline))
+		assertEqual(t, true, rec.write.Equal(deadline.Add(time.Second)))
+	}
+
+	// Not supported by the recorder.
+	handler = GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := w.(deadliner)
+		assertEqual(t, http.ErrNotSupported, d.SetReadDeadline(deadline))
+		assertEqual(t, http.ErrNotSupported, d.SetWriteDeadline(deadline))
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestNotAcceptable(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   int
+	}{
+		{accept: "gzip, identity;q=0", want: http.StatusOK},
+		{accept: "br, identity;q=0", want: http.StatusNotAcceptable},
+		{accept: "br, *;q=0", want: http.StatusNotAcceptable},
+		{accept: "br, identity, *;q=0", want: http.StatusOK},
+		{accept: "br", want: http.StatusOK},
+		{accept: "*;q=0", want: http.StatusNotAcceptable},
+		{accept: "gzip;q=0, identity;q=0", want: http.StatusNotAcceptable},
+	}
+	for _, enable := range []bool{false, true} {
+		opts := []option{}
+		if enable {
+			opts = append(opts, NotAcceptable(nil))
+		}
+		wrapper, err := NewWrapper(opts...)
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(testBody)
+		}))
+		for _, test := range tests {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			want := test.want
+			if !enable {
+				want = http.StatusOK
+			}
+			if resp.Code != want {
+				t.Errorf("%q (enabled: %v): got status %d, want %d", test.accept, enable, resp.Code, want)
+			}
+		}
+	}
+}
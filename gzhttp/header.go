@@ -0,0 +1,62 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+// GzipHeader sets the header of gzip compressed responses.
+//
+// By default the header has no name, comment or modification time,
+// and the operating system is unknown (255), so identical responses
+// are compressed to identical bytes. Note that an OS of 0 means FAT.
+// With RandomJitter the padding is appended to the comment.
+//
+// The header is only set if the gzip writer implements writer.GzipWriterExt.
+// Name and Comment must be Latin-1 without zero bytes.
+func GzipHeader(h writer.Header) option {
+	return func(c *config) {
+		c.gzipHeader = &h
+	}
+}
+
+// validateGzipHeader returns an error if h cannot be written.
+func validateGzipHeader(h writer.Header) error {
+	for _, s := range []string{h.Name, h.Comment} {
+		for _, r := range s {
+			if r == 0 || r > 0xff {
+				return fmt.Errorf("gzip header name and comment must be Latin-1 without zero bytes")
+			}
+		}
+	}
+	if len(h.Extra) > 0xffff {
+		return fmt.Errorf("gzip header extra data is too large")
+	}
+	if !h.ModTime.IsZero() && (h.ModTime.Unix() < 0 || h.ModTime.Unix() > 0xffffffff) {
+		return fmt.Errorf("gzip header modification time is out of range")
+	}
+	return nil
+}
+
+// setGzipHeader sets the header of the gzip stream written by enc,
+// with padding bytes appended to the comment.
+func (w *GzipResponseWriter) setGzipHeader(enc writer.GzipWriter, padding int) {
+	if w.gzipHeader == nil && padding == 0 {
+		return
+	}
+	gw, ok := enc.(writer.GzipWriterExt)
+	if !ok {
+		return
+	}
+	h := writer.Header{OS: 255}
+	if w.gzipHeader != nil {
+		h = *w.gzipHeader
+	}
+	h.Comment += jitterPadding[:padding]
+	gw.SetHeader(h)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+	"github.com/klauspost/compress/gzhttp/writer/gzstd"
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestGzipHeader(t *testing.T) {
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	header := writer.Header{Name: "data.txt", Comment: "hello", ModTime: modTime, OS: 3}
+
+	get := func(t *testing.T, opts ...option) (gzip.Header, []byte) {
+		wrapper, err := NewWrapper(opts...)
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(testBody)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+		zr, err := gzip.NewReader(bytes.NewReader(resp.Body.Bytes()))
+		assertNil(t, err)
+		got, err := ioutil.ReadAll(zr)
+		assertNil(t, err)
+		assertEqual(t, testBody, got)
+		return zr.Header, resp.Body.Bytes()
+	}
+
+	t.Run("default", func(t *testing.T) {
+		h, b := get(t)
+		assertEqual(t, "", h.Name)
+		assertEqual(t, "", h.Comment)
+		assertEqual(t, int64(0), h.ModTime.Unix())
+		assertEqual(t, byte(255), h.OS)
+		// Responses are deterministic.
+		_, b2 := get(t)
+		assertEqual(t, b, b2)
+	})
+	for name, opts := range map[string][]option{
+		"gzkp":    {GzipHeader(header)},
+		"gzstd":   {GzipHeader(header), Implementation(writer.GzipWriterFactory{Levels: gzstd.Levels, New: gzstd.NewWriter})},
+		"metrics": {GzipHeader(header), WithMetrics(func(Stats) {})},
+		"cutoff":  {GzipHeader(header), MaxCompressSize(10)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, _ := get(t, opts...)
+			assertEqual(t, header.Name, h.Name)
+			assertEqual(t, header.Comment, h.Comment)
+			assertEqual(t, true, modTime.Equal(h.ModTime))
+			assertEqual(t, header.OS, h.OS)
+		})
+	}
+	t.Run("jitter", func(t *testing.T) {
+		h, _ := get(t, GzipHeader(header), RandomJitter(10))
+		assertEqual(t, header.Name, h.Name)
+		if !strings.HasPrefix(h.Comment, header.Comment) || len(h.Comment) == len(header.Comment) {
+			t.Fatalf("unexpected comment %q", h.Comment)
+		}
+	})
+}
+
+func TestGzipHeaderInvalid(t *testing.T) {
+	for _, h := range []writer.Header{
+		{Name: "a\x00b"},
+		{Comment: "☃"},
+		{Extra: make([]byte, 1<<16)},
+		{ModTime: time.Unix(-1, 0)},
+	} {
+		_, err := NewWrapper(GzipHeader(h))
+		assertNotNil(t, err)
+	}
+}
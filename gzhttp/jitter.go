@@ -0,0 +1,76 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+// MaxJitter is the maximum padding that can be set with RandomJitter.
+const MaxJitter = 1 << 14
+
+// jitterPadding holds the bytes used for padding.
+var jitterPadding = strings.Repeat(" ", MaxJitter)
+
+// RandomJitter adds between 1 and n bytes of random padding
+// to compressed responses.
+//
+// This makes it harder to use the size of compressed responses to
+// guess secrets they contain, as done by the BREACH attack.
+// It does not prevent such attacks, since the padding can be averaged out,
+// but it increases the number of requests needed.
+// For full protection, secrets should not be mixed with content that
+// can be controlled by an attacker, or compression should be disabled.
+//
+// The padding is added as a header comment for gzip and as a skippable frame
+// for zstd. Other encodings and gzip writers that do not implement
+// writer.GzipWriterExt are not padded.
+//
+// n must be between 0 and MaxJitter. 0 disables padding, which is the default.
+func RandomJitter(n int) option {
+	return func(c *config) {
+		c.jitter = n
+	}
+}
+
+// randomJitter returns a random value between 1 and n.
+func randomJitter(n int) int {
+	var b [4]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		// Should never happen, but always pad.
+		return n
+	}
+	return 1 + int(binary.LittleEndian.Uint32(b[:])%uint32(n))
+}
+
+// pad adds padding to the compressed stream written by enc to out,
+// and sets the gzip header.
+func (w *GzipResponseWriter) pad(enc writer.GzipWriter, out io.Writer) {
+	n := 0
+	if w.jitter > 0 {
+		n = randomJitter(w.jitter)
+	}
+	switch w.encoding {
+	case encodingGzip:
+		w.setGzipHeader(enc, n)
+	case encodingZstd, encodingDcz:
+		if n == 0 {
+			return
+		}
+		// A skippable frame before the compressed data.
+		var hdr [8]byte
+		binary.LittleEndian.PutUint32(hdr[:4], 0x184D2A50)
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(n))
+		// Write errors will be returned by the following writes.
+		if _, err := out.Write(hdr[:]); err == nil {
+			io.WriteString(out, jitterPadding[:n])
+		}
+	}
+}
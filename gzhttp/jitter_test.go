@@ -0,0 +1,78 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRandomJitter(t *testing.T) {
+	const jitter = 100
+	var sizes = map[string]map[int]bool{"gzip": {}, "zstd": {}}
+	for _, metrics := range []bool{false, true} {
+		opts := []option{EnableZstd(), RandomJitter(jitter)}
+		if metrics {
+			opts = append(opts, WithMetrics(func(Stats) {}))
+		}
+		wrapper, err := NewWrapper(opts...)
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(testBody)
+		}))
+		for i := 0; i < 20; i++ {
+			for enc := range sizes {
+				req, _ := http.NewRequest("GET", "/", nil)
+				req.Header.Set("Accept-Encoding", enc)
+				resp := httptest.NewRecorder()
+				handler.ServeHTTP(resp, req)
+				assertEqual(t, enc, resp.Result().Header.Get("Content-Encoding"))
+				b := resp.Body.Bytes()
+				sizes[enc][len(b)] = true
+
+				var got []byte
+				switch enc {
+				case "gzip":
+					zr, err := gzip.NewReader(bytes.NewReader(b))
+					assertNil(t, err)
+					if n := len(zr.Header.Comment); n < 1 || n > jitter {
+						t.Fatalf("padding %d out of range", n)
+					}
+					got, err = ioutil.ReadAll(zr)
+					assertNil(t, err)
+				case "zstd":
+					zr, err := zstd.NewReader(bytes.NewReader(b))
+					assertNil(t, err)
+					got, err = ioutil.ReadAll(zr)
+					zr.Close()
+					assertNil(t, err)
+				}
+				if !bytes.Equal(got, testBody) {
+					t.Fatalf("%s: body mismatch", enc)
+				}
+			}
+		}
+	}
+	for enc, s := range sizes {
+		if len(s) < 2 {
+			t.Errorf("%s: response sizes did not change: %v", enc, s)
+		}
+	}
+}
+
+func TestRandomJitterInvalid(t *testing.T) {
+	_, err := NewWrapper(RandomJitter(-1))
+	assertNotNil(t, err)
+	_, err = NewWrapper(RandomJitter(MaxJitter + 1))
+	assertNotNil(t, err)
+	_, err = NewWrapper(RandomJitter(MaxJitter))
+	assertNil(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+// MaxConcurrent limits the number of responses compressed concurrently to n.
+// When the limit is reached, responses are sent uncompressed,
+// so a spike in traffic cannot use all CPU for compression.
+//
+// The limit is shared by all paths of the wrapper,
+// unless a path rule sets its own limit.
+// The default, 0, means no limit.
+func MaxConcurrent(n int) option {
+	return func(c *config) {
+		c.maxConcurrent = n
+		c.limiter = nil
+		if n > 0 {
+			c.limiter = make(chan struct{}, n)
+		}
+	}
+}
+
+// acquire reserves compression of the response.
+// It returns false if the concurrency limit has been reached
+// or the load is too high.
+func (w *GzipResponseWriter) acquire() bool {
+	if !w.checkLoad() {
+		return false
+	}
+	if w.limiter == nil {
+		return true
+	}
+	select {
+	case w.limiter <- struct{}{}:
+		w.acquired = true
+		return true
+	default:
+		return false
+	}
+}
+
+// release releases a reservation made by acquire.
+func (w *GzipResponseWriter) release() {
+	if w.acquired {
+		<-w.limiter
+		w.acquired = false
+	}
+}
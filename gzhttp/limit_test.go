@@ -0,0 +1,44 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaxConcurrent(t *testing.T) {
+	wrapper, err := NewWrapper(MaxConcurrent(1))
+	assertNil(t, err)
+	started, proceed := make(chan struct{}), make(chan struct{})
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testBody)
+		if r.URL.Path == "/block" {
+			close(started)
+			<-proceed
+		}
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	blocked := make(chan *httptest.ResponseRecorder)
+	go func() { blocked <- serve("/block") }()
+	<-started
+	// The limit is reached while the first response is being compressed.
+	assertEqual(t, "", serve("/").Header().Get("Content-Encoding"))
+	close(proceed)
+	assertEqual(t, "gzip", (<-blocked).Header().Get("Content-Encoding"))
+	// The slot is released when the response is complete.
+	assertEqual(t, "gzip", serve("/").Header().Get("Content-Encoding"))
+
+	_, err = NewWrapper(MaxConcurrent(-1))
+	assertNotNil(t, err)
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+// Stats contains information about a single response.
+type Stats struct {
+	// Encoding is the content coding of the response,
+	// or an empty string if it was not compressed.
+	Encoding string
+
+	// Uncompressed is the number of bytes written by the handler.
+	Uncompressed int64
+
+	// Compressed is the number of bytes sent to the client.
+	// If the response was not compressed, this is the same as Uncompressed.
+	Compressed int64
+
+	// Duration is the time spent compressing the response.
+	// Time spent writing to the client is not included.
+	Duration time.Duration
+}
+
+// WithMetrics will call fn with statistics for every response
+// to a client that accepts one of the enabled encodings.
+// This includes responses that were not compressed, for instance
+// because they were smaller than the minimum size.
+//
+// fn is called after the handler has returned and the response
+// has been written. fn may be called concurrently.
+func WithMetrics(fn func(Stats)) option {
+	return func(c *config) {
+		c.metrics = fn
+	}
+}
+
+// measuredWriter measures an encoder and the bytes it outputs.
+type measuredWriter struct {
+	enc   writer.GzipWriter
+	out   io.Writer
+	stats *Stats
+
+	// writing is the time spent writing output,
+	// which is subtracted from stats.Duration.
+	writing time.Duration
+}
+
+// newMeasuredWriter returns a writer that compresses to out using newWriter,
+// and adds measurements to stats.
+func newMeasuredWriter(newWriter writer.EncoderFactory, out io.Writer, stats *Stats) *measuredWriter {
+	m := &measuredWriter{out: out, stats: stats}
+	m.enc = newWriter(measuredOutput{m})
+	return m
+}
+
+func (m *measuredWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := m.enc.Write(p)
+	m.stats.Uncompressed += int64(n)
+	m.stats.Duration += time.Since(start)
+	return n, err
+}
+
+func (m *measuredWriter) Flush() error {
+	start := time.Now()
+	err := m.enc.Flush()
+	m.stats.Duration += time.Since(start)
+	return err
+}
+
+func (m *measuredWriter) Close() error {
+	start := time.Now()
+	err := m.enc.Close()
+	m.stats.Duration += time.Since(start) - m.writing
+	m.writing = 0
+	if m.stats.Duration < 0 {
+		m.stats.Duration = 0
+	}
+	return err
+}
+
+// measuredOutput counts the output of a measuredWriter.
+type measuredOutput struct {
+	m *measuredWriter
+}
+
+func (o measuredOutput) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := o.m.out.Write(p)
+	o.m.stats.Compressed += int64(n)
+	o.m.writing += time.Since(start)
+	return n, err
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithMetrics(t *testing.T) {
+	var got []Stats
+	wrapper, err := NewWrapper(EnableZstd(), WithMetrics(func(s Stats) {
+		got = append(got, s)
+	}))
+	assertNil(t, err)
+	var body []byte
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Write in two parts to pass both the buffered and the direct path.
+		w.Write(body[:len(body)/2])
+		w.Write(body[len(body)/2:])
+	}))
+
+	tests := []struct {
+		accept   string
+		body     []byte
+		encoding string
+		reported bool
+	}{
+		{accept: "gzip", body: testBody, encoding: "gzip", reported: true},
+		{accept: "zstd", body: testBody, encoding: "zstd", reported: true},
+		{accept: "gzip", body: smallTestBody, encoding: "", reported: true},
+		{accept: "", body: testBody},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			got = got[:0]
+			body = test.body
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", test.accept)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if !test.reported {
+				assertEqual(t, 0, len(got))
+				return
+			}
+			assertEqual(t, 1, len(got))
+			s := got[0]
+			assertEqual(t, test.encoding, s.Encoding)
+			assertEqual(t, int64(len(test.body)), s.Uncompressed)
+			assertEqual(t, int64(resp.Body.Len()), s.Compressed)
+			if test.encoding == "" {
+				assertEqual(t, s.Uncompressed, s.Compressed)
+				return
+			}
+			if s.Compressed >= s.Uncompressed {
+				t.Errorf("compressed size %d >= uncompressed size %d", s.Compressed, s.Uncompressed)
+			}
+			if s.Duration < 0 {
+				t.Errorf("negative duration: %v", s.Duration)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gzecho provides echo middleware compressing responses with gzhttp.
+package gzecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns echo middleware that compresses responses with wrapper,
+// which is usually created with gzhttp.NewWrapper.
+//
+// Errors returned by handlers are passed to the echo error handler
+// while the response is still being compressed, so the error response is compressed as well.
+// Since the error is handled, nil is returned to the middleware before it.
+// When the middleware returns, the original echo response is restored.
+// Its Status and Size describe what was sent to the client,
+// so Size is the compressed size.
+func Middleware(wrapper func(http.Handler) http.Handler) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			orig := c.Response()
+			wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				c.SetResponse(echo.NewResponse(w, c.Echo()))
+				if err := next(c); err != nil {
+					c.Error(err)
+				}
+			})).ServeHTTP(orig, c.Request())
+			c.SetResponse(orig)
+			return nil
+		}
+	}
+}
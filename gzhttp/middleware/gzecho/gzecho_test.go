@@ -0,0 +1,109 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzecho
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzhttp"
+	"github.com/klauspost/compress/gzip"
+	"github.com/labstack/echo/v4"
+)
+
+func decode(t *testing.T, b []byte) string {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(got)
+}
+
+func TestMiddleware(t *testing.T) {
+	wrapper, err := gzhttp.NewWrapper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("compress me please ", 1000)
+	var outerStatus int
+	var outerSize int64
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			outerStatus, outerSize = c.Response().Status, c.Response().Size
+			return err
+		}
+	})
+	e.Use(Middleware(wrapper))
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusCreated, body)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d", resp.Code, http.StatusCreated)
+	}
+	if got := resp.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	if got := decode(t, resp.Body.Bytes()); got != body {
+		t.Fatal("body mismatch")
+	}
+	if outerStatus != http.StatusCreated || outerSize != int64(resp.Body.Len()) {
+		t.Errorf("outer middleware saw status %d, size %d, want %d, %d", outerStatus, outerSize, http.StatusCreated, resp.Body.Len())
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	wrapper, err := gzhttp.NewWrapper(gzhttp.MinSize(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	var handled int
+	handler := e.HTTPErrorHandler
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		handled++
+		handler(err, c)
+	}
+	e.Use(Middleware(wrapper))
+	e.GET("/", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad request")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	e.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", resp.Code, http.StatusBadRequest)
+	}
+	if handled != 1 {
+		t.Errorf("error handler called %d times, want 1", handled)
+	}
+	if got := resp.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	// The error must only be written once.
+	if got := decode(t, resp.Body.Bytes()); strings.Count(got, "bad request") != 1 {
+		t.Errorf("got body %q", got)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gzgin provides gin middleware compressing responses with gzhttp.
+package gzgin
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// noWritten is the size before anything has been written, like gin uses.
+const noWritten = -1
+
+var errHijackAlreadyWritten = errors.New("gzgin: response body already written")
+
+// Middleware returns gin middleware that compresses responses with wrapper,
+// which is usually created with gzhttp.NewWrapper.
+//
+// While the handlers run, Status, Size and Written of c.Writer describe
+// the response written by the handlers, so Size is the uncompressed size.
+// When the middleware returns, the original gin writer is restored and
+// reports what was sent to the client.
+func Middleware(wrapper func(http.Handler) http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orig := c.Writer
+		wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.Writer = &responseWriter{ResponseWriter: w, orig: orig, status: orig.Status(), size: noWritten}
+			c.Request = r
+			c.Next()
+		})).ServeHTTP(orig, c.Request)
+		c.Writer = orig
+	}
+}
+
+// responseWriter forwards writes to the compressing writer
+// and keeps track of the status and size like the gin writer.
+type responseWriter struct {
+	http.ResponseWriter
+	orig   gin.ResponseWriter
+	status int
+	size   int
+}
+
+var _ gin.ResponseWriter = (*responseWriter)(nil)
+
+// WriteHeader sets the status code, which is sent on the first write.
+func (w *responseWriter) WriteHeader(code int) {
+	if code > 0 && w.status != code && !w.Written() {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow sends the status code, if it hasn't been sent.
+func (w *responseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// Write compresses b to the response.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// WriteString compresses s to the response.
+func (w *responseWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	n, err := io.WriteString(w.ResponseWriter, s)
+	w.size += n
+	return n, err
+}
+
+// Status returns the status code of the response.
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of uncompressed bytes written,
+// or -1 if nothing has been written.
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+// Written returns whether the status code has been sent.
+func (w *responseWriter) Written() bool {
+	return w.size != noWritten
+}
+
+// Flush flushes the compressed data to the client.
+func (w *responseWriter) Flush() {
+	w.WriteHeaderNow()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection, if nothing has been written to the body.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.size > 0 {
+		return nil, nil, errHijackAlreadyWritten
+	}
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// CloseNotify returns the close notification channel of the gin writer.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	return w.orig.CloseNotify()
+}
+
+// Pusher returns the http.Pusher of the response, or nil if not supported.
+func (w *responseWriter) Pusher() http.Pusher {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p
+	}
+	return nil
+}
+
+// Unwrap returns the compressing writer, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzgin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzhttp"
+	"github.com/klauspost/compress/gzip"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestMiddleware(t *testing.T) {
+	wrapper, err := gzhttp.NewWrapper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("compress me please ", 1000)
+	var outerStatus, outerSize int
+	var innerStatus, innerSize int
+	var innerWritten bool
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Next()
+		outerStatus, outerSize = c.Writer.Status(), c.Writer.Size()
+	})
+	r.Use(Middleware(wrapper))
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+		if c.Writer.Written() {
+			t.Error("written before write")
+		}
+		c.Writer.WriteString(body)
+		innerStatus, innerSize, innerWritten = c.Writer.Status(), c.Writer.Size(), c.Writer.Written()
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusCreated {
+		t.Errorf("got status %d, want %d", resp.Code, http.StatusCreated)
+	}
+	if got := resp.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", got)
+	}
+	compressed := resp.Body.Bytes()
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Fatal("body mismatch")
+	}
+	if innerStatus != http.StatusCreated || innerSize != len(body) || !innerWritten {
+		t.Errorf("handler saw status %d, size %d, written %v", innerStatus, innerSize, innerWritten)
+	}
+	if outerStatus != http.StatusCreated || outerSize != len(compressed) {
+		t.Errorf("outer middleware saw status %d, size %d, want %d, %d", outerStatus, outerSize, http.StatusCreated, len(compressed))
+	}
+}
+
+func TestMiddlewareSmall(t *testing.T) {
+	wrapper, err := gzhttp.NewWrapper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(Middleware(wrapper))
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusNotFound, "not found")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("got status %d, want %d", resp.Code, http.StatusNotFound)
+	}
+	if got := resp.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("small response was compressed with %q", got)
+	}
+	if got := resp.Body.String(); got != "not found" {
+		t.Errorf("got body %q", got)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// TransportCompressRequests compresses request bodies of at least minSize bytes
+// with encoding, which must be "gzip" or "zstd".
+// Only use this with servers that accept compressed requests.
+//
+// Compressed requests are sent with Content-Encoding set and without
+// a Content-Length, so HTTP/1.1 requests use chunked encoding.
+// Up to minSize bytes are read from bodies of unknown length
+// to decide whether to compress them.
+// Requests that already have a Content-Encoding are sent unchanged.
+//
+// TransportCompressRequests panics if encoding is not supported.
+func TransportCompressRequests(encoding string, minSize int64) transportOption {
+	switch encoding {
+	case encodingGzip, encodingZstd:
+	default:
+		panic("gzhttp: unsupported request encoding " + encoding)
+	}
+	return func(c *gzRoundtripper) {
+		c.requestEncoding = encoding
+		c.requestMinSize = minSize
+	}
+}
+
+// compressRequest returns req with the body compressed, if enabled.
+// If a new request is returned, the body of req will be closed by it.
+func (g gzRoundtripper) compressRequest(req *http.Request) (*http.Request, error) {
+	if g.requestEncoding == "" || req.Body == nil || req.Body == http.NoBody ||
+		req.Header.Get(contentEncoding) != "" {
+		return req, nil
+	}
+	if req.ContentLength > 0 && req.ContentLength < g.requestMinSize {
+		return req, nil
+	}
+	body := req.Body
+	if req.ContentLength <= 0 && g.requestMinSize > 0 {
+		// Unknown length. Read the start to see if it is long enough.
+		buf := make([]byte, g.requestMinSize)
+		n, err := io.ReadFull(body, buf)
+		switch err {
+		case nil:
+			body = readCloser{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}
+		case io.EOF, io.ErrUnexpectedEOF:
+			// Send the short body uncompressed.
+			body.Close()
+			r2 := req.Clone(req.Context())
+			r2.Body = ioutil.NopCloser(bytes.NewReader(buf[:n]))
+			r2.ContentLength = int64(n)
+			return r2, nil
+		default:
+			body.Close()
+			return nil, err
+		}
+	}
+
+	r2 := req.Clone(req.Context())
+	r2.Body = g.compressBody(body)
+	r2.ContentLength = -1
+	r2.Header.Set(contentEncoding, g.requestEncoding)
+	r2.Header.Del(contentLength)
+	if req.GetBody != nil {
+		getBody := req.GetBody
+		r2.GetBody = func() (io.ReadCloser, error) {
+			body, err := getBody()
+			if err != nil {
+				return nil, err
+			}
+			return g.compressBody(body), nil
+		}
+	}
+	return r2, nil
+}
+
+// compressBody returns body compressed with the request encoding.
+// body is closed when it has been compressed,
+// or when the returned reader is closed.
+func (g gzRoundtripper) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		var err error
+		switch g.requestEncoding {
+		case encodingGzip:
+			gw, _ := gzWriterPool.Get().(*gzip.Writer)
+			if gw == nil {
+				gw = gzip.NewWriter(pw)
+			} else {
+				gw.Reset(pw)
+			}
+			if _, err = io.Copy(gw, body); err == nil {
+				err = gw.Close()
+			}
+			gw.Reset(nil)
+			gzWriterPool.Put(gw)
+		case encodingZstd:
+			enc, _ := zstdWriterPool.Get().(*zstd.Encoder)
+			if enc == nil {
+				enc, _ = zstd.NewWriter(pw, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+			} else {
+				enc.Reset(pw)
+			}
+			if _, err = io.Copy(enc, body); err == nil {
+				err = enc.Close()
+			}
+			enc.Reset(nil)
+			zstdWriterPool.Put(enc)
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+var (
+	gzWriterPool   sync.Pool
+	zstdWriterPool sync.Pool
+)
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// requestEchoHandler records the encoding and length of requests
+// and responds with the decompressed body.
+type requestEchoHandler struct {
+	encoding string
+	length   int64
+}
+
+func (h *requestEchoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/redirect" {
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	h.encoding = r.Header.Get("Content-Encoding")
+	h.length = r.ContentLength
+	var body io.Reader = r.Body
+	switch h.encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(b)
+}
+
+func TestTransportCompressRequests(t *testing.T) {
+	h := &requestEchoHandler{}
+	server := httptest.NewServer(h)
+	defer server.Close()
+
+	small := []byte("small request")
+	tests := []struct {
+		name         string
+		encoding     string
+		body         []byte
+		unknown      bool // Send the body without a length.
+		preEncoded   bool
+		path         string
+		wantEncoding string
+	}{
+		{name: "gzip", encoding: "gzip", body: testBody, wantEncoding: "gzip"},
+		{name: "zstd", encoding: "zstd", body: testBody, wantEncoding: "zstd"},
+		{name: "small", encoding: "gzip", body: small},
+		{name: "unknown-length", encoding: "gzip", body: testBody, unknown: true, wantEncoding: "gzip"},
+		{name: "unknown-length-small", encoding: "zstd", body: small, unknown: true},
+		{name: "encoded", encoding: "gzip", body: testBody, preEncoded: true, wantEncoding: "identity"},
+		{name: "redirect", encoding: "gzip", body: testBody, path: "/redirect", wantEncoding: "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(test.body)
+			if test.unknown {
+				body = ioutil.NopCloser(body)
+			}
+			req, err := http.NewRequest("POST", server.URL+test.path, body)
+			assertNil(t, err)
+			if test.preEncoded {
+				req.Header.Set("Content-Encoding", "identity")
+			}
+			c := http.Client{Transport: Transport(http.DefaultTransport, TransportCompressRequests(test.encoding, 100))}
+			resp, err := c.Do(req)
+			assertNil(t, err)
+			got, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assertNil(t, err)
+			assertEqual(t, http.StatusOK, resp.StatusCode)
+			assertEqual(t, test.body, got)
+			assertEqual(t, test.wantEncoding, h.encoding)
+			if test.wantEncoding == "gzip" || test.wantEncoding == "zstd" {
+				assertEqual(t, int64(-1), h.length)
+			} else {
+				assertEqual(t, int64(len(test.body)), h.length)
+			}
+		})
+	}
+}
+
+func TestTransportCompressRequestsInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	TransportCompressRequests("br", 0)
+}
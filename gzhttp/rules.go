@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pathRule applies a separate configuration to matching paths.
+type pathRule struct {
+	name  string
+	match func(path string) bool
+	opts  []option
+	c     *config
+}
+
+// PathPrefix applies opts to requests with a URL path starting with prefix.
+// The options are applied on top of the other options of the wrapper,
+// regardless of the order they are given in.
+//
+// Rules are checked in the order they are added and the first matching
+// rule is used. Requests not matching any rule use the wrapper options.
+//
+// Example, compressing /api/ harder and not compressing /stream/:
+//
+//	gzhttp.NewWrapper(
+//		gzhttp.PathPrefix("/api/", gzhttp.CompressionLevel(gzip.BestCompression)),
+//		gzhttp.PathPrefix("/stream/", gzhttp.DisableCompression()),
+//	)
+func PathPrefix(prefix string, opts ...option) option {
+	return func(c *config) {
+		c.rules = append(c.rules, pathRule{
+			name:  fmt.Sprintf("path prefix %q", prefix),
+			match: func(path string) bool { return strings.HasPrefix(path, prefix) },
+			opts:  opts,
+		})
+	}
+}
+
+// PathRegexp applies opts to requests with a URL path matching re.
+// See PathPrefix for how rules are applied.
+func PathRegexp(re *regexp.Regexp, opts ...option) option {
+	return func(c *config) {
+		r := pathRule{name: "path regexp <nil>", opts: opts}
+		if re != nil {
+			r.name = fmt.Sprintf("path regexp %q", re.String())
+			r.match = re.MatchString
+		}
+		c.rules = append(c.rules, r)
+	}
+}
+
+// DisableCompression disables compression.
+// Responses are passed through unmodified and no Vary header is added.
+// This is mainly useful with PathPrefix and PathRegexp.
+func DisableCompression() option {
+	return func(c *config) {
+		c.disabled = true
+	}
+}
+
+// initRules creates the configuration of each rule.
+// c must be validated and initialized.
+func (c *config) initRules() error {
+	for i := range c.rules {
+		r := &c.rules[i]
+		if r.match == nil {
+			return fmt.Errorf("%s: nil matcher", r.name)
+		}
+		rc := *c
+		rc.rules = nil
+		rc.encodings = nil
+		rc.registered = append([]encoding(nil), c.registered...)
+		rc.dictionaries = append([]dictionary(nil), c.dictionaries...)
+		for _, o := range r.opts {
+			o(&rc)
+		}
+		if len(rc.rules) > 0 {
+			return fmt.Errorf("%s: %w", r.name, errNestedRules)
+		}
+		if err := rc.validate(); err != nil {
+			return fmt.Errorf("%s: %w", r.name, err)
+		}
+		rc.initEncodings()
+		r.c = &rc
+	}
+	return nil
+}
+
+var errNestedRules = errors.New("path rules cannot be nested")
+
+// forPath returns the configuration to use for path.
+func (c *config) forPath(path string) *config {
+	for _, r := range c.rules {
+		if r.match(path) {
+			return r.c
+		}
+	}
+	return c
+}
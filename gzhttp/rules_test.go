@@ -0,0 +1,65 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestPathRules(t *testing.T) {
+	wrapper, err := NewWrapper(
+		MinSize(10),
+		PathPrefix("/stream/", DisableCompression()),
+		PathPrefix("/api/", CompressionLevel(gzip.BestCompression), MinSize(len(testBody)+1)),
+		PathRegexp(regexp.MustCompile(`\.json$`), ContentTypes([]string{"application/json"})),
+		PathPrefix("/zstd/", EnableZstd()),
+	)
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(testBody)
+	}))
+
+	tests := []struct {
+		path     string
+		encoding string
+		vary     string
+	}{
+		{path: "/", encoding: "gzip", vary: "Accept-Encoding"},
+		{path: "/stream/a", encoding: "", vary: ""},
+		// Below the minimum size of the rule.
+		{path: "/api/a", encoding: "", vary: "Accept-Encoding"},
+		// Content type is not accepted by the rule.
+		{path: "/a.json", encoding: "", vary: "Accept-Encoding"},
+		{path: "/zstd/a", encoding: "zstd", vary: "Accept-Encoding"},
+		// First matching rule is used.
+		{path: "/stream/a.json", encoding: "", vary: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", test.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip, zstd")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, test.encoding, res.Header.Get("Content-Encoding"))
+			assertEqual(t, test.vary, res.Header.Get("Vary"))
+		})
+	}
+}
+
+func TestPathRulesInvalid(t *testing.T) {
+	_, err := NewWrapper(PathPrefix("/a/", CompressionLevel(100)))
+	assertNotNil(t, err)
+	_, err = NewWrapper(PathRegexp(nil))
+	assertNotNil(t, err)
+	_, err = NewWrapper(PathPrefix("/a/", PathPrefix("/a/b/")))
+	assertNotNil(t, err)
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import "net/http"
+
+// SniffLength sets the number of bytes of responses without a Content-Type
+// that are buffered and used to detect the content type.
+// The default, 0, uses the data buffered to decide whether to compress,
+// see BufferSize and MinSize.
+// Note that http.DetectContentType only considers the first 512 bytes,
+// so larger values are mostly useful with ContentSniffer.
+func SniffLength(n int) option {
+	return func(c *config) {
+		c.sniffLength = n
+	}
+}
+
+// ContentSniffer sets a function that detects the content type of
+// responses without a Content-Type, used instead of http.DetectContentType.
+// It is called with the start of the response, see SniffLength.
+// If fn returns an empty string, http.DetectContentType is used.
+// The function may be called concurrently.
+func ContentSniffer(fn func(b []byte) string) option {
+	return func(c *config) {
+		c.sniffer = fn
+	}
+}
+
+// detectContentType returns the content type of a response starting with b.
+func detectContentType(sniffer func([]byte) string, sniffLength int, b []byte) string {
+	if sniffLength > 0 && len(b) > sniffLength {
+		b = b[:sniffLength]
+	}
+	if sniffer != nil {
+		if ct := sniffer(b); ct != "" {
+			return ct
+		}
+	}
+	return http.DetectContentType(b)
+}
+
+// sniffing returns whether more data should be buffered to detect
+// the content type of the response.
+func (w *GzipResponseWriter) sniffing() bool {
+	return len(w.buf) < w.sniffLength && w.Header().Get(contentType) == ""
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentSniffer(t *testing.T) {
+	// JSON is detected as text/plain by http.DetectContentType.
+	body := bytes.Repeat([]byte(`{"key": "value"}`), 100)
+	var sniffed []byte
+	wrapper, err := NewWrapper(MinSize(10), ContentSniffer(func(b []byte) string {
+		sniffed = append(sniffed[:0], b...)
+		if bytes.HasPrefix(b, []byte("{")) {
+			return "application/json"
+		}
+		return ""
+	}))
+	assertNil(t, err)
+
+	for _, test := range []struct {
+		name, want string
+		body       []byte
+	}{
+		{name: "custom", body: body, want: "application/json"},
+		{name: "fallback", body: []byte("<html><body>hello world</body></html>"), want: "text/html; charset=utf-8"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(test.body)
+			}))
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, test.want, resp.Header().Get("Content-Type"))
+			assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestSniffLength(t *testing.T) {
+	// The content type can only be detected after 100 bytes.
+	body := append(bytes.Repeat([]byte(" "), 100), []byte("marker and more text")...)
+	sniffer := func(b []byte) string {
+		if bytes.Contains(b, []byte("marker")) {
+			return "text/x-marker"
+		}
+		return "application/octet-stream"
+	}
+	for _, test := range []struct {
+		name   string
+		length int
+		parts  int
+		want   string
+	}{
+		{name: "default", length: 0, parts: 1, want: "application/octet-stream"},
+		{name: "longer", length: 200, parts: 1, want: "text/x-marker"},
+		{name: "longer-parts", length: 200, parts: 10, want: "text/x-marker"},
+		{name: "shorter", length: 50, parts: 1, want: "application/octet-stream"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			wrapper, err := NewWrapper(MinSize(10), BufferSize(10), SniffLength(test.length), ContentSniffer(sniffer),
+				ContentTypes([]string{"text/x-marker", "application/octet-stream"}))
+			assertNil(t, err)
+			handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := len(body) / test.parts
+				for b := body; len(b) > 0; {
+					if n > len(b) {
+						n = len(b)
+					}
+					w.Write(b[:n])
+					b = b[n:]
+				}
+			}))
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			assertEqual(t, test.want, resp.Header().Get("Content-Type"))
+			assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+			assertEqual(t, body, gunzipPartial(t, resp.Body.Bytes()))
+		})
+	}
+}
+
+func TestSniffLengthShortResponse(t *testing.T) {
+	// Responses shorter than the sniff length are still compressed.
+	wrapper, err := NewWrapper(MinSize(10), SniffLength(1000))
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a short text response"))
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertEqual(t, "text/plain; charset=utf-8", resp.Header().Get("Content-Type"))
+	assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assertEqual(t, []byte("a short text response"), gunzipPartial(t, resp.Body.Bytes()))
+
+	_, err = NewWrapper(SniffLength(-1))
+	assertNotNil(t, err)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// precompressed lists the sidecar files that are served, in order of preference.
+var precompressed = []struct {
+	token, ext string
+}{
+	{token: encodingZstd, ext: ".zst"},
+	{token: "br", ext: ".br"},
+	{token: encodingGzip, ext: ".gz"},
+}
+
+// FileServer returns a handler that serves files from root like http.FileServer.
+//
+// If a precompressed version of the requested file exists next to it,
+// with ".zst", ".br" or ".gz" appended to the name, and the client accepts
+// that encoding, the precompressed file is served as-is with the
+// matching Content-Encoding. If several are accepted, the one with the
+// highest qvalue is used, preferring zstd, then brotli, then gzip.
+//
+// Other requests are served by http.FileServer, compressed on the fly
+// with the supplied options, as with NewWrapper.
+func FileServer(root http.FileSystem, opts ...option) (http.Handler, error) {
+	c, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	files := http.FileServer(root)
+	return &fileServer{root: root, c: c, files: files, fallback: c.wrap(files)}, nil
+}
+
+type fileServer struct {
+	root     http.FileSystem
+	c        *config
+	files    http.Handler // Uncompressed files.
+	fallback http.Handler // Files compressed on the fly.
+}
+
+func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	name = path.Clean(name)
+	c := f.c.forPath(r.URL.Path)
+	if c.disabled || c.requestFilter != nil && !c.requestFilter(r) {
+		f.files.ServeHTTP(w, r)
+		return
+	}
+	ae := r.Header.Get(acceptEncoding)
+	// Directories, including index files, HEAD requests, range requests
+	// that should apply to the uncompressed file, and requests that can use
+	// a dictionary are left to the fallback handler.
+	if r.Method == http.MethodHead || strings.HasSuffix(r.URL.Path, "/") ||
+		c.uncompressedRanges && r.Header.Get(rangeHeader) != "" {
+		f.fallback.ServeHTTP(w, r)
+		return
+	}
+	if enc, _ := c.dictionaryEncoding(r, ae); enc != nil {
+		f.fallback.ServeHTTP(w, r)
+		return
+	}
+	var (
+		bestQ   float64
+		best    int
+		sidecar http.File
+		fi      os.FileInfo
+	)
+	for i, p := range precompressed {
+		q := encodingQ(ae, p.token)
+		if q <= bestQ {
+			continue
+		}
+		file, info, ok := f.open(name + p.ext)
+		if !ok {
+			continue
+		}
+		if sidecar != nil {
+			sidecar.Close()
+		}
+		best, bestQ, sidecar, fi = i, q, file, info
+	}
+	if sidecar != nil && identityPreferred(ae, bestQ) {
+		sidecar.Close()
+		sidecar = nil
+	}
+	if sidecar == nil {
+		f.fallback.ServeHTTP(w, r)
+		return
+	}
+	defer sidecar.Close()
+
+	h := w.Header()
+	h.Add(vary, acceptEncoding)
+	if _, ok := h[contentType]; !ok {
+		h.Set(contentType, f.contentType(c, name))
+	}
+	h.Set(contentEncoding, precompressed[best].token)
+	http.ServeContent(w, r, name, fi.ModTime(), sidecar)
+}
+
+// open opens name if it is a regular file.
+func (f *fileServer) open(name string) (http.File, os.FileInfo, bool) {
+	file, err := f.root.Open(name)
+	if err != nil {
+		return nil, nil, false
+	}
+	fi, err := file.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, false
+	}
+	return file, fi, true
+}
+
+// contentType returns the content type of the uncompressed file name.
+// It is based on the extension if known, otherwise the uncompressed
+// file is sniffed with the configuration c, if it exists.
+func (f *fileServer) contentType(c *config, name string) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	file, err := f.root.Open(name)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	n := c.sniffLength
+	if n == 0 {
+		// The length used by http.DetectContentType.
+		n = 512
+	}
+	buf := make([]byte, n)
+	n, _ = io.ReadFull(file, buf)
+	return detectContentType(c.sniffer, c.sniffLength, buf[:n])
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestFileServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, b []byte) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(testBody)
+	gw.Close()
+	enc, _ := zstd.NewWriter(nil)
+	zst := enc.EncodeAll(testBody, nil)
+	enc.Close()
+
+	// both.js has gzip and zstd sidecars, gz.css only gzip,
+	// and plain.txt none.
+	write("both.js", testBody)
+	write("both.js.gz", gz.Bytes())
+	write("both.js.zst", zst)
+	write("gz.css", testBody)
+	write("gz.css.gz", gz.Bytes())
+	write("plain.txt", testBody)
+
+	handler, err := FileServer(http.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, accept string
+		encoding     string
+		body         []byte
+	}{
+		{path: "/both.js", accept: "gzip, zstd", encoding: "zstd", body: zst},
+		{path: "/both.js", accept: "gzip", encoding: "gzip", body: gz.Bytes()},
+		{path: "/both.js", accept: "gzip, zstd;q=0.5", encoding: "gzip", body: gz.Bytes()},
+		{path: "/both.js", accept: "", encoding: "", body: testBody},
+		{path: "/gz.css", accept: "zstd, gzip;q=0.5", encoding: "gzip", body: gz.Bytes()},
+		{path: "/gz.css", accept: "zstd", encoding: "", body: testBody},
+		// No sidecar, compressed on the fly.
+		{path: "/plain.txt", accept: "gzip", encoding: "gzip"},
+		{path: "/plain.txt", accept: "", encoding: "", body: testBody},
+	}
+	for _, test := range tests {
+		t.Run(test.path+"-"+test.accept, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", test.path, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept-Encoding", test.accept)
+			}
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, http.StatusOK, res.StatusCode)
+			assertEqual(t, test.encoding, res.Header.Get("Content-Encoding"))
+			assertEqual(t, "Accept-Encoding", res.Header.Get("Vary"))
+			got, _ := ioutil.ReadAll(res.Body)
+			if test.body == nil {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				assertNil(t, err)
+				got, err = ioutil.ReadAll(zr)
+				assertNil(t, err)
+				test.body = testBody
+			}
+			if !bytes.Equal(got, test.body) {
+				t.Errorf("body mismatch, got %d bytes, want %d", len(got), len(test.body))
+			}
+			if test.encoding != "" {
+				ct := res.Header.Get("Content-Type")
+				if ct == "" || ct == "application/x-gzip" || ct == "application/octet-stream" {
+					t.Errorf("unexpected content type %q", ct)
+				}
+			}
+		})
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(testBody)
+	gw.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt.gz"), gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := FileServer(http.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	res := resp.Result()
+	// Ranges apply to the encoded file.
+	assertEqual(t, http.StatusPartialContent, res.StatusCode)
+	assertEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+	assertEqual(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	got, _ := ioutil.ReadAll(res.Body)
+	assertEqual(t, gz.Bytes()[:10], got)
+}
+
+func TestFileServerUncompressedRanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gzhttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(testBody)
+	gw.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), testBody, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt.gz"), gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := FileServer(http.Dir(dir), UncompressedRanges())
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	res := resp.Result()
+	assertEqual(t, http.StatusPartialContent, res.StatusCode)
+	assertEqual(t, "", res.Header.Get("Content-Encoding"))
+	got, _ := ioutil.ReadAll(res.Body)
+	assertEqual(t, testBody[:10], got)
+
+	// Full requests still use the precompressed file.
+	req.Header.Del("Range")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	res = resp.Result()
+	assertEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+	got, _ = ioutil.ReadAll(res.Body)
+	assertEqual(t, gz.Bytes(), got)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import "net/http"
+
+// ContentStatusCodes limits compression to responses with one of the status codes.
+// Other responses are sent uncompressed.
+// Setting this overrides any previous StatusCodeFilter.
+func ContentStatusCodes(codes []int) option {
+	allowed := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		allowed[code] = true
+	}
+	return StatusCodeFilter(func(code int) bool {
+		return allowed[code]
+	})
+}
+
+// StatusCodeFilter sets a function that returns whether responses with
+// a status code may be compressed, for example to only compress 2xx responses:
+//
+//	gzhttp.StatusCodeFilter(func(code int) bool { return code >= 200 && code < 300 })
+//
+// By default responses are compressed regardless of the status code.
+// The function may be called concurrently.
+func StatusCodeFilter(fn func(code int) bool) option {
+	return func(c *config) {
+		c.statusFilter = fn
+	}
+}
+
+// statusAllowed returns whether the status code of the response allows compression.
+func (w *GzipResponseWriter) statusAllowed() bool {
+	if w.statusFilter == nil {
+		return true
+	}
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return w.statusFilter(code)
+}
+
+// bodyAllowedForStatus reports whether a response with status code may have a body.
+// See RFC 7230, section 3.3.
+func bodyAllowedForStatus(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// informational reports whether code is an informational status code,
+// such as 103 Early Hints, which is sent before the final response.
+func informational(code int) bool {
+	return code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentStatusCodes(t *testing.T) {
+	wrapper, err := NewWrapper(ContentStatusCodes([]int{http.StatusOK, http.StatusNotFound}))
+	assertNil(t, err)
+	for _, test := range []struct {
+		code     int
+		flush    bool
+		encoding string
+	}{
+		{code: 0, encoding: "gzip"},
+		{code: http.StatusOK, encoding: "gzip"},
+		{code: http.StatusNotFound, encoding: "gzip"},
+		{code: http.StatusInternalServerError, encoding: ""},
+		{code: http.StatusInternalServerError, flush: true, encoding: ""},
+		{code: http.StatusOK, flush: true, encoding: "gzip"},
+	} {
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.code != 0 {
+				w.WriteHeader(test.code)
+			}
+			if test.flush {
+				w.Write(smallTestBody)
+				w.(http.Flusher).Flush()
+			}
+			w.Write(testBody)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, test.encoding, resp.Header().Get("Content-Encoding"))
+		if test.code != 0 {
+			assertEqual(t, test.code, resp.Code)
+		}
+	}
+}
+
+func TestNoBodyStatusCodes(t *testing.T) {
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(code)
+			w.Write(testBody)
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		assertEqual(t, code, resp.Code)
+		assertEqual(t, "", resp.Header().Get("Content-Encoding"))
+	}
+}
+
+// codeRecorder records the status codes written.
+type codeRecorder struct {
+	*httptest.ResponseRecorder
+	codes []int
+}
+
+func (c *codeRecorder) WriteHeader(code int) {
+	c.codes = append(c.codes, code)
+	if code >= 200 {
+		c.ResponseRecorder.WriteHeader(code)
+	}
+}
+
+func TestEarlyHints(t *testing.T) {
+	rec := &codeRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+		w.WriteHeader(http.StatusEarlyHints)
+		// Sent before the response is written.
+		assertEqual(t, []int{http.StatusEarlyHints}, rec.codes)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write(testBody)
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+	assertEqual(t, []int{http.StatusEarlyHints, http.StatusOK}, rec.codes)
+	assertEqual(t, "gzip", rec.Header().Get("Content-Encoding"))
+}
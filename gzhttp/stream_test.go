@@ -0,0 +1,73 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestStreamingAutoFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var sent bytes.Buffer
+
+	// decoded returns what the client can decode so far.
+	decoded := func() []byte {
+		zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	wrapper, err := NewWrapper()
+	assertNil(t, err)
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < 3; i++ {
+			event := []byte("data: event\n\n")
+			w.Write(event)
+			sent.Write(event)
+			assertEqual(t, "gzip", rec.Header().Get("Content-Encoding"))
+			if !rec.Flushed {
+				t.Fatal("not flushed")
+			}
+			if got := decoded(); !bytes.Equal(got, sent.Bytes()) {
+				t.Fatalf("got %q, want %q", got, sent.Bytes())
+			}
+		}
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	handler.ServeHTTP(rec, req)
+}
+
+func TestStreamingContentTypes(t *testing.T) {
+	for _, types := range [][]string{{}, {"application/x-ndjson"}} {
+		wrapper, err := NewWrapper(StreamingContentTypes(types))
+		assertNil(t, err)
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: event\n\n"))
+		}))
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		// Small and not streaming, so not compressed.
+		assertEqual(t, "", rec.Header().Get("Content-Encoding"))
+		assertEqual(t, false, rec.Flushed)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/klauspost/compress/gzhttp/writer"
+)
+
+const (
+	trailer = "Trailer"
+
+	// DefaultLengthTrailer is the default name of the trailer
+	// added by UncompressedLengthTrailer.
+	DefaultLengthTrailer = "X-Uncompressed-Content-Length"
+)
+
+// UncompressedLengthTrailer will send the number of bytes written by the handler
+// in a trailer named name, when the response is compressed.
+// Since the Content-Length header is removed from compressed responses,
+// this can be used by clients to show progress of the decompressed data.
+// If name is empty, DefaultLengthTrailer is used.
+//
+// The trailer is declared in the Trailer header when compression starts.
+// Note that not all clients read trailers.
+func UncompressedLengthTrailer(name string) option {
+	return func(c *config) {
+		if name == "" {
+			name = DefaultLengthTrailer
+		}
+		c.lengthTrailer = http.CanonicalHeaderKey(name)
+	}
+}
+
+// declareTrailer declares the length and digest trailers, if enabled.
+// It must be called before the header is written.
+func (w *GzipResponseWriter) declareTrailer() {
+	if w.lengthTrailer != "" {
+		w.Header().Add(trailer, w.lengthTrailer)
+	}
+	if w.digestTrailer != "" {
+		w.Header().Add(trailer, w.digestTrailer)
+		w.digest = newDigest(w.digestAlgorithm)
+	}
+}
+
+// setTrailer sets the length and digest trailers, if enabled.
+// It must be called after the body is written.
+func (w *GzipResponseWriter) setTrailer() {
+	if w.lengthTrailer != "" {
+		w.Header().Set(w.lengthTrailer, strconv.FormatInt(w.written, 10))
+	}
+	if w.digest != nil {
+		w.Header().Set(w.digestTrailer, w.digestValue())
+	}
+}
+
+// counted counts and hashes b as written to the encoder.
+func (w *GzipResponseWriter) counted(b []byte) {
+	w.written += int64(len(b))
+	if w.digest != nil {
+		w.digest.Write(b)
+	}
+}
+
+// countingWriter counts and hashes the bytes written to an encoder.
+type countingWriter struct {
+	writer.GzipWriter
+	w *GzipResponseWriter
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.GzipWriter.Write(p)
+	c.w.counted(p[:n])
+	return n, err
+}
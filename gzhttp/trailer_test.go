@@ -0,0 +1,53 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestUncompressedLengthTrailer(t *testing.T) {
+	for _, name := range []string{"", "x-length"} {
+		wrapper, err := NewWrapper(UncompressedLengthTrailer(name), WithMetrics(func(Stats) {}))
+		assertNil(t, err)
+		var body []byte
+		handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body[:len(body)/2])
+			w.Write(body[len(body)/2:])
+		}))
+		want := DefaultLengthTrailer
+		if name != "" {
+			want = "X-Length"
+		}
+
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		body = testBody
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		res := resp.Result()
+		assertEqual(t, "gzip", res.Header.Get("Content-Encoding"))
+		assertEqual(t, want, res.Header.Get("Trailer"))
+		ioutil.ReadAll(res.Body)
+		assertEqual(t, strconv.Itoa(len(testBody)), res.Trailer.Get(want))
+
+		// Uncompressed responses keep their Content-Length and have no trailer.
+		body = smallTestBody
+		resp = httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		res = resp.Result()
+		assertEqual(t, "", res.Header.Get("Content-Encoding"))
+		assertEqual(t, "", res.Header.Get("Trailer"))
+		assertEqual(t, strconv.Itoa(len(smallTestBody)), res.Header.Get("Content-Length"))
+	}
+
+	_, err := NewWrapper(UncompressedLengthTrailer("a b"))
+	assertNotNil(t, err)
+}
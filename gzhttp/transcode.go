@@ -0,0 +1,155 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DecompressUnaccepted will decompress responses that the handler has
+// already compressed with gzip or zstd, when the client does not accept
+// the encoding. This is useful when proxying to backends that compress
+// regardless of the Accept-Encoding header.
+//
+// The decompressed response is compressed again if the client accepts
+// another enabled encoding, otherwise it is sent uncompressed.
+// The Content-Length and Accept-Ranges headers are removed and
+// strong ETags are made weak, since the bytes sent are different.
+func DecompressUnaccepted() option {
+	return func(c *config) {
+		c.decompressUnaccepted = true
+	}
+}
+
+// decompressHandler returns h with responses decompressed
+// if their content coding is not accepted by the client.
+func decompressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dw := &decompressWriter{
+			ResponseWriter: w,
+			accept:         r.Header.Get(acceptEncoding),
+			head:           r.Method == http.MethodHead,
+		}
+		defer dw.close()
+		h.ServeHTTP(dw, r)
+	})
+}
+
+// decompressWriter decompresses responses with a content coding
+// that is not accepted by the client.
+// Decompression runs on a separate goroutine, which writes to the
+// underlying ResponseWriter while holding mu.
+type decompressWriter struct {
+	http.ResponseWriter
+	accept string
+	head   bool // HEAD responses have no body to decompress.
+
+	started bool
+	pw      *io.PipeWriter
+	done    chan error
+
+	mu sync.Mutex // Protects ResponseWriter while decompressing.
+}
+
+func (d *decompressWriter) WriteHeader(code int) {
+	if !d.started {
+		d.start(code)
+	}
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *decompressWriter) Write(b []byte) (int, error) {
+	if !d.started {
+		d.start(http.StatusOK)
+	}
+	if d.pw == nil {
+		return d.ResponseWriter.Write(b)
+	}
+	return d.pw.Write(b)
+}
+
+// start decides whether to decompress the response with status code.
+func (d *decompressWriter) start(code int) {
+	d.started = true
+	h := d.Header()
+	ce := strings.ToLower(strings.TrimSpace(h.Get(contentEncoding)))
+	if !bodyAllowedForStatus(code) || h.Get(contentRange) != "" {
+		return
+	}
+	var body func(r io.ReadCloser) io.ReadCloser
+	switch ce {
+	case encodingGzip, "x-gzip":
+		body = func(r io.ReadCloser) io.ReadCloser { return &gzipReader{body: r} }
+	case encodingZstd:
+		body = func(r io.ReadCloser) io.ReadCloser { return &zstdReader{body: r} }
+	default:
+		return
+	}
+	if encodingQ(d.accept, ce) > 0 {
+		return
+	}
+	h.Del(contentEncoding)
+	h.Del(contentLength)
+	h.Del(acceptRanges)
+	if tag := h.Get(etag); tag != "" {
+		h.Set(etag, rewriteETag(tag, "", true, false))
+	}
+	if d.head {
+		return
+	}
+
+	pr, pw := io.Pipe()
+	d.pw = pw
+	d.done = make(chan error, 1)
+	go func() {
+		zr := body(ioutil.NopCloser(pr))
+		_, err := io.Copy(lockedWriter{d}, zr)
+		zr.Close()
+		// Unblock writes if decompression failed.
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+}
+
+// close waits for decompression to finish.
+func (d *decompressWriter) close() error {
+	if d.pw == nil {
+		return nil
+	}
+	d.pw.Close()
+	err := <-d.done
+	d.pw = nil
+	return err
+}
+
+// Flush flushes the underlying ResponseWriter, if it is an http.Flusher.
+// Data that has not been decompressed yet is not flushed.
+func (d *decompressWriter) Flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if fw, ok := d.ResponseWriter.(http.Flusher); ok {
+		fw.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter.
+func (d *decompressWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// lockedWriter writes decompressed data to the underlying ResponseWriter.
+type lockedWriter struct {
+	d *decompressWriter
+}
+
+func (l lockedWriter) Write(b []byte) (int, error) {
+	l.d.mu.Lock()
+	defer l.d.mu.Unlock()
+	return l.d.ResponseWriter.Write(b)
+}
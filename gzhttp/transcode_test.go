@@ -0,0 +1,124 @@
+// Copyright (c) 2021 Klaus Post. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gzhttp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecompressUnaccepted(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(testBody)
+	gw.Close()
+	enc, _ := zstd.NewWriter(nil)
+	zst := enc.EncodeAll(testBody, nil)
+	enc.Close()
+
+	wrapper, err := NewWrapper(EnableZstd(), DecompressUnaccepted())
+	assertNil(t, err)
+	handler := func(encoding string, body []byte) http.Handler {
+		return wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", encoding)
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("ETag", `"abc"`)
+			// Write in parts.
+			w.Write(body[:len(body)/2])
+			w.Write(body[len(body)/2:])
+		}))
+	}
+
+	tests := []struct {
+		name, accept string
+		encoding     string
+		body         []byte
+		wantEncoding string
+		wantETag     string
+		passThrough  bool
+	}{
+		{name: "gzip-accepted", accept: "gzip", encoding: "gzip", body: gz.Bytes(), wantEncoding: "gzip", wantETag: `"abc"`, passThrough: true},
+		{name: "gzip-identity", accept: "", encoding: "gzip", body: gz.Bytes(), wantEncoding: "", wantETag: `W/"abc"`},
+		{name: "gzip-to-zstd", accept: "zstd", encoding: "gzip", body: gz.Bytes(), wantEncoding: "zstd", wantETag: `W/"abc"`},
+		{name: "zstd-to-gzip", accept: "gzip", encoding: "zstd", body: zst, wantEncoding: "gzip", wantETag: `W/"abc"`},
+		{name: "zstd-accepted", accept: "zstd", encoding: "zstd", body: zst, wantEncoding: "zstd", wantETag: `"abc"`, passThrough: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept-Encoding", test.accept)
+			}
+			resp := httptest.NewRecorder()
+			handler(test.encoding, test.body).ServeHTTP(resp, req)
+			res := resp.Result()
+			assertEqual(t, test.wantEncoding, res.Header.Get("Content-Encoding"))
+			assertEqual(t, test.wantETag, res.Header.Get("ETag"))
+			got, _ := ioutil.ReadAll(res.Body)
+			if test.passThrough {
+				assertEqual(t, test.body, got)
+				return
+			}
+			assertEqual(t, "", res.Header.Get("Content-Length"))
+			switch test.wantEncoding {
+			case "gzip":
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				assertNil(t, err)
+				got, err = ioutil.ReadAll(zr)
+				assertNil(t, err)
+			case "zstd":
+				dec, err := zstd.NewReader(nil)
+				assertNil(t, err)
+				got, err = dec.DecodeAll(got, nil)
+				dec.Close()
+				assertNil(t, err)
+			}
+			assertEqual(t, testBody, got)
+		})
+	}
+}
+
+func TestDecompressUnacceptedDisabled(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(testBody)
+	gw.Close()
+	handler := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	// Sent as-is by default.
+	assertEqual(t, "gzip", resp.Header().Get("Content-Encoding"))
+	assertEqual(t, gz.Bytes(), resp.Body.Bytes())
+}
+
+func TestDecompressUnacceptedInvalid(t *testing.T) {
+	wrapper, err := NewWrapper(DecompressUnaccepted())
+	assertNil(t, err)
+	var writeErr error
+	handler := wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		// Keep writing until the decompression error is returned.
+		for i := 0; i < 100 && writeErr == nil; i++ {
+			_, writeErr = w.Write(testBody)
+		}
+	}))
+	req, _ := http.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	assertNotNil(t, writeErr)
+	assertEqual(t, "", resp.Header().Get("Content-Encoding"))
+}
@@ -10,20 +10,49 @@ import (
 	"sync"
 
 	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Transport will wrap a transport with a custom gzip handler
 // that will request gzip and automatically decompress it.
 // Using this is significantly faster than using the default transport.
-func Transport(parent http.RoundTripper) http.RoundTripper {
-	return gzRoundtripper{parent: parent}
+// zstd can be requested as well with the TransportEnableZstd option.
+func Transport(parent http.RoundTripper, opts ...transportOption) http.RoundTripper {
+	g := gzRoundtripper{parent: parent}
+	for _, o := range opts {
+		o(&g)
+	}
+	return g
 }
 
+type transportOption func(c *gzRoundtripper)
+
+// TransportEnableZstd will request zstd compression in addition to gzip.
+// Responses are decoded with a window of at most TransportZstdMaxWindow bytes.
+// Default is false.
+func TransportEnableZstd(b bool) transportOption {
+	return func(c *gzRoundtripper) {
+		c.withZstd = b
+	}
+}
+
+// TransportZstdMaxWindow is the largest zstd window the transport will decode.
+// Responses using a larger window return an error when read.
+const TransportZstdMaxWindow = 32 << 20
+
 type gzRoundtripper struct {
-	parent http.RoundTripper
+	parent   http.RoundTripper
+	withZstd bool
+
+	requestEncoding string // Compress request bodies with this encoding, if set.
+	requestMinSize  int64
 }
 
 func (g gzRoundtripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, err := g.compressRequest(req)
+	if err != nil {
+		return nil, err
+	}
 	var requestedGzip bool
 	if req.Header.Get("Accept-Encoding") == "" &&
 		req.Header.Get("Range") == "" &&
@@ -41,19 +70,28 @@ func (g gzRoundtripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		// auto-decoding a portion of a gzipped document will just fail
 		// anyway. See https://golang.org/issue/8923
 		requestedGzip = true
-		req.Header.Set("Accept-Encoding", "gzip")
+		if g.withZstd {
+			req.Header.Set("Accept-Encoding", "zstd,gzip")
+		} else {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
 	}
 	resp, err := g.parent.RoundTrip(req)
 	if err != nil || !requestedGzip {
 		return resp, err
 	}
-	if asciiEqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+	switch ce := resp.Header.Get("Content-Encoding"); {
+	case asciiEqualFold(ce, "gzip"):
 		resp.Body = &gzipReader{body: resp.Body}
-		resp.Header.Del("Content-Encoding")
-		resp.Header.Del("Content-Length")
-		resp.ContentLength = -1
-		resp.Uncompressed = true
+	case g.withZstd && asciiEqualFold(ce, "zstd"):
+		resp.Body = &zstdReader{body: resp.Body}
+	default:
+		return resp, nil
 	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
 	return resp, nil
 }
 
@@ -93,6 +131,47 @@ func (gz *gzipReader) Close() error {
 	return gz.body.Close()
 }
 
+var zstdReaderPool sync.Pool
+
+// zstdReader wraps a response body so it can lazily
+// create a zstd decoder on the first call to Read.
+type zstdReader struct {
+	body io.ReadCloser // underlying HTTP/1 response body framing
+	zr   *zstd.Decoder // lazily-initialized zstd reader
+	zerr error         // any error from zstd.NewReader; sticky
+}
+
+func (zr *zstdReader) Read(p []byte) (n int, err error) {
+	if zr.zr == nil {
+		if zr.zerr == nil {
+			dec, ok := zstdReaderPool.Get().(*zstd.Decoder)
+			if ok {
+				zr.zerr = dec.Reset(zr.body)
+			} else {
+				dec, zr.zerr = zstd.NewReader(zr.body, zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxMemory(TransportZstdMaxWindow), zstd.WithDecoderConcurrency(1))
+			}
+			if zr.zerr == nil {
+				zr.zr = dec
+			}
+		}
+		if zr.zr == nil {
+			return 0, zr.zerr
+		}
+	}
+	return zr.zr.Read(p)
+}
+
+func (zr *zstdReader) Close() error {
+	if zr.zr != nil {
+		// Release the body before returning the decoder to the pool.
+		if err := zr.zr.Reset(nil); err == nil {
+			zstdReaderPool.Put(zr.zr)
+		}
+		zr.zr = nil
+	}
+	return zr.body.Close()
+}
+
 // asciiEqualFold is strings.EqualFold, ASCII only. It reports whether s and t
 // are equal, ASCII-case-insensitively.
 func asciiEqualFold(s, t string) bool {
@@ -58,6 +58,89 @@ func TestTransportInvalid(t *testing.T) {
 	}
 }
 
+func TestTransportZstd(t *testing.T) {
+	bin, err := ioutil.ReadFile("testdata/benchmark.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapper, err := NewWrapper(EnableZstd())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotEncoding string
+	var accepted string
+	server := httptest.NewServer(wrapper(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		w.Write(bin)
+	})))
+	defer server.Close()
+
+	for _, enable := range []bool{true, false} {
+		// Record the encoding used on the wire.
+		wire := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err == nil {
+				gotEncoding = resp.Header.Get("Content-Encoding")
+			}
+			return resp, err
+		})
+		c := http.Client{Transport: Transport(wire, TransportEnableZstd(enable))}
+		resp, err := c.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if !bytes.Equal(got, bin) {
+			t.Errorf("data mismatch")
+		}
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Content-Encoding not removed: %q", resp.Header.Get("Content-Encoding"))
+		}
+		if !resp.Uncompressed {
+			t.Error("response not marked as uncompressed")
+		}
+		want, wantAccept := "gzip", "gzip"
+		if enable {
+			want, wantAccept = "zstd", "zstd,gzip"
+		}
+		if gotEncoding != want {
+			t.Errorf("got encoding %q, want %q", gotEncoding, want)
+		}
+		if accepted != wantAccept {
+			t.Errorf("got Accept-Encoding %q, want %q", accepted, wantAccept)
+		}
+	}
+}
+
+func TestTransportZstdInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write([]byte("not zstd data"))
+	}))
+	defer server.Close()
+
+	c := http.Client{Transport: Transport(http.DefaultTransport, TransportEnableZstd(true))}
+	resp, err := c.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, err = ioutil.ReadAll(resp.Body)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 func TestDefaultTransport(t *testing.T) {
 	bin, err := ioutil.ReadFile("testdata/benchmark.json")
 	if err != nil {
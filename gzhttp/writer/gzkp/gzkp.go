@@ -4,16 +4,19 @@ package gzkp
 
 import (
 	"io"
-	"sync"
 
+	"github.com/klauspost/compress/budget"
 	"github.com/klauspost/compress/gzhttp/writer"
 	"github.com/klauspost/compress/gzip"
 )
 
-// gzipWriterPools stores a sync.Pool for each compression level for reuse of
+// gzipWriterPools stores a pool for each compression level for reuse of
 // gzip.Writers. Use poolIndex to covert a compression level to an index into
 // gzipWriterPools.
-var gzipWriterPools [gzip.BestCompression - gzip.StatelessCompression + 1]*sync.Pool
+var gzipWriterPools [gzip.BestCompression - gzip.StatelessCompression + 1]*budget.Pool
+
+// writerSize is the approximate memory retained by a gzip.Writer.
+const writerSize = 1 << 20
 
 func init() {
 	for i := gzip.StatelessCompression; i <= gzip.BestCompression; i++ {
@@ -28,7 +31,9 @@ func poolIndex(level int) int {
 }
 
 func addLevelPool(level int) {
-	gzipWriterPools[poolIndex(level)] = &sync.Pool{
+	gzipWriterPools[poolIndex(level)] = &budget.Pool{
+		Name: "gzhttp.gzkp",
+		Size: func(interface{}) int64 { return writerSize },
 		New: func() interface{} {
 			// NewWriterLevel only returns error on a bad level, we are guaranteeing
 			// that this will be a valid level so it is okay to ignore the returned
@@ -51,6 +56,18 @@ func (pw *pooledWriter) Close() error {
 	return err
 }
 
+// verify GzipWriterExt interface implementation
+var _ writer.GzipWriterExt = &pooledWriter{}
+
+// SetHeader sets the gzip header of the stream.
+func (pw *pooledWriter) SetHeader(h writer.Header) {
+	pw.Header.Comment = h.Comment
+	pw.Header.Extra = h.Extra
+	pw.Header.ModTime = h.ModTime
+	pw.Header.Name = h.Name
+	pw.Header.OS = h.OS
+}
+
 func NewWriter(w io.Writer, level int) writer.GzipWriter {
 	index := poolIndex(level)
 	gzw := gzipWriterPools[index].Get().(*gzip.Writer)
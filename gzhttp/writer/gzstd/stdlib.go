@@ -51,6 +51,18 @@ func (pw *pooledWriter) Close() error {
 	return err
 }
 
+// verify GzipWriterExt interface implementation
+var _ writer.GzipWriterExt = &pooledWriter{}
+
+// SetHeader sets the gzip header of the stream.
+func (pw *pooledWriter) SetHeader(h writer.Header) {
+	pw.Header.Comment = h.Comment
+	pw.Header.Extra = h.Extra
+	pw.Header.ModTime = h.ModTime
+	pw.Header.Name = h.Name
+	pw.Header.OS = h.OS
+}
+
 func NewWriter(w io.Writer, level int) writer.GzipWriter {
 	index := poolIndex(level)
 	gzw := gzipWriterPools[index].Get().(*gzip.Writer)
@@ -1,6 +1,9 @@
 package writer
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 // GzipWriter implements the functions needed for compressing content.
 type GzipWriter interface {
@@ -9,6 +12,26 @@ type GzipWriter interface {
 	Flush() error
 }
 
+// GzipWriterExt is implemented by gzip writers that allow
+// setting the gzip header.
+type GzipWriterExt interface {
+	GzipWriter
+
+	// SetHeader sets the header of the gzip stream.
+	// It must be called before the first Write or Flush.
+	SetHeader(h Header)
+}
+
+// Header is a gzip header.
+// See the Header type in the gzip package.
+type Header struct {
+	Comment string    // comment
+	Extra   []byte    // "extra data"
+	ModTime time.Time // modification time
+	Name    string    // file name
+	OS      byte      // operating system type
+}
+
 // GzipWriterFactory contains the information needed for custom gzip implementations.
 type GzipWriterFactory struct {
 	// Must return the minimum and maximum supported level.
@@ -18,3 +41,8 @@ type GzipWriterFactory struct {
 	// level will always be within the return limits above.
 	New func(writer io.Writer, level int) GzipWriter
 }
+
+// EncoderFactory returns a new encoder for a content coding writing to w.
+// The compression level, if any, is chosen by the factory.
+// The returned writer is closed when the response is complete.
+type EncoderFactory func(w io.Writer) GzipWriter
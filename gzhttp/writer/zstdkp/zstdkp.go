@@ -0,0 +1,88 @@
+// Package zstdkp provides zstd compression through github.com/klauspost/compress/zstd.
+
+package zstdkp
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/budget"
+	"github.com/klauspost/compress/gzhttp/writer"
+	"github.com/klauspost/compress/zstd"
+)
+
+// WindowSize is the window size used by the encoders.
+// Responses are usually small, and decoders are only required to support
+// windows up to 8MB for Content-Encoding, so it is limited to reduce memory use.
+const WindowSize = 1 << 20
+
+// encoderPools stores a pool for each compression level for reuse of encoders.
+var encoderPools [zstd.SpeedBestCompression - zstd.SpeedFastest + 1]*budget.Pool
+
+// encoderSize is the approximate memory retained by an encoder.
+const encoderSize = 2 * WindowSize
+
+func init() {
+	for i := zstd.SpeedFastest; i <= zstd.SpeedBestCompression; i++ {
+		addLevelPool(i)
+	}
+}
+
+// poolIndex maps a compression level to its index into encoderPools.
+// It assumes that level is a valid level.
+func poolIndex(level zstd.EncoderLevel) int {
+	return int(level - zstd.SpeedFastest)
+}
+
+func addLevelPool(level zstd.EncoderLevel) {
+	encoderPools[poolIndex(level)] = &budget.Pool{
+		Name: "gzhttp.zstdkp",
+		Size: func(interface{}) int64 { return encoderSize },
+		New: func() interface{} {
+			// Options are valid, so no error is returned.
+			enc, _ := zstd.NewWriter(nil,
+				zstd.WithEncoderLevel(level),
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(WindowSize),
+				zstd.WithLowerEncoderMem(true))
+			return enc
+		},
+	}
+}
+
+type pooledWriter struct {
+	*zstd.Encoder
+	index int
+}
+
+func (pw *pooledWriter) Close() error {
+	if pw.Encoder == nil {
+		return nil
+	}
+	err := pw.Encoder.Close()
+	// Release the reference to the output.
+	pw.Encoder.Reset(nil)
+	encoderPools[pw.index].Put(pw.Encoder)
+	pw.Encoder = nil
+	return err
+}
+
+// NewWriter returns a writer that compresses to w with the level.
+// The level must be a zstd.EncoderLevel within the limits returned by Levels.
+func NewWriter(w io.Writer, level int) writer.GzipWriter {
+	index := poolIndex(zstd.EncoderLevel(level))
+	enc := encoderPools[index].Get().(*zstd.Encoder)
+	enc.Reset(w)
+	return &pooledWriter{
+		Encoder: enc,
+		index:   index,
+	}
+}
+
+// Levels returns the supported levels, which are zstd.EncoderLevel values.
+func Levels() (min, max int) {
+	return int(zstd.SpeedFastest), int(zstd.SpeedBestCompression)
+}
+
+func ImplementationInfo() string {
+	return "klauspost/compress/zstd"
+}
@@ -0,0 +1,39 @@
+package zstdkp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestRoundTrip(t *testing.T) {
+	min, max := Levels()
+	want := bytes.Repeat([]byte("hello zstd "), 1000)
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := min; level <= max; level++ {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, level)
+		if _, err := w.Write(want); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// A second close must not return the encoder to the pool again.
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := dec.DecodeAll(buf.Bytes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("level %d: mismatch", level)
+		}
+	}
+}
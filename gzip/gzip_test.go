@@ -34,6 +34,9 @@ func TestEmpty(t *testing.T) {
 	if len(b) != 0 {
 		t.Fatalf("got %d bytes, want 0", len(b))
 	}
+	if r.ModTime.Unix() != 0 {
+		t.Fatalf("mtime is %d, want 0", r.ModTime.Unix())
+	}
 	if err := r.Close(); err != nil {
 		t.Fatalf("Reader.Close: %v", err)
 	}
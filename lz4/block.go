@@ -0,0 +1,219 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	minMatch     = 4
+	maxOffset    = 65535
+	lastLiterals = 5  // The last 5 bytes of a block are always literals.
+	mfLimit      = 12 // The last match must start at least 12 bytes before the end.
+
+	tableBits = 16
+	tableSize = 1 << tableBits
+)
+
+// MaxEncodedLen returns the maximum size of an LZ4 block
+// with srcLen bytes of input.
+func MaxEncodedLen(srcLen int) int {
+	return srcLen + srcLen/255 + 16
+}
+
+var tablePool = sync.Pool{New: func() interface{} { return new([tableSize]int32) }}
+
+// EncodeBlock appends the LZ4 block encoded src to dst and returns the result.
+// The output does not contain the uncompressed size,
+// which must be stored separately for the block to be decoded.
+func EncodeBlock(dst, src []byte) []byte {
+	t := tablePool.Get().(*[tableSize]int32)
+	dst = encodeBlock(dst, src, t)
+	tablePool.Put(t)
+	return dst
+}
+
+func hash4(u uint32) uint32 {
+	return (u * prime32x1) >> (32 - tableBits)
+}
+
+// encodeBlock encodes src using a greedy parse.
+// The table is cleared before use.
+func encodeBlock(dst, src []byte, table *[tableSize]int32) []byte {
+	if len(src) <= mfLimit {
+		return emitLiterals(dst, src)
+	}
+	for i := range table {
+		table[i] = 0
+	}
+	// Table entries are positions + 1, so 0 means empty.
+	sLimit := len(src) - mfLimit
+	maxEnd := len(src) - lastLiterals
+	var anchor int
+	s := 0
+	for s < sLimit {
+		cv := binary.LittleEndian.Uint32(src[s:])
+		h := hash4(cv)
+		cand := int(table[h]) - 1
+		table[h] = int32(s + 1)
+		if cand < 0 || s-cand > maxOffset || binary.LittleEndian.Uint32(src[cand:]) != cv {
+			// Skip faster over incompressible data.
+			s += 1 + (s-anchor)>>6
+			continue
+		}
+		// Extend backwards.
+		for s > anchor && cand > 0 && src[s-1] == src[cand-1] {
+			s--
+			cand--
+		}
+		// Extend forwards.
+		l := minMatch
+		for s+l < maxEnd && src[s+l] == src[cand+l] {
+			l++
+		}
+		dst = emitSequence(dst, src[anchor:s], s-cand, l)
+		s += l
+		anchor = s
+		// Index a position within the match.
+		if s < sLimit {
+			table[hash4(binary.LittleEndian.Uint32(src[s-2:]))] = int32(s - 2 + 1)
+		}
+	}
+	return emitLiterals(dst, src[anchor:])
+}
+
+// appendLength appends the length extension bytes of n.
+func appendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+// emitSequence appends literals followed by a match.
+func emitSequence(dst, lits []byte, offset, length int) []byte {
+	ll, ml := len(lits), length-minMatch
+	var token byte
+	if ll >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(ll) << 4
+	}
+	if ml >= 15 {
+		token |= 15
+	} else {
+		token |= byte(ml)
+	}
+	dst = append(dst, token)
+	if ll >= 15 {
+		dst = appendLength(dst, ll-15)
+	}
+	dst = append(dst, lits...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if ml >= 15 {
+		dst = appendLength(dst, ml-15)
+	}
+	return dst
+}
+
+// emitLiterals appends the final literals-only sequence.
+func emitLiterals(dst, lits []byte) []byte {
+	ll := len(lits)
+	if ll < 15 {
+		dst = append(dst, byte(ll)<<4)
+	} else {
+		dst = append(dst, 15<<4)
+		dst = appendLength(dst, ll-15)
+	}
+	return append(dst, lits...)
+}
+
+// DecodeBlock appends the decoded LZ4 block src to dst and returns the result.
+// At most maxSize bytes will be decoded. If maxSize is <= 0, 4MB is used,
+// which is the maximum block size of the frame format.
+func DecodeBlock(dst, src []byte, maxSize int) ([]byte, error) {
+	if maxSize <= 0 {
+		maxSize = maxBlockSize
+	}
+	return decodeBlock(dst, len(dst), src, maxSize)
+}
+
+// decodeBlock appends the decoded block to dst.
+// Matches can reference dst from histStart.
+// At most maxSize bytes are added to dst.
+func decodeBlock(dst []byte, histStart int, src []byte, maxSize int) ([]byte, error) {
+	start := len(dst)
+	readLength := func(s, n int) (int, int, error) {
+		for {
+			if s >= len(src) {
+				return 0, 0, ErrCorrupt
+			}
+			b := src[s]
+			s++
+			n += int(b)
+			if b != 255 {
+				return s, n, nil
+			}
+			if n > maxSize {
+				return 0, 0, ErrCorrupt
+			}
+		}
+	}
+	var err error
+	s := 0
+	for s < len(src) {
+		token := src[s]
+		s++
+
+		// Literals
+		ll := int(token >> 4)
+		if ll == 15 {
+			if s, ll, err = readLength(s, ll); err != nil {
+				return dst, err
+			}
+		}
+		if ll > len(src)-s || len(dst)-start+ll > maxSize {
+			return dst, ErrCorrupt
+		}
+		dst = append(dst, src[s:s+ll]...)
+		s += ll
+		if s == len(src) {
+			// The last sequence has no match.
+			return dst, nil
+		}
+
+		// Match
+		if s+2 > len(src) {
+			return dst, ErrCorrupt
+		}
+		offset := int(src[s]) | int(src[s+1])<<8
+		s += 2
+		if offset == 0 || offset > len(dst)-histStart {
+			return dst, ErrCorrupt
+		}
+		ml := int(token & 15)
+		if ml == 15 {
+			if s, ml, err = readLength(s, ml); err != nil {
+				return dst, err
+			}
+		}
+		ml += minMatch
+		if len(dst)-start+ml > maxSize {
+			return dst, ErrCorrupt
+		}
+		pos := len(dst) - offset
+		if offset >= ml {
+			dst = append(dst, dst[pos:pos+ml]...)
+			continue
+		}
+		// Overlapping copy.
+		for i := 0; i < ml; i++ {
+			dst = append(dst, dst[pos+i])
+		}
+	}
+	// Blocks must end with literals.
+	return dst, ErrCorrupt
+}
@@ -0,0 +1,67 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package lz4 implements the LZ4 block and frame formats.
+//
+// The frame format is described at
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Frame_format.md
+// and the block format at
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md
+//
+// Frames with dictionary IDs and the legacy frame format are not supported.
+package lz4
+
+import "errors"
+
+const (
+	frameMagic         = 0x184D2204
+	skippableMagic     = 0x184D2A50
+	skippableMagicMask = 0xFFFFFFF0
+
+	// Frame descriptor flags.
+	flagVersion         = 1 << 6
+	flagVersionMask     = 3 << 6
+	flagBlockIndep      = 1 << 5
+	flagBlockChecksum   = 1 << 4
+	flagContentSize     = 1 << 3
+	flagContentChecksum = 1 << 2
+	flagReserved        = 1 << 1
+	flagDictID          = 1 << 0
+
+	// uncompressedBit is set on block sizes for uncompressed blocks.
+	uncompressedBit = 1 << 31
+
+	// windowSize is the maximum distance matches can reference.
+	windowSize = 64 << 10
+
+	maxBlockSize = 4 << 20
+)
+
+var (
+	// ErrCorrupt reports that the input is invalid.
+	ErrCorrupt = errors.New("lz4: corrupt input")
+
+	// ErrChecksum reports a checksum mismatch.
+	ErrChecksum = errors.New("lz4: invalid checksum")
+
+	// ErrUnsupported reports that the input uses unsupported features.
+	ErrUnsupported = errors.New("lz4: unsupported input")
+
+	errClosed = errors.New("lz4: writer is closed")
+)
+
+// blockSizeID returns the frame block size ID of a block size.
+// 0 is returned if the size isn't valid.
+func blockSizeID(size int) byte {
+	switch size {
+	case 64 << 10:
+		return 4
+	case 256 << 10:
+		return 5
+	case 1 << 20:
+		return 6
+	case 4 << 20:
+		return 7
+	}
+	return 0
+}
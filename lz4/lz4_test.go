@@ -0,0 +1,239 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func testInputs(t testing.TB) map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 100<<10)
+	rng.Read(random)
+	inputs := map[string][]byte{
+		"empty":   {},
+		"one":     {1},
+		"short":   []byte("hello world"),
+		"repeat":  bytes.Repeat([]byte{'a'}, 1<<20),
+		"pattern": bytes.Repeat([]byte("abcdefghijklmnopqrstuvwxyz0123456789"), 100000),
+		"random":  random,
+	}
+	for _, name := range []string{"Mark.Twain-Tom.Sawyer.txt", "e.txt", "html.txt", "pngdata.bin", "sharnd.out"} {
+		b, err := ioutil.ReadFile("../testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[name] = b
+	}
+	return inputs
+}
+
+func TestXXH32(t *testing.T) {
+	for in, want := range map[string]uint32{
+		"":    0x02cc5d05,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	} {
+		if got := xxh32Sum([]byte(in)); got != want {
+			t.Errorf("%q: got 0x%08x, want 0x%08x", in, got, want)
+		}
+	}
+	// Streaming must match.
+	b := bytes.Repeat([]byte("0123456789"), 100)
+	want := xxh32Sum(b)
+	for _, step := range []int{1, 3, 15, 16, 17, 100} {
+		var d xxh32
+		d.reset()
+		for i := 0; i < len(b); i += step {
+			end := i + step
+			if end > len(b) {
+				end = len(b)
+			}
+			d.write(b[i:end])
+		}
+		if got := d.sum(); got != want {
+			t.Errorf("step %d: got 0x%08x, want 0x%08x", step, got, want)
+		}
+	}
+}
+
+func TestBlock(t *testing.T) {
+	for name, in := range testInputs(t) {
+		t.Run(name, func(t *testing.T) {
+			if len(in) > maxBlockSize {
+				in = in[:maxBlockSize]
+			}
+			enc := EncodeBlock(nil, in)
+			if len(enc) > MaxEncodedLen(len(in)) {
+				t.Errorf("encoded size %d > max %d", len(enc), MaxEncodedLen(len(in)))
+			}
+			t.Logf("%d -> %d bytes", len(in), len(enc))
+			dec, err := DecodeBlock([]byte("prefix"), enc, len(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dec[6:], in) {
+				t.Fatal("output mismatch")
+			}
+			if len(in) > 1 {
+				if _, err := DecodeBlock(nil, enc, len(in)-1); err == nil {
+					t.Error("expected error on too small max size")
+				}
+			}
+		})
+	}
+}
+
+func TestBlockCorrupt(t *testing.T) {
+	for _, in := range [][]byte{
+		{},
+		{0xf0},                 // missing literal length
+		{0x20, 'a'},            // short literals
+		{0x10, 'a', 0, 0},      // zero offset
+		{0x10, 'a', 2, 0},      // offset before start
+		{0x10, 'a', 1, 0},      // ends with match
+		{0x1f, 'a', 1, 0, 255}, // missing match length
+	} {
+		if _, err := DecodeBlock(nil, in, 0); err != ErrCorrupt {
+			t.Errorf("%x: got error %v", in, err)
+		}
+	}
+	// Random input must not panic.
+	rng := rand.New(rand.NewSource(1))
+	enc := EncodeBlock(nil, bytes.Repeat([]byte("hello world, hello lz4 "), 100))
+	for i := 0; i < 10000; i++ {
+		b := append([]byte(nil), enc...)
+		b[rng.Intn(len(b))] = byte(rng.Intn(256))
+		_, _ = DecodeBlock(nil, b[:rng.Intn(len(b))], 1<<16)
+	}
+}
+
+func TestFrame(t *testing.T) {
+	opts := map[string][]WriterOption{
+		"default":   nil,
+		"64k":       {WriterBlockSize(64 << 10)},
+		"nocrc":     {WriterChecksum(false)},
+		"blockcrc":  {WriterBlockSize(256 << 10), WriterBlockChecksum(true)},
+		"1m-allcrc": {WriterBlockSize(1 << 20), WriterBlockChecksum(true), WriterChecksum(true)},
+	}
+	for name, in := range testInputs(t) {
+		for oname, o := range opts {
+			t.Run(name+"-"+oname, func(t *testing.T) {
+				var buf bytes.Buffer
+				w := NewWriter(&buf, o...)
+				// Write in pieces.
+				for i := 0; i < len(in); i += 100000 {
+					end := i + 100000
+					if end > len(in) {
+						end = len(in)
+					}
+					if _, err := w.Write(in[i:end]); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := w.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := w.Write([]byte{1}); err == nil {
+					t.Error("expected error writing to closed writer")
+				}
+				got, err := ioutil.ReadAll(NewReader(&buf))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Fatal("output mismatch")
+				}
+			})
+		}
+	}
+}
+
+func TestFrameEmpty(t *testing.T) {
+	// Output of the lz4 commandline tool with empty input.
+	want, _ := hex.DecodeString("04224d186440a700000000055dcc02")
+	var buf bytes.Buffer
+	w := NewWriter(&buf, WriterBlockSize(64<<10))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("got %x, want %x", buf.Bytes(), want)
+	}
+	got, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil || len(got) != 0 {
+		t.Fatal(got, err)
+	}
+}
+
+func TestReaderConcatSkippable(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var want []byte
+	for i := 0; i < 3; i++ {
+		// Skippable frame.
+		buf.Write([]byte{0x5a, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 1, 2, 3})
+		w.Reset(&buf)
+		b := bytes.Repeat([]byte{byte('a' + i)}, 1000)
+		want = append(want, b...)
+		w.Write(b)
+		w.Close()
+	}
+	var out bytes.Buffer
+	n, err := NewReader(&buf).WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != len(want) || !bytes.Equal(out.Bytes(), want) {
+		t.Fatal("output mismatch")
+	}
+}
+
+func TestReaderDependentBlocks(t *testing.T) {
+	first := []byte("0123456789abcdef")
+	want := append(append([]byte{}, first...), "0123456789abcdefghijk"...)
+	flg := []byte{flagVersion | flagContentChecksum, 4 << 4}
+	stream := []byte{0x04, 0x22, 0x4d, 0x18}
+	stream = append(stream, flg...)
+	stream = append(stream, byte(xxh32Sum(flg)>>8))
+	// Uncompressed first block.
+	stream = append(stream, byte(len(first)), 0, 0, 0x80)
+	stream = append(stream, first...)
+	// Second block referencing the first.
+	second := []byte{0x0c, 16, 0, 0x50, 'g', 'h', 'i', 'j', 'k'}
+	stream = append(stream, byte(len(second)), 0, 0, 0)
+	stream = append(stream, second...)
+	stream = append(stream, 0, 0, 0, 0)
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], xxh32Sum(want))
+	stream = append(stream, tmp[:]...)
+
+	got, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// Corrupt content checksum.
+	stream[len(stream)-1]++
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream))); err != ErrChecksum {
+		t.Errorf("got error %v, want %v", err, ErrChecksum)
+	}
+	// Truncated.
+	if _, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream[:len(stream)-2]))); err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestWriterOptions(t *testing.T) {
+	w := NewWriter(ioutil.Discard, WriterBlockSize(1000))
+	if _, err := w.Write([]byte("hello")); err == nil {
+		t.Error("expected error on invalid block size")
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// Reader decompresses LZ4 frames.
+// Concatenated frames are read as a single stream,
+// and skippable frames are skipped.
+type Reader struct {
+	r   io.Reader
+	err error
+	tmp [16]byte
+
+	// Current frame.
+	inFrame         bool
+	blockSize       int
+	independent     bool
+	blockChecksum   bool
+	contentChecksum bool
+	hasContentSize  bool
+	contentSize     uint64
+	read            uint64
+	digest          xxh32
+
+	buf  []byte // compressed block
+	hist []byte // decompressed data, including history for dependent blocks
+	out  []byte // unread part of hist
+}
+
+// NewReader returns a new Reader that decompresses from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Reset discards the Reader's state and makes it equivalent to
+// the result of NewReader, but reading from r instead.
+// Buffers are reused.
+func (r *Reader) Reset(rd io.Reader) {
+	r.r = rd
+	r.err = nil
+	r.inFrame = false
+	r.out = nil
+	r.hist = r.hist[:0]
+}
+
+// Read decompresses into p.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.nextBlock()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// WriteTo writes all decompressed data to w.
+func (r *Reader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if len(r.out) > 0 {
+			n, err := w.Write(r.out)
+			total += int64(n)
+			r.out = r.out[n:]
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+		if r.err != nil {
+			if r.err == io.EOF {
+				return total, nil
+			}
+			return total, r.err
+		}
+		r.err = r.nextBlock()
+	}
+}
+
+// readFull reads exactly len(b) bytes.
+// An EOF before any data is read at a frame boundary is returned as io.EOF.
+func (r *Reader) readFull(b []byte, allowEOF bool) error {
+	n, err := io.ReadFull(r.r, b)
+	if err == io.EOF && (!allowEOF || n > 0) || err == io.ErrUnexpectedEOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readHeader reads the next frame header, skipping skippable frames.
+func (r *Reader) readHeader() error {
+	for {
+		if err := r.readFull(r.tmp[:4], true); err != nil {
+			return err
+		}
+		magic := binary.LittleEndian.Uint32(r.tmp[:4])
+		if magic&skippableMagicMask == skippableMagic {
+			if err := r.readFull(r.tmp[:4], false); err != nil {
+				return err
+			}
+			n := int64(binary.LittleEndian.Uint32(r.tmp[:4]))
+			if m, err := io.CopyN(ioutil.Discard, r.r, n); err != nil || m != n {
+				return io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		if magic != frameMagic {
+			return ErrCorrupt
+		}
+		break
+	}
+	// Descriptor
+	desc := r.tmp[:2]
+	if err := r.readFull(desc, false); err != nil {
+		return err
+	}
+	flg, bd := desc[0], desc[1]
+	if flg&flagVersionMask != flagVersion || flg&flagReserved != 0 || bd&0x8f != 0 {
+		return ErrCorrupt
+	}
+	if flg&flagDictID != 0 {
+		return ErrUnsupported
+	}
+	id := (bd >> 4) & 7
+	if id < 4 {
+		return ErrCorrupt
+	}
+	r.blockSize = 1 << (8 + 2*uint(id))
+	r.independent = flg&flagBlockIndep != 0
+	r.blockChecksum = flg&flagBlockChecksum != 0
+	r.contentChecksum = flg&flagContentChecksum != 0
+	r.hasContentSize = flg&flagContentSize != 0
+	n := 2
+	if r.hasContentSize {
+		if err := r.readFull(r.tmp[n:n+8], false); err != nil {
+			return err
+		}
+		r.contentSize = binary.LittleEndian.Uint64(r.tmp[n:])
+		n += 8
+	}
+	// Header checksum
+	if err := r.readFull(r.tmp[n:n+1], false); err != nil {
+		return err
+	}
+	if byte(xxh32Sum(r.tmp[:n])>>8) != r.tmp[n] {
+		return ErrChecksum
+	}
+	r.digest.reset()
+	r.read = 0
+	r.hist = r.hist[:0]
+	r.inFrame = true
+	return nil
+}
+
+// nextBlock decodes the next block into r.out.
+// If the end of the input is reached io.EOF is returned.
+func (r *Reader) nextBlock() error {
+	if !r.inFrame {
+		if err := r.readHeader(); err != nil {
+			return err
+		}
+	}
+	if err := r.readFull(r.tmp[:4], false); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.Uint32(r.tmp[:4])
+	if size == 0 {
+		// End mark
+		r.inFrame = false
+		if r.contentChecksum {
+			if err := r.readFull(r.tmp[:4], false); err != nil {
+				return err
+			}
+			if binary.LittleEndian.Uint32(r.tmp[:4]) != r.digest.sum() {
+				return ErrChecksum
+			}
+		}
+		if r.hasContentSize && r.read != r.contentSize {
+			return ErrCorrupt
+		}
+		return nil
+	}
+	uncompressed := size&uncompressedBit != 0
+	size &^= uncompressedBit
+	if int(size) > r.blockSize {
+		return ErrCorrupt
+	}
+	if cap(r.buf) < int(size) {
+		r.buf = make([]byte, r.blockSize)
+	}
+	r.buf = r.buf[:size]
+	if err := r.readFull(r.buf, false); err != nil {
+		return err
+	}
+	if r.blockChecksum {
+		if err := r.readFull(r.tmp[:4], false); err != nil {
+			return err
+		}
+		if binary.LittleEndian.Uint32(r.tmp[:4]) != xxh32Sum(r.buf) {
+			return ErrChecksum
+		}
+	}
+
+	// Keep history for dependent blocks.
+	if r.independent || len(r.hist) == 0 {
+		r.hist = r.hist[:0]
+	} else if len(r.hist) > windowSize {
+		r.hist = r.hist[:copy(r.hist, r.hist[len(r.hist)-windowSize:])]
+	}
+	if cap(r.hist) < windowSize+r.blockSize {
+		r.hist = append(make([]byte, 0, windowSize+r.blockSize), r.hist...)
+	}
+	start := len(r.hist)
+	if uncompressed {
+		r.hist = append(r.hist, r.buf...)
+	} else {
+		var err error
+		r.hist, err = decodeBlock(r.hist, 0, r.buf, r.blockSize)
+		if err != nil {
+			return err
+		}
+	}
+	r.out = r.hist[start:]
+	r.read += uint64(len(r.out))
+	if r.contentChecksum {
+		r.digest.write(r.out)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Writer compresses to LZ4 frames.
+// All blocks are independent.
+type Writer struct {
+	w        io.Writer
+	errState error
+
+	blockSize       int
+	blockChecksum   bool
+	contentChecksum bool
+
+	wroteHeader bool
+	digest      xxh32
+	table       *[tableSize]int32
+	buf         []byte // uncompressed input
+	out         []byte // output buffer
+}
+
+// WriterOption is an option for creating an encoder.
+type WriterOption func(*Writer) error
+
+// NewWriter returns a new Writer that compresses to w.
+// By default 4MB blocks are used and a content checksum is added.
+func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
+	w2 := Writer{
+		blockSize:       maxBlockSize,
+		contentChecksum: true,
+	}
+	for _, opt := range opts {
+		if err := opt(&w2); err != nil {
+			w2.errState = err
+			return &w2
+		}
+	}
+	w2.Reset(w)
+	return &w2
+}
+
+// WriterBlockSize sets the maximum size of each block.
+// Valid values are 64KB, 256KB, 1MB and 4MB.
+// Smaller blocks use less memory but compress worse.
+func WriterBlockSize(n int) WriterOption {
+	return func(w *Writer) error {
+		if blockSizeID(n) == 0 {
+			return fmt.Errorf("lz4: invalid block size %d. Must be 64KB, 256KB, 1MB or 4MB", n)
+		}
+		w.blockSize = n
+		return nil
+	}
+}
+
+// WriterChecksum enables or disables the checksum of the content of each frame.
+// Enabled by default.
+func WriterChecksum(b bool) WriterOption {
+	return func(w *Writer) error {
+		w.contentChecksum = b
+		return nil
+	}
+}
+
+// WriterBlockChecksum enables or disables checksums of each block.
+// Disabled by default.
+func WriterBlockChecksum(b bool) WriterOption {
+	return func(w *Writer) error {
+		w.blockChecksum = b
+		return nil
+	}
+}
+
+// Reset discards the writer's state and makes it equivalent to the result
+// of its original state from NewWriter, but writing to w instead.
+// Options are kept.
+func (w *Writer) Reset(writer io.Writer) {
+	w.w = writer
+	w.errState = nil
+	w.wroteHeader = false
+	w.digest.reset()
+	if w.table == nil {
+		w.table = new([tableSize]int32)
+	}
+	if cap(w.buf) < w.blockSize {
+		w.buf = make([]byte, 0, w.blockSize)
+	}
+	w.buf = w.buf[:0]
+}
+
+// Write compresses p.
+// Data is buffered until a full block is available, Flush or Close is called.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.errState != nil {
+		return 0, w.errState
+	}
+	var n int
+	for len(p) > 0 {
+		free := w.blockSize - len(w.buf)
+		todo := p
+		if len(todo) > free {
+			todo = todo[:free]
+		}
+		w.buf = append(w.buf, todo...)
+		n += len(todo)
+		p = p[len(todo):]
+		if len(w.buf) == w.blockSize {
+			if err := w.writeBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom reads all data from r and compresses it.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.errState != nil {
+		return 0, w.errState
+	}
+	var total int64
+	for {
+		n, err := r.Read(w.buf[len(w.buf):w.blockSize])
+		w.buf = w.buf[:len(w.buf)+n]
+		total += int64(n)
+		if len(w.buf) == w.blockSize {
+			if err := w.writeBlock(); err != nil {
+				return total, err
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			w.errState = err
+			return total, err
+		}
+	}
+}
+
+// Flush compresses any buffered data and writes it as a block.
+func (w *Writer) Flush() error {
+	if w.errState != nil {
+		return w.errState
+	}
+	return w.writeBlock()
+}
+
+// Close flushes buffered data and ends the frame.
+// The underlying writer is not closed.
+func (w *Writer) Close() error {
+	if w.errState != nil {
+		return w.errState
+	}
+	if err := w.writeBlock(); err != nil {
+		return err
+	}
+	w.out = w.out[:0]
+	if !w.wroteHeader {
+		w.out = w.appendHeader(w.out)
+	}
+	w.out = append(w.out, 0, 0, 0, 0)
+	if w.contentChecksum {
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], w.digest.sum())
+		w.out = append(w.out, tmp[:]...)
+	}
+	if _, err := w.w.Write(w.out); err != nil {
+		w.errState = err
+		return err
+	}
+	w.errState = errClosed
+	return nil
+}
+
+func (w *Writer) appendHeader(dst []byte) []byte {
+	w.wroteHeader = true
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], frameMagic)
+	dst = append(dst, tmp[:]...)
+	flg := byte(flagVersion | flagBlockIndep)
+	if w.blockChecksum {
+		flg |= flagBlockChecksum
+	}
+	if w.contentChecksum {
+		flg |= flagContentChecksum
+	}
+	desc := []byte{flg, blockSizeID(w.blockSize) << 4}
+	dst = append(dst, desc...)
+	return append(dst, byte(xxh32Sum(desc)>>8))
+}
+
+// writeBlock compresses and writes the buffered data.
+func (w *Writer) writeBlock() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	w.out = w.out[:0]
+	if !w.wroteHeader {
+		w.out = w.appendHeader(w.out)
+	}
+	if w.contentChecksum {
+		w.digest.write(w.buf)
+	}
+	hdr := len(w.out)
+	w.out = append(w.out, 0, 0, 0, 0)
+	w.out = encodeBlock(w.out, w.buf, w.table)
+	size := uint32(len(w.out) - hdr - 4)
+	if int(size) >= len(w.buf) {
+		w.out = append(w.out[:hdr+4], w.buf...)
+		size = uint32(len(w.buf)) | uncompressedBit
+	}
+	binary.LittleEndian.PutUint32(w.out[hdr:], size)
+	if w.blockChecksum {
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], xxh32Sum(w.out[hdr+4:]))
+		w.out = append(w.out, tmp[:]...)
+	}
+	w.buf = w.buf[:0]
+	if _, err := w.w.Write(w.out); err != nil {
+		w.errState = err
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package lz4
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32x1 uint32 = 2654435761
+	prime32x2 uint32 = 2246822519
+	prime32x3 uint32 = 3266489917
+	prime32x4 uint32 = 668265263
+	prime32x5 uint32 = 374761393
+)
+
+// xxh32 is a streaming implementation of the 32 bit xxHash,
+// as used for LZ4 frame checksums. The seed is always 0.
+type xxh32 struct {
+	v1, v2, v3, v4 uint32
+	total          uint64
+	mem            [16]byte
+	n              int
+}
+
+func (d *xxh32) reset() {
+	p1, p2 := prime32x1, prime32x2
+	d.v1 = p1 + p2
+	d.v2 = p2
+	d.v3 = 0
+	d.v4 = -p1
+	d.total = 0
+	d.n = 0
+}
+
+func xxh32Round(v, input uint32) uint32 {
+	v += input * prime32x2
+	v = bits.RotateLeft32(v, 13)
+	return v * prime32x1
+}
+
+func (d *xxh32) write(b []byte) {
+	d.total += uint64(len(b))
+	if d.n+len(b) < 16 {
+		d.n += copy(d.mem[d.n:], b)
+		return
+	}
+	if d.n > 0 {
+		c := copy(d.mem[d.n:], b)
+		b = b[c:]
+		d.v1 = xxh32Round(d.v1, binary.LittleEndian.Uint32(d.mem[0:]))
+		d.v2 = xxh32Round(d.v2, binary.LittleEndian.Uint32(d.mem[4:]))
+		d.v3 = xxh32Round(d.v3, binary.LittleEndian.Uint32(d.mem[8:]))
+		d.v4 = xxh32Round(d.v4, binary.LittleEndian.Uint32(d.mem[12:]))
+		d.n = 0
+	}
+	for len(b) >= 16 {
+		d.v1 = xxh32Round(d.v1, binary.LittleEndian.Uint32(b[0:]))
+		d.v2 = xxh32Round(d.v2, binary.LittleEndian.Uint32(b[4:]))
+		d.v3 = xxh32Round(d.v3, binary.LittleEndian.Uint32(b[8:]))
+		d.v4 = xxh32Round(d.v4, binary.LittleEndian.Uint32(b[12:]))
+		b = b[16:]
+	}
+	d.n = copy(d.mem[:], b)
+}
+
+func (d *xxh32) sum() uint32 {
+	var h uint32
+	if d.total >= 16 {
+		h = bits.RotateLeft32(d.v1, 1) + bits.RotateLeft32(d.v2, 7) + bits.RotateLeft32(d.v3, 12) + bits.RotateLeft32(d.v4, 18)
+	} else {
+		h = prime32x5
+	}
+	h += uint32(d.total)
+	b := d.mem[:d.n]
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * prime32x3
+		h = bits.RotateLeft32(h, 17) * prime32x4
+	}
+	for _, c := range b {
+		h += uint32(c) * prime32x5
+		h = bits.RotateLeft32(h, 11) * prime32x1
+	}
+	h ^= h >> 15
+	h *= prime32x2
+	h ^= h >> 13
+	h *= prime32x3
+	h ^= h >> 16
+	return h
+}
+
+// xxh32Sum returns the 32 bit xxHash of b with seed 0.
+func xxh32Sum(b []byte) uint32 {
+	var d xxh32
+	d.reset()
+	d.write(b)
+	return d.sum()
+}
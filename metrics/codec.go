@@ -0,0 +1,53 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/codec"
+)
+
+// Codec returns a codec that records metrics for all operations
+// on a recorder with the name of the codec.
+// The level is only used for reporting and should be the level the codec was created with.
+//
+// To instrument a registered codec, register the returned codec:
+//
+//	c, _ := codec.Get("zstd")
+//	codec.Register(metrics.Codec(c, 3))
+func Codec(c codec.Codec, level int) codec.Codec {
+	return &instrumented{c: c, r: NewRecorder(c.Name()), level: level}
+}
+
+type instrumented struct {
+	c     codec.Codec
+	r     *Recorder
+	level int
+}
+
+func (i *instrumented) Name() string {
+	return i.c.Name()
+}
+
+func (i *instrumented) Encode(dst, src []byte) ([]byte, error) {
+	i.r.RecordLevel(i.level)
+	res, err := i.c.Encode(dst, src)
+	i.r.RecordEncode(int64(len(src)), int64(len(res)-len(dst)), err)
+	return res, err
+}
+
+func (i *instrumented) Decode(dst, src []byte) ([]byte, error) {
+	res, err := i.c.Decode(dst, src)
+	i.r.RecordDecode(int64(len(src)), int64(len(res)-len(dst)), err)
+	return res, err
+}
+
+func (i *instrumented) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return i.r.Writer(w, i.level, i.c.NewWriter)
+}
+
+func (i *instrumented) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return i.r.Reader(r, i.c.NewReader)
+}
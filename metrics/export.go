@@ -0,0 +1,101 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/compress/budget"
+)
+
+// snapshot contains all exported metrics.
+type snapshot struct {
+	Codecs []Stats            `json:"codecs"`
+	Pools  []budget.PoolStats `json:"pools"`
+	Budget map[string]int64   `json:"budget"`
+}
+
+func takeSnapshot() snapshot {
+	return snapshot{
+		Codecs: Snapshot(),
+		Pools:  budget.Stats(),
+		Budget: map[string]int64{"limit": budget.Limit(), "retained": budget.Retained()},
+	}
+}
+
+// PublishExpvar publishes all metrics as an expvar variable with the supplied name.
+// Like expvar.Publish, it panics if the name is already in use.
+// Pool stats are collected from when it is called.
+func PublishExpvar(name string) {
+	budget.CollectStats(true)
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return takeSnapshot()
+	}))
+}
+
+// Handler returns an HTTP handler that serves all metrics
+// in the Prometheus text exposition format.
+// Pool stats are collected from when it is called.
+func Handler() http.Handler {
+	budget.CollectStats(true)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w)
+	})
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+// Metric names are prefixed with "compress_".
+// Pool stats are collected from the first call, unless Handler or PublishExpvar was called before.
+func WritePrometheus(w io.Writer) error {
+	budget.CollectStats(true)
+	bw := bufio.NewWriter(w)
+	s := takeSnapshot()
+	codecMetric := func(name, help string, value func(s Stats) int64) {
+		fmt.Fprintf(bw, "# HELP compress_%s %s\n# TYPE compress_%s counter\n", name, help, name)
+		for _, c := range s.Codecs {
+			fmt.Fprintf(bw, "compress_%s{codec=%s} %d\n", name, strconv.Quote(c.Name), value(c))
+		}
+	}
+	codecMetric("encode_total", "Number of compression operations.", func(s Stats) int64 { return s.Encodes })
+	codecMetric("encode_in_bytes_total", "Uncompressed bytes compressed.", func(s Stats) int64 { return s.EncodeIn })
+	codecMetric("encode_out_bytes_total", "Compressed bytes produced.", func(s Stats) int64 { return s.EncodeOut })
+	codecMetric("decode_total", "Number of decompression operations.", func(s Stats) int64 { return s.Decodes })
+	codecMetric("decode_in_bytes_total", "Compressed bytes decompressed.", func(s Stats) int64 { return s.DecodeIn })
+	codecMetric("decode_out_bytes_total", "Decompressed bytes produced.", func(s Stats) int64 { return s.DecodeOut })
+	codecMetric("errors_total", "Number of failed operations.", func(s Stats) int64 { return s.Errors })
+
+	fmt.Fprintf(bw, "# HELP compress_encoder_level_total Encoders created by level.\n# TYPE compress_encoder_level_total counter\n")
+	for _, c := range s.Codecs {
+		levels := make([]int, 0, len(c.Levels))
+		for l := range c.Levels {
+			levels = append(levels, l)
+		}
+		sort.Ints(levels)
+		for _, l := range levels {
+			fmt.Fprintf(bw, "compress_encoder_level_total{codec=%s,level=\"%d\"} %d\n", strconv.Quote(c.Name), l, c.Levels[l])
+		}
+	}
+
+	poolMetric := func(name, typ, help string, value func(p budget.PoolStats) int64) {
+		fmt.Fprintf(bw, "# HELP compress_pool_%s %s\n# TYPE compress_pool_%s %s\n", name, help, name, typ)
+		for _, p := range s.Pools {
+			fmt.Fprintf(bw, "compress_pool_%s{pool=%s} %d\n", name, strconv.Quote(p.Name), value(p))
+		}
+	}
+	poolMetric("gets_total", "counter", "Values requested from pools.", func(p budget.PoolStats) int64 { return p.Gets })
+	poolMetric("misses_total", "counter", "Requested values that were allocated.", func(p budget.PoolStats) int64 { return p.Misses })
+	poolMetric("dropped_total", "counter", "Values not retained because of the memory budget.", func(p budget.PoolStats) int64 { return p.Dropped })
+	poolMetric("retained_bytes", "gauge", "Bytes retained in pools with a memory budget.", func(p budget.PoolStats) int64 { return p.Retained })
+
+	fmt.Fprintf(bw, "# HELP compress_budget_limit_bytes Memory budget for pools. 0 means no limit.\n# TYPE compress_budget_limit_bytes gauge\ncompress_budget_limit_bytes %d\n", s.Budget["limit"])
+	fmt.Fprintf(bw, "# HELP compress_budget_retained_bytes Bytes retained by all pools.\n# TYPE compress_budget_retained_bytes gauge\ncompress_budget_retained_bytes %d\n", s.Budget["retained"])
+	return bw.Flush()
+}
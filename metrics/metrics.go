@@ -0,0 +1,251 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package metrics provides opt-in instrumentation of compression and decompression.
+//
+// A Recorder collects metrics for a named codec.
+// Writers and readers are instrumented by creating them through a Recorder,
+// or by wrapping a codec from the codec package with Codec:
+//
+//	c := metrics.Codec(codec.Zstd(zstd.SpeedDefault), int(zstd.SpeedDefault))
+//	compressed, err := c.Encode(nil, data)
+//
+// Metrics of all recorders and the buffer pools of this module can be
+// published with expvar using PublishExpvar, or served in the Prometheus
+// text format with Handler.
+// Pool gets and misses are counted once metrics are exported.
+package metrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	recordersMu sync.Mutex
+	recorders   = make(map[string]*Recorder)
+)
+
+// Recorder collects metrics for a codec.
+// All methods are safe for concurrent use.
+type Recorder struct {
+	// Counters are first, so they are 64 bit aligned.
+	encodeIn, encodeOut int64
+	decodeIn, decodeOut int64
+	encodes, decodes    int64
+	errors              int64
+
+	name     string
+	levelsMu sync.Mutex
+	levels   map[int]int64
+}
+
+// NewRecorder returns the recorder with the supplied name.
+// If a recorder with the name already exists, it is returned.
+func NewRecorder(name string) *Recorder {
+	recordersMu.Lock()
+	defer recordersMu.Unlock()
+	if r := recorders[name]; r != nil {
+		return r
+	}
+	r := &Recorder{name: name, levels: make(map[int]int64)}
+	recorders[name] = r
+	return r
+}
+
+// Name returns the name of the recorder.
+func (r *Recorder) Name() string {
+	return r.name
+}
+
+// RecordEncode records a single compression operation.
+func (r *Recorder) RecordEncode(in, out int64, err error) {
+	atomic.AddInt64(&r.encodes, 1)
+	atomic.AddInt64(&r.encodeIn, in)
+	atomic.AddInt64(&r.encodeOut, out)
+	if err != nil {
+		atomic.AddInt64(&r.errors, 1)
+	}
+}
+
+// RecordDecode records a single decompression operation.
+func (r *Recorder) RecordDecode(in, out int64, err error) {
+	atomic.AddInt64(&r.decodes, 1)
+	atomic.AddInt64(&r.decodeIn, in)
+	atomic.AddInt64(&r.decodeOut, out)
+	if err != nil {
+		atomic.AddInt64(&r.errors, 1)
+	}
+}
+
+// RecordLevel records that an encoder was created with the level.
+func (r *Recorder) RecordLevel(level int) {
+	r.levelsMu.Lock()
+	r.levels[level]++
+	r.levelsMu.Unlock()
+}
+
+// Writer returns a compressing writer created by newWriter,
+// which records the bytes written and the compressed output when closed.
+// The level is only used for reporting.
+func (r *Recorder) Writer(dst io.Writer, level int, newWriter func(w io.Writer) (io.WriteCloser, error)) (io.WriteCloser, error) {
+	out := &countWriter{w: dst}
+	w, err := newWriter(out)
+	if err != nil {
+		atomic.AddInt64(&r.errors, 1)
+		return nil, err
+	}
+	r.RecordLevel(level)
+	return &recordWriter{r: r, w: w, out: out}, nil
+}
+
+// Reader returns a decompressing reader created by newReader,
+// which records the bytes read when closed.
+func (r *Recorder) Reader(src io.Reader, newReader func(r io.Reader) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	in := &countReader{r: src}
+	rc, err := newReader(in)
+	if err != nil {
+		atomic.AddInt64(&r.errors, 1)
+		return nil, err
+	}
+	return &recordReader{r: r, rc: rc, in: in}, nil
+}
+
+// Stats contains the metrics of a recorder.
+type Stats struct {
+	Name string
+
+	// Encodes is the number of compression operations.
+	// EncodeIn and EncodeOut are the uncompressed and compressed bytes.
+	Encodes, EncodeIn, EncodeOut int64
+
+	// Decodes is the number of decompression operations.
+	// DecodeIn and DecodeOut are the compressed and decompressed bytes.
+	Decodes, DecodeIn, DecodeOut int64
+
+	// Errors is the number of failed operations.
+	Errors int64
+
+	// Levels contains the number of encoders created at each level.
+	Levels map[int]int64
+}
+
+// Stats returns the current metrics of the recorder.
+func (r *Recorder) Stats() Stats {
+	s := Stats{
+		Name:      r.name,
+		Encodes:   atomic.LoadInt64(&r.encodes),
+		EncodeIn:  atomic.LoadInt64(&r.encodeIn),
+		EncodeOut: atomic.LoadInt64(&r.encodeOut),
+		Decodes:   atomic.LoadInt64(&r.decodes),
+		DecodeIn:  atomic.LoadInt64(&r.decodeIn),
+		DecodeOut: atomic.LoadInt64(&r.decodeOut),
+		Errors:    atomic.LoadInt64(&r.errors),
+		Levels:    make(map[int]int64),
+	}
+	r.levelsMu.Lock()
+	for l, n := range r.levels {
+		s.Levels[l] = n
+	}
+	r.levelsMu.Unlock()
+	return s
+}
+
+// Snapshot returns the metrics of all recorders sorted by name.
+func Snapshot() []Stats {
+	recordersMu.Lock()
+	rs := make([]*Recorder, 0, len(recorders))
+	for _, r := range recorders {
+		rs = append(rs, r)
+	}
+	recordersMu.Unlock()
+	res := make([]Stats, len(rs))
+	for i, r := range rs {
+		res[i] = r.Stats()
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+// recordWriter records an encode operation when closed.
+type recordWriter struct {
+	r   *Recorder
+	w   io.WriteCloser
+	out *countWriter
+	in  int64
+	err error
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.in += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *recordWriter) Close() error {
+	err := w.w.Close()
+	if w.err == nil {
+		w.err = err
+	}
+	w.r.RecordEncode(w.in, atomic.LoadInt64(&w.out.n), w.err)
+	return err
+}
+
+// Flush flushes the underlying writer if supported.
+func (w *recordWriter) Flush() error {
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// recordReader records a decode operation when closed.
+type recordReader struct {
+	r   *Recorder
+	rc  io.ReadCloser
+	in  *countReader
+	out int64
+	err error
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.out += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *recordReader) Close() error {
+	err := r.rc.Close()
+	r.r.RecordDecode(atomic.LoadInt64(&r.in.n), r.out, r.err)
+	return err
+}
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestCodec(t *testing.T) {
+	c := Codec(codec.Gzip(gzip.BestSpeed), gzip.BestSpeed)
+	in := bytes.Repeat([]byte("metrics "), 1000)
+	comp, err := c.Encode(nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decode(nil, comp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Decode(nil, []byte("not gzip")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(in)
+	w.Close()
+	r, err := c.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+
+	s := NewRecorder(codec.NameGzip).Stats()
+	if s.Encodes != 2 || s.Decodes != 3 || s.Errors != 1 {
+		t.Errorf("unexpected counts: %+v", s)
+	}
+	if s.EncodeIn != int64(2*len(in)) || s.EncodeOut != int64(len(comp)*2) {
+		t.Errorf("unexpected encode sizes: %+v (compressed %d)", s, len(comp))
+	}
+	if s.DecodeOut != int64(2*len(in)) {
+		t.Errorf("unexpected decode size: %+v", s)
+	}
+	if s.Levels[gzip.BestSpeed] != 2 {
+		t.Errorf("unexpected levels: %v", s.Levels)
+	}
+}
+
+func TestExport(t *testing.T) {
+	rec := NewRecorder("test")
+	rec.RecordEncode(100, 10, nil)
+	rec.RecordLevel(5)
+
+	rw := httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	body := rw.Body.String()
+	for _, want := range []string{
+		`compress_encode_in_bytes_total{codec="test"} 100`,
+		`compress_encoder_level_total{codec="test",level="5"} 1`,
+		"# TYPE compress_pool_gets_total counter",
+		"compress_budget_limit_bytes 0",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("output missing %q:\n%s", want, body)
+		}
+	}
+
+	PublishExpvar("compress_test")
+	var got struct {
+		Codecs []Stats
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("compress_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, c := range got.Codecs {
+		if c.Name == "test" && c.EncodeOut == 10 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("recorder not found in expvar output: %+v", got)
+	}
+}
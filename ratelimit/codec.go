@@ -0,0 +1,113 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package ratelimit
+
+import (
+	"context"
+	"io"
+
+	"github.com/klauspost/compress/codec"
+)
+
+// Measure selects which bytes are counted by the limiter of a codec.
+type Measure uint8
+
+const (
+	// Uncompressed counts bytes before compression and after decompression.
+	// This limits the amount of data processed.
+	Uncompressed Measure = iota
+	// Compressed counts bytes after compression and before decompression.
+	// This limits the I/O caused by the codec.
+	Compressed
+)
+
+// Codec returns a codec that limits the throughput of c with l.
+// Compression and decompression share the limiter.
+//
+// Encode and Decode wait before returning the result.
+// Streams are limited while they are written or read.
+func Codec(c codec.Codec, l *Limiter, m Measure) codec.Codec {
+	if l == nil {
+		panic("ratelimit: nil Limiter")
+	}
+	return &limited{Codec: c, l: l, m: m}
+}
+
+type limited struct {
+	codec.Codec
+	l *Limiter
+	m Measure
+}
+
+func (c *limited) Encode(dst, src []byte) ([]byte, error) {
+	res, err := c.Codec.Encode(dst, src)
+	if err != nil {
+		return res, err
+	}
+	n := len(src)
+	if c.m == Compressed {
+		n = len(res) - len(dst)
+	}
+	return res, c.l.WaitN(context.Background(), n)
+}
+
+func (c *limited) Decode(dst, src []byte) ([]byte, error) {
+	res, err := c.Codec.Decode(dst, src)
+	if err != nil {
+		return res, err
+	}
+	n := len(res) - len(dst)
+	if c.m == Compressed {
+		n = len(src)
+	}
+	return res, c.l.WaitN(context.Background(), n)
+}
+
+func (c *limited) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if c.m == Compressed {
+		return c.Codec.NewWriter(Writer(context.Background(), w, c.l))
+	}
+	cw, err := c.Codec.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedWriter{Writer: Writer(context.Background(), cw, c.l), c: cw}, nil
+}
+
+func (c *limited) NewReader(r io.Reader) (io.ReadCloser, error) {
+	if c.m == Compressed {
+		return c.Codec.NewReader(Reader(context.Background(), r, c.l))
+	}
+	cr, err := c.Codec.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReader{Reader: Reader(context.Background(), cr, c.l), c: cr}, nil
+}
+
+type limitedWriter struct {
+	io.Writer
+	c io.WriteCloser
+}
+
+func (w *limitedWriter) Close() error {
+	return w.c.Close()
+}
+
+// Flush flushes the underlying writer if supported.
+func (w *limitedWriter) Flush() error {
+	if f, ok := w.c.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+type limitedReader struct {
+	io.Reader
+	c io.ReadCloser
+}
+
+func (r *limitedReader) Close() error {
+	return r.c.Close()
+}
@@ -0,0 +1,211 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package ratelimit throttles compression and decompression throughput.
+//
+// A Limiter is a token bucket measured in bytes per second.
+// It can be shared by any number of readers and writers,
+// so the total throughput of a set of jobs can be capped:
+//
+//	l := ratelimit.NewLimiter(50<<20, 1<<20)
+//	c := ratelimit.Codec(codec.Zstd(zstd.SpeedDefault), l, ratelimit.Uncompressed)
+//	w, err := c.NewWriter(dst)
+//
+// Readers and writers can also be wrapped directly with Reader and Writer.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limiter limits the rate of bytes passing through readers and writers.
+// All methods are safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+
+	// now and sleep can be replaced for testing.
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewLimiter returns a limiter allowing rate bytes per second
+// with bursts of up to burst bytes.
+// A rate of 0 or less means no limit.
+// If burst is 0 or less, it is set to 1/10 of the rate, with a minimum of 4KB.
+func NewLimiter(rate float64, burst int) *Limiter {
+	l := &Limiter{now: time.Now, sleep: sleepCtx}
+	l.SetLimit(rate, burst)
+	return l
+}
+
+// SetLimit changes the rate and burst size.
+// Waiting readers and writers are not affected until they wait again.
+func (l *Limiter) SetLimit(rate float64, burst int) {
+	if burst <= 0 {
+		burst = int(rate / 10)
+		if burst < 4<<10 {
+			burst = 4 << 10
+		}
+	}
+	l.mu.Lock()
+	l.rate = rate
+	l.burst = burst
+	l.tokens = float64(burst)
+	l.last = l.now()
+	l.mu.Unlock()
+}
+
+// Rate returns the current rate in bytes per second.
+// 0 means no limit.
+func (l *Limiter) Rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	return l.rate
+}
+
+// Burst returns the maximum number of bytes that pass without waiting.
+func (l *Limiter) Burst() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.burst
+}
+
+// reserve takes n tokens and returns how long to wait before they are available.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 || math.IsInf(l.rate, 1) {
+		return 0
+	}
+	now := l.now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns n tokens not used.
+func (l *Limiter) cancel(n int) {
+	l.mu.Lock()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.mu.Unlock()
+}
+
+// WaitN blocks until n bytes are allowed or the context is done.
+// n may be larger than the burst size.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		take := n
+		if b := l.Burst(); take > b {
+			take = b
+		}
+		if d := l.reserve(take); d > 0 {
+			if err := l.sleep(ctx, d); err != nil {
+				l.cancel(take)
+				return err
+			}
+		}
+		n -= take
+	}
+	return ctx.Err()
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunkSize returns the size of reads and writes,
+// so waiting is spread evenly.
+func (l *Limiter) chunkSize(n int) int {
+	if b := l.Burst(); n > b {
+		return b
+	}
+	return n
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+// Reader returns a reader that limits the rate of bytes read from r.
+// If the context is cancelled, reads return the context error.
+func Reader(ctx context.Context, r io.Reader, l *Limiter) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	p = p[:r.l.chunkSize(len(p))]
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+type writer struct {
+	ctx context.Context
+	w   io.Writer
+	l   *Limiter
+}
+
+// Writer returns a writer that limits the rate of bytes written to w.
+// If the context is cancelled, writes return the context error.
+func Writer(ctx context.Context, w io.Writer, l *Limiter) io.Writer {
+	return &writer{ctx: ctx, w: w, l: l}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:w.l.chunkSize(len(p))]
+		if err := w.l.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if n != len(chunk) {
+			return written, io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
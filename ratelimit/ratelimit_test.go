@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/codec"
+	"github.com/klauspost/compress/zstd"
+)
+
+// fakeClock advances time when sleeping instead of waiting.
+type fakeClock struct {
+	mu    sync.Mutex
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.slept += d
+	c.mu.Unlock()
+	return nil
+}
+
+func newFakeLimiter(rate float64, burst int) (*Limiter, *fakeClock) {
+	c := &fakeClock{t: time.Unix(1000, 0)}
+	l := &Limiter{now: c.now, sleep: c.sleep}
+	l.SetLimit(rate, burst)
+	return l, c
+}
+
+// within returns whether got is within 1% of want.
+func within(got, want time.Duration) bool {
+	diff := got - want
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= want/100
+}
+
+func TestLimiterWaitN(t *testing.T) {
+	l, c := newFakeLimiter(1000, 100)
+	ctx := context.Background()
+	// The burst is available immediately.
+	if err := l.WaitN(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	if c.slept != 0 {
+		t.Fatalf("slept %v within burst", c.slept)
+	}
+	// 10000 bytes at 1000/s.
+	if err := l.WaitN(ctx, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if !within(c.slept, 10*time.Second) {
+		t.Fatalf("want 10s, slept %v", c.slept)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l, c := newFakeLimiter(0, 0)
+	if err := l.WaitN(context.Background(), 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	if c.slept != 0 {
+		t.Fatalf("slept %v without limit", c.slept)
+	}
+	if l.Rate() != 0 {
+		t.Fatalf("want rate 0, got %v", l.Rate())
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l, _ := newFakeLimiter(1000, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.WaitN(ctx, 1000); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	// The real timer must respect cancellation.
+	l = NewLimiter(1, 1)
+	l.WaitN(context.Background(), 1)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.WaitN(ctx, 100); err != context.DeadlineExceeded {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("cancel took %v", d)
+	}
+}
+
+func TestReaderWriter(t *testing.T) {
+	l, c := newFakeLimiter(1<<20, 64<<10)
+	data := bytes.Repeat([]byte("0123456789"), 1<<20)
+	var buf bytes.Buffer
+	w := Writer(context.Background(), &buf, l)
+	if n, err := w.Write(data); err != nil || n != len(data) {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("written data mismatch")
+	}
+	want := time.Duration(float64(len(data)-64<<10) / (1 << 20) * float64(time.Second))
+	if !within(c.slept, want) {
+		t.Fatalf("write: want %v, slept %v", want, c.slept)
+	}
+
+	c.slept = 0
+	l.SetLimit(1<<20, 64<<10)
+	got, err := ioutil.ReadAll(Reader(context.Background(), bytes.NewReader(data), l))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read data mismatch")
+	}
+	if !within(c.slept, want) {
+		t.Fatalf("read: want %v, slept %v", want, c.slept)
+	}
+}
+
+func TestCodec(t *testing.T) {
+	// Partly random, so the compressed size exceeds the burst.
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data[:256<<10])
+	for _, m := range []Measure{Uncompressed, Compressed} {
+		l, c := newFakeLimiter(1<<20, 16<<10)
+		cd := Codec(codec.Zstd(zstd.SpeedDefault), l, m)
+
+		var buf bytes.Buffer
+		w, err := cd.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		counted := len(data)
+		if m == Compressed {
+			counted = buf.Len()
+		}
+		want := time.Duration(float64(counted-16<<10) / (1 << 20) * float64(time.Second))
+		if want < 0 {
+			want = 0
+		}
+		if !within(c.slept, want) {
+			t.Fatalf("measure %d: writer want %v, slept %v", m, want, c.slept)
+		}
+
+		c.slept = 0
+		l.SetLimit(1<<20, 16<<10)
+		r, err := cd.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("stream mismatch")
+		}
+		if !within(c.slept, want) {
+			t.Fatalf("measure %d: reader want %v, slept %v", m, want, c.slept)
+		}
+
+		c.slept = 0
+		l.SetLimit(1<<20, 16<<10)
+		enc, err := cd.Encode(nil, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := cd.Decode(nil, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dec, data) {
+			t.Fatal("block mismatch")
+		}
+		if c.slept == 0 {
+			t.Fatalf("measure %d: block encode/decode not limited", m)
+		}
+	}
+}
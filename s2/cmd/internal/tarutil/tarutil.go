@@ -0,0 +1,123 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package tarutil creates and extracts tar archives for the s2 commands.
+package tarutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/tarball"
+)
+
+// Create writes a tar archive of the files and directories in paths to w.
+// Directories are added recursively. Symbolic links are stored as links.
+// Paths are stored relative to the given path; absolute paths and
+// paths outside the current directory are stored by their base name.
+// If progress is not nil, it is called with the name of each entry.
+// The archive is not closed, so it can be combined with other archives.
+func Create(w io.Writer, paths []string, progress func(name string)) error {
+	tw := tar.NewWriter(w)
+	br := bufio.NewReaderSize(nil, 1<<20)
+	for _, root := range paths {
+		prefix := archivePrefix(root)
+		err := filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, file)
+			if err != nil {
+				return err
+			}
+			name := path.Join(prefix, filepath.ToSlash(rel))
+			if name == "." || name == "" {
+				return nil
+			}
+			var link string
+			if fi.Mode()&os.ModeSymlink != 0 {
+				link, err = os.Readlink(file)
+				if err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			hdr.Name = name
+			if fi.IsDir() {
+				hdr.Name += "/"
+			}
+			if progress != nil {
+				progress(hdr.Name)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			br.Reset(f)
+			n, err := io.Copy(tw, br)
+			if err == nil && n != hdr.Size {
+				err = fmt.Errorf("%s: file changed size while archiving", file)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// archivePrefix returns the name used for root in the archive.
+func archivePrefix(root string) string {
+	clean := filepath.Clean(root)
+	vol := filepath.VolumeName(clean)
+	if filepath.IsAbs(clean) || vol != "" || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		base := filepath.Base(clean)
+		if base == string(filepath.Separator) || base == "." || base == ".." {
+			return ""
+		}
+		return base
+	}
+	return filepath.ToSlash(clean)
+}
+
+// ExtractOptions control extraction.
+type ExtractOptions struct {
+	// NoOverwrite returns an error if a file already exists.
+	NoOverwrite bool
+
+	// Progress is called with the name of each entry, if not nil.
+	Progress func(name string)
+}
+
+// Extract extracts the tar archive in r to the directory dst.
+//
+// Extraction is done by the tarball package, which rejects entries that
+// would be written outside dst. This includes absolute names, names
+// containing "..", writing through symbolic links, and links pointing
+// outside dst. Permissions are limited to 0777, and special files like
+// devices are skipped.
+func Extract(dst string, r io.Reader, o ExtractOptions) error {
+	return tarball.Extract(context.Background(), r, dst, tarball.ExtractOptions{
+		Format:    tarball.FormatNone,
+		Overwrite: !o.NoOverwrite,
+		Progress:  o.Progress,
+	})
+}
@@ -0,0 +1,134 @@
+package tarutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "tarutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	files := map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     "world",
+		"sub/deep/c.go": "package c",
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := Create(&buf, []string{src}, nil); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := ioutil.TempDir("", "tarutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := Extract(dst, &buf, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Absolute inputs are stored by their base name.
+	base := filepath.Base(src)
+	for name, content := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dst, base, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tar.Header
+	}{
+		{name: "parent", entries: []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg}}},
+		{name: "nested parent", entries: []tar.Header{{Name: "a/../../evil", Typeflag: tar.TypeReg}}},
+		{name: "absolute", entries: []tar.Header{{Name: "/tmp/evil", Typeflag: tar.TypeReg}}},
+		{name: "absolute link", entries: []tar.Header{{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}},
+		{name: "escaping link", entries: []tar.Header{{Name: "a/l", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}}},
+		{name: "link through link", entries: []tar.Header{
+			{Name: "s", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "l", Typeflag: tar.TypeSymlink, Linkname: "s/../evil"},
+		}},
+		{name: "write through link", entries: []tar.Header{
+			{Name: "d", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "d/l", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "d/l/x", Typeflag: tar.TypeReg},
+		}},
+		{name: "hard link", entries: []tar.Header{{Name: "h", Typeflag: tar.TypeLink, Linkname: "../evil"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if runtime.GOOS == "windows" {
+				t.Skip("symbolic links need privileges")
+			}
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range test.entries {
+				hdr := hdr
+				if err := tw.WriteHeader(&hdr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tw.Close()
+			parent, err := ioutil.TempDir("", "tarutil")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(parent)
+			dst := filepath.Join(parent, "dst")
+			if err := Extract(dst, &buf, ExtractOptions{}); err == nil {
+				t.Fatal("want error")
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "evil")); err == nil {
+				t.Fatal("file written outside destination")
+			}
+		})
+	}
+}
+
+func TestExtractNoOverwrite(t *testing.T) {
+	dst, err := ioutil.TempDir("", "tarutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := ioutil.WriteFile(filepath.Join(dst, "a"), []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a", Typeflag: tar.TypeReg, Size: 3, Mode: 0644})
+	tw.Write([]byte("new"))
+	tw.Close()
+	archive := buf.Bytes()
+	if err := Extract(dst, bytes.NewReader(archive), ExtractOptions{NoOverwrite: true}); err == nil {
+		t.Fatal("want error")
+	}
+	if err := Extract(dst, bytes.NewReader(archive), ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(dst, "a"))
+	if string(got) != "new" {
+		t.Fatalf("got %q, want %q", got, "new")
+	}
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/klauspost/compress/s2"
 	"github.com/klauspost/compress/s2/cmd/internal/readahead"
+	"github.com/klauspost/compress/s2/cmd/internal/tarutil"
 )
 
 var (
@@ -39,6 +40,8 @@ var (
 	quiet     = flag.Bool("q", false, "Don't write any output to terminal, except errors")
 	bench     = flag.Int("bench", 0, "Run benchmark n times. No output will be written")
 	verify    = flag.Bool("verify", false, "Verify written files")
+	tarFlag   = flag.Bool("tar", false, "Archive all inputs, including directories, as a single compressed tar file")
+	tarOut    = flag.String("o", "", "Output file name with -tar. Default is the first input with '.tar.s2' added. Use - for stdout")
 	help      = flag.Bool("help", false, "Display help")
 
 	cpuprofile, memprofile, traceprofile string
@@ -77,6 +80,12 @@ Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/
 File names beginning with 'http://' and 'https://' will be downloaded and compressed.
 Only http response code 200 is accepted.
 
+Use -tar to archive files and directories into a single file:
+  s2c -tar -o backup.tar.s2 dir1 dir2 file.txt
+  s2c -tar -c dir | s2d -untar -C dst -
+
+The exit code is 0 on success and 2 on errors.
+
 Options:`)
 		flag.PrintDefaults()
 		os.Exit(0)
@@ -92,13 +101,16 @@ Options:`)
 
 	// No args, use stdin/stdout
 	if len(args) == 1 && args[0] == "-" {
+		if *tarFlag {
+			exitErr(errors.New("-tar cannot be used with stdin input"))
+		}
 		// Catch interrupt, so we don't exit at once.
 		// os.Stdin will return EOF, so we should be able to get everything.
 		signal.Notify(make(chan os.Signal, 1), os.Interrupt)
 		wr.Reset(os.Stdout)
 		_, err = wr.ReadFrom(os.Stdin)
-		printErr(err)
-		printErr(wr.Close())
+		exitErr(err)
+		exitErr(wr.Close())
 		return
 	}
 	var files []string
@@ -144,6 +156,11 @@ Options:`)
 		defer trace.Stop()
 	}
 
+	if *tarFlag {
+		compressTar(wr, files, int(sz))
+		return
+	}
+
 	*quiet = *quiet || *stdout
 	if *bench > 0 {
 		debug.SetGCPercent(10)
@@ -279,6 +296,85 @@ Options:`)
 	}
 }
 
+// compressTar writes a compressed tar archive of files.
+func compressTar(wr *s2.Writer, files []string, bufSize int) {
+	if *bench > 0 || *remove {
+		exitErr(errors.New("-tar cannot be used with -bench or -rm"))
+	}
+	for _, f := range files {
+		if isHTTP(f) {
+			exitErr(fmt.Errorf("-tar cannot be used with http input: %s", f))
+		}
+	}
+	dstFilename := *tarOut
+	if *stdout {
+		dstFilename = "-"
+	}
+	if dstFilename == "" {
+		base := filepath.Base(filepath.Clean(files[0]))
+		if base == "." || base == ".." || base == string(filepath.Separator) {
+			exitErr(errors.New("unable to determine output file name, use -o"))
+		}
+		dstFilename = base + ".tar.s2"
+	}
+	toStdout := dstFilename == "-"
+	*quiet = *quiet || toStdout
+
+	var out io.Writer
+	var dstFile *os.File
+	if toStdout {
+		out = os.Stdout
+	} else {
+		if *safe {
+			_, err := os.Stat(dstFilename)
+			if !os.IsNotExist(err) {
+				exitErr(errors.New("destination file exists"))
+			}
+		}
+		var err error
+		dstFile, err = os.OpenFile(dstFilename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		exitErr(err)
+		out = dstFile
+	}
+	// Remove incomplete output on errors.
+	fail := func(err error) {
+		if err != nil && dstFile != nil {
+			dstFile.Close()
+			os.Remove(dstFilename)
+		}
+		exitErr(err)
+	}
+	bw := bufio.NewWriterSize(out, bufSize*2)
+	out, errFn := verifyTo(bw)
+	wc := wCounter{out: out}
+	wr.Reset(&wc)
+	if !*quiet {
+		fmt.Println("Archiving to", dstFilename)
+	}
+	var progress func(string)
+	if !*quiet {
+		progress = func(name string) { fmt.Println(" ", name) }
+	}
+	input := wCounter{out: wr}
+	start := time.Now()
+	fail(tarutil.Create(&input, files, progress))
+	fail(wr.Close())
+	fail(bw.Flush())
+	if dstFile != nil {
+		fail(dstFile.Close())
+	}
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbpersec := (float64(input.n) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(wc.n) * 100 / float64(input.n)
+		fmt.Printf("%d -> %d [%.02f%%]; %.01fMB/s\n", input.n, wc.n, pct, mbpersec)
+	}
+	fail(errFn())
+	if *verify && !*quiet {
+		fmt.Println()
+	}
+}
+
 func isHTTP(name string) bool {
 	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
 }
@@ -346,12 +442,6 @@ func verifyTo(w io.Writer) (io.Writer, func() error) {
 	}
 }
 
-func printErr(err error) {
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
-	}
-}
-
 func exitErr(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
 	"errors"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/klauspost/compress/s2"
 	"github.com/klauspost/compress/s2/cmd/internal/readahead"
+	"github.com/klauspost/compress/s2/cmd/internal/tarutil"
 )
 
 var (
@@ -27,6 +29,8 @@ var (
 	remove = flag.Bool("rm", false, "Delete source file(s) after successful decompression")
 	quiet  = flag.Bool("q", false, "Don't write any output to terminal, except errors")
 	bench  = flag.Int("bench", 0, "Run benchmark n times. No output will be written")
+	untar  = flag.Bool("untar", false, "Extract decompressed tar archives")
+	tarDir = flag.String("C", ".", "Extract to this directory with -untar")
 	help   = flag.Bool("help", false, "Display help")
 
 	version = "(dev)"
@@ -55,12 +59,26 @@ Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/
 File names beginning with 'http://' and 'https://' will be downloaded and decompressed.
 Extensions on downloaded files are ignored. Only http response code 200 is accepted.
 
+Use -untar to extract tar archives created with 's2c -tar':
+  s2d -untar -C dst backup.tar.s2
+  s2c -tar -c dir | s2d -untar -C dst -
+Entries that would be extracted outside the destination are rejected.
+
+The exit code is 0 on success and 2 on errors.
+
 Options:`)
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
+	if *untar && (*stdout || *bench > 0) {
+		exitErr(errors.New("-untar cannot be used with -c or -bench"))
+	}
 	if len(args) == 1 && args[0] == "-" {
 		r.Reset(os.Stdin)
+		if *untar {
+			exitErr(extractTar(r))
+			return
+		}
 		if !*verify {
 			_, err := io.Copy(os.Stdout, r)
 			exitErr(err)
@@ -149,6 +167,8 @@ Options:`)
 		}
 		if *verify {
 			dstFilename = "(verify)"
+		} else if *untar {
+			dstFilename = *tarDir
 		}
 
 		func() {
@@ -163,12 +183,32 @@ Options:`)
 			src, err := readahead.NewReaderSize(&rc, 2, 4<<20)
 			exitErr(err)
 			defer src.Close()
-			if *safe {
+			if *safe && !*untar {
 				_, err := os.Stat(dstFilename)
 				if !os.IsNotExist(err) {
 					exitErr(errors.New("destination files exists"))
 				}
 			}
+			if *untar {
+				r.Reset(src)
+				start := time.Now()
+				exitErr(extractTar(r))
+				if !*quiet {
+					elapsed := time.Since(start)
+					mbPerSec := (float64(rc.n) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+					fmt.Printf("%d bytes read; %.01fMB/s\n", rc.n, mbPerSec)
+				}
+				if *remove && !*verify {
+					closeOnce.Do(func() {
+						file.Close()
+						if !*quiet {
+							fmt.Println("Removing", filename)
+						}
+						exitErr(os.Remove(filename))
+					})
+				}
+				return
+			}
 			var out io.Writer
 			switch {
 			case *verify:
@@ -207,6 +247,35 @@ Options:`)
 	}
 }
 
+// extractTar extracts the tar archive in r to the destination directory.
+// With -verify the archive is read, but nothing is written.
+func extractTar(r io.Reader) error {
+	var progress func(string)
+	if !*quiet {
+		fmt.Println()
+		progress = func(name string) { fmt.Println(" ", name) }
+	}
+	if *verify {
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(hdr.Name)
+			}
+			if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+				return err
+			}
+		}
+	}
+	return tarutil.Extract(*tarDir, r, tarutil.ExtractOptions{NoOverwrite: *safe, Progress: progress})
+}
+
 func openFile(name string) (rc io.ReadCloser, size int64, mode os.FileMode) {
 	if isHTTP(name) {
 		resp, err := http.Get(name)
@@ -10,7 +10,18 @@
 
 package s2
 
-// decode has the same semantics as in decode_other.go.
+import "github.com/klauspost/compress/cpuinfo"
+
+// s2Decode has the same semantics as s2DecodeGo.
+// The Go implementation is used if assembly has been disabled.
+func s2Decode(dst, src []byte) int {
+	if !cpuinfo.AsmS2.Enabled() {
+		return s2DecodeGo(dst, src)
+	}
+	return s2DecodeAsm(dst, src)
+}
+
+// s2DecodeAsm has the same semantics as s2DecodeGo.
 //
 //go:noescape
-func s2Decode(dst, src []byte) int
+func s2DecodeAsm(dst, src []byte) int
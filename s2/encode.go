@@ -15,6 +15,8 @@ import (
 	"math/bits"
 	"runtime"
 	"sync"
+
+	"github.com/klauspost/compress/budget"
 )
 
 // Encode returns the encoded form of src. The returned slice may be a sub-
@@ -292,9 +294,13 @@ func NewWriter(w io.Writer, opts ...WriterOption) *Writer {
 	w2.obufLen = obufHeaderLen + MaxEncodedLen(w2.blockSize)
 	w2.paramsOK = true
 	w2.ibuf = make([]byte, 0, w2.blockSize)
+	w2.buffers.Name = "s2.Writer"
 	w2.buffers.New = func() interface{} {
 		return make([]byte, w2.obufLen)
 	}
+	w2.buffers.Size = func(x interface{}) int64 {
+		return int64(cap(x.([]byte)))
+	}
 	w2.Reset(w)
 	return &w2
 }
@@ -312,7 +318,7 @@ type Writer struct {
 	concurrency int
 	written     int64
 	output      chan chan result
-	buffers     sync.Pool
+	buffers     budget.Pool
 	pad         int
 
 	writer   io.Writer
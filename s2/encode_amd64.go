@@ -4,6 +4,8 @@
 
 package s2
 
+import "github.com/klauspost/compress/cpuinfo"
+
 // encodeBlock encodes a non-empty src to a guaranteed-large-enough dst. It
 // assumes that the varint-encoded length of the decompressed bytes has already
 // been written.
@@ -12,6 +14,12 @@ package s2
 //	len(dst) >= MaxEncodedLen(len(src)) &&
 // 	minNonLiteralBlockSize <= len(src) && len(src) <= maxBlockSize
 func encodeBlock(dst, src []byte) (d int) {
+	if !cpuinfo.AsmS2.Enabled() {
+		if len(src) < minNonLiteralBlockSize {
+			return 0
+		}
+		return encodeBlockGo(dst, src)
+	}
 	const (
 		// Use 12 bit table when less than...
 		limit12B = 16 << 10
@@ -47,6 +55,12 @@ func encodeBlock(dst, src []byte) (d int) {
 //	len(dst) >= MaxEncodedLen(len(src)) &&
 // 	minNonLiteralBlockSize <= len(src) && len(src) <= maxBlockSize
 func encodeBlockBetter(dst, src []byte) (d int) {
+	if !cpuinfo.AsmS2.Enabled() {
+		if len(src) < minNonLiteralBlockSize {
+			return 0
+		}
+		return encodeBlockBetterGo(dst, src)
+	}
 	const (
 		// Use 12 bit table when less than...
 		limit12B = 16 << 10
@@ -82,6 +96,12 @@ func encodeBlockBetter(dst, src []byte) (d int) {
 //	len(dst) >= MaxEncodedLen(len(src)) &&
 // 	minNonLiteralBlockSize <= len(src) && len(src) <= maxBlockSize
 func encodeBlockSnappy(dst, src []byte) (d int) {
+	if !cpuinfo.AsmS2.Enabled() {
+		if len(src) < minNonLiteralBlockSize {
+			return 0
+		}
+		return encodeBlockSnappyGo(dst, src)
+	}
 	const (
 		// Use 12 bit table when less than...
 		limit12B = 16 << 10
@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/cpuinfo"
 	"github.com/klauspost/compress/zstd"
 )
 
@@ -1826,6 +1827,51 @@ func testFile(t *testing.T, i, repeat int) {
 	})
 }
 
+func TestAsmDisabled(t *testing.T) {
+	defer cpuinfo.EnableAsm(cpuinfo.AsmS2)
+	rng := rand.New(rand.NewSource(1))
+	var inputs [][]byte
+	for _, n := range []int{10, 100, 1000, 10000, 100000, 1 << 20} {
+		b := make([]byte, n)
+		for i := 0; i < n; {
+			// Mix random data with repeats.
+			l := rng.Intn(100) + 1
+			if i > 1000 && rng.Intn(2) == 0 {
+				i += copy(b[i:], b[i-rng.Intn(1000)-1:][:l])
+				continue
+			}
+			for j := 0; j < l && i < n; j++ {
+				b[i] = byte(rng.Intn(16))
+				i++
+			}
+		}
+		inputs = append(inputs, b)
+	}
+	encoders := map[string]func(dst, src []byte) []byte{
+		"default": Encode,
+		"better":  EncodeBetter,
+		"snappy":  EncodeSnappy,
+	}
+	for name, enc := range encoders {
+		for _, in := range inputs {
+			cpuinfo.EnableAsm(cpuinfo.AsmS2)
+			asm := enc(nil, in)
+			cpuinfo.DisableAsm(cpuinfo.AsmS2)
+			generic := enc(nil, in)
+			// Decode the output of each implementation with the other.
+			got, err := Decode(nil, asm)
+			if err != nil || !bytes.Equal(got, in) {
+				t.Fatalf("%s, size %d: generic decode of asm output failed: %v", name, len(in), err)
+			}
+			cpuinfo.EnableAsm(cpuinfo.AsmS2)
+			got, err = Decode(nil, generic)
+			if err != nil || !bytes.Equal(got, in) {
+				t.Fatalf("%s, size %d: asm decode of generic output failed: %v", name, len(in), err)
+			}
+		}
+	}
+}
+
 func TestDataRoundtrips(t *testing.T) {
 	test := func(t *testing.T, data []byte) {
 		t.Run("s2", func(t *testing.T) {
@@ -0,0 +1,34 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package seekable
+
+import (
+	"io"
+)
+
+// Convert recompresses the seekable stream in src, which is size bytes,
+// to dst in the format.
+// Chunk boundaries are kept, so uncompressed offsets of chunks are unchanged.
+// The chunk size option is ignored.
+func Convert(dst io.Writer, src io.ReaderAt, size int64, f Format, opts ...WriterOption) error {
+	r, err := NewReader(src, size)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := NewWriter(dst, f, opts...)
+	if err != nil {
+		return err
+	}
+	for i := range r.index.Chunks {
+		b, err := r.Chunk(i)
+		if err != nil {
+			return err
+		}
+		if err := w.writeChunk(b); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,203 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package seekable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/xxhash"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Reader provides random access to a seekable stream.
+// ReadAt is safe for concurrent use, Read and Seek are not.
+type Reader struct {
+	r      io.ReaderAt
+	index  *Index
+	format Format
+	off    int64
+
+	zdecOnce sync.Once
+	zdec     *zstd.Decoder
+	zdecErr  error
+	s2Pool   sync.Pool
+
+	// The most recently decoded chunk.
+	mu     sync.Mutex
+	cached int
+	cache  []byte
+}
+
+// NewReader reads the index of the seekable stream in r,
+// which is size bytes, and returns a Reader of the uncompressed content.
+// Close should be called to release resources when done.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	idx, f, err := ReadIndex(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, index: idx, format: f, cached: -1}, nil
+}
+
+// Index returns the index of the stream.
+// The returned index must not be modified.
+func (r *Reader) Index() *Index {
+	return r.index
+}
+
+// Format returns the format of the stream.
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Size returns the uncompressed size of the stream.
+func (r *Reader) Size() int64 {
+	return r.index.Size()
+}
+
+// Chunk returns the uncompressed content of chunk i.
+// The checksum is verified if present.
+// The returned slice must not be modified.
+func (r *Reader) Chunk(i int) ([]byte, error) {
+	if i < 0 || i >= len(r.index.Chunks) {
+		return nil, fmt.Errorf("seekable: chunk %d out of range", i)
+	}
+	r.mu.Lock()
+	if r.cached == i {
+		b := r.cache
+		r.mu.Unlock()
+		return b, nil
+	}
+	r.mu.Unlock()
+
+	c := r.index.Chunks[i]
+	comp := make([]byte, c.CompressedSize)
+	if _, err := r.r.ReadAt(comp, c.CompressedOffset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	b, err := r.decode(comp, c.Size)
+	if err != nil {
+		return nil, fmt.Errorf("seekable: chunk %d: %w", i, err)
+	}
+	if int64(len(b)) != c.Size {
+		return nil, fmt.Errorf("%w: chunk %d size mismatch", ErrCorrupt, i)
+	}
+	if r.index.Checksums && xxhash.Checksum32(b) != c.Checksum {
+		return nil, fmt.Errorf("%w: chunk %d checksum mismatch", ErrCorrupt, i)
+	}
+	r.mu.Lock()
+	r.cached, r.cache = i, b
+	r.mu.Unlock()
+	return b, nil
+}
+
+func (r *Reader) decode(comp []byte, size int64) ([]byte, error) {
+	switch r.format {
+	case Zstd:
+		r.zdecOnce.Do(func() {
+			r.zdec, r.zdecErr = zstd.NewReader(nil)
+		})
+		if r.zdecErr != nil {
+			return nil, r.zdecErr
+		}
+		return r.zdec.DecodeAll(comp, make([]byte, 0, size))
+	case S2:
+		dec, _ := r.s2Pool.Get().(*s2.Reader)
+		if dec == nil {
+			dec = s2.NewReader(nil)
+		}
+		defer r.s2Pool.Put(dec)
+		dec.Reset(bytes.NewReader(comp))
+		b := make([]byte, size)
+		if _, err := io.ReadFull(dec, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		// Must be at the end of the stream.
+		var tmp [1]byte
+		if n, err := dec.Read(tmp[:]); n != 0 || err != io.EOF {
+			return nil, errors.New("chunk larger than index")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown format %v", r.format)
+}
+
+// ReadAt reads len(p) uncompressed bytes starting at offset off.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("seekable: negative offset")
+	}
+	n := 0
+	for len(p) > 0 {
+		i := r.index.Find(off)
+		if i >= len(r.index.Chunks) {
+			return n, io.EOF
+		}
+		b, err := r.Chunk(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p, b[off-r.index.Chunks[i].Offset:])
+		p = p[c:]
+		off += int64(c)
+		n += c
+	}
+	return n, nil
+}
+
+// Read reads uncompressed data from the current offset.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.off >= r.Size() {
+		return 0, io.EOF
+	}
+	if rem := r.Size() - r.off; int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset of the next Read.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.Size()
+	default:
+		return r.off, errors.New("seekable: invalid whence")
+	}
+	if offset < 0 {
+		return r.off, errors.New("seekable: negative offset")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+// Close releases resources held by the Reader.
+func (r *Reader) Close() error {
+	if r.zdec != nil {
+		r.zdec.Close()
+	}
+	r.mu.Lock()
+	r.cached, r.cache = -1, nil
+	r.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,286 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package seekable implements a container format allowing random access
+// into zstd and s2 compressed streams.
+//
+// A seekable stream is a sequence of chunks, each compressed independently
+// as a complete zstd frame or s2 stream, followed by an index.
+// The output is a regular zstd or s2 stream that can be decompressed by
+// any decoder, since the index is stored in a frame that decoders skip.
+//
+// The index is identical for both formats, so tools can read the index
+// and convert between formats without format specific logic.
+// All values are little endian.
+//
+// For each chunk, in stream order:
+//
+//	Compressed size    uint32
+//	Uncompressed size  uint32
+//	Checksum           uint32 (only if checksums are present)
+//
+// Followed by the footer:
+//
+//	Number of chunks   uint32
+//	Descriptor         uint8: bit 7 is set if checksums are present, bits 2-6 must be 0.
+//	Magic              uint32: 0x8F92EAB1
+//
+// The checksum is the lower 32 bits of the XXH64 hash with seed 0
+// of the uncompressed chunk.
+//
+// For zstd, the index is stored as a skippable frame with magic 0x184D2A5E
+// followed by the uint32 size of the index. This is the seek table of the
+// zstd seekable format, so zstd streams can be read by other implementations.
+//
+// For s2, the index is stored as a skippable chunk of type 0x9a
+// followed by the 24 bit size of the index.
+//
+// Chunks must start at offset 0 and be contiguous, and the index must be
+// the final frame of the stream.
+//
+// The index is encoded and parsed by zstd.SeekTable.
+package seekable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is the compression format of a seekable stream.
+type Format uint8
+
+// Supported formats.
+const (
+	// Zstd compresses each chunk as a zstd frame.
+	Zstd Format = iota + 1
+	// S2 compresses each chunk as an s2 stream.
+	S2
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case Zstd:
+		return "zstd"
+	case S2:
+		return "s2"
+	}
+	return fmt.Sprintf("Format(%d)", uint8(f))
+}
+
+const (
+	zstdHeaderSize   = 8
+	s2IndexChunkType = 0x9a
+	s2HeaderSize     = 4
+	maxS2IndexSize   = 1<<24 - 1
+)
+
+// ErrNoIndex is returned when a stream does not end with an index.
+var ErrNoIndex = errors.New("seekable: no index found")
+
+// ErrCorrupt is returned when the index or a chunk is invalid.
+var ErrCorrupt = errors.New("seekable: corrupt stream")
+
+// Chunk describes a single independently compressed chunk.
+type Chunk struct {
+	// CompressedOffset and CompressedSize are the position of the chunk in the stream.
+	CompressedOffset, CompressedSize int64
+
+	// Offset and Size are the position of the chunk in the uncompressed output.
+	Offset, Size int64
+
+	// Checksum of the uncompressed chunk, if the index has checksums.
+	Checksum uint32
+}
+
+// Index contains the chunks of a seekable stream.
+type Index struct {
+	Chunks []Chunk
+
+	// Checksums is true if the chunks have checksums.
+	Checksums bool
+}
+
+// Size returns the total uncompressed size.
+func (i *Index) Size() int64 {
+	if len(i.Chunks) == 0 {
+		return 0
+	}
+	c := i.Chunks[len(i.Chunks)-1]
+	return c.Offset + c.Size
+}
+
+// CompressedSize returns the size of all chunks, excluding the index.
+func (i *Index) CompressedSize() int64 {
+	if len(i.Chunks) == 0 {
+		return 0
+	}
+	c := i.Chunks[len(i.Chunks)-1]
+	return c.CompressedOffset + c.CompressedSize
+}
+
+// Find returns the index of the chunk containing the uncompressed offset.
+// If the offset is outside the stream, len(i.Chunks) is returned.
+func (i *Index) Find(offset int64) int {
+	if offset < 0 {
+		return len(i.Chunks)
+	}
+	return sort.Search(len(i.Chunks), func(n int) bool {
+		c := i.Chunks[n]
+		return c.Offset+c.Size > offset
+	})
+}
+
+// add a chunk to the end of the index.
+func (i *Index) add(compressed, uncompressed int64, checksum uint32) {
+	i.Chunks = append(i.Chunks, Chunk{
+		CompressedOffset: i.CompressedSize(),
+		CompressedSize:   compressed,
+		Offset:           i.Size(),
+		Size:             uncompressed,
+		Checksum:         checksum,
+	})
+}
+
+// seekTable returns the index as a zstd seek table.
+func (i *Index) seekTable() *zstd.SeekTable {
+	t := zstd.SeekTable{
+		Entries:   make([]zstd.SeekTableEntry, len(i.Chunks)),
+		Checksums: i.Checksums,
+	}
+	for n, c := range i.Chunks {
+		t.Entries[n] = zstd.SeekTableEntry{
+			CompressedSize:   uint32(c.CompressedSize),
+			DecompressedSize: uint32(c.Size),
+			Checksum:         c.Checksum,
+		}
+	}
+	return &t
+}
+
+// AppendTo appends the index, wrapped in a skippable frame of the format, to dst.
+func (i *Index) AppendTo(dst []byte, f Format) (_ []byte, err error) {
+	for _, c := range i.Chunks {
+		if c.CompressedSize > 0xffffffff || c.Size > 0xffffffff {
+			return dst, errors.New("seekable: chunk too large")
+		}
+	}
+	t := i.seekTable()
+	orig := dst
+	switch f {
+	case Zstd:
+		dst, err = t.AppendFrame(dst)
+	case S2:
+		n := t.Size()
+		if n > maxS2IndexSize {
+			return orig, fmt.Errorf("seekable: too many chunks for s2 index (%d)", len(i.Chunks))
+		}
+		dst, err = t.AppendTable(append(dst, s2IndexChunkType, uint8(n), uint8(n>>8), uint8(n>>16)))
+	default:
+		return orig, fmt.Errorf("seekable: unknown format %v", f)
+	}
+	if err != nil {
+		return orig, fmt.Errorf("seekable: %w", err)
+	}
+	return dst, nil
+}
+
+// indexSize returns the size of the index from its footer, without the frame header.
+// ErrNoIndex is returned if footer doesn't have the magic of an index.
+func indexSize(footer []byte) (int64, error) {
+	if binary.LittleEndian.Uint32(footer[5:]) != zstd.SeekableMagic {
+		return 0, ErrNoIndex
+	}
+	n, err := zstd.SeekTableSize(footer)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return int64(n), nil
+}
+
+// ParseIndex parses the index at the end of b.
+// b must contain at least the complete index frame,
+// but may contain the entire stream.
+// The returned index has offsets relative to the start of the stream,
+// assuming the index is the end of the stream.
+func ParseIndex(b []byte) (*Index, Format, error) {
+	if len(b) < zstd.SeekTableFooterSize {
+		return nil, 0, ErrNoIndex
+	}
+	n, err := indexSize(b[len(b)-zstd.SeekTableFooterSize:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f := Zstd
+	t, _, err := zstd.ParseSeekTableFrame(b)
+	if err != nil {
+		if int64(len(b)) < n+s2HeaderSize || b[int64(len(b))-n-s2HeaderSize] != s2IndexChunkType {
+			return nil, 0, fmt.Errorf("%w: index frame header not found", ErrCorrupt)
+		}
+		h := b[int64(len(b))-n-s2HeaderSize+1:]
+		if int64(h[0])|int64(h[1])<<8|int64(h[2])<<16 != n {
+			return nil, 0, fmt.Errorf("%w: index size mismatch", ErrCorrupt)
+		}
+		f = S2
+		t, err = zstd.ParseSeekTable(b[int64(len(b))-n:])
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+
+	idx := Index{Checksums: t.Checksums}
+	idx.Chunks = make([]Chunk, 0, len(t.Entries))
+	for _, e := range t.Entries {
+		idx.add(int64(e.CompressedSize), int64(e.DecompressedSize), e.Checksum)
+	}
+	return &idx, f, nil
+}
+
+// IndexFrameSize returns the size of the index frame,
+// including the skippable frame header, when stored in the format.
+func (i *Index) IndexFrameSize(f Format) int64 {
+	n := int64(i.seekTable().Size())
+	if f == S2 {
+		return n + s2HeaderSize
+	}
+	return n + zstdHeaderSize
+}
+
+// ReadIndex reads the index from a seekable stream of the supplied size.
+// It is verified that the chunks cover the stream up to the index.
+func ReadIndex(r io.ReaderAt, size int64) (*Index, Format, error) {
+	if size < zstd.SeekTableFooterSize {
+		return nil, 0, ErrNoIndex
+	}
+	var footer [zstd.SeekTableFooterSize]byte
+	if _, err := r.ReadAt(footer[:], size-zstd.SeekTableFooterSize); err != nil {
+		return nil, 0, err
+	}
+	n, err := indexSize(footer[:])
+	if err != nil {
+		return nil, 0, err
+	}
+	// Read enough for the larger zstd header.
+	n += zstdHeaderSize
+	if n > size {
+		n = size
+	}
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, size-n); err != nil {
+		return nil, 0, err
+	}
+	idx, f, err := ParseIndex(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	if idx.CompressedSize()+idx.IndexFrameSize(f) != size {
+		return nil, 0, fmt.Errorf("%w: chunks do not match stream size", ErrCorrupt)
+	}
+	return idx, f, nil
+}
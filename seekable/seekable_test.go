@@ -0,0 +1,198 @@
+package seekable
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+func testData(n int) []byte {
+	rng := rand.New(rand.NewSource(int64(n)))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + rng.Intn(8))
+	}
+	return b
+}
+
+func compress(t *testing.T, f Format, data []byte, opts ...WriterOption) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, f, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in odd sizes.
+	for b := data; len(b) > 0; {
+		n := 1000 + len(b)%777
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err := w.Write(b[:n]); err != nil {
+			t.Fatal(err)
+		}
+		b = b[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decodeStream decodes with the regular stream decoder of the format.
+func decodeStream(t *testing.T, f Format, b []byte) []byte {
+	t.Helper()
+	var r io.Reader
+	switch f {
+	case Zstd:
+		dec, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		r = dec
+	case S2:
+		r = s2.NewReader(bytes.NewReader(b))
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestRoundtrip(t *testing.T) {
+	for _, f := range []Format{Zstd, S2} {
+		for _, size := range []int{0, 1, 1000, 100000} {
+			for _, checksum := range []bool{false, true} {
+				data := testData(size)
+				comp := compress(t, f, data, WriterChunkSize(4096), WriterChecksum(checksum))
+				if got := decodeStream(t, f, comp); !bytes.Equal(got, data) {
+					t.Fatalf("%v size %d: stream decode mismatch", f, size)
+				}
+				r, err := NewReader(bytes.NewReader(comp), int64(len(comp)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r.Format() != f || r.Size() != int64(size) || r.Index().Checksums != checksum {
+					t.Fatalf("unexpected reader: format %v, size %d, checksums %v", r.Format(), r.Size(), r.Index().Checksums)
+				}
+				if want := (size + 4095) / 4096; size > 0 && len(r.Index().Chunks) != want {
+					t.Errorf("want %d chunks, got %d", want, len(r.Index().Chunks))
+				}
+				got, err := ioutil.ReadAll(r)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("%v size %d: read mismatch: %v", f, size, err)
+				}
+				rng := rand.New(rand.NewSource(1))
+				for i := 0; i < 20 && size > 0; i++ {
+					off := rng.Intn(size)
+					n := rng.Intn(size - off)
+					buf := make([]byte, n)
+					if _, err := r.ReadAt(buf, int64(off)); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(buf, data[off:off+n]) {
+						t.Fatalf("ReadAt(%d, %d) mismatch", off, n)
+					}
+				}
+				if _, err := r.ReadAt(make([]byte, 2), int64(size)); err != io.EOF {
+					t.Errorf("want io.EOF reading past end, got %v", err)
+				}
+				r.Close()
+			}
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	data := testData(50000)
+	comp := compress(t, S2, data, WriterChunkSize(1000))
+	r, err := NewReader(bytes.NewReader(comp), int64(len(comp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(-100, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data[len(data)-100:]) {
+		t.Fatalf("read after seek mismatch: %v", err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected error seeking to negative offset")
+	}
+}
+
+func TestConvert(t *testing.T) {
+	data := testData(30000)
+	zcomp := compress(t, Zstd, data, WriterChunkSize(7000))
+	var buf bytes.Buffer
+	if err := Convert(&buf, bytes.NewReader(zcomp), int64(len(zcomp)), S2, WriterLevel(2)); err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeStream(t, S2, buf.Bytes()); !bytes.Equal(got, data) {
+		t.Fatal("converted output mismatch")
+	}
+	zidx, _, err := ReadIndex(bytes.NewReader(zcomp), int64(len(zcomp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidx, f, err := ReadIndex(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != S2 || len(sidx.Chunks) != len(zidx.Chunks) {
+		t.Fatalf("unexpected converted index: %v, %d chunks", f, len(sidx.Chunks))
+	}
+	for i, c := range sidx.Chunks {
+		z := zidx.Chunks[i]
+		if c.Offset != z.Offset || c.Size != z.Size || c.Checksum != z.Checksum {
+			t.Errorf("chunk %d: got %+v, want %+v", i, c, z)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	data := testData(10000)
+	for _, f := range []Format{Zstd, S2} {
+		comp := compress(t, f, data, WriterChunkSize(1000))
+		if _, err := NewReader(bytes.NewReader(comp[:len(comp)-1]), int64(len(comp)-1)); err == nil {
+			t.Errorf("%v: expected error on truncated stream", f)
+		}
+		if _, err := NewReader(bytes.NewReader(comp[1:]), int64(len(comp)-1)); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%v: want ErrCorrupt on stream with missing data, got %v", f, err)
+		}
+		// Change a checksum.
+		bad := append([]byte{}, comp...)
+		bad[len(bad)-zstd.SeekTableFooterSize-1] ^= 1
+		r, err := NewReader(bytes.NewReader(bad), int64(len(bad)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(r); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%v: want ErrCorrupt on checksum mismatch, got %v", f, err)
+		}
+	}
+	if _, _, err := ParseIndex([]byte("not an index")); err != ErrNoIndex {
+		t.Errorf("want ErrNoIndex, got %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if _, err := NewWriter(ioutil.Discard, S2, WriterLevel(4)); err == nil {
+		t.Error("expected error on invalid s2 level")
+	}
+	if _, err := NewWriter(ioutil.Discard, Zstd, WriterChunkSize(0)); err == nil {
+		t.Error("expected error on invalid chunk size")
+	}
+	if _, err := NewWriter(ioutil.Discard, Format(0)); err == nil {
+		t.Error("expected error on invalid format")
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package seekable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/xxhash"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultChunkSize is the default uncompressed size of chunks.
+	DefaultChunkSize = 1 << 20
+
+	// MaxChunkSize is the maximum uncompressed size of chunks.
+	MaxChunkSize = 1 << 30
+)
+
+// s2StreamIdentifier is the s2 stream identifier chunk,
+// used as the content of empty s2 chunks.
+const s2StreamIdentifier = "\xff\x06\x00\x00S2sTwO"
+
+var errClosed = errors.New("seekable: Writer is closed")
+
+// WriterOption is an option for creating a Writer.
+type WriterOption func(*Writer) error
+
+// WriterChunkSize sets the uncompressed size of each chunk.
+// Smaller chunks give faster random access, but lower compression.
+// The default is DefaultChunkSize.
+func WriterChunkSize(n int) WriterOption {
+	return func(w *Writer) error {
+		if n <= 0 || n > MaxChunkSize {
+			return fmt.Errorf("seekable: chunk size must be 1 to %d, got %d", MaxChunkSize, n)
+		}
+		w.chunkSize = n
+		return nil
+	}
+}
+
+// WriterLevel sets the compression level.
+// For zstd the level uses the zstd command line scale,
+// see zstd.EncoderLevelFromZstd. The default is 3.
+// For s2 the levels are 1 (fast), 2 (better) and 3 (best). The default is 1.
+func WriterLevel(level int) WriterOption {
+	return func(w *Writer) error {
+		if w.format == S2 && (level < 1 || level > 3) {
+			return fmt.Errorf("seekable: s2 level must be 1 to 3, got %d", level)
+		}
+		w.level = level
+		return nil
+	}
+}
+
+// WriterChecksum sets whether checksums of chunks are stored in the index.
+// The default is true.
+func WriterChecksum(b bool) WriterOption {
+	return func(w *Writer) error {
+		w.checksums = b
+		return nil
+	}
+}
+
+// Writer compresses a seekable stream.
+type Writer struct {
+	w         io.Writer
+	format    Format
+	chunkSize int
+	level     int
+	checksums bool
+
+	buf   []byte
+	out   []byte
+	index Index
+	err   error
+
+	zenc   *zstd.Encoder
+	s2w    *s2.Writer
+	s2buf  bytes.Buffer
+	closed bool
+}
+
+// NewWriter returns a Writer that writes a seekable stream in the format to w.
+// Close must be called to write the index.
+func NewWriter(w io.Writer, f Format, opts ...WriterOption) (*Writer, error) {
+	sw := Writer{
+		w:         w,
+		format:    f,
+		chunkSize: DefaultChunkSize,
+		checksums: true,
+	}
+	switch f {
+	case Zstd:
+		sw.level = 3
+	case S2:
+		sw.level = 1
+	default:
+		return nil, fmt.Errorf("seekable: unknown format %v", f)
+	}
+	for _, o := range opts {
+		if err := o(&sw); err != nil {
+			return nil, err
+		}
+	}
+	sw.index.Checksums = sw.checksums
+	switch f {
+	case Zstd:
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(sw.level)),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithZeroFrames(true))
+		if err != nil {
+			return nil, err
+		}
+		sw.zenc = enc
+	case S2:
+		opts := []s2.WriterOption{s2.WriterConcurrency(1)}
+		switch sw.level {
+		case 2:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 3:
+			opts = append(opts, s2.WriterBestCompression())
+		}
+		sw.s2w = s2.NewWriter(nil, opts...)
+	}
+	return &sw, nil
+}
+
+// Write compresses p.
+// Chunks are written when they are full.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := 0
+	for len(p) > 0 {
+		add := w.chunkSize - len(w.buf)
+		if add > len(p) {
+			add = len(p)
+		}
+		w.buf = append(w.buf, p[:add]...)
+		p = p[add:]
+		n += add
+		if len(w.buf) == w.chunkSize {
+			if err := w.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes buffered data as a chunk, even if it is not full.
+// Flushing often will reduce compression.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeChunk(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// writeChunk compresses and writes src as a single chunk.
+func (w *Writer) writeChunk(src []byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(src) > MaxChunkSize {
+		w.err = fmt.Errorf("seekable: chunk size %d exceeds maximum", len(src))
+		return w.err
+	}
+	switch w.format {
+	case Zstd:
+		w.out = w.zenc.EncodeAll(src, w.out[:0])
+	case S2:
+		if len(src) == 0 {
+			w.out = append(w.out[:0], s2StreamIdentifier...)
+			break
+		}
+		w.s2buf.Reset()
+		w.s2w.Reset(&w.s2buf)
+		if _, err := w.s2w.Write(src); err != nil {
+			w.err = err
+			return err
+		}
+		if err := w.s2w.Close(); err != nil {
+			w.err = err
+			return err
+		}
+		w.out = append(w.out[:0], w.s2buf.Bytes()...)
+	}
+	var sum uint32
+	if w.checksums {
+		sum = xxhash.Checksum32(src)
+	}
+	if _, err := w.w.Write(w.out); err != nil {
+		w.err = err
+		return err
+	}
+	w.index.add(int64(len(w.out)), int64(len(src)), sum)
+	return nil
+}
+
+// Close writes remaining data and the index.
+// The Writer cannot be used after Close.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(w.index.Chunks) == 0 {
+		// Always write a chunk, so the output is a valid stream.
+		if err := w.writeChunk(nil); err != nil {
+			return err
+		}
+	}
+	if w.zenc != nil {
+		w.zenc.Close()
+	}
+	var err error
+	w.out, err = w.index.AppendTo(w.out[:0], w.format)
+	if err == nil {
+		_, err = w.w.Write(w.out)
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errClosed
+	return nil
+}
+
+// Index returns the index of the chunks written so far.
+// The returned index must not be modified.
+func (w *Writer) Index() *Index {
+	return &w.index
+}
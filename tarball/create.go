@@ -0,0 +1,149 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package tarball
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// CreateOptions controls how archives are created.
+type CreateOptions struct {
+	// Format of the archive. Must be set.
+	Format Format
+
+	// Level is the compression level in the scale of the format.
+	// Gzip uses 1 -> 9, zstd uses the zstd commandline levels 1 -> 22,
+	// and S2 uses 1 (fast), 2 (better) and 3 (best).
+	// If 0, the default level of the format is used.
+	Level int
+
+	// Concurrency is the number of goroutines used for compression.
+	// If 0, GOMAXPROCS is used.
+	Concurrency int
+
+	// Include will only add files matching at least one of the patterns.
+	// Directories are always traversed.
+	// If empty, all files are included.
+	Include []string
+
+	// Exclude will skip files and directories matching any of the patterns.
+	// Excluded directories are not traversed.
+	Exclude []string
+
+	// ZstdOptions are added to the encoder options of FormatZstd,
+	// after those set by Level and Concurrency.
+	ZstdOptions []zstd.EOption
+}
+
+// Create writes a compressed archive of the content of root to w.
+// Names in the archive are relative to root and use forward slashes.
+// Regular files, directories and symbolic links are added.
+// Other file types are skipped.
+//
+// Patterns are matched using path.Match against both the relative name and the base name.
+func Create(ctx context.Context, w io.Writer, root string, o CreateOptions) error {
+	if err := validatePatterns(o.Include); err != nil {
+		return err
+	}
+	if err := validatePatterns(o.Exclude); err != nil {
+		return err
+	}
+	cw, err := newCompressor(w, o.Format, o.Level, concurrency(o.Concurrency), o.ZstdOptions)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(cw)
+	err = filepath.Walk(root, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if matchAny(o.Exclude, rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && len(o.Include) > 0 && !matchAny(o.Include, rel) {
+			return nil
+		}
+		return addFile(ctx, tw, name, rel, info)
+	})
+	if err != nil {
+		cw.Close()
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// addFile adds a single file to the archive.
+func addFile(ctx context.Context, tw *tar.Writer, name, rel string, info os.FileInfo) error {
+	var link string
+	switch {
+	case info.Mode().IsRegular(), info.IsDir():
+	case info.Mode()&os.ModeSymlink != 0:
+		var err error
+		link, err = os.Readlink(name)
+		if err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, ctxReader{ctx: ctx, r: f})
+	return err
+}
+
+// ctxReader returns the context error when the context is cancelled.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,292 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package tarball
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ExtractOptions controls how archives are extracted.
+// Limits of 0 means no limit.
+// When extracting archives from untrusted sources, limits should be set.
+type ExtractOptions struct {
+	// Format of the archive.
+	// If FormatAuto, the format is detected from the content.
+	Format Format
+
+	// Concurrency is the number of goroutines used for decompression
+	// if the format supports it.
+	// If 0, GOMAXPROCS is used.
+	Concurrency int
+
+	// Include will only extract files matching at least one of the patterns.
+	// If empty, all files are extracted.
+	Include []string
+
+	// Exclude will skip files matching any of the patterns.
+	Exclude []string
+
+	// MaxFiles is the maximum number of entries that will be extracted.
+	MaxFiles int
+
+	// MaxFileSize is the maximum size of a single file.
+	MaxFileSize int64
+
+	// MaxTotalSize is the maximum number of bytes written to all files.
+	MaxTotalSize int64
+
+	// Overwrite allows existing files to be replaced.
+	// If false, extracting to an existing file returns an error.
+	Overwrite bool
+
+	// NoSymlinks will skip symbolic links and hard links.
+	NoSymlinks bool
+
+	// Progress is called with the name of each entry before it is extracted,
+	// if not nil.
+	Progress func(name string)
+
+	// ZstdOptions are added to the decoder options of FormatZstd,
+	// after the one set by Concurrency.
+	// They are not used when the format is detected.
+	ZstdOptions []zstd.DOption
+}
+
+// Extract decompresses the archive read from r into the dst directory.
+// The directory is created if it doesn't exist.
+//
+// Entries with absolute names or names that would be placed outside dst are
+// rejected, as are links that point outside dst.
+// Files are never written through symbolic links.
+// Permission bits are kept, but setuid, setgid and sticky bits are removed.
+// Ownership is not restored.
+//
+// If a limit is exceeded, ErrLimit is returned.
+// Files extracted before an error are not removed.
+func Extract(ctx context.Context, r io.Reader, dst string, o ExtractOptions) error {
+	if err := validatePatterns(o.Include); err != nil {
+		return err
+	}
+	if err := validatePatterns(o.Exclude); err != nil {
+		return err
+	}
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	dec, err := newDecompressor(r, o.Format, concurrency(o.Concurrency), o.ZstdOptions)
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	x := extractor{dst: dst, o: o, ctx: ctx}
+	tr := tar.NewReader(ctxReader{ctx: ctx, r: dec})
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.extract(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+type extractor struct {
+	dst   string
+	o     ExtractOptions
+	ctx   context.Context
+	files int
+	total int64
+}
+
+// target returns the cleaned, slash separated name of the entry and the
+// file system path it should be written to.
+func (x *extractor) target(name string) (string, string, error) {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || filepath.IsAbs(name) {
+		return "", "", fmt.Errorf("tarball: invalid file name %q", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", "", fmt.Errorf("tarball: file name %q outside destination", name)
+	}
+	return clean, filepath.Join(x.dst, filepath.FromSlash(clean)), nil
+}
+
+// within returns whether the link target is inside the destination,
+// when resolved from the directory of the slash separated name.
+func within(name, link string) bool {
+	if link == "" || path.IsAbs(link) || filepath.IsAbs(link) {
+		return false
+	}
+	// ".." is only allowed at the start. After another element
+	// it could follow an extracted symbolic link and resolve elsewhere.
+	parents := true
+	for _, elem := range strings.Split(filepath.ToSlash(link), "/") {
+		if elem != ".." {
+			parents = false
+		} else if !parents {
+			return false
+		}
+	}
+	p := path.Join(path.Dir(name), link)
+	return p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// checkParents returns an error if any directory between dst and the
+// name is a symbolic link or not a directory.
+func (x *extractor) checkParents(name string) error {
+	dir := x.dst
+	parts := strings.Split(name, "/")
+	for _, p := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, p)
+		st, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("tarball: %q is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) extract(hdr *tar.Header, r io.Reader) error {
+	name, target, err := x.target(hdr.Name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return nil
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir, tar.TypeReg, tar.TypeRegA, tar.TypeSymlink, tar.TypeLink:
+	default:
+		// Devices, fifos, etc are skipped.
+		return nil
+	}
+	if hdr.Typeflag != tar.TypeDir {
+		if len(x.o.Include) > 0 && !matchAny(x.o.Include, name) {
+			return nil
+		}
+	}
+	if matchAny(x.o.Exclude, name) {
+		return nil
+	}
+	if (hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink) && x.o.NoSymlinks {
+		return nil
+	}
+	x.files++
+	if x.o.MaxFiles > 0 && x.files > x.o.MaxFiles {
+		return ErrLimit
+	}
+	if err := x.checkParents(name); err != nil {
+		return err
+	}
+	if x.o.Progress != nil {
+		x.o.Progress(hdr.Name)
+	}
+	mode := os.FileMode(hdr.Mode).Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		return os.Chmod(target, mode|0700)
+	case tar.TypeSymlink:
+		if !within(name, hdr.Linkname) {
+			return fmt.Errorf("tarball: link %q -> %q points outside destination", hdr.Name, hdr.Linkname)
+		}
+		if err := x.prepare(target); err != nil {
+			return err
+		}
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		linkName, linkTarget, err := x.target(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := x.checkParents(linkName); err != nil {
+			return err
+		}
+		if st, err := os.Lstat(linkTarget); err != nil || !st.Mode().IsRegular() {
+			return fmt.Errorf("tarball: hard link %q -> %q must point to an extracted file", hdr.Name, hdr.Linkname)
+		}
+		if err := x.prepare(target); err != nil {
+			return err
+		}
+		return os.Link(linkTarget, target)
+	}
+
+	// Regular file.
+	if x.o.MaxFileSize > 0 && hdr.Size > x.o.MaxFileSize {
+		return ErrLimit
+	}
+	if x.o.MaxTotalSize > 0 && x.total+hdr.Size > x.o.MaxTotalSize {
+		return ErrLimit
+	}
+	if err := x.prepare(target); err != nil {
+		return err
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_EXCL
+	f, err := os.OpenFile(target, flags, mode)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	x.total += n
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// prepare creates the parent directory of target and removes any
+// existing file if overwriting is allowed.
+func (x *extractor) prepare(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	st, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !x.o.Overwrite {
+		return fmt.Errorf("tarball: %q already exists", target)
+	}
+	if st.IsDir() {
+		return errors.New("tarball: cannot replace directory " + target)
+	}
+	return os.Remove(target)
+}
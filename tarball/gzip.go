@@ -0,0 +1,125 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package tarball
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// parallelGzipBlock is the amount of input compressed as a single gzip member.
+const parallelGzipBlock = 1 << 20
+
+// parallelGzip compresses blocks concurrently as separate gzip members.
+// Output is written in order.
+type parallelGzip struct {
+	w     io.Writer
+	level int
+	buf   []byte
+
+	// blocks is the number of blocks sent for compression.
+	blocks int
+
+	// results has an entry for each block in order.
+	results chan chan gzipResult
+	sem     chan struct{}
+	done    chan struct{}
+	err     error
+	pool    sync.Pool
+}
+
+type gzipResult struct {
+	b   *bytes.Buffer
+	err error
+}
+
+func newParallelGzip(w io.Writer, level, conc int) (*parallelGzip, error) {
+	// Check level.
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+	g := &parallelGzip{
+		w:       w,
+		level:   level,
+		results: make(chan chan gzipResult, conc),
+		sem:     make(chan struct{}, conc),
+		done:    make(chan struct{}),
+	}
+	go g.writeResults()
+	return g, nil
+}
+
+// writeResults writes compressed blocks in order.
+func (g *parallelGzip) writeResults() {
+	defer close(g.done)
+	for res := range g.results {
+		r := <-res
+		if g.err == nil {
+			g.err = r.err
+			if g.err == nil {
+				_, g.err = r.b.WriteTo(g.w)
+			}
+		}
+		g.pool.Put(r.b)
+	}
+}
+
+func (g *parallelGzip) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if g.buf == nil {
+			g.buf = make([]byte, 0, parallelGzipBlock)
+		}
+		todo := p
+		if rem := parallelGzipBlock - len(g.buf); len(todo) > rem {
+			todo = todo[:rem]
+		}
+		g.buf = append(g.buf, todo...)
+		p = p[len(todo):]
+		if len(g.buf) == parallelGzipBlock {
+			g.flushBlock()
+		}
+	}
+	return n, nil
+}
+
+func (g *parallelGzip) flushBlock() {
+	block := g.buf
+	g.buf = nil
+	g.blocks++
+	res := make(chan gzipResult, 1)
+	g.sem <- struct{}{}
+	g.results <- res
+	go func() {
+		defer func() { <-g.sem }()
+		b, _ := g.pool.Get().(*bytes.Buffer)
+		if b == nil {
+			b = new(bytes.Buffer)
+		}
+		b.Reset()
+		gw, err := gzip.NewWriterLevel(b, g.level)
+		if err == nil {
+			_, err = gw.Write(block)
+			if err == nil {
+				err = gw.Close()
+			}
+		}
+		res <- gzipResult{b: b, err: err}
+	}()
+}
+
+// Close flushes remaining data and waits for all output to be written.
+// Errors from compressing or writing are returned here.
+func (g *parallelGzip) Close() error {
+	// Empty input still needs a gzip member to be valid.
+	if len(g.buf) > 0 || g.blocks == 0 {
+		g.flushBlock()
+	}
+	close(g.results)
+	<-g.done
+	return g.err
+}
@@ -0,0 +1,212 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package tarball creates and extracts compressed tar archives.
+//
+// Archives can be compressed with gzip, zstd or S2.
+// Compression is done concurrently and all operations can be cancelled
+// with a context.
+// Extraction never writes outside the destination directory and
+// can be limited in the number of files and bytes written.
+package tarball
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/klauspost/compress/auto"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Format is the compression format of an archive.
+type Format int
+
+const (
+	// FormatAuto will detect the format when extracting.
+	// It cannot be used for creating archives.
+	FormatAuto Format = iota
+
+	// FormatGzip is a gzip compressed archive, usually named .tar.gz or .tgz.
+	// When compressing concurrently the output is a multi-member gzip stream,
+	// which can be read by all gzip decoders.
+	FormatGzip
+
+	// FormatZstd is a zstd compressed archive, usually named .tar.zst.
+	FormatZstd
+
+	// FormatS2 is an S2 compressed archive, usually named .tar.s2.
+	FormatS2
+
+	// FormatNone is an uncompressed tar archive.
+	FormatNone
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatAuto:
+		return "auto"
+	case FormatGzip:
+		return "gzip"
+	case FormatZstd:
+		return "zstd"
+	case FormatS2:
+		return "s2"
+	case FormatNone:
+		return "none"
+	}
+	return "invalid"
+}
+
+// Ext returns the usual file extension of the format.
+func (f Format) Ext() string {
+	switch f {
+	case FormatGzip:
+		return ".tar.gz"
+	case FormatZstd:
+		return ".tar.zst"
+	case FormatS2:
+		return ".tar.s2"
+	case FormatNone:
+		return ".tar"
+	}
+	return ""
+}
+
+// FormatFromName returns the format indicated by the extension of a file name.
+// If the extension isn't recognized, false is returned.
+func FormatFromName(name string) (Format, bool) {
+	name = strings.ToLower(path.Base(name))
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatGzip, true
+	case strings.HasSuffix(name, ".tar.zst"), strings.HasSuffix(name, ".tzst"):
+		return FormatZstd, true
+	case strings.HasSuffix(name, ".tar.s2"):
+		return FormatS2, true
+	case strings.HasSuffix(name, ".tar"):
+		return FormatNone, true
+	}
+	return FormatAuto, false
+}
+
+// ErrLimit is returned when an archive exceeds the extraction limits.
+var ErrLimit = errors.New("tarball: extraction limit exceeded")
+
+func concurrency(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// newCompressor returns a compressing writer for the format.
+// Level uses the scale of the format, and 0 selects the default.
+// zopts are added to the options of zstd encoders.
+func newCompressor(w io.Writer, f Format, level, conc int, zopts []zstd.EOption) (io.WriteCloser, error) {
+	switch f {
+	case FormatGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if conc > 1 {
+			return newParallelGzip(w, level, conc)
+		}
+		return gzip.NewWriterLevel(w, level)
+	case FormatZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(conc)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, append(opts, zopts...)...)
+	case FormatS2:
+		opts := []s2.WriterOption{s2.WriterConcurrency(conc)}
+		switch level {
+		case 0, 1:
+		case 2:
+			opts = append(opts, s2.WriterBetterCompression())
+		case 3:
+			opts = append(opts, s2.WriterBestCompression())
+		default:
+			return nil, fmt.Errorf("tarball: invalid s2 level %d. Must be 1 -> 3", level)
+		}
+		return s2.NewWriter(w, opts...), nil
+	case FormatNone:
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("tarball: cannot create archive with format %v", f)
+}
+
+// newDecompressor returns a decompressing reader for the format.
+// zopts are added to the options of zstd decoders.
+func newDecompressor(r io.Reader, f Format, conc int, zopts []zstd.DOption) (io.ReadCloser, error) {
+	switch f {
+	case FormatAuto:
+		// Check for uncompressed archives first.
+		br := bufio.NewReader(r)
+		b, _ := br.Peek(tarMagicOffset + len(tarMagic))
+		if len(b) == tarMagicOffset+len(tarMagic) && string(b[tarMagicOffset:]) == tarMagic {
+			return ioutil.NopCloser(br), nil
+		}
+		rc, _, err := auto.NewReader(br)
+		return rc, err
+	case FormatGzip:
+		return gzip.NewReader(r)
+	case FormatZstd:
+		dec, err := zstd.NewReader(r, append([]zstd.DOption{zstd.WithDecoderConcurrency(conc)}, zopts...)...)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case FormatS2:
+		return ioutil.NopCloser(s2.NewReader(r)), nil
+	case FormatNone:
+		return ioutil.NopCloser(r), nil
+	}
+	return nil, fmt.Errorf("tarball: unknown format %v", f)
+}
+
+// The ustar magic is also present in GNU and PAX archives.
+const (
+	tarMagic       = "ustar"
+	tarMagicOffset = 257
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// matchAny returns whether name matches any of the patterns.
+// Patterns are matched against both the full slash separated name
+// and the base name using path.Match.
+func matchAny(patterns []string, name string) bool {
+	base := path.Base(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("tarball: invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
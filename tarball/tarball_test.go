@@ -0,0 +1,275 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// makeTree creates a directory with some files.
+func makeTree(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tarball")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":          "hello world",
+		"sub/b.txt":      strings.Repeat("compress me ", 10000),
+		"sub/c.log":      "log file",
+		"sub/deep/d.txt": "deep",
+		"skip/e.txt":     "skipped",
+		"empty.txt":      "",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Big file, so parallel compression is used.
+	big := bytes.Repeat([]byte("0123456789abcdef"), 300000)
+	if err := ioutil.WriteFile(filepath.Join(dir, "big.bin"), big, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tarball-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func compareFile(t *testing.T, a, b, name string) {
+	t.Helper()
+	want, err := ioutil.ReadFile(filepath.Join(a, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(b, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("%s: content mismatch", name)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	src := makeTree(t)
+	defer os.RemoveAll(src)
+	for _, f := range []Format{FormatGzip, FormatZstd, FormatS2, FormatNone} {
+		for _, conc := range []int{1, 4} {
+			t.Run(f.String(), func(t *testing.T) {
+				var buf bytes.Buffer
+				err := Create(context.Background(), &buf, src, CreateOptions{Format: f, Concurrency: conc, Exclude: []string{"skip"}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Log("archive size:", buf.Len())
+				dst := tempDir(t)
+				defer os.RemoveAll(dst)
+				var names []string
+				progress := func(name string) { names = append(names, name) }
+				err = Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, ExtractOptions{Progress: progress})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(strings.Join(names, ","), "sub/deep/d.txt") {
+					t.Errorf("progress not called for all entries: %v", names)
+				}
+				for _, name := range []string{"a.txt", "sub/b.txt", "sub/c.log", "sub/deep/d.txt", "empty.txt", "big.bin"} {
+					compareFile(t, src, dst, name)
+				}
+				if _, err := os.Stat(filepath.Join(dst, "skip")); !os.IsNotExist(err) {
+					t.Error("excluded directory was added")
+				}
+				if runtime.GOOS != "windows" {
+					link, err := os.Readlink(filepath.Join(dst, "link"))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if link != "a.txt" {
+						t.Errorf("want link to a.txt, got %q", link)
+					}
+				}
+				st, err := os.Stat(filepath.Join(dst, "big.bin"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if runtime.GOOS != "windows" && st.Mode().Perm() != 0600 {
+					t.Errorf("want mode 0600, got %v", st.Mode().Perm())
+				}
+
+				// Extracting again should fail, unless overwriting.
+				err = Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, ExtractOptions{})
+				if err == nil {
+					t.Error("expected error on existing files")
+				}
+				err = Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, ExtractOptions{Overwrite: true, Format: f})
+				if err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	src := makeTree(t)
+	defer os.RemoveAll(src)
+	var buf bytes.Buffer
+	err := Create(context.Background(), &buf, src, CreateOptions{Format: FormatZstd, Include: []string{"*.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+	err = Extract(context.Background(), &buf, dst, ExtractOptions{Exclude: []string{"sub/deep/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareFile(t, src, dst, "sub/b.txt")
+	for _, name := range []string{"sub/c.log", "big.bin", "sub/deep/d.txt"} {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s should not be extracted", name)
+		}
+	}
+	if err := Create(context.Background(), &buf, src, CreateOptions{Format: FormatZstd, Include: []string{"["}}); err == nil {
+		t.Error("expected error on invalid pattern")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	src := makeTree(t)
+	defer os.RemoveAll(src)
+	var buf bytes.Buffer
+	if err := Create(context.Background(), &buf, src, CreateOptions{Format: FormatS2}); err != nil {
+		t.Fatal(err)
+	}
+	for name, o := range map[string]ExtractOptions{
+		"files":     {MaxFiles: 3},
+		"file-size": {MaxFileSize: 1000},
+		"total":     {MaxTotalSize: 100000},
+	} {
+		dst := tempDir(t)
+		err := Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, o)
+		os.RemoveAll(dst)
+		if err != ErrLimit {
+			t.Errorf("%s: want ErrLimit, got %v", name, err)
+		}
+	}
+}
+
+func TestUnsafe(t *testing.T) {
+	for name, hdr := range map[string]tar.Header{
+		"parent":   {Name: "../evil.txt", Typeflag: tar.TypeReg},
+		"nested":   {Name: "a/../../evil.txt", Typeflag: tar.TypeReg},
+		"absolute": {Name: "/tmp/evil.txt", Typeflag: tar.TypeReg},
+		"symlink":  {Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../outside"},
+		"abs-link": {Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		"hardlink": {Name: "link", Typeflag: tar.TypeLink, Linkname: "../outside"},
+	} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		hdr.Mode = 0644
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Close()
+		dst := tempDir(t)
+		err := Extract(context.Background(), &buf, dst, ExtractOptions{Format: FormatNone})
+		os.RemoveAll(dst)
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// Writing through a symlink must fail.
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dir", Typeflag: tar.TypeSymlink, Linkname: "."})
+	tw.WriteHeader(&tar.Header{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0644})
+	tw.Close()
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+	if err := Extract(context.Background(), &buf, dst, ExtractOptions{Format: FormatNone}); err == nil {
+		t.Error("expected error writing through symlink")
+	}
+
+	// A link resolving through a previously extracted link must fail.
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "deep/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "deep/y", Typeflag: tar.TypeSymlink, Linkname: "../top"})
+	tw.WriteHeader(&tar.Header{Name: "deep/x", Typeflag: tar.TypeSymlink, Linkname: "y/../.."})
+	tw.Close()
+	dst2 := tempDir(t)
+	defer os.RemoveAll(dst2)
+	if err := Extract(context.Background(), &buf, dst2, ExtractOptions{Format: FormatNone}); err == nil {
+		t.Error("expected error for link through link")
+	}
+	if _, err := os.Lstat(filepath.Join(dst2, "deep", "x")); err == nil {
+		t.Error("escaping link was created")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	src := makeTree(t)
+	defer os.RemoveAll(src)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	if err := Create(ctx, &buf, src, CreateOptions{Format: FormatGzip}); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+	buf.Reset()
+	if err := Create(context.Background(), &buf, src, CreateOptions{Format: FormatGzip}); err != nil {
+		t.Fatal(err)
+	}
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+	if err := Extract(ctx, &buf, dst, ExtractOptions{}); err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestFormatFromName(t *testing.T) {
+	for name, want := range map[string]Format{
+		"a.tar.gz":  FormatGzip,
+		"a.TGZ":     FormatGzip,
+		"a.tar.zst": FormatZstd,
+		"a.tar.s2":  FormatS2,
+		"a.tar":     FormatNone,
+	} {
+		got, ok := FormatFromName(name)
+		if !ok || got != want {
+			t.Errorf("%s: want %v, got %v", name, want, got)
+		}
+		if want != FormatGzip && !strings.HasSuffix(name, want.Ext()) {
+			t.Errorf("%s: unexpected extension %s", name, want.Ext())
+		}
+	}
+	if _, ok := FormatFromName("a.zip"); ok {
+		t.Error("zip should not be recognized")
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package wsflate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/budget"
+	"github.com/klauspost/compress/flate"
+)
+
+// syncMarker ends every flushed message and is removed before sending.
+var syncMarker = []byte{0, 0, 0xff, 0xff}
+
+// messageTail is appended to received payloads.
+// It is the removed sync marker followed by a final empty stored block,
+// so the decompressor sees a complete stream.
+var messageTail = []byte{0, 0, 0xff, 0xff, 1, 0, 0, 0xff, 0xff}
+
+// ErrMessageTooLarge is returned when a decompressed message exceeds the limit.
+var ErrMessageTooLarge = errors.New("wsflate: message too large")
+
+// writerSize is an estimate of the memory used by a flate writer.
+const writerSize = 600 << 10
+
+// writerPools contains flate writers by level, offset by -flate.HuffmanOnly.
+var writerPools [flate.BestCompression - flate.HuffmanOnly + 1]budget.Pool
+
+func init() {
+	for i := range writerPools {
+		level := i + flate.HuffmanOnly
+		writerPools[i] = budget.Pool{
+			Name: "wsflate.writer",
+			New: func() interface{} {
+				w, _ := flate.NewWriter(nil, level)
+				return w
+			},
+			Size: func(interface{}) int64 { return writerSize },
+		}
+	}
+}
+
+var readerPool = budget.Pool{
+	Name: "wsflate.reader",
+	New: func() interface{} {
+		return flate.NewReader(bytes.NewReader(nil))
+	},
+	Size: func(interface{}) int64 { return 48 << 10 },
+}
+
+// Compressor compresses the messages sent by one side of a connection.
+// Messages must be compressed in the order they are sent.
+// A Compressor is not safe for concurrent use.
+type Compressor struct {
+	pool      *budget.Pool
+	noContext bool
+
+	// fw is kept between messages with context takeover.
+	// It always writes to tw, which forwards to the current message.
+	fw      *flate.Writer
+	tw      tailWriter
+	pending bool
+	closed  bool
+}
+
+// NewCompressor returns a Compressor for messages sent by the role
+// using the negotiated parameters.
+//
+// The flate implementation always uses a 32KB window. If the parameters
+// limit the window used by the role, Huffman only compression is used
+// regardless of the level, since it never references previous data.
+func NewCompressor(p Params, r Role, level int) (*Compressor, error) {
+	if !validLevel(level) {
+		return nil, fmt.Errorf("wsflate: invalid compression level %d", level)
+	}
+	if p.windowBits(r) < maxWindowBits {
+		level = flate.HuffmanOnly
+	}
+	return &Compressor{
+		pool:      &writerPools[level-flate.HuffmanOnly],
+		noContext: p.noContextTakeover(r),
+	}, nil
+}
+
+// NewMessage returns a writer that compresses a single message to w.
+// Close must be called to write the end of the message.
+// The next message cannot be started until Close has been called.
+func (c *Compressor) NewMessage(w io.Writer) (io.WriteCloser, error) {
+	if c.closed {
+		return nil, errors.New("wsflate: Compressor is closed")
+	}
+	if c.pending {
+		return nil, errors.New("wsflate: previous message not closed")
+	}
+	c.tw = tailWriter{w: w}
+	if c.fw == nil {
+		c.fw = c.pool.Get().(*flate.Writer)
+		c.fw.Reset(&c.tw)
+	}
+	c.pending = true
+	return &messageWriter{c: c}, nil
+}
+
+// Compress appends the compressed payload of msg to dst and returns the result.
+func (c *Compressor) Compress(dst, msg []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := c.NewMessage(buf)
+	if err != nil {
+		return dst, err
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Close releases the resources of the Compressor.
+func (c *Compressor) Close() {
+	if c.fw != nil && !c.pending {
+		c.pool.Put(c.fw)
+	}
+	c.fw = nil
+	c.closed = true
+}
+
+type messageWriter struct {
+	c *Compressor
+}
+
+func (m *messageWriter) Write(p []byte) (int, error) {
+	if m.c == nil {
+		return 0, errors.New("wsflate: write to closed message")
+	}
+	return m.c.fw.Write(p)
+}
+
+func (m *messageWriter) Close() error {
+	c := m.c
+	if c == nil {
+		return nil
+	}
+	m.c = nil
+	c.pending = false
+	err := c.fw.Flush()
+	if err == nil && (c.tw.n != len(syncMarker) || !bytes.Equal(c.tw.tail[:], syncMarker)) {
+		err = errors.New("wsflate: flushed output did not end with sync marker")
+	}
+	if err == nil {
+		err = c.tw.err
+	}
+	c.tw.w = nil
+	if c.noContext || err != nil {
+		// The writer is returned to the pool after every message.
+		// On errors the state cannot be trusted.
+		c.pool.Put(c.fw)
+		c.fw = nil
+		if err != nil {
+			c.closed = true
+		}
+	}
+	return err
+}
+
+// tailWriter forwards all writes except the last 4 bytes.
+type tailWriter struct {
+	w    io.Writer
+	tail [4]byte
+	n    int
+	err  error
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	n := len(p)
+	total := t.n + len(p)
+	if total <= len(t.tail) {
+		t.n += copy(t.tail[t.n:], p)
+		return len(p), nil
+	}
+	emit := total - len(t.tail)
+	// Emit held bytes first.
+	fromTail := emit
+	if fromTail > t.n {
+		fromTail = t.n
+	}
+	if fromTail > 0 {
+		if _, t.err = t.w.Write(t.tail[:fromTail]); t.err != nil {
+			return 0, t.err
+		}
+		t.n = copy(t.tail[:], t.tail[fromTail:t.n])
+	}
+	if fromP := emit - fromTail; fromP > 0 {
+		if _, t.err = t.w.Write(p[:fromP]); t.err != nil {
+			return 0, t.err
+		}
+		p = p[fromP:]
+	}
+	t.n += copy(t.tail[t.n:], p)
+	return n, nil
+}
+
+// Decompressor decompresses the messages received by one side of a connection.
+// Messages must be decompressed in the order they are received.
+// A Decompressor is not safe for concurrent use.
+type Decompressor struct {
+	noContext bool
+	window    int
+	maxSize   int64
+
+	fr      io.ReadCloser
+	hist    []byte
+	pending bool
+	broken  bool
+}
+
+// NewDecompressor returns a Decompressor for messages received by the role
+// using the negotiated parameters.
+func NewDecompressor(p Params, r Role) *Decompressor {
+	peer := Client
+	if r == Client {
+		peer = Server
+	}
+	return &Decompressor{
+		noContext: p.noContextTakeover(peer),
+		window:    1 << uint(p.windowBits(peer)),
+	}
+}
+
+// SetMaxMessageSize limits the decompressed size of messages.
+// Messages exceeding the limit return ErrMessageTooLarge.
+// 0, the default, means no limit.
+func (d *Decompressor) SetMaxMessageSize(n int64) {
+	d.maxSize = n
+}
+
+// NewMessage returns a reader of the decompressed message with the payload in r.
+// r must return io.EOF at the end of the payload.
+// The message must be read until io.EOF before the next message is started.
+func (d *Decompressor) NewMessage(r io.Reader) (io.Reader, error) {
+	if d.broken {
+		return nil, errors.New("wsflate: Decompressor is in an invalid state")
+	}
+	if d.pending {
+		if !d.noContext {
+			// The history is incomplete.
+			d.broken = true
+			return nil, errors.New("wsflate: previous message not fully read")
+		}
+		d.release()
+	}
+	src := io.MultiReader(r, bytes.NewReader(messageTail))
+	if d.fr == nil {
+		d.fr = readerPool.Get().(io.ReadCloser)
+	}
+	var dict []byte
+	if !d.noContext {
+		dict = d.hist
+	}
+	if err := d.fr.(flate.Resetter).Reset(src, dict); err != nil {
+		return nil, err
+	}
+	d.pending = true
+	return &messageReader{d: d}, nil
+}
+
+// Decompress appends the decompressed payload to dst and returns the result.
+func (d *Decompressor) Decompress(dst, payload []byte) ([]byte, error) {
+	r, err := d.NewMessage(bytes.NewReader(payload))
+	if err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Close releases the resources of the Decompressor.
+func (d *Decompressor) Close() {
+	d.release()
+	d.hist = nil
+	d.broken = true
+}
+
+// release returns the flate reader to the pool.
+func (d *Decompressor) release() {
+	if d.fr != nil {
+		readerPool.Put(d.fr)
+		d.fr = nil
+	}
+	d.pending = false
+}
+
+// addHistory adds decompressed output to the window used for the next message.
+func (d *Decompressor) addHistory(b []byte) {
+	if d.noContext {
+		return
+	}
+	if len(b) >= d.window {
+		d.hist = append(d.hist[:0], b[len(b)-d.window:]...)
+		return
+	}
+	if len(d.hist)+len(b) > 2*d.window {
+		d.hist = append(d.hist[:0], d.hist[len(d.hist)-d.window:]...)
+	}
+	d.hist = append(d.hist, b...)
+}
+
+type messageReader struct {
+	d   *Decompressor
+	n   int64
+	err error
+}
+
+func (m *messageReader) Read(p []byte) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	d := m.d
+	n, err := d.fr.Read(p)
+	d.addHistory(p[:n])
+	m.n += int64(n)
+	if d.maxSize > 0 && m.n > d.maxSize {
+		err = ErrMessageTooLarge
+	}
+	switch err {
+	case nil:
+		return n, nil
+	case io.EOF:
+		d.pending = false
+		if d.noContext {
+			d.release()
+		} else if len(d.hist) > d.window {
+			d.hist = append(d.hist[:0], d.hist[len(d.hist)-d.window:]...)
+		}
+	default:
+		d.broken = true
+		d.release()
+	}
+	m.err = err
+	return n, err
+}
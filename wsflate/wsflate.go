@@ -0,0 +1,306 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package wsflate implements the WebSocket permessage-deflate extension
+// described in RFC 7692.
+//
+// The package handles extension negotiation and compression of message
+// payloads, including context takeover across messages and window size
+// limits. Framing and setting the RSV1 bit is left to the WebSocket library.
+//
+// A server negotiates with the client offers and creates a Compressor and
+// Decompressor for the connection:
+//
+//	cfg := wsflate.Config{Level: flate.BestSpeed}
+//	params, ok := cfg.Negotiate(r.Header)
+//	if ok {
+//		respHeader.Set("Sec-WebSocket-Extensions", params.String())
+//		comp, _ := wsflate.NewCompressor(params, wsflate.Server, cfg.Level)
+//		decomp := wsflate.NewDecompressor(params, wsflate.Server)
+//		...
+//	}
+//
+// A client sends cfg.Offer() and validates the response with cfg.Accept.
+package wsflate
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// ExtensionName is the name of the extension.
+const ExtensionName = "permessage-deflate"
+
+// HeaderName is the HTTP header used for negotiation.
+const HeaderName = "Sec-WebSocket-Extensions"
+
+const (
+	minWindowBits = 8
+	maxWindowBits = 15
+)
+
+// Role is the side of the connection.
+type Role uint8
+
+const (
+	// Server is the side that accepted the connection.
+	Server Role = iota
+	// Client is the side that initiated the connection.
+	Client
+)
+
+// Params are the parameters of the extension.
+type Params struct {
+	// ServerNoContextTakeover prevents the server from using
+	// previous messages when compressing.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover prevents the client from using
+	// previous messages when compressing.
+	ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits limits the window the server uses for compression.
+	// The value is 8 to 15, or 0 if not specified, which means 15.
+	ServerMaxWindowBits int
+
+	// ClientMaxWindowBits limits the window the client uses for compression.
+	// The value is 8 to 15, or 0 if not specified, which means 15.
+	// In offers, -1 means the parameter is present without a value,
+	// indicating the client supports it.
+	ClientMaxWindowBits int
+}
+
+// String returns the parameters as an extension header value.
+func (p Params) String() string {
+	var sb strings.Builder
+	sb.WriteString(ExtensionName)
+	if p.ServerNoContextTakeover {
+		sb.WriteString("; server_no_context_takeover")
+	}
+	if p.ClientNoContextTakeover {
+		sb.WriteString("; client_no_context_takeover")
+	}
+	if p.ServerMaxWindowBits > 0 {
+		sb.WriteString("; server_max_window_bits=")
+		sb.WriteString(strconv.Itoa(p.ServerMaxWindowBits))
+	}
+	switch {
+	case p.ClientMaxWindowBits > 0:
+		sb.WriteString("; client_max_window_bits=")
+		sb.WriteString(strconv.Itoa(p.ClientMaxWindowBits))
+	case p.ClientMaxWindowBits < 0:
+		sb.WriteString("; client_max_window_bits")
+	}
+	return sb.String()
+}
+
+// windowBits returns the window bits the role uses for compression.
+func (p Params) windowBits(r Role) int {
+	bits := p.ServerMaxWindowBits
+	if r == Client {
+		bits = p.ClientMaxWindowBits
+	}
+	if bits <= 0 {
+		return maxWindowBits
+	}
+	return bits
+}
+
+// noContextTakeover returns whether the role compresses without context takeover.
+func (p Params) noContextTakeover(r Role) bool {
+	if r == Client {
+		return p.ClientNoContextTakeover
+	}
+	return p.ServerNoContextTakeover
+}
+
+// ParseHeader returns all permessage-deflate offers or responses in the header,
+// which may contain multiple extensions and values.
+// Other extensions are ignored.
+// An error is returned if a permessage-deflate element is invalid.
+func ParseHeader(h http.Header) ([]Params, error) {
+	var res []Params
+	for _, e := range parseElements(h) {
+		if e.err != nil {
+			return res, e.err
+		}
+		res = append(res, e.p)
+	}
+	return res, nil
+}
+
+type element struct {
+	p   Params
+	err error
+}
+
+// parseElements returns all permessage-deflate elements in the header.
+func parseElements(h http.Header) []element {
+	var res []element
+	for _, v := range h[http.CanonicalHeaderKey(HeaderName)] {
+		for _, ext := range strings.Split(v, ",") {
+			parts := strings.Split(ext, ";")
+			if !strings.EqualFold(strings.TrimSpace(parts[0]), ExtensionName) {
+				continue
+			}
+			p, err := parseParams(parts[1:])
+			res = append(res, element{p: p, err: err})
+		}
+	}
+	return res
+}
+
+func parseParams(parts []string) (Params, error) {
+	var p Params
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		kv := strings.SplitN(part, "=", 2)
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if seen[name] {
+			return p, fmt.Errorf("wsflate: duplicate parameter %q", name)
+		}
+		seen[name] = true
+		val := ""
+		hasVal := len(kv) == 2
+		if hasVal {
+			val = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+		}
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if hasVal {
+				return p, fmt.Errorf("wsflate: unexpected value for %s", name)
+			}
+			if name == "server_no_context_takeover" {
+				p.ServerNoContextTakeover = true
+			} else {
+				p.ClientNoContextTakeover = true
+			}
+		case "server_max_window_bits":
+			bits, err := parseWindowBits(val)
+			if err != nil {
+				return p, err
+			}
+			p.ServerMaxWindowBits = bits
+		case "client_max_window_bits":
+			if !hasVal {
+				p.ClientMaxWindowBits = -1
+				continue
+			}
+			bits, err := parseWindowBits(val)
+			if err != nil {
+				return p, err
+			}
+			p.ClientMaxWindowBits = bits
+		default:
+			return p, fmt.Errorf("wsflate: unknown parameter %q", name)
+		}
+	}
+	return p, nil
+}
+
+func parseWindowBits(s string) (int, error) {
+	// Values must be plain decimal integers without leading zeros.
+	if s == "" || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("wsflate: invalid window bits %q", s)
+	}
+	bits, err := strconv.Atoi(s)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, fmt.Errorf("wsflate: invalid window bits %q", s)
+	}
+	return bits, nil
+}
+
+// Config contains the local preferences for negotiation.
+type Config struct {
+	// Level is the flate compression level used for compression.
+	Level int
+
+	// ServerNoContextTakeover and ClientNoContextTakeover request
+	// that the server or client compress each message independently.
+	// This reduces memory use per connection, since no state is kept
+	// between messages, at the cost of compression.
+	ServerNoContextTakeover, ClientNoContextTakeover bool
+
+	// ServerMaxWindowBits requests a limit of the window the server uses.
+	// Only used by clients. 0 means no limit.
+	ServerMaxWindowBits int
+}
+
+// ErrDeclined is returned by Accept when the server did not accept the extension.
+var ErrDeclined = errors.New("wsflate: extension declined")
+
+// Negotiate selects the first acceptable offer in the request headers
+// and returns the parameters to use and to send in the response.
+// If no offer is acceptable, ok is false and the extension must not be used.
+func (c Config) Negotiate(h http.Header) (p Params, ok bool) {
+	for _, e := range parseElements(h) {
+		if e.err != nil {
+			// Invalid offers are declined, but later offers may be acceptable.
+			continue
+		}
+		offer := e.p
+		// The server must honor server parameters in the offer.
+		p = Params{
+			ServerNoContextTakeover: offer.ServerNoContextTakeover || c.ServerNoContextTakeover,
+			ClientNoContextTakeover: offer.ClientNoContextTakeover || c.ClientNoContextTakeover,
+			ServerMaxWindowBits:     offer.ServerMaxWindowBits,
+		}
+		if offer.ClientMaxWindowBits > 0 {
+			// Echo the limit, so the client doesn't need to assume it.
+			p.ClientMaxWindowBits = offer.ClientMaxWindowBits
+		}
+		return p, true
+	}
+	return Params{}, false
+}
+
+// Offer returns the header value a client sends to offer the extension.
+func (c Config) Offer() string {
+	return Params{
+		ServerNoContextTakeover: c.ServerNoContextTakeover,
+		ClientNoContextTakeover: c.ClientNoContextTakeover,
+		ServerMaxWindowBits:     c.ServerMaxWindowBits,
+		ClientMaxWindowBits:     -1,
+	}.String()
+}
+
+// Accept validates the server response headers against the offer made
+// with Offer and returns the parameters to use.
+// If the response does not contain the extension, ErrDeclined is returned
+// and the connection can be used without compression.
+// Other errors mean the connection must be failed.
+func (c Config) Accept(h http.Header) (Params, error) {
+	res, err := ParseHeader(h)
+	if err != nil {
+		return Params{}, err
+	}
+	if len(res) == 0 {
+		return Params{}, ErrDeclined
+	}
+	if len(res) > 1 {
+		return Params{}, errors.New("wsflate: multiple responses")
+	}
+	p := res[0]
+	if p.ClientMaxWindowBits < 0 {
+		return Params{}, errors.New("wsflate: client_max_window_bits without value in response")
+	}
+	if c.ServerNoContextTakeover && !p.ServerNoContextTakeover {
+		return Params{}, errors.New("wsflate: server_no_context_takeover not accepted")
+	}
+	if c.ServerMaxWindowBits > 0 && (p.ServerMaxWindowBits == 0 || p.ServerMaxWindowBits > c.ServerMaxWindowBits) {
+		return Params{}, errors.New("wsflate: server_max_window_bits not accepted")
+	}
+	// Our offer supports client limits, so respect them, and disabling context takeover.
+	p.ClientNoContextTakeover = p.ClientNoContextTakeover || c.ClientNoContextTakeover
+	return p, nil
+}
+
+// validLevel returns whether the level can be used for compression.
+func validLevel(level int) bool {
+	return level >= flate.HuffmanOnly && level <= flate.BestCompression
+}
@@ -0,0 +1,216 @@
+package wsflate
+
+import (
+	"bytes"
+	stdflate "compress/flate"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+func header(v ...string) http.Header {
+	h := http.Header{}
+	for _, s := range v {
+		h.Add(HeaderName, s)
+	}
+	return h
+}
+
+func TestNegotiate(t *testing.T) {
+	cfg := Config{Level: flate.BestSpeed}
+	for _, tc := range []struct {
+		offer string
+		want  string
+		ok    bool
+	}{
+		{offer: "permessage-deflate", want: "permessage-deflate", ok: true},
+		{offer: "permessage-deflate; client_max_window_bits", want: "permessage-deflate", ok: true},
+		{offer: "permessage-deflate; client_max_window_bits=10; server_no_context_takeover", want: "permessage-deflate; server_no_context_takeover; client_max_window_bits=10", ok: true},
+		{offer: `permessage-deflate; server_max_window_bits="12"`, want: "permessage-deflate; server_max_window_bits=12", ok: true},
+		{offer: "x-webkit-deflate-frame, permessage-deflate; client_no_context_takeover", want: "permessage-deflate; client_no_context_takeover", ok: true},
+		// Invalid offers are skipped.
+		{offer: "permessage-deflate; server_max_window_bits=16, permessage-deflate", want: "permessage-deflate", ok: true},
+		{offer: "permessage-deflate; server_max_window_bits=010", ok: false},
+		{offer: "permessage-deflate; server_no_context_takeover; server_no_context_takeover", ok: false},
+		{offer: "permessage-deflate; unknown", ok: false},
+		{offer: "x-other", ok: false},
+	} {
+		p, ok := cfg.Negotiate(header(tc.offer))
+		if ok != tc.ok {
+			t.Errorf("%q: got ok %v", tc.offer, ok)
+			continue
+		}
+		if ok && p.String() != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.offer, p.String(), tc.want)
+		}
+	}
+
+	cfg.ClientNoContextTakeover = true
+	p, _ := cfg.Negotiate(header("permessage-deflate"))
+	if !p.ClientNoContextTakeover {
+		t.Error("server preference for client_no_context_takeover not applied")
+	}
+}
+
+func TestAccept(t *testing.T) {
+	cfg := Config{ServerNoContextTakeover: true, ServerMaxWindowBits: 12}
+	offer := cfg.Offer()
+	if offer != "permessage-deflate; server_no_context_takeover; server_max_window_bits=12; client_max_window_bits" {
+		t.Fatalf("unexpected offer %q", offer)
+	}
+	resp, ok := Config{}.Negotiate(header(offer))
+	if !ok {
+		t.Fatal("offer not accepted")
+	}
+	p, err := cfg.Accept(header(resp.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != resp {
+		t.Errorf("got %+v, want %+v", p, resp)
+	}
+	if _, err := cfg.Accept(header()); err != ErrDeclined {
+		t.Errorf("want ErrDeclined, got %v", err)
+	}
+	for _, bad := range []string{
+		"permessage-deflate; server_max_window_bits=12",
+		"permessage-deflate; server_no_context_takeover; server_max_window_bits=13",
+		"permessage-deflate; server_no_context_takeover; server_max_window_bits=12; client_max_window_bits",
+		"permessage-deflate; foo",
+	} {
+		if _, err := cfg.Accept(header(bad)); err == nil || err == ErrDeclined {
+			t.Errorf("%q: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func messages() [][]byte {
+	var res [][]byte
+	for i := 0; i < 20; i++ {
+		res = append(res, []byte(fmt.Sprintf(`{"type":"update","id":%d,"payload":"the same text is repeated in every message"}`, i%3)))
+	}
+	res = append(res, nil, bytes.Repeat([]byte("large message "), 10000))
+	return res
+}
+
+func TestRoundtrip(t *testing.T) {
+	for _, p := range []Params{
+		{},
+		{ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+		{ServerMaxWindowBits: 9, ClientMaxWindowBits: 10},
+	} {
+		for _, level := range []int{flate.HuffmanOnly, flate.BestSpeed, 5, flate.BestCompression} {
+			t.Run(fmt.Sprintf("%s/level-%d", p, level), func(t *testing.T) {
+				comp, err := NewCompressor(p, Server, level)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer comp.Close()
+				dec := NewDecompressor(p, Client)
+				defer dec.Close()
+				var sizes []int
+				for _, msg := range messages() {
+					payload, err := comp.Compress(nil, msg)
+					if err != nil {
+						t.Fatal(err)
+					}
+					sizes = append(sizes, len(payload))
+					got, err := dec.Decompress(nil, payload)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, msg) {
+						t.Fatalf("mismatch: got %q", got)
+					}
+				}
+				// Repeated messages compress better with context takeover.
+				// The fast levels don't search for matches in small writes.
+				takeover := !p.ServerNoContextTakeover && p.ServerMaxWindowBits == 0 && level != flate.HuffmanOnly
+				if takeover && level == flate.BestCompression && sizes[10] >= sizes[0] {
+					t.Errorf("context takeover not used: sizes %v", sizes)
+				}
+				if !takeover && sizes[3] != sizes[0] {
+					t.Errorf("context used without takeover: sizes %v", sizes)
+				}
+			})
+		}
+	}
+}
+
+// TestStdlib verifies interoperability with compress/flate.
+func TestStdlib(t *testing.T) {
+	p := Params{}
+	comp, _ := NewCompressor(p, Client, flate.DefaultCompression)
+	dec := NewDecompressor(p, Server)
+
+	// Our output read by a single stdlib stream.
+	var stream []byte
+	for _, msg := range messages() {
+		payload, err := comp.Compress(nil, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(append(stream, payload...), syncMarker...)
+	}
+	stream = append(stream, 1, 0, 0, 0xff, 0xff)
+	got, err := ioutil.ReadAll(stdflate.NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bytes.Join(messages(), nil); !bytes.Equal(got, want) {
+		t.Fatal("stdlib output mismatch")
+	}
+
+	// Stdlib output with context takeover read by us.
+	var buf bytes.Buffer
+	fw, _ := stdflate.NewWriter(&buf, stdflate.BestCompression)
+	for _, msg := range messages() {
+		buf.Reset()
+		fw.Write(msg)
+		fw.Flush()
+		payload := bytes.TrimSuffix(buf.Bytes(), syncMarker)
+		got, err := dec.Decompress(nil, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("decompress mismatch")
+		}
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	comp, _ := NewCompressor(Params{}, Server, flate.BestSpeed)
+	payload, err := comp.Compress(nil, make([]byte, 100000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewDecompressor(Params{}, Client)
+	dec.SetMaxMessageSize(1000)
+	if _, err := dec.Decompress(nil, payload); err != ErrMessageTooLarge {
+		t.Fatalf("want ErrMessageTooLarge, got %v", err)
+	}
+	// The history is lost, so the decompressor cannot be used.
+	if _, err := dec.Decompress(nil, payload); err == nil {
+		t.Fatal("expected error after failed message")
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	in := []byte("0123456789abcdef")
+	for split := 0; split <= len(in); split++ {
+		for split2 := split; split2 <= len(in); split2++ {
+			var buf bytes.Buffer
+			tw := tailWriter{w: &buf}
+			tw.Write(in[:split])
+			tw.Write(in[split:split2])
+			tw.Write(in[split2:])
+			if buf.String() != string(in[:len(in)-4]) || string(tw.tail[:tw.n]) != "cdef" {
+				t.Fatalf("split %d,%d: got %q, tail %q", split, split2, buf.String(), tw.tail[:tw.n])
+			}
+		}
+	}
+}
@@ -1,6 +1,13 @@
 // Package xxhash implements the 64-bit variant of xxHash (XXH64) as described
 // at http://cyan4973.github.io/xxHash/.
-// THIS IS VENDORED: Go to github.com/cespare/xxhash for original package.
+//
+// The package is based on github.com/cespare/xxhash and is the implementation
+// used by the zstd and seekable packages. It is exported, so users of
+// this module can compute matching checksums without another dependency.
+//
+// Checksum32 returns the 32 bit checksum stored in zstd frames
+// and seekable indexes, which is the lower 32 bits of the XXH64 hash
+// with seed 0.
 
 package xxhash
 
@@ -118,6 +125,18 @@ func (d *Digest) Sum(b []byte) []byte {
 	)
 }
 
+// Checksum32 returns the lower 32 bits of the current hash.
+// This is the checksum used by zstd frames and seekable indexes.
+func (d *Digest) Checksum32() uint32 {
+	return uint32(d.Sum64())
+}
+
+// Checksum32 returns the lower 32 bits of the 64-bit xxHash digest of b.
+// This is the checksum used by zstd frames and seekable indexes.
+func Checksum32(b []byte) uint32 {
+	return uint32(Sum64(b))
+}
+
 // Sum64 returns the current hash.
 func (d *Digest) Sum64() uint64 {
 	var h uint64
@@ -0,0 +1,28 @@
+// +build !appengine
+// +build gc
+// +build !purego
+
+package xxhash
+
+import "github.com/klauspost/compress/cpuinfo"
+
+// Sum64 computes the 64-bit xxHash digest of b.
+func Sum64(b []byte) uint64 {
+	if !cpuinfo.AsmXXHash.Enabled() {
+		return sum64Go(b)
+	}
+	return sum64Asm(b)
+}
+
+func writeBlocks(d *Digest, b []byte) int {
+	if !cpuinfo.AsmXXHash.Enabled() {
+		return writeBlocksGo(d, b)
+	}
+	return writeBlocksAsm(d, b)
+}
+
+//go:noescape
+func sum64Asm(b []byte) uint64
+
+//go:noescape
+func writeBlocksAsm(*Digest, []byte) int
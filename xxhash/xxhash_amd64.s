@@ -38,8 +38,8 @@
 	IMULQ R13, acc \
 	ADDQ  R15, acc
 
-// func Sum64(b []byte) uint64
-TEXT ·Sum64(SB), NOSPLIT, $0-32
+// func sum64Asm(b []byte) uint64
+TEXT ·sum64Asm(SB), NOSPLIT, $0-32
 	// Load fixed primes.
 	MOVQ ·prime1v(SB), R13
 	MOVQ ·prime2v(SB), R14
@@ -172,8 +172,8 @@ finalize:
 // writeBlocks uses the same registers as above except that it uses AX to store
 // the d pointer.
 
-// func writeBlocks(d *Digest, b []byte) int
-TEXT ·writeBlocks(SB), NOSPLIT, $0-40
+// func writeBlocksAsm(d *Digest, b []byte) int
+TEXT ·writeBlocksAsm(SB), NOSPLIT, $0-40
 	// Load fixed primes needed for round.
 	MOVQ ·prime1v(SB), R13
 	MOVQ ·prime2v(SB), R14
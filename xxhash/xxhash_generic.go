@@ -1,9 +1,7 @@
-// +build !amd64 appengine !gc purego
-
 package xxhash
 
-// Sum64 computes the 64-bit xxHash digest of b.
-func Sum64(b []byte) uint64 {
+// sum64Go computes the 64-bit xxHash digest of b.
+func sum64Go(b []byte) uint64 {
 	// A simpler version would be
 	//   d := New()
 	//   d.Write(b)
@@ -61,7 +59,7 @@ func Sum64(b []byte) uint64 {
 	return h
 }
 
-func writeBlocks(d *Digest, b []byte) int {
+func writeBlocksGo(d *Digest, b []byte) int {
 	v1, v2, v3, v4 := d.v1, d.v2, d.v3, d.v4
 	n := len(b)
 	for len(b) >= 32 {
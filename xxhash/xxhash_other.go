@@ -0,0 +1,12 @@
+// +build !amd64 appengine !gc purego
+
+package xxhash
+
+// Sum64 computes the 64-bit xxHash digest of b.
+func Sum64(b []byte) uint64 {
+	return sum64Go(b)
+}
+
+func writeBlocks(d *Digest, b []byte) int {
+	return writeBlocksGo(d, b)
+}
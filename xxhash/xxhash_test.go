@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/klauspost/compress/cpuinfo"
 )
 
 func TestAll(t *testing.T) {
@@ -59,6 +61,9 @@ func testDigest(t *testing.T, input string, chunkSize int, want uint64) {
 	if got := ds.Sum64(); got != want {
 		t.Fatalf("Digest.Sum64 (WriteString): got 0x%x; want 0x%x", got, want)
 	}
+	if got := d.Checksum32(); got != uint32(want) {
+		t.Fatalf("Digest.Checksum32: got 0x%x; want 0x%x", got, uint32(want))
+	}
 	var b [8]byte
 	binary.BigEndian.PutUint64(b[:], want)
 	if got := d.Sum(nil); !bytes.Equal(got, b[:]) {
@@ -70,11 +75,38 @@ func testSum(t *testing.T, input string, want uint64) {
 	if got := Sum64([]byte(input)); got != want {
 		t.Fatalf("Sum64: got 0x%x; want 0x%x", got, want)
 	}
+	if got := Checksum32([]byte(input)); got != uint32(want) {
+		t.Fatalf("Checksum32: got 0x%x; want 0x%x", got, uint32(want))
+	}
 	if got := Sum64String(input); got != want {
 		t.Fatalf("Sum64String: got 0x%x; want 0x%x", got, want)
 	}
 }
 
+func TestAsmDisabled(t *testing.T) {
+	defer cpuinfo.EnableAsm(cpuinfo.AsmXXHash)
+	b := make([]byte, 1000)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	for n := 0; n <= len(b); n += 13 {
+		cpuinfo.EnableAsm(cpuinfo.AsmXXHash)
+		want := Sum64(b[:n])
+		d := New()
+		d.Write(b[:n])
+		wantDigest := d.Sum64()
+		cpuinfo.DisableAsm(cpuinfo.AsmXXHash)
+		if got := Sum64(b[:n]); got != want {
+			t.Errorf("Sum64 of %d bytes: got %x, want %x", n, got, want)
+		}
+		d.Reset()
+		d.Write(b[:n])
+		if got := d.Sum64(); got != wantDigest {
+			t.Errorf("Digest of %d bytes: got %x, want %x", n, got, wantDigest)
+		}
+	}
+}
+
 func TestReset(t *testing.T) {
 	parts := []string{"The quic", "k br", "o", "wn fox jumps", " ov", "er the lazy ", "dog."}
 	d := New()
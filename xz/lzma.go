@@ -0,0 +1,370 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package xz
+
+const (
+	numStates          = 12
+	numPosBitsMax      = 4
+	numLenToPosStates  = 4
+	numAlignBits       = 4
+	startPosModelIndex = 4
+	endPosModelIndex   = 14
+	numFullDistances   = 1 << (endPosModelIndex >> 1)
+	matchMinLen        = 2
+	probInit           = 1 << 10
+	probBits           = 11
+	probMoveBits       = 5
+	rcTopValue         = 1 << 24
+	literalCoderSize   = 0x300
+)
+
+// rangeDecoder decodes a single LZMA2 chunk.
+type rangeDecoder struct {
+	b    []byte
+	pos  int
+	rng  uint32
+	code uint32
+	err  bool // set if reading past the end of input
+}
+
+func (rc *rangeDecoder) init(b []byte) bool {
+	if len(b) < 5 || b[0] != 0 {
+		return false
+	}
+	rc.b = b
+	rc.pos = 5
+	rc.rng = 0xFFFFFFFF
+	rc.code = uint32(b[1])<<24 | uint32(b[2])<<16 | uint32(b[3])<<8 | uint32(b[4])
+	rc.err = false
+	return true
+}
+
+func (rc *rangeDecoder) normalize() {
+	if rc.rng < rcTopValue {
+		rc.rng <<= 8
+		if rc.pos >= len(rc.b) {
+			rc.err = true
+			rc.code <<= 8
+			return
+		}
+		rc.code = rc.code<<8 | uint32(rc.b[rc.pos])
+		rc.pos++
+	}
+}
+
+// finished returns whether the decoder has consumed all input
+// and ended in a valid state.
+func (rc *rangeDecoder) finished() bool {
+	return !rc.err && rc.pos == len(rc.b) && rc.code == 0
+}
+
+// bit decodes a single bit using the probability p.
+func (rc *rangeDecoder) bit(p *uint16) uint32 {
+	bound := (rc.rng >> probBits) * uint32(*p)
+	var b uint32
+	if rc.code < bound {
+		rc.rng = bound
+		*p += ((1 << probBits) - *p) >> probMoveBits
+	} else {
+		rc.rng -= bound
+		rc.code -= bound
+		*p -= *p >> probMoveBits
+		b = 1
+	}
+	rc.normalize()
+	return b
+}
+
+// bitTree decodes n bits, most significant bit first.
+func (rc *rangeDecoder) bitTree(probs []uint16, n uint) uint32 {
+	m := uint32(1)
+	for i := uint(0); i < n; i++ {
+		m = m<<1 | rc.bit(&probs[m])
+	}
+	return m - (1 << n)
+}
+
+// bitTreeReverse decodes n bits, least significant bit first.
+func (rc *rangeDecoder) bitTreeReverse(probs []uint16, n uint) uint32 {
+	m := uint32(1)
+	var sym uint32
+	for i := uint(0); i < n; i++ {
+		b := rc.bit(&probs[m])
+		m = m<<1 | b
+		sym |= b << i
+	}
+	return sym
+}
+
+// direct decodes n bits with fixed probabilities.
+func (rc *rangeDecoder) direct(n uint) uint32 {
+	var res uint32
+	for i := uint(0); i < n; i++ {
+		rc.rng >>= 1
+		rc.code -= rc.rng
+		t := 0 - (rc.code >> 31)
+		rc.code += rc.rng & t
+		if rc.code == rc.rng {
+			rc.err = true
+		}
+		res = res<<1 + (t + 1)
+		rc.normalize()
+	}
+	return res
+}
+
+type lenDecoder struct {
+	choice  uint16
+	choice2 uint16
+	low     [1 << numPosBitsMax][1 << 3]uint16
+	mid     [1 << numPosBitsMax][1 << 3]uint16
+	high    [1 << 8]uint16
+}
+
+func (l *lenDecoder) reset() {
+	l.choice = probInit
+	l.choice2 = probInit
+	for i := range l.low {
+		initProbs(l.low[i][:])
+		initProbs(l.mid[i][:])
+	}
+	initProbs(l.high[:])
+}
+
+// decode returns the match length minus matchMinLen.
+func (l *lenDecoder) decode(rc *rangeDecoder, posState uint32) uint32 {
+	if rc.bit(&l.choice) == 0 {
+		return rc.bitTree(l.low[posState][:], 3)
+	}
+	if rc.bit(&l.choice2) == 0 {
+		return 8 + rc.bitTree(l.mid[posState][:], 3)
+	}
+	return 16 + rc.bitTree(l.high[:], 8)
+}
+
+func initProbs(p []uint16) {
+	for i := range p {
+		p[i] = probInit
+	}
+}
+
+// lzmaDecoder contains the LZMA state that is kept between LZMA2 chunks.
+type lzmaDecoder struct {
+	lc, lp, pb uint
+
+	state uint32
+	rep   [4]uint32
+
+	literal    []uint16
+	isMatch    [numStates << numPosBitsMax]uint16
+	isRep      [numStates]uint16
+	isRepG0    [numStates]uint16
+	isRepG1    [numStates]uint16
+	isRepG2    [numStates]uint16
+	isRep0Long [numStates << numPosBitsMax]uint16
+	posSlot    [numLenToPosStates][1 << 6]uint16
+	// posSpecial is offset by one, so it can be indexed with the
+	// position slot base directly.
+	posSpecial [1 + numFullDistances - endPosModelIndex]uint16
+	align      [1 << numAlignBits]uint16
+	lenDec     lenDecoder
+	repLenDec  lenDecoder
+}
+
+// setProps sets lc, lp and pb from an LZMA properties byte.
+// LZMA2 requires lc+lp <= 4.
+func (d *lzmaDecoder) setProps(props byte) bool {
+	if props >= 9*5*5 {
+		return false
+	}
+	d.lc = uint(props % 9)
+	props /= 9
+	d.lp = uint(props % 5)
+	d.pb = uint(props / 5)
+	if d.lc+d.lp > 4 {
+		return false
+	}
+	n := literalCoderSize << (d.lc + d.lp)
+	if cap(d.literal) < n {
+		d.literal = make([]uint16, n)
+	}
+	d.literal = d.literal[:n]
+	return true
+}
+
+// reset resets the state and all probabilities.
+func (d *lzmaDecoder) reset() {
+	d.state = 0
+	d.rep = [4]uint32{}
+	initProbs(d.literal)
+	initProbs(d.isMatch[:])
+	initProbs(d.isRep[:])
+	initProbs(d.isRepG0[:])
+	initProbs(d.isRepG1[:])
+	initProbs(d.isRepG2[:])
+	initProbs(d.isRep0Long[:])
+	for i := range d.posSlot {
+		initProbs(d.posSlot[i][:])
+	}
+	initProbs(d.posSpecial[:])
+	initProbs(d.align[:])
+	d.lenDec.reset()
+	d.repLenDec.reset()
+}
+
+// decodeDistance decodes the distance of a match with the given length.
+// The returned value is the distance minus one.
+func (d *lzmaDecoder) decodeDistance(rc *rangeDecoder, length uint32) uint32 {
+	lenState := length
+	if lenState > numLenToPosStates-1 {
+		lenState = numLenToPosStates - 1
+	}
+	posSlot := rc.bitTree(d.posSlot[lenState][:], 6)
+	if posSlot < startPosModelIndex {
+		return posSlot
+	}
+	numDirectBits := uint(posSlot>>1) - 1
+	dist := (2 | posSlot&1) << numDirectBits
+	if posSlot < endPosModelIndex {
+		return dist + rc.bitTreeReverse(d.posSpecial[dist-posSlot:], numDirectBits)
+	}
+	dist += rc.direct(numDirectBits-numAlignBits) << numAlignBits
+	return dist + rc.bitTreeReverse(d.align[:], numAlignBits)
+}
+
+// decode decodes symbols until dict has reached end bytes.
+// dict must have capacity for end bytes.
+// pos is the number of bytes since the last dictionary reset.
+// All of dict can be referenced by matches.
+func (d *lzmaDecoder) decode(rc *rangeDecoder, dict []byte, end int, pos uint64) ([]byte, error) {
+	b := dict[:end]
+	p := len(dict)
+	pbMask := uint32(1)<<d.pb - 1
+	lpMask := uint32(1)<<d.lp - 1
+	for p < end {
+		if rc.err {
+			return b[:p], ErrCorrupt
+		}
+		posState := uint32(pos) & pbMask
+		if rc.bit(&d.isMatch[d.state<<numPosBitsMax+posState]) == 0 {
+			// Literal
+			var prev byte
+			if p > 0 {
+				prev = b[p-1]
+			}
+			litState := (uint32(pos)&lpMask)<<d.lc + uint32(prev)>>(8-d.lc)
+			probs := d.literal[literalCoderSize*litState : literalCoderSize*(litState+1)]
+			sym := uint32(1)
+			if d.state >= 7 {
+				// Use the byte at the last match distance as context.
+				mp := p - int(d.rep[0]) - 1
+				if mp < 0 {
+					return b[:p], ErrCorrupt
+				}
+				match := uint32(b[mp])
+				for sym < 0x100 {
+					matchBit := (match >> 7) & 1
+					match <<= 1
+					bit := rc.bit(&probs[((1+matchBit)<<8)+sym])
+					sym = sym<<1 | bit
+					if matchBit != bit {
+						break
+					}
+				}
+			}
+			for sym < 0x100 {
+				sym = sym<<1 | rc.bit(&probs[sym])
+			}
+			b[p] = byte(sym)
+			p++
+			pos++
+			switch {
+			case d.state < 4:
+				d.state = 0
+			case d.state < 10:
+				d.state -= 3
+			default:
+				d.state -= 6
+			}
+			continue
+		}
+
+		var length uint32
+		if rc.bit(&d.isRep[d.state]) == 0 {
+			// Simple match
+			d.rep[3], d.rep[2], d.rep[1] = d.rep[2], d.rep[1], d.rep[0]
+			length = d.lenDec.decode(rc, posState)
+			if d.state < 7 {
+				d.state = 7
+			} else {
+				d.state = 10
+			}
+			d.rep[0] = d.decodeDistance(rc, length)
+			if d.rep[0] == 0xFFFFFFFF {
+				// End marker is not allowed in LZMA2.
+				return b[:p], ErrCorrupt
+			}
+		} else {
+			if rc.bit(&d.isRepG0[d.state]) == 0 {
+				if rc.bit(&d.isRep0Long[d.state<<numPosBitsMax+posState]) == 0 {
+					// Short rep: a single byte.
+					if d.state < 7 {
+						d.state = 9
+					} else {
+						d.state = 11
+					}
+					mp := p - int(d.rep[0]) - 1
+					if mp < 0 {
+						return b[:p], ErrCorrupt
+					}
+					b[p] = b[mp]
+					p++
+					pos++
+					continue
+				}
+			} else {
+				var dist uint32
+				if rc.bit(&d.isRepG1[d.state]) == 0 {
+					dist = d.rep[1]
+				} else {
+					if rc.bit(&d.isRepG2[d.state]) == 0 {
+						dist = d.rep[2]
+					} else {
+						dist = d.rep[3]
+						d.rep[3] = d.rep[2]
+					}
+					d.rep[2] = d.rep[1]
+				}
+				d.rep[1] = d.rep[0]
+				d.rep[0] = dist
+			}
+			length = d.repLenDec.decode(rc, posState)
+			if d.state < 7 {
+				d.state = 8
+			} else {
+				d.state = 11
+			}
+		}
+
+		// Copy match.
+		n := int(length) + matchMinLen
+		mp := p - int(d.rep[0]) - 1
+		if mp < 0 || n > end-p {
+			return b[:p], ErrCorrupt
+		}
+		if p-mp >= n {
+			copy(b[p:p+n], b[mp:mp+n])
+		} else {
+			for i := 0; i < n; i++ {
+				b[p+i] = b[mp+i]
+			}
+		}
+		p += n
+		pos += uint64(n)
+	}
+	if rc.err {
+		return b[:p], ErrCorrupt
+	}
+	return b[:p], nil
+}
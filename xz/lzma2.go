@@ -0,0 +1,230 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// maxChunkUnpacked is the maximum uncompressed size of an LZMA2 chunk.
+	maxChunkUnpacked = 1 << 21
+	// maxChunkPacked is the maximum compressed size of an LZMA2 chunk.
+	maxChunkPacked = 1 << 16
+
+	minDictSize = 4 << 10
+)
+
+// decodeDictSize returns the dictionary size from an LZMA2 dictionary size property,
+// as stored in xz headers.
+func decodeDictSize(b byte) (uint32, bool) {
+	if b > 40 {
+		return 0, false
+	}
+	if b == 40 {
+		return 0xFFFFFFFF, true
+	}
+	return (2 | uint32(b)&1) << (b/2 + 11), true
+}
+
+// lzma2Decoder decodes an LZMA2 stream.
+type lzma2Decoder struct {
+	r        byteReader
+	dictSize int
+
+	lzma lzmaDecoder
+	rc   rangeDecoder
+	buf  []byte
+	dict []byte // history and output
+	pos  uint64 // bytes since the last dictionary reset
+	out  []byte // unread output
+
+	needDictReset  bool
+	needProps      bool
+	needStateReset bool
+	eof            bool
+	err            error
+}
+
+// byteReader is the input needed by the decoder.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func newLZMA2Decoder(r byteReader, dictSize int) *lzma2Decoder {
+	d := &lzma2Decoder{}
+	d.reset(r, dictSize)
+	return d
+}
+
+func (d *lzma2Decoder) reset(r byteReader, dictSize int) {
+	if dictSize < minDictSize {
+		dictSize = minDictSize
+	}
+	d.r = r
+	d.dictSize = dictSize
+	d.dict = d.dict[:0]
+	d.pos = 0
+	d.out = nil
+	d.needDictReset = true
+	d.needProps = true
+	d.needStateReset = true
+	d.eof = false
+	d.err = nil
+}
+
+func (d *lzma2Decoder) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.eof {
+			return 0, io.EOF
+		}
+		d.err = d.nextChunk()
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// readFull reads exactly len(b) bytes, where EOF is unexpected.
+func (d *lzma2Decoder) readFull(b []byte) error {
+	_, err := io.ReadFull(d.r, b)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// grow makes room for n more bytes in the dictionary buffer,
+// discarding history that can no longer be referenced.
+func (d *lzma2Decoder) grow(n int) {
+	if keep := d.dictSize; len(d.dict) > 2*keep {
+		d.dict = d.dict[:copy(d.dict, d.dict[len(d.dict)-keep:])]
+	}
+	if cap(d.dict)-len(d.dict) < n {
+		nd := make([]byte, len(d.dict), 2*cap(d.dict)+n)
+		copy(nd, d.dict)
+		d.dict = nd
+	}
+}
+
+// nextChunk decodes the next chunk into d.out.
+func (d *lzma2Decoder) nextChunk() error {
+	control, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if control == 0 {
+		d.eof = true
+		return nil
+	}
+	var hdr [5]byte
+	if control < 0x80 {
+		// Uncompressed chunk
+		if control > 2 {
+			return ErrCorrupt
+		}
+		if control == 1 {
+			d.resetDict()
+		} else if d.needDictReset {
+			return ErrCorrupt
+		}
+		if err := d.readFull(hdr[:2]); err != nil {
+			return err
+		}
+		n := int(hdr[0])<<8 | int(hdr[1]) + 1
+		d.grow(n)
+		start := len(d.dict)
+		d.dict = d.dict[:start+n]
+		if err := d.readFull(d.dict[start:]); err != nil {
+			return err
+		}
+		d.pos += uint64(n)
+		d.out = d.dict[start:]
+		// LZMA state must be reset before the next LZMA chunk.
+		d.needStateReset = true
+		return nil
+	}
+
+	// LZMA chunk
+	reset := (control >> 5) & 3
+	switch {
+	case reset == 3:
+		d.resetDict()
+	case d.needDictReset:
+		return ErrCorrupt
+	}
+	if err := d.readFull(hdr[:4]); err != nil {
+		return err
+	}
+	unpacked := int(control&0x1f)<<16 | int(hdr[0])<<8 | int(hdr[1]) + 1
+	packed := int(hdr[2])<<8 | int(hdr[3]) + 1
+	if reset >= 2 {
+		props, err := d.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if !d.lzma.setProps(props) {
+			return ErrCorrupt
+		}
+		d.needProps = false
+	} else if d.needProps {
+		return ErrCorrupt
+	}
+	if reset >= 1 {
+		d.lzma.reset()
+		d.needStateReset = false
+	} else if d.needStateReset {
+		return ErrCorrupt
+	}
+
+	if cap(d.buf) < packed {
+		d.buf = make([]byte, maxChunkPacked)
+	}
+	d.buf = d.buf[:packed]
+	if err := d.readFull(d.buf); err != nil {
+		return err
+	}
+	if !d.rc.init(d.buf) {
+		return ErrCorrupt
+	}
+	d.grow(unpacked)
+	start := len(d.dict)
+	d.dict, err = d.lzma.decode(&d.rc, d.dict, start+unpacked, d.pos)
+	if err != nil {
+		return err
+	}
+	if !d.rc.finished() {
+		return ErrCorrupt
+	}
+	d.pos += uint64(unpacked)
+	d.out = d.dict[start:]
+	return nil
+}
+
+func (d *lzma2Decoder) resetDict() {
+	d.dict = d.dict[:0]
+	d.pos = 0
+	d.needDictReset = false
+}
+
+// NewLZMA2Reader returns a reader that decompresses a raw LZMA2 stream,
+// as found inside xz blocks.
+// The dictionary size must be at least the size used when compressing.
+// The returned reader reads until the LZMA2 end marker.
+// Data may be read ahead from r.
+func NewLZMA2Reader(r io.Reader, dictSize int) io.Reader {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return newLZMA2Decoder(br, dictSize)
+}
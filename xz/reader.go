@@ -0,0 +1,502 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package xz implements decompression of xz files and raw LZMA2 streams.
+//
+// Only the LZMA2 filter is supported, which is used by default by xz.
+// Files using other filters, like BCJ or delta, will return ErrUnsupported.
+//
+// The format is described at https://tukaani.org/xz/xz-file-format.txt
+package xz
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+var (
+	// ErrCorrupt reports that the input is invalid.
+	ErrCorrupt = errors.New("xz: corrupt input")
+
+	// ErrChecksum reports a checksum mismatch.
+	ErrChecksum = errors.New("xz: checksum mismatch")
+
+	// ErrUnsupported reports that the input uses an unsupported feature.
+	ErrUnsupported = errors.New("xz: unsupported feature")
+
+	// ErrMemoryLimit is returned when the dictionary size exceeds the maximum allowed.
+	ErrMemoryLimit = errors.New("xz: dictionary size exceeds limit")
+)
+
+const (
+	headerMagic = "\xfd7zXZ\x00"
+	footerMagic = "YZ"
+
+	checkNone   = 0x00
+	checkCRC32  = 0x01
+	checkCRC64  = 0x04
+	checkSHA256 = 0x0a
+
+	filterLZMA2 = 0x21
+
+	// DefaultMaxDictSize is the default dictionary size limit.
+	// This allows decoding files compressed with all xz presets.
+	DefaultMaxDictSize = 64 << 20
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// ReaderOption is an option for creating a decoder.
+type ReaderOption func(*Reader) error
+
+// ReaderMaxDictSize sets the maximum dictionary size accepted.
+// The memory used by the decoder is up to about twice the dictionary size.
+// Default is DefaultMaxDictSize.
+func ReaderMaxDictSize(n int) ReaderOption {
+	return func(r *Reader) error {
+		if n < minDictSize {
+			return fmt.Errorf("xz: max dictionary size must be at least %d", minDictSize)
+		}
+		r.maxDict = n
+		return nil
+	}
+}
+
+// ReaderSingleStream will make the reader stop after the first stream.
+// By default concatenated streams are decoded as one.
+func ReaderSingleStream() ReaderOption {
+	return func(r *Reader) error {
+		r.single = true
+		return nil
+	}
+}
+
+// Reader decompresses xz files.
+type Reader struct {
+	r       *countReader
+	maxDict int
+	single  bool
+	err     error
+
+	// Stream state
+	flags  [2]byte
+	check  hash.Hash
+	blocks []indexRecord
+
+	// Block state
+	dec            *lzma2Decoder
+	inBlock        bool
+	blockStart     int64
+	headerSize     int64
+	compressedSize int64
+	unpackedSize   int64
+	unpacked       int64
+}
+
+type indexRecord struct {
+	unpadded, uncompressed int64
+}
+
+// countReader counts the bytes read and optionally hashes them.
+type countReader struct {
+	r *bufio.Reader
+	n int64
+	h hash.Hash32
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.h != nil {
+		c.h.Write(p[:n])
+	}
+	return n, err
+}
+
+func (c *countReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		if c.h != nil {
+			c.h.Write([]byte{b})
+		}
+	}
+	return b, err
+}
+
+// NewReader returns a reader that decompresses the xz stream from r.
+// The stream header is read and validated before returning.
+// Data may be read ahead from r.
+func NewReader(r io.Reader, opts ...ReaderOption) (*Reader, error) {
+	xr := &Reader{maxDict: DefaultMaxDictSize}
+	for _, o := range opts {
+		if err := o(xr); err != nil {
+			return nil, err
+		}
+	}
+	if err := xr.Reset(r); err != nil {
+		return nil, err
+	}
+	return xr, nil
+}
+
+// Reset discards the current state and starts reading a new stream from r.
+// The stream header is read and validated.
+func (x *Reader) Reset(r io.Reader) error {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	x.r = &countReader{r: br}
+	x.inBlock = false
+	x.err = x.readStreamHeader()
+	if x.err == io.EOF {
+		x.err = io.ErrUnexpectedEOF
+	}
+	return x.err
+}
+
+// Read decompresses into p.
+func (x *Reader) Read(p []byte) (int, error) {
+	for x.err == nil {
+		if !x.inBlock {
+			x.err = x.nextBlock()
+			continue
+		}
+		n, err := x.dec.Read(p)
+		if n > 0 {
+			x.unpacked += int64(n)
+			if x.check != nil {
+				x.check.Write(p[:n])
+			}
+			return n, nil
+		}
+		if err == io.EOF {
+			x.err = x.endBlock()
+			continue
+		}
+		if err != nil {
+			x.err = err
+		}
+	}
+	return 0, x.err
+}
+
+func (x *Reader) readFull(b []byte) error {
+	_, err := io.ReadFull(x.r, b)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readVLI reads a variable length integer.
+func readVLI(r io.ByteReader) (int64, error) {
+	var v uint64
+	for i := uint(0); i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			if b == 0 && i > 0 {
+				return 0, ErrCorrupt
+			}
+			if v > 1<<63-1 {
+				return 0, ErrCorrupt
+			}
+			return int64(v), nil
+		}
+	}
+	return 0, ErrCorrupt
+}
+
+func (x *Reader) readStreamHeader() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(x.r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	if !bytes.Equal(hdr[:6], []byte(headerMagic)) {
+		return ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(hdr[6:8]) != binary.LittleEndian.Uint32(hdr[8:]) {
+		return ErrChecksum
+	}
+	if hdr[6] != 0 || hdr[7] > 0x0f {
+		return ErrUnsupported
+	}
+	copy(x.flags[:], hdr[6:8])
+	switch hdr[7] {
+	case checkNone:
+		x.check = nil
+	case checkCRC32:
+		x.check = crc32.NewIEEE()
+	case checkCRC64:
+		x.check = crc64.New(crc64Table)
+	case checkSHA256:
+		x.check = sha256.New()
+	default:
+		return ErrUnsupported
+	}
+	x.blocks = x.blocks[:0]
+	return nil
+}
+
+// checkSize returns the size of the check field.
+func (x *Reader) checkSize() int {
+	if x.check == nil {
+		return 0
+	}
+	return x.check.Size()
+}
+
+// nextBlock reads the next block header.
+// If the index is found, the rest of the stream is read and validated,
+// and the next stream is started, if any.
+func (x *Reader) nextBlock() error {
+	start := x.r.n
+	x.r.h = crc32.NewIEEE()
+	size, err := x.r.ReadByte()
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if size == 0 {
+		if err := x.readIndex(); err != nil {
+			return err
+		}
+		return x.nextStream()
+	}
+	x.r.h = nil
+	hdrSize := (int(size) + 1) * 4
+	hdr := make([]byte, hdrSize-1)
+	if err := x.readFull(hdr); err != nil {
+		return err
+	}
+	want := binary.LittleEndian.Uint32(hdr[len(hdr)-4:])
+	if crc32.Update(crc32.ChecksumIEEE([]byte{size}), crc32.IEEETable, hdr[:len(hdr)-4]) != want {
+		return ErrChecksum
+	}
+	hr := bytes.NewReader(hdr[:len(hdr)-4])
+	flags, _ := hr.ReadByte()
+	if flags&0x3c != 0 {
+		return ErrUnsupported
+	}
+	x.compressedSize, x.unpackedSize = -1, -1
+	if flags&0x40 != 0 {
+		if x.compressedSize, err = readVLI(hr); err != nil || x.compressedSize == 0 {
+			return ErrCorrupt
+		}
+	}
+	if flags&0x80 != 0 {
+		if x.unpackedSize, err = readVLI(hr); err != nil {
+			return ErrCorrupt
+		}
+	}
+	if flags&3 != 0 {
+		// Only a single filter is supported.
+		return ErrUnsupported
+	}
+	id, err := readVLI(hr)
+	if err != nil {
+		return ErrCorrupt
+	}
+	if id != filterLZMA2 {
+		return ErrUnsupported
+	}
+	propSize, err := readVLI(hr)
+	if err != nil || propSize != 1 {
+		return ErrCorrupt
+	}
+	prop, err := hr.ReadByte()
+	if err != nil {
+		return ErrCorrupt
+	}
+	dictSize, ok := decodeDictSize(prop)
+	if !ok {
+		return ErrCorrupt
+	}
+	if int64(dictSize) > int64(x.maxDict) {
+		return ErrMemoryLimit
+	}
+	// Remaining header must be zero padding.
+	for {
+		b, err := hr.ReadByte()
+		if err != nil {
+			break
+		}
+		if b != 0 {
+			return ErrUnsupported
+		}
+	}
+
+	if x.dec == nil {
+		x.dec = newLZMA2Decoder(x.r, int(dictSize))
+	} else {
+		x.dec.reset(x.r, int(dictSize))
+	}
+	x.blockStart = x.r.n
+	x.headerSize = x.r.n - start
+	x.unpacked = 0
+	if x.check != nil {
+		x.check.Reset()
+	}
+	x.inBlock = true
+	return nil
+}
+
+// endBlock validates the end of a block.
+func (x *Reader) endBlock() error {
+	x.inBlock = false
+	compressed := x.r.n - x.blockStart
+	if x.compressedSize >= 0 && compressed != x.compressedSize {
+		return ErrCorrupt
+	}
+	if x.unpackedSize >= 0 && x.unpacked != x.unpackedSize {
+		return ErrCorrupt
+	}
+	// Block padding
+	for i := compressed; i%4 != 0; i++ {
+		b, err := x.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if b != 0 {
+			return ErrCorrupt
+		}
+	}
+	if x.check != nil {
+		var tmp [64]byte
+		got := tmp[:x.check.Size()]
+		if err := x.readFull(got); err != nil {
+			return err
+		}
+		want := x.check.Sum(tmp[len(got):len(got)])
+		if x.flags[1] != checkSHA256 {
+			// CRC32 and CRC64 are stored little endian.
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+		if !bytes.Equal(got, want) {
+			return ErrChecksum
+		}
+	}
+	x.blocks = append(x.blocks, indexRecord{
+		unpadded:     x.headerSize + compressed + int64(x.checkSize()),
+		uncompressed: x.unpacked,
+	})
+	return nil
+}
+
+// readIndex reads the index and stream footer and validates them
+// against the decoded blocks.
+// The index indicator has already been read and hashed.
+func (x *Reader) readIndex() error {
+	start := x.r.n - 1
+	n, err := readVLI(x.r)
+	if err != nil {
+		return err
+	}
+	if n != int64(len(x.blocks)) {
+		return ErrCorrupt
+	}
+	for _, b := range x.blocks {
+		unpadded, err := readVLI(x.r)
+		if err != nil {
+			return err
+		}
+		uncompressed, err := readVLI(x.r)
+		if err != nil {
+			return err
+		}
+		if unpadded != b.unpadded || uncompressed != b.uncompressed {
+			return ErrCorrupt
+		}
+	}
+	for (x.r.n-start)%4 != 0 {
+		b, err := x.r.ReadByte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		if b != 0 {
+			return ErrCorrupt
+		}
+	}
+	sum := x.r.h.Sum32()
+	x.r.h = nil
+	indexSize := x.r.n - start
+	var crc [4]byte
+	if err := x.readFull(crc[:]); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(crc[:]) != sum {
+		return ErrChecksum
+	}
+
+	// Stream footer
+	var footer [12]byte
+	if err := x.readFull(footer[:]); err != nil {
+		return err
+	}
+	if !bytes.Equal(footer[10:], []byte(footerMagic)) {
+		return ErrCorrupt
+	}
+	if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer[:4]) {
+		return ErrChecksum
+	}
+	backward := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+	if backward != indexSize+4 || !bytes.Equal(footer[8:10], x.flags[:]) {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// nextStream skips stream padding and reads the next stream header.
+// io.EOF is returned if there are no more streams.
+func (x *Reader) nextStream() error {
+	if x.single {
+		return io.EOF
+	}
+	// Stream padding is a multiple of 4 zero bytes.
+	var padding int
+	for {
+		b, err := x.r.r.Peek(1)
+		if err == io.EOF {
+			if padding%4 != 0 {
+				return ErrCorrupt
+			}
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != 0 {
+			break
+		}
+		x.r.ReadByte()
+		padding++
+	}
+	if padding%4 != 0 {
+		return ErrCorrupt
+	}
+	err := x.readStreamHeader()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
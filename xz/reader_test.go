@@ -0,0 +1,153 @@
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func readTestFile(t testing.TB, name string) []byte {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestReader(t *testing.T) {
+	sawyer := readTestFile(t, "../testdata/Mark.Twain-Tom.Sawyer.txt")
+	tests := map[string][]byte{
+		"e-blocks.xz":          readTestFile(t, "../testdata/e.txt"),
+		"gettysburg-crc32.xz":  readTestFile(t, "../testdata/gettysburg.txt"),
+		"gettysburg-sha256.xz": readTestFile(t, "../testdata/gettysburg.txt"),
+		"gettysburg-none.xz":   readTestFile(t, "../testdata/gettysburg.txt"),
+		"sharnd.out.xz":        readTestFile(t, "../testdata/sharnd.out"),
+		"repeat-dict64k.xz":    bytes.Repeat(sawyer[:60000], 40),
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			in := readTestFile(t, "testdata/"+name)
+			r, err := NewReader(bytes.NewReader(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("output mismatch, got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestReaderMultiStream(t *testing.T) {
+	a := readTestFile(t, "testdata/gettysburg-crc32.xz")
+	b := readTestFile(t, "testdata/gettysburg-sha256.xz")
+	want := readTestFile(t, "../testdata/gettysburg.txt")
+	var in []byte
+	in = append(in, a...)
+	in = append(in, 0, 0, 0, 0)
+	in = append(in, b...)
+	in = append(in, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	r, err := NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, append(append([]byte{}, want...), want...)) {
+		t.Fatal("output mismatch")
+	}
+
+	r, err = NewReader(bytes.NewReader(in), ReaderSingleStream())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output mismatch")
+	}
+
+	// Bad padding.
+	in = append(append(append([]byte{}, a...), 0, 0), b...)
+	r, err = NewReader(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrCorrupt {
+		t.Errorf("got error %v, want %v", err, ErrCorrupt)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	in := readTestFile(t, "testdata/gettysburg-crc32.xz")
+	decode := func(b []byte, opts ...ReaderOption) error {
+		r, err := NewReader(bytes.NewReader(b), opts...)
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(r)
+		return err
+	}
+	if err := decode(in); err != nil {
+		t.Fatal(err)
+	}
+	if err := decode(in[:5]); err != ErrCorrupt {
+		t.Errorf("short header: got %v", err)
+	}
+	if err := decode(nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("empty: got %v", err)
+	}
+	for i := 12; i < len(in); i += 7 {
+		if err := decode(in[:i]); err == nil {
+			t.Fatalf("truncated at %d: expected error", i)
+		}
+	}
+	// Flip a bit in every position. Everything is covered by a checksum.
+	for i := range in {
+		b := append([]byte{}, in...)
+		b[i] ^= 4
+		if err := decode(b); err == nil {
+			t.Fatalf("corrupted byte %d: expected error", i)
+		}
+	}
+	if err := decode(readTestFile(t, "testdata/gettysburg-x86.xz")); err != ErrUnsupported {
+		t.Errorf("x86 filter: got %v", err)
+	}
+	if err := decode(readTestFile(t, "testdata/repeat-dict64k.xz"), ReaderMaxDictSize(32<<10)); err != ErrMemoryLimit {
+		t.Errorf("memory limit: got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(in), ReaderMaxDictSize(1)); err == nil {
+		t.Error("expected error on invalid option")
+	}
+}
+
+func TestLZMA2Reader(t *testing.T) {
+	want := readTestFile(t, "../testdata/html.txt")
+	in := readTestFile(t, "testdata/html.lzma2")
+	got, err := ioutil.ReadAll(NewLZMA2Reader(bytes.NewReader(in), 1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("output mismatch")
+	}
+	// Corrupt input must not panic.
+	for i := 0; i < len(in); i += 13 {
+		b := append([]byte{}, in...)
+		b[i] ^= 0x55
+		_, _ = ioutil.ReadAll(NewLZMA2Reader(bytes.NewReader(b), 1<<20))
+	}
+	if _, err := ioutil.ReadAll(NewLZMA2Reader(bytes.NewReader(in[:len(in)-1]), 1<<20)); err == nil {
+		t.Error("expected error on missing end marker")
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"unsafe"
 
+	"github.com/klauspost/compress/budget"
 	"github.com/klauspost/compress/huff0"
-	"github.com/klauspost/compress/zstd/internal/xxhash"
+	"github.com/klauspost/compress/xxhash"
 )
 
 type blockType uint8
@@ -53,13 +55,22 @@ const (
 )
 
 var (
-	huffDecoderPool = sync.Pool{New: func() interface{} {
-		return &huff0.Scratch{}
-	}}
-
-	fseDecoderPool = sync.Pool{New: func() interface{} {
-		return &fseDecoder{}
-	}}
+	huffDecoderPool = budget.Pool{
+		Name: "zstd.huffDecoder",
+		New: func() interface{} {
+			return &huff0.Scratch{}
+		},
+		// Includes the decoding table.
+		Size: func(interface{}) int64 { return int64(unsafe.Sizeof(huff0.Scratch{})) + 4<<10 },
+	}
+
+	fseDecoderPool = budget.Pool{
+		Name: "zstd.fseDecoder",
+		New: func() interface{} {
+			return &fseDecoder{}
+		},
+		Size: func(interface{}) int64 { return int64(unsafe.Sizeof(fseDecoder{})) },
+	}
 )
 
 type blockDec struct {
@@ -188,6 +199,16 @@ func (b *blockDec) reset(br byteBuffer, windowSize uint64) error {
 	return nil
 }
 
+// releaseBuffers releases the buffers of the block,
+// which are allocated again when needed.
+func (b *blockDec) releaseBuffers() {
+	b.data = nil
+	b.dataStorage = nil
+	b.dst = nil
+	b.literalBuf = nil
+	b.sequenceBuf = nil
+}
+
 // sendEOF will make the decoder send EOF on this frame.
 func (b *blockDec) sendErr(err error) {
 	b.Last = true
@@ -331,6 +331,27 @@ func (b *blockEnc) encodeRawTo(dst, src []byte) []byte {
 	return dst
 }
 
+// incompressibleLits returns whether entropy coding b is estimated to save less than 1/32 of the size.
+// Small inputs are never considered incompressible.
+func incompressibleLits(b []byte) bool {
+	if len(b) < 1024 {
+		return false
+	}
+	var hist [256]uint32
+	for _, v := range b {
+		hist[v]++
+	}
+	// Shannon entropy of the byte distribution, in bits.
+	total := float64(len(b))
+	entropy := 0.0
+	for _, n := range hist {
+		if n > 0 {
+			entropy -= float64(n) * math.Log2(float64(n)/total)
+		}
+	}
+	return entropy/8 >= total-total/32
+}
+
 // encodeLits can be used if the block is only litLen.
 func (b *blockEnc) encodeLits(lits []byte, raw bool) error {
 	var bh blockHeader
@@ -0,0 +1,177 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// BlockEncoder compresses a sequence of blocks without a frame.
+// Each block can reference the previous blocks within the window size,
+// so blocks must be decoded in the same order by a BlockDecoder
+// with the same window size and dictionary.
+//
+// This is intended for protocols that keep their own framing,
+// where the 3 byte block header is the only overhead per message.
+// There is no checksum or content size, so these must be handled by the protocol.
+//
+// A BlockEncoder cannot be used concurrently.
+type BlockEncoder struct {
+	o   encoderOptions
+	enc encoder
+}
+
+// NewBlockEncoder returns a new block encoder.
+// Only options affecting the compression are used, meaning the level,
+// window size, dictionary and entropy options.
+func NewBlockEncoder(opts ...EOption) (*BlockEncoder, error) {
+	initPredefined()
+	var e BlockEncoder
+	e.o.setDefault()
+	for _, o := range opts {
+		if err := o(&e.o); err != nil {
+			return nil, err
+		}
+	}
+	if e.o.patchFrom && !e.o.customWindow {
+		if ws := patchFromWindow(len(e.o.dict.content)); ws > e.o.windowSize {
+			e.o.windowSize = ws
+		}
+	}
+	e.enc = e.o.encoder()
+	e.enc.Reset(e.o.dict, false)
+	return &e, nil
+}
+
+// WindowSize returns the window size of the encoder.
+// BlockDecoders must use the same window size.
+func (e *BlockEncoder) WindowSize() int {
+	return e.o.windowSize
+}
+
+// MaxBlockSize returns the maximum number of bytes that can be encoded in a block.
+func (e *BlockEncoder) MaxBlockSize() int {
+	return maxBlockSizeFor(e.o.windowSize)
+}
+
+// EncodeBlock compresses src as a single block and appends it to dst.
+// src can be at most MaxBlockSize bytes.
+// Blocks that cannot be compressed are stored uncompressed.
+func (e *BlockEncoder) EncodeBlock(dst, src []byte) ([]byte, error) {
+	if max := e.MaxBlockSize(); len(src) > max {
+		return dst, fmt.Errorf("block size %d exceeds maximum %d", len(src), max)
+	}
+	blk := e.enc.Block()
+	if len(src) == 0 {
+		return blk.encodeRawTo(dst, src), nil
+	}
+	blk.pushOffsets()
+	e.enc.Encode(blk, src)
+	err := blk.encode(src, e.o.noEntropy, !e.o.allLitEntropy)
+	switch err {
+	case errIncompressible:
+		dst = blk.encodeRawTo(dst, src)
+		blk.popOffsets()
+	case nil:
+		dst = append(dst, blk.output...)
+	default:
+		return dst, err
+	}
+	blk.reset(nil)
+	return dst, nil
+}
+
+// Reset starts a new sequence of blocks, which will not reference any previous blocks.
+// The matching BlockDecoder must also be reset.
+func (e *BlockEncoder) Reset() {
+	e.enc.Reset(e.o.dict, false)
+}
+
+// BlockDecoder decompresses blocks created by a BlockEncoder.
+//
+// A BlockDecoder cannot be used concurrently.
+type BlockDecoder struct {
+	o          decoderOptions
+	dec        *blockDec
+	hist       history
+	dict       *dict
+	windowSize int
+}
+
+// NewBlockDecoder returns a decoder for blocks created with the given window size.
+// If a dictionary is registered with WithDecoderDicts or WithDecoderDictRaw
+// it is used for all blocks. Only a single dictionary can be registered.
+func NewBlockDecoder(windowSize int, opts ...DOption) (*BlockDecoder, error) {
+	initPredefined()
+	if windowSize < MinWindowSize || windowSize > MaxWindowSize {
+		return nil, fmt.Errorf("window size must be between %d and %d", MinWindowSize, MaxWindowSize)
+	}
+	var d BlockDecoder
+	d.o.setDefault()
+	for _, o := range opts {
+		if err := o(&d.o); err != nil {
+			return nil, err
+		}
+	}
+	switch len(d.o.dicts) {
+	case 0:
+	case 1:
+		d.dict = &d.o.dicts[0]
+	default:
+		return nil, errors.New("block decoders support a single dictionary")
+	}
+	d.windowSize = windowSize
+	d.dec = &blockDec{lowMem: d.o.lowMem}
+	d.Reset()
+	return &d, nil
+}
+
+// DecodeBlock decompresses the single block in src and appends the output to dst.
+// If an error is returned, the decoder must be reset before decoding more blocks.
+func (d *BlockDecoder) DecodeBlock(dst, src []byte) ([]byte, error) {
+	// Keep the window and room for the output.
+	h := d.hist.b
+	if len(h) > 2*d.windowSize {
+		n := copy(h, h[len(h)-d.windowSize:])
+		d.hist.b = h[:n]
+	}
+	start := len(d.hist.b)
+
+	in := byteBuf(src)
+	if err := d.dec.reset(&in, uint64(d.windowSize)); err != nil {
+		return dst, err
+	}
+	if len(in) > 0 {
+		return dst, fmt.Errorf("%d bytes after block", len(in))
+	}
+	err := d.dec.decodeBuf(&d.hist)
+	if err == nil && len(d.hist.b)-start > maxBlockSizeFor(d.windowSize) {
+		err = ErrWindowSizeExceeded
+	}
+	if err != nil {
+		d.hist.b = d.hist.b[:start]
+		return dst, err
+	}
+	return append(dst, d.hist.b[start:]...), nil
+}
+
+// Reset starts a new sequence of blocks.
+func (d *BlockDecoder) Reset() {
+	d.hist.reset()
+	d.hist.windowSize = d.windowSize
+	if d.dict != nil {
+		// The history may modify the dictionary.
+		dict := *d.dict
+		d.hist.setDict(&dict)
+	}
+}
+
+// maxBlockSizeFor returns the maximum block size for a window size.
+func maxBlockSizeFor(windowSize int) int {
+	if windowSize < maxCompressedBlockSize {
+		return windowSize
+	}
+	return maxCompressedBlockSize
+}
@@ -0,0 +1,155 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestBlockEncoder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 10000)
+	rng.Read(random)
+	var msgs [][]byte
+	for i := 0; i < 50; i++ {
+		msgs = append(msgs, []byte(fmt.Sprintf(`{"id":%d,"name":"message %d","tags":["a","b","c"],"value":%d}`, i, i, i*i)))
+	}
+	msgs = append(msgs, nil, random, testSeekableData(100<<10), msgs[10])
+	dict := bytes.Repeat([]byte(`{"id":0,"name":"message ","tags":["a","b","c"]}`), 10)
+
+	for level := SpeedFastest; level <= SpeedBestCompression; level++ {
+		for _, withDict := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s-dict-%t", level, withDict), func(t *testing.T) {
+				eopts := []EOption{WithEncoderLevel(level)}
+				var dopts []DOption
+				if withDict {
+					eopts = append(eopts, WithEncoderDictRaw(0, dict))
+					dopts = append(dopts, WithDecoderDictRaw(0, dict))
+				}
+				enc, err := NewBlockEncoder(eopts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				dec, err := NewBlockDecoder(enc.WindowSize(), dopts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for reset := 0; reset < 2; reset++ {
+					var total, compressed int
+					for i, msg := range msgs {
+						block, err := enc.EncodeBlock(nil, msg)
+						if err != nil {
+							t.Fatal(err)
+						}
+						total += len(msg)
+						compressed += len(block)
+						got, err := dec.DecodeBlock([]byte("prefix"), block)
+						if err != nil {
+							t.Fatalf("block %d: %v", i, err)
+						}
+						if !bytes.Equal(got[:6], []byte("prefix")) || !bytes.Equal(got[6:], msg) {
+							t.Fatalf("block %d: output mismatch", i)
+						}
+					}
+					if compressed >= total-len(random) {
+						t.Errorf("no compression: %d -> %d", total, compressed)
+					}
+					enc.Reset()
+					dec.Reset()
+				}
+			})
+		}
+	}
+}
+
+func TestBlockEncoderHistory(t *testing.T) {
+	enc, err := NewBlockEncoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(msg)
+	first, _ := enc.EncodeBlock(nil, msg)
+	second, _ := enc.EncodeBlock(nil, msg)
+	if len(second) > 100 {
+		t.Errorf("repeated block is %d bytes, want it to reference the previous block", len(second))
+	}
+
+	// The second block cannot be decoded without the first.
+	dec, err := NewBlockDecoder(enc.WindowSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlock(nil, second); err == nil {
+		t.Error("expected error without history")
+	}
+	dec.Reset()
+	for _, b := range [][]byte{first, second} {
+		got, err := dec.DecodeBlock(nil, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, msg) {
+			t.Fatal("output mismatch")
+		}
+	}
+}
+
+func TestBlockEncoderLimits(t *testing.T) {
+	enc, err := NewBlockEncoder(WithWindowSize(64 << 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc.MaxBlockSize() != 64<<10 {
+		t.Fatalf("got max block size %d", enc.MaxBlockSize())
+	}
+	if _, err := enc.EncodeBlock(nil, make([]byte, 64<<10+1)); err == nil {
+		t.Error("expected error on too big block")
+	}
+
+	// Stream through more than the window.
+	dec, err := NewBlockDecoder(64 << 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := testSeekableData(1 << 20)
+	for b := in; len(b) > 0; b = b[10000:] {
+		block, err := enc.EncodeBlock(nil, b[:10000])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dec.DecodeBlock(nil, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, b[:10000]) {
+			t.Fatal("output mismatch")
+		}
+		if len(b) < 20000 {
+			break
+		}
+	}
+
+	block, _ := enc.EncodeBlock(nil, in[:1000])
+	if _, err := dec.DecodeBlock(nil, append(block, 0)); err == nil {
+		t.Error("expected error on trailing data")
+	}
+	if _, err := dec.DecodeBlock(nil, block[:len(block)-1]); err == nil {
+		t.Error("expected error on truncated block")
+	}
+	// An RLE block bigger than the block size.
+	if _, err := dec.DecodeBlock(nil, []byte{0x03 | 1<<3, 0x00, 0x10, 'a'}); err != ErrWindowSizeExceeded {
+		t.Errorf("got %v, want %v", err, ErrWindowSizeExceeded)
+	}
+
+	if _, err := NewBlockDecoder(100); err == nil {
+		t.Error("expected error on small window")
+	}
+	if _, err := NewBlockDecoder(1<<20, WithDecoderDictRaw(1, testSeekableData(100)), WithDecoderDictRaw(2, testSeekableData(100))); err == nil {
+		t.Error("expected error with several dictionaries")
+	}
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	decompress = flag.Bool("d", false, "Decompress files")
+	test       = flag.Bool("t", false, "Test integrity of compressed files. No output will be written")
+	list       = flag.Bool("list", false, "List information about compressed files")
+	level      = flag.Int("level", 3, "Compression level 1-22. Levels are mapped to the closest supported encoder speed")
+	long       = flag.Bool("long", false, "Use a 128MB window when compressing, to find matches at longer distances")
+	dictFile   = flag.String("D", "", "Use this dictionary file for compression and decompression")
+	recursive  = flag.Bool("r", false, "Operate recursively on directories")
+	cpu        = flag.Int("T", runtime.GOMAXPROCS(0), "Compress/decompress using this amount of threads")
+	checksum   = flag.Bool("check", true, "Add content checksum when compressing")
+	safe       = flag.Bool("safe", false, "Do not overwrite output files")
+	stdout     = flag.Bool("c", false, "Write all output to stdout. Multiple input files will be concatenated")
+	remove     = flag.Bool("rm", false, "Delete source file(s) after successful compression or decompression")
+	quiet      = flag.Bool("q", false, "Don't write any output to terminal, except errors")
+	help       = flag.Bool("help", false, "Display help")
+
+	version = "(dev)"
+	date    = "(unknown)"
+)
+
+const (
+	ext       = ".zst"
+	longWin   = 128 << 20
+	frameHdr  = 0xFD2FB528
+	skipMagic = 0x184D2A50
+)
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	modes := 0
+	for _, b := range []bool{*decompress, *test, *list} {
+		if b {
+			modes++
+		}
+	}
+	if len(args) == 0 || *help || modes > 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "zstd compress/decompress v%v, built at %v.\n\n", version, date)
+		_, _ = fmt.Fprintf(os.Stderr, "Copyright (c) 2021 Klaus Post. All rights reserved.\n\n")
+		_, _ = fmt.Fprintln(os.Stderr, `Usage: kzstd [options] file1 file2
+
+Compresses all files supplied as input separately.
+Output files are written as 'filename.ext.zst'.
+Use -d to decompress files ending with '.zst' or '.zstd'. Output file names have the extension removed.
+Use -t to test compressed files and -list to show information about them.
+By default output files will be overwritten.
+Use - as the only file name to read from stdin and write to stdout.
+
+Wildcards are accepted: testdir/*.txt will compress all files in testdir ending with .txt
+Directories can be wildcards as well. testdir/*/*.txt will match testdir/subdir/b.txt
+Use -r to include all files in directories.
+
+Options:`)
+		flag.PrintDefaults()
+		os.Exit(0)
+	}
+
+	var dict []byte
+	if *dictFile != "" {
+		var err error
+		dict, err = ioutil.ReadFile(*dictFile)
+		exitErr(err)
+	}
+
+	if len(args) == 1 && args[0] == "-" {
+		// Catch interrupt, so we don't exit at once.
+		// os.Stdin will return EOF, so we should be able to get everything.
+		signal.Notify(make(chan os.Signal, 1), os.Interrupt)
+		switch {
+		case *list:
+			info, err := listFrames(os.Stdin)
+			exitErr(err)
+			printList([]listInfo{info}, []string{"(stdin)"})
+		case *decompress, *test:
+			dec := newDecoder(dict)
+			defer dec.Close()
+			exitErr(dec.Reset(os.Stdin))
+			out := io.Writer(os.Stdout)
+			if *test {
+				out = ioutil.Discard
+			}
+			_, err := dec.WriteTo(out)
+			exitErr(err)
+		default:
+			enc := newEncoder(dict)
+			enc.Reset(os.Stdout)
+			_, err := enc.ReadFrom(os.Stdin)
+			exitErr(err)
+			exitErr(enc.Close())
+		}
+		return
+	}
+
+	files := findFiles(args)
+	*quiet = *quiet || *stdout
+	switch {
+	case *list:
+		infos := make([]listInfo, 0, len(files))
+		for _, filename := range files {
+			f, err := os.Open(filename)
+			exitErr(err)
+			info, err := listFrames(f)
+			f.Close()
+			if err != nil {
+				exitErr(fmt.Errorf("%s: %w", filename, err))
+			}
+			infos = append(infos, info)
+		}
+		printList(infos, files)
+	case *decompress, *test:
+		dec := newDecoder(dict)
+		defer dec.Close()
+		for _, filename := range files {
+			decompressFile(dec, filename)
+		}
+	default:
+		enc := newEncoder(dict)
+		for _, filename := range files {
+			compressFile(enc, filename)
+		}
+	}
+}
+
+func newEncoder(dict []byte) *zstd.Encoder {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(*level)),
+		zstd.WithEncoderConcurrency(*cpu),
+		zstd.WithEncoderCRC(*checksum),
+	}
+	if *long {
+		opts = append(opts, zstd.WithWindowSize(longWin))
+	}
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	enc, err := zstd.NewWriter(nil, opts...)
+	exitErr(err)
+	return enc
+}
+
+func newDecoder(dict []byte) *zstd.Decoder {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(*cpu)}
+	if dict != nil {
+		opts = append(opts, zstd.WithDecoderDicts(dict))
+	}
+	dec, err := zstd.NewReader(nil, opts...)
+	exitErr(err)
+	return dec
+}
+
+// findFiles expands the patterns to file names.
+// Directories are walked if recursive is set, otherwise they are skipped.
+func findFiles(patterns []string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		found, err := filepath.Glob(pattern)
+		exitErr(err)
+		if len(found) == 0 {
+			exitErr(fmt.Errorf("unable to find file %v", pattern))
+		}
+		for _, name := range found {
+			st, err := os.Stat(name)
+			exitErr(err)
+			if !st.IsDir() {
+				files = append(files, name)
+				continue
+			}
+			if !*recursive {
+				if !*quiet {
+					fmt.Println("Skipping directory", name)
+				}
+				continue
+			}
+			err = filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+			exitErr(err)
+		}
+	}
+	return files
+}
+
+func compressFile(enc *zstd.Encoder, filename string) {
+	if strings.HasSuffix(filename, ext) && !*stdout {
+		if !*quiet {
+			fmt.Println("Skipping", filename, "already has", ext, "extension")
+		}
+		return
+	}
+	var closeOnce sync.Once
+	dstFilename := filename + ext
+	if !*quiet {
+		fmt.Print("Compressing ", filename, " -> ", dstFilename)
+	}
+	file, err := os.Open(filename)
+	exitErr(err)
+	defer closeOnce.Do(func() { file.Close() })
+	st, err := file.Stat()
+	exitErr(err)
+	out, done := createOutput(dstFilename, st.Mode())
+	wc := wCounter{out: out}
+	enc.Reset(&wc)
+	start := time.Now()
+	input, err := enc.ReadFrom(bufio.NewReaderSize(file, 1<<20))
+	exitErr(err)
+	exitErr(enc.Close())
+	exitErr(done())
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbpersec := (float64(input) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(wc.n) * 100 / float64(input)
+		fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", input, wc.n, pct, mbpersec)
+	}
+	if *remove {
+		closeOnce.Do(func() {
+			file.Close()
+			removeFile(filename)
+		})
+	}
+}
+
+func decompressFile(dec *zstd.Decoder, filename string) {
+	var dstFilename string
+	switch {
+	case strings.HasSuffix(filename, ext):
+		dstFilename = strings.TrimSuffix(filename, ext)
+	case strings.HasSuffix(filename, ".zstd"):
+		dstFilename = strings.TrimSuffix(filename, ".zstd")
+	default:
+		if !*quiet {
+			fmt.Println("Skipping", filename)
+		}
+		return
+	}
+	if *test {
+		dstFilename = "(test)"
+	}
+	var closeOnce sync.Once
+	if !*quiet {
+		fmt.Print("Decompressing ", filename, " -> ", dstFilename)
+	}
+	file, err := os.Open(filename)
+	exitErr(err)
+	defer closeOnce.Do(func() { file.Close() })
+	st, err := file.Stat()
+	exitErr(err)
+	var out io.Writer = ioutil.Discard
+	done := func() error { return nil }
+	if !*test {
+		out, done = createOutput(dstFilename, st.Mode())
+	}
+	rc := rCounter{in: file}
+	exitErr(dec.Reset(bufio.NewReaderSize(&rc, 1<<20)))
+	start := time.Now()
+	output, err := dec.WriteTo(out)
+	exitErr(err)
+	exitErr(done())
+	if !*quiet {
+		elapsed := time.Since(start)
+		mbPerSec := (float64(output) / (1024 * 1024)) / (float64(elapsed) / (float64(time.Second)))
+		pct := float64(output) * 100 / float64(rc.n)
+		fmt.Printf(" %d -> %d [%.02f%%]; %.01fMB/s\n", rc.n, output, pct, mbPerSec)
+	}
+	if *remove && !*test {
+		closeOnce.Do(func() {
+			file.Close()
+			removeFile(filename)
+		})
+	}
+}
+
+// createOutput returns the writer for output and a function that must be
+// called when all output has been written.
+func createOutput(name string, mode os.FileMode) (io.Writer, func() error) {
+	if *stdout {
+		return os.Stdout, func() error { return nil }
+	}
+	if *safe {
+		_, err := os.Stat(name)
+		if !os.IsNotExist(err) {
+			exitErr(errors.New("destination file exists"))
+		}
+	}
+	dstFile, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	exitErr(err)
+	bw := bufio.NewWriterSize(dstFile, 1<<20)
+	return bw, func() error {
+		err := bw.Flush()
+		if err2 := dstFile.Close(); err == nil {
+			err = err2
+		}
+		return err
+	}
+}
+
+func removeFile(name string) {
+	if !*quiet {
+		fmt.Println("Removing", name)
+	}
+	exitErr(os.Remove(name))
+}
+
+// listInfo contains information about the frames of a stream.
+type listInfo struct {
+	frames, skips int
+
+	compressed, uncompressed int64
+
+	// sizeKnown is set if all frames have the content size in the header.
+	sizeKnown bool
+
+	// checksum is set if all frames have a content checksum.
+	checksum bool
+}
+
+// listFrames reads frame and block headers to collect information about a stream.
+// Block content is skipped, so the content is not validated.
+func listFrames(r io.Reader) (listInfo, error) {
+	info := listInfo{sizeKnown: true, checksum: true}
+	br := bufio.NewReader(r)
+	var tmp [8]byte
+	skip := func(n int64) error {
+		if _, err := io.CopyN(ioutil.Discard, br, n); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		info.compressed += n
+		return nil
+	}
+	read := func(b []byte) error {
+		if _, err := io.ReadFull(br, b); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		info.compressed += int64(len(b))
+		return nil
+	}
+	for {
+		n, err := io.ReadFull(br, tmp[:4])
+		if err == io.EOF && n == 0 {
+			break
+		}
+		if err != nil {
+			return info, io.ErrUnexpectedEOF
+		}
+		info.compressed += 4
+		magic := binary.LittleEndian.Uint32(tmp[:4])
+		if magic&0xfffffff0 == skipMagic {
+			if err := read(tmp[:4]); err != nil {
+				return info, err
+			}
+			if err := skip(int64(binary.LittleEndian.Uint32(tmp[:4]))); err != nil {
+				return info, err
+			}
+			info.skips++
+			continue
+		}
+		if magic != frameHdr {
+			return info, errors.New("not a zstd stream")
+		}
+		info.frames++
+		if err := read(tmp[:1]); err != nil {
+			return info, err
+		}
+		fhd := tmp[0]
+		single := fhd&(1<<5) != 0
+		if fhd&4 == 0 {
+			info.checksum = false
+		}
+		if !single {
+			// Window descriptor.
+			if err := read(tmp[:1]); err != nil {
+				return info, err
+			}
+		}
+		if err := skip(int64([4]int{0, 1, 2, 4}[fhd&3])); err != nil {
+			return info, err
+		}
+		fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+		if fcsSize == 0 && single {
+			fcsSize = 1
+		}
+		if fcsSize == 0 {
+			info.sizeKnown = false
+		} else {
+			for i := range tmp {
+				tmp[i] = 0
+			}
+			if err := read(tmp[:fcsSize]); err != nil {
+				return info, err
+			}
+			fcs := int64(binary.LittleEndian.Uint64(tmp[:]))
+			if fcsSize == 2 {
+				fcs += 256
+			}
+			info.uncompressed += fcs
+		}
+		for {
+			if err := read(tmp[:3]); err != nil {
+				return info, err
+			}
+			bh := uint32(tmp[0]) | uint32(tmp[1])<<8 | uint32(tmp[2])<<16
+			size := int64(bh >> 3)
+			switch (bh >> 1) & 3 {
+			case 1:
+				// RLE blocks store a single byte.
+				size = 1
+			case 3:
+				return info, errors.New("reserved block type")
+			}
+			if err := skip(size); err != nil {
+				return info, err
+			}
+			if bh&1 != 0 {
+				break
+			}
+		}
+		if fhd&4 != 0 {
+			if err := skip(4); err != nil {
+				return info, err
+			}
+		}
+	}
+	if info.frames == 0 {
+		info.checksum = false
+	}
+	return info, nil
+}
+
+func printList(infos []listInfo, names []string) {
+	fmt.Printf("%6s %6s %12s %14s %7s %6s  %s\n", "Frames", "Skips", "Compressed", "Uncompressed", "Ratio", "Check", "Filename")
+	for i, info := range infos {
+		uncomp, ratio := "", ""
+		if info.sizeKnown {
+			uncomp = humanSize(info.uncompressed)
+			if info.compressed > 0 {
+				ratio = fmt.Sprintf("%.3f", float64(info.uncompressed)/float64(info.compressed))
+			}
+		}
+		check := "None"
+		if info.checksum {
+			check = "XXH64"
+		}
+		fmt.Printf("%6d %6d %12s %14s %7s %6s  %s\n", info.frames, info.skips, humanSize(info.compressed), uncomp, ratio, check, names[i])
+	}
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func exitErr(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nERROR:", err.Error())
+		os.Exit(2)
+	}
+}
+
+type wCounter struct {
+	n   int
+	out io.Writer
+}
+
+func (w *wCounter) Write(p []byte) (n int, err error) {
+	n, err = w.out.Write(p)
+	w.n += n
+	return n, err
+}
+
+type rCounter struct {
+	n  int
+	in io.Reader
+}
+
+func (w *rCounter) Read(p []byte) (n int, err error) {
+	n, err = w.in.Read(p)
+	w.n += n
+	return n, err
+}
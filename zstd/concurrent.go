@@ -0,0 +1,146 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// splitFrames returns the frames of input that are not skippable.
+// If input cannot be split, nil is returned.
+func splitFrames(input []byte) [][]byte {
+	var frames [][]byte
+	fs := Frames(bytes.NewReader(input))
+	for fs.Next() {
+		f := fs.Frame()
+		if !f.Skippable {
+			frames = append(frames, input[f.Offset:f.Offset+f.CompressedSize])
+		}
+	}
+	if fs.Err() != nil {
+		// Let the serial decoder report the error.
+		return nil
+	}
+	return frames
+}
+
+// errSharedLimit is returned when a frame decoded concurrently
+// exceeds the output allowance shared with the other frames.
+var errSharedLimit = errors.New("shared output limit exceeded")
+
+// sharedLimit is the output allowance shared by frames decoded concurrently.
+// Frames take from it as they are decoded, so the combined output
+// cannot exceed the allowance by more than a block per frame.
+type sharedLimit struct {
+	left int64 // atomic
+}
+
+func newSharedLimit(n uint64) *sharedLimit {
+	if n > math.MaxInt64 {
+		n = math.MaxInt64
+	}
+	return &sharedLimit{left: int64(n)}
+}
+
+// take n bytes from the allowance and return whether they were available.
+func (s *sharedLimit) take(n uint64) bool {
+	if n > math.MaxInt64 {
+		atomic.StoreInt64(&s.left, -1)
+		return false
+	}
+	return atomic.AddInt64(&s.left, -int64(n)) >= 0
+}
+
+// exhausted returns whether nothing is left of the allowance.
+func (s *sharedLimit) exhausted() bool {
+	return atomic.LoadInt64(&s.left) <= 0
+}
+
+// decodeFramesConcurrent decodes frames concurrently and appends the output to dst in order.
+// frames must be the frames of input.
+// If a frame fails to decode, the output of the frames before it is returned with the error.
+// If sizes is not nil, the content size of each frame is appended to it.
+// If prefix is not nil, it is the history of the first frame.
+//
+// The output limits are shared by the frames while they are decoded.
+// If the combined output exceeds them, input is decoded serially instead,
+// so the output and error returned are the same as without concurrency.
+func (d *Decoder) decodeFramesConcurrent(ctx context.Context, input []byte, frames [][]byte, dst []byte, sizes *[]contentSize, prefix *dict) ([]byte, error) {
+	type result struct {
+		b     []byte
+		err   error
+		sizes []contentSize
+	}
+	results := make([]result, len(frames))
+	next := make(chan int, len(frames))
+	for i := range frames {
+		next <- i
+	}
+	close(next)
+
+	allowance := d.o.maxOutputSize
+	if uint64(len(dst)) >= d.o.maxDecodedSize {
+		allowance = 0
+	} else if left := d.o.maxDecodedSize - uint64(len(dst)); left < allowance {
+		allowance = left
+	}
+	limit := newSharedLimit(allowance)
+
+	workers := d.o.concurrent
+	if workers > len(frames) {
+		workers = len(frames)
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				r := &results[i]
+				var p *dict
+				if i == 0 {
+					p = prefix
+				}
+				if sizes != nil {
+					r.b, r.err = d.decodeAll(ctx, frames[i], nil, &r.sizes, limit, p)
+				} else {
+					r.b, r.err = d.decodeAll(ctx, frames[i], nil, nil, limit, p)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := uint64(len(dst))
+	for _, r := range results {
+		if r.err == errSharedLimit {
+			return d.decodeAll(ctx, input, dst, sizes, nil, prefix)
+		}
+		total += uint64(len(r.b))
+	}
+	if total > uint64(cap(dst)) {
+		dst2 := make([]byte, len(dst), total)
+		copy(dst2, dst)
+		dst = dst2
+	}
+	start := len(dst)
+	for _, r := range results {
+		if sizes != nil {
+			for _, s := range r.sizes {
+				s.start += len(dst) - start
+				*sizes = append(*sizes, s)
+			}
+		}
+		dst = append(dst, r.b...)
+		if r.err != nil {
+			return dst, r.err
+		}
+	}
+	return dst, nil
+}
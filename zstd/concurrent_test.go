@@ -0,0 +1,125 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func TestDecodeAllConcurrentFrames(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	var input, stream []byte
+	for i := 0; i < 20; i++ {
+		b := testSeekableData(10<<10 + i*1000)
+		input = append(input, b...)
+		stream = enc.EncodeAll(b, stream)
+		if i == 5 {
+			// Skippable frames are ignored.
+			stream = append(stream, 0x50, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 1, 2)
+		}
+	}
+
+	dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecodeAllConcurrentFrames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	prefix := []byte("prefix")
+	got, err := dec.DecodeAll(stream, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[:len(prefix)], prefix) || !bytes.Equal(got[len(prefix):], input) {
+		t.Fatal("output mismatch")
+	}
+
+	// A corrupt frame returns the output of the frames before it.
+	second := len(enc.EncodeAll(testSeekableData(10<<10), nil))
+	corrupt := append([]byte{}, stream...)
+	corrupt[second+20] ^= 0xff
+	got, err = dec.DecodeAll(corrupt, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(got) < 10<<10 || !bytes.Equal(got[:10<<10], input[:10<<10]) {
+		t.Fatalf("got %d bytes before the error", len(got))
+	}
+
+	// Truncated input falls back to serial decoding.
+	if _, err := dec.DecodeAll(stream[:len(stream)-10], nil); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The size limit applies to the combined output.
+	dec2, err := NewReader(nil, WithDecoderConcurrency(4), WithDecodeAllConcurrentFrames(true), WithDecoderMaxMemory(100<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	if _, err := dec2.DecodeAll(stream, nil); err != ErrDecoderSizeExceeded {
+		t.Fatalf("got error %v, want %v", err, ErrDecoderSizeExceeded)
+	}
+}
+
+func TestDecodeAllConcurrentFramesLimit(t *testing.T) {
+	const frameSize = 64 << 10
+	const limit = 256 << 10
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	// Frames encoded by streams have no content size.
+	var stream []byte
+	var noSize bytes.Buffer
+	for i := 0; i < 100; i++ {
+		stream = enc.EncodeAll(testSeekableData(frameSize), stream)
+		enc.Reset(&noSize)
+		enc.Write(testSeekableData(frameSize))
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, opt := range []DOption{WithDecoderMaxMemory(limit), WithDecoderMaxDecompressedSize(limit)} {
+		serial, err := NewReader(nil, WithDecoderConcurrency(1), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer serial.Close()
+		dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecodeAllConcurrentFrames(true), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		for i, in := range [][]byte{stream, noSize.Bytes()} {
+			t.Run(fmt.Sprint(i), func(t *testing.T) {
+				want, wantErr := serial.DecodeAll(in, []byte("dst"))
+				if wantErr == nil {
+					t.Fatal("expected error")
+				}
+				var before, after runtime.MemStats
+				runtime.ReadMemStats(&before)
+				got, err := dec.DecodeAll(in, []byte("dst"))
+				runtime.ReadMemStats(&after)
+				if err != wantErr || !bytes.Equal(got, want) {
+					t.Fatalf("got %d bytes, error %v, want %d bytes, error %v", len(got), err, len(want), wantErr)
+				}
+				// The frames are not all decoded before the limit is checked.
+				if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 100*frameSize/2 {
+					t.Errorf("allocated %d bytes with a limit of %d", alloc, limit)
+				}
+			})
+		}
+	}
+}
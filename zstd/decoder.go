@@ -5,6 +5,7 @@
 package zstd
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync"
@@ -30,7 +31,8 @@ type Decoder struct {
 
 	// Custom dictionaries.
 	// Always uses copies.
-	dicts map[uint32]dict
+	dicts   map[uint32]dict
+	dictsMu sync.RWMutex
 
 	// streamWg is the waitgroup for all streams
 	streamWg sync.WaitGroup
@@ -48,13 +50,35 @@ type decoderState struct {
 	// cancel remaining output.
 	cancel chan struct{}
 
+	// ctx stops reading the stream when done.
+	ctx context.Context
+
 	flushed bool
+
+	// decoded is the number of bytes output by the stream.
+	decoded uint64
+
+	// frame is the content size of the frame being read.
+	frame contentSize
+	// frames are the frames starting in the output of a sync decode.
+	frames []contentSize
+	// outLen is the length of the current output before it was read.
+	outLen int
+}
+
+// contentSize is the content size stated by a frame.
+type contentSize struct {
+	// start is the offset of the frame in the output of a sync decode.
+	start int
+	size  uint64
+	known bool
 }
 
 var (
 	// Check the interfaces we want to support.
 	_ = io.WriterTo(&Decoder{})
 	_ = io.Reader(&Decoder{})
+	_ = io.ByteReader(&Decoder{})
 )
 
 // NewReader creates a new decoder.
@@ -81,8 +105,12 @@ func NewReader(r io.Reader, opts ...DOption) (*Decoder, error) {
 			return nil, err
 		}
 	}
+	if d.o.lazyBuffers {
+		d.o.lowMem = true
+	}
 	d.current.output = make(chan decodeOutput, d.o.concurrent)
 	d.current.flushed = true
+	d.current.ctx = context.Background()
 
 	if r == nil {
 		d.current.err = ErrDecoderNilInput
@@ -113,6 +141,12 @@ func NewReader(r io.Reader, opts ...DOption) (*Decoder, error) {
 // Returns the number of bytes written and any error that occurred.
 // When the stream is done, io.EOF will be returned.
 func (d *Decoder) Read(p []byte) (int, error) {
+	if len(p) < len(d.current.b) {
+		// Fast path for reads within the current block.
+		n := copy(p, d.current.b)
+		d.current.b = d.current.b[n:]
+		return n, nil
+	}
 	var n int
 	for {
 		if len(d.current.b) > 0 {
@@ -150,17 +184,86 @@ func (d *Decoder) Read(p []byte) (int, error) {
 	return n, d.current.err
 }
 
+// ReadByte reads a single byte from the decompressed stream.
+// When the stream is done, io.EOF will be returned.
+// This allows the decoder to be used by parsers that read single bytes,
+// like encoding/binary.ReadUvarint and encoding/gob, without a bufio.Reader.
+func (d *Decoder) ReadByte() (byte, error) {
+	for len(d.current.b) == 0 {
+		if d.current.err != nil {
+			d.drainOutput()
+			return 0, d.current.err
+		}
+		d.nextBlock(true)
+	}
+	c := d.current.b[0]
+	d.current.b = d.current.b[1:]
+	return c, nil
+}
+
+// FrameContentSize returns the content size stated in the header of the frame
+// that the next byte read from the stream belongs to.
+// known is false if the frame does not state its size.
+// This can be used to allocate a buffer for the frame before reading it.
+// The decoder may need to decode the first block of the frame to find it,
+// but no output is consumed.
+// When the stream is done, io.EOF will be returned.
+func (d *Decoder) FrameContentSize() (size uint64, known bool, err error) {
+	for len(d.current.b) == 0 {
+		if d.current.err != nil {
+			d.drainOutput()
+			return 0, false, d.current.err
+		}
+		d.nextBlock(true)
+	}
+	pos := d.current.outLen - len(d.current.b)
+	for len(d.current.frames) > 0 && d.current.frames[0].start <= pos {
+		d.current.frame = d.current.frames[0]
+		d.current.frames = d.current.frames[1:]
+	}
+	return d.current.frame.size, d.current.frame.known, nil
+}
+
 // Reset will reset the decoder the supplied stream after the current has finished processing.
 // Note that this functionality cannot be used after Close has been called.
 // Reset can be called with a nil reader to release references to the previous reader.
 // After being called with a nil reader, no other operations than Reset or DecodeAll or Close
 // should be used.
 func (d *Decoder) Reset(r io.Reader) error {
+	return d.ResetContext(context.Background(), r)
+}
+
+// ResetContext will reset the decoder to the supplied stream like Reset.
+// When ctx is done, reads from the stream return the error of ctx
+// and decoding of the remaining blocks is cancelled.
+// A read from r that is in progress is not interrupted.
+// Streams that are decoded synchronously, like small bytes.Buffers,
+// are decoded before ResetContext returns and stop when ctx is done.
+func (d *Decoder) ResetContext(ctx context.Context, r io.Reader) error {
+	return d.reset(ctx, r, d.o.prefix)
+}
+
+// ResetWithPrefix will reset the decoder to the supplied stream like Reset,
+// and use prefix as the history of the first frame of the stream
+// instead of the prefix set by WithDecoderPrefix.
+// It must be the prefix the frame was encoded with.
+// If prefix is empty, no prefix is used.
+// prefix must not be modified until the stream has been read or the decoder is reset.
+func (d *Decoder) ResetWithPrefix(r io.Reader, prefix []byte) error {
+	return d.reset(context.Background(), r, loadPrefix(prefix))
+}
+
+// reset starts decoding r, with prefix as the history of the first frame.
+func (d *Decoder) reset(ctx context.Context, r io.Reader, prefix *dict) error {
 	if d.current.err == ErrDecoderClosed {
 		return d.current.err
 	}
 
 	d.drainOutput()
+	d.current.ctx = ctx
+	d.current.decoded = 0
+	d.current.frame = contentSize{}
+	d.current.frames = d.current.frames[:0]
 
 	if r == nil {
 		d.current.err = ErrDecoderNilInput
@@ -183,11 +286,12 @@ func (d *Decoder) Reset(r io.Reader) error {
 			dst = d.current.b
 		}
 
-		dst, err := d.DecodeAll(b, dst[:0])
+		dst, err := d.decodeAllFrames(ctx, b, dst[:0], &d.current.frames, prefix)
 		if err == nil {
 			err = io.EOF
 		}
 		d.current.b = dst
+		d.current.outLen = len(dst)
 		d.current.err = err
 		d.current.flushed = true
 		if debugDecoder {
@@ -196,7 +300,11 @@ func (d *Decoder) Reset(r io.Reader) error {
 		return nil
 	}
 
-	if d.stream == nil {
+	var index *seekIndex
+	if d.o.seekIndex && d.o.concurrent > 1 {
+		index = readSeekIndex(r)
+	}
+	if d.stream == nil && index == nil {
 		d.stream = make(chan decodeStream, 1)
 		d.streamWg.Add(1)
 		go d.startStreamDecoder(d.stream)
@@ -209,11 +317,18 @@ func (d *Decoder) Reset(r io.Reader) error {
 	d.current.flushed = false
 	d.current.d = nil
 
-	d.stream <- decodeStream{
+	stream := decodeStream{
 		r:      r,
 		output: d.current.output,
 		cancel: d.current.cancel,
+		prefix: prefix,
+	}
+	if index != nil {
+		d.streamWg.Add(1)
+		go d.startSeekIndexDecoder(index, stream)
+		return nil
 	}
+	d.stream <- stream
 	return nil
 }
 
@@ -234,6 +349,7 @@ func (d *Decoder) drainOutput() {
 	}
 	if d.current.output == nil || d.current.flushed {
 		println("current already flushed")
+		d.releaseBuffers()
 		return
 	}
 	for v := range d.current.output {
@@ -246,6 +362,27 @@ func (d *Decoder) drainOutput() {
 		if v.err == errEndOfStream {
 			println("current flushed")
 			d.current.flushed = true
+			d.releaseBuffers()
+			return
+		}
+	}
+}
+
+// releaseBuffers releases the stream output and the buffers of idle block decoders
+// if WithDecoderLazyBuffers is set.
+func (d *Decoder) releaseBuffers() {
+	if !d.o.lazyBuffers {
+		return
+	}
+	d.current.b = nil
+	d.current.frames = nil
+	// Block decoders used by DecodeAll are released when they are returned.
+	for n := len(d.decoders); n > 0; n-- {
+		select {
+		case dec := <-d.decoders:
+			dec.releaseBuffers()
+			d.decoders <- dec
+		default:
 			return
 		}
 	}
@@ -260,8 +397,15 @@ func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
 		if len(d.current.b) > 0 {
 			n2, err2 := w.Write(d.current.b)
 			n += int64(n2)
-			if err2 != nil && d.current.err == nil {
-				d.current.err = err2
+			if err2 == nil && n2 != len(d.current.b) {
+				err2 = io.ErrShortWrite
+			}
+			d.current.b = d.current.b[n2:]
+			if err2 != nil {
+				// Decoding errors take precedence.
+				if d.current.err == nil || d.current.err == io.EOF {
+					d.current.err = err2
+				}
 				break
 			}
 		}
@@ -286,10 +430,53 @@ func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
 // DecodeAll can be used concurrently.
 // The Decoder concurrency limits will be respected.
 func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
+	return d.decodeAllFrames(context.Background(), input, dst, nil, d.o.prefix)
+}
+
+// DecodeAllWithPrefix decodes input like DecodeAll,
+// and uses prefix as the history of the first frame of input
+// instead of the prefix set by WithDecoderPrefix.
+// It must be the prefix the frame was encoded with.
+// If prefix is empty, no prefix is used.
+func (d *Decoder) DecodeAllWithPrefix(input, dst, prefix []byte) ([]byte, error) {
+	return d.decodeAllFrames(context.Background(), input, dst, nil, loadPrefix(prefix))
+}
+
+// DecodeAllContext decodes input like DecodeAll.
+// When ctx is done, decoding stops before the next block
+// and the error of ctx is returned with the output decoded so far.
+// Waiting for a DecoderBudget is also stopped.
+func (d *Decoder) DecodeAllContext(ctx context.Context, input, dst []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return dst, err
+	}
+	return d.decodeAllFrames(ctx, input, dst, nil, d.o.prefix)
+}
+
+// decodeAllFrames decodes like DecodeAll.
+// If sizes is not nil, the content size of each frame is appended to it,
+// with the start of the frame relative to the length of dst.
+// If prefix is not nil, it is the history of the first frame.
+func (d *Decoder) decodeAllFrames(ctx context.Context, input, dst []byte, sizes *[]contentSize, prefix *dict) ([]byte, error) {
 	if d.current.err == ErrDecoderClosed {
 		return dst, ErrDecoderClosed
 	}
+	if d.o.concurrentFrames && d.o.concurrent > 1 {
+		if frames := splitFrames(input); len(frames) > 1 {
+			return d.decodeFramesConcurrent(ctx, input, frames, dst, sizes, prefix)
+		}
+	}
+	return d.decodeAll(ctx, input, dst, sizes, nil, prefix)
+}
 
+// decodeAll decodes all frames of input serially and appends the output to dst.
+// If sizes is not nil, the content size of each frame is appended to it.
+// If limit is not nil, the output is taken from it as it is decoded,
+// and errSharedLimit is returned if it is exhausted.
+// If prefix is not nil, it is the history of the first frame.
+// Decoding stops with the error of ctx when it is done.
+func (d *Decoder) decodeAll(ctx context.Context, input, dst []byte, sizes *[]contentSize, limit *sharedLimit, prefix *dict) ([]byte, error) {
+	start := len(dst)
 	// Grab a block decoder and frame decoder.
 	block := <-d.decoders
 	frame := block.localFrame
@@ -302,6 +489,8 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 		d.decoders <- block
 	}()
 	frame.bBuf = input
+	// The output limit only counts what is appended to dst.
+	maxLen := uint64(len(dst)) + d.o.maxOutputSize
 
 	for {
 		frame.history.reset()
@@ -312,19 +501,42 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 			}
 			return dst, nil
 		}
-		if frame.DictionaryID != nil {
-			dict, ok := d.dicts[*frame.DictionaryID]
-			if !ok {
-				return nil, ErrUnknownDictionary
-			}
-			frame.history.setDict(&dict)
-		}
 		if err != nil {
 			return dst, err
 		}
+		if err = d.setDict(frame, prefix); err != nil {
+			return nil, err
+		}
+		prefix = nil
 		if frame.FrameContentSize > d.o.maxDecodedSize-uint64(len(dst)) {
 			return dst, ErrDecoderSizeExceeded
 		}
+		if frame.FrameContentSize > maxLen-uint64(len(dst)) {
+			return dst, ErrDecompressedSizeExceeded
+		}
+		if sizes != nil {
+			*sizes = append(*sizes, contentSize{start: len(dst) - start, size: frame.FrameContentSize, known: frame.HasFCS})
+		}
+		// Take the content size before allocating it.
+		var reserved uint64
+		if limit != nil {
+			if limit.exhausted() {
+				return dst, errSharedLimit
+			}
+			if frame.HasFCS {
+				if !limit.take(frame.FrameContentSize) {
+					return dst, errSharedLimit
+				}
+				reserved = frame.FrameContentSize
+			}
+		}
+		mem := frameMemory(frame, true)
+		if err := d.o.memBudget.acquire(mem, ctx.Done()); err != nil {
+			if err == io.EOF {
+				err = ctx.Err()
+			}
+			return dst, err
+		}
 		if frame.FrameContentSize > 0 && frame.FrameContentSize < 1<<30 {
 			// Never preallocate moe than 1 GB up front.
 			if cap(dst)-len(dst) < int(frame.FrameContentSize) {
@@ -347,7 +559,8 @@ func (d *Decoder) DecodeAll(input, dst []byte) ([]byte, error) {
 			dst = make([]byte, 0, size)
 		}
 
-		dst, err = frame.runDecoder(dst, block)
+		dst, err = frame.runDecoder(ctx, dst, block, maxLen, limit, reserved, &mem)
+		d.o.memBudget.release(mem)
 		if err != nil {
 			return dst, err
 		}
@@ -380,14 +593,30 @@ func (d *Decoder) nextBlock(blocking bool) (ok bool) {
 	}
 
 	if blocking {
-		d.current.decodeOutput = <-d.current.output
+		select {
+		case d.current.decodeOutput = <-d.current.output:
+		case <-d.current.ctx.Done():
+			d.current.decodeOutput = decodeOutput{err: d.current.ctx.Err()}
+		}
 	} else {
 		select {
 		case d.current.decodeOutput = <-d.current.output:
+		case <-d.current.ctx.Done():
+			d.current.decodeOutput = decodeOutput{err: d.current.ctx.Err()}
 		default:
 			return false
 		}
 	}
+	if d.current.newFrame {
+		d.current.frame = d.current.decodeOutput.frame
+	}
+	d.current.outLen = len(d.current.b)
+	d.current.decoded += uint64(len(d.current.b))
+	if over := d.current.decoded - d.o.maxOutputSize; d.current.decoded > d.o.maxOutputSize {
+		// Return the output up to the limit.
+		d.current.b = d.current.b[:uint64(len(d.current.b))-over]
+		d.current.err = ErrDecompressedSizeExceeded
+	}
 	if debugDecoder {
 		println("got", len(d.current.b), "bytes, error:", d.current.err)
 	}
@@ -423,7 +652,7 @@ func (d *Decoder) Close() {
 // IOReadCloser returns the decoder as an io.ReadCloser for convenience.
 // Any changes to the decoder will be reflected, so the returned ReadCloser
 // can be reused along with the decoder.
-// io.WriterTo is also supported by the returned ReadCloser.
+// io.WriterTo and io.ByteReader are also supported by the returned ReadCloser.
 func (d *Decoder) IOReadCloser() io.ReadCloser {
 	return closeWrapper{d: d}
 }
@@ -443,6 +672,11 @@ func (c closeWrapper) Read(p []byte) (n int, err error) {
 	return c.d.Read(p)
 }
 
+// ReadByte forwards ReadByte calls to the decoder.
+func (c closeWrapper) ReadByte() (byte, error) {
+	return c.d.ReadByte()
+}
+
 // Close closes the decoder.
 func (c closeWrapper) Close() error {
 	c.d.Close()
@@ -453,6 +687,11 @@ type decodeOutput struct {
 	d   *blockDec
 	b   []byte
 	err error
+
+	// newFrame is set on the first output of a frame,
+	// and frame is the content size stated by it.
+	newFrame bool
+	frame    contentSize
 }
 
 type decodeStream struct {
@@ -463,6 +702,9 @@ type decodeStream struct {
 
 	// cancel reading from the input
 	cancel chan struct{}
+
+	// prefix is the history of the first frame, if not nil.
+	prefix *dict
 }
 
 // errEndOfStream indicates that everything from the stream was read.
@@ -486,6 +728,9 @@ func (d *Decoder) startStreamDecoder(inStream chan decodeStream) {
 			println("got new stream")
 		}
 		br := readerWrapper{r: stream.r}
+		prefix := stream.prefix
+		// mem is the memory reserved from the budget by the current frame.
+		var mem int64
 	decodeStream:
 		for {
 			frame.history.reset()
@@ -493,12 +738,14 @@ func (d *Decoder) startStreamDecoder(inStream chan decodeStream) {
 			if debugDecoder && err != nil {
 				println("Frame decoder returned", err)
 			}
-			if err == nil && frame.DictionaryID != nil {
-				dict, ok := d.dicts[*frame.DictionaryID]
-				if !ok {
-					err = ErrUnknownDictionary
-				} else {
-					frame.history.setDict(&dict)
+			if err == nil {
+				err = d.setDict(frame, prefix)
+				prefix = nil
+			}
+			if err == nil {
+				mem = frameMemory(frame, false)
+				if err = d.o.memBudget.acquire(mem, stream.cancel); err != nil {
+					mem = 0
 				}
 			}
 			if err != nil {
@@ -546,9 +793,88 @@ func (d *Decoder) startStreamDecoder(inStream chan decodeStream) {
 			println("waiting for done")
 			frame.frameDone.Wait()
 			println("done waiting...")
+			d.o.memBudget.release(mem)
+			mem = 0
 		}
 		frame.frameDone.Wait()
+		d.o.memBudget.release(mem)
+		if d.o.lazyBuffers {
+			frame.history.b = nil
+		}
 		println("Sending EOS")
 		stream.output <- decodeOutput{err: errEndOfStream}
 	}
 }
+
+// setDict sets the dictionary of frame.
+// If prefix is not nil, it replaces the dictionary.
+// Frames without a dictionary ID use the default dictionary, if one is selected.
+func (d *Decoder) setDict(frame *frameDec, prefix *dict) error {
+	if prefix != nil {
+		frame.history.setDict(prefix)
+		return nil
+	}
+	var id uint32
+	switch {
+	case frame.DictionaryID != nil:
+		id = *frame.DictionaryID
+	case d.o.defaultDict != nil:
+		id = *d.o.defaultDict
+	default:
+		return nil
+	}
+	d.dictsMu.RLock()
+	dict, ok := d.dicts[id]
+	d.dictsMu.RUnlock()
+	if !ok {
+		return ErrUnknownDictionary
+	}
+	frame.history.setDict(&dict)
+	return nil
+}
+
+// RegisterDict adds a dictionary in the zstd dictionary format to the decoder,
+// replacing any dictionary with the same ID.
+// Dictionaries can be added while the decoder is in use.
+// Frames that have already started decoding keep using the dictionary they started with.
+func (d *Decoder) RegisterDict(dict []byte) error {
+	dc, err := loadDict(dict)
+	if err != nil {
+		return err
+	}
+	d.addDict(dc)
+	return nil
+}
+
+// RegisterDictRaw adds content as a raw dictionary with the given ID,
+// replacing any dictionary with the same ID.
+// Frames that do not specify a dictionary only use it if the ID
+// is selected with WithDecoderDefaultDict.
+// Dictionaries can be added while the decoder is in use.
+func (d *Decoder) RegisterDictRaw(id uint32, content []byte) error {
+	dc, err := loadRawDict(id, content)
+	if err != nil {
+		return err
+	}
+	d.addDict(dc)
+	return nil
+}
+
+// RemoveDict removes the dictionary with the given ID from the decoder.
+// Frames that have already started decoding keep using the dictionary.
+// Frames that need the dictionary afterwards will fail with ErrUnknownDictionary.
+func (d *Decoder) RemoveDict(id uint32) {
+	d.dictsMu.Lock()
+	delete(d.dicts, id)
+	d.dictsMu.Unlock()
+}
+
+// addDict adds or replaces a dictionary.
+func (d *Decoder) addDict(dc *dict) {
+	d.dictsMu.Lock()
+	if d.dicts == nil {
+		d.dicts = make(map[uint32]dict)
+	}
+	d.dicts[dc.id] = *dc
+	d.dictsMu.Unlock()
+}
@@ -0,0 +1,131 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"io"
+	"sync"
+)
+
+// DecoderBudget is a limit on the memory used by the decoders that share it.
+// Use WithDecoderBudget to make decoders draw from a budget.
+//
+// Each frame reserves the memory needed for its history from the budget when it starts decoding,
+// which is the window size of the frame plus up to 2MB, and releases it when the frame is done.
+// DecodeAll reserves the content size of frames instead, if it is larger,
+// since the output is used as history.
+// Frames without a content size reserve more as their DecodeAll output grows past the reservation.
+// That memory is never waited for, since the frame already holds memory,
+// so ErrDecoderBudgetExceeded is returned if it is not available.
+// Output that has been decoded, but not read from a stream, is not counted.
+//
+// This is separate from the budget package, which limits the memory retained by buffer pools.
+// A DecoderBudget is safe for concurrent use.
+type DecoderBudget struct {
+	limit int64
+	wait  bool
+
+	mu   sync.Mutex
+	used int64
+	// released is closed when memory is released, if there are waiters.
+	released chan struct{}
+}
+
+// NewDecoderBudget returns a budget of limit bytes.
+// If wait is true, frames wait for other frames to release memory when the budget is used up,
+// otherwise decoding fails with ErrDecoderBudgetExceeded.
+// Frames that need more than the limit always fail.
+func NewDecoderBudget(limit int64, wait bool) *DecoderBudget {
+	return &DecoderBudget{limit: limit, wait: wait}
+}
+
+// Limit returns the limit of the budget.
+func (b *DecoderBudget) Limit() int64 {
+	return b.limit
+}
+
+// Used returns the memory currently reserved by frames being decoded.
+func (b *DecoderBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// acquire reserves n bytes from the budget.
+// If the budget waits, it blocks until the memory is available or cancel is closed,
+// in which case io.EOF is returned.
+// A nil budget has no limit.
+func (b *DecoderBudget) acquire(n int64, cancel <-chan struct{}) error {
+	if b == nil {
+		return nil
+	}
+	if n > b.limit {
+		return ErrDecoderBudgetExceeded
+	}
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		if !b.wait {
+			b.mu.Unlock()
+			return ErrDecoderBudgetExceeded
+		}
+		if b.released == nil {
+			b.released = make(chan struct{})
+		}
+		released := b.released
+		b.mu.Unlock()
+		select {
+		case <-released:
+		case <-cancel:
+			return io.EOF
+		}
+	}
+}
+
+// grow reserves n more bytes from the budget for a frame that already holds memory.
+// It doesn't wait, since frames waiting for each other while holding memory could deadlock.
+// A nil budget has no limit.
+func (b *DecoderBudget) grow(n int64) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit {
+		return ErrDecoderBudgetExceeded
+	}
+	b.used += n
+	return nil
+}
+
+// release returns n bytes to the budget.
+func (b *DecoderBudget) release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	if b.released != nil {
+		close(b.released)
+		b.released = nil
+	}
+	b.mu.Unlock()
+}
+
+// frameMemory returns the memory reserved from the budget to decode frame.
+// If output is set, the output of the frame is used as history.
+func frameMemory(frame *frameDec, output bool) int64 {
+	n := int64(frame.history.maxSize)
+	if output && frame.FrameContentSize > uint64(n) {
+		if frame.FrameContentSize > 1<<62 {
+			return 1 << 62
+		}
+		n = int64(frame.FrameContentSize)
+	}
+	return n
+}
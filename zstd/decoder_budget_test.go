@@ -0,0 +1,206 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// testBudgetStream returns input and a stream of it with a window of 4MB,
+// which reserves 4MB + maxBlockSize.
+func testBudgetStream(t *testing.T) (input, stream []byte) {
+	t.Helper()
+	input = testSeekableData(1 << 20)
+	enc, err := NewWriter(nil, WithWindowSize(4<<20), WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return input, buf.Bytes()
+}
+
+func TestDecoderBudget(t *testing.T) {
+	input, stream := testBudgetStream(t)
+	const frameMem = 4<<20 + maxBlockSize
+
+	small := NewDecoderBudget(frameMem-1, true)
+	dec, err := NewReader(nil, WithDecoderBudget(small))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if _, err := dec.DecodeAll(stream, nil); err != ErrDecoderBudgetExceeded {
+		t.Fatalf("got DecodeAll error %v", err)
+	}
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(dec); err != ErrDecoderBudgetExceeded {
+		t.Fatalf("got stream error %v", err)
+	}
+
+	b := NewDecoderBudget(frameMem, false)
+	dec1, err := NewReader(nil, WithDecoderBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec1.Close()
+	dec2, err := NewReader(nil, WithDecoderBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	got, err := dec2.DecodeAll(stream, nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	// The frame of the first stream holds the budget until it has been read.
+	if err := dec1.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(dec1, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != frameMem {
+		t.Fatalf("got %d used, want %d", b.Used(), frameMem)
+	}
+	if _, err := dec2.DecodeAll(stream, nil); err != ErrDecoderBudgetExceeded {
+		t.Fatalf("got DecodeAll error %v", err)
+	}
+	if _, err := ioutil.ReadAll(dec1); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != 0 {
+		t.Fatalf("got %d used after decoding", b.Used())
+	}
+}
+
+func TestDecoderBudgetWait(t *testing.T) {
+	input, stream := testBudgetStream(t)
+	b := NewDecoderBudget(4<<20+maxBlockSize, true)
+	dec1, err := NewReader(ioutil.NopCloser(bytes.NewReader(stream)), WithDecoderBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec1.Close()
+	dec2, err := NewReader(nil, WithDecoderBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	if _, err := io.ReadFull(dec1, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		got, err := dec2.DecodeAll(stream, nil)
+		if err == nil && !bytes.Equal(got, input) {
+			err = ErrCRCMismatch
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("DecodeAll did not wait for the budget, error %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := ioutil.ReadAll(dec1); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A stream waiting for the budget is stopped by Reset.
+	if err := dec1.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(dec1, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec2.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := dec2.Reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec1.Reset(nil); err != nil {
+		t.Fatal(err)
+	}
+	if b.Used() != 0 {
+		t.Fatalf("got %d used after reset", b.Used())
+	}
+}
+
+func TestDecoderBudgetNoContentSize(t *testing.T) {
+	// A frame without a content size with a small window.
+	input := testSeekableData(4 << 20)
+	enc, err := NewWriter(nil, WithWindowSize(256<<10), WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	// The output of DecodeAll is charged as it grows, and the budget is not waited for.
+	for _, wait := range []bool{false, true} {
+		b := NewDecoderBudget(1<<20, wait)
+		dec, err := NewReader(nil, WithDecoderBudget(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		got, err := dec.DecodeAll(stream, nil)
+		if err != ErrDecoderBudgetExceeded {
+			t.Fatalf("got error %v", err)
+		}
+		// The block that exceeded the budget is returned.
+		if len(got) > 1<<20+maxBlockSize {
+			t.Fatalf("got %d bytes with a budget of %d", len(got), 1<<20)
+		}
+		if b.Used() != 0 {
+			t.Fatalf("got %d used after decoding", b.Used())
+		}
+	}
+
+	b := NewDecoderBudget(int64(len(input)), false)
+	dec, err := NewReader(nil, WithDecoderBudget(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := dec.DecodeAll(stream, nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	if b.Used() != 0 {
+		t.Fatalf("got %d used after decoding", b.Used())
+	}
+	// Streams only keep the window.
+	if err := dec.Reset(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(dec); err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+}
@@ -17,7 +17,18 @@ type decoderOptions struct {
 	lowMem         bool
 	concurrent     int
 	maxDecodedSize uint64
+	maxWindowSize  uint64
+	maxOutputSize  uint64
 	dicts          []dict
+	prefix         *dict
+	defaultDict    *uint32
+
+	concurrentFrames bool
+	seekIndex        bool
+	checksumFn       func(FrameChecksum)
+	checkContentSize bool
+	lazyBuffers      bool
+	memBudget        *DecoderBudget
 }
 
 func (o *decoderOptions) setDefault() {
@@ -27,6 +38,8 @@ func (o *decoderOptions) setDefault() {
 		concurrent: runtime.GOMAXPROCS(0),
 	}
 	o.maxDecodedSize = 1 << 63
+	o.maxWindowSize = MaxWindowSize
+	o.maxOutputSize = 1 << 63
 }
 
 // WithDecoderLowmem will set whether to use a lower amount of memory,
@@ -35,6 +48,30 @@ func WithDecoderLowmem(b bool) DOption {
 	return func(o *decoderOptions) error { o.lowMem = b; return nil }
 }
 
+// WithDecoderLazyBuffers will allocate stream buffers as they are needed
+// and release them when a stream has been read to the end or is reset.
+// The history of a frame starts small and grows with the decoded output
+// up to the window size of the frame, instead of being allocated for the full window up front.
+// This reduces the memory of decoders that are mostly idle, or that decode streams
+// that are small compared to their window size, at the cost of more allocations.
+// Servers holding many decoders should also consider WithDecoderConcurrency(1).
+// This implies WithDecoderLowmem(true).
+// Default is false.
+func WithDecoderLazyBuffers(b bool) DOption {
+	return func(o *decoderOptions) error { o.lazyBuffers = b; return nil }
+}
+
+// WithDecoderBudget makes the decoder reserve the memory of the frames it decodes from b,
+// which can be shared by many decoders to limit their total memory use.
+// See DecoderBudget for the memory that is counted.
+// When the budget is used up, decoding fails with ErrDecoderBudgetExceeded
+// or waits for memory to be released, depending on the budget.
+// A waiting stream is stopped by Reset and Close.
+// Default is no budget.
+func WithDecoderBudget(b *DecoderBudget) DOption {
+	return func(o *decoderOptions) error { o.memBudget = b; return nil }
+}
+
 // WithDecoderConcurrency will set the concurrency,
 // meaning the maximum number of decoders to run concurrently.
 // The value supplied must be at least 1.
@@ -52,7 +89,7 @@ func WithDecoderConcurrency(n int) DOption {
 // WithDecoderMaxMemory allows to set a maximum decoded size for in-memory
 // non-streaming operations or maximum window size for streaming operations.
 // This can be used to control memory usage of potentially hostile content.
-// For streaming operations, the maximum window size is capped at 1<<30 bytes.
+// For streaming operations, the maximum window size is also capped by WithDecoderMaxWindow.
 // Maximum and default is 1 << 63 bytes.
 func WithDecoderMaxMemory(n uint64) DOption {
 	return func(o *decoderOptions) error {
@@ -67,6 +104,45 @@ func WithDecoderMaxMemory(n uint64) DOption {
 	}
 }
 
+// WithDecoderMaxDecompressedSize limits the output of each DecodeAll and DecodeReaderAt call
+// and each stream to n bytes, which protects against decompression bombs.
+// Output up to the limit is returned with ErrDecompressedSizeExceeded.
+// Frames that state a larger content size are rejected before they are decoded.
+// Unlike WithDecoderMaxMemory, the limit also applies to streams
+// and only counts the output appended to dst by DecodeAll.
+// Maximum and default is 1 << 63 bytes.
+func WithDecoderMaxDecompressedSize(n uint64) DOption {
+	return func(o *decoderOptions) error {
+		if n == 0 {
+			return errors.New("WithDecoderMaxDecompressedSize must be at least 1")
+		}
+		if n > 1<<63 {
+			return errors.New("WithDecoderMaxDecompressedSize must be at most 1 << 63")
+		}
+		o.maxOutputSize = n
+		return nil
+	}
+}
+
+// WithDecoderMaxWindow sets the maximum window size of frames.
+// Frames with a larger window are rejected with ErrWindowSizeExceeded.
+// Streams keep up to the window size of decoded output in memory,
+// so this can be used to limit memory usage of long window streams.
+// The value must be between MinWindowSize and MaxWindowSize.
+// The default is MaxWindowSize.
+func WithDecoderMaxWindow(size uint64) DOption {
+	return func(o *decoderOptions) error {
+		if size < MinWindowSize {
+			return errors.New("WithDecoderMaxWindow must be at least MinWindowSize")
+		}
+		if size > MaxWindowSize {
+			return errors.New("WithDecoderMaxWindow must be at most MaxWindowSize")
+		}
+		o.maxWindowSize = size
+		return nil
+	}
+}
+
 // WithDecoderDicts allows to register one or more dictionaries for the decoder.
 // If several dictionaries with the same ID is provided the last one will be used.
 func WithDecoderDicts(dicts ...[]byte) DOption {
@@ -81,3 +157,102 @@ func WithDecoderDicts(dicts ...[]byte) DOption {
 		return nil
 	}
 }
+
+// WithDecoderDictRaw registers content as a raw dictionary with the given ID.
+// Frames that do not specify a dictionary only use it if the ID
+// is selected with WithDecoderDefaultDict.
+// If several dictionaries with the same ID is provided the last one will be used.
+func WithDecoderDictRaw(id uint32, content []byte) DOption {
+	return func(o *decoderOptions) error {
+		d, err := loadRawDict(id, content)
+		if err != nil {
+			return err
+		}
+		o.dicts = append(o.dicts, *d)
+		return nil
+	}
+}
+
+// WithDecoderDefaultDict will decode frames that do not specify a dictionary
+// with the registered dictionary with the given ID.
+// This is needed for frames encoded with a raw dictionary with ID 0,
+// since no dictionary ID is written to them.
+// If the dictionary isn't registered when a frame is decoded,
+// ErrUnknownDictionary is returned.
+// By default frames without a dictionary ID are decoded without a dictionary.
+func WithDecoderDefaultDict(id uint32) DOption {
+	return func(o *decoderOptions) error {
+		o.defaultDict = &id
+		return nil
+	}
+}
+
+// WithDecoderPatchFrom registers the reference used to encode a delta
+// with WithEncoderPatchFrom or "zstd --patch-from".
+// It is the same as a raw dictionary with ID 0 that is used as the default dictionary.
+func WithDecoderPatchFrom(reference []byte) DOption {
+	return func(o *decoderOptions) error {
+		if err := WithDecoderDictRaw(0, reference)(o); err != nil {
+			return err
+		}
+		return WithDecoderDefaultDict(0)(o)
+	}
+}
+
+// WithDecoderPrefix uses prefix as the history of the first frame
+// of each stream and of the input to each DecodeAll and DecodeReaderAt call,
+// like ZSTD_DCtx_refPrefix in the zstd library.
+// The prefix replaces any dictionary for the frame,
+// and later frames are decoded without it.
+// It must be the prefix the frame was encoded with.
+// Use ResetWithPrefix or DecodeAllWithPrefix to use a different prefix per payload.
+func WithDecoderPrefix(prefix []byte) DOption {
+	return func(o *decoderOptions) error {
+		o.prefix = loadPrefix(append([]byte{}, prefix...))
+		return nil
+	}
+}
+
+// WithDecodeAllConcurrentFrames will make DecodeAll decode the frames of
+// inputs with several frames concurrently, using up to the decoder concurrency.
+// This speeds up decoding of data compressed in independent frames,
+// for example by pzstd or in the seekable format.
+// The decoded frames are appended to the output in order.
+// Default is false.
+func WithDecodeAllConcurrentFrames(b bool) DOption {
+	return func(o *decoderOptions) error { o.concurrentFrames = b; return nil }
+}
+
+// WithDecoderSeekIndex will make streams in the seekable format decode
+// their frames concurrently, using up to the decoder concurrency.
+// This applies when the reader given to NewReader or Reset implements
+// io.ReaderAt and io.Seeker, like *os.File, and ends with a seek table.
+// The seek table is read from the end of the reader before decoding starts,
+// and the frames are read with ReadAt, so the read position is not advanced.
+// Up to about twice the concurrency of decoded frames are kept in memory.
+// Other streams are decoded as usual.
+// Default is false.
+func WithDecoderSeekIndex(b bool) DOption {
+	return func(o *decoderOptions) error { o.seekIndex = b; return nil }
+}
+
+// WithDecoderCheckContentSize will make frames that state a content size
+// return ErrFrameSizeMismatch if the decoded size differs.
+// Decoding stops as soon as a frame exceeds its content size,
+// and frames that are shorter fail when their last block is decoded.
+// The output of the frame up to the error is returned.
+// Frames without a content size are not checked.
+// Default is false.
+func WithDecoderCheckContentSize(b bool) DOption {
+	return func(o *decoderOptions) error { o.checkContentSize = b; return nil }
+}
+
+// WithDecoderChecksumFunc calls fn with the stored and computed checksum
+// of every decoded frame that has a checksum.
+// fn is called when the frame has been decoded, before a mismatch is returned
+// as ErrCRCMismatch, so applications can log or compare the digests.
+// Frames of a stream are reported in order, but fn can be called
+// concurrently by DecodeAll and DecodeReaderAt.
+func WithDecoderChecksumFunc(fn func(FrameChecksum)) DOption {
+	return func(o *decoderOptions) error { o.checksumFn = fn; return nil }
+}
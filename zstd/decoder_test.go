@@ -7,6 +7,7 @@ package zstd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -27,8 +28,8 @@ import (
 	// "github.com/DataDog/zstd"
 	// zstd "github.com/valyala/gozstd"
 
+	"github.com/klauspost/compress/xxhash"
 	"github.com/klauspost/compress/zip"
-	"github.com/klauspost/compress/zstd/internal/xxhash"
 )
 
 func TestNewReaderMismatch(t *testing.T) {
@@ -600,6 +601,460 @@ func TestShort(t *testing.T) {
 	}
 }
 
+// shortWriter writes half of the input without returning an error.
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, nil
+}
+
+func TestDecoderWriteTo(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := testSeekableData(1 << 20)
+	encoded := enc.EncodeAll(input, nil)
+	enc.Close()
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	readers := map[string]func() io.Reader{
+		// Buffers are decoded synchronously.
+		"buffer": func() io.Reader { return bytes.NewBuffer(encoded) },
+		"stream": func() io.Reader { return ioutil.NopCloser(bytes.NewReader(encoded)) },
+	}
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			// Continue after a Read.
+			if err := dec.Reset(newReader()); err != nil {
+				t.Fatal(err)
+			}
+			start := make([]byte, 1000)
+			if _, err := io.ReadFull(dec, start); err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			n, err := dec.WriteTo(&out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(out.Len()) || !bytes.Equal(append(start, out.Bytes()...), input) {
+				t.Fatal("output mismatch")
+			}
+			// Nothing is written twice.
+			if n, err := dec.WriteTo(&out); n != 0 || err != nil {
+				t.Fatalf("got %d bytes, error %v", n, err)
+			}
+
+			if err := dec.Reset(newReader()); err != nil {
+				t.Fatal(err)
+			}
+			n, err = dec.WriteTo(&errorWriter{n: 1000})
+			if err != errTestWrite || n != 1000 {
+				t.Fatalf("got %d bytes, error %v, want 1000, %v", n, err, errTestWrite)
+			}
+
+			if err := dec.Reset(newReader()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.WriteTo(shortWriter{}); err != io.ErrShortWrite {
+				t.Fatalf("got error %v, want %v", err, io.ErrShortWrite)
+			}
+		})
+	}
+}
+
+func TestDecoderReadByte(t *testing.T) {
+	var input []byte
+	var tmp [binary.MaxVarintLen64]byte
+	var values uint64
+	for ; len(input) < 1<<20; values++ {
+		n := binary.PutUvarint(tmp[:], values*values)
+		input = append(input, tmp[:n]...)
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	compressed := enc.EncodeAll(input, nil)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	// Buffers are decoded synchronously.
+	for _, r := range []io.Reader{bytes.NewBuffer(compressed), ioutil.NopCloser(bytes.NewReader(compressed))} {
+		if err := dec.Reset(r); err != nil {
+			t.Fatal(err)
+		}
+		br := dec.IOReadCloser().(io.ByteReader)
+		var i uint64
+		for ; ; i++ {
+			v, err := binary.ReadUvarint(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v != i*i {
+				t.Fatalf("value %d: got %d, want %d", i, v, i*i)
+			}
+		}
+		if i != values {
+			t.Fatalf("got %d values, want %d", i, values)
+		}
+		if _, err := dec.ReadByte(); err != io.EOF {
+			t.Fatalf("got %v, want EOF", err)
+		}
+	}
+
+	// Mix ReadByte and Read.
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(compressed))); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	buf := make([]byte, 1000)
+	for {
+		c, err := dec.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, c)
+		n, err := dec.Read(buf[:len(got)%len(buf)])
+		got = append(got, buf[:n]...)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(input))
+	}
+
+	// Decoding errors are returned.
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(compressed[:len(compressed)/2]))); err != nil {
+		t.Fatal(err)
+	}
+	for err == nil {
+		_, err = dec.ReadByte()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDecoderMaxDecompressedSize(t *testing.T) {
+	const limit = 100000
+	input := testSeekableData(1 << 20)
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	withSize := enc.EncodeAll(input, nil)
+	// Streams have no content size in the frame header.
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	noSize := buf.Bytes()
+	// Each frame is below the limit.
+	frames := enc.EncodeAll(input[:limit*2/3], nil)
+	frames = enc.EncodeAll(input[limit*2/3:limit*4/3], frames)
+
+	for _, concurrent := range []bool{false, true} {
+		dec, err := NewReader(nil, WithDecoderMaxDecompressedSize(limit), WithDecoderConcurrency(2), WithDecodeAllConcurrentFrames(concurrent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		// Frames stating a larger size are not decoded.
+		got, err := dec.DecodeAll(withSize, nil)
+		if err != ErrDecompressedSizeExceeded || len(got) != 0 {
+			t.Fatalf("got %d bytes, error %v", len(got), err)
+		}
+		got, err = dec.DecodeAll(noSize, []byte("dst"))
+		if err != ErrDecompressedSizeExceeded || !bytes.Equal(got, append([]byte("dst"), input[:limit]...)) {
+			t.Fatalf("got %d bytes, error %v", len(got), err)
+		}
+		// Without concurrency the second frame is rejected by its size.
+		got, err = dec.DecodeAll(frames, nil)
+		if err != ErrDecompressedSizeExceeded || len(got) > limit || !bytes.Equal(got, input[:len(got)]) {
+			t.Fatalf("got %d bytes, error %v", len(got), err)
+		}
+		got, err = dec.DecodeAll(enc.EncodeAll(input[:limit], nil), nil)
+		if err != nil || !bytes.Equal(got, input[:limit]) {
+			t.Fatalf("got %d bytes, error %v", len(got), err)
+		}
+	}
+
+	dec, err := NewReader(nil, WithDecoderMaxDecompressedSize(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for _, in := range [][]byte{withSize, noSize} {
+		// Buffers are decoded synchronously.
+		for _, r := range []io.Reader{bytes.NewBuffer(in), ioutil.NopCloser(bytes.NewReader(in))} {
+			if err := dec.Reset(r); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(dec)
+			if err != ErrDecompressedSizeExceeded {
+				t.Fatalf("got error %v, want %v", err, ErrDecompressedSizeExceeded)
+			}
+			if len(got) > limit || !bytes.Equal(got, input[:len(got)]) {
+				t.Fatalf("got %d bytes, want at most %d", len(got), limit)
+			}
+		}
+	}
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(noSize))); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := dec.WriteTo(&out); err != ErrDecompressedSizeExceeded || !bytes.Equal(out.Bytes(), input[:limit]) {
+		t.Fatalf("got %d bytes, error %v", out.Len(), err)
+	}
+	// The limit is per stream.
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(frames))); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(dec)
+	if err != ErrDecompressedSizeExceeded || !bytes.Equal(got, input[:limit]) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(enc.EncodeAll(input[:limit], nil)))); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(dec)
+	if err != nil || !bytes.Equal(got, input[:limit]) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+
+	if _, err := NewReader(nil, WithDecoderMaxDecompressedSize(0)); err == nil {
+		t.Error("expected error for zero limit")
+	}
+}
+
+func TestDecoderFrameContentSize(t *testing.T) {
+	input := testSeekableData(400 << 10)
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	parts := [][]byte{input[:1000], input[1000:101000], input[101000:302000], input[302000:]}
+	var stream []byte
+	for i, p := range parts {
+		if i != 1 {
+			stream = enc.EncodeAll(p, stream)
+			continue
+		}
+		// Streams of more than one block have no content size in the frame header.
+		var buf bytes.Buffer
+		enc.Reset(&buf)
+		if _, err := enc.Write(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, buf.Bytes()...)
+	}
+
+	for _, concurrent := range []bool{false, true} {
+		dec, err := NewReader(nil, WithDecoderConcurrency(2), WithDecodeAllConcurrentFrames(concurrent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		// Buffers are decoded synchronously.
+		for _, r := range []io.Reader{bytes.NewBuffer(stream), ioutil.NopCloser(bytes.NewReader(stream))} {
+			if err := dec.Reset(r); err != nil {
+				t.Fatal(err)
+			}
+			for i, p := range parts {
+				size, known, err := dec.FrameContentSize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if known != (i != 1) || known && size != uint64(len(p)) {
+					t.Fatalf("frame %d: got size %d, known %v", i, size, known)
+				}
+				// Read the frame in two parts.
+				got := make([]byte, len(p))
+				if _, err := io.ReadFull(dec, got[:len(p)/2]); err != nil {
+					t.Fatal(err)
+				}
+				if size2, _, err := dec.FrameContentSize(); err != nil || size2 != size {
+					t.Fatalf("frame %d: got size %d in frame, error %v", i, size2, err)
+				}
+				if _, err := io.ReadFull(dec, got[len(p)/2:]); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, p) {
+					t.Fatalf("frame %d: output mismatch", i)
+				}
+			}
+			if _, _, err := dec.FrameContentSize(); err != io.EOF {
+				t.Fatalf("got error %v, want io.EOF", err)
+			}
+		}
+	}
+}
+
+func TestDecoderLazyBuffers(t *testing.T) {
+	input := testSeekableData(1 << 20)
+	for _, window := range []int{MinWindowSize, 128 << 10, 8 << 20} {
+		enc, err := NewWriter(nil, WithWindowSize(window), WithEncoderConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stream []byte
+		for _, size := range []int{100, 200 << 10, len(input)} {
+			// Streams have no content size, so the history is sized by the window.
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.Write(input[:size]); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			stream = append(stream, buf.Bytes()...)
+		}
+		enc.Close()
+		for _, concurrent := range []int{1, 4} {
+			dec, err := NewReader(nil, WithDecoderLazyBuffers(true), WithDecoderConcurrency(concurrent))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(dec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := append(append(append([]byte{}, input[:100]...), input[:200<<10]...), input...)
+				if !bytes.Equal(got, want) {
+					t.Fatalf("window %d: output mismatch", window)
+				}
+			}
+			for n := len(dec.decoders); n > 0; n-- {
+				b := <-dec.decoders
+				if cap(b.dst) > 0 || cap(b.dataStorage) > 0 || cap(b.literalBuf) > 0 {
+					t.Errorf("block decoder buffers not released: %d, %d, %d", cap(b.dst), cap(b.dataStorage), cap(b.literalBuf))
+				}
+				dec.decoders <- b
+			}
+			dec.Close()
+		}
+	}
+}
+
+func TestDecoderLazyBuffersMemory(t *testing.T) {
+	const decoders = 10
+	input := testSeekableData(200 << 10)
+	enc, err := NewWriter(nil, WithWindowSize(8<<20), WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream := buf.Bytes()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	decs := make([]*Decoder, decoders)
+	for i := range decs {
+		dec, err := NewReader(ioutil.NopCloser(bytes.NewReader(stream)), WithDecoderLazyBuffers(true), WithDecoderConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		got, err := ioutil.ReadAll(dec)
+		if err != nil || !bytes.Equal(got, input) {
+			t.Fatalf("got %d bytes, error %v", len(got), err)
+		}
+		decs[i] = dec
+	}
+	// Allow the stream decoders to release the history.
+	time.Sleep(10 * time.Millisecond)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	perDecoder := (int64(after.HeapAlloc) - int64(before.HeapAlloc)) / decoders
+	t.Logf("%d bytes per idle decoder", perDecoder)
+	if perDecoder > 1<<20 {
+		t.Errorf("idle decoders use %d bytes each", perDecoder)
+	}
+	runtime.KeepAlive(decs)
+}
+
+func TestDecoderCheckContentSize(t *testing.T) {
+	input := testSeekableData(1000)
+	for _, single := range []bool{false, true} {
+		enc, err := NewWriter(nil, WithSingleSegment(single), WithEncoderCRC(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid := enc.EncodeAll(input, nil)
+		enc.Close()
+		// The content size follows the frame header descriptor and the window descriptor.
+		pos := 6
+		if single {
+			pos = 5
+		}
+		for _, size := range []int{len(input) - 1, len(input) + 1} {
+			b := append([]byte(nil), valid...)
+			binary.LittleEndian.PutUint16(b[pos:], uint16(size-256))
+			var h Header
+			if err := h.Decode(b); err != nil || h.FrameContentSize != uint64(size) {
+				t.Fatalf("got size %d, error %v", h.FrameContentSize, err)
+			}
+			dec, err := NewReader(nil, WithDecoderCheckContentSize(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.DecodeAll(b, nil); err != ErrFrameSizeMismatch {
+				t.Errorf("single %v, size %d: got DecodeAll error %v", single, size, err)
+			}
+			if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(b))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ioutil.ReadAll(dec); err != ErrFrameSizeMismatch {
+				t.Errorf("single %v, size %d: got stream error %v", single, size, err)
+			}
+			got, err := dec.DecodeAll(valid, nil)
+			if err != nil || !bytes.Equal(got, input) {
+				t.Fatalf("got %d bytes, error %v", len(got), err)
+			}
+			dec.Close()
+		}
+	}
+}
+
 func TestDecoder_Reset(t *testing.T) {
 	in, err := ioutil.ReadFile("testdata/z000028")
 	if err != nil {
@@ -1545,3 +2000,61 @@ func timeout(after time.Duration) (cancel func()) {
 		close(cc)
 	}
 }
+
+func TestDecoderContext(t *testing.T) {
+	input := testSeekableData(1 << 20)
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithSingleSegment(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	stream := enc.EncodeAll(input, nil)
+	dec, err := NewReader(nil, WithDecoderConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	got, err := dec.DecodeAllContext(context.Background(), stream, nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dec.DecodeAllContext(ctx, stream, nil); err != context.Canceled {
+		t.Fatalf("got error %v", err)
+	}
+	// Cancel after the first blocks.
+	got, err = dec.DecodeAllContext(&countdownCtx{Context: context.Background(), n: 3}, stream, nil)
+	if err != context.Canceled || len(got) >= len(input) || !bytes.Equal(got, input[:len(got)]) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+
+	// Streams return the error of the context on the next read.
+	ctx, cancel = context.WithCancel(context.Background())
+	if err := dec.ResetContext(ctx, ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(dec, make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(dec); err != context.Canceled {
+		t.Fatalf("got stream error %v", err)
+	}
+	if err := dec.ResetContext(ctx, bytes.NewBuffer(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(dec); err != context.Canceled {
+		t.Fatalf("got sync stream error %v", err)
+	}
+
+	// The decoder can be reused.
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(dec)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+}
@@ -41,42 +41,55 @@ func (d *dict) DictContentSize() int {
 // Load a dictionary as described in
 // https://github.com/facebook/zstd/blob/master/doc/zstd_compression_format.md#dictionary-format
 func loadDict(b []byte) (*dict, error) {
+	d, _, err := parseDict(b)
+	return d, err
+}
+
+// parseDict loads a dictionary and returns its components.
+// The components of the DictInfo reference b.
+func parseDict(b []byte) (*dict, *DictInfo, error) {
 	// Check static field size.
 	if len(b) <= 8+(3*4) {
-		return nil, io.ErrUnexpectedEOF
+		return nil, nil, io.ErrUnexpectedEOF
 	}
+	var info DictInfo
+	in := b
 	d := dict{
 		llDec: sequenceDec{fse: &fseDecoder{}},
 		ofDec: sequenceDec{fse: &fseDecoder{}},
 		mlDec: sequenceDec{fse: &fseDecoder{}},
 	}
 	if !bytes.Equal(b[:4], dictMagic[:]) {
-		return nil, ErrMagicMismatch
+		return nil, nil, ErrMagicMismatch
 	}
 	d.id = binary.LittleEndian.Uint32(b[4:8])
+	info.ID = d.id
 	if d.id == 0 {
-		return nil, errors.New("dictionaries cannot have ID 0")
+		return nil, nil, errors.New("dictionaries cannot have ID 0")
 	}
 
 	// Read literal table
 	var err error
 	d.litEnc, b, err = huff0.ReadTable(b[8:], nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	info.LiteralTable = in[8 : len(in)-len(b)]
 	d.litEnc.Reuse = huff0.ReusePolicyMust
 
 	br := byteReader{
 		b:   b,
 		off: 0,
 	}
-	readDec := func(i tableIndex, dec *fseDecoder) error {
+	readDec := func(i tableIndex, dec *fseDecoder, table *[]byte) error {
+		start := br.off
 		if err := dec.readNCount(&br, uint16(maxTableSymbol[i])); err != nil {
 			return err
 		}
 		if br.overread() {
 			return io.ErrUnexpectedEOF
 		}
+		*table = b[start:br.off]
 		err = dec.transform(symbolTableX[i])
 		if err != nil {
 			println("Transform table error:", err)
@@ -90,17 +103,17 @@ func loadDict(b []byte) (*dict, error) {
 		return nil
 	}
 
-	if err := readDec(tableOffsets, d.ofDec.fse); err != nil {
-		return nil, err
+	if err := readDec(tableOffsets, d.ofDec.fse, &info.OffsetTable); err != nil {
+		return nil, nil, err
 	}
-	if err := readDec(tableMatchLengths, d.mlDec.fse); err != nil {
-		return nil, err
+	if err := readDec(tableMatchLengths, d.mlDec.fse, &info.MatchLengthTable); err != nil {
+		return nil, nil, err
 	}
-	if err := readDec(tableLiteralLengths, d.llDec.fse); err != nil {
-		return nil, err
+	if err := readDec(tableLiteralLengths, d.llDec.fse, &info.LiteralLengthTable); err != nil {
+		return nil, nil, err
 	}
 	if br.remain() < 12 {
-		return nil, io.ErrUnexpectedEOF
+		return nil, nil, io.ErrUnexpectedEOF
 	}
 
 	d.offsets[0] = int(br.Uint32())
@@ -110,13 +123,296 @@ func loadDict(b []byte) (*dict, error) {
 	d.offsets[2] = int(br.Uint32())
 	br.advance(4)
 	if d.offsets[0] <= 0 || d.offsets[1] <= 0 || d.offsets[2] <= 0 {
-		return nil, errors.New("invalid offset in dictionary")
+		return nil, nil, errors.New("invalid offset in dictionary")
 	}
+	info.Offsets = d.offsets
+	info.Content = br.unread()
 	d.content = make([]byte, br.remain())
 	copy(d.content, br.unread())
 	if d.offsets[0] > len(d.content) || d.offsets[1] > len(d.content) || d.offsets[2] > len(d.content) {
-		return nil, fmt.Errorf("initial offset bigger than dictionary content size %d, offsets: %v", len(d.content), d.offsets)
+		return nil, nil, fmt.Errorf("initial offset bigger than dictionary content size %d, offsets: %v", len(d.content), d.offsets)
+	}
+
+	return &d, &info, nil
+}
+
+// DictInfo contains the components of a dictionary in the zstd dictionary format.
+// It can be used to inspect and modify dictionaries.
+type DictInfo struct {
+	// ID of the dictionary.
+	ID uint32
+
+	// LiteralTable is the serialized Huffman table of literals.
+	LiteralTable []byte
+
+	// OffsetTable, MatchLengthTable and LiteralLengthTable are the
+	// serialized FSE tables of the sequence codes.
+	OffsetTable, MatchLengthTable, LiteralLengthTable []byte
+
+	// Offsets are the initial repeat offsets.
+	Offsets [3]int
+
+	// Content is the history used by frames compressed with the dictionary.
+	Content []byte
+}
+
+// ParseDict parses a dictionary in the zstd dictionary format into its components.
+// The dictionary is checked like when it is loaded by the encoder or decoder.
+// The components reference b.
+func ParseDict(b []byte) (*DictInfo, error) {
+	initPredefined()
+	_, info, err := parseDict(b)
+	return info, err
+}
+
+// Size returns the size of the serialized dictionary.
+func (d *DictInfo) Size() int {
+	return len(dictMagic) + 4 + len(d.LiteralTable) + len(d.OffsetTable) + len(d.MatchLengthTable) + len(d.LiteralLengthTable) + 3*4 + len(d.Content)
+}
+
+// String returns the ID, offsets and the size of each component.
+func (d *DictInfo) String() string {
+	return fmt.Sprintf("ID %d, %d bytes: header 8, literal table %d, offset table %d, match length table %d, literal length table %d, offsets 12 %v, content %d",
+		d.ID, d.Size(), len(d.LiteralTable), len(d.OffsetTable), len(d.MatchLengthTable), len(d.LiteralLengthTable), d.Offsets, len(d.Content))
+}
+
+// AppendTo appends the dictionary in the zstd dictionary format to dst.
+// Use ParseDict on the output to check modified dictionaries.
+func (d *DictInfo) AppendTo(dst []byte) []byte {
+	dst = append(dst, dictMagic[:]...)
+	dst = append(dst, uint8(d.ID), uint8(d.ID>>8), uint8(d.ID>>16), uint8(d.ID>>24))
+	dst = append(dst, d.LiteralTable...)
+	dst = append(dst, d.OffsetTable...)
+	dst = append(dst, d.MatchLengthTable...)
+	dst = append(dst, d.LiteralLengthTable...)
+	for _, off := range d.Offsets {
+		dst = append(dst, uint8(off), uint8(off>>8), uint8(off>>16), uint8(off>>24))
 	}
+	return append(dst, d.Content...)
+}
 
+// loadRawDict returns a dictionary with content as the initial history
+// and no entropy tables.
+// Raw dictionaries may use ID 0, which is not written to frames.
+func loadRawDict(id uint32, content []byte) (*dict, error) {
+	if len(content) < 8 {
+		return nil, fmt.Errorf("raw dictionary too small: %d bytes", len(content))
+	}
+	d := dict{
+		id:      id,
+		offsets: [3]int{1, 4, 8},
+		content: make([]byte, len(content)),
+	}
+	copy(d.content, content)
 	return &d, nil
 }
+
+// loadPrefix returns a prefix as a raw dictionary with ID 0.
+// Unlike raw dictionaries, prefixes can be any size.
+// The content is not copied.
+// An empty prefix returns nil.
+func loadPrefix(content []byte) *dict {
+	if len(content) == 0 {
+		return nil
+	}
+	return &dict{
+		offsets: [3]int{1, 4, 8},
+		content: content,
+	}
+}
+
+// BuildDictOptions contains options used for creating a dictionary.
+type BuildDictOptions struct {
+	// ID to use for the dictionary. Must not be 0.
+	ID uint32
+
+	// Contents are samples of the data the dictionary will be used for.
+	// They are compressed with the history to collect statistics
+	// for the entropy tables.
+	Contents [][]byte
+
+	// History is the dictionary content.
+	// The most frequently used content should be placed at the end.
+	History []byte
+
+	// Offsets are the initial repeat offsets.
+	// If all are zero, the default offsets 1, 4 and 8 are used.
+	// All offsets must be within the history.
+	Offsets [3]int
+}
+
+// BuildDict will build a dictionary in the zstd dictionary format.
+// The entropy tables are built from compressing the contents with the history,
+// so they match what the encoder will see when using the dictionary.
+// All literal bytes and codes can be represented by the tables,
+// so the dictionary can be used for any input.
+func BuildDict(o BuildDictOptions) ([]byte, error) {
+	if o.ID == 0 {
+		return nil, errors.New("dictionaries cannot have ID 0")
+	}
+	if len(o.History) < 8 {
+		return nil, fmt.Errorf("dictionary history must be at least 8 bytes, got %d", len(o.History))
+	}
+	if len(o.History) > dictMaxLength {
+		return nil, fmt.Errorf("dictionary history too big: %d > %d", len(o.History), dictMaxLength)
+	}
+	if len(o.Contents) == 0 {
+		return nil, errors.New("no contents provided")
+	}
+	if o.Offsets == [3]int{} {
+		o.Offsets = [3]int{1, 4, 8}
+	}
+	for _, off := range o.Offsets {
+		if off <= 0 || off > len(o.History) {
+			return nil, fmt.Errorf("initial offset %d outside history of %d bytes", off, len(o.History))
+		}
+	}
+
+	initPredefined()
+	d := &dict{id: o.ID, content: o.History, offsets: o.Offsets}
+	opts := encoderOptions{level: SpeedDefault, windowSize: 8 << 20, dict: d}
+	enc := opts.encoder()
+
+	// Collect statistics.
+	var litHist [256]uint64
+	var llHist, ofHist, mlHist [256]uint32
+	for _, src := range o.Contents {
+		if len(src) == 0 {
+			continue
+		}
+		enc.Reset(d, true)
+		for len(src) > 0 {
+			todo := src
+			if len(todo) > maxCompressedBlockSize {
+				todo = todo[:maxCompressedBlockSize]
+			}
+			src = src[len(todo):]
+			blk := enc.Block()
+			enc.Encode(blk, todo)
+			for _, b := range blk.literals {
+				litHist[b]++
+			}
+			for _, s := range blk.sequences {
+				llHist[llCode(s.litLen)]++
+				ofHist[ofCode(s.offset)]++
+				mlHist[mlCode(s.matchLen)]++
+			}
+			blk.reset(nil)
+		}
+	}
+
+	out := make([]byte, 0, len(o.History)+1024)
+	out = append(out, dictMagic[:]...)
+	out = append(out, uint8(o.ID), uint8(o.ID>>8), uint8(o.ID>>16), uint8(o.ID>>24))
+
+	lits, err := buildDictLiterals(litHist)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, lits...)
+
+	for _, t := range []struct {
+		hist *[256]uint32
+		idx  tableIndex
+	}{
+		{hist: &ofHist, idx: tableOffsets},
+		{hist: &mlHist, idx: tableMatchLengths},
+		{hist: &llHist, idx: tableLiteralLengths},
+	} {
+		out, err = appendDictTable(out, t.hist, maxTableSymbol[t.idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, off := range o.Offsets {
+		out = append(out, uint8(off), uint8(off>>8), uint8(off>>16), uint8(off>>24))
+	}
+	out = append(out, o.History...)
+
+	// Check that the dictionary can be loaded.
+	if _, err := loadDict(out); err != nil {
+		return nil, fmt.Errorf("built dictionary failed to load: %w", err)
+	}
+	return out, nil
+}
+
+// dictMaxLength is the maximum history size supported by BuildDict.
+const dictMaxLength = maxCompressedBlockSize * 8
+
+// buildDictLiterals returns a serialized Huffman table for the literal histogram.
+// All symbols are given a weight, so any literal can be encoded.
+func buildDictLiterals(hist [256]uint64) ([]byte, error) {
+	var total uint64
+	for _, v := range hist {
+		total += v
+	}
+	// Scale the histogram to fit within a single huff0 block,
+	// leaving room for all symbols.
+	const maxLits = huff0.BlockSizeMax - 256
+	div := total/maxLits + 1
+	var counts [256]int
+	var maxIdx int
+	for i, v := range hist {
+		counts[i] = int(v/div) + 1
+		if counts[i] > counts[maxIdx] {
+			maxIdx = i
+		}
+	}
+	var s huff0.Scratch
+	for {
+		var lits []byte
+		for i, n := range counts {
+			lits = append(lits, bytes.Repeat([]byte{byte(i)}, n)...)
+		}
+		s.Reuse = huff0.ReusePolicyNone
+		_, _, err := huff0.Compress1X(lits, &s)
+		switch err {
+		case nil:
+			return s.OutTable, nil
+		case huff0.ErrIncompressible:
+			// Evenly distributed literals.
+			// Skew the distribution until a table can be generated.
+			if len(lits) >= huff0.BlockSizeMax/2 {
+				return nil, errors.New("unable to build literal table")
+			}
+			counts[maxIdx] += len(lits)
+		default:
+			return nil, err
+		}
+	}
+}
+
+// appendDictTable appends the normalized counts of hist to out.
+// All symbols up to maxSym are given a weight, so any code can be encoded.
+func appendDictTable(out []byte, hist *[256]uint32, maxSym uint8) ([]byte, error) {
+	var enc fseEncoder
+	h := enc.Histogram()
+	var total int
+	for i := range h[:maxSym+1] {
+		h[i] = hist[i] + 1
+		total += int(h[i])
+	}
+	// With few samples, scale up the counts so the maximum table size is used.
+	// Otherwise not all symbols can be represented.
+	const minTotal = 1 << (maxEncTableLog + 3)
+	if total < minTotal {
+		mul := uint32(minTotal/total + 1)
+		total = 0
+		for i := range h[:maxSym+1] {
+			h[i] *= mul
+			total += int(h[i])
+		}
+	}
+	var maxCount int
+	for _, v := range h[:maxSym+1] {
+		if int(v) > maxCount {
+			maxCount = int(v)
+		}
+	}
+	enc.HistogramFinished(maxSym, maxCount)
+	if err := enc.normalizeCount(total); err != nil {
+		return nil, err
+	}
+	return enc.writeCount(out)
+}
@@ -2,9 +2,13 @@ package zstd
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/klauspost/compress/zip"
@@ -408,3 +412,831 @@ func TestDecoder_MoreDicts(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildDict(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dict-tests-small.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dicts [][]byte
+	var zsts [][]byte
+	for _, tt := range zr.File {
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case strings.HasSuffix(tt.Name, ".dict"):
+			dicts = append(dicts, in)
+		case strings.HasSuffix(tt.Name, ".zst"):
+			zsts = append(zsts, in)
+		}
+	}
+	dec, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDicts(dicts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	var samples [][]byte
+	for _, in := range zsts {
+		b, err := dec.DecodeAll(in, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, b)
+	}
+
+	ref, err := loadDict(dicts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := BuildDict(BuildDictOptions{
+		ID:       1234,
+		Contents: samples,
+		History:  ref.content,
+		Offsets:  ref.offsets,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := loadDict(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.id != 1234 || !bytes.Equal(d.content, ref.content) || d.offsets != ref.offsets {
+		t.Fatal("dictionary content mismatch")
+	}
+	dec2, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDicts(built))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderDict(built), WithEncoderLevel(level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			noDict, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderLevel(level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer noDict.Close()
+			var withDict, without int
+			for _, s := range samples {
+				encoded := enc.EncodeAll(s, nil)
+				withDict += len(encoded)
+				without += len(noDict.EncodeAll(s, nil))
+				got, err := dec2.DecodeAll(encoded, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, s) {
+					t.Fatal("output mismatch")
+				}
+			}
+			t.Logf("with dict: %d, without: %d", withDict, without)
+			if withDict >= without {
+				t.Error("dictionary did not improve compression")
+			}
+		})
+	}
+
+	for name, o := range map[string]BuildDictOptions{
+		"id":       {Contents: samples, History: ref.content},
+		"history":  {ID: 1, Contents: samples, History: []byte("short")},
+		"contents": {ID: 1, History: ref.content},
+		"offsets":  {ID: 1, Contents: samples, History: ref.content, Offsets: [3]int{1, 4, len(ref.content) + 1}},
+	} {
+		if _, err := BuildDict(o); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseDict(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/dict-tests-small.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, tt := range zr.File {
+		if !strings.HasSuffix(tt.Name, ".dict") {
+			continue
+		}
+		r, err := tt.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := ParseDict(in)
+		if err != nil {
+			t.Fatal(tt.Name, err)
+		}
+		t.Log(tt.Name, info)
+		if info.Size() != len(in) {
+			t.Fatalf("%s: size %d, want %d", tt.Name, info.Size(), len(in))
+		}
+		if len(info.LiteralTable) == 0 || len(info.OffsetTable) == 0 || len(info.MatchLengthTable) == 0 || len(info.LiteralLengthTable) == 0 {
+			t.Fatalf("%s: missing table: %v", tt.Name, info)
+		}
+		if got := info.AppendTo(nil); !bytes.Equal(got, in) {
+			t.Fatalf("%s: serialized dictionary mismatch", tt.Name)
+		}
+
+		// Modify the dictionary.
+		info.ID++
+		info.Content = info.Content[len(info.Content)/2:]
+		modified := info.AppendTo(nil)
+		info2, err := ParseDict(modified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info2.ID != info.ID || !bytes.Equal(info2.Content, info.Content) {
+			t.Fatalf("%s: modified dictionary mismatch", tt.Name)
+		}
+		n++
+	}
+	if n == 0 {
+		t.Fatal("no dictionaries found")
+	}
+	if _, err := ParseDict([]byte("not a dictionary, but long enough")); err != ErrMagicMismatch {
+		t.Fatalf("got %v, want %v", err, ErrMagicMismatch)
+	}
+}
+
+func TestRawDict(t *testing.T) {
+	var hist bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&hist, "{\"id\":%d,\"name\":\"item-%d\",\"tags\":[\"a\",\"b\"]}\n", i, i*7)
+	}
+	in := []byte("{\"id\":12,\"name\":\"item-84\",\"tags\":[\"a\",\"b\"]}\n{\"id\":13,\"name\":\"item-91\",\"tags\":[\"a\",\"b\"]}\n")
+
+	for _, id := range []uint32{0, 1234} {
+		for level := SpeedFastest; level <= SpeedBestCompression; level++ {
+			t.Run(fmt.Sprintf("id-%d-%s", id, level), func(t *testing.T) {
+				enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderLevel(level), WithEncoderDictRaw(id, hist.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				noDict, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderLevel(level))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer noDict.Close()
+				dec, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDictRaw(id, hist.Bytes()), WithDecoderDefaultDict(0))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer dec.Close()
+
+				encoded := enc.EncodeAll(in, nil)
+				if without := noDict.EncodeAll(in, nil); len(encoded) >= len(without) {
+					t.Errorf("dictionary did not improve compression: %d >= %d", len(encoded), len(without))
+				}
+				got, err := dec.DecodeAll(encoded, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Fatal("output mismatch")
+				}
+
+				// Streams.
+				var buf bytes.Buffer
+				enc.Reset(&buf)
+				if _, err := enc.Write(in); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if err := dec.Reset(&buf); err != nil {
+					t.Fatal(err)
+				}
+				got, err = ioutil.ReadAll(dec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Fatal("stream output mismatch")
+				}
+			})
+		}
+	}
+
+	if _, err := NewWriter(nil, WithEncoderDictRaw(0, []byte("short"))); err == nil {
+		t.Error("expected error on short dictionary")
+	}
+}
+
+func TestPatchFrom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ref := make([]byte, 4<<20)
+	rng.Read(ref)
+	// A new version with a few changes and an insertion.
+	in := append([]byte{}, ref[:1<<20]...)
+	in = append(in, []byte("inserted content")...)
+	in = append(in, ref[1<<20:]...)
+	for i := 0; i < 50; i++ {
+		in[rng.Intn(len(in))] = 'x'
+	}
+
+	for level := SpeedFastest; level <= SpeedBestCompression; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderPatchFrom(ref))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			dec, err := NewReader(nil, WithDecoderPatchFrom(ref))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+
+			encoded := enc.EncodeAll(in, nil)
+			if len(encoded) > 10<<10 {
+				t.Errorf("delta too big: %d bytes", len(encoded))
+			}
+			got, err := dec.DecodeAll(encoded, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("output mismatch")
+			}
+
+			// Streams.
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.Write(in); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if buf.Len() > 10<<10 {
+				t.Errorf("stream delta too big: %d bytes", buf.Len())
+			}
+			if err := dec.Reset(&buf); err != nil {
+				t.Fatal(err)
+			}
+			got, err = ioutil.ReadAll(dec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("stream output mismatch")
+			}
+		})
+	}
+
+	if _, err := NewWriter(nil, WithEncoderPatchFrom(make([]byte, maxPatchFromSize+1))); err == nil {
+		t.Error("expected error on too big reference")
+	}
+}
+
+func TestDecoderDefaultDict(t *testing.T) {
+	var hist bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&hist, "{\"id\":%d,\"name\":\"item-%d\",\"tags\":[\"a\",\"b\"]}\n", i, i*7)
+	}
+	in := hist.Bytes()[20000:20500]
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderDictRaw(0, hist.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	encoded := enc.EncodeAll(in, nil)
+	plain, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plainEncoded := plain.EncodeAll(in, nil)
+
+	// Registering a raw dictionary with ID 0 does not apply it to frames without an ID.
+	dec, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDictRaw(0, hist.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if got, err := dec.DecodeAll(encoded, nil); err == nil && bytes.Equal(got, in) {
+		t.Fatal("dictionary used without WithDecoderDefaultDict")
+	}
+	got, err := dec.DecodeAll(plainEncoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+
+	// Selecting the default dictionary applies it.
+	dec2, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDictRaw(0, hist.Bytes()), WithDecoderDefaultDict(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec2.Close()
+	got, err = dec2.DecodeAll(encoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+
+	// A missing default dictionary is an error.
+	dec3, err := NewReader(nil, WithDecoderConcurrency(1), WithDecoderDefaultDict(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec3.Close()
+	if _, err := dec3.DecodeAll(plainEncoded, nil); err != ErrUnknownDictionary {
+		t.Fatalf("got %v, want %v", err, ErrUnknownDictionary)
+	}
+}
+
+func TestDecoderRegisterDict(t *testing.T) {
+	var hist bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&hist, "{\"id\":%d,\"name\":\"item-%d\",\"tags\":[\"a\",\"b\"]}\n", i, i*7)
+	}
+	dict, err := BuildDict(BuildDictOptions{ID: 1, Contents: [][]byte{hist.Bytes()[:5000]}, History: hist.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderDict(dict))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	in := hist.Bytes()[20000:20500]
+	encoded := enc.EncodeAll(in, nil)
+
+	dec, err := NewReader(nil, WithDecoderDefaultDict(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	if _, err := dec.DecodeAll(encoded, nil); err != ErrUnknownDictionary {
+		t.Fatalf("got %v, want %v", err, ErrUnknownDictionary)
+	}
+	if err := dec.RegisterDict(dict); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dec.DecodeAll(encoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+	dec.RemoveDict(1)
+	if _, err := dec.DecodeAll(encoded, nil); err != ErrUnknownDictionary {
+		t.Fatalf("got %v, want %v", err, ErrUnknownDictionary)
+	}
+	if err := dec.RegisterDict([]byte("not a dictionary")); err == nil {
+		t.Error("expected error on invalid dictionary")
+	}
+
+	// Raw dictionaries for frames without an ID.
+	raw, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderDictRaw(0, hist.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	rawEncoded := raw.EncodeAll(in, nil)
+	if err := dec.RegisterDictRaw(0, hist.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	got, err = dec.DecodeAll(rawEncoded, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatal("output mismatch")
+	}
+
+	// Streams keep the dictionary they started with.
+	long := bytes.Repeat(in, 2000)
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	enc.Write(long)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.RegisterDict(dict); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Reset(&buf); err != nil {
+		t.Fatal(err)
+	}
+	start := make([]byte, 1000)
+	if _, err := io.ReadFull(dec, start); err != nil {
+		t.Fatal(err)
+	}
+	dec.RemoveDict(1)
+	rest, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(start, rest...), long) {
+		t.Fatal("stream output mismatch")
+	}
+
+	// Concurrent use.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				got, err := dec.DecodeAll(encoded, nil)
+				if err == nil && !bytes.Equal(got, in) {
+					t.Error("output mismatch")
+					return
+				}
+				if err != nil && err != ErrUnknownDictionary {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		if j%2 == 0 {
+			dec.RegisterDict(dict)
+		} else {
+			dec.RemoveDict(1)
+		}
+	}
+	wg.Wait()
+}
+
+func TestEncoderResetWithDict(t *testing.T) {
+	tenants := []string{"user", "order", "invoice"}
+	var dicts, payloads [][]byte
+	for i, name := range tenants {
+		var hist bytes.Buffer
+		for j := 0; j < 1000; j++ {
+			fmt.Fprintf(&hist, "{\"%s_id\":%d,\"%s_name\":\"%s-%d\",\"tags\":[\"%s\"]}\n", name, j, name, name, j*7, name)
+		}
+		// The last dictionary reuses the ID of the first.
+		id := uint32(i%2 + 1)
+		dict, err := BuildDict(BuildDictOptions{ID: id, Contents: [][]byte{hist.Bytes()[:5000]}, History: hist.Bytes()[:20000]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dicts = append(dicts, dict)
+		payloads = append(payloads, hist.Bytes()[30000:30500])
+	}
+	order := []int{0, 1, -1, 1, 0, 2, 0, 2, -1, 2}
+
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderConcurrency(2), WithEncoderLevel(level))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			for _, i := range order {
+				var dict []byte
+				in := payloads[0]
+				decOpts := []DOption{WithDecoderConcurrency(1)}
+				if i >= 0 {
+					dict = dicts[i]
+					in = payloads[i]
+					decOpts = append(decOpts, WithDecoderDicts(dict))
+				}
+				var buf bytes.Buffer
+				if err := enc.ResetWithDict(&buf, dict); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := enc.Write(in); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				encoded := enc.EncodeAll(in, nil)
+
+				// Output must match an encoder created with the dictionary.
+				opts := []EOption{WithEncoderConcurrency(1), WithEncoderLevel(level)}
+				if dict != nil {
+					opts = append(opts, WithEncoderDict(dict))
+				}
+				ref, err := NewWriter(nil, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				want := ref.EncodeAll(in, nil)
+				ref.Close()
+				if !bytes.Equal(encoded, want) {
+					t.Fatalf("dict %d: encoded to %d bytes, want %d", i, len(encoded), len(want))
+				}
+
+				var h Header
+				if err := h.Decode(encoded); err != nil {
+					t.Fatal(err)
+				}
+				if wantID := uint32(i%2 + 1); i >= 0 && h.DictionaryID != wantID {
+					t.Fatalf("dict %d: got dictionary ID %d, want %d", i, h.DictionaryID, wantID)
+				}
+				dec, err := NewReader(nil, decOpts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, b := range [][]byte{buf.Bytes(), encoded} {
+					got, err := dec.DecodeAll(b, nil)
+					if err != nil {
+						dec.Close()
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, in) {
+						dec.Close()
+						t.Fatalf("dict %d: output mismatch", i)
+					}
+				}
+				dec.Close()
+			}
+			if err := enc.ResetWithDict(nil, []byte("not a dictionary")); err == nil {
+				t.Error("expected error on invalid dictionary")
+			}
+		})
+	}
+}
+
+func TestEncoderPrefix(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var base bytes.Buffer
+	for i := 0; base.Len() < 200<<10; i++ {
+		fmt.Fprintf(&base, "{\"id\":%d,\"name\":\"user-%d\",\"score\":%d}\n", i, rng.Intn(10000), rng.Intn(1000))
+	}
+	// The new version has a few changes.
+	next := append([]byte{}, base.Bytes()...)
+	for i := 0; i < len(next); i += 5000 + rng.Intn(5000) {
+		next[i] = 'x'
+	}
+	dict, err := BuildDict(BuildDictOptions{ID: 1, Contents: [][]byte{next[:5000]}, History: next[:20000]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			ref, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+			plain := ref.EncodeAll(next, nil)
+
+			// The prefix is only used for the first frame of the stream.
+			var buf bytes.Buffer
+			enc, err := NewWriter(&buf, WithEncoderLevel(level), WithEncoderConcurrency(1), WithEncoderPrefix(base.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			if _, err := enc.Write(next); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			delta := buf.Bytes()
+			if len(delta)*10 > len(plain) {
+				t.Errorf("delta is %d bytes, without prefix %d bytes", len(delta), len(plain))
+			}
+			var h Header
+			if err := h.Decode(delta); err != nil {
+				t.Fatal(err)
+			}
+			if h.DictionaryID != 0 {
+				t.Errorf("got dictionary ID %d", h.DictionaryID)
+			}
+			// Frames after the first are decoded without the prefix.
+			input := append(delta, plain...)
+			want := append(append([]byte{}, next...), next...)
+
+			for _, concurrent := range []bool{false, true} {
+				dec, err := NewReader(nil, WithDecoderPrefix(base.Bytes()), WithDecodeAllConcurrentFrames(concurrent))
+				if err != nil {
+					t.Fatal(err)
+				}
+				// The prefix is used by every call.
+				for i := 0; i < 2; i++ {
+					got, err := dec.DecodeAll(input, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Fatal("output mismatch")
+					}
+				}
+				dec.Close()
+			}
+			dec, err := NewReader(nil, WithDecoderPrefix(base.Bytes()), WithDecoderConcurrency(4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			_, err = dec.DecodeReaderAt(bytes.NewReader(input), int64(len(input)), &out)
+			dec.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Fatal("DecodeReaderAt output mismatch")
+			}
+			dec, err = NewReader(nil, WithDecoderPrefix(base.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 2; i++ {
+				// Use a reader that is not decoded synchronously.
+				if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(input))); err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(dec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatal("stream output mismatch")
+				}
+			}
+			dec.Close()
+
+			// Every EncodeAll uses the prefix, and it replaces the dictionary.
+			enc, err = NewWriter(nil, WithEncoderLevel(level), WithEncoderDict(dict), WithEncoderPrefix(base.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			if !bytes.Equal(enc.EncodeAll(next, nil), enc.EncodeAll(next, nil)) {
+				t.Error("EncodeAll output differs between calls")
+			}
+			input = enc.EncodeAll(next, nil)
+			first := len(input)
+			input = enc.EncodeAllWithPrefix(next, input, nil)
+			dec, err = NewReader(nil, WithDecoderDicts(dict), WithDecoderPrefix(base.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			got, err := dec.DecodeAll(input, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatal("output mismatch with dictionary")
+			}
+			if err := h.Decode(input[first:]); err != nil || h.DictionaryID != 1 {
+				t.Errorf("got dictionary ID %d without prefix, want 1 (%v)", h.DictionaryID, err)
+			}
+
+			// Small prefixes are valid.
+			enc, err = NewWriter(nil, WithEncoderLevel(level), WithEncoderPrefix([]byte("xyz")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			dec, err = NewReader(nil, WithDecoderPrefix([]byte("xyz")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			in := append([]byte("xyzxyz"), next...)
+			got, err = dec.DecodeAll(enc.EncodeAll(in, nil), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("output mismatch with small prefix")
+			}
+		})
+	}
+}
+
+func TestEncoderPrefixPerCall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	prefixes := make([][]byte, 4)
+	payloads := make([][]byte, len(prefixes))
+	for i := range prefixes {
+		var b bytes.Buffer
+		for b.Len() < 50<<10 {
+			fmt.Fprintf(&b, "{\"id\":%d,\"name\":\"user-%d\",\"score\":%d}\n", i, rng.Intn(10000), rng.Intn(1000))
+		}
+		prefixes[i] = b.Bytes()
+		payloads[i] = append(append([]byte{}, b.Bytes()[1000:]...), "changed"...)
+	}
+
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			dec, err := NewReader(nil, WithDecoderConcurrency(2))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+
+			// Concurrent calls use their own prefix.
+			var wg sync.WaitGroup
+			errs := make([]error, len(prefixes)*4)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					n := i % len(prefixes)
+					delta := enc.EncodeAllWithPrefix(payloads[n], nil, prefixes[n])
+					if len(delta)*10 > len(payloads[n]) {
+						errs[i] = fmt.Errorf("delta is %d bytes", len(delta))
+						return
+					}
+					got, err := dec.DecodeAllWithPrefix(delta, nil, prefixes[n])
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					if !bytes.Equal(got, payloads[n]) {
+						errs[i] = errors.New("output mismatch")
+					}
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Each stream uses the prefix it was reset with.
+			for n := range prefixes {
+				var buf bytes.Buffer
+				enc.ResetWithPrefix(&buf, prefixes[n])
+				if _, err := enc.Write(payloads[n]); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if buf.Len()*10 > len(payloads[n]) {
+					t.Errorf("stream delta is %d bytes", buf.Len())
+				}
+				if err := dec.ResetWithPrefix(ioutil.NopCloser(&buf), prefixes[n]); err != nil {
+					t.Fatal(err)
+				}
+				got, err := ioutil.ReadAll(dec)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payloads[n]) {
+					t.Fatal("stream output mismatch")
+				}
+			}
+
+			// Reset does not keep the prefix.
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.Write(payloads[0]); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := dec.DecodeAll(buf.Bytes(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, payloads[0]) {
+				t.Fatal("output mismatch after Reset")
+			}
+		})
+	}
+}
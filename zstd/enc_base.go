@@ -2,9 +2,10 @@ package zstd
 
 import (
 	"fmt"
+	"math"
 	"math/bits"
 
-	"github.com/klauspost/compress/zstd/internal/xxhash"
+	"github.com/klauspost/compress/xxhash"
 )
 
 const (
@@ -20,8 +21,11 @@ type fastBase struct {
 	crc         *xxhash.Digest
 	tmp         [8]byte
 	blk         *blockEnc
-	lastDictID  uint32
-	lowMem      bool
+	// lastDict is the dictionary the dictionary tables were built for.
+	lastDict *dict
+	lowMem   bool
+	// vec is the vector instructions used to extend matches.
+	vec uint8
 }
 
 // CRC returns the underlying CRC writer.
@@ -45,6 +49,10 @@ func (e *fastBase) WindowSize(size int) int32 {
 		if b < 1024 {
 			b = 1024
 		}
+		if b > e.maxMatchOff {
+			// Long windows are not a power of two.
+			b = e.maxMatchOff
+		}
 		return b
 	}
 	return e.maxMatchOff
@@ -63,6 +71,18 @@ func (e *fastBase) addBlock(src []byte) int32 {
 	if len(e.hist)+len(src) > cap(e.hist) {
 		if cap(e.hist) == 0 {
 			e.ensureHist(len(src))
+		} else if max := e.histSize(); max > maxPreallocHist && cap(e.hist) < int(max) {
+			// Large histories grow as they are needed.
+			n := 2 * cap(e.hist)
+			if n < len(e.hist)+len(src) {
+				n = len(e.hist) + len(src)
+			}
+			if n > int(max) {
+				n = int(max)
+			}
+			hist := make([]byte, len(e.hist), n)
+			copy(hist, e.hist)
+			e.hist = hist
 		} else {
 			if cap(e.hist) < int(e.maxMatchOff+maxCompressedBlockSize) {
 				panic(fmt.Errorf("unexpected buffer cap %d, want at least %d with window %d", cap(e.hist), e.maxMatchOff+maxCompressedBlockSize, e.maxMatchOff))
@@ -79,30 +99,54 @@ func (e *fastBase) addBlock(src []byte) int32 {
 	return s
 }
 
+// maxPreallocHist is the largest history allocated before it is needed.
+const maxPreallocHist = 16 << 20
+
 // ensureHist will ensure that history can keep at least this many bytes.
 func (e *fastBase) ensureHist(n int) {
 	if cap(e.hist) >= n {
 		return
 	}
+	l := e.histSize()
+	if l > maxPreallocHist && int(l) > n {
+		// Large histories start small and grow as they are needed.
+		l = 1 << 20
+	}
+	// Make it at least the requested size.
+	if l < int32(n) {
+		l = int32(n)
+	}
+	e.hist = make([]byte, 0, l)
+}
+
+// histSize returns the capacity of the history.
+func (e *fastBase) histSize() int32 {
 	l := e.maxMatchOff
-	if (e.lowMem && e.maxMatchOff > maxCompressedBlockSize) || e.maxMatchOff <= maxCompressedBlockSize {
+	switch {
+	case (e.lowMem && e.maxMatchOff > maxCompressedBlockSize) || e.maxMatchOff <= maxCompressedBlockSize:
 		l += maxCompressedBlockSize
-	} else {
+	case e.maxMatchOff > maxEncoderWindowSize:
+		// Positions are rebased to the window size before each block,
+		// so the window, the history and a block must fit in an int32.
+		l = math.MaxInt32 - e.maxMatchOff - 2*maxCompressedBlockSize
+	default:
 		l += e.maxMatchOff
 	}
 	// Make it at least 1MB.
 	if l < 1<<20 && !e.lowMem {
 		l = 1 << 20
 	}
-	// Make it at least the requested size.
-	if l < int32(n) {
-		l = int32(n)
-	}
-	e.hist = make([]byte, 0, l)
+	return l
 }
 
 // useBlock will replace the block with the provided one,
 // but transfer recent offsets from the previous.
+// AddHistory adds src to the history without searching for matches.
+// It is used for blocks that are stored uncompressed.
+func (e *fastBase) AddHistory(src []byte) {
+	e.addBlock(src)
+}
+
 func (e *fastBase) UseBlock(enc *blockEnc) {
 	enc.reset(e.blk)
 	e.blk = enc
@@ -110,7 +154,7 @@ func (e *fastBase) UseBlock(enc *blockEnc) {
 
 func (e *fastBase) matchlenNoHist(s, t int32, src []byte) int32 {
 	// Extend the match to be as long as possible.
-	return int32(matchLen(src[s:], src[t:]))
+	return int32(matchLenVec(src[s:], src[t:], e.vec))
 }
 
 func (e *fastBase) matchlen(s, t int32, src []byte) int32 {
@@ -133,7 +177,7 @@ func (e *fastBase) matchlen(s, t int32, src []byte) int32 {
 	}
 
 	// Extend the match to be as long as possible.
-	return int32(matchLen(src[s:], src[t:]))
+	return int32(matchLenVec(src[s:], src[t:], e.vec))
 }
 
 // Reset the encoding table.
@@ -145,6 +189,7 @@ func (e *fastBase) resetBase(d *dict, singleBlock bool) {
 		e.blk.reset(nil)
 	}
 	e.blk.initNewEncode()
+	e.vec = matchVecLevel()
 	if e.crc == nil {
 		e.crc = xxhash.New()
 	} else {
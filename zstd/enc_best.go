@@ -33,8 +33,16 @@ type bestFastEncoder struct {
 	longTable     [bestLongTableSize]prevEntry
 	dictTable     []prevEntry
 	dictLongTable []prevEntry
+
+	// goodEnough is the match length where searching stops.
+	// If 0, defaultGoodEnough is used.
+	goodEnough int32
 }
 
+// defaultGoodEnough is the default match length where searching for
+// a longer match stops.
+const defaultGoodEnough = 100
+
 // Encode improves compression...
 func (e *bestFastEncoder) Encode(blk *blockEnc, src []byte) {
 	const (
@@ -43,6 +51,10 @@ func (e *bestFastEncoder) Encode(blk *blockEnc, src []byte) {
 		inputMargin            = 8 + 4
 		minNonLiteralBlockSize = 16
 	)
+	goodEnough := e.goodEnough
+	if goodEnough <= 0 {
+		goodEnough = defaultGoodEnough
+	}
 
 	// Protect against e.cur wraparound.
 	for e.cur >= bufferReset {
@@ -172,7 +184,6 @@ encodeLoop:
 			}
 			return b
 		}
-		const goodEnough = 100
 
 		nextHashL := hash8(cv, bestLongTableBits)
 		nextHashS := hash4x64(cv, bestShortTableBits)
@@ -431,10 +442,16 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 	if d == nil {
 		return
 	}
+	// Both tables must be rebuilt if the dictionary changed.
+	newDict := d != e.lastDict
 	// Init or copy dict table
-	if len(e.dictTable) != len(e.table) || d.id != e.lastDictID {
+	if len(e.dictTable) != len(e.table) || newDict {
 		if len(e.dictTable) != len(e.table) {
 			e.dictTable = make([]prevEntry, len(e.table))
+		} else {
+			for i := range e.dictTable {
+				e.dictTable[i] = prevEntry{}
+			}
 		}
 		end := int32(len(d.content)) - 8 + e.maxMatchOff
 		for i := e.maxMatchOff; i < end; i += 4 {
@@ -462,13 +479,17 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 				offset: i + 3,
 			}
 		}
-		e.lastDictID = d.id
+		e.lastDict = d
 	}
 
 	// Init or copy dict table
-	if len(e.dictLongTable) != len(e.longTable) || d.id != e.lastDictID {
+	if len(e.dictLongTable) != len(e.longTable) || newDict {
 		if len(e.dictLongTable) != len(e.longTable) {
 			e.dictLongTable = make([]prevEntry, len(e.longTable))
+		} else {
+			for i := range e.dictLongTable {
+				e.dictLongTable[i] = prevEntry{}
+			}
 		}
 		if len(d.content) >= 8 {
 			cv := load6432(d.content, 0)
@@ -490,7 +511,7 @@ func (e *bestFastEncoder) Reset(d *dict, singleBlock bool) {
 				off++
 			}
 		}
-		e.lastDictID = d.id
+		e.lastDict = d
 	}
 	// Reset table to initial state
 	copy(e.longTable[:], e.dictLongTable)
@@ -0,0 +1,311 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// ldmMinMatch is the minimum length of long distance matches.
+	ldmMinMatch = 64
+
+	// ldmRateLog is the log2 of the average distance between hashed positions.
+	ldmRateLog = 7
+
+	// ldmBucketLog is the log2 of the number of positions kept per hash.
+	ldmBucketLog  = 3
+	ldmBucketSize = 1 << ldmBucketLog
+
+	// ldmMinLiterals is the number of literals a long distance match
+	// must replace to be used instead of the matches found by the encoder.
+	ldmMinLiterals = 32
+
+	// ldmDefaultWindow is the window size used with long distance matching,
+	// unless a window size is set.
+	ldmDefaultWindow = 128 << 20
+)
+
+// ldmGear contains the random values of the rolling hash.
+var ldmGear = func() (t [256]uint64) {
+	// splitmix64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// historyEncoder is an encoder that exposes its history.
+type historyEncoder interface {
+	encoder
+	history() []byte
+}
+
+// history returns the current history,
+// which ends with the last block added.
+func (e *fastBase) history() []byte {
+	return e.hist
+}
+
+// ldmEntry is a hashed position.
+type ldmEntry struct {
+	// pos is the position after the hashed bytes,
+	// counted from the first input to the encoder.
+	pos      int64
+	checksum uint32
+}
+
+// ldmMatch is a match within a block.
+type ldmMatch struct {
+	start, length int
+	offset        uint32
+}
+
+// ldmEncoder adds long distance matching to an encoder.
+// A rolling hash selects positions to keep in a large table,
+// which finds matches much further back than the hash tables of the encoder.
+// Long matches are merged into the sequences found by the encoder.
+type ldmEncoder struct {
+	historyEncoder
+	table   []ldmEntry
+	hashLog uint
+	// end is the position of the end of the history.
+	end int64
+
+	matches []ldmMatch
+	found   []ldmMatch
+	merged  []ldmMatch
+}
+
+func newLDMEncoder(enc encoder, windowSize int) *ldmEncoder {
+	hashLog := uint(bits.Len(uint(windowSize-1))) - ldmRateLog - ldmBucketLog
+	if hashLog < 10 {
+		hashLog = 10
+	}
+	return &ldmEncoder{historyEncoder: enc.(historyEncoder), hashLog: hashLog}
+}
+
+// Encode encodes src with the wrapped encoder, and adds long distance matches.
+func (e *ldmEncoder) Encode(blk *blockEnc, src []byte) {
+	offsets := blk.recentOffsets
+	e.historyEncoder.Encode(blk, src)
+	e.end += int64(len(src))
+	hist := e.history()
+	if len(hist) < len(src) || len(src) < ldmMinMatch {
+		return
+	}
+	if e.findMatches(hist, len(hist)-len(src)) {
+		e.merge(blk, src, offsets)
+	}
+}
+
+// AddHistory adds src to the history without searching for matches.
+func (e *ldmEncoder) AddHistory(src []byte) {
+	e.historyEncoder.AddHistory(src)
+	e.end += int64(len(src))
+}
+
+// Reset the encoder for a new frame.
+func (e *ldmEncoder) Reset(d *dict, singleBlock bool) {
+	e.historyEncoder.Reset(d, singleBlock)
+	// Move past all positions in the table,
+	// so a new history doesn't match them.
+	e.end += int64(len(e.history()))
+}
+
+// findMatches finds long distance matches for the block starting at blockStart in hist.
+// It returns whether any matches were found.
+func (e *ldmEncoder) findMatches(hist []byte, blockStart int) bool {
+	if e.table == nil {
+		e.table = make([]ldmEntry, 1<<(e.hashLog+ldmBucketLog))
+	}
+	histStart := e.end - int64(len(hist))
+	maxDist := int64(e.WindowSize(0))
+	hashShift := 64 - ldmRateLog - e.hashLog
+	mask := uint64(1)<<e.hashLog - 1
+	e.found = e.found[:0]
+
+	var h uint64
+	i := blockStart - 64
+	if i < 0 {
+		i = 0
+	}
+	for ; i < blockStart; i++ {
+		h = h<<1 + ldmGear[hist[i]]
+	}
+	// Matches may not start before next.
+	next := blockStart
+	for ; i < len(hist); i++ {
+		h = h<<1 + ldmGear[hist[i]]
+		if h>>(64-ldmRateLog) != 0 || i < 64 {
+			continue
+		}
+		end := i + 1
+		pos := histStart + int64(end)
+		checksum := uint32(h >> 8)
+		idx := int((h>>hashShift)&mask) << ldmBucketLog
+		bucket := e.table[idx : idx+ldmBucketSize]
+		if end > next {
+			var best ldmMatch
+			for _, c := range bucket {
+				dist := pos - c.pos
+				if c.pos == 0 || c.checksum != checksum || dist > maxDist || c.pos < histStart {
+					continue
+				}
+				cand := int(c.pos - histStart)
+				fwd := matchLen(hist[end:], hist[cand:])
+				back := 0
+				for end-back > next && cand-back > 0 && hist[end-back-1] == hist[cand-back-1] {
+					back++
+				}
+				if n := fwd + back; n > best.length {
+					best = ldmMatch{start: end - back, length: n, offset: uint32(dist)}
+				}
+			}
+			if best.length >= ldmMinMatch {
+				if best.length > maxMatchLength {
+					best.length = maxMatchLength
+				}
+				next = best.start + best.length
+				best.start -= blockStart
+				e.found = append(e.found, best)
+			}
+		}
+		copy(bucket[1:], bucket[:ldmBucketSize-1])
+		bucket[0] = ldmEntry{pos: pos, checksum: checksum}
+	}
+	return len(e.found) > 0
+}
+
+// merge merges the long distance matches into the sequences of blk.
+// offsets are the recent offsets before the block was encoded.
+func (e *ldmEncoder) merge(blk *blockEnc, src []byte, offsets [3]uint32) {
+	// Resolve the offsets of the sequences found by the encoder.
+	e.matches = e.matches[:0]
+	reps := offsets
+	pos := 0
+	for _, s := range blk.sequences {
+		pos += int(s.litLen)
+		m := ldmMatch{start: pos, length: int(s.matchLen) + zstdMinMatch, offset: resolveOffset(&reps, s.offset, s.litLen)}
+		e.matches = append(e.matches, m)
+		pos += m.length
+	}
+
+	// Use long distance matches that replace enough literals.
+	used := e.found[:0]
+	j := 0
+	for _, f := range e.found {
+		fEnd := f.start + f.length
+		for j < len(e.matches) && e.matches[j].start+e.matches[j].length <= f.start {
+			j++
+		}
+		lits := f.length
+		for k := j; k < len(e.matches) && e.matches[k].start < fEnd; k++ {
+			start, end := e.matches[k].start, e.matches[k].start+e.matches[k].length
+			if start < f.start {
+				start = f.start
+			}
+			if end > fEnd {
+				end = fEnd
+			}
+			lits -= end - start
+		}
+		if lits >= ldmMinLiterals {
+			used = append(used, f)
+		}
+	}
+	if len(used) == 0 {
+		return
+	}
+
+	// Cut the parts covered by long distance matches from the matches of the encoder.
+	cut := e.merged[:0]
+	j = 0
+	for _, m := range e.matches {
+		start, end := m.start, m.start+m.length
+		for j < len(used) && used[j].start+used[j].length <= start {
+			j++
+		}
+		for k := j; k < len(used) && used[k].start < end; k++ {
+			if used[k].start-start >= zstdMinMatch {
+				cut = append(cut, ldmMatch{start: start, length: used[k].start - start, offset: m.offset})
+			}
+			if uEnd := used[k].start + used[k].length; uEnd > start {
+				start = uEnd
+			}
+		}
+		if end-start >= zstdMinMatch {
+			cut = append(cut, ldmMatch{start: start, length: end - start, offset: m.offset})
+		}
+	}
+	e.merged = cut
+
+	// Merge the sorted matches.
+	matches := e.matches[:0]
+	for len(cut) > 0 || len(used) > 0 {
+		if len(used) == 0 || (len(cut) > 0 && cut[0].start < used[0].start) {
+			matches = append(matches, cut[0])
+			cut = cut[1:]
+		} else {
+			matches = append(matches, used[0])
+			used = used[1:]
+		}
+	}
+	e.matches = matches
+
+	// Encode the sequences.
+	blk.literals = blk.literals[:0]
+	blk.sequences = blk.sequences[:0]
+	blk.recentOffsets = offsets
+	pos = 0
+	for _, m := range e.matches {
+		if debugAsserts && (m.start < pos || m.offset == 0 || m.length < zstdMinMatch) {
+			panic(fmt.Sprintf("invalid merged match %+v at %d", m, pos))
+		}
+		litLen := uint32(m.start - pos)
+		blk.literals = append(blk.literals, src[pos:m.start]...)
+		var offset uint32
+		if len(blk.sequences) > 2 {
+			offset = blk.matchOffset(m.offset, litLen)
+		} else {
+			// Recent offsets are not used before they are set by the block.
+			blk.recentOffsets = [3]uint32{m.offset, blk.recentOffsets[0], blk.recentOffsets[1]}
+			offset = m.offset + 3
+		}
+		blk.sequences = append(blk.sequences, seq{litLen: litLen, matchLen: uint32(m.length - zstdMinMatch), offset: offset})
+		pos = m.start + m.length
+	}
+	blk.literals = append(blk.literals, src[pos:]...)
+	blk.extraLits = len(src) - pos
+}
+
+// resolveOffset returns the offset of a sequence with the offset value v
+// and updates the recent offsets, as done by the decoder.
+func resolveOffset(reps *[3]uint32, v, litLen uint32) uint32 {
+	if v > 3 {
+		reps[0], reps[1], reps[2] = v-3, reps[0], reps[1]
+		return reps[0]
+	}
+	idx := v - 1
+	if litLen == 0 {
+		idx++
+	}
+	switch idx {
+	case 0:
+		return reps[0]
+	case 1:
+		reps[0], reps[1] = reps[1], reps[0]
+	case 2:
+		reps[0], reps[1], reps[2] = reps[2], reps[0], reps[1]
+	default:
+		reps[0], reps[1], reps[2] = reps[0]-1, reps[0], reps[1]
+	}
+	return reps[0]
+}
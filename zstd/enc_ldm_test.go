@@ -0,0 +1,136 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestEncoderLongDistanceMatching(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields("the quick brown fox jumps over lazy dog and cat runs away from big red house")
+	text := func(n int) []byte {
+		var buf bytes.Buffer
+		for i := 0; buf.Len() < n; i++ {
+			fmt.Fprintf(&buf, "%d %s %s %s %d\n", i, words[rng.Intn(len(words))], words[rng.Intn(len(words))], words[rng.Intn(len(words))], rng.Intn(100000))
+		}
+		return buf.Bytes()
+	}
+	// The repeated text is too far back for the hash tables of the levels,
+	// since the text between fills them.
+	// The last copy is changed, so long and short matches are mixed.
+	repeated := text(1 << 20)
+	changed := append([]byte{}, repeated...)
+	for i := 0; i < len(changed); i += 1000 + rng.Intn(10000) {
+		changed[i]++
+	}
+	unique := append(repeated, text(4<<20)...)
+	input := bytes.Join([][]byte{unique, repeated, changed, repeated[:12345]}, nil)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			if testing.Short() && level > SpeedDefault {
+				t.SkipNow()
+			}
+			opts := []EOption{WithEncoderLevel(level), WithEncoderConcurrency(1), WithWindowSize(16 << 20)}
+			enc, err := NewWriter(nil, append(opts, WithEncoderLongDistanceMatching(true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			ref, err := NewWriter(nil, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.Write(input); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// Encode twice to check that the table is not used across frames.
+			enc.EncodeAll(input, nil)
+			for _, b := range [][]byte{buf.Bytes(), enc.EncodeAll(input, nil)} {
+				got, err := dec.DecodeAll(b, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, input) {
+					t.Fatal("output mismatch")
+				}
+				// Repeated content must add little to the size.
+				if want := len(ref.EncodeAll(unique, nil)) + len(ref.EncodeAll(repeated, nil))/10; len(b) > want {
+					t.Errorf("got %d bytes, want at most %d", len(b), want)
+				}
+			}
+		})
+	}
+
+	t.Run("dict", func(t *testing.T) {
+		dict, err := BuildDict(BuildDictOptions{ID: 1, Contents: [][]byte{repeated[:5000]}, History: repeated[:20000]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc, err := NewWriter(nil, WithEncoderDict(dict), WithWindowSize(16<<20), WithEncoderLongDistanceMatching(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		dec, err := NewReader(nil, WithDecoderDicts(dict))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		got, err := dec.DecodeAll(enc.EncodeAll(input, nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatal("output mismatch")
+		}
+	})
+
+	t.Run("default-window", func(t *testing.T) {
+		enc, err := NewWriter(nil, WithEncoderLongDistanceMatching(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer enc.Close()
+		if got := enc.o.windowSize; got != ldmDefaultWindow {
+			t.Errorf("got window size %d, want %d", got, ldmDefaultWindow)
+		}
+	})
+}
+
+func TestResolveOffset(t *testing.T) {
+	// Offsets are encoded and resolved again.
+	rng := rand.New(rand.NewSource(1))
+	var blk blockEnc
+	blk.recentOffsets = [3]uint32{1, 4, 8}
+	reps := blk.recentOffsets
+	for i := 0; i < 10000; i++ {
+		offset := uint32(rng.Intn(10) + 1)
+		litLen := uint32(rng.Intn(2))
+		v := blk.matchOffset(offset, litLen)
+		if got := resolveOffset(&reps, v, litLen); got != offset {
+			t.Fatalf("offset %d, lits %d encoded as %d: got %d", offset, litLen, v, got)
+		}
+		if reps != blk.recentOffsets {
+			t.Fatalf("got recent offsets %v, want %v", reps, blk.recentOffsets)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Sequence is a number of literals followed by a match.
+type Sequence struct {
+	// LitLen is the number of literal bytes before the match.
+	LitLen uint32
+
+	// MatchLen is the length of the match.
+	// It must be at least 3 and at most 131074.
+	MatchLen uint32
+
+	// Offset is the distance back from the start of the match to the matched data.
+	// The match may overlap itself, so Offset can be less than MatchLen.
+	Offset uint32
+}
+
+// SequenceProducer finds matches for the encoder,
+// replacing the built-in match finder.
+// It can be used to plug in specialized match finders or hardware offload,
+// while the encoder does the entropy coding.
+type SequenceProducer interface {
+	// ProduceSequences appends the sequences that encode block to dst.
+	// hist contains the data before block, which matches may refer to.
+	// hist is no longer than the window size, and may contain a dictionary.
+	// Bytes after the last sequence are encoded as literals,
+	// so returning no sequences will store the block as literals.
+	//
+	// Sequences are checked before they are used.
+	// If an error is returned or a sequence is invalid, the block is encoded
+	// with the built-in match finder, which will only find matches within the block.
+	//
+	// The slices must not be retained or modified.
+	ProduceSequences(dst []Sequence, hist, block []byte) ([]Sequence, error)
+}
+
+var errInvalidSequence = errors.New("invalid sequence")
+
+// producerEncoder is an encoder that gets sequences from a SequenceProducer.
+type producerEncoder struct {
+	fastBase
+	producer SequenceProducer
+	fallback encoder
+	seqs     []Sequence
+}
+
+// Encode encodes blk, using the previous blocks as history.
+func (e *producerEncoder) Encode(blk *blockEnc, src []byte) {
+	s := e.addBlock(src)
+	blk.size = len(src)
+	start := 0
+	if int(s) > int(e.maxMatchOff) {
+		start = int(s) - int(e.maxMatchOff)
+	}
+	e.encode(blk, e.hist[start:], int(s)-start)
+}
+
+// EncodeNoHist encodes blk without any history.
+func (e *producerEncoder) EncodeNoHist(blk *blockEnc, src []byte) {
+	blk.size = len(src)
+	e.encode(blk, src, 0)
+}
+
+// encode encodes the block that starts at pos in buf.
+// buf contains the history before the block.
+func (e *producerEncoder) encode(blk *blockEnc, buf []byte, pos int) {
+	var err error
+	hist, src := buf[:pos], buf[pos:]
+	e.seqs, err = e.producer.ProduceSequences(e.seqs[:0], hist, src)
+	if err == nil {
+		err = e.addSequences(blk, buf, pos)
+	}
+	if err != nil {
+		if debugEncoder {
+			println("sequence producer failed:", err)
+		}
+		blk.literals = blk.literals[:0]
+		blk.sequences = blk.sequences[:0]
+		blk.extraLits = 0
+		e.fallback.Reset(nil, true)
+		e.fallback.EncodeNoHist(blk, src)
+	}
+}
+
+// addSequences checks the produced sequences and adds them to blk.
+func (e *producerEncoder) addSequences(blk *blockEnc, buf []byte, pos int) error {
+	src := buf[pos:]
+	for _, s := range e.seqs {
+		switch {
+		case s.MatchLen < zstdMinMatch || s.MatchLen > maxMatchLength:
+			return errInvalidSequence
+		case uint64(pos)+uint64(s.LitLen)+uint64(s.MatchLen) > uint64(len(buf)):
+			return errInvalidSequence
+		}
+		lits := buf[pos : pos+int(s.LitLen)]
+		pos += int(s.LitLen)
+		if s.Offset == 0 || s.Offset > uint32(e.maxMatchOff) || int(s.Offset) > pos {
+			return errInvalidSequence
+		}
+		end := pos + int(s.MatchLen)
+		if !bytes.Equal(buf[pos:end], buf[pos-int(s.Offset):end-int(s.Offset)]) {
+			return errInvalidSequence
+		}
+		blk.literals = append(blk.literals, lits...)
+		// Offsets are not matched against recent offsets,
+		// since these may not be in sync with the decoder.
+		blk.sequences = append(blk.sequences, seq{
+			litLen:   s.LitLen,
+			matchLen: s.MatchLen - zstdMinMatch,
+			offset:   s.Offset + 3,
+		})
+		pos = end
+	}
+	rest := len(buf) - pos
+	blk.literals = append(blk.literals, buf[pos:]...)
+	blk.extraLits = rest
+	if debugAsserts && len(blk.literals) > len(src) {
+		panic("more literals than input")
+	}
+	return nil
+}
+
+// Reset the encoder for a new frame.
+func (e *producerEncoder) Reset(d *dict, singleBlock bool) {
+	e.resetBase(d, singleBlock)
+}
@@ -0,0 +1,168 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// testProducer is a simple greedy match finder.
+type testProducer struct {
+	table map[uint32]int
+	calls int
+}
+
+func (p *testProducer) ProduceSequences(dst []Sequence, hist, block []byte) ([]Sequence, error) {
+	p.calls++
+	buf := append(append([]byte{}, hist...), block...)
+	p.table = make(map[uint32]int)
+	for i := 0; i+4 <= len(hist); i++ {
+		p.table[binary.LittleEndian.Uint32(buf[i:])] = i
+	}
+	lits := 0
+	for i := len(hist); i+4 <= len(buf); {
+		v := binary.LittleEndian.Uint32(buf[i:])
+		cand, ok := p.table[v]
+		p.table[v] = i
+		if !ok {
+			i++
+			lits++
+			continue
+		}
+		n := 4
+		for i+n < len(buf) && buf[i+n] == buf[cand+n] && n < maxMatchLength {
+			n++
+		}
+		dst = append(dst, Sequence{LitLen: uint32(lits), MatchLen: uint32(n), Offset: uint32(i - cand)})
+		i += n
+		lits = 0
+	}
+	return dst, nil
+}
+
+// badProducer returns sequences that are not valid.
+type badProducer struct {
+	seqs []Sequence
+	err  error
+}
+
+func (p badProducer) ProduceSequences(dst []Sequence, hist, block []byte) ([]Sequence, error) {
+	return append(dst, p.seqs...), p.err
+}
+
+func TestSequenceProducer(t *testing.T) {
+	input := testSeekableData(500 << 10)
+	var producers []*testProducer
+	newProducer := func() SequenceProducer {
+		p := &testProducer{}
+		producers = append(producers, p)
+		return p
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	for _, size := range []int{100, 10 << 10, len(input)} {
+		in := input[:size]
+		enc, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderSequenceProducer(newProducer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded := enc.EncodeAll(in, nil)
+		litOnly, err := NewWriter(nil, WithEncoderConcurrency(1), WithEncoderSequenceProducer(func() SequenceProducer { return badProducer{} }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lits := litOnly.EncodeAll(in, nil); size > 1000 && len(encoded) > len(lits)/2 {
+			t.Errorf("size %d: encoded to %d bytes, %d without matches", size, len(encoded), len(lits))
+		}
+		litOnly.Close()
+		got, err := dec.DecodeAll(encoded, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatalf("size %d: output mismatch", size)
+		}
+
+		// Streams use history from previous blocks.
+		var buf bytes.Buffer
+		enc.Reset(&buf)
+		for b := in; len(b) > 0; {
+			n := 50000
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := enc.Write(b[:n]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[n:]
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := dec.Reset(&buf); err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, in) {
+			t.Fatalf("size %d: stream output mismatch", size)
+		}
+	}
+	calls := 0
+	for _, p := range producers {
+		calls += p.calls
+	}
+	if calls == 0 {
+		t.Error("producers not called")
+	}
+}
+
+func TestSequenceProducerFallback(t *testing.T) {
+	input := testSeekableData(200 << 10)
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	for name, p := range map[string]badProducer{
+		"error":       {err: errors.New("producer error")},
+		"short-match": {seqs: []Sequence{{LitLen: 10, MatchLen: 2, Offset: 1}}},
+		"offset-zero": {seqs: []Sequence{{LitLen: 10, MatchLen: 3}}},
+		"offset-far":  {seqs: []Sequence{{LitLen: 10, MatchLen: 3, Offset: 11}}},
+		"too-long":    {seqs: []Sequence{{LitLen: 1 << 20, MatchLen: 3, Offset: 1}}},
+		"mismatch":    {seqs: []Sequence{{LitLen: 10, MatchLen: 100, Offset: 3}}},
+		"literals":    {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderSequenceProducer(func() SequenceProducer { return p }))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			encoded := enc.EncodeAll(input, nil)
+			got, err := dec.DecodeAll(encoded, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, input) {
+				t.Fatal("output mismatch")
+			}
+			// Invalid sequences are replaced by the built-in match finder.
+			if name != "literals" && len(encoded) > len(input)/5 {
+				t.Errorf("encoded to %d bytes", len(encoded))
+			}
+		})
+	}
+}
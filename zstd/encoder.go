@@ -5,13 +5,16 @@
 package zstd
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
 	rdebug "runtime/debug"
 	"sync"
+	"time"
 
-	"github.com/klauspost/compress/zstd/internal/xxhash"
+	"github.com/klauspost/compress/xxhash"
 )
 
 // Encoder provides encoding to Zstandard.
@@ -25,6 +28,13 @@ type Encoder struct {
 	encoders chan encoder
 	state    encoderState
 	init     sync.Once
+	statsMu  sync.Mutex
+	stats    EncoderStats
+
+	// prefixEncoders are used by EncodeAll for frames with a prefix,
+	// if the encoders don't support dictionaries.
+	prefixEncoders chan encoder
+	prefixInit     sync.Once
 }
 
 type encoder interface {
@@ -35,11 +45,13 @@ type encoder interface {
 	AppendCRC([]byte) []byte
 	WindowSize(size int) int32
 	UseBlock(*blockEnc)
+	AddHistory(src []byte)
 	Reset(d *dict, singleBlock bool)
 }
 
 type encoderState struct {
 	w                io.Writer
+	ctx              context.Context
 	filling          []byte
 	current          []byte
 	previous         []byte
@@ -51,6 +63,20 @@ type encoderState struct {
 	headerWritten    bool
 	eofWritten       bool
 	fullFrameWritten bool
+	blocks           frameBlocks
+	// dict is the dictionary or prefix of the stream.
+	dict *dict
+	// dictEncoder is set if encoder supports dictionaries.
+	dictEncoder bool
+
+	// output is the buffer for blocks written by the encode goroutine.
+	output []byte
+
+	// frames are the independent frames being encoded, in output order.
+	// frameIn and frameOut are input and output buffers that can be reused for frames.
+	frames   []*frameJob
+	frameIn  [][]byte
+	frameOut [][]byte
 
 	// This waitgroup indicates an encode is running.
 	wg sync.WaitGroup
@@ -70,6 +96,22 @@ func NewWriter(w io.Writer, opts ...EOption) (*Encoder, error) {
 			return nil, err
 		}
 	}
+	if e.o.patchFrom && !e.o.customWindow {
+		if ws := patchFromWindow(len(e.o.dict.content)); ws > e.o.windowSize {
+			e.o.windowSize = ws
+		}
+	}
+	if e.o.ldm && !e.o.customWindow && e.o.windowSize < ldmDefaultWindow {
+		e.o.windowSize = ldmDefaultWindow
+	}
+	if e.o.windowSize > maxEncoderWindowSize {
+		if !e.o.longWindow {
+			return nil, fmt.Errorf("window size %d above %d requires WithEncoderLongWindow", e.o.windowSize, maxEncoderWindowSize)
+		}
+		if e.o.windowSize > maxLongWindowSize {
+			e.o.windowSize = maxLongWindowSize
+		}
+	}
 	if w != nil {
 		e.Reset(w)
 	}
@@ -87,12 +129,36 @@ func (e *Encoder) initialize() {
 	}
 }
 
+// initializePrefix creates the encoders used for frames with a prefix.
+func (e *Encoder) initializePrefix() {
+	e.prefixEncoders = make(chan encoder, e.o.concurrent)
+	for i := 0; i < e.o.concurrent; i++ {
+		e.prefixEncoders <- e.o.prefixEncoder()
+	}
+}
+
 // Reset will re-initialize the writer and new writes will encode to the supplied writer
 // as a new, independent stream.
 func (e *Encoder) Reset(w io.Writer) {
+	e.reset(w, e.o.prefix)
+}
+
+// ResetWithPrefix will re-initialize the writer like Reset,
+// and use prefix as the history of the first frame of the new stream
+// instead of the prefix set by WithEncoderPrefix.
+// The frame must be decoded with the same prefix.
+// If prefix is empty, no prefix is used.
+// prefix must not be modified until the stream is closed or reset.
+func (e *Encoder) ResetWithPrefix(w io.Writer, prefix []byte) {
+	e.reset(w, loadPrefix(prefix))
+}
+
+// reset starts a new stream to w, with prefix as the history of the first frame.
+func (e *Encoder) reset(w io.Writer, prefix *dict) {
 	s := &e.state
 	s.wg.Wait()
 	s.wWg.Wait()
+	e.dropFrames()
 	if cap(s.filling) == 0 {
 		s.filling = make([]byte, 0, e.o.blockSize)
 	}
@@ -102,8 +168,12 @@ func (e *Encoder) Reset(w io.Writer) {
 	if cap(s.previous) == 0 {
 		s.previous = make([]byte, 0, e.o.blockSize)
 	}
-	if s.encoder == nil {
-		s.encoder = e.o.encoder()
+	if s.encoder == nil || prefix != nil && !s.dictEncoder {
+		if prefix != nil {
+			s.encoder, s.dictEncoder = e.o.prefixEncoder(), true
+		} else {
+			s.encoder, s.dictEncoder = e.o.encoder(), e.o.dictEncoder()
+		}
 	}
 	if s.writing == nil {
 		s.writing = &blockEnc{lowMem: e.o.lowMem}
@@ -113,16 +183,88 @@ func (e *Encoder) Reset(w io.Writer) {
 	s.filling = s.filling[:0]
 	s.current = s.current[:0]
 	s.previous = s.previous[:0]
-	s.encoder.Reset(e.o.dict, false)
+	s.dict = e.frameDict(prefix)
+	s.encoder.Reset(s.dict, false)
 	s.headerWritten = false
 	s.eofWritten = false
 	s.fullFrameWritten = false
+	s.blocks = frameBlocks{}
 	s.w = w
+	s.ctx = context.Background()
 	s.err = nil
 	s.nWritten = 0
 	s.writeErr = nil
 }
 
+// ResetContext will re-initialize the writer like Reset.
+// When ctx is done, the stream stops encoding before the next block
+// and the error of ctx is returned by Write, ReadFrom, Flush and Close.
+// A block that is already being encoded or written is finished first.
+func (e *Encoder) ResetContext(ctx context.Context, w io.Writer) {
+	e.Reset(w)
+	e.state.ctx = ctx
+}
+
+// ResetWithDict will re-initialize the writer like Reset and use dict
+// for the new stream and for subsequent calls to EncodeAll.
+// dict must be in zstd dictionary format. If dict is nil, no dictionary is used.
+//
+// The allocated encoders are kept when switching between dictionaries,
+// and only the dictionary tables are rebuilt.
+// This makes it cheap to keep a pool of Encoders and switch dictionaries per payload.
+// The encoders are replaced when switching between no dictionary and a dictionary.
+//
+// ResetWithDict must not be called concurrently with other calls to the Encoder.
+// If an error is returned, the Encoder is unchanged.
+func (e *Encoder) ResetWithDict(w io.Writer, dict []byte) error {
+	s := &e.state
+	if dict == nil {
+		s.wg.Wait()
+		s.wWg.Wait()
+		e.setDict(nil)
+		e.Reset(w)
+		return nil
+	}
+	d, err := loadDict(dict)
+	if err != nil {
+		return err
+	}
+	s.wg.Wait()
+	s.wWg.Wait()
+	e.setDict(d)
+	e.Reset(w)
+	return nil
+}
+
+// setDict changes the dictionary used by the encoders.
+// Encoders that cannot be reused with the new dictionary are replaced.
+func (e *Encoder) setDict(d *dict) {
+	old := e.o.dict
+	replace := e.o.patchFrom
+	switch {
+	case old == nil || d == nil:
+		// Most levels use a different encoder with dictionaries.
+		replace = replace || (old != d && e.o.producer == nil)
+	case old.id == d.id && bytes.Equal(old.content, d.content):
+		// The encoders have tables for the current dictionary.
+		return
+	}
+	e.o.dict = d
+	e.o.patchFrom = false
+	if !replace {
+		return
+	}
+	e.state.encoder = nil
+	if e.encoders == nil {
+		// Created on first use.
+		return
+	}
+	for i := 0; i < e.o.concurrent; i++ {
+		<-e.encoders
+		e.encoders <- e.o.encoder()
+	}
+}
+
 // Write data to the encoder.
 // Input data will be buffered and as the buffer fills up
 // content will be compressed and written to the output.
@@ -130,6 +272,14 @@ func (e *Encoder) Reset(w io.Writer) {
 // and write CRC if requested.
 func (e *Encoder) Write(p []byte) (n int, err error) {
 	s := &e.state
+	if e.o.frameSize > 0 {
+		return e.writeFrames(p)
+	}
+	if e.o.singleStream() {
+		// The frame is encoded on Close, when the content size is known.
+		s.filling = append(s.filling, p...)
+		return len(p), nil
+	}
 	for len(p) > 0 {
 		if len(p)+len(s.filling) < e.o.blockSize {
 			if e.o.crc {
@@ -171,7 +321,16 @@ func (e *Encoder) nextBlock(final bool) error {
 	if s.err != nil {
 		return s.err
 	}
-	if len(s.filling) > e.o.blockSize {
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	if e.o.singleStream() && !s.headerWritten {
+		if !final {
+			// Single segment frames are written by Close.
+			return nil
+		}
+	} else if len(s.filling) > e.o.blockSize {
 		return fmt.Errorf("block > maxStoreBlockSize")
 	}
 	if !s.headerWritten {
@@ -182,8 +341,12 @@ func (e *Encoder) nextBlock(final bool) error {
 			s.eofWritten = true
 			return nil
 		}
-		if final && len(s.filling) > 0 {
-			s.current = e.EncodeAll(s.filling, s.current[:0])
+		if final && (len(s.filling) > 0 || e.o.singleStream()) {
+			s.current = e.appendPadding(e.encodeAll(s.ctx, s.filling, s.current[:0], s.dict))
+			if err := s.ctx.Err(); err != nil {
+				s.err = err
+				return err
+			}
 			var n2 int
 			n2, s.err = s.w.Write(s.current)
 			if s.err != nil {
@@ -204,7 +367,7 @@ func (e *Encoder) nextBlock(final bool) error {
 			WindowSize:    uint32(s.encoder.WindowSize(0)),
 			SingleSegment: false,
 			Checksum:      e.o.crc,
-			DictID:        e.o.dict.ID(),
+			DictID:        s.dict.ID(),
 		}
 
 		dst, err := fh.appendTo(tmp[:0])
@@ -215,6 +378,7 @@ func (e *Encoder) nextBlock(final bool) error {
 		s.wWg.Wait()
 		var n2 int
 		n2, s.err = s.w.Write(dst)
+		e.addStats(EncoderStats{BytesOut: int64(n2)})
 		if s.err != nil {
 			return s.err
 		}
@@ -234,8 +398,12 @@ func (e *Encoder) nextBlock(final bool) error {
 			blk.last = true
 			blk.encodeRaw(nil)
 			s.wWg.Wait()
-			_, s.err = s.w.Write(blk.output)
+			var n2 int
+			n2, s.err = s.w.Write(blk.output)
 			s.nWritten += int64(len(blk.output))
+			st := EncoderStats{BytesOut: int64(n2)}
+			st.addBlock(blk.output)
+			e.addStats(st)
 			s.eofWritten = true
 		}
 		return s.err
@@ -255,10 +423,43 @@ func (e *Encoder) nextBlock(final bool) error {
 			}
 			s.wg.Done()
 		}()
+		start := time.Now()
 		enc := s.encoder
+		if e.o.targetCBlock > 0 {
+			// Block sizes depend on the previous block,
+			// so blocks are encoded and written in order.
+			s.wWg.Wait()
+			if s.writeErr != nil {
+				s.err = s.writeErr
+				return
+			}
+			st := EncoderStats{BytesIn: int64(len(src))}
+			s.output = e.encodeBlocks(s.ctx, enc, s.output[:0], src, final, &s.blocks, &st)
+			if err := s.ctx.Err(); err != nil {
+				s.err = err
+				return
+			}
+			st.EncodeTime = time.Since(start)
+			if final {
+				s.eofWritten = true
+			}
+			var n2 int
+			n2, s.err = s.w.Write(s.output)
+			s.nWritten += int64(n2)
+			st.BytesOut = int64(n2)
+			e.addStats(st)
+			return
+		}
 		blk := enc.Block()
-		enc.Encode(blk, src)
+		stored := s.blocks.store.store(&e.o, src)
+		if stored {
+			enc.AddHistory(src)
+		} else {
+			enc.Encode(blk, src)
+			s.blocks.store.searched(blk)
+		}
 		blk.last = final
+		searchTime := time.Since(start)
 		if final {
 			s.eofWritten = true
 		}
@@ -282,10 +483,11 @@ func (e *Encoder) nextBlock(final bool) error {
 				}
 				s.wWg.Done()
 			}()
+			start := time.Now()
 			err := errIncompressible
 			// If we got the exact same number of literals as input,
 			// assume the literals cannot be compressed.
-			if len(src) != len(blk.literals) || len(src) != e.o.blockSize {
+			if !stored && (len(src) != len(blk.literals) || len(src) != e.o.blockSize) {
 				err = blk.encode(src, e.o.noEntropy, !e.o.allLitEntropy)
 			}
 			switch err {
@@ -300,8 +502,13 @@ func (e *Encoder) nextBlock(final bool) error {
 				s.writeErr = err
 				return
 			}
-			_, s.writeErr = s.w.Write(blk.output)
+			st := EncoderStats{BytesIn: int64(len(src)), EncodeTime: searchTime + time.Since(start)}
+			st.addBlock(blk.output)
+			var n2 int
+			n2, s.writeErr = s.w.Write(blk.output)
 			s.nWritten += int64(len(blk.output))
+			st.BytesOut = int64(n2)
+			e.addStats(st)
 		}()
 	}(s.current)
 	return nil
@@ -316,15 +523,31 @@ func (e *Encoder) ReadFrom(r io.Reader) (n int64, err error) {
 	if debugEncoder {
 		println("Using ReadFrom")
 	}
+	if e.o.frameSize > 0 {
+		return e.readFromFrames(r)
+	}
+
+	if e.o.singleStream() {
+		// Keep everything for Close.
+		buf := bytes.NewBuffer(e.state.filling)
+		n, err = buf.ReadFrom(r)
+		e.state.filling = buf.Bytes()
+		if err != nil {
+			e.state.err = err
+		}
+		return n, err
+	}
 
-	// Flush any current writes.
-	if len(e.state.filling) > 0 {
+	// Flush any current writes, unless the block should be filled first.
+	filled := len(e.state.filling)
+	if filled > 0 && !e.o.deterministic {
 		if err := e.nextBlock(false); err != nil {
 			return 0, err
 		}
+		filled = 0
 	}
 	e.state.filling = e.state.filling[:e.o.blockSize]
-	src := e.state.filling
+	src := e.state.filling[filled:]
 	for {
 		n2, err := r.Read(src)
 		if e.o.crc {
@@ -368,6 +591,9 @@ func (e *Encoder) ReadFrom(r io.Reader) (n int64, err error) {
 // This should only be used on rare occasions where pushing the currently queued data is critical.
 func (e *Encoder) Flush() error {
 	s := &e.state
+	if e.o.frameSize > 0 {
+		return e.flushFrames()
+	}
 	if len(s.filling) > 0 {
 		err := e.nextBlock(false)
 		if err != nil {
@@ -390,6 +616,9 @@ func (e *Encoder) Close() error {
 	if s.encoder == nil {
 		return nil
 	}
+	if e.o.frameSize > 0 {
+		return e.closeFrames()
+	}
 	err := e.nextBlock(true)
 	if err != nil {
 		return err
@@ -411,36 +640,114 @@ func (e *Encoder) Close() error {
 	if e.o.crc && s.err == nil {
 		// heap alloc.
 		var tmp [4]byte
-		_, s.err = s.w.Write(s.encoder.AppendCRC(tmp[:0]))
+		var n2 int
+		n2, s.err = s.w.Write(s.encoder.AppendCRC(tmp[:0]))
 		s.nWritten += 4
+		e.addStats(EncoderStats{BytesOut: int64(n2)})
 	}
 
-	// Add padding with content from crypto/rand.Reader
-	if s.err == nil && e.o.pad > 0 {
-		add := calcSkippableFrame(s.nWritten, int64(e.o.pad))
-		frame, err := skippableFrame(s.filling[:0], add, rand.Reader)
+	// Add padding
+	if s.err == nil {
+		add := e.o.paddingSize(s.nWritten)
+		if add == 0 {
+			return nil
+		}
+		frame, err := skippableFrame(s.filling[:0], add, e.padding())
 		if err != nil {
 			return err
 		}
-		_, s.err = s.w.Write(frame)
+		var n2 int
+		n2, s.err = s.w.Write(frame)
+		e.addStats(EncoderStats{BytesOut: int64(n2)})
 	}
 	return s.err
 }
 
+// maxFrameHeaderSize is the largest frame header written by the encoder:
+// magic, frame header descriptor, window descriptor,
+// dictionary ID and frame content size.
+const maxFrameHeaderSize = 4 + 1 + 1 + 4 + 8
+
+// CompressBound returns the maximum size of the output of EncodeAll
+// for an input of srcLen bytes, with any encoder options except padding.
+// EncodeAll will not allocate when dst has at least this much spare capacity,
+// once the Encoder has been warmed up by a call of the same size.
+// A negative value is returned if srcLen is negative or the bound overflows an int.
+func CompressBound(srcLen int) int {
+	if srcLen < 0 {
+		return -1
+	}
+	// Incompressible blocks are stored with a 3 byte header.
+	// Blocks contain at least MinWindowSize bytes, except the last,
+	// or a bit less with WithEncoderTargetCBlockSize.
+	blocks := uint64(srcLen)/(MinWindowSize/2) + 1
+	n := uint64(srcLen) + blocks*3 + maxFrameHeaderSize + 4
+	if int(n) < 0 {
+		return -1
+	}
+	return int(n)
+}
+
 // EncodeAll will encode all input in src and append it to dst.
 // This function can be called concurrently, but each call will only run on a single goroutine.
 // If empty input is given, nothing is returned, unless WithZeroFrames is specified.
 // Encoded blocks can be concatenated and the result will be the combined input stream.
 // Data compressed with EncodeAll can be decoded with the Decoder,
 // using either a stream or DecodeAll.
+// If dst has CompressBound(len(src)) bytes of spare capacity, the output will fit without allocating.
 func (e *Encoder) EncodeAll(src, dst []byte) []byte {
+	return e.appendPadding(e.encodeAll(context.Background(), src, dst, e.frameDict(e.o.prefix)))
+}
+
+// EncodeAllWithPrefix will encode all input in src and append it to dst like EncodeAll,
+// and use prefix as the history of the frame instead of the prefix set by WithEncoderPrefix.
+// The frame must be decoded with the same prefix, for example using DecodeAllWithPrefix.
+// If prefix is empty, no prefix is used.
+func (e *Encoder) EncodeAllWithPrefix(src, dst, prefix []byte) []byte {
+	return e.appendPadding(e.encodeAll(context.Background(), src, dst, e.frameDict(loadPrefix(prefix))))
+}
+
+// EncodeAllContext will encode all input in src and append it to dst like EncodeAll.
+// When ctx is done, encoding stops before the next block
+// and dst is returned unchanged with the error of ctx.
+// Input that fits in a single block is always encoded.
+func (e *Encoder) EncodeAllContext(ctx context.Context, src, dst []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return dst, err
+	}
+	out := e.encodeAll(ctx, src, dst, e.frameDict(e.o.prefix))
+	if err := ctx.Err(); err != nil {
+		return dst, err
+	}
+	return e.appendPadding(out), nil
+}
+
+// frameDict returns the dictionary for a frame with the prefix,
+// which replaces the dictionary if it is not nil.
+func (e *Encoder) frameDict(prefix *dict) *dict {
+	if prefix != nil {
+		return prefix
+	}
+	return e.o.dict
+}
+
+// encodeAll encodes src as a frame with the dictionary d and appends it to dst.
+// If ctx is done, the frame is left incomplete.
+func (e *Encoder) encodeAll(ctx context.Context, src, dst []byte, d *dict) []byte {
+	st := EncoderStats{BytesIn: int64(len(src))}
+	start, dstStart := time.Now(), len(dst)
+	defer func() {
+		st.BytesOut = int64(len(dst) - dstStart)
+		st.EncodeTime = time.Since(start)
+		e.addStats(st)
+	}()
 	if len(src) == 0 {
 		if e.o.fullZero {
 			// Add frame header.
 			fh := frameHeader{
 				ContentSize:   0,
 				WindowSize:    MinWindowSize,
-				SingleSegment: true,
+				SingleSegment: e.o.single == nil || *e.o.single,
 				// Adding a checksum would be a waste of space.
 				Checksum: false,
 				DictID:   0,
@@ -453,15 +760,22 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 			blk.setType(blockTypeRaw)
 			blk.setLast(true)
 			dst = blk.appendTo(dst)
+			st.RawBlocks++
 		}
 		return dst
 	}
 	e.init.Do(e.initialize)
-	enc := <-e.encoders
+	encoders := e.encoders
+	if d != nil && !e.o.dictEncoder() {
+		// Only prefixes are used without a dictionary.
+		e.prefixInit.Do(e.initializePrefix)
+		encoders = e.prefixEncoders
+	}
+	enc := <-encoders
 	defer func() {
 		// Release encoder reference to last block.
 		// If a non-single block is needed the encoder will reset again.
-		e.encoders <- enc
+		encoders <- enc
 	}()
 	// Use single segments when above minimum window and below 1MB.
 	single := len(src) < 1<<20 && len(src) > MinWindowSize
@@ -473,7 +787,7 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 		WindowSize:    uint32(enc.WindowSize(len(src))),
 		SingleSegment: single,
 		Checksum:      e.o.crc,
-		DictID:        e.o.dict.ID(),
+		DictID:        d.ID(),
 	}
 
 	// If less than 1MB, allocate a buffer up front.
@@ -486,15 +800,15 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 	}
 
 	// If we can do everything in one block, prefer that.
-	if len(src) <= maxCompressedBlockSize {
-		enc.Reset(e.o.dict, true)
+	if len(src) <= maxCompressedBlockSize && (e.o.targetCBlock <= 0 || len(src) <= e.o.targetCBlock) {
+		enc.Reset(d, true)
 		// Slightly faster with no history and everything in one block.
 		if e.o.crc {
 			_, _ = enc.CRC().Write(src)
 		}
 		blk := enc.Block()
 		blk.last = true
-		if e.o.dict == nil {
+		if d == nil {
 			enc.EncodeNoHist(blk, src)
 		} else {
 			enc.Encode(blk, src)
@@ -504,9 +818,14 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 		// assume the literals cannot be compressed.
 		err := errIncompressible
 		oldout := blk.output
+		blockStart := len(dst)
+		// A failed attempt to compress the block can be larger than the bound,
+		// so only output directly to dst if it can grow anyway.
+		direct := cap(dst)-len(dst) < CompressBound(len(src))
 		if len(blk.literals) != len(src) || len(src) != e.o.blockSize {
-			// Output directly to dst
-			blk.output = dst
+			if direct {
+				blk.output = dst
+			}
 			err = blk.encode(src, e.o.noEntropy, !e.o.allLitEntropy)
 		}
 
@@ -517,60 +836,206 @@ func (e *Encoder) EncodeAll(src, dst []byte) []byte {
 			}
 			dst = blk.encodeRawTo(dst, src)
 		case nil:
-			dst = blk.output
+			if direct {
+				dst = blk.output
+			} else {
+				dst = append(dst, blk.output...)
+			}
 		default:
 			panic(err)
 		}
-		blk.output = oldout
+		st.addBlock(dst[blockStart:])
+		if direct {
+			blk.output = oldout
+		}
 	} else {
-		enc.Reset(e.o.dict, false)
-		blk := enc.Block()
-		for len(src) > 0 {
-			todo := src
-			if len(todo) > e.o.blockSize {
-				todo = todo[:e.o.blockSize]
-			}
-			src = src[len(todo):]
-			if e.o.crc {
-				_, _ = enc.CRC().Write(todo)
-			}
-			blk.pushOffsets()
-			enc.Encode(blk, todo)
-			if len(src) == 0 {
-				blk.last = true
-			}
-			err := errIncompressible
-			// If we got the exact same number of literals as input,
-			// assume the literals cannot be compressed.
-			if len(blk.literals) != len(todo) || len(todo) != e.o.blockSize {
-				err = blk.encode(todo, e.o.noEntropy, !e.o.allLitEntropy)
-			}
-
-			switch err {
-			case errIncompressible:
-				if debugEncoder {
-					println("Storing incompressible block as raw")
-				}
-				dst = blk.encodeRawTo(dst, todo)
-				blk.popOffsets()
-			case nil:
-				dst = append(dst, blk.output...)
-			default:
-				panic(err)
-			}
-			blk.reset(nil)
+		enc.Reset(d, false)
+		if e.o.crc {
+			_, _ = enc.CRC().Write(src)
 		}
+		var fb frameBlocks
+		dst = e.encodeBlocks(ctx, enc, dst, src, true, &fb, &st)
 	}
 	if e.o.crc {
 		dst = enc.AppendCRC(dst)
 	}
-	// Add padding with content from crypto/rand.Reader
-	if e.o.pad > 0 {
-		add := calcSkippableFrame(int64(len(dst)), int64(e.o.pad))
-		dst, err = skippableFrame(dst, add, rand.Reader)
+	return dst
+}
+
+// appendPadding adds the padding selected by the options to dst.
+func (e *Encoder) appendPadding(dst []byte) []byte {
+	if add := e.o.paddingSize(int64(len(dst))); add > 0 {
+		n := len(dst)
+		var err error
+		dst, err = skippableFrame(dst, add, e.padding())
 		if err != nil {
 			panic(err)
 		}
+		e.addStats(EncoderStats{BytesOut: int64(len(dst) - n)})
+	}
+	return dst
+}
+
+// encodeBlocks appends src to dst as one or more blocks and returns the result.
+// If last is set, the final block ends the frame.
+// fb is the state of the previous blocks of the frame,
+// and the encoded blocks are counted in st.
+// If ctx is done, no more blocks are encoded.
+func (e *Encoder) encodeBlocks(ctx context.Context, enc encoder, dst, src []byte, last bool, fb *frameBlocks, st *EncoderStats) []byte {
+	blk := enc.Block()
+	for len(src) > 0 {
+		if ctx.Err() != nil {
+			return dst
+		}
+		todo := src
+		if size := fb.nextSize(&e.o, src); len(todo) > size {
+			todo = todo[:size]
+		}
+		src = src[len(todo):]
+		blk.pushOffsets()
+		stored := fb.store.store(&e.o, todo)
+		if stored {
+			enc.AddHistory(todo)
+		} else {
+			enc.Encode(blk, todo)
+			fb.store.searched(blk)
+		}
+		blk.last = last && len(src) == 0
+		err := errIncompressible
+		// If we got the exact same number of literals as input,
+		// assume the literals cannot be compressed.
+		if !stored && (len(blk.literals) != len(todo) || len(todo) != e.o.blockSize) {
+			err = blk.encode(todo, e.o.noEntropy, !e.o.allLitEntropy)
+		}
+		blockStart := len(dst)
+
+		switch err {
+		case errIncompressible:
+			if debugEncoder {
+				println("Storing incompressible block as raw")
+			}
+			dst = blk.encodeRawTo(dst, todo)
+			blk.popOffsets()
+		case nil:
+			dst = append(dst, blk.output...)
+		default:
+			panic(err)
+		}
+		st.addBlock(dst[blockStart:])
+		fb.add(len(todo), len(dst)-blockStart)
+		blk.reset(nil)
 	}
 	return dst
 }
+
+// frameBlocks is the state kept between the blocks of a frame.
+type frameBlocks struct {
+	store storeState
+
+	// Input size of the last block.
+	lastIn int
+	// Input and output size of previous blocks,
+	// with the weight halved for each block.
+	in, out int
+}
+
+// maxTargetProbe is the maximum size of the parts of the input checked for
+// incompressible data, when a block is larger than the target compressed size.
+const maxTargetProbe = 4 << 10
+
+// nextSize returns the input size of the next block with input src.
+// With WithEncoderTargetCBlockSize the size is picked from the
+// compression ratio of the previous blocks.
+func (f *frameBlocks) nextSize(o *encoderOptions, src []byte) int {
+	target := o.targetCBlock
+	if target <= 0 {
+		return o.blockSize
+	}
+	n := target
+	if f.in > 0 && f.out > 0 {
+		n = int(int64(target) * int64(f.in) / int64(f.out))
+		// The ratio varies between blocks, so grow slowly.
+		if max := 2 * f.lastIn; n > max {
+			n = max
+			if n < target {
+				n = target
+			}
+		}
+	}
+	if n > o.blockSize {
+		n = o.blockSize
+	}
+	// End the block before incompressible data,
+	// which would make the block much larger than the target.
+	probeSize := target
+	if probeSize > maxTargetProbe {
+		probeSize = maxTargetProbe
+	}
+	for i := 0; i < n-target && i < len(src); i += probeSize {
+		probe := src[i:]
+		if len(probe) > probeSize {
+			probe = probe[:probeSize]
+		}
+		if incompressibleLits(probe) {
+			n = i
+			if n < target {
+				n = target
+			}
+			break
+		}
+	}
+	return n
+}
+
+// add records the input and output size of a block.
+func (f *frameBlocks) add(in, out int) {
+	f.lastIn = in
+	f.in = f.in/2 + in
+	f.out = f.out/2 + out
+}
+
+// storeProbeBlocks is the maximum number of consecutive blocks stored
+// without searching for matches.
+// Searching a block will detect the end of an incompressible region,
+// and keeps the encoder offsets in range.
+const storeProbeBlocks = 8
+
+// storeState tracks incompressible regions of a frame for WithEncoderStoreIncompressible.
+type storeState struct {
+	noMatches bool // No matches were found in the last searched block.
+	stored    int  // Blocks stored since the last search.
+}
+
+// store returns whether src should be stored without searching for matches.
+func (s *storeState) store(o *encoderOptions, src []byte) bool {
+	if !o.storeIncompress || !s.noMatches || s.stored >= storeProbeBlocks || !incompressibleLits(src) {
+		return false
+	}
+	s.stored++
+	return true
+}
+
+// searched records the result of searching blk for matches.
+func (s *storeState) searched(blk *blockEnc) {
+	s.noMatches = len(blk.literals) > blk.size-blk.size/32
+	s.stored = 0
+}
+
+// padding returns the reader used for filling padding.
+// Padding is random, unless output must be deterministic.
+func (e *Encoder) padding() io.Reader {
+	if e.o.deterministic {
+		return zeroReader{}
+	}
+	return rand.Reader
+}
+
+// zeroReader returns an infinite stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
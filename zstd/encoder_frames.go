@@ -0,0 +1,211 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"fmt"
+	"io"
+	rdebug "runtime/debug"
+)
+
+// frameJob is an independent frame being encoded by a goroutine,
+// when WithEncoderIndependentFrames is used.
+type frameJob struct {
+	src  []byte
+	out  []byte
+	err  error
+	done chan struct{}
+}
+
+// writeFrames buffers p and starts encoding a frame each time the frame size is reached.
+func (e *Encoder) writeFrames(p []byte) (n int, err error) {
+	s := &e.state
+	if s.err != nil {
+		return 0, s.err
+	}
+	for len(p) > 0 {
+		e.fillingFrame()
+		add := p
+		if room := e.o.frameSize - len(s.filling); len(add) > room {
+			add = add[:room]
+		}
+		s.filling = append(s.filling, add...)
+		p = p[len(add):]
+		n += len(add)
+		if len(s.filling) == e.o.frameSize {
+			if err := e.nextFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// readFromFrames reads r until EOF like ReadFrom,
+// and starts encoding a frame each time the frame size is reached.
+func (e *Encoder) readFromFrames(r io.Reader) (n int64, err error) {
+	s := &e.state
+	if s.err != nil {
+		return 0, s.err
+	}
+	for {
+		e.fillingFrame()
+		n2, err := r.Read(s.filling[len(s.filling):e.o.frameSize])
+		s.filling = s.filling[:len(s.filling)+n2]
+		n += int64(n2)
+		switch err {
+		case io.EOF:
+			return n, nil
+		case nil:
+		default:
+			s.err = err
+			return n, err
+		}
+		if len(s.filling) == e.o.frameSize {
+			if err := e.nextFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+}
+
+// fillingFrame makes sure the input buffer can hold a full frame.
+func (e *Encoder) fillingFrame() {
+	s := &e.state
+	if cap(s.filling) >= e.o.frameSize {
+		return
+	}
+	b := popFrameBuf(&s.frameIn)
+	if cap(b) < e.o.frameSize {
+		b = make([]byte, 0, e.o.frameSize)
+	}
+	s.filling = append(b, s.filling...)
+}
+
+// popFrameBuf removes the last buffer from bufs and returns it, or nil if there are none.
+func popFrameBuf(bufs *[][]byte) []byte {
+	n := len(*bufs)
+	if n == 0 {
+		return nil
+	}
+	b := (*bufs)[n-1]
+	(*bufs)[n-1] = nil
+	*bufs = (*bufs)[:n-1]
+	return b
+}
+
+// nextFrame starts encoding the buffered input as an independent frame.
+// If the encoder concurrency is reached, the oldest frame is written first.
+func (e *Encoder) nextFrame() error {
+	s := &e.state
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	for len(s.frames) >= e.o.concurrent {
+		if err := e.writeFrame(); err != nil {
+			return err
+		}
+	}
+	// The prefix only applies to the first frame.
+	d := e.o.dict
+	if !s.headerWritten {
+		d = s.dict
+		s.headerWritten = true
+	}
+	job := &frameJob{src: s.filling, out: popFrameBuf(&s.frameOut), done: make(chan struct{})}
+	s.filling = popFrameBuf(&s.frameIn)[:0]
+	s.frames = append(s.frames, job)
+	ctx := s.ctx
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				job.err = fmt.Errorf("panic while encoding: %v", r)
+				rdebug.PrintStack()
+			}
+			close(job.done)
+		}()
+		job.out = e.encodeAll(ctx, job.src, job.out[:0], d)
+		job.err = ctx.Err()
+	}()
+	return nil
+}
+
+// writeFrame waits for the oldest frame being encoded and writes it.
+func (e *Encoder) writeFrame() error {
+	s := &e.state
+	job := s.frames[0]
+	s.frames[0] = nil
+	s.frames = s.frames[1:]
+	<-job.done
+	if s.err == nil {
+		s.err = job.err
+	}
+	if s.err == nil {
+		var n2 int
+		n2, s.err = s.w.Write(job.out)
+		s.nWritten += int64(n2)
+	}
+	s.frameIn = append(s.frameIn, job.src[:0])
+	s.frameOut = append(s.frameOut, job.out[:0])
+	return s.err
+}
+
+// flushFrames ends the current frame and writes all frames.
+func (e *Encoder) flushFrames() error {
+	s := &e.state
+	if len(s.filling) > 0 && s.err == nil {
+		if err := e.nextFrame(); err != nil {
+			return err
+		}
+	}
+	for len(s.frames) > 0 {
+		e.writeFrame()
+	}
+	return s.err
+}
+
+// closeFrames writes the remaining frames and the padding.
+func (e *Encoder) closeFrames() error {
+	s := &e.state
+	if s.eofWritten {
+		return s.err
+	}
+	if err := e.flushFrames(); err != nil {
+		return err
+	}
+	s.eofWritten = true
+	if !s.headerWritten && e.o.fullZero {
+		s.headerWritten = true
+		s.filling = e.encodeAll(s.ctx, nil, s.filling[:0], nil)
+		var n2 int
+		n2, s.err = s.w.Write(s.filling)
+		s.nWritten += int64(n2)
+		s.filling = s.filling[:0]
+		if s.err != nil {
+			return s.err
+		}
+	}
+	if add := e.o.paddingSize(s.nWritten); add > 0 {
+		frame, err := skippableFrame(s.filling[:0], add, e.padding())
+		if err != nil {
+			return err
+		}
+		var n2 int
+		n2, s.err = s.w.Write(frame)
+		e.addStats(EncoderStats{BytesOut: int64(n2)})
+	}
+	return s.err
+}
+
+// dropFrames waits for frames being encoded and discards them.
+func (e *Encoder) dropFrames() {
+	s := &e.state
+	for _, job := range s.frames {
+		<-job.done
+		s.frameIn = append(s.frameIn, job.src[:0])
+		s.frameOut = append(s.frameOut, job.out[:0])
+	}
+	s.frames = s.frames[:0]
+}
@@ -3,6 +3,7 @@ package zstd
 import (
 	"errors"
 	"fmt"
+	"math/bits"
 	"runtime"
 	"strings"
 )
@@ -16,6 +17,7 @@ type encoderOptions struct {
 	level           EncoderLevel
 	single          *bool
 	pad             int
+	padFn           func(size int64) int64
 	blockSize       int
 	windowSize      int
 	crc             bool
@@ -25,7 +27,17 @@ type encoderOptions struct {
 	customWindow    bool
 	customALEntropy bool
 	lowMem          bool
+	deterministic   bool
+	storeIncompress bool
+	targetCBlock    int
+	targetLength    int
+	longWindow      bool
+	ldm             bool
 	dict            *dict
+	patchFrom       bool
+	prefix          *dict
+	producer        func() SequenceProducer
+	frameSize       int
 }
 
 func (o *encoderOptions) setDefault() {
@@ -41,27 +53,75 @@ func (o *encoderOptions) setDefault() {
 	}
 }
 
+// singleStream returns whether streams must be written as single segment frames.
+func (o *encoderOptions) singleStream() bool {
+	return o.single != nil && *o.single
+}
+
 // encoder returns an encoder with the selected options.
 func (o encoderOptions) encoder() encoder {
+	enc := o.matchEncoder()
+	if o.ldm && o.producer == nil {
+		return newLDMEncoder(enc, o.windowSize)
+	}
+	return enc
+}
+
+// prefixEncoder returns an encoder with the selected options
+// that can also encode frames with a prefix.
+func (o encoderOptions) prefixEncoder() encoder {
+	if !o.dictEncoder() {
+		// Prefixes use the dictionary encoders.
+		o.prefix = &dict{}
+	}
+	return o.encoder()
+}
+
+// dictEncoder returns whether the encoders of the options support dictionaries and prefixes.
+func (o encoderOptions) dictEncoder() bool {
+	return o.dict != nil || o.prefix != nil || o.patchFrom || o.producer != nil || o.level == SpeedBestCompression
+}
+
+// matchEncoder returns the encoder for the level, or the sequence producer.
+func (o encoderOptions) matchEncoder() encoder {
+	if o.producer != nil {
+		fallback := o
+		fallback.producer = nil
+		fallback.ldm = false
+		fallback.dict = nil
+		fallback.patchFrom = false
+		fallback.prefix = nil
+		return &producerEncoder{
+			fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem},
+			producer: o.producer(),
+			fallback: fallback.encoder(),
+		}
+	}
+	if o.patchFrom {
+		// Only the best encoder finds matches in all of a large reference.
+		return &bestFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}, goodEnough: int32(o.targetLength)}
+	}
+	// Prefixes use the dictionary encoders.
+	useDict := o.dict != nil || o.prefix != nil
 	switch o.level {
 	case SpeedFastest:
-		if o.dict != nil {
+		if useDict {
 			return &fastEncoderDict{fastEncoder: fastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}}
 		}
 		return &fastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}
 
 	case SpeedDefault:
-		if o.dict != nil {
+		if useDict {
 			return &doubleFastEncoderDict{fastEncoderDict: fastEncoderDict{fastEncoder: fastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}}}
 		}
 		return &doubleFastEncoder{fastEncoder: fastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}}
 	case SpeedBetterCompression:
-		if o.dict != nil {
+		if useDict {
 			return &betterFastEncoderDict{betterFastEncoder: betterFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}}
 		}
 		return &betterFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}
 	case SpeedBestCompression:
-		return &bestFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}}
+		return &bestFastEncoder{fastBase: fastBase{maxMatchOff: int32(o.windowSize), lowMem: o.lowMem}, goodEnough: int32(o.targetLength)}
 	}
 	panic("unknown compression level")
 }
@@ -86,8 +146,29 @@ func WithEncoderConcurrency(n int) EOption {
 	}
 }
 
+// WithEncoderIndependentFrames will make streams split the input into frames of size bytes,
+// which are compressed as independent frames on separate goroutines, like pzstd does.
+// Up to the encoder concurrency frames are compressed at the same time,
+// so stream encoding scales with the number of cores.
+// Compression is slightly worse, since matches cannot reference previous frames.
+// The frames can be decoded concurrently with WithDecodeAllConcurrentFrames.
+// The input of up to twice the concurrency frames is buffered.
+// Flush ends the current frame, and the last frame can be smaller than size.
+// EncodeAll is not affected.
+// size must be at least MinWindowSize, or 0 to disable independent frames, which is the default.
+func WithEncoderIndependentFrames(size int) EOption {
+	return func(o *encoderOptions) error {
+		if size != 0 && size < MinWindowSize {
+			return fmt.Errorf("frame size must be at least %d", MinWindowSize)
+		}
+		o.frameSize = size
+		return nil
+	}
+}
+
 // WithWindowSize will set the maximum allowed back-reference distance.
 // The value must be a power of two between MinWindowSize and MaxWindowSize.
+// Windows above 512MB also require WithEncoderLongWindow.
 // A larger value will enable better compression but allocate more memory and,
 // for above-default values, take considerably longer.
 // The default value is determined by the compression level.
@@ -111,11 +192,60 @@ func WithWindowSize(n int) EOption {
 	}
 }
 
+const (
+	// maxEncoderWindowSize is the largest window used without WithEncoderLongWindow.
+	maxEncoderWindowSize = 1 << 29
+
+	// maxLongWindowSize is the largest window supported by the encoder.
+	// The window and the history must fit in int32 positions.
+	maxLongWindowSize = 960 << 20
+)
+
+// WithEncoderLongWindow allows window sizes above 512MB set with WithWindowSize.
+// Long windows find content repeated at very long distances,
+// for example in archives of similar files.
+// The encoder supports windows up to 960MB, larger windows are reduced to 960MB.
+//
+// The frame header contains the window size, and decoders must allow it.
+// Decoders in this package allow it by default, see WithDecoderMaxWindow.
+// The zstd command line tool must be given --long=30 or --memory=1GB.
+//
+// Each encoder keeps up to 1GB of history, which is allocated as input is added.
+// Concurrent calls to EncodeAll use separate encoders,
+// so WithEncoderConcurrency can be used to limit the memory used.
+func WithEncoderLongWindow(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.longWindow = b
+		return nil
+	}
+}
+
+// WithEncoderLongDistanceMatching enables long distance matching,
+// which finds long repeated content far back in large windows.
+// This can significantly improve compression of large inputs with content
+// repeated at distances of megabytes, like VM images and database dumps.
+//
+// Positions selected by a rolling hash are kept in a table covering the window,
+// and matches of at least 64 bytes are added to the matches found at the level.
+// The table uses 16 bytes for every 128 bytes of window.
+//
+// Unless WithWindowSize is used, the window size is set to 128MB.
+// The window size is stored in the frame header, and the zstd command line tool
+// must be given --long=27 or --memory=128MB to decode frames with a 128MB window.
+// Long distance matching is not used with WithEncoderSequenceProducer.
+func WithEncoderLongDistanceMatching(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.ldm = b
+		return nil
+	}
+}
+
 // WithEncoderPadding will add padding to all output so the size will be a multiple of n.
 // This can be used to obfuscate the exact output size or make blocks of a certain size.
 // The contents will be a skippable frame, so it will be invisible by the decoder.
 // n must be > 0 and <= 1GB, 1<<30 bytes.
-// The padded area will be filled with data from crypto/rand.Reader.
+// The padded area will be filled with data from crypto/rand.Reader,
+// or zeros if WithEncoderDeterministic is enabled.
 // If `EncodeAll` is used with data already in the destination, the total size will be multiple of this.
 func WithEncoderPadding(n int) EOption {
 	return func(o *encoderOptions) error {
@@ -130,10 +260,54 @@ func WithEncoderPadding(n int) EOption {
 			return fmt.Errorf("padding must less than 1GB (1<<30 bytes) ")
 		}
 		o.pad = n
+		o.padFn = nil
 		return nil
 	}
 }
 
+// WithEncoderPaddingFunc will add padding to all output, so the size
+// is picked by fn, for example to hide the length of the plaintext before encryption.
+// fn is called with the output size and must return the padded size,
+// which must be at least size and at most 1GB larger, or the encoder will panic.
+// Since padding is added as a skippable frame with an 8 byte header,
+// fn is called again with size+8 if it returns less than 8 bytes of padding.
+// PadPowerOfTwo and PadPadme can be used as fn.
+// The padded area is filled like with WithEncoderPadding, which is replaced by this option.
+func WithEncoderPaddingFunc(fn func(size int64) int64) EOption {
+	return func(o *encoderOptions) error {
+		if fn == nil {
+			return errors.New("nil padding function")
+		}
+		o.padFn = fn
+		o.pad = 0
+		return nil
+	}
+}
+
+// PadPowerOfTwo returns the smallest power of two that is at least size.
+// It can be used with WithEncoderPaddingFunc, but adds up to 100% overhead.
+func PadPowerOfTwo(size int64) int64 {
+	if size <= 1 {
+		return size
+	}
+	return 1 << bits.Len64(uint64(size-1))
+}
+
+// PadPadme returns size rounded up with the Padmé scheme,
+// which hides the low bits of the size depending on its magnitude.
+// Sizes only leak O(log log size) bits of information,
+// while adding at most 12% overhead.
+// It can be used with WithEncoderPaddingFunc.
+func PadPadme(size int64) int64 {
+	if size <= 2 {
+		return size
+	}
+	e := bits.Len64(uint64(size)) - 1
+	s := bits.Len64(uint64(e))
+	mask := int64(1)<<uint(e-s) - 1
+	return (size + mask) &^ mask
+}
+
 // EncoderLevel predefines encoder compression levels.
 // Only use the constants made available, since the actual mapping
 // of these values are very likely to change and your compression could change
@@ -278,8 +452,14 @@ func WithNoEntropyCompression(b bool) EOption {
 // a decoder is allowed to reject a compressed frame which requests a memory size beyond decoder's authorized range.
 // For broader compatibility, decoders are recommended to support memory sizes of at least 8 MB.
 // This is only a recommendation, each decoder is free to support higher or lower limits, depending on local limitations.
-// If this is not specified, block encodes will automatically choose this based on the input size.
-// This setting has no effect on streamed encodes.
+// If this is not specified, block encodes will automatically choose this based on the input size,
+// and streams only use single segment frames when they fit in one block.
+//
+// Setting the flag explicitly forces or forbids single segment frames regardless of the input size,
+// which also applies to empty frames written with WithZeroFrames.
+// Since the content size must be known before the frame header is written,
+// streams forced to single segment frames keep all input in memory and encode it on Close.
+// Flush will not write any output for such streams.
 func WithSingleSegment(b bool) EOption {
 	return func(o *encoderOptions) error {
 		o.single = &b
@@ -298,6 +478,76 @@ func WithLowerEncoderMem(b bool) EOption {
 	}
 }
 
+// WithEncoderDeterministic will guarantee that identical input and options produce identical output,
+// independent of the concurrency, the sizes of the writes and previous use of the Encoder.
+// This is useful for content addressed storage, where the output must be reproducible.
+//
+// Padding will be filled with zeros, and ReadFrom will not end the current block
+// when data has already been written.
+// Output still differs between streams and EncodeAll, and is affected by calls to Flush.
+// Output from a SequenceProducer must also be deterministic.
+func WithEncoderDeterministic(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.deterministic = b
+		return nil
+	}
+}
+
+// WithEncoderStoreIncompressible will store incompressible regions of the input
+// without searching for matches.
+// When no matches are found in a block, the following blocks are stored uncompressed
+// if their byte distribution is so even that entropy coding would save less than 1/32.
+// Every 8th block is searched again to detect the end of the region.
+// This avoids spending time on already compressed or encrypted data,
+// while other parts of the input are compressed as usual.
+// Repeated content within incompressible regions may not be found.
+//
+// Regardless of this option, blocks that cannot be compressed are stored uncompressed,
+// so the output is at most 3 bytes larger than the input per block,
+// plus the frame header and checksum.
+func WithEncoderStoreIncompressible(b bool) EOption {
+	return func(o *encoderOptions) error {
+		o.storeIncompress = b
+		return nil
+	}
+}
+
+// WithEncoderTargetCBlockSize will pick the input size of each block,
+// so compressed blocks are approximately n bytes, including the block header.
+// This is useful for low latency streaming, where the receiver should be able
+// to decode blocks as they arrive.
+// The size is estimated from the compression ratio of the previous blocks,
+// so blocks can be larger when the input becomes harder to compress.
+// Blocks never contain more input than the block size of the Encoder.
+// Smaller blocks reduce the compression ratio.
+// Streams write each block before the next is encoded,
+// so encoding does not overlap with writing.
+// n must be between 1KB and 128KB. If n is 0, blocks are not limited.
+func WithEncoderTargetCBlockSize(n int) EOption {
+	return func(o *encoderOptions) error {
+		if n != 0 && (n < 1<<10 || n > maxCompressedBlockSize) {
+			return fmt.Errorf("target compressed block size must be 0 or between 1KB and 128KB, got %d", n)
+		}
+		o.targetCBlock = n
+		return nil
+	}
+}
+
+// WithEncoderTargetLength sets the match length that is accepted
+// without searching for a longer match.
+// Lower values are faster, but reduce the compression ratio.
+// The option only affects SpeedBestCompression, where the default is 100.
+// n must be between 4 and 128KB. If n is 0, the default is used.
+func WithEncoderTargetLength(n int) EOption {
+	return func(o *encoderOptions) error {
+		if n != 0 && (n < 4 || n > maxCompressedBlockSize) {
+			return fmt.Errorf("target length must be 0 or between 4 and 128KB, got %d", n)
+		}
+		o.targetLength = n
+		return nil
+	}
+}
+
 // WithEncoderDict allows to register a dictionary that will be used for the encode.
 // The encoder *may* choose to use no dictionary instead for certain payloads.
 func WithEncoderDict(dict []byte) EOption {
@@ -307,6 +557,93 @@ func WithEncoderDict(dict []byte) EOption {
 			return err
 		}
 		o.dict = d
+		o.patchFrom = false
+		return nil
+	}
+}
+
+// WithEncoderDictRaw registers content as a raw dictionary.
+// The content is used as initial history and may contain arbitrary data.
+// If id is 0 no dictionary ID is written to the frames,
+// so the decoder must be told which dictionary to use.
+func WithEncoderDictRaw(id uint32, content []byte) EOption {
+	return func(o *encoderOptions) error {
+		d, err := loadRawDict(id, content)
+		if err != nil {
+			return err
+		}
+		o.dict = d
+		o.patchFrom = false
+		return nil
+	}
+}
+
+// WithEncoderPatchFrom uses reference as the base for delta compression,
+// like "zstd --patch-from".
+// Input that is similar to reference, like a new version of a file,
+// will compress to a small delta.
+// The output must be decoded with the same reference using WithDecoderPatchFrom.
+//
+// The reference is used as a raw dictionary, and no dictionary ID is written.
+// Unless WithWindowSize is used, the window size is increased so matches
+// can be found in all of the reference.
+// Matches are searched for as in SpeedBestCompression at all levels,
+// since the faster levels will only find matches near the end of a large reference.
+// The reference can be at most 128MB.
+func WithEncoderPatchFrom(reference []byte) EOption {
+	return func(o *encoderOptions) error {
+		if len(reference) > maxPatchFromSize {
+			return fmt.Errorf("patch reference too big: %d > %d", len(reference), maxPatchFromSize)
+		}
+		d, err := loadRawDict(0, reference)
+		if err != nil {
+			return err
+		}
+		o.dict = d
+		o.patchFrom = true
+		return nil
+	}
+}
+
+// maxPatchFromSize is the largest reference accepted by WithEncoderPatchFrom.
+const maxPatchFromSize = 128 << 20
+
+// patchFromWindow returns the window size to use for a patch reference of n bytes.
+// The window will cover the reference and input of the same size.
+func patchFromWindow(n int) int {
+	w := MinWindowSize
+	for w < 2*n {
+		w <<= 1
+	}
+	return w
+}
+
+// WithEncoderPrefix uses prefix as the history of the first frame of each stream
+// and of each frame written by EncodeAll, like ZSTD_CCtx_refPrefix in the zstd library.
+// Later frames of a stream are encoded without the prefix.
+// Use ResetWithPrefix or EncodeAllWithPrefix to use a different prefix per payload.
+//
+// The prefix is used like a raw dictionary without a dictionary ID,
+// and replaces any dictionary for its frame.
+// This makes it a cheap way to encode the difference to a previous payload.
+// The frame must be decoded with the same prefix, for example using WithDecoderPrefix.
+// An empty prefix is not used.
+func WithEncoderPrefix(prefix []byte) EOption {
+	return func(o *encoderOptions) error {
+		o.prefix = loadPrefix(append([]byte{}, prefix...))
+		return nil
+	}
+}
+
+// WithEncoderSequenceProducer replaces the built-in match finder
+// with sequence producers returned by newProducer.
+// A producer is created for each concurrent encode and for streams,
+// so each producer is only used by one goroutine at a time.
+// The encoder level selects the fallback match finder.
+// Use nil to restore the built-in match finder.
+func WithEncoderSequenceProducer(newProducer func() SequenceProducer) EOption {
+	return func(o *encoderOptions) error {
+		o.producer = newProducer
 		return nil
 	}
 }
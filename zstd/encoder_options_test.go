@@ -1,6 +1,9 @@
 package zstd
 
 import (
+	"bytes"
+	"errors"
+	"math/rand"
 	"strconv"
 	"testing"
 )
@@ -152,3 +155,99 @@ func TestWindowSize(t *testing.T) {
 		})
 	}
 }
+
+func TestEncoderLongWindow(t *testing.T) {
+	if _, err := NewWriter(nil, WithWindowSize(1<<30)); err == nil {
+		t.Fatal("want error for long window without WithEncoderLongWindow")
+	}
+	// Content repeated at a distance larger than the initial history.
+	rng := rand.New(rand.NewSource(1))
+	repeated := make([]byte, 1<<20)
+	rng.Read(repeated)
+	gap := make([]byte, 3<<20)
+	rng.Read(gap)
+	input := append(append(append([]byte{}, repeated...), gap...), repeated...)
+
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			enc, err := NewWriter(&buf, WithEncoderLevel(level), WithWindowSize(MaxWindowSize), WithEncoderLongWindow(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for b := input; len(b) > 0; {
+				n := 100000
+				if n > len(b) {
+					n = len(b)
+				}
+				if _, err := enc.Write(b[:n]); err != nil {
+					t.Fatal(err)
+				}
+				b = b[n:]
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if max := len(input) - len(repeated)/2; buf.Len() > max {
+				t.Errorf("repeated content not found, got %d bytes, want at most %d", buf.Len(), max)
+			}
+			var h Header
+			if err := h.Decode(buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			if h.WindowSize != maxLongWindowSize {
+				t.Errorf("got window size %d, want %d", h.WindowSize, maxLongWindowSize)
+			}
+
+			dec, err := NewReader(nil, WithDecoderMaxWindow(maxEncoderWindowSize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			if err := dec.Reset(bytes.NewReader(buf.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dec.WriteTo(&bytes.Buffer{}); !errors.Is(err, ErrWindowSizeExceeded) {
+				t.Fatalf("got error %v, want %v", err, ErrWindowSizeExceeded)
+			}
+			dec, err = NewReader(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			var got bytes.Buffer
+			if _, err := dec.WriteTo(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), input) {
+				t.Fatal("output mismatch")
+			}
+			all := enc.EncodeAll(input, nil)
+			if max := len(input) - len(repeated)/2; len(all) > max {
+				t.Errorf("EncodeAll: repeated content not found, got %d bytes, want at most %d", len(all), max)
+			}
+			decoded, err := dec.DecodeAll(all, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, input) {
+				t.Fatal("EncodeAll: output mismatch")
+			}
+		})
+	}
+}
+
+func TestWindowDescriptor(t *testing.T) {
+	sizes := []uint32{MinWindowSize, MinWindowSize + 1, 1 << 20, 3 << 20, maxEncoderWindowSize, maxLongWindowSize, maxLongWindowSize + 1, MaxWindowSize}
+	for _, w := range sizes {
+		var h Header
+		b := []byte{0x28, 0xb5, 0x2f, 0xfd, 0, windowDescriptor(w)}
+		if err := h.Decode(b); err != nil {
+			t.Fatal(err)
+		}
+		// The window is at least w, and less than w plus an eighth.
+		if h.WindowSize < uint64(w) || h.WindowSize >= uint64(w)+uint64(w)/8 {
+			t.Errorf("window %d: got %d", w, h.WindowSize)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"time"
+)
+
+// EncoderStats contains statistics of an Encoder,
+// counting both streams and EncodeAll calls.
+type EncoderStats struct {
+	// BytesIn is the number of uncompressed bytes that has been encoded.
+	BytesIn int64
+
+	// BytesOut is the number of compressed bytes output,
+	// including frame headers, checksums and padding.
+	BytesOut int64
+
+	// CompressedBlocks is the number of compressed blocks output.
+	CompressedBlocks int64
+
+	// RawBlocks is the number of blocks stored uncompressed.
+	RawBlocks int64
+
+	// RLEBlocks is the number of blocks encoded as a single repeated byte.
+	RLEBlocks int64
+
+	// EncodeTime is the time spent compressing.
+	// Time spent writing the output is not included.
+	// Concurrent encodes each add their time, so this can exceed the wall time.
+	EncodeTime time.Duration
+}
+
+// Stats returns the statistics of the Encoder since it was created.
+// Blocks of a stream are counted when they have been encoded,
+// so all input of a stream is included after Flush or Close.
+// The statistics of a single stream can be found by subtracting
+// the statistics from before it was started.
+// Stats can be called concurrently with other calls to the Encoder.
+func (e *Encoder) Stats() EncoderStats {
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	return e.stats
+}
+
+// addStats adds the counters of s to the Encoder statistics.
+func (e *Encoder) addStats(s EncoderStats) {
+	e.statsMu.Lock()
+	e.stats.BytesIn += s.BytesIn
+	e.stats.BytesOut += s.BytesOut
+	e.stats.CompressedBlocks += s.CompressedBlocks
+	e.stats.RawBlocks += s.RawBlocks
+	e.stats.RLEBlocks += s.RLEBlocks
+	e.stats.EncodeTime += s.EncodeTime
+	e.statsMu.Unlock()
+}
+
+// addBlock counts the block with the header at the start of b.
+func (s *EncoderStats) addBlock(b []byte) {
+	if len(b) < 3 {
+		return
+	}
+	switch blockType((b[0] >> 1) & 3) {
+	case blockTypeRaw:
+		s.RawBlocks++
+	case blockTypeRLE:
+		s.RLEBlocks++
+	case blockTypeCompressed:
+		s.CompressedBlocks++
+	}
+}
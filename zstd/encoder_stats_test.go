@@ -0,0 +1,91 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestEncoderStats(t *testing.T) {
+	random := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{nil, testSeekableData(1000), testSeekableData(500 << 10), random}
+
+	enc, err := NewWriter(nil, WithEncoderConcurrency(2), WithZeroFrames(true), WithEncoderPadding(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// blocks returns the number of blocks in the frames of b.
+	blocks := func(b []byte) int64 {
+		var n int64
+		fs := Frames(bytes.NewReader(b))
+		for fs.Next() {
+			n += int64(fs.Frame().Blocks)
+		}
+		if err := fs.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	var want EncoderStats
+	var output []byte
+	check := func() {
+		t.Helper()
+		got := enc.Stats()
+		if got.BytesIn != want.BytesIn || got.BytesOut != want.BytesOut {
+			t.Fatalf("got %d bytes in, %d bytes out, want %d, %d", got.BytesIn, got.BytesOut, want.BytesIn, want.BytesOut)
+		}
+		if n := got.CompressedBlocks + got.RawBlocks + got.RLEBlocks; n != blocks(output) {
+			t.Fatalf("got %d blocks, want %d", n, blocks(output))
+		}
+	}
+	for _, in := range inputs {
+		output = enc.EncodeAll(in, output)
+		want.BytesIn += int64(len(in))
+		want.BytesOut = int64(len(output))
+		check()
+
+		var buf bytes.Buffer
+		enc.Reset(&buf)
+		if _, err := enc.Write(in); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, buf.Bytes()...)
+		want.BytesIn += int64(len(in))
+		want.BytesOut = int64(len(output))
+		check()
+	}
+	got := enc.Stats()
+	if got.CompressedBlocks == 0 || got.RawBlocks == 0 {
+		t.Errorf("got %d compressed and %d raw blocks", got.CompressedBlocks, got.RawBlocks)
+	}
+	if got.EncodeTime <= 0 {
+		t.Error("no encode time")
+	}
+
+	// Concurrent EncodeAll and Stats.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				enc.EncodeAll(inputs[1], nil)
+				enc.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := enc.Stats().BytesIn - got.BytesIn; n != 40*int64(len(inputs[1])) {
+		t.Errorf("got %d bytes in, want %d", n, 40*len(inputs[1]))
+	}
+}
@@ -6,6 +6,7 @@ package zstd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,10 +16,11 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
+	"github.com/klauspost/compress/xxhash"
 	"github.com/klauspost/compress/zip"
-	"github.com/klauspost/compress/zstd/internal/xxhash"
 )
 
 var testWindowSizes = []int{MinWindowSize, 1 << 16, 1 << 22, 1 << 24}
@@ -453,6 +455,71 @@ func TestWithEncoderPadding(t *testing.T) {
 		}
 	}
 }
+
+func TestWithEncoderPaddingFunc(t *testing.T) {
+	d, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	rng := rand.New(rand.NewSource(0x1337))
+	for name, fn := range map[string]func(int64) int64{"pow2": PadPowerOfTwo, "padme": PadPadme} {
+		e, err := NewWriter(nil, WithEncoderPaddingFunc(fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			src := make([]byte, rng.Intn(100000)+1)
+			for i := range src {
+				src[i] = uint8(rng.Uint32()) & 7
+			}
+			var buf bytes.Buffer
+			e.Reset(&buf)
+			e.Write(src)
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for _, dst := range [][]byte{e.EncodeAll(src, nil), buf.Bytes()} {
+				if n := int64(len(dst)); fn(n) != n {
+					t.Fatalf("%s: size %d is not padded", name, n)
+				}
+				got, err := d.DecodeAll(dst, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(src, got) {
+					t.Fatal("output mismatch")
+				}
+			}
+		}
+		e.Close()
+	}
+
+	for _, test := range []struct{ in, pow2, padme int64 }{
+		{0, 0, 0}, {1, 1, 1}, {3, 4, 3}, {9, 16, 10}, {1100, 2048, 1152}, {1 << 20, 1 << 20, 1 << 20}, {1<<20 + 1, 1 << 21, 1<<20 + 1<<15},
+	} {
+		if got := PadPowerOfTwo(test.in); got != test.pow2 {
+			t.Errorf("PadPowerOfTwo(%d): got %d, want %d", test.in, got, test.pow2)
+		}
+		if got := PadPadme(test.in); got != test.padme {
+			t.Errorf("PadPadme(%d): got %d, want %d", test.in, got, test.padme)
+		}
+	}
+
+	// Invalid sizes panic.
+	e, err := NewWriter(nil, WithEncoderPaddingFunc(func(size int64) int64 { return size - 1 }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want panic for invalid padded size")
+		}
+	}()
+	e.EncodeAll([]byte("hello"), nil)
+}
+
 func TestEncoder_EncoderXML(t *testing.T) {
 	testEncoderRoundtrip(t, "./testdata/xml.zst", []byte{0x56, 0x54, 0x69, 0x8e, 0x40, 0x50, 0x11, 0xe})
 	testEncoderRoundtripWriter(t, "./testdata/xml.zst", []byte{0x56, 0x54, 0x69, 0x8e, 0x40, 0x50, 0x11, 0xe})
@@ -785,6 +852,404 @@ func TestInterleavedWriteReadFrom(t *testing.T) {
 	}
 }
 
+func TestEncoderDeterministic(t *testing.T) {
+	input := testSeekableData(1 << 20)
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			var wantAll, wantStream []byte
+			for _, conc := range []int{1, 4} {
+				enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(conc),
+					WithEncoderPadding(1000), WithEncoderDeterministic(true))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i := 0; i < 2; i++ {
+					// Previous use must not change the output.
+					enc.EncodeAll(input[i*1000:], nil)
+					got := enc.EncodeAll(input, nil)
+					if wantAll == nil {
+						wantAll = got
+					} else if !bytes.Equal(got, wantAll) {
+						t.Fatalf("concurrency %d: EncodeAll output changed", conc)
+					}
+				}
+				for _, chunk := range []int{1000, 100 << 10, len(input)} {
+					for _, readFrom := range []bool{false, true} {
+						var buf bytes.Buffer
+						enc.Reset(&buf)
+						in := input
+						if readFrom {
+							// Write some data, and read the rest in small pieces.
+							if _, err := enc.Write(in[:chunk]); err != nil {
+								t.Fatal(err)
+							}
+							in = in[chunk:]
+							if _, err := enc.ReadFrom(iotest.HalfReader(bytes.NewReader(in))); err != nil {
+								t.Fatal(err)
+							}
+							in = nil
+						}
+						for len(in) > 0 {
+							n := chunk
+							if n > len(in) {
+								n = len(in)
+							}
+							if _, err := enc.Write(in[:n]); err != nil {
+								t.Fatal(err)
+							}
+							in = in[n:]
+						}
+						if err := enc.Close(); err != nil {
+							t.Fatal(err)
+						}
+						if wantStream == nil {
+							wantStream = buf.Bytes()
+						} else if !bytes.Equal(buf.Bytes(), wantStream) {
+							t.Fatalf("concurrency %d, chunk %d, readfrom %v: stream output changed", conc, chunk, readFrom)
+						}
+					}
+				}
+				enc.Close()
+			}
+			dec, err := NewReader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			for _, b := range [][]byte{wantAll, wantStream} {
+				if len(b)%1000 != 0 {
+					t.Errorf("output size %d not padded", len(b))
+				}
+				got, err := dec.DecodeAll(b, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, input) {
+					t.Fatal("output mismatch")
+				}
+			}
+		})
+	}
+}
+
+func TestEncoderStoreIncompressible(t *testing.T) {
+	random := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(random)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 1<<20; i++ {
+		fmt.Fprintf(&text, "line %d: the quick brown fox jumps over the lazy dog %d times\n", i, i%17)
+	}
+	input := append(append(append([]byte{}, text.Bytes()...), random...), text.Bytes()...)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for level := SpeedFastest; level < speedLast; level++ {
+		t.Run(level.String(), func(t *testing.T) {
+			enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1), WithEncoderStoreIncompressible(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			ref, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ref.Close()
+
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.Write(input); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range [][]byte{enc.EncodeAll(input, nil), buf.Bytes()} {
+				got, err := dec.DecodeAll(b, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, input) {
+					t.Fatal("output mismatch")
+				}
+				// The text must still be compressed.
+				if want := len(ref.EncodeAll(input, nil)); len(b) > want+want/50 {
+					t.Errorf("encoded to %d bytes, %d without storing", len(b), want)
+				}
+			}
+
+			// Expansion is limited to the block headers and frame overhead.
+			encoded := enc.EncodeAll(random, nil)
+			if max := len(random) + 3*(len(random)/(64<<10)+1) + 32; len(encoded) > max {
+				t.Errorf("random data encoded to %d bytes, max %d", len(encoded), max)
+			}
+		})
+	}
+}
+
+func TestEncoderTargetCBlockSize(t *testing.T) {
+	random := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 500<<10; i++ {
+		fmt.Fprintf(&text, "line %d: the quick brown fox jumps over the lazy dog %d times\n", i, i%17)
+	}
+	input := append(append(append([]byte{}, text.Bytes()...), random...), text.Bytes()...)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for _, target := range []int{1 << 10, 4 << 10, 32 << 10} {
+		for level := SpeedFastest; level < speedLast; level++ {
+			t.Run(fmt.Sprintf("%d-%s", target, level), func(t *testing.T) {
+				enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(2), WithEncoderTargetCBlockSize(target))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				var buf bytes.Buffer
+				enc.Reset(&buf)
+				if _, err := enc.Write(input); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Close(); err != nil {
+					t.Fatal(err)
+				}
+				for _, b := range [][]byte{enc.EncodeAll(input, nil), buf.Bytes()} {
+					got, err := dec.DecodeAll(b, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, input) {
+						t.Fatal("output mismatch")
+					}
+					// Block sizes are estimated, but must stay close to the target.
+					sizes := testBlockSizes(t, b)
+					large := 0
+					for _, n := range sizes {
+						if n > 3*target {
+							t.Fatalf("block of %d bytes, target %d: %v", n, target, sizes)
+						}
+						if n > target+target/2 {
+							large++
+						}
+					}
+					if large > len(sizes)/10 {
+						t.Errorf("%d of %d blocks are above target %d: %v", large, len(sizes), target, sizes)
+					}
+				}
+			})
+		}
+	}
+
+	if _, err := NewWriter(nil, WithEncoderTargetCBlockSize(100)); err == nil {
+		t.Error("want error for too small target")
+	}
+}
+
+func TestEncoderTargetLength(t *testing.T) {
+	input := testSeekableData(500 << 10)
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	for _, n := range []int{4, 16, 1000} {
+		enc, err := NewWriter(nil, WithEncoderLevel(SpeedBestCompression), WithEncoderConcurrency(1), WithEncoderTargetLength(n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dec.DecodeAll(enc.EncodeAll(input, nil), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("target length %d: output mismatch", n)
+		}
+		enc.Close()
+	}
+	if _, err := NewWriter(nil, WithEncoderTargetLength(2)); err == nil {
+		t.Error("want error for too small target length")
+	}
+}
+
+// testBlockSizes returns the size of each block in the frames of b, including block headers.
+func testBlockSizes(t *testing.T, b []byte) []int {
+	t.Helper()
+	var sizes []int
+	for len(b) > 0 {
+		if len(b) < 6 {
+			t.Fatal("short frame")
+		}
+		fhd := b[4]
+		size := 5
+		if fhd&(1<<5) == 0 {
+			// Window descriptor
+			size++
+		}
+		size += []int{0, 1, 2, 4}[fhd&3]
+		switch fcs := fhd >> 6; {
+		case fcs != 0:
+			size += 1 << fcs
+		case fhd&(1<<5) != 0:
+			size++
+		}
+		b = b[size:]
+		for {
+			v := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+			cSize := v >> 3
+			if blockType((v>>1)&3) == blockTypeRLE {
+				cSize = 1
+			}
+			sizes = append(sizes, cSize+3)
+			b = b[cSize+3:]
+			if v&1 != 0 {
+				break
+			}
+		}
+		if fhd&(1<<2) != 0 {
+			// Checksum
+			b = b[4:]
+		}
+	}
+	return sizes
+}
+
+func TestCompressBound(t *testing.T) {
+	random := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	opts := map[string][]EOption{
+		"default": nil,
+		"target":  {WithEncoderTargetCBlockSize(1 << 10)},
+		"window":  {WithWindowSize(MinWindowSize)},
+		"lowmem":  {WithLowerEncoderMem(true)},
+		"zerof":   {WithZeroFrames(true)},
+	}
+	for level := SpeedFastest; level < speedLast; level++ {
+		for name, o := range opts {
+			t.Run(fmt.Sprintf("%s-%s", level, name), func(t *testing.T) {
+				enc, err := NewWriter(nil, append(o, WithEncoderLevel(level), WithEncoderConcurrency(1))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer enc.Close()
+				for _, input := range [][]byte{nil, random[:100], random[:100<<10], random, testSeekableData(300 << 10)} {
+					dst := make([]byte, 0, CompressBound(len(input)))
+					// Warm up.
+					enc.EncodeAll(input, dst)
+					var got []byte
+					allocs := testing.AllocsPerRun(2, func() {
+						got = enc.EncodeAll(input, dst)
+					})
+					if allocs > 0 {
+						t.Errorf("size %d: %v allocations", len(input), allocs)
+					}
+					if len(got) > cap(dst) {
+						t.Fatalf("size %d: output %d bytes, bound %d", len(input), len(got), cap(dst))
+					}
+					decoded, err := dec.DecodeAll(got, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decoded, input) {
+						t.Fatalf("size %d: output mismatch", len(input))
+					}
+				}
+			})
+		}
+	}
+	if CompressBound(-1) >= 0 {
+		t.Error("want negative bound for negative size")
+	}
+}
+
+func TestEncoderSingleSegment(t *testing.T) {
+	input := testSeekableData(300 << 10)
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	methods := map[string]func(enc *Encoder, in []byte) []byte{
+		"encodeall": func(enc *Encoder, in []byte) []byte {
+			return enc.EncodeAll(in, nil)
+		},
+		"write": func(enc *Encoder, in []byte) []byte {
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			for len(in) > 0 {
+				n := 50000
+				if n > len(in) {
+					n = len(in)
+				}
+				if _, err := enc.Write(in[:n]); err != nil {
+					t.Fatal(err)
+				}
+				if err := enc.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				in = in[n:]
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+		"readfrom": func(enc *Encoder, in []byte) []byte {
+			var buf bytes.Buffer
+			enc.Reset(&buf)
+			if _, err := enc.ReadFrom(bytes.NewReader(in)); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			return buf.Bytes()
+		},
+	}
+	for _, single := range []bool{false, true} {
+		for name, encode := range methods {
+			for _, size := range []int{0, 100, 1000, len(input)} {
+				t.Run(fmt.Sprintf("%s-%v-%d", name, single, size), func(t *testing.T) {
+					enc, err := NewWriter(nil, WithSingleSegment(single), WithZeroFrames(true), WithEncoderConcurrency(2))
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer enc.Close()
+					got := encode(enc, input[:size])
+					var h Header
+					if err := h.Decode(got); err != nil {
+						t.Fatal(err)
+					}
+					if h.SingleSegment != single {
+						t.Errorf("single segment: got %v, want %v", h.SingleSegment, single)
+					}
+					if single && h.FrameContentSize != uint64(size) {
+						t.Errorf("content size: got %d, want %d", h.FrameContentSize, size)
+					}
+					decoded, err := dec.DecodeAll(got, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decoded, input[:size]) {
+						t.Fatal("output mismatch")
+					}
+				})
+			}
+		}
+	}
+}
+
 func TestEncoder_EncodeAllEmpty(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
@@ -1178,3 +1643,222 @@ func BenchmarkRandomEncoderDefault(b *testing.B) {
 		}
 	}
 }
+
+// countdownCtx is a context that is done after Err has been called n times.
+type countdownCtx struct {
+	context.Context
+	n int
+}
+
+func (c *countdownCtx) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestEncoderContext(t *testing.T) {
+	input := testSeekableData(1 << 20)
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	want := enc.EncodeAll(input, nil)
+	got, err := enc.EncodeAllContext(context.Background(), input, nil)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+
+	dst := []byte("prefix")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err = enc.EncodeAllContext(ctx, input, dst)
+	if err != context.Canceled || !bytes.Equal(got, dst) {
+		t.Fatalf("got %q, error %v", got, err)
+	}
+	// Cancel after the first blocks.
+	got, err = enc.EncodeAllContext(&countdownCtx{Context: context.Background(), n: 3}, input, dst)
+	if err != context.Canceled || !bytes.Equal(got, dst) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+
+	var buf bytes.Buffer
+	enc.ResetContext(&countdownCtx{Context: context.Background(), n: 3}, &buf)
+	_, err = enc.Write(input)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != context.Canceled {
+		t.Fatalf("got stream error %v", err)
+	}
+	if err := enc.Close(); err != context.Canceled {
+		t.Fatalf("got Close error %v", err)
+	}
+
+	// The encoder can be reused.
+	var out bytes.Buffer
+	enc.Reset(&out)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err = dec.DecodeAll(out.Bytes(), nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+}
+
+func TestEncoderIndependentFrames(t *testing.T) {
+	input := testSeekableData(900 << 10)
+	const frameSize = 256 << 10
+	var want []byte
+	for _, conc := range []int{1, 4} {
+		enc, err := NewWriter(nil, WithEncoderConcurrency(conc), WithEncoderIndependentFrames(frameSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		enc.Reset(&buf)
+		for in := input; len(in) > 0; {
+			n := 10000
+			if n > len(in) {
+				n = len(in)
+			}
+			if _, err := enc.Write(in[:n]); err != nil {
+				t.Fatal(err)
+			}
+			in = in[n:]
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got := append([]byte(nil), buf.Bytes()...)
+		if want == nil {
+			want = got
+		} else if !bytes.Equal(got, want) {
+			t.Fatalf("concurrency %d: output differs", conc)
+		}
+
+		// ReadFrom splits the input the same way.
+		buf.Reset()
+		enc.Reset(&buf)
+		if _, err := enc.ReadFrom(iotest.OneByteReader(bytes.NewReader(input[:300<<10]))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := enc.ReadFrom(bytes.NewReader(input[300<<10:])); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("concurrency %d: ReadFrom output differs", conc)
+		}
+		enc.Close()
+	}
+
+	var sizes []uint64
+	fs := Frames(bytes.NewReader(want))
+	for fs.Next() {
+		sizes = append(sizes, fs.Frame().FrameContentSize)
+	}
+	if err := fs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(sizes) != "[262144 262144 262144 135168]" {
+		t.Fatalf("got frame sizes %v", sizes)
+	}
+	dec, err := NewReader(bytes.NewReader(want), WithDecoderConcurrency(4), WithDecodeAllConcurrentFrames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := ioutil.ReadAll(dec)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	got, err = dec.DecodeAll(want, nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+}
+
+func TestEncoderIndependentFramesFlush(t *testing.T) {
+	input := testSeekableData(100 << 10)
+	enc, err := NewWriter(nil, WithEncoderIndependentFrames(64<<10), WithEncoderPadding(1000), WithZeroFrames(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	if _, err := enc.Write(input[:1000]); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(input[1000:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len()%1000 != 0 {
+		t.Fatalf("got %d bytes, want multiple of 1000", buf.Len())
+	}
+	frames := 0
+	fs := Frames(bytes.NewReader(buf.Bytes()))
+	for fs.Next() {
+		if !fs.Frame().Skippable {
+			frames++
+		}
+	}
+	if frames != 3 {
+		t.Fatalf("got %d frames, want 3", frames)
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+
+	// Empty streams write an empty frame, and streams can be abandoned.
+	buf.Reset()
+	enc.Reset(&buf)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dec.DecodeAll(buf.Bytes(), nil); err != nil || len(got) != 0 {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+	enc.Reset(ioutil.Discard)
+	if _, err := enc.Write(input); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	enc.Reset(&buf)
+	if _, err := enc.Write(input[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = dec.DecodeAll(buf.Bytes(), nil)
+	if err != nil || !bytes.Equal(got, input[:10]) {
+		t.Fatalf("got %d bytes, error %v", len(got), err)
+	}
+}
@@ -6,13 +6,14 @@ package zstd
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"hash"
 	"io"
 	"sync"
 
-	"github.com/klauspost/compress/zstd/internal/xxhash"
+	"github.com/klauspost/compress/xxhash"
 )
 
 type frameDec struct {
@@ -38,6 +39,7 @@ type frameDec struct {
 	bBuf byteBuf
 
 	FrameContentSize uint64
+	HasFCS           bool
 	frameDone        sync.WaitGroup
 
 	DictionaryID  *uint32
@@ -63,7 +65,7 @@ var (
 func newFrameDec(o decoderOptions) *frameDec {
 	d := frameDec{
 		o:             o,
-		maxWindowSize: MaxWindowSize,
+		maxWindowSize: o.maxWindowSize,
 	}
 	if d.maxWindowSize > o.maxDecodedSize {
 		d.maxWindowSize = o.maxDecodedSize
@@ -211,6 +213,7 @@ func (d *frameDec) reset(br byteBuffer) error {
 		fcsSize = 1 << v
 	}
 	d.FrameContentSize = 0
+	d.HasFCS = fcsSize > 0
 	if fcsSize > 0 {
 		b, err := br.readSmall(fcsSize)
 		if err != nil {
@@ -338,6 +341,13 @@ func (d *frameDec) checkCRC() error {
 		return err
 	}
 
+	if d.o.checksumFn != nil {
+		d.o.checksumFn(FrameChecksum{
+			Stored:   uint32(want[0]) | uint32(want[1])<<8 | uint32(want[2])<<16 | uint32(want[3])<<24,
+			Computed: got,
+		})
+	}
+
 	if !bytes.Equal(tmp[:], want) {
 		if debugDecoder {
 			println("CRC Check Failed:", tmp[:], "!=", want)
@@ -355,11 +365,16 @@ func (d *frameDec) initAsync() {
 		// set max extra size history to 10MB.
 		d.history.maxSize = d.history.windowSize + maxBlockSize*5
 	}
-	// re-alloc if more than one extra block size.
-	if d.o.lowMem && cap(d.history.b) > d.history.maxSize+maxBlockSize {
+	switch {
+	case d.o.lazyBuffers:
+		// The history grows as it is filled.
+		if cap(d.history.b) > d.history.maxSize {
+			d.history.b = nil
+		}
+	case d.o.lowMem && cap(d.history.b) > d.history.maxSize+maxBlockSize:
+		// re-alloc if more than one extra block size.
 		d.history.b = make([]byte, 0, d.history.maxSize)
-	}
-	if cap(d.history.b) < d.history.maxSize {
+	case cap(d.history.b) < d.history.maxSize:
 		d.history.b = make([]byte, 0, d.history.maxSize)
 	}
 	if cap(d.decoding) < d.o.concurrent {
@@ -380,6 +395,7 @@ func (d *frameDec) initAsync() {
 // containing the remaining input will be sent on frameDec.frameDone.
 func (d *frameDec) startDecoder(output chan decodeOutput) {
 	written := int64(0)
+	started := false
 
 	defer func() {
 		d.asyncRunningMu.Lock()
@@ -445,12 +461,23 @@ func (d *frameDec) startDecoder(output chan decodeOutput) {
 			}
 		}
 		written += int64(len(r.b))
+		if d.o.checkContentSize && d.HasFCS && (uint64(written) > d.FrameContentSize || block.Last && uint64(written) != d.FrameContentSize) {
+			println("startDecoder: decoded", written, "content size", d.FrameContentSize)
+			r.err = ErrFrameSizeMismatch
+			output <- r
+			return
+		}
 		if d.SingleSegment && uint64(written) > d.FrameContentSize {
 			println("runDecoder: single segment and", uint64(written), ">", d.FrameContentSize)
 			r.err = ErrFrameSizeExceeded
 			output <- r
 			return
 		}
+		if !started {
+			r.newFrame = true
+			r.frame = contentSize{size: d.FrameContentSize, known: d.HasFCS}
+			started = true
+		}
 		if block.Last {
 			r.err = d.checkCRC()
 			output <- r
@@ -470,15 +497,24 @@ func (d *frameDec) startDecoder(output chan decodeOutput) {
 }
 
 // runDecoder will create a sync decoder that will decode a block of data.
-func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
+// The output is limited to maxLen bytes, including the content of dst.
+// If limit is not nil, output beyond the reserved bytes is taken from it
+// after each block.
+// mem is the memory reserved from the budget for the frame,
+// which is increased when the output grows beyond it.
+func (d *frameDec) runDecoder(ctx context.Context, dst []byte, dec *blockDec, maxLen uint64, limit *sharedLimit, reserved uint64, mem *int64) ([]byte, error) {
 	saved := d.history.b
 
 	// We use the history for output to avoid copying it.
 	d.history.b = dst
+	d.history.ignoreBuffer = len(dst)
 	// Store input length, so we only check new data.
 	crcStart := len(dst)
 	var err error
 	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
 		err = dec.reset(d.rawInput, d.WindowSize)
 		if err != nil {
 			break
@@ -487,6 +523,30 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 			println("next block:", dec)
 		}
 		err = dec.decodeBuf(&d.history)
+		if err == nil && uint64(len(d.history.b)) > maxLen {
+			err = ErrDecompressedSizeExceeded
+		}
+		if err == nil && limit != nil {
+			if n := uint64(len(d.history.b) - crcStart); n > reserved {
+				if !limit.take(n - reserved) {
+					err = errSharedLimit
+				}
+				reserved = n
+			}
+		}
+		if err == nil && d.o.memBudget != nil {
+			// The output is the history, so it is charged when it exceeds the reservation.
+			if n := int64(len(d.history.b) - crcStart); n > *mem {
+				if err = d.o.memBudget.grow(n - *mem); err == nil {
+					*mem = n
+				}
+			}
+		}
+		if err == nil && d.o.checkContentSize && d.HasFCS {
+			if n := uint64(len(d.history.b) - crcStart); n > d.FrameContentSize || dec.Last && n != d.FrameContentSize {
+				err = ErrFrameSizeMismatch
+			}
+		}
 		if err != nil || dec.Last {
 			break
 		}
@@ -501,6 +561,9 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 		}
 	}
 	dst = d.history.b
+	if err == ErrDecompressedSizeExceeded {
+		dst = dst[:maxLen]
+	}
 	if err == nil {
 		if d.HasCheckSum {
 			var n int
@@ -515,5 +578,6 @@ func (d *frameDec) runDecoder(dst []byte, dec *blockDec) ([]byte, error) {
 		}
 	}
 	d.history.b = saved
+	d.history.ignoreBuffer = 0
 	return dst, err
 }
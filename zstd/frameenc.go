@@ -64,9 +64,7 @@ func (f frameHeader) appendTo(dst []byte) ([]byte, error) {
 
 	dst = append(dst, fhd)
 	if !f.SingleSegment {
-		const winLogMin = 10
-		windowLog := (bits.Len32(f.WindowSize-1) - winLogMin) << 3
-		dst = append(dst, uint8(windowLog))
+		dst = append(dst, windowDescriptor(f.WindowSize))
 	}
 	if f.DictID > 0 {
 		dst = append(dst, dictIDContent...)
@@ -91,8 +89,45 @@ func (f frameHeader) appendTo(dst []byte) ([]byte, error) {
 	return dst, nil
 }
 
+// windowDescriptor returns the frame header window descriptor
+// of the smallest window of at least w bytes.
+func windowDescriptor(w uint32) uint8 {
+	const winLogMin = 10
+	windowLog := bits.Len32(w - 1)
+	if windowLog > winLogMin {
+		// Use the smaller exponent with a mantissa, if it is large enough.
+		base := uint32(1) << uint(windowLog-1)
+		step := base / 8
+		if m := (w - base + step - 1) / step; m < 8 {
+			return uint8(windowLog-1-winLogMin)<<3 | uint8(m)
+		}
+	}
+	return uint8(windowLog-winLogMin) << 3
+}
+
 const skippableFrameHeader = 4 + 4
 
+// paddingSize returns the size of the skippable frame to add
+// after written bytes of output, or 0 for no padding.
+// The function will panic if the padding function returns an invalid size.
+func (o *encoderOptions) paddingSize(written int64) int {
+	if o.padFn == nil {
+		if o.pad <= 0 {
+			return 0
+		}
+		return calcSkippableFrame(written, int64(o.pad))
+	}
+	total := o.padFn(written)
+	if total > written && total-written < skippableFrameHeader {
+		// Make room for the frame header.
+		total = o.padFn(written + skippableFrameHeader)
+	}
+	if total < written || total-written > 1<<30 {
+		panic(fmt.Sprintf("padding function returned %d for size %d", total, written))
+	}
+	return int(total - written)
+}
+
 // calcSkippableFrame will return a total size to be added for written
 // to be divisible by multiple.
 // The value will always be > skippableFrameHeader.
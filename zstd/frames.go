@@ -0,0 +1,253 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// FrameInfo contains information about a frame in a stream.
+type FrameInfo struct {
+	// Header of the frame.
+	// For single segment frames WindowSize is set to FrameContentSize.
+	// For skippable frames only Skippable is set.
+	Header
+
+	// Offset of the frame in the stream.
+	Offset int64
+
+	// CompressedSize is the size of the entire frame,
+	// including the headers and the checksum.
+	CompressedSize int64
+
+	// Blocks is the number of blocks in the frame.
+	Blocks int
+
+	// Checksum is the content checksum stored in the frame, if HasCheckSum is set.
+	Checksum uint32
+}
+
+// FrameChecksum contains the content checksum of a decoded frame.
+type FrameChecksum struct {
+	// Stored is the checksum stored in the frame,
+	// which is the lower 32 bits of the XXH64 of the content.
+	Stored uint32
+
+	// Computed is the XXH64 of the decoded content.
+	Computed uint64
+}
+
+// Match returns whether the stored checksum matches the decoded content.
+func (c FrameChecksum) Match() bool {
+	return c.Stored == uint32(c.Computed)
+}
+
+// FrameScanner reads information about the frames of a stream
+// without decompressing them.
+//
+// Use Frames to create a FrameScanner. Calls to Next advance to the next frame,
+// until the end of the stream is reached or an error occurs:
+//
+//	fs := zstd.Frames(r)
+//	for fs.Next() {
+//		info := fs.Frame()
+//		...
+//	}
+//	if err := fs.Err(); err != nil {
+//		...
+//	}
+type FrameScanner struct {
+	r     io.Reader
+	off   int64
+	end   int64 // Size of a seekable stream, or -1.
+	frame FrameInfo
+	err   error
+	buf   [4 + 1 + 1 + 4 + 8 + 3]byte // Largest frame header and a block header.
+
+	// blocks is the offsets of the block headers of the frame, if recordBlocks is set.
+	blocks       []int64
+	recordBlocks bool
+}
+
+// Frames returns a FrameScanner reading the frames of the stream r.
+// The compressed data is read, but not decompressed.
+// If r is a *bytes.Reader, *io.SectionReader or similar,
+// only the headers are read and the compressed data is skipped by seeking.
+func Frames(r io.Reader) *FrameScanner {
+	f := &FrameScanner{r: r, end: -1}
+	if s, ok := r.(sizeSeeker); ok {
+		if pos, err := s.Seek(0, io.SeekCurrent); err == nil {
+			f.end = s.Size() - pos
+		}
+	}
+	return f
+}
+
+// sizeSeeker is a stream with a known size that supports seeking.
+type sizeSeeker interface {
+	io.Seeker
+	Size() int64
+}
+
+// Next advances to the next frame, which is then available through Frame.
+// It returns false when the end of the stream is reached, or on an error.
+func (f *FrameScanner) Next() bool {
+	if f.err != nil {
+		return false
+	}
+	if err := f.next(); err != nil {
+		f.err = err
+		return false
+	}
+	return true
+}
+
+// Frame returns the frame read by the last call to Next.
+func (f *FrameScanner) Frame() FrameInfo {
+	return f.frame
+}
+
+// Err returns the first error that was encountered,
+// or nil if the end of the stream was reached.
+func (f *FrameScanner) Err() error {
+	if f.err == io.EOF {
+		return nil
+	}
+	return f.err
+}
+
+// next reads the next frame.
+func (f *FrameScanner) next() error {
+	start := f.off
+	f.frame = FrameInfo{Offset: start}
+	f.blocks = f.blocks[:0]
+	magic := f.buf[:4]
+	if n, err := io.ReadFull(f.r, magic); err != nil {
+		if n == 0 && err == io.EOF {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	}
+	f.off += 4
+
+	if !bytes.Equal(magic, frameMagic) {
+		if !bytes.Equal(magic[1:4], skippableFrameMagic) || magic[0]&0xf0 != 0x50 {
+			return ErrMagicMismatch
+		}
+		if err := f.read(f.buf[4:8]); err != nil {
+			return err
+		}
+		if err := f.skip(int64(binary.LittleEndian.Uint32(f.buf[4:8]))); err != nil {
+			return err
+		}
+		f.frame.Skippable = true
+		f.frame.CompressedSize = f.off - start
+		return nil
+	}
+
+	// Read the rest of the frame header.
+	if err := f.read(f.buf[4:5]); err != nil {
+		return err
+	}
+	fhd := f.buf[4]
+	size := 0
+	if fhd&(1<<5) == 0 {
+		// Window_Descriptor
+		size++
+	}
+	size += [4]int{0, 1, 2, 4}[fhd&3]
+	switch fcs := fhd >> 6; {
+	case fcs != 0:
+		size += 1 << fcs
+	case fhd&(1<<5) != 0:
+		size++
+	}
+	// Read the first block header as well.
+	hdr := f.buf[:5+size+3]
+	if err := f.read(hdr[5:]); err != nil {
+		return err
+	}
+	if err := f.frame.Header.Decode(hdr); err != nil {
+		return err
+	}
+	if f.frame.SingleSegment {
+		f.frame.WindowSize = f.frame.FrameContentSize
+	}
+
+	// Skip the blocks.
+	bh := hdr[len(hdr)-3:]
+	for {
+		if f.recordBlocks {
+			f.blocks = append(f.blocks, f.off-3)
+		}
+		v := uint32(bh[0]) | uint32(bh[1])<<8 | uint32(bh[2])<<16
+		f.frame.Blocks++
+		cSize := int64(v >> 3)
+		switch blockType((v >> 1) & 3) {
+		case blockTypeReserved:
+			return ErrReservedBlockType
+		case blockTypeRLE:
+			cSize = 1
+		}
+		if cSize > maxCompressedBlockSize {
+			return ErrCompressedSizeTooBig
+		}
+		if err := f.skip(cSize); err != nil {
+			return err
+		}
+		if v&1 != 0 {
+			break
+		}
+		bh = f.buf[:3]
+		if err := f.read(bh); err != nil {
+			return err
+		}
+	}
+	if f.frame.HasCheckSum {
+		if err := f.read(f.buf[:4]); err != nil {
+			return err
+		}
+		f.frame.Checksum = binary.LittleEndian.Uint32(f.buf[:4])
+	}
+	f.frame.CompressedSize = f.off - start
+	return nil
+}
+
+// read reads len(b) bytes of the frame.
+func (f *FrameScanner) read(b []byte) error {
+	n, err := io.ReadFull(f.r, b)
+	f.off += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// skip skips n bytes of the frame.
+func (f *FrameScanner) skip(n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if f.end >= 0 {
+		var err error
+		if f.off+n > f.end {
+			n = f.end - f.off
+			err = io.ErrUnexpectedEOF
+		}
+		if _, serr := f.r.(io.Seeker).Seek(n, io.SeekCurrent); serr != nil {
+			return serr
+		}
+		f.off += n
+		return err
+	}
+	copied, err := io.CopyN(ioutil.Discard, f.r, n)
+	f.off += copied
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
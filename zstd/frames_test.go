@@ -0,0 +1,200 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/xxhash"
+)
+
+func TestFrames(t *testing.T) {
+	input := testSeekableData(300 << 10)
+
+	// A single segment frame with content size and checksum.
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := enc.EncodeAll(input, nil)
+	enc.Close()
+
+	// A streamed frame without checksum.
+	var streamed bytes.Buffer
+	enc, err = NewWriter(&streamed, WithEncoderCRC(false), WithWindowSize(1<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Write(input)
+	enc.Close()
+
+	skippable := []byte{0x50, 0x2a, 0x4d, 0x18, 3, 0, 0, 0, 1, 2, 3}
+
+	var stream []byte
+	stream = append(stream, first...)
+	stream = append(stream, skippable...)
+	stream = append(stream, streamed.Bytes()...)
+
+	var frames []FrameInfo
+	fs := Frames(bytes.NewReader(stream))
+	for fs.Next() {
+		frames = append(frames, fs.Frame())
+	}
+	if err := fs.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+
+	f := frames[0]
+	if f.Offset != 0 || f.CompressedSize != int64(len(first)) {
+		t.Fatalf("frame 0: offset %d, size %d, want 0, %d", f.Offset, f.CompressedSize, len(first))
+	}
+	if !f.HasFCS || f.FrameContentSize != uint64(len(input)) || !f.HasCheckSum || f.Skippable {
+		t.Fatalf("frame 0: unexpected header %+v", f.Header)
+	}
+	if f.SingleSegment && f.WindowSize != f.FrameContentSize {
+		t.Fatalf("frame 0: window size %d", f.WindowSize)
+	}
+	if f.Blocks < 3 || !f.FirstBlock.OK {
+		t.Fatalf("frame 0: %d blocks", f.Blocks)
+	}
+	if f.Checksum != uint32(xxhash.Sum64(input)) {
+		t.Fatalf("frame 0: checksum %x", f.Checksum)
+	}
+
+	f = frames[1]
+	if !f.Skippable || f.Offset != int64(len(first)) || f.CompressedSize != int64(len(skippable)) {
+		t.Fatalf("frame 1: unexpected %+v", f)
+	}
+
+	f = frames[2]
+	if f.Offset != int64(len(first)+len(skippable)) || f.CompressedSize != int64(streamed.Len()) {
+		t.Fatalf("frame 2: offset %d, size %d", f.Offset, f.CompressedSize)
+	}
+	if f.HasCheckSum || f.WindowSize != 1<<20 || f.DictionaryID != 0 {
+		t.Fatalf("frame 2: unexpected header %+v", f.Header)
+	}
+
+	// Frames of the seekable format.
+	seekable := seekableTestStream(t, input, 100<<10)
+	n := 0
+	for fs := Frames(bytes.NewReader(seekable)); fs.Next(); n++ {
+		if f := fs.Frame(); f.Skippable != (n == 3) {
+			t.Fatalf("frame %d: skippable %v", n, f.Skippable)
+		}
+	}
+	if n != 4 {
+		t.Fatalf("got %d seekable frames, want 4", n)
+	}
+}
+
+func TestFramesInvalid(t *testing.T) {
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := enc.EncodeAll(testSeekableData(10000), nil)
+	enc.Close()
+
+	for i := 1; i < len(frame); i++ {
+		// Test both seeking and reading the compressed data.
+		for _, r := range []io.Reader{bytes.NewReader(frame[:i]), struct{ io.Reader }{bytes.NewReader(frame[:i])}} {
+			fs := Frames(r)
+			if fs.Next() {
+				t.Fatalf("truncated to %d: got frame", i)
+			}
+			if err := fs.Err(); err != io.ErrUnexpectedEOF {
+				t.Fatalf("truncated to %d: got %v, want io.ErrUnexpectedEOF", i, err)
+			}
+		}
+	}
+
+	fs := Frames(bytes.NewReader([]byte("not a zstd stream")))
+	if fs.Next() || fs.Err() != ErrMagicMismatch {
+		t.Fatalf("got %v, want ErrMagicMismatch", fs.Err())
+	}
+
+	fs = Frames(bytes.NewReader(nil))
+	if fs.Next() || fs.Err() != nil {
+		t.Fatalf("empty stream: got %v", fs.Err())
+	}
+}
+
+func TestDecoderChecksumFunc(t *testing.T) {
+	input := testSeekableData(300 << 10)
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := enc.EncodeAll(input[:1000], nil)
+	stream := enc.EncodeAll(input, append([]byte{}, first...))
+	enc.Close()
+	enc, err = NewWriter(nil, WithEncoderCRC(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream = enc.EncodeAll(input, stream)
+	enc.Close()
+	want := []FrameChecksum{
+		{Stored: uint32(xxhash.Sum64(input[:1000])), Computed: xxhash.Sum64(input[:1000])},
+		{Stored: uint32(xxhash.Sum64(input)), Computed: xxhash.Sum64(input)},
+	}
+
+	var mu sync.Mutex
+	var got []FrameChecksum
+	dec, err := NewReader(nil, WithDecoderChecksumFunc(func(c FrameChecksum) {
+		mu.Lock()
+		got = append(got, c)
+		mu.Unlock()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	check := func(name string, want []FrameChecksum) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d checksums, want %d", name, len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s: checksum %d: got %+v, want %+v", name, i, got[i], want[i])
+			}
+		}
+		got = nil
+	}
+
+	if _, err := dec.DecodeAll(stream, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("DecodeAll", want)
+	if err := dec.Reset(ioutil.NopCloser(bytes.NewReader(stream))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(dec); err != nil {
+		t.Fatal(err)
+	}
+	check("stream", want)
+
+	// Mismatches are reported before the error.
+	corrupt := append([]byte{}, first...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := dec.DecodeAll(corrupt, nil); err != ErrCRCMismatch {
+		t.Fatalf("got %v, want ErrCRCMismatch", err)
+	}
+	bad := want[0]
+	bad.Stored ^= 0xff << 24
+	if bad.Match() {
+		t.Fatal("corrupt checksum matches")
+	}
+	check("corrupt", []FrameChecksum{bad})
+}
@@ -18,6 +18,9 @@ type history struct {
 	maxSize       int
 	error         bool
 	dict          *dict
+	// ignoreBuffer is the number of bytes at the start of b
+	// that were output by previous frames, which matches cannot reference.
+	ignoreBuffer int
 }
 
 // reset will reset the history to initial state of a frame.
@@ -64,6 +67,9 @@ func (h *history) setDict(dict *dict) {
 func (h *history) append(b []byte) {
 	if len(b) >= h.windowSize {
 		// Discard all history by simply overwriting
+		if cap(h.b) < h.windowSize {
+			h.b = make([]byte, 0, h.windowSize)
+		}
 		h.b = h.b[:h.windowSize]
 		copy(h.b, b[len(b)-h.windowSize:])
 		return
@@ -75,6 +81,18 @@ func (h *history) append(b []byte) {
 		return
 	}
 
+	// Grow buffers that are allocated lazily, up to the maximum size.
+	if n := len(h.b) + len(b); cap(h.b) < h.maxSize && n <= h.maxSize {
+		size := 2 * n
+		if size > h.maxSize {
+			size = h.maxSize
+		}
+		grown := make([]byte, len(h.b), size)
+		copy(grown, h.b)
+		h.b = append(grown, b...)
+		return
+	}
+
 	// Move data down so we only have window size left.
 	// We know we have less than window size in b at this point.
 	discard := len(b) + len(h.b) - h.windowSize
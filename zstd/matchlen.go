@@ -0,0 +1,15 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+// Vector instructions used to extend matches of the default and better levels.
+const (
+	matchVecNone uint8 = iota
+	matchVecAVX2
+	matchVecAVX512
+)
+
+// matchVecMin is the minimum remaining input length
+// where matches are extended with vector instructions.
+const matchVecMin = 32
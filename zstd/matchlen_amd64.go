@@ -0,0 +1,61 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !appengine
+// +build !noasm
+// +build gc
+
+package zstd
+
+import (
+	"math/bits"
+
+	"github.com/klauspost/compress/cpuinfo"
+)
+
+// matchVecLevel returns the widest vector instructions
+// that are available to extend matches.
+func matchVecLevel() uint8 {
+	if !cpuinfo.AsmZstd.Enabled() {
+		return matchVecNone
+	}
+	if cpuinfo.Has(cpuinfo.AVX512F) && cpuinfo.Has(cpuinfo.AVX512BW) {
+		return matchVecAVX512
+	}
+	if cpuinfo.Has(cpuinfo.AVX2) {
+		return matchVecAVX2
+	}
+	return matchVecNone
+}
+
+// matchLenVec returns the same as matchLen,
+// but extends long matches with the vector instructions of level.
+// a must be the shortest of the two.
+func matchLenVec(a, b []byte, level uint8) int {
+	if level == matchVecNone || len(a) < matchVecMin {
+		return matchLen(a, b)
+	}
+	// Most matches are short, so check the first bytes before calling assembly.
+	if diff := load64(a, 0) ^ load64(b, 0); diff != 0 {
+		return bits.TrailingZeros64(diff) >> 3
+	}
+	a, b = a[8:], b[8:len(a)]
+	if level == matchVecAVX512 {
+		return 8 + matchLenAVX512(a, b)
+	}
+	return 8 + matchLenAVX2(a, b)
+}
+
+// matchLenAVX2 returns the number of matching bytes at the start of a and b,
+// comparing 32 bytes at the time.
+// b must be at least as long as a.
+//
+//go:noescape
+func matchLenAVX2(a, b []byte) int
+
+// matchLenAVX512 returns the number of matching bytes at the start of a and b,
+// comparing 64 bytes at the time.
+// b must be at least as long as a.
+//
+//go:noescape
+func matchLenAVX512(a, b []byte) int
@@ -0,0 +1,140 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !appengine
+// +build !noasm
+// +build gc
+
+#include "textflag.h"
+
+// Register allocation:
+// SI	a
+// DI	b
+// DX	len(a)
+// AX	matched bytes
+// BX	loop end
+// CX	tmp
+
+// func matchLenAVX2(a, b []byte) int
+TEXT ·matchLenAVX2(SB), NOSPLIT, $0-56
+	MOVQ a_base+0(FP), SI
+	MOVQ a_len+8(FP), DX
+	MOVQ b_base+24(FP), DI
+	XORQ AX, AX
+	MOVQ DX, BX
+	SUBQ $32, BX
+	JL   avx2_tail
+
+avx2_loop32:
+	VMOVDQU   (SI)(AX*1), Y0
+	VPCMPEQB  (DI)(AX*1), Y0, Y0
+	VPMOVMSKB Y0, CX
+	NOTL      CX
+	TESTL     CX, CX
+	JNZ       avx2_found32
+	ADDQ      $32, AX
+	CMPQ      AX, BX
+	JLE       avx2_loop32
+	VZEROUPPER
+
+avx2_tail:
+	// Compare the remaining bytes, 8 bytes at the time and then single bytes.
+	MOVQ DX, BX
+	SUBQ $8, BX
+	CMPQ AX, BX
+	JG   avx2_bytes
+
+avx2_loop8:
+	MOVQ (SI)(AX*1), CX
+	XORQ (DI)(AX*1), CX
+	JNZ  avx2_found8
+	ADDQ $8, AX
+	CMPQ AX, BX
+	JLE  avx2_loop8
+
+avx2_bytes:
+	CMPQ AX, DX
+	JGE  avx2_done
+	MOVB (SI)(AX*1), CL
+	CMPB CL, (DI)(AX*1)
+	JNE  avx2_done
+	INCQ AX
+	JMP  avx2_bytes
+
+avx2_found8:
+	BSFQ CX, CX
+	SHRQ $3, CX
+	ADDQ CX, AX
+
+avx2_done:
+	MOVQ AX, ret+48(FP)
+	RET
+
+avx2_found32:
+	VZEROUPPER
+	BSFL CX, CX
+	ADDQ CX, AX
+	MOVQ AX, ret+48(FP)
+	RET
+
+// func matchLenAVX512(a, b []byte) int
+TEXT ·matchLenAVX512(SB), NOSPLIT, $0-56
+	MOVQ a_base+0(FP), SI
+	MOVQ a_len+8(FP), DX
+	MOVQ b_base+24(FP), DI
+	XORQ AX, AX
+	MOVQ DX, BX
+	SUBQ $64, BX
+	JL   avx512_tail
+
+avx512_loop64:
+	VMOVDQU8 (SI)(AX*1), Z0
+	VPCMPEQB (DI)(AX*1), Z0, K1
+	KMOVQ    K1, CX
+	NOTQ     CX
+	TESTQ    CX, CX
+	JNZ      avx512_found64
+	ADDQ     $64, AX
+	CMPQ     AX, BX
+	JLE      avx512_loop64
+	VZEROUPPER
+
+avx512_tail:
+	// Compare the remaining bytes, 8 bytes at the time and then single bytes.
+	MOVQ DX, BX
+	SUBQ $8, BX
+	CMPQ AX, BX
+	JG   avx512_bytes
+
+avx512_loop8:
+	MOVQ (SI)(AX*1), CX
+	XORQ (DI)(AX*1), CX
+	JNZ  avx512_found8
+	ADDQ $8, AX
+	CMPQ AX, BX
+	JLE  avx512_loop8
+
+avx512_bytes:
+	CMPQ AX, DX
+	JGE  avx512_done
+	MOVB (SI)(AX*1), CL
+	CMPB CL, (DI)(AX*1)
+	JNE  avx512_done
+	INCQ AX
+	JMP  avx512_bytes
+
+avx512_found8:
+	BSFQ CX, CX
+	SHRQ $3, CX
+	ADDQ CX, AX
+
+avx512_done:
+	MOVQ AX, ret+48(FP)
+	RET
+
+avx512_found64:
+	VZEROUPPER
+	BSFQ CX, CX
+	ADDQ CX, AX
+	MOVQ AX, ret+48(FP)
+	RET
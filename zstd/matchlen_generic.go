@@ -0,0 +1,17 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// +build !amd64 appengine !gc noasm
+
+package zstd
+
+// matchVecLevel returns the widest vector instructions
+// that are available to extend matches.
+func matchVecLevel() uint8 {
+	return matchVecNone
+}
+
+// matchLenVec returns the same as matchLen.
+func matchLenVec(a, b []byte, level uint8) int {
+	return matchLen(a, b)
+}
@@ -0,0 +1,175 @@
+// Copyright 2021+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/cpuinfo"
+)
+
+func TestMatchLenVec(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a := make([]byte, 1000)
+	rng.Read(a)
+	b := make([]byte, len(a)+10)
+	for level := matchVecNone; level <= matchVecLevel(); level++ {
+		for n := 0; n <= len(a); n += 1 + n/32 {
+			for diff := 0; diff <= n; diff += 1 + diff/16 {
+				copy(b, a)
+				if diff < n {
+					b[diff]++
+				}
+				got := matchLenVec(a[:n], b, level)
+				want := matchLen(a[:n], b)
+				if got != want || want != diff {
+					t.Fatalf("level %d, len %d, diff at %d: got %d, want %d", level, n, diff, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncoderMatchVecOutput(t *testing.T) {
+	if matchVecLevel() == matchVecNone {
+		t.Skip("no vector instructions to extend matches")
+	}
+	defer cpuinfo.EnableAsm(cpuinfo.AsmZstd)
+	in := testMatchVecData(1 << 20)
+	for _, level := range []EncoderLevel{SpeedDefault, SpeedBetterCompression} {
+		t.Run(level.String(), func(t *testing.T) {
+			cpuinfo.EnableAsm(cpuinfo.AsmZstd)
+			enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enc.Close()
+			vec := enc.EncodeAll(in, nil)
+			cpuinfo.DisableAsm(cpuinfo.AsmZstd)
+			noVec := enc.EncodeAll(in, nil)
+			if !bytes.Equal(vec, noVec) {
+				t.Fatalf("output differs with vector match extension: %d != %d bytes", len(vec), len(noVec))
+			}
+			dec, err := NewReader(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dec.Close()
+			got, err := dec.DecodeAll(vec, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, in) {
+				t.Fatal("decoded output mismatch")
+			}
+		})
+	}
+}
+
+// testMatchVecData returns n bytes with matches of varying length.
+func testMatchVecData(n int) []byte {
+	rng := rand.New(rand.NewSource(0))
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		if len(b) < 1024 || rng.Intn(4) == 0 {
+			b = append(b, byte(rng.Intn(256)))
+			continue
+		}
+		off := 1 + rng.Intn(len(b))
+		l := 4 + rng.Intn(1000)
+		for i := 0; i < l && len(b) < n; i++ {
+			b = append(b, b[len(b)-off])
+		}
+	}
+	return b
+}
+
+// testLongMatchData returns n bytes of random blocks that are repeated
+// with small changes, which gives matches of several KB.
+func testLongMatchData(n int) []byte {
+	rng := rand.New(rand.NewSource(0))
+	block := make([]byte, 64<<10)
+	rng.Read(block)
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		block[rng.Intn(len(block))]++
+		b = append(b, block...)
+	}
+	return b[:n]
+}
+
+func BenchmarkMatchLenVec(b *testing.B) {
+	for _, n := range []int{16, 64, 256, 4096} {
+		a := bytes.Repeat([]byte{'a'}, n+8)
+		for level := matchVecNone; level <= matchVecLevel(); level++ {
+			b.Run(fmt.Sprintf("len-%d-vec-%d", n, level), func(b *testing.B) {
+				b.SetBytes(int64(n))
+				for i := 0; i < b.N; i++ {
+					matchLenVec(a[:n], a[8:], level)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkEncoderMatchVec compares the encoder speed with and without
+// vector match extension.
+func BenchmarkEncoderMatchVec(b *testing.B) {
+	if matchVecLevel() == matchVecNone {
+		b.Skip("no vector instructions to extend matches")
+	}
+	f, err := os.Open("testdata/xml.zst")
+	if err != nil {
+		b.Fatal(err)
+	}
+	dec, err := NewReader(f)
+	if err != nil {
+		b.Fatal(err)
+	}
+	xml, err := ioutil.ReadAll(dec)
+	dec.Close()
+	f.Close()
+	if err != nil {
+		b.Fatal(err)
+	}
+	inputs := []struct {
+		name string
+		b    []byte
+	}{
+		{name: "xml", b: xml},
+		{name: "matches", b: testMatchVecData(4 << 20)},
+		{name: "long", b: testLongMatchData(4 << 20)},
+	}
+	defer cpuinfo.EnableAsm(cpuinfo.AsmZstd)
+	for _, in := range inputs {
+		for _, level := range []EncoderLevel{SpeedDefault, SpeedBetterCompression} {
+			for _, vec := range []bool{false, true} {
+				b.Run(fmt.Sprintf("%s-%s-vec-%v", in.name, level, vec), func(b *testing.B) {
+					if vec {
+						cpuinfo.EnableAsm(cpuinfo.AsmZstd)
+					} else {
+						cpuinfo.DisableAsm(cpuinfo.AsmZstd)
+					}
+					enc, err := NewWriter(nil, WithEncoderLevel(level), WithEncoderConcurrency(1))
+					if err != nil {
+						b.Fatal(err)
+					}
+					defer enc.Close()
+					dst := enc.EncodeAll(in.b, nil)
+					b.ReportAllocs()
+					b.SetBytes(int64(len(in.b)))
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						dst = enc.EncodeAll(in.b, dst[:0])
+					}
+				})
+			}
+		}
+	}
+}
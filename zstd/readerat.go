@@ -0,0 +1,306 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// readerAtFrameSize is the largest compressed frame that DecodeReaderAt
+// will decode concurrently with other frames.
+// The blocks of larger frames are read concurrently.
+const readerAtFrameSize = 4 << 20
+
+// DecodeReaderAt decodes size bytes of compressed data from r and writes the output to w.
+// The number of bytes written to w is returned.
+//
+// The frames and blocks of the input are scanned before anything is decoded,
+// so truncated input or invalid block headers are reported before any output is written.
+// Only the headers are read by the scan.
+//
+// Frames are independent, so frames up to 4MB compressed are decoded concurrently.
+// Blocks within a frame depend on the output and entropy tables of the previous blocks,
+// so larger frames are split at their blocks, which are read concurrently ahead of the output.
+// The literals and tables of the blocks are decoded concurrently as they are read,
+// and the sequences are executed in order.
+// The Decoder concurrency limits will be respected.
+// The prefix set by WithDecoderPrefix is used for the first frame.
+//
+// The limit set by WithDecoderMaxDecompressedSize applies to the output of the call.
+// As with DecodeAll, frames that state a larger content size than what is left are not decoded,
+// and other output is written up to the limit before ErrDecompressedSizeExceeded is returned.
+// Frames decoded concurrently are kept in memory until they are written,
+// and reserve memory from the decoder budget, if one is set.
+//
+// DecodeReaderAt can be used concurrently, and while a stream is being decoded.
+func (d *Decoder) DecodeReaderAt(r io.ReaderAt, size int64, w io.Writer) (int64, error) {
+	if d.current.err == ErrDecoderClosed {
+		return 0, ErrDecoderClosed
+	}
+	var frames []FrameInfo
+	// blocks has the offsets of the block headers of the frames that are split.
+	blocks := make(map[int64][]int64)
+	fs := Frames(io.NewSectionReader(r, 0, size))
+	fs.recordBlocks = true
+	for fs.Next() {
+		f := fs.Frame()
+		if f.Skippable {
+			continue
+		}
+		frames = append(frames, f)
+		if f.CompressedSize > readerAtFrameSize {
+			blocks[f.Offset] = append([]int64{}, fs.blocks...)
+		}
+	}
+	if err := fs.Err(); err != nil {
+		return 0, err
+	}
+
+	// The output limit is shared by the frames decoded concurrently.
+	// One more byte is allowed, so output of exactly the limit is not exceeded.
+	limit := newSharedLimit(d.o.maxOutputSize + 1)
+	// The prefix is only used for the first frame.
+	prefix := d.o.prefix
+	var written int64
+	for len(frames) > 0 {
+		left := d.o.maxOutputSize - uint64(written)
+		if frames[0].FrameContentSize > left {
+			// Like DecodeAll, frames that state a larger content size are not decoded.
+			return written, ErrDecompressedSizeExceeded
+		}
+		if frames[0].CompressedSize > readerAtFrameSize {
+			n, err := d.decodeFrameBlocksAt(r, frames[0], blocks[frames[0].Offset], w, left, prefix)
+			written += n
+			if err != nil {
+				return written, err
+			}
+			limit.take(uint64(n))
+			frames, prefix = frames[1:], nil
+			continue
+		}
+		n := 0
+		for n < len(frames) && n < d.o.concurrent && frames[n].CompressedSize <= readerAtFrameSize {
+			n++
+		}
+		n2, err := d.decodeFramesAt(r, frames[:n], w, limit, left, prefix)
+		written += n2
+		if err != nil {
+			return written, err
+		}
+		frames, prefix = frames[n:], nil
+	}
+	return written, nil
+}
+
+// decodeFramesAt decodes frames concurrently and writes the output to w in order.
+// The frames take their output from limit while they are decoded.
+// At most left bytes are written, and ErrDecompressedSizeExceeded is returned
+// if the output is larger.
+// If prefix is not nil, it is the history of the first frame.
+func (d *Decoder) decodeFramesAt(r io.ReaderAt, frames []FrameInfo, w io.Writer, limit *sharedLimit, left uint64, prefix *dict) (int64, error) {
+	type result struct {
+		in  []byte
+		b   []byte
+		err error
+	}
+	results := make([]result, len(frames))
+	framePrefix := func(i int) *dict {
+		if i == 0 {
+			return prefix
+		}
+		return nil
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(frames))
+	for i := range frames {
+		go func(i int) {
+			defer wg.Done()
+			f := frames[i]
+			res := &results[i]
+			res.in = make([]byte, f.CompressedSize)
+			if err := readFullAt(r, res.in, f.Offset); err != nil {
+				res.err = err
+				return
+			}
+			res.b, res.err = d.decodeAll(context.Background(), res.in, nil, nil, limit, framePrefix(i))
+		}(i)
+	}
+	wg.Wait()
+
+	var written int64
+	for i, res := range results {
+		if frames[i].FrameContentSize > left {
+			return written, ErrDecompressedSizeExceeded
+		}
+		if res.err == errSharedLimit {
+			// The frames exceeded the limit together.
+			// Decode the frame again, limited to what is left.
+			res.b, res.err = d.decodeAll(context.Background(), res.in, nil, nil, newSharedLimit(left+1), framePrefix(i))
+			if res.err == errSharedLimit {
+				res.err = ErrDecompressedSizeExceeded
+			}
+		}
+		if uint64(len(res.b)) > left {
+			res.b, res.err = res.b[:left], ErrDecompressedSizeExceeded
+		}
+		n, err := w.Write(res.b)
+		written += int64(n)
+		left -= uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if res.err != nil {
+			return written, res.err
+		}
+	}
+	return written, nil
+}
+
+// decodeFrameBlocksAt decodes a single frame with the stream decoder and writes the output to w.
+// blocks has the offsets of the block headers in r.
+// The blocks of the frame are read concurrently ahead of the decoder.
+// At most left bytes are written, and ErrDecompressedSizeExceeded is returned
+// if the output is larger.
+// If prefix is not nil, it is the history of the frame.
+func (d *Decoder) decodeFrameBlocksAt(r io.ReaderAt, f FrameInfo, blocks []int64, w io.Writer, left uint64, prefix *dict) (int64, error) {
+	// Split the frame before each block header.
+	bounds := make([]int64, 0, len(blocks)+2)
+	bounds = append(bounds, f.Offset)
+	bounds = append(bounds, blocks...)
+	bounds = append(bounds, f.Offset+f.CompressedSize)
+	br := newBlockReader(r, bounds, d.o.concurrent)
+	defer br.close()
+
+	stream := decodeStream{
+		r:      br,
+		output: make(chan decodeOutput, d.o.concurrent),
+		cancel: make(chan struct{}),
+		prefix: prefix,
+	}
+	streams := make(chan decodeStream, 1)
+	streams <- stream
+	close(streams)
+	d.streamWg.Add(1)
+	go d.startStreamDecoder(streams)
+
+	var written int64
+	var err error
+	for o := range stream.output {
+		if o.err == errEndOfStream {
+			break
+		}
+		if err == nil {
+			if o.err == nil || o.err == io.EOF {
+				b := o.b
+				if uint64(len(b)) > left {
+					b = b[:left]
+				}
+				var n int
+				n, err = w.Write(b)
+				written += int64(n)
+				left -= uint64(n)
+				if err == nil && len(b) < len(o.b) {
+					err = ErrDecompressedSizeExceeded
+				}
+			} else {
+				err = o.err
+			}
+			if err != nil {
+				// Cancel the stream and drain the output.
+				close(stream.cancel)
+			}
+		}
+		if o.d != nil {
+			d.decoders <- o.d
+		}
+	}
+	return written, err
+}
+
+// blockReader reads parts of r in order, with up to n parts read concurrently ahead of Read.
+type blockReader struct {
+	parts  chan chan blockPart // Parts being read, in order.
+	free   chan []byte         // Buffers of parts that have been read.
+	buf    []byte              // Buffer of the current part.
+	cur    []byte              // Unread data of the current part.
+	err    error
+	cancel chan struct{}
+}
+
+// blockPart is a part of a blockReader.
+type blockPart struct {
+	b   []byte
+	err error
+}
+
+// newBlockReader returns a reader of r from bounds[0] to bounds[len(bounds)-1],
+// where parts between bounds are read with one ReadAt call each.
+// close must be called when the reader is no longer used.
+func newBlockReader(r io.ReaderAt, bounds []int64, n int) *blockReader {
+	b := &blockReader{
+		parts:  make(chan chan blockPart, n),
+		free:   make(chan []byte, n+1),
+		cancel: make(chan struct{}),
+	}
+	go func() {
+		defer close(b.parts)
+		for i := 0; i+1 < len(bounds); i++ {
+			part := make(chan blockPart, 1)
+			select {
+			case b.parts <- part:
+			case <-b.cancel:
+				return
+			}
+			var buf []byte
+			select {
+			case buf = <-b.free:
+			default:
+			}
+			go func(buf []byte, off, end int64) {
+				if cap(buf) < int(end-off) {
+					buf = make([]byte, end-off)
+				}
+				buf = buf[:end-off]
+				part <- blockPart{b: buf, err: readFullAt(r, buf, off)}
+			}(buf, bounds[i], bounds[i+1])
+		}
+	}()
+	return b
+}
+
+// Read reads the parts in order.
+func (b *blockReader) Read(p []byte) (int, error) {
+	for len(b.cur) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		if b.buf != nil {
+			select {
+			case b.free <- b.buf:
+			default:
+			}
+			b.buf = nil
+		}
+		part, ok := <-b.parts
+		if !ok {
+			b.err = io.EOF
+			continue
+		}
+		res := <-part
+		b.buf, b.err = res.b, res.err
+		if b.err == nil {
+			b.cur = res.b
+		}
+	}
+	n := copy(p, b.cur)
+	b.cur = b.cur[n:]
+	return n, nil
+}
+
+// close stops reading ahead.
+func (b *blockReader) close() {
+	close(b.cancel)
+}
@@ -0,0 +1,302 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// errorWriter accepts n bytes and then returns an error.
+type errorWriter struct {
+	n int
+}
+
+var errTestWrite = errors.New("write error")
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errTestWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestDecodeReaderAt(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	// A frame above the streaming limit, followed by smaller frames.
+	big := make([]byte, readerAtFrameSize+1<<20)
+	rng := rand.New(rand.NewSource(1))
+	rng.Read(big[:readerAtFrameSize+1<<10])
+	copy(big[readerAtFrameSize+1<<10:], testSeekableData(len(big)))
+	input := append([]byte{}, big...)
+	stream := enc.EncodeAll(big, nil)
+	if len(stream) <= readerAtFrameSize {
+		t.Fatalf("big frame is only %d bytes", len(stream))
+	}
+	for i := 0; i < 10; i++ {
+		b := testSeekableData(50<<10 + i*1000)
+		input = append(input, b...)
+		stream = enc.EncodeAll(b, stream)
+		if i == 3 {
+			// Skippable frames are ignored.
+			stream = append(stream, 0x50, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 1, 2)
+		}
+	}
+	stream = enc.EncodeAll(big, stream)
+	input = append(input, big...)
+
+	dec, err := NewReader(nil, WithDecoderConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	var out bytes.Buffer
+	n, err := dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(input)) || !bytes.Equal(out.Bytes(), input) {
+		t.Fatalf("output mismatch, got %d bytes, want %d", n, len(input))
+	}
+
+	// Truncated input is detected before anything is written.
+	out.Reset()
+	n, err = dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)-1), &out)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if n != 0 || out.Len() != 0 {
+		t.Fatalf("got %d bytes of output", out.Len())
+	}
+
+	// Write errors are returned, both while streaming and from smaller frames.
+	for _, limit := range []int{1000, len(big) + 1000} {
+		w := &errorWriter{n: limit}
+		n, err = dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)), w)
+		if err != errTestWrite {
+			t.Fatalf("got error %v, want %v", err, errTestWrite)
+		}
+		if n != int64(limit) {
+			t.Fatalf("got %d bytes written, want %d", n, limit)
+		}
+	}
+
+	// The decoder can still be used after an error.
+	out.Reset()
+	if _, err := dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), input) {
+		t.Fatal("output mismatch")
+	}
+}
+
+func TestDecodeReaderAtCorrupt(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	input := testSeekableData(5 << 20)
+	// Random data keeps the first frame above the streaming limit.
+	rng := rand.New(rand.NewSource(2))
+	rng.Read(input[:readerAtFrameSize+1<<10])
+	stream := enc.EncodeAll(input, nil)
+	stream = enc.EncodeAll(testSeekableData(1000), stream)
+
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// Corrupt the checksum of the first frame.
+	first := len(stream) - len(enc.EncodeAll(testSeekableData(1000), nil))
+	stream[first-1] ^= 0xff
+	var out bytes.Buffer
+	_, err = dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)), &out)
+	if err != ErrCRCMismatch {
+		t.Fatalf("got error %v, want %v", err, ErrCRCMismatch)
+	}
+}
+
+// slowReaderAt delays reads and records the largest number of concurrent reads.
+// If failAt is set, reads of blocks from failAt fail,
+// but the headers can still be scanned.
+type slowReaderAt struct {
+	r      io.ReaderAt
+	failAt int64
+
+	mu       sync.Mutex
+	cur, max int
+}
+
+var errTestRead = errors.New("read error")
+
+func (s *slowReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	s.cur++
+	if s.cur > s.max {
+		s.max = s.cur
+	}
+	s.mu.Unlock()
+	time.Sleep(time.Millisecond)
+	s.mu.Lock()
+	s.cur--
+	s.mu.Unlock()
+	if s.failAt > 0 && off+int64(len(p)) > s.failAt && len(p) > 64 {
+		return 0, errTestRead
+	}
+	return s.r.ReadAt(p, off)
+}
+
+func TestDecodeReaderAtBlocks(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	input := testSeekableData(readerAtFrameSize + 1<<20)
+	rng := rand.New(rand.NewSource(3))
+	rng.Read(input[:readerAtFrameSize+1<<10])
+	stream := enc.EncodeAll(input, nil)
+
+	const concurrency = 4
+	dec, err := NewReader(nil, WithDecoderConcurrency(concurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	// The blocks of the frame are read concurrently.
+	r := &slowReaderAt{r: bytes.NewReader(stream)}
+	var out bytes.Buffer
+	if _, err := dec.DecodeReaderAt(r, int64(len(stream)), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), input) {
+		t.Fatal("output mismatch")
+	}
+	if r.max < 2 || r.max > concurrency+1 {
+		t.Fatalf("got %d concurrent reads with a concurrency of %d", r.max, concurrency)
+	}
+
+	// Read errors are returned with the output of the blocks before them.
+	r = &slowReaderAt{r: bytes.NewReader(stream), failAt: int64(len(stream) / 2)}
+	out.Reset()
+	_, err = dec.DecodeReaderAt(r, int64(len(stream)), &out)
+	if err != errTestRead {
+		t.Fatalf("got error %v, want %v", err, errTestRead)
+	}
+	if out.Len() == 0 || !bytes.Equal(out.Bytes(), input[:out.Len()]) {
+		t.Fatalf("got %d bytes of output before the error", out.Len())
+	}
+}
+
+func TestDecodeReaderAtLimit(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	// Many frames that are each below the limit.
+	const frameSize = 64 << 10
+	var input, stream []byte
+	for i := 0; i < 100; i++ {
+		b := testSeekableData(frameSize + i)
+		input = append(input, b...)
+		stream = enc.EncodeAll(b, stream)
+	}
+	// A frame without a content size that is read in blocks.
+	big := make([]byte, readerAtFrameSize+1<<20)
+	rand.New(rand.NewSource(4)).Read(big)
+	var buf bytes.Buffer
+	enc.Reset(&buf)
+	enc.Write(big)
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bigStream := buf.Bytes()
+
+	for _, limit := range []int{frameSize / 2, 10*frameSize + 100, len(input) - 1, len(input)} {
+		dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecoderMaxDecompressedSize(uint64(limit)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dec.Close()
+		// Frames that state a larger size than what is left are not decoded.
+		want := 0
+		for i := 0; i < 100 && want+frameSize+i <= limit; i++ {
+			want += frameSize + i
+		}
+		var out bytes.Buffer
+		n, err := dec.DecodeReaderAt(bytes.NewReader(stream), int64(len(stream)), &out)
+		if want < len(input) {
+			if err != ErrDecompressedSizeExceeded {
+				t.Fatalf("limit %d: got error %v, want %v", limit, err, ErrDecompressedSizeExceeded)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(want) || !bytes.Equal(out.Bytes(), input[:want]) {
+			t.Fatalf("limit %d: got %d bytes, want %d", limit, n, want)
+		}
+
+		// Other frames are written up to the limit.
+		want = len(big)
+		if limit < want {
+			want = limit
+		}
+		out.Reset()
+		n, err = dec.DecodeReaderAt(bytes.NewReader(bigStream), int64(len(bigStream)), &out)
+		if want < len(big) {
+			if err != ErrDecompressedSizeExceeded {
+				t.Fatalf("limit %d: got error %v, want %v", limit, err, ErrDecompressedSizeExceeded)
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(want) || !bytes.Equal(out.Bytes(), big[:want]) {
+			t.Fatalf("limit %d: got %d bytes, want %d", limit, n, want)
+		}
+	}
+
+	// The output of frames without a content size is limited while frames are decoded concurrently.
+	var noSize bytes.Buffer
+	for i := 0; i < 100; i++ {
+		enc.Reset(&noSize)
+		enc.Write(input[i*frameSize : (i+1)*frameSize])
+		if err := enc.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	const limit = 10*frameSize + 100
+	dec, err := NewReader(nil, WithDecoderConcurrency(4), WithDecoderMaxDecompressedSize(limit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	var out bytes.Buffer
+	n, err := dec.DecodeReaderAt(bytes.NewReader(noSize.Bytes()), int64(noSize.Len()), &out)
+	if err != ErrDecompressedSizeExceeded {
+		t.Fatalf("got error %v, want %v", err, ErrDecompressedSizeExceeded)
+	}
+	if n != limit || !bytes.Equal(out.Bytes(), input[:limit]) {
+		t.Fatalf("got %d bytes, want %d", n, limit)
+	}
+}
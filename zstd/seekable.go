@@ -0,0 +1,358 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/xxhash"
+)
+
+// ErrSeekableWriterClosed is returned if a SeekableWriter is used after Close.
+var ErrSeekableWriterClosed = errors.New("seekable writer used after Close")
+
+// SeekableWriter writes data in the zstd seekable format.
+// Data is compressed in independent frames of up to a fixed size,
+// followed by a seek table in a skippable frame,
+// so the uncompressed data can be read from any position
+// without decompressing all frames before it.
+//
+// The output can be decompressed as a regular zstd stream,
+// and can be read by other implementations of the seekable format.
+type SeekableWriter struct {
+	w         io.Writer
+	enc       *Encoder
+	frameSize int
+	buf       []byte // Uncompressed data of the current frame.
+	dst       []byte
+	table     SeekTable
+	err       error
+}
+
+// NewSeekableWriter returns a writer that writes data compressed by enc to w
+// in the zstd seekable format, in frames of frameSize uncompressed bytes.
+// Frames are compressed with enc.EncodeAll, so enc can be shared.
+// frameSize must be between 1 and MaxSeekableFrameSize.
+// Smaller frames give faster random access, but a lower compression ratio.
+//
+// The seek table is written when the writer is closed.
+func NewSeekableWriter(w io.Writer, enc *Encoder, frameSize int) (*SeekableWriter, error) {
+	if enc == nil {
+		return nil, errors.New("nil encoder")
+	}
+	if frameSize <= 0 || frameSize > MaxSeekableFrameSize {
+		return nil, fmt.Errorf("frame size must be between 1 and %d", MaxSeekableFrameSize)
+	}
+	return &SeekableWriter{w: w, enc: enc, frameSize: frameSize, table: SeekTable{Checksums: true}}, nil
+}
+
+// Write compresses p.
+// Frames are written when frameSize bytes have been written.
+func (s *SeekableWriter) Write(p []byte) (n int, err error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	for len(p) > 0 {
+		if len(s.buf) == 0 && len(p) >= s.frameSize {
+			// Compress directly from p.
+			if err := s.writeFrame(p[:s.frameSize]); err != nil {
+				return n, err
+			}
+			n += s.frameSize
+			p = p[s.frameSize:]
+			continue
+		}
+		toAdd := s.frameSize - len(s.buf)
+		if toAdd > len(p) {
+			toAdd = len(p)
+		}
+		s.buf = append(s.buf, p[:toAdd]...)
+		n += toAdd
+		p = p[toAdd:]
+		if len(s.buf) == s.frameSize {
+			if err := s.writeFrame(s.buf); err != nil {
+				return n, err
+			}
+			s.buf = s.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Flush writes the data written so far as a frame.
+// The underlying writer is not flushed.
+// Flushing often creates small frames, which compress worse.
+func (s *SeekableWriter) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.buf) == 0 {
+		return nil
+	}
+	err := s.writeFrame(s.buf)
+	s.buf = s.buf[:0]
+	return err
+}
+
+// Close writes the remaining data and the seek table.
+// The underlying writer is not closed.
+func (s *SeekableWriter) Close() error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	var err error
+	s.dst, err = s.table.AppendFrame(s.dst[:0])
+	if err != nil {
+		s.err = err
+		return err
+	}
+	if _, err := s.w.Write(s.dst); err != nil {
+		s.err = err
+		return err
+	}
+	s.err = ErrSeekableWriterClosed
+	return nil
+}
+
+// writeFrame writes b as a frame and adds it to the seek table.
+func (s *SeekableWriter) writeFrame(b []byte) error {
+	s.dst = s.enc.EncodeAll(b, s.dst[:0])
+	if int64(len(s.dst)) > math.MaxUint32 {
+		s.err = errors.New("compressed frame too large")
+		return s.err
+	}
+	if _, err := s.w.Write(s.dst); err != nil {
+		s.err = err
+		return err
+	}
+	s.table.Entries = append(s.table.Entries, SeekTableEntry{
+		CompressedSize:   uint32(len(s.dst)),
+		DecompressedSize: uint32(len(b)),
+		Checksum:         uint32(xxhash.Sum64(b)),
+	})
+	return nil
+}
+
+// seekFrame is a frame of the seekable format.
+type seekFrame struct {
+	SeekTableEntry
+	cOff int64 // Offset of the compressed frame.
+	dOff int64 // Offset of the decompressed data.
+}
+
+// SeekableReader reads data in the zstd seekable format,
+// decoding only the frames needed for the data that is read.
+// It implements io.ReaderAt, io.ReadSeeker and io.Closer.
+//
+// The most recently decoded frame is kept, so reads of
+// consecutive parts of a frame only decode it once.
+// Concurrent calls to ReadAt are safe, but decode one frame at a time.
+type SeekableReader struct {
+	r        io.ReaderAt
+	dec      *Decoder
+	frames   []seekFrame
+	size     int64 // Decompressed size.
+	checksum bool  // Entries have checksums.
+	off      int64 // Offset of Read.
+
+	mu      sync.Mutex // Protects the fields below.
+	current int        // Index of the decoded frame, or -1.
+	buf     []byte     // Decompressed data of the current frame.
+	src     []byte     // Compressed data of the current frame.
+}
+
+// NewSeekableReader returns a reader of the seekable stream r of size bytes.
+// Frames are decoded with dec.DecodeAll, so dec can be shared.
+// ErrInvalidSeekTable is returned if r does not end with a valid seek table.
+func NewSeekableReader(r io.ReaderAt, size int64, dec *Decoder) (*SeekableReader, error) {
+	if dec == nil {
+		return nil, errors.New("nil decoder")
+	}
+	frames, checksum, err := readSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+	s := &SeekableReader{
+		r:        r,
+		dec:      dec,
+		frames:   frames,
+		checksum: checksum,
+		current:  -1,
+	}
+	if n := len(frames); n > 0 {
+		s.size = frames[n-1].dOff + int64(frames[n-1].DecompressedSize)
+	}
+	return s, nil
+}
+
+// readSeekTable reads the seek table at the end of the seekable stream r of size bytes
+// and returns the frames and whether entries have checksums.
+func readSeekTable(r io.ReaderAt, size int64) (frames []seekFrame, checksum bool, err error) {
+	if size < skippableFrameHeader+SeekTableFooterSize {
+		return nil, false, ErrInvalidSeekTable
+	}
+	var footer [SeekTableFooterSize]byte
+	if err := readFullAt(r, footer[:], size-SeekTableFooterSize); err != nil {
+		return nil, false, err
+	}
+	n, err := SeekTableSize(footer[:])
+	if err != nil {
+		return nil, false, err
+	}
+	frameSize := int64(skippableFrameHeader + n)
+	if frameSize > size {
+		return nil, false, ErrInvalidSeekTable
+	}
+	b := make([]byte, frameSize)
+	if err := readFullAt(r, b, size-frameSize); err != nil {
+		return nil, false, err
+	}
+	table, _, err := ParseSeekTableFrame(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	frames = make([]seekFrame, len(table.Entries))
+	var cOff, dOff int64
+	for i, e := range table.Entries {
+		frames[i] = seekFrame{SeekTableEntry: e, cOff: cOff, dOff: dOff}
+		cOff += int64(e.CompressedSize)
+		dOff += int64(e.DecompressedSize)
+	}
+	if cOff != size-frameSize {
+		return nil, false, ErrInvalidSeekTable
+	}
+	return frames, table.Checksums, nil
+}
+
+// Size returns the decompressed size.
+func (s *SeekableReader) Size() int64 {
+	return s.size
+}
+
+// ReadAt reads len(p) decompressed bytes starting at off.
+func (s *SeekableReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	// Find the first frame containing off.
+	i := sort.Search(len(s.frames), func(i int) bool {
+		f := s.frames[i]
+		return f.dOff+int64(f.DecompressedSize) > off
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n < len(p) && i < len(s.frames) {
+		if err := s.decodeFrame(i); err != nil {
+			return n, err
+		}
+		f := s.frames[i]
+		copied := copy(p[n:], s.buf[off-f.dOff:])
+		n += copied
+		off += int64(copied)
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decodeFrame decodes frame i into s.buf, unless it is already decoded.
+// s.mu must be held.
+func (s *SeekableReader) decodeFrame(i int) error {
+	if s.current == i {
+		return nil
+	}
+	f := s.frames[i]
+	s.current = -1
+	if cap(s.src) < int(f.CompressedSize) {
+		s.src = make([]byte, f.CompressedSize)
+	}
+	s.src = s.src[:f.CompressedSize]
+	if err := readFullAt(s.r, s.src, f.cOff); err != nil {
+		return err
+	}
+	var err error
+	s.buf, err = s.dec.DecodeAll(s.src, s.buf[:0])
+	if err != nil {
+		return err
+	}
+	if err := f.check(i, s.buf, s.checksum); err != nil {
+		return err
+	}
+	s.current = i
+	return nil
+}
+
+// check returns an error if b, the decompressed data of frame i,
+// does not match the seek table.
+func (f seekFrame) check(i int, b []byte, checksum bool) error {
+	if len(b) != int(f.DecompressedSize) {
+		return fmt.Errorf("frame %d: decompressed size %d, seek table has %d", i, len(b), f.DecompressedSize)
+	}
+	if checksum && uint32(xxhash.Sum64(b)) != f.Checksum {
+		return ErrCRCMismatch
+	}
+	return nil
+}
+
+// Read reads decompressed data from the current offset.
+func (s *SeekableReader) Read(p []byte) (n int, err error) {
+	n, err = s.ReadAt(p, s.off)
+	s.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read to offset,
+// interpreted according to whence.
+func (s *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.off = offset
+	return offset, nil
+}
+
+// Close releases the decoded frame.
+// The underlying reader and decoder are not closed.
+func (s *SeekableReader) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current, s.buf, s.src = -1, nil, nil
+	return nil
+}
+
+// readFullAt reads len(b) bytes from r at off.
+func readFullAt(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if n == len(b) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,408 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/xxhash"
+)
+
+// testSeekableData returns n bytes of compressible data.
+func testSeekableData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i/7) ^ byte(i>>10)
+	}
+	return b
+}
+
+func TestSeekableWriter(t *testing.T) {
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	const frameSize = 1000
+	for _, size := range []int{0, 1, frameSize - 1, frameSize, frameSize + 1, 10*frameSize + 123} {
+		input := testSeekableData(size)
+		var buf bytes.Buffer
+		w, err := NewSeekableWriter(&buf, enc, frameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in uneven parts.
+		for b := input; len(b) > 0; {
+			n := 333
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := w.Write(b[:n]); err != nil {
+				t.Fatal(err)
+			}
+			b = b[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte{1}); err != ErrSeekableWriterClosed {
+			t.Fatalf("want ErrSeekableWriterClosed, got %v", err)
+		}
+
+		// Decodes as a regular stream.
+		got, err := dec.DecodeAll(buf.Bytes(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("size %d: decoded mismatch", size)
+		}
+		if err := dec.Reset(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+		got, err = ioutil.ReadAll(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("size %d: stream decoded mismatch", size)
+		}
+
+		// Check the seek table.
+		b := buf.Bytes()
+		footer := b[len(b)-SeekTableFooterSize:]
+		if binary.LittleEndian.Uint32(footer[5:]) != SeekableMagic {
+			t.Fatal("seekable magic mismatch")
+		}
+		if footer[4] != seekChecksumFlag {
+			t.Fatalf("unexpected descriptor %x", footer[4])
+		}
+		frames := int(binary.LittleEndian.Uint32(footer))
+		if want := (size + frameSize - 1) / frameSize; frames != want {
+			t.Fatalf("size %d: got %d frames, want %d", size, frames, want)
+		}
+		table := b[len(b)-SeekTableFooterSize-frames*12-8:]
+		if binary.LittleEndian.Uint32(table) != SeekTableMagic {
+			t.Fatal("seek table magic mismatch")
+		}
+		if int(binary.LittleEndian.Uint32(table[4:])) != frames*12+SeekTableFooterSize {
+			t.Fatal("seek table frame size mismatch")
+		}
+		var cOff, dOff int
+		for i := 0; i < frames; i++ {
+			e := table[8+i*12:]
+			c, d := int(binary.LittleEndian.Uint32(e)), int(binary.LittleEndian.Uint32(e[4:]))
+			frame, err := dec.DecodeAll(b[cOff:cOff+c], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(frame, input[dOff:dOff+d]) {
+				t.Fatalf("frame %d mismatch", i)
+			}
+			if binary.LittleEndian.Uint32(e[8:]) != uint32(xxhash.Sum64(frame)) {
+				t.Fatalf("frame %d checksum mismatch", i)
+			}
+			cOff += c
+			dOff += d
+		}
+		if dOff != size || cOff != len(b)-len(table) {
+			t.Fatalf("size %d: frames cover %d/%d bytes", size, dOff, cOff)
+		}
+	}
+}
+
+func TestSeekableWriterFlush(t *testing.T) {
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	w, err := NewSeekableWriter(&buf, enc, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("first"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("frame not written on flush")
+	}
+	w.Write([]byte("second"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if frames := binary.LittleEndian.Uint32(b[len(b)-SeekTableFooterSize:]); frames != 2 {
+		t.Fatalf("got %d frames, want 2", frames)
+	}
+
+	if _, err := NewSeekableWriter(&buf, enc, 0); err == nil {
+		t.Fatal("expected error for frame size 0")
+	}
+	if _, err := NewSeekableWriter(&buf, enc, MaxSeekableFrameSize+1); err == nil {
+		t.Fatal("expected error for large frame size")
+	}
+}
+
+// seekableTestStream returns input written with a SeekableWriter.
+func seekableTestStream(t *testing.T, input []byte, frameSize int) []byte {
+	t.Helper()
+	enc, err := NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var buf bytes.Buffer
+	w, err := NewSeekableWriter(&buf, enc, frameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(input)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSeekTable(t *testing.T) {
+	for _, checksums := range []bool{false, true} {
+		table := SeekTable{Checksums: checksums}
+		for i := 0; i < 10; i++ {
+			e := SeekTableEntry{CompressedSize: uint32(i * 100), DecompressedSize: uint32(i * 1000)}
+			if checksums {
+				e.Checksum = uint32(i)
+			}
+			table.Entries = append(table.Entries, e)
+		}
+		b, err := table.AppendTable([]byte("prefix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != len("prefix")+table.Size() {
+			t.Fatalf("got size %d, want %d", len(b)-len("prefix"), table.Size())
+		}
+		if n, err := SeekTableSize(b[len(b)-SeekTableFooterSize:]); err != nil || n != table.Size() {
+			t.Fatalf("got size %d, error %v, want %d", n, err, table.Size())
+		}
+		got, err := ParseSeekTable(b[len("prefix"):])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Checksums != checksums || len(got.Entries) != len(table.Entries) || got.Entries[9] != table.Entries[9] {
+			t.Fatalf("got %+v, want %+v", got, table)
+		}
+		// The table alone is not a frame.
+		if _, _, err := ParseSeekTableFrame(b); err != ErrInvalidSeekTable {
+			t.Fatalf("got %v, want ErrInvalidSeekTable", err)
+		}
+
+		b, err = table.AppendFrame([]byte("prefix"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, n, err := ParseSeekTableFrame(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(b)-len("prefix") || len(got.Entries) != len(table.Entries) {
+			t.Fatalf("got %d entries in %d bytes", len(got.Entries), n)
+		}
+
+		// Reserved bits must be zero.
+		b[len(b)-5] |= 4
+		if _, _, err := ParseSeekTableFrame(b); err != ErrInvalidSeekTable {
+			t.Fatalf("got %v, want ErrInvalidSeekTable", err)
+		}
+	}
+}
+
+func TestSeekableReader(t *testing.T) {
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	const frameSize = 1000
+	input := testSeekableData(10*frameSize + 123)
+	stream := seekableTestStream(t, input, frameSize)
+	r, err := NewSeekableReader(bytes.NewReader(stream), int64(len(stream)), dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Size() != int64(len(input)) {
+		t.Fatalf("got size %d, want %d", r.Size(), len(input))
+	}
+
+	// ReadAt within, across and at the end of frames.
+	for _, test := range []struct{ off, n int }{
+		{0, 10}, {5, frameSize}, {frameSize - 1, 2}, {2500, 3 * frameSize}, {len(input) - 5, 5}, {0, len(input)},
+	} {
+		got := make([]byte, test.n)
+		n, err := r.ReadAt(got, int64(test.off))
+		if err != nil {
+			t.Fatalf("ReadAt(%d, %d): %v", test.off, test.n, err)
+		}
+		if n != test.n || !bytes.Equal(got, input[test.off:test.off+test.n]) {
+			t.Fatalf("ReadAt(%d, %d): mismatch", test.off, test.n)
+		}
+	}
+	got := make([]byte, 10)
+	n, err := r.ReadAt(got, int64(len(input)-5))
+	if n != 5 || err != io.EOF {
+		t.Fatalf("got %d, %v, want 5, EOF", n, err)
+	}
+	if _, err := r.ReadAt(got, int64(len(input))); err != io.EOF {
+		t.Fatalf("got %v, want EOF", err)
+	}
+
+	// Seek and Read.
+	pos, err := r.Seek(-200, io.SeekEnd)
+	if err != nil || pos != int64(len(input)-200) {
+		t.Fatalf("Seek: %d, %v", pos, err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, input[len(input)-200:]) {
+		t.Fatal("Read after Seek mismatch")
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	all, err := ioutil.ReadAll(io.NewSectionReader(r, 0, r.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, input) {
+		t.Fatal("SectionReader mismatch")
+	}
+}
+
+func TestSeekableReaderInvalid(t *testing.T) {
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	input := testSeekableData(5000)
+	stream := seekableTestStream(t, input, 1000)
+
+	// Regular streams have no seek table.
+	enc, _ := NewWriter(nil)
+	plain := enc.EncodeAll(input, nil)
+	enc.Close()
+	if _, err := NewSeekableReader(bytes.NewReader(plain), int64(len(plain)), dec); err != ErrInvalidSeekTable {
+		t.Fatalf("got %v, want ErrInvalidSeekTable", err)
+	}
+
+	// Truncated stream.
+	truncated := stream[100:]
+	if _, err := NewSeekableReader(bytes.NewReader(truncated), int64(len(truncated)), dec); err != ErrInvalidSeekTable {
+		t.Fatalf("got %v, want ErrInvalidSeekTable", err)
+	}
+
+	// Corrupted checksum is detected on read.
+	corrupt := append([]byte{}, stream...)
+	corrupt[len(corrupt)-SeekTableFooterSize-1] ^= 1
+	r, err := NewSeekableReader(bytes.NewReader(corrupt), int64(len(corrupt)), dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 4500); err != ErrCRCMismatch {
+		t.Fatalf("got %v, want ErrCRCMismatch", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecoderSeekIndex(t *testing.T) {
+	dec, err := NewReader(nil, WithDecoderSeekIndex(true), WithDecoderConcurrency(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	const frameSize = 1000
+	input := testSeekableData(50*frameSize + 123)
+	stream := seekableTestStream(t, input, frameSize)
+
+	r := bytes.NewReader(stream)
+	if err := dec.Reset(r); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, input) {
+		t.Fatal("decoded mismatch")
+	}
+	// Frames are read with ReadAt.
+	if r.Len() != len(stream) {
+		t.Fatalf("reader advanced to %d", len(stream)-r.Len())
+	}
+
+	// The stream starts at the current position.
+	r = bytes.NewReader(append([]byte("junk"), stream...))
+	r.Seek(4, io.SeekStart)
+	if err := dec.Reset(r); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if _, err := dec.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), input) {
+		t.Fatal("WriteTo mismatch")
+	}
+
+	// Reset while frames are decoded.
+	if err := dec.Reset(bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(dec, make([]byte, 1500)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Checksums are verified and output before the frame is returned.
+	corrupt := append([]byte{}, stream...)
+	corrupt[len(corrupt)-SeekTableFooterSize-1] ^= 1
+	if err := dec.Reset(bytes.NewReader(corrupt)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(dec)
+	if err != ErrCRCMismatch {
+		t.Fatalf("got %v, want ErrCRCMismatch", err)
+	}
+	if !bytes.Equal(got, input[:len(input)-123]) {
+		t.Fatalf("got %d bytes before error", len(got))
+	}
+
+	// Streams without a seek table are decoded as usual.
+	enc, _ := NewWriter(nil)
+	plain := enc.EncodeAll(input, nil)
+	enc.Close()
+	if err := dec.Reset(bytes.NewReader(plain)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = ioutil.ReadAll(dec)
+	if err != nil || !bytes.Equal(got, input) {
+		t.Fatalf("plain stream: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// seekIndex is the seek table of a stream in the seekable format.
+type seekIndex struct {
+	r        io.ReaderAt
+	frames   []seekFrame
+	checksum bool
+}
+
+// readSeekIndex returns the seek table of r from its current position,
+// if r implements io.ReaderAt and io.Seeker and ends with a seek table.
+// Otherwise nil is returned.
+// The position of r is not changed.
+func readSeekIndex(r io.Reader) *seekIndex {
+	rs, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	})
+	if !ok {
+		return nil
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if _, err2 := rs.Seek(start, io.SeekStart); err != nil || err2 != nil {
+		return nil
+	}
+	sr := io.NewSectionReader(rs, start, end-start)
+	frames, checksum, err := readSeekTable(sr, end-start)
+	if err != nil || len(frames) < 2 {
+		// Nothing to gain from a single frame.
+		return nil
+	}
+	return &seekIndex{r: sr, frames: frames, checksum: checksum}
+}
+
+// startSeekIndexDecoder decodes the frames of index concurrently
+// and sends the output of each frame to stream.output in order.
+func (d *Decoder) startSeekIndexDecoder(index *seekIndex, stream decodeStream) {
+	defer d.streamWg.Done()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Frames are decoded up to the decoder concurrency ahead of the output.
+	pending := make(chan chan decodeOutput, d.o.concurrent)
+	go func() {
+		defer close(pending)
+		for i, f := range index.frames {
+			res := make(chan decodeOutput, 1)
+			select {
+			case pending <- res:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			var prefix *dict
+			if i == 0 {
+				prefix = stream.prefix
+			}
+			go func(i int, f seekFrame) {
+				defer wg.Done()
+				res <- d.decodeSeekFrame(index, i, f, prefix)
+			}(i, f)
+		}
+	}()
+
+	failed := false
+	for res := range pending {
+		o := <-res
+		if failed {
+			continue
+		}
+		select {
+		case <-stream.cancel:
+			failed = true
+			close(stop)
+			continue
+		default:
+		}
+		stream.output <- o
+		if o.err != nil {
+			failed = true
+			close(stop)
+		}
+	}
+	// Wait for all decoders to be returned.
+	wg.Wait()
+	if !failed {
+		stream.output <- decodeOutput{err: io.EOF}
+	}
+	stream.output <- decodeOutput{err: errEndOfStream}
+}
+
+// decodeSeekFrame reads and decodes frame i of index.
+// If prefix is not nil, it is the history of the frame.
+func (d *Decoder) decodeSeekFrame(index *seekIndex, i int, f seekFrame, prefix *dict) decodeOutput {
+	in := make([]byte, f.CompressedSize)
+	if err := readFullAt(index.r, in, f.cOff); err != nil {
+		return decodeOutput{err: err}
+	}
+	var sizes []contentSize
+	b, err := d.decodeAll(context.Background(), in, nil, &sizes, nil, prefix)
+	if err != nil {
+		return decodeOutput{b: b, err: err}
+	}
+	if err := f.check(i, b, index.checksum); err != nil {
+		return decodeOutput{err: err}
+	}
+	o := decodeOutput{b: b}
+	if len(sizes) > 0 {
+		o.newFrame = true
+		o.frame = sizes[0]
+	}
+	return o
+}
@@ -0,0 +1,188 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The seekable format is described in
+// https://github.com/facebook/zstd/blob/dev/contrib/seekable_format/zstd_seekable_compression_format.md
+const (
+	// SeekTableMagic is the magic number of the skippable frame containing the seek table.
+	SeekTableMagic = 0x184D2A5E
+
+	// SeekableMagic is the magic number at the end of the seek table.
+	SeekableMagic = 0x8F92EAB1
+
+	// SeekTableFooterSize is the size of the seek table footer.
+	SeekTableFooterSize = 9
+
+	// seekChecksumFlag is set in the seek table descriptor
+	// if entries have checksums.
+	seekChecksumFlag = 1 << 7
+
+	// seekReservedBits must be zero in the seek table descriptor.
+	seekReservedBits = 0x7c
+
+	// MaxSeekableFrameSize is the maximum uncompressed size of
+	// frames in the seekable format.
+	MaxSeekableFrameSize = 1 << 30
+
+	// maxSeekableFrames is the maximum number of frames in the seekable format.
+	maxSeekableFrames = 0x8000000
+)
+
+// ErrInvalidSeekTable is returned if the seek table of the seekable format
+// is missing or invalid.
+var ErrInvalidSeekTable = errors.New("invalid seek table")
+
+// SeekTableEntry is the entry of a frame in a seek table.
+type SeekTableEntry struct {
+	CompressedSize   uint32
+	DecompressedSize uint32
+
+	// Checksum is the lower 32 bits of the XXH64 hash of the decompressed data.
+	// It is only stored if the table has checksums.
+	Checksum uint32
+}
+
+// SeekTable is the seek table of the seekable format,
+// which lists the frames of a stream in order.
+//
+// The table is normally stored in a skippable frame at the end of a zstd stream,
+// but the table itself can also be stored in other containers.
+type SeekTable struct {
+	Entries []SeekTableEntry
+
+	// Checksums is true if entries have checksums.
+	Checksums bool
+}
+
+func (t *SeekTable) entrySize() int {
+	if t.Checksums {
+		return 12
+	}
+	return 8
+}
+
+// Size returns the size of the table, without the skippable frame header.
+func (t *SeekTable) Size() int {
+	return len(t.Entries)*t.entrySize() + SeekTableFooterSize
+}
+
+// AppendTable appends the entries and footer of the table to dst,
+// without the skippable frame header.
+// An error is returned if the table has too many entries.
+func (t *SeekTable) AppendTable(dst []byte) ([]byte, error) {
+	if len(t.Entries) > maxSeekableFrames {
+		return dst, errors.New("too many frames for seek table")
+	}
+	var tmp [4]byte
+	add32 := func(v uint32) {
+		binary.LittleEndian.PutUint32(tmp[:], v)
+		dst = append(dst, tmp[:]...)
+	}
+	for _, e := range t.Entries {
+		add32(e.CompressedSize)
+		add32(e.DecompressedSize)
+		if t.Checksums {
+			add32(e.Checksum)
+		}
+	}
+	add32(uint32(len(t.Entries)))
+	var desc byte
+	if t.Checksums {
+		desc |= seekChecksumFlag
+	}
+	dst = append(dst, desc)
+	add32(SeekableMagic)
+	return dst, nil
+}
+
+// AppendFrame appends the table in a skippable frame to dst.
+// An error is returned if the table has too many entries.
+func (t *SeekTable) AppendFrame(dst []byte) ([]byte, error) {
+	var tmp [skippableFrameHeader]byte
+	binary.LittleEndian.PutUint32(tmp[:], SeekTableMagic)
+	binary.LittleEndian.PutUint32(tmp[4:], uint32(t.Size()))
+	return t.AppendTable(append(dst, tmp[:]...))
+}
+
+// SeekTableSize returns the size of a seek table, without the skippable frame header,
+// from its footer, which must be SeekTableFooterSize bytes.
+// ErrInvalidSeekTable is returned if the footer is invalid.
+func SeekTableSize(footer []byte) (int, error) {
+	if len(footer) != SeekTableFooterSize || binary.LittleEndian.Uint32(footer[5:]) != SeekableMagic {
+		return 0, ErrInvalidSeekTable
+	}
+	desc := footer[4]
+	if desc&seekReservedBits != 0 {
+		return 0, ErrInvalidSeekTable
+	}
+	n := binary.LittleEndian.Uint32(footer[:4])
+	if n > maxSeekableFrames {
+		return 0, ErrInvalidSeekTable
+	}
+	t := SeekTable{Checksums: desc&seekChecksumFlag != 0}
+	return int(n)*t.entrySize() + SeekTableFooterSize, nil
+}
+
+// ParseSeekTable parses a seek table without the skippable frame header.
+// b must contain exactly the table.
+// ErrInvalidSeekTable is returned if the table is invalid.
+func ParseSeekTable(b []byte) (*SeekTable, error) {
+	if len(b) < SeekTableFooterSize {
+		return nil, ErrInvalidSeekTable
+	}
+	n, err := SeekTableSize(b[len(b)-SeekTableFooterSize:])
+	if err != nil {
+		return nil, err
+	}
+	if n != len(b) {
+		return nil, ErrInvalidSeekTable
+	}
+	t := SeekTable{Checksums: b[len(b)-5]&seekChecksumFlag != 0}
+	entries := b[:len(b)-SeekTableFooterSize]
+	t.Entries = make([]SeekTableEntry, 0, len(entries)/t.entrySize())
+	for len(entries) > 0 {
+		e := SeekTableEntry{
+			CompressedSize:   binary.LittleEndian.Uint32(entries),
+			DecompressedSize: binary.LittleEndian.Uint32(entries[4:]),
+		}
+		if t.Checksums {
+			e.Checksum = binary.LittleEndian.Uint32(entries[8:])
+		}
+		t.Entries = append(t.Entries, e)
+		entries = entries[t.entrySize():]
+	}
+	return &t, nil
+}
+
+// ParseSeekTableFrame parses the skippable frame containing the seek table
+// at the end of b. b may contain the entire stream.
+// The size of the frame is returned with the table.
+// ErrInvalidSeekTable is returned if b does not end with a valid seek table.
+func ParseSeekTableFrame(b []byte) (*SeekTable, int, error) {
+	if len(b) < skippableFrameHeader+SeekTableFooterSize {
+		return nil, 0, ErrInvalidSeekTable
+	}
+	n, err := SeekTableSize(b[len(b)-SeekTableFooterSize:])
+	if err != nil {
+		return nil, 0, err
+	}
+	if n+skippableFrameHeader > len(b) {
+		return nil, 0, ErrInvalidSeekTable
+	}
+	frame := b[len(b)-n-skippableFrameHeader:]
+	if binary.LittleEndian.Uint32(frame) != SeekTableMagic || int(binary.LittleEndian.Uint32(frame[4:])) != n {
+		return nil, 0, ErrInvalidSeekTable
+	}
+	t, err := ParseSeekTable(frame[skippableFrameHeader:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return t, len(frame), nil
+}
@@ -65,6 +65,9 @@ type sequenceDecs struct {
 	dict         []byte
 	literals     []byte
 	out          []byte
+	// ignoreBuffer is the number of bytes at the start of out
+	// that were output by previous frames.
+	ignoreBuffer int
 	windowSize   int
 	maxBits      uint8
 }
@@ -86,6 +89,7 @@ func (s *sequenceDecs) initialize(br *bitReader, hist *history, literals, out []
 	s.maxBits = s.litLengths.fse.maxBits + s.offsets.fse.maxBits + s.matchLengths.fse.maxBits
 	s.windowSize = hist.windowSize
 	s.out = out
+	s.ignoreBuffer = hist.ignoreBuffer
 	s.dict = nil
 	if hist.dict != nil {
 		s.dict = hist.dict.content
@@ -207,15 +211,15 @@ func (s *sequenceDecs) decode(seqs int, br *bitReader, hist []byte) error {
 			return fmt.Errorf("zero matchoff and matchlen (%d) > 0", ml)
 		}
 
-		if mo > len(s.out)+len(hist) || mo > s.windowSize {
+		if avail := len(s.out) - s.ignoreBuffer + len(hist); mo > avail || mo > s.windowSize {
 			if len(s.dict) == 0 {
-				return fmt.Errorf("match offset (%d) bigger than current history (%d)", mo, len(s.out)+len(hist))
+				return fmt.Errorf("match offset (%d) bigger than current history (%d)", mo, avail)
 			}
 
 			// we may be in dictionary.
-			dictO := len(s.dict) - (mo - (len(s.out) + len(hist)))
+			dictO := len(s.dict) - (mo - avail)
 			if dictO < 0 || dictO >= len(s.dict) {
-				return fmt.Errorf("match offset (%d) bigger than current history (%d)", mo, len(s.out)+len(hist))
+				return fmt.Errorf("match offset (%d) bigger than current history (%d)", mo, avail)
 			}
 			end := dictO + ml
 			if end > len(s.dict) {
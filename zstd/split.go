@@ -0,0 +1,83 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SplitFrames splits a stream of frames into pieces of at most maxSize bytes,
+// without decompressing it.
+// Each piece contains whole frames, so pieces can be stored, transferred
+// and decoded independently, or joined again with ConcatFrames.
+// A frame larger than maxSize is returned as a piece by itself.
+// If maxSize <= 0, each frame is returned as a separate piece.
+// Skippable frames are kept in the piece of the frame before them.
+//
+// The returned pieces are slices of b.
+func SplitFrames(b []byte, maxSize int) ([][]byte, error) {
+	var pieces [][]byte
+	start, end := 0, 0
+	fs := Frames(bytes.NewReader(b))
+	for fs.Next() {
+		f := fs.Frame()
+		next := int(f.Offset + f.CompressedSize)
+		if end > start && !f.Skippable && (maxSize <= 0 || next-start > maxSize) {
+			pieces = append(pieces, b[start:end])
+			start = end
+		}
+		end = next
+	}
+	if err := fs.Err(); err != nil {
+		return nil, err
+	}
+	if end > start {
+		pieces = append(pieces, b[start:end])
+	}
+	return pieces, nil
+}
+
+// ConcatFrames appends pieces of compressed frames to dst and returns the result.
+// The result is a single stream that decodes to the output of the pieces in order.
+//
+// Each piece must contain whole frames.
+// All frames must use the same dictionary, or no dictionary,
+// and have a window size of at most maxWindow,
+// so the stream can be decoded by a single Decoder.
+// If maxWindow is 0, MaxWindowSize is used.
+// Only the frame headers are checked, the frames are not decompressed.
+//
+// If an error is returned, dst is returned unchanged.
+func ConcatFrames(dst []byte, maxWindow uint64, pieces ...[]byte) ([]byte, error) {
+	if maxWindow == 0 {
+		maxWindow = MaxWindowSize
+	}
+	var dictID uint32
+	frames := 0
+	for i, piece := range pieces {
+		fs := Frames(bytes.NewReader(piece))
+		for fs.Next() {
+			f := fs.Frame()
+			if f.Skippable {
+				continue
+			}
+			if frames > 0 && f.DictionaryID != dictID {
+				return dst, fmt.Errorf("piece %d uses dictionary %d, previous frames use %d", i, f.DictionaryID, dictID)
+			}
+			if f.WindowSize > maxWindow {
+				return dst, fmt.Errorf("piece %d: %w: %d > %d", i, ErrWindowSizeExceeded, f.WindowSize, maxWindow)
+			}
+			dictID = f.DictionaryID
+			frames++
+		}
+		if err := fs.Err(); err != nil {
+			return dst, fmt.Errorf("piece %d: %w", i, err)
+		}
+	}
+	for _, piece := range pieces {
+		dst = append(dst, piece...)
+	}
+	return dst, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSplitFrames(t *testing.T) {
+	enc, err := NewWriter(nil, WithEncoderConcurrency(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	var input, stream []byte
+	for i := 0; i < 20; i++ {
+		b := testSeekableData(10000 + i*5000)
+		input = append(input, b...)
+		stream = enc.EncodeAll(b, stream)
+		if i%5 == 0 {
+			stream = append(stream, 0x50, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 1, 2)
+		}
+	}
+	dec, err := NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+
+	for _, maxSize := range []int{0, 1, 1000, 10000, len(stream)} {
+		pieces, err := SplitFrames(stream, maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		for i, piece := range pieces {
+			if maxSize > 0 && len(piece) > maxSize {
+				if p, _ := SplitFrames(piece, 0); len(p) > 1 {
+					t.Fatalf("max %d: piece %d is %d bytes with %d frames", maxSize, i, len(piece), len(p))
+				}
+			}
+			// Each piece can be decoded.
+			got, err = dec.DecodeAll(piece, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(got, input) {
+			t.Fatalf("max %d: output mismatch", maxSize)
+		}
+		joined, err := ConcatFrames(nil, 0, pieces...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(joined, stream) {
+			t.Fatalf("max %d: joined stream mismatch", maxSize)
+		}
+		if maxSize == 0 && len(pieces) != 20 {
+			t.Fatalf("got %d pieces, want 20", len(pieces))
+		}
+		if maxSize == len(stream) && len(pieces) != 1 {
+			t.Fatalf("got %d pieces, want 1", len(pieces))
+		}
+	}
+
+	if _, err := SplitFrames(stream[:len(stream)-1], 0); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := SplitFrames(append(stream[:len(stream):len(stream)], 1, 2, 3, 4), 0); err != ErrMagicMismatch {
+		t.Fatalf("got error %v, want %v", err, ErrMagicMismatch)
+	}
+}
+
+func TestConcatFrames(t *testing.T) {
+	dict, err := BuildDict(BuildDictOptions{ID: 1, Contents: [][]byte{testSeekableData(5000)}, History: testSeekableData(20000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEnc := func(opts ...EOption) *Encoder {
+		enc, err := NewWriter(nil, append(opts, WithEncoderConcurrency(1))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc
+	}
+	plain := newEnc(WithSingleSegment(false))
+	defer plain.Close()
+	withDict := newEnc(WithEncoderDict(dict))
+	defer withDict.Close()
+
+	in := testSeekableData(5000)
+	a := plain.EncodeAll(in, nil)
+	b := withDict.EncodeAll(in, nil)
+
+	dst := []byte("prefix")
+	got, err := ConcatFrames(dst, 0, a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append(append([]byte{}, dst...), a...), a...); !bytes.Equal(got, want) {
+		t.Fatal("output mismatch")
+	}
+	if _, err := ConcatFrames(nil, 0, b, b); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ConcatFrames(dst, 0, a, b); err == nil || !bytes.Equal(got, dst) {
+		t.Fatalf("got error %v, want dictionary mismatch", err)
+	}
+	if _, err := ConcatFrames(nil, MinWindowSize, a); !errors.Is(err, ErrWindowSizeExceeded) {
+		t.Fatalf("got error %v, want %v", err, ErrWindowSizeExceeded)
+	}
+	if _, err := ConcatFrames(nil, 0, a, a[:len(a)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
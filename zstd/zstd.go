@@ -67,6 +67,10 @@ var (
 	// ErrDecoderSizeExceeded is returned if decompressed size exceeds the configured limit.
 	ErrDecoderSizeExceeded = errors.New("decompressed size exceeds configured limit")
 
+	// ErrDecompressedSizeExceeded is returned if the output of a DecodeAll call
+	// or a stream exceeds the limit set with WithDecoderMaxDecompressedSize.
+	ErrDecompressedSizeExceeded = errors.New("decompressed size exceeds output limit")
+
 	// ErrUnknownDictionary is returned if the dictionary ID is unknown.
 	// For the time being dictionaries are not supported.
 	ErrUnknownDictionary = errors.New("unknown dictionary")
@@ -75,6 +79,14 @@ var (
 	// This is only returned if SingleSegment is specified on the frame.
 	ErrFrameSizeExceeded = errors.New("frame size exceeded")
 
+	// ErrDecoderBudgetExceeded is returned if a frame needs more memory
+	// than is available in the budget set with WithDecoderBudget.
+	ErrDecoderBudgetExceeded = errors.New("decoder memory budget exceeded")
+
+	// ErrFrameSizeMismatch is returned if the decoded size of a frame differs
+	// from its content size and WithDecoderCheckContentSize is set.
+	ErrFrameSizeMismatch = errors.New("frame content size does not match decoded size")
+
 	// ErrCRCMismatch is returned if CRC mismatches.
 	ErrCRCMismatch = errors.New("CRC check failed")
 
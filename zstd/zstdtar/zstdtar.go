@@ -0,0 +1,110 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+// Package zstdtar creates and extracts tar archives compressed with zstd,
+// usually named .tar.zst.
+//
+// Encoders and decoders use all cores by default,
+// and decoders reject windows above DefaultMaxWindow like the zstd commandline tool.
+// Options given to the functions are applied after the defaults.
+//
+// Create and Extract use the tarball package,
+// so they share its filtering, limits and path safety checks.
+package zstdtar
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"runtime"
+
+	"github.com/klauspost/compress/tarball"
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultMaxWindow is the largest window accepted by decoders,
+// unless WithDecoderMaxWindow is given.
+// This is the limit of the zstd commandline tool, unless --long=31 is used.
+const DefaultMaxWindow = 128 << 20
+
+// encoderOptions returns the default encoder options followed by opts.
+func encoderOptions(opts []zstd.EOption) []zstd.EOption {
+	return append([]zstd.EOption{zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0))}, opts...)
+}
+
+// decoderOptions returns the default decoder options followed by opts.
+func decoderOptions(opts []zstd.DOption) []zstd.DOption {
+	return append([]zstd.DOption{
+		zstd.WithDecoderConcurrency(runtime.GOMAXPROCS(0)),
+		zstd.WithDecoderMaxWindow(DefaultMaxWindow),
+	}, opts...)
+}
+
+// Writer writes a tar archive compressed with zstd.
+// The embedded tar.Writer is used to add entries.
+type Writer struct {
+	*tar.Writer
+	enc *zstd.Encoder
+}
+
+// NewWriter returns a Writer writing a compressed archive to w.
+func NewWriter(w io.Writer, opts ...zstd.EOption) (*Writer, error) {
+	enc, err := zstd.NewWriter(w, encoderOptions(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{Writer: tar.NewWriter(enc), enc: enc}, nil
+}
+
+// Close writes the end of the archive and flushes the compressed output.
+// The underlying writer is not closed.
+func (w *Writer) Close() error {
+	err := w.Writer.Close()
+	if err2 := w.enc.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Reader reads a tar archive compressed with zstd.
+// The embedded tar.Reader is used to read entries.
+type Reader struct {
+	*tar.Reader
+	dec *zstd.Decoder
+}
+
+// NewReader returns a Reader reading a compressed archive from r.
+func NewReader(r io.Reader, opts ...zstd.DOption) (*Reader, error) {
+	dec, err := zstd.NewReader(r, decoderOptions(opts)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{Reader: tar.NewReader(dec), dec: dec}, nil
+}
+
+// Close releases the decoder.
+// The underlying reader is not closed.
+func (r *Reader) Close() {
+	r.dec.Close()
+}
+
+// Create writes a compressed archive of the content of root to w.
+// See tarball.Create for the files that are added.
+// The format and concurrency of o are ignored, and the level is
+// in the scale of the zstd commandline tool, 1 -> 22.
+func Create(ctx context.Context, w io.Writer, root string, o tarball.CreateOptions, opts ...zstd.EOption) error {
+	o.Format = tarball.FormatZstd
+	o.Concurrency = 0
+	o.ZstdOptions = append(encoderOptions(o.ZstdOptions), opts...)
+	return tarball.Create(ctx, w, root, o)
+}
+
+// Extract decompresses the archive read from r into the dst directory.
+// See tarball.Extract for how files are extracted and checked.
+// The format and concurrency of o are ignored.
+func Extract(ctx context.Context, r io.Reader, dst string, o tarball.ExtractOptions, opts ...zstd.DOption) error {
+	o.Format = tarball.FormatZstd
+	o.Concurrency = 0
+	o.ZstdOptions = append(decoderOptions(o.ZstdOptions), opts...)
+	return tarball.Extract(ctx, r, dst, o)
+}
@@ -0,0 +1,142 @@
+// Copyright 2019+ Klaus Post. All rights reserved.
+// License information can be found in the LICENSE file.
+
+package zstdtar
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/tarball"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestWriterReader(t *testing.T) {
+	files := map[string]string{
+		"a.txt":     "hello world",
+		"sub/b.txt": strings.Repeat("compress me ", 100000),
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		content := files[name]
+		if err := w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	n := 0
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != files[hdr.Name] {
+			t.Fatalf("%s: content mismatch", hdr.Name)
+		}
+		n++
+	}
+	if n != len(files) {
+		t.Fatalf("got %d files, want %d", n, len(files))
+	}
+}
+
+func TestMaxWindow(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, zstd.WithWindowSize(256<<20), zstd.WithEncoderLongWindow(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("x", 1<<20)
+	w.WriteHeader(&tar.Header{Name: "x", Mode: 0644, Size: int64(len(content))})
+	io.WriteString(w, content)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Next(); err != zstd.ErrWindowSizeExceeded {
+		t.Fatalf("got %v, want %v", err, zstd.ErrWindowSizeExceeded)
+	}
+	r2, err := NewReader(bytes.NewReader(buf.Bytes()), zstd.WithDecoderMaxWindow(zstd.MaxWindowSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	if _, err := r2.Next(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateExtract(t *testing.T) {
+	src, err := ioutil.TempDir("", "zstdtar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 100000)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "big.bin"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Create(context.Background(), &buf, src, tarball.CreateOptions{Level: 19}); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := ioutil.TempDir("", "zstdtar-out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+	if err := Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, tarball.ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(dst, "sub", "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("content mismatch")
+	}
+
+	// Decoder options are applied.
+	err = Extract(context.Background(), bytes.NewReader(buf.Bytes()), dst, tarball.ExtractOptions{Overwrite: true}, zstd.WithDecoderMaxDecompressedSize(1000))
+	if err != zstd.ErrDecompressedSizeExceeded {
+		t.Fatalf("got %v, want %v", err, zstd.ErrDecompressedSizeExceeded)
+	}
+}